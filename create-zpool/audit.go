@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// driftItem is a single difference between the configuration and the system.
+type driftItem struct {
+	Pool  string `json:"pool"`
+	Field string `json:"field"`
+	Want  string `json:"want"`
+	Have  string `json:"have"`
+}
+
+// partitionSuffixPattern matches the partition part of a device name relative to its disk,
+// e.g. "1" for sda1, "p1" for nvme0n1p1 and "-part1" for by-id links. Disks whose name ends
+// in a digit always use a separator, which partitionSeparatorPattern requires.
+var (
+	partitionSuffixPattern    = regexp.MustCompile(`^(p|-part)?[0-9]+$`)
+	partitionSeparatorPattern = regexp.MustCompile(`^(p|-part)[0-9]+$`)
+)
+
+// vdevTypeFromName derives the vdev type from a top-level vdev name as shown by zpool status,
+// e.g. "mirror-0" is "mirror", "raidz2-1" is "raidz2" and "draid1:4d:8c:1s-0" is "draid1".
+func vdevTypeFromName(name string) string {
+	name, _, _ = strings.Cut(name, ":")
+	if i := strings.LastIndex(name, "-"); i > 0 {
+		if _, err := strconv.Atoi(name[i+1:]); err == nil {
+			name = name[:i]
+		}
+	}
+	return name
+}
+
+// normalizeVdevType maps configured vdev types to the names zpool status uses for them.
+func normalizeVdevType(vdevType string) string {
+	vdevType, _, _ = strings.Cut(vdevType, ":")
+	switch vdevType {
+	case "raidz":
+		return "raidz1"
+	case "draid":
+		return "draid1"
+	}
+	return vdevType
+}
+
+// dataVdevs returns the top-level data vdevs of the pool, or nil if the vdev tree is unknown.
+func (s *poolStatus) dataVdevs() []*vdevStatus {
+	root, ok := s.Vdevs[s.Name]
+	if !ok {
+		return nil
+	}
+	var vdevs []*vdevStatus
+	for _, name := range slices.Sorted(maps.Keys(root.Vdevs)) {
+		vdev := root.Vdevs[name]
+		if vdev.Class == "" || vdev.Class == "normal" {
+			vdevs = append(vdevs, vdev)
+		}
+	}
+	return vdevs
+}
+
+// actualLayout describes the data vdev layout of a pool as the configured type and number of disks.
+func actualLayout(vdevs []*vdevStatus) (vdevType string, disks []*vdevStatus) {
+	var types []string
+	for _, vdev := range vdevs {
+		t := ""
+		if len(vdev.Vdevs) > 0 {
+			t = vdevTypeFromName(vdev.Name)
+		}
+		if !slices.Contains(types, t) {
+			types = append(types, t)
+		}
+		disks = append(disks, vdev.leaves()...)
+	}
+	return strings.Join(types, "+"), disks
+}
+
+// describeVdevType returns a human readable vdev type, using "stripe" for plain disks.
+func describeVdevType(vdevType string) string {
+	if vdevType == "" {
+		return "stripe"
+	}
+	return vdevType
+}
+
+// leafMatchesDisk reports whether the leaf vdev lives on the disk with the given device name,
+// either directly or on one of its partitions.
+func leafMatchesDisk(provider zfsProvider, leaf *vdevStatus, disk string) bool {
+	candidates := []string{leaf.Name}
+	if leaf.Path != "" {
+		candidates = append(candidates, filepath.Base(leaf.Path))
+		if resolved, err := provider.EvalSymlinks(leaf.Path); err == nil {
+			candidates = append(candidates, filepath.Base(resolved))
+		}
+	}
+	suffix := partitionSuffixPattern
+	if disk != "" && disk[len(disk)-1] >= '0' && disk[len(disk)-1] <= '9' {
+		suffix = partitionSeparatorPattern
+	}
+	for _, name := range candidates {
+		if rest, ok := strings.CutPrefix(name, disk); ok && (rest == "" || suffix.MatchString(rest)) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvedDiskName returns the device name a path points to, e.g. nvme0n1 for a
+// /dev/disk/by-id link, or its own name if it cannot be resolved.
+func resolvedDiskName(provider zfsProvider, path string) string {
+	if resolved, err := provider.EvalSymlinks(path); err == nil {
+		return filepath.Base(resolved)
+	}
+	return filepath.Base(path)
+}
+
+// auditPool compares a single configured pool against the system and returns all differences.
+// Nothing is changed. Errors are returned for parts that could not be inspected.
+func auditPool(ctx context.Context, provider zfsProvider, zpoolPath, zfsPath string, config poolConfig, state *runState, cache *poolStatusCache) ([]driftItem, error) {
+	if err := errors.Join(config.ParseErrors...); err != nil {
+		return nil, err
+	}
+	drift := func(field, want, have string) driftItem {
+		return driftItem{Pool: config.Name, Field: field, Want: want, Have: have}
+	}
+
+	if _, exists := state.existingPools[config.Name]; !exists {
+		have := "missing"
+		exported, err := state.importablePools(ctx, provider, zpoolPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan for exported pools: %w", err)
+		}
+		for _, pool := range exported {
+			if pool.Name == config.Name {
+				have = "exported (id " + pool.ID + ")"
+			}
+		}
+		return []driftItem{drift("pool", "imported", have)}, nil
+	}
+
+	var items []driftItem
+	statuses, err := cache.Status(ctx, []string{config.Name})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pool status: %w", err)
+	}
+	if status, ok := statuses[config.Name]; ok {
+		if !status.healthy() {
+			items = append(items, drift("health", "ONLINE", status.State))
+		}
+		items = append(items, auditLayout(provider, config, status)...)
+		for _, class := range vdevClasses {
+			items = append(items, auditClassVdev(config, class, config.classVdev(class), status)...)
+		}
+	}
+
+	want := map[string]string{"ashift": config.Ashift}
+	maps.Copy(want, config.Properties)
+	have, err := provider.GetPoolProperties(ctx, zpoolPath, config.Name, slices.Sorted(maps.Keys(want)))
+	if err != nil {
+		return items, fmt.Errorf("failed to read pool properties: %w", err)
+	}
+	for _, prop := range slices.Sorted(maps.Keys(want)) {
+		if have[prop] != want[prop] {
+			items = append(items, drift("property "+prop, want[prop], have[prop]))
+		}
+	}
+
+	if zfsPath == "" {
+		slog.Info("zfs binary not available, skipping dataset checks", "pool", config.Name)
+		return items, nil
+	}
+	wantDataset := map[string]string{"mountpoint": config.Mountpoint, "canmount": config.CanMount}
+	if wantDataset["mountpoint"] == "" {
+		wantDataset["mountpoint"] = filepath.Join(defaultMountDir, config.Name)
+	}
+	if wantDataset["canmount"] == "" {
+		delete(wantDataset, "canmount")
+	}
+	// Numeric properties are read in their exact form.
+	if size, err := parseSizeInBytes(config.SmallBlocks); err == nil {
+		wantDataset["special_small_blocks"] = strconv.FormatUint(size, 10)
+	}
+	if size, err := parseSizeInBytes(config.Recordsize); err == nil {
+		wantDataset["recordsize"] = strconv.FormatUint(size, 10)
+	}
+	haveDataset, err := provider.GetDatasetProperties(ctx, zfsPath, config.Name, slices.Sorted(maps.Keys(wantDataset)))
+	if err != nil {
+		return items, fmt.Errorf("failed to read root dataset properties: %w", err)
+	}
+	for _, prop := range slices.Sorted(maps.Keys(wantDataset)) {
+		if haveDataset[prop] != wantDataset[prop] {
+			items = append(items, drift("dataset "+prop, wantDataset[prop], haveDataset[prop]))
+		}
+	}
+	return items, nil
+}
+
+// auditLayout compares the configured vdev type and disks with the pool's data vdevs.
+func auditLayout(provider zfsProvider, config poolConfig, status *poolStatus) []driftItem {
+	vdevs := status.dataVdevs()
+	if vdevs == nil {
+		// Text status output from older zpool versions has no vdev tree.
+		return nil
+	}
+	disks, err := configuredDisks(config)
+	if err != nil {
+		return []driftItem{{Pool: config.Name, Field: "disks", Want: config.DiskList, Have: err.Error()}}
+	}
+
+	var items []driftItem
+	haveType, leaves := actualLayout(vdevs)
+	if len(config.Vdevs) > 0 {
+		if want, have := describeVdevSpecs(config.Vdevs), describeActualVdevs(vdevs); want != have {
+			items = append(items, driftItem{Pool: config.Name, Field: "vdevs", Want: want, Have: have})
+		}
+	} else if wantType := normalizeVdevType(config.Type); wantType != haveType {
+		items = append(items, driftItem{Pool: config.Name, Field: "type", Want: describeVdevType(wantType), Have: describeVdevType(haveType)})
+	}
+	if len(disks) != len(leaves) {
+		items = append(items, driftItem{Pool: config.Name, Field: "disk count", Want: strconv.Itoa(len(disks)), Have: strconv.Itoa(len(leaves))})
+	}
+	for _, disk := range disks {
+		if disk.Dev == "" {
+			// Model patterns cannot be mapped to a specific member after creation.
+			continue
+		}
+		name := resolvedDiskName(provider, disk.Dev)
+		if !slices.ContainsFunc(leaves, func(leaf *vdevStatus) bool {
+			return leafMatchesDisk(provider, leaf, name) || leafMatchesDisk(provider, leaf, filepath.Base(disk.Dev))
+		}) {
+			items = append(items, driftItem{Pool: config.Name, Field: "disk", Want: disk.Dev, Have: "not a member"})
+		}
+	}
+	return items
+}
+
+// auditMain implements ZPOOL_MODE=audit: it compares every configured pool with the system and
+// logs a drift report without changing anything. It returns the process exit code, which is
+// only non-zero if the system could not be inspected; drift itself is reported, not failed.
+func auditMain(ctx context.Context, provider zfsProvider, zpoolPath, zfsPath string, configs []poolConfig) int {
+	existingPools, err := provider.ListPools(ctx, zpoolPath)
+	if err != nil {
+		slog.Error("Failed to list existing pools", "error", err)
+		return 1
+	}
+	state := newRunState(existingPools)
+	cache := newPoolStatusCache(provider, zpoolPath)
+
+	var drift []driftItem
+	var errs []error
+	for _, config := range configs {
+		items, err := auditPool(ctx, provider, zpoolPath, zfsPath, config, state, cache)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("pool %q: %w", config.Name, err))
+		}
+		drift = append(drift, items...)
+	}
+
+	for _, item := range drift {
+		slog.Warn("Drift detected", "pool", item.Pool, "field", item.Field, "want", item.Want, "have", item.Have)
+	}
+	for _, err := range errs {
+		slog.Error("Audit failed", "error", err)
+	}
+	slog.Info("Audit completed, no changes were made.", "pools", len(configs), "drift_count", len(drift), "error_count", len(errs))
+	if len(errs) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestVdevTypeFromName(t *testing.T) {
+	tests := map[string]string{
+		"mirror-0":          "mirror",
+		"raidz2-1":          "raidz2",
+		"draid1:4d:8c:1s-0": "draid1",
+		"sda":               "sda",
+		"nvme-Samsung-1":    "nvme-Samsung",
+	}
+	for name, want := range tests {
+		if got := vdevTypeFromName(name); got != want {
+			t.Errorf("vdevTypeFromName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestLeafMatchesDisk(t *testing.T) {
+	mockProvider := &mockZFSProvider{}
+	tests := []struct {
+		leaf vdevStatus
+		disk string
+		want bool
+	}{
+		{vdevStatus{Name: "sda", Path: "/dev/sda1"}, "sda", true},
+		{vdevStatus{Name: "nvme0n1", Path: "/dev/nvme0n1p1"}, "nvme0n1", true},
+		{vdevStatus{Name: "ata-X", Path: "/dev/disk/by-id/ata-X-part1"}, "ata-X", true},
+		{vdevStatus{Name: "sdab", Path: "/dev/sdab1"}, "sda", false},
+		{vdevStatus{Name: "nvme0n10", Path: "/dev/nvme0n10p1"}, "nvme0n1", false},
+	}
+	for _, tt := range tests {
+		if got := leafMatchesDisk(mockProvider, &tt.leaf, tt.disk); got != tt.want {
+			t.Errorf("leafMatchesDisk(%+v, %q) = %v, want %v", tt.leaf, tt.disk, got, tt.want)
+		}
+	}
+}
+
+func TestAuditPool(t *testing.T) {
+	mockProvider := &mockZFSProvider{
+		GetAllPoolStatusFunc: func(ctx context.Context, zpoolPath string) ([]byte, error) {
+			return []byte(testPoolStatusJSON), nil
+		},
+		GetPoolPropertiesFunc: func(ctx context.Context, zpoolPath, name string, props []string) (map[string]string, error) {
+			return map[string]string{"ashift": "12", "autotrim": "off"}, nil
+		},
+		GetDatasetPropertiesFunc: func(ctx context.Context, zfsPath, dataset string, props []string) (map[string]string, error) {
+			return map[string]string{"mountpoint": "/var/mnt/tank", "canmount": "on"}, nil
+		},
+		CreatePoolFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+			t.Errorf("Audit must not create pools, got %v", args)
+			return nil, nil
+		},
+		SetPoolPropertyFunc: func(ctx context.Context, zpoolPath, name, prop, value string) ([]byte, error) {
+			t.Errorf("Audit must not set properties, got %s=%s", prop, value)
+			return nil, nil
+		},
+	}
+
+	config := poolConfig{
+		Name:       "tank",
+		Type:       "raidz",
+		Disks:      []diskSpec{{Dev: "/dev/sda"}, {Dev: "/dev/sdc"}, {Model: "Samsung*"}},
+		Ashift:     "12",
+		CanMount:   "off",
+		Properties: map[string]string{"autotrim": "on"},
+	}
+	state := newRunState(map[string]string{"tank": "1234567890"})
+	items, err := auditPool(t.Context(), mockProvider, "/fake/zpool", "/fake/zfs", config, state, newPoolStatusCache(mockProvider, "/fake/zpool"))
+	if err != nil {
+		t.Fatalf("auditPool() returned an unexpected error: %v", err)
+	}
+
+	want := []driftItem{
+		{Pool: "tank", Field: "health", Want: "ONLINE", Have: "DEGRADED"},
+		{Pool: "tank", Field: "type", Want: "raidz1", Have: "mirror"},
+		{Pool: "tank", Field: "disk count", Want: "3", Have: "2"},
+		{Pool: "tank", Field: "disk", Want: "/dev/sdc", Have: "not a member"},
+		{Pool: "tank", Field: "property autotrim", Want: "on", Have: "off"},
+		{Pool: "tank", Field: "dataset canmount", Want: "off", Have: "on"},
+	}
+	if !slices.Equal(items, want) {
+		t.Errorf("auditPool() drift =\n%+v\nwant\n%+v", items, want)
+	}
+}
+
+func TestAuditPool_Missing(t *testing.T) {
+	mockProvider := &mockZFSProvider{
+		ListImportablePoolsFunc: func(ctx context.Context, zpoolPath string) ([]importablePool, error) {
+			return []importablePool{{Name: "old", ID: "99"}}, nil
+		},
+	}
+	state := newRunState(nil)
+	cache := newPoolStatusCache(mockProvider, "/fake/zpool")
+
+	items, err := auditPool(t.Context(), mockProvider, "/fake/zpool", "", poolConfig{Name: "old", Ashift: "12"}, state, cache)
+	if err != nil || !slices.Equal(items, []driftItem{{Pool: "old", Field: "pool", Want: "imported", Have: "exported (id 99)"}}) {
+		t.Errorf("auditPool() for an exported pool = %+v, %v", items, err)
+	}
+	items, err = auditPool(t.Context(), mockProvider, "/fake/zpool", "", poolConfig{Name: "tank", Ashift: "12"}, state, cache)
+	if err != nil || !slices.Equal(items, []driftItem{{Pool: "tank", Field: "pool", Want: "imported", Have: "missing"}}) {
+		t.Errorf("auditPool() for a missing pool = %+v, %v", items, err)
+	}
+}
+
+func TestAuditMain_Errors(t *testing.T) {
+	mockProvider := &mockZFSProvider{
+		GetAllPoolStatusFunc: func(ctx context.Context, zpoolPath string) ([]byte, error) {
+			return []byte(testPoolStatusJSON), nil
+		},
+		ListPoolsFunc: func(ctx context.Context, zpoolPath string) (map[string]string, error) {
+			return map[string]string{"tank": "1234567890"}, nil
+		},
+		GetPoolPropertiesFunc: func(ctx context.Context, zpoolPath, name string, props []string) (map[string]string, error) {
+			return nil, errors.New("permission denied")
+		},
+	}
+	configs := []poolConfig{{Name: "tank", Ashift: "12"}}
+	if code := auditMain(t.Context(), mockProvider, "/fake/zpool", "", configs); code != 1 {
+		t.Errorf("auditMain() = %d when properties cannot be read, want 1", code)
+	}
+}
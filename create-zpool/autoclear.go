@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultAutoClearWindow = 24 * time.Hour // How long error counters must stay unchanged, see ZPOOL_AUTO_CLEAR_WINDOW.
+
+// deviceErrors are the error counters of a device and when they last changed.
+type deviceErrors struct {
+	Read     uint64    `json:"read"`
+	Write    uint64    `json:"write"`
+	Checksum uint64    `json:"checksum"`
+	Since    time.Time `json:"since"` // When these values were first seen.
+}
+
+// sameCounters reports whether e and o have the same error counters.
+func (e deviceErrors) sameCounters(o deviceErrors) bool {
+	return e.Read == o.Read && e.Write == o.Write && e.Checksum == o.Checksum
+}
+
+// leafErrors parses the error counters of a leaf vdev. ok is false if they are unknown.
+func leafErrors(leaf *vdevStatus) (errs deviceErrors, ok bool) {
+	for _, c := range []struct {
+		value string
+		dst   *uint64
+	}{{leaf.ReadErrors, &errs.Read}, {leaf.WriteErrors, &errs.Write}, {leaf.ChecksumErrors, &errs.Checksum}} {
+		v, err := strconv.ParseUint(c.value, 10, 64)
+		if err != nil {
+			return deviceErrors{}, false
+		}
+		*c.dst = v
+	}
+	return errs, true
+}
+
+// autoClearErrors clears the error counters of devices whose errors stopped increasing for
+// at least window, so that transient errors (e.g. from a flaky expander) do not leave the
+// pool status dirty forever. The counters are tracked in st across runs. Devices that are not
+// ONLINE are never cleared, so active failures are not masked. It reports whether st changed.
+func autoClearErrors(ctx context.Context, provider zfsProvider, zpoolPath string, st *persistentState, statuses map[string]*poolStatus, window time.Duration, now time.Time) bool {
+	changed := false
+	for pool, status := range statuses {
+		seen := make(map[string]bool)
+		for _, leaf := range status.leaves() {
+			key := pool + "/" + leaf.Name
+			seen[key] = true
+			current, ok := leafErrors(leaf)
+			if !ok {
+				continue
+			}
+			if current.Read+current.Write+current.Checksum == 0 {
+				if _, tracked := st.ErrorCounters[key]; tracked {
+					delete(st.ErrorCounters, key)
+					changed = true
+				}
+				continue
+			}
+
+			previous, tracked := st.ErrorCounters[key]
+			if !tracked || !previous.sameCounters(current) {
+				current.Since = now
+				st.ErrorCounters[key] = current
+				changed = true
+				continue
+			}
+			if leaf.State != "ONLINE" || now.Sub(previous.Since) < window {
+				continue
+			}
+
+			slog.Info("Clearing error counters that stopped increasing", "pool", pool, "device", leaf.Name,
+				"read", current.Read, "write", current.Write, "checksum", current.Checksum, "unchanged_since", previous.Since)
+			if output, err := provider.ClearDevice(ctx, zpoolPath, pool, leaf.Name); err != nil {
+				slog.Warn("Failed to clear device errors", "pool", pool, "device", leaf.Name, "error", err, "output", string(output))
+				continue
+			}
+			delete(st.ErrorCounters, key)
+			changed = true
+		}
+
+		// Forget devices that are no longer part of the pool.
+		for key := range st.ErrorCounters {
+			if strings.HasPrefix(key, pool+"/") && !seen[key] {
+				delete(st.ErrorCounters, key)
+				changed = true
+			}
+		}
+	}
+	return changed
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestAutoClearErrors(t *testing.T) {
+	start := time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+	status := func(state, checksum string) map[string]*poolStatus {
+		return map[string]*poolStatus{"tank": {Name: "tank", State: "ONLINE", Vdevs: map[string]*vdevStatus{
+			"tank": {Name: "tank", Vdevs: map[string]*vdevStatus{
+				"mirror-0": {Name: "mirror-0", Vdevs: map[string]*vdevStatus{
+					"sda": {Name: "sda", State: state, ReadErrors: "0", WriteErrors: "0", ChecksumErrors: checksum},
+					"sdb": {Name: "sdb", State: "ONLINE", ReadErrors: "0", WriteErrors: "0", ChecksumErrors: "0"},
+				}},
+			}},
+		}}}
+	}
+
+	var cleared []string
+	clearErr := error(nil)
+	mockProvider := &mockZFSProvider{
+		ClearDeviceFunc: func(ctx context.Context, zpoolPath, name, device string) ([]byte, error) {
+			cleared = append(cleared, name+"/"+device)
+			return nil, clearErr
+		},
+	}
+	st := &persistentState{ErrorCounters: map[string]deviceErrors{"tank/gone": {Checksum: 1, Since: start}}}
+	check := func(statuses map[string]*poolStatus, now time.Duration) bool {
+		return autoClearErrors(t.Context(), mockProvider, "/fake/zpool", st, statuses, 24*time.Hour, start.Add(now))
+	}
+
+	if !check(status("ONLINE", "3"), 0) {
+		t.Error("Expected new error counters to change the state")
+	}
+	if _, ok := st.ErrorCounters["tank/gone"]; ok {
+		t.Error("Expected devices no longer in the pool to be forgotten")
+	}
+	if got := st.ErrorCounters["tank/sda"]; got.Checksum != 3 || !got.Since.Equal(start) {
+		t.Errorf("Unexpected tracked counters %+v", got)
+	}
+
+	// The errors keep increasing, which restarts the window.
+	check(status("ONLINE", "5"), 20*time.Hour)
+	check(status("ONLINE", "5"), 40*time.Hour)
+	if len(cleared) != 0 {
+		t.Errorf("Expected no clear while errors are recent, got %v", cleared)
+	}
+
+	// A device that is not ONLINE is never cleared, even with unchanged counters.
+	check(status("FAULTED", "5"), 50*time.Hour)
+	if len(cleared) != 0 {
+		t.Errorf("Expected a faulted device not to be cleared, got %v", cleared)
+	}
+
+	// A failed clear keeps tracking the counters.
+	clearErr = errors.New("exit status 1")
+	check(status("ONLINE", "5"), 45*time.Hour)
+	if _, ok := st.ErrorCounters["tank/sda"]; !ok {
+		t.Error("Expected the counters to stay tracked after a failed clear")
+	}
+
+	clearErr = nil
+	cleared = nil
+	if !check(status("ONLINE", "5"), 46*time.Hour) {
+		t.Error("Expected the clear to change the state")
+	}
+	if !slices.Equal(cleared, []string{"tank/sda"}) {
+		t.Errorf("Expected sda to be cleared, got %v", cleared)
+	}
+	if len(st.ErrorCounters) != 0 {
+		t.Errorf("Expected no tracked counters after the clear, got %v", st.ErrorCounters)
+	}
+	if check(status("ONLINE", "0"), 47*time.Hour) {
+		t.Error("Expected no state change for a clean pool")
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Block device ioctls from <linux/fs.h>.
+const (
+	ioctlBLKDISCARD    = 0x1277     // _IO(0x12, 119)
+	ioctlBLKSECDISCARD = 0x127d     // _IO(0x12, 125)
+	ioctlBLKGETSIZE64  = 0x80081272 // _IOR(0x12, 114, size_t)
+	ioctlBLKFLSBUF     = 0x1261     // _IO(0x12, 97)
+)
+
+// DiscardDevice discards all blocks of the block device at path, securely if requested.
+// The device is opened exclusively, so devices that are mounted or in use are refused.
+func (p *liveZFSProvider) DiscardDevice(path string, secure bool) error {
+	// #nosec G304: Intentionally opening the configured block device
+	f, err := os.OpenFile(path, os.O_WRONLY|syscall.O_EXCL, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	size, err := blockDeviceSize(f)
+	if err != nil {
+		return err
+	}
+
+	request, name := uintptr(ioctlBLKDISCARD), "BLKDISCARD"
+	if secure {
+		request, name = ioctlBLKSECDISCARD, "BLKSECDISCARD"
+	}
+	r := [2]uint64{0, size}
+	if err := blockIoctl(f, request, unsafe.Pointer(&r)); err != nil {
+		return fmt.Errorf("%s failed: %w", name, err)
+	}
+	return nil
+}
+
+// BurnInDevice writes and verifies a test pattern over the first size bytes of the block device
+// at path, or the whole device if it is smaller. The buffer cache is dropped between writing and
+// verifying, so the data is read back from the device itself.
+func (p *liveZFSProvider) BurnInDevice(path string, size, seed uint64) error {
+	// #nosec G304: Intentionally opening the configured block device
+	f, err := os.OpenFile(path, os.O_RDWR|syscall.O_EXCL, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	deviceSize, err := blockDeviceSize(f)
+	if err != nil {
+		return err
+	}
+	return burnInPass(f, min(size, deviceSize), seed, func() error {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+		return blockIoctl(f, ioctlBLKFLSBUF, nil)
+	})
+}
+
+// ProbeSyncWriteLatency times synchronous writes of the first writes blocks of the block device
+// at path. Each block is read and written back unchanged with O_DSYNC, which makes the device
+// flush its write cache like a log write does, so the data on the device stays as it was. The
+// device is opened exclusively, so devices that are mounted or in use are refused.
+func (p *liveZFSProvider) ProbeSyncWriteLatency(path string, writes int) (time.Duration, error) {
+	// #nosec G304: Intentionally opening the configured block device
+	f, err := os.OpenFile(path, os.O_RDWR|syscall.O_EXCL|syscall.O_DSYNC, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	block := make([]byte, syncProbeBlockSize)
+	latencies := make([]time.Duration, 0, writes)
+	for i := range writes {
+		offset := int64(i) * syncProbeBlockSize
+		if _, err := f.ReadAt(block, offset); err != nil {
+			return 0, err
+		}
+		start := time.Now()
+		if _, err := f.WriteAt(block, offset); err != nil {
+			return 0, err
+		}
+		latencies = append(latencies, time.Since(start))
+	}
+	if len(latencies) == 0 {
+		return 0, errors.New("no writes to probe")
+	}
+	slices.Sort(latencies)
+	return latencies[len(latencies)/2], nil
+}
+
+// IsSwapFormatted reads the first page of the block device at path and checks for the swap magic
+// and for a blank page.
+func (p *liveZFSProvider) IsSwapFormatted(path string) (formatted, blank bool, err error) {
+	// #nosec G304: Intentionally opening the swap zvol
+	f, err := os.Open(path)
+	if err != nil {
+		return false, false, err
+	}
+	defer f.Close()
+	page := make([]byte, os.Getpagesize())
+	if _, err := io.ReadFull(f, page); err != nil {
+		return false, false, err
+	}
+	return hasSwapSignature(page), !slices.ContainsFunc(page, func(b byte) bool { return b != 0 }), nil
+}
+
+// FormatSwap writes a swap header over the first page of the block device at path. The device
+// is opened exclusively, so a device that is already in use as swap is refused.
+func (p *liveZFSProvider) FormatSwap(path string) error {
+	// #nosec G304: Intentionally opening the swap zvol
+	f, err := os.OpenFile(path, os.O_WRONLY|syscall.O_EXCL, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	size, err := blockDeviceSize(f)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(swapHeader(size, os.Getpagesize(), newSwapUUID()), 0); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// EnableSwap calls swapon(2) for the block device at path with the default priority.
+func (p *liveZFSProvider) EnableSwap(path string) error {
+	name, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_SWAPON, uintptr(unsafe.Pointer(name)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func blockDeviceSize(f *os.File) (uint64, error) {
+	var size uint64
+	if err := blockIoctl(f, ioctlBLKGETSIZE64, unsafe.Pointer(&size)); err != nil {
+		return 0, fmt.Errorf("failed to get device size: %w", err)
+	}
+	return size, nil
+}
+
+func blockIoctl(f *os.File, request uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), request, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// DiscardDevice is only supported on Linux.
+func (p *liveZFSProvider) DiscardDevice(path string, secure bool) error {
+	return errors.New("discard is only supported on linux")
+}
+
+// BurnInDevice is only supported on Linux.
+func (p *liveZFSProvider) BurnInDevice(path string, size, seed uint64) error {
+	return errors.New("burn-in is only supported on linux")
+}
+
+// ProbeSyncWriteLatency is only supported on Linux.
+func (p *liveZFSProvider) ProbeSyncWriteLatency(path string, writes int) (time.Duration, error) {
+	return 0, errors.New("latency probes are only supported on linux")
+}
+
+// IsSwapFormatted is only supported on Linux.
+func (p *liveZFSProvider) IsSwapFormatted(path string) (formatted, blank bool, err error) {
+	return false, false, errors.New("swap is only supported on linux")
+}
+
+// FormatSwap is only supported on Linux.
+func (p *liveZFSProvider) FormatSwap(path string) error {
+	return errors.New("swap is only supported on linux")
+}
+
+// EnableSwap is only supported on Linux.
+func (p *liveZFSProvider) EnableSwap(path string) error {
+	return errors.New("swap is only supported on linux")
+}
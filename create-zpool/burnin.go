@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	burnInConfirmValue = "destroy-data" // Required value of ZPOOL_BURNIN_CONFIRM to run the destructive burn-in.
+	defaultBurnInSize  = "1GiB"         // Default amount of data written and verified per disk.
+	burnInChunkSize    = 1 << 20        // Size of a single write/verify chunk.
+)
+
+// burnInDevice is the part of a block device that the burn-in pass needs.
+type burnInDevice interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// fillBurnInPattern fills buf with the test pattern for the data at offset. Every 8-byte word
+// depends on its position and the seed, so misdirected or stale writes are detected as well.
+func fillBurnInPattern(buf []byte, offset, seed uint64) {
+	for i := 0; i+8 <= len(buf); i += 8 {
+		word := (offset + uint64(i)) ^ seed
+		word *= 0x9e3779b97f4a7c15
+		binary.LittleEndian.PutUint64(buf[i:], word^(word>>29))
+	}
+}
+
+// burnInPass writes the test pattern to the first size bytes of dev, calls flush so that the
+// data really reaches the device, and reads everything back for verification. Write and read
+// errors abort the pass; verification mismatches are counted and reported together.
+func burnInPass(dev burnInDevice, size, seed uint64, flush func() error) error {
+	size -= size % 8
+	want := make([]byte, burnInChunkSize)
+	got := make([]byte, burnInChunkSize)
+
+	for offset := uint64(0); offset < size; offset += burnInChunkSize {
+		n := min(burnInChunkSize, size-offset)
+		fillBurnInPattern(want[:n], offset, seed)
+		if _, err := dev.WriteAt(want[:n], int64(offset)); err != nil {
+			return fmt.Errorf("write failed at offset %d: %w", offset, err)
+		}
+	}
+	if err := flush(); err != nil {
+		return fmt.Errorf("flush failed: %w", err)
+	}
+
+	var bad int
+	var firstBad uint64
+	for offset := uint64(0); offset < size; offset += burnInChunkSize {
+		n := min(burnInChunkSize, size-offset)
+		if _, err := dev.ReadAt(got[:n], int64(offset)); err != nil {
+			return fmt.Errorf("read failed at offset %d: %w", offset, err)
+		}
+		fillBurnInPattern(want[:n], offset, seed)
+		if !bytes.Equal(got[:n], want[:n]) {
+			if bad == 0 {
+				firstBad = offset
+			}
+			bad++
+		}
+	}
+	if bad > 0 {
+		chunks := (size + burnInChunkSize - 1) / burnInChunkSize
+		return fmt.Errorf("%d of %d chunks failed verification, first at offset %d", bad, chunks, firstBad)
+	}
+	return nil
+}
+
+// runBurnIn resolves the disks of every configured pool that does not exist yet and runs a
+// destructive write/verify pass over the first size bytes of each of them, without creating
+// any pool. Disks of existing pools and of exported pools found on the system are never touched.
+// It returns one error per pool that could not be tested or had failing disks.
+func runBurnIn(ctx context.Context, provider zfsProvider, zpoolPath string, configs []poolConfig, state *runState, size, seed uint64) []error {
+	exported, err := state.importablePools(ctx, provider, zpoolPath)
+	if err != nil {
+		return []error{fmt.Errorf("failed to scan for exported pools, refusing to burn in: %w", err)}
+	}
+	exportedNames := make(map[string]bool)
+	exportedDevices := make(map[string]bool)
+	for _, pool := range exported {
+		exportedNames[pool.Name] = true
+		for _, dev := range pool.Devices {
+			exportedDevices[filepath.Base(dev)] = true
+		}
+	}
+
+	var allErrors []error
+	for _, config := range configs {
+		if err := errors.Join(config.ParseErrors...); err != nil {
+			allErrors = append(allErrors, fmt.Errorf("pool %q: %w", config.Name, err))
+			continue
+		}
+		if _, exists := state.existingPools[config.Name]; exists || exportedNames[config.Name] {
+			slog.Info("Pool already exists, skipping burn-in of its disks", "pool", config.Name)
+			continue
+		}
+		disks, err := configuredDisks(config)
+		if err != nil {
+			allErrors = append(allErrors, fmt.Errorf("pool %q: %w", config.Name, err))
+			continue
+		}
+		sizeConds, err := parseSizeConditions(config.SizeFilters)
+		if err != nil {
+			allErrors = append(allErrors, fmt.Errorf("pool %q: %w", config.Name, err))
+			continue
+		}
+
+		selected, _ := selectDisks(provider, config.Name, disks, sizeConds, state.usedDisks)
+		var candidates []string
+		for _, disk := range selected {
+			realPath, err := provider.EvalSymlinks(disk)
+			if err != nil {
+				realPath = disk
+			}
+			if exportedDevices[filepath.Base(realPath)] {
+				slog.Warn("Disk belongs to an exported pool, skipping burn-in", "pool", config.Name, "device", disk)
+				continue
+			}
+			candidates = append(candidates, disk)
+		}
+		if err := burnInDisks(provider, config.Name, candidates, size, seed); err != nil {
+			allErrors = append(allErrors, fmt.Errorf("pool %q: %w", config.Name, err))
+		}
+	}
+	return allErrors
+}
+
+// burnInDisks runs the burn-in pass on the disks concurrently, see diskSlots, and joins their
+// failures.
+func burnInDisks(provider zfsProvider, pool string, disks []string, size, seed uint64) error {
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs []error
+		sem  = make(chan struct{}, diskSlots(len(disks)))
+	)
+	for _, disk := range disks {
+		wg.Go(func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			slog.Info("Starting burn-in", "pool", pool, "device", disk, "bytes", size)
+			if err := provider.BurnInDevice(disk, size, seed); err != nil {
+				slog.Error("Disk failed burn-in", "pool", pool, "device", disk, "error", err)
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("disk %s failed burn-in: %w", disk, err))
+				mu.Unlock()
+				return
+			}
+			slog.Info("Disk passed burn-in", "pool", pool, "device", disk)
+		})
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// burnInMain implements ZPOOL_MODE=burnin and returns the process exit code. The mode is
+// destructive, so it refuses to run unless ZPOOL_BURNIN_CONFIRM is set to burnInConfirmValue.
+func burnInMain(ctx context.Context, provider zfsProvider, zpoolPath string, configs []poolConfig) int {
+	if confirm, _ := lookupEnvTrimmed("ZPOOL_BURNIN_CONFIRM"); confirm != burnInConfirmValue {
+		slog.Error("Burn-in overwrites the data on all candidate disks. Set ZPOOL_BURNIN_CONFIRM to confirm.", "required_value", burnInConfirmValue)
+		return 1
+	}
+	size, err := parseSizeInBytes(getEnv("ZPOOL_BURNIN_SIZE", defaultBurnInSize))
+	if err != nil {
+		slog.Error("Invalid ZPOOL_BURNIN_SIZE", "error", err)
+		return 1
+	}
+
+	existingPools, err := provider.ListPools(ctx, zpoolPath)
+	if err != nil {
+		slog.Error("Failed to list existing pools", "error", err)
+		return 1
+	}
+
+	slog.Info("Starting disk burn-in, no pools will be created", "bytes_per_disk", size)
+	errs := runBurnIn(ctx, provider, zpoolPath, configs, newRunState(existingPools), size, uint64(time.Now().UnixNano()))
+	if len(errs) > 0 {
+		slog.Error("Burn-in found problems.", "error_count", len(errs))
+		for _, e := range errs {
+			slog.Error("Detailed error", "error", e)
+		}
+		return 1
+	}
+	slog.Info("Burn-in completed, all candidate disks passed.")
+	return 0
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// corruptingDevice flips a byte of everything written at corruptAt, like a failing sector.
+type corruptingDevice struct {
+	*os.File
+	corruptAt int64
+}
+
+func (d *corruptingDevice) WriteAt(p []byte, off int64) (int, error) {
+	if d.corruptAt >= off && d.corruptAt < off+int64(len(p)) {
+		p = slices.Clone(p)
+		p[d.corruptAt-off] ^= 0xff
+	}
+	return d.File.WriteAt(p, off)
+}
+
+func TestBurnInPass(t *testing.T) {
+	const size = 3*burnInChunkSize + 4096
+	newDevice := func(t *testing.T) *os.File {
+		f, err := os.Create(filepath.Join(t.TempDir(), "disk"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		return f
+	}
+
+	t.Run("healthy", func(t *testing.T) {
+		f := newDevice(t)
+		flushed := false
+		if err := burnInPass(f, size, 42, func() error { flushed = true; return nil }); err != nil {
+			t.Fatalf("burnInPass() returned an unexpected error: %v", err)
+		}
+		if !flushed {
+			t.Error("Expected the device to be flushed before verification")
+		}
+		info, _ := f.Stat()
+		if info.Size() != size {
+			t.Errorf("Expected %d bytes to be written, got %d", size, info.Size())
+		}
+	})
+
+	t.Run("corrupted", func(t *testing.T) {
+		dev := &corruptingDevice{File: newDevice(t), corruptAt: 2*burnInChunkSize + 17}
+		err := burnInPass(dev, size, 42, func() error { return nil })
+		if err == nil || !strings.Contains(err.Error(), "1 of 4 chunks failed verification, first at offset 2097152") {
+			t.Errorf("Expected a verification failure in the third chunk, got %v", err)
+		}
+	})
+
+	t.Run("flush failure", func(t *testing.T) {
+		err := burnInPass(newDevice(t), size, 42, func() error { return errors.New("input/output error") })
+		if err == nil || !strings.Contains(err.Error(), "flush failed") {
+			t.Errorf("Expected a flush error, got %v", err)
+		}
+	})
+}
+
+func TestFillBurnInPattern(t *testing.T) {
+	a := make([]byte, 64)
+	b := make([]byte, 64)
+	fillBurnInPattern(a, 0, 1)
+	fillBurnInPattern(b, 64, 1)
+	if slices.Equal(a, b) {
+		t.Error("Expected the pattern to depend on the offset")
+	}
+	fillBurnInPattern(b, 0, 2)
+	if slices.Equal(a, b) {
+		t.Error("Expected the pattern to depend on the seed")
+	}
+}
+
+func TestRunBurnIn(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		tested []string
+	)
+	mockProvider := &mockZFSProvider{
+		ListImportablePoolsFunc: func(ctx context.Context, zpoolPath string) ([]importablePool, error) {
+			return []importablePool{
+				{Name: "old", ID: "1", Devices: []string{"sdx"}},
+				{Name: "other", ID: "2", Devices: []string{"sdd"}},
+			}, nil
+		},
+		BurnInDeviceFunc: func(path string, size, seed uint64) error {
+			mu.Lock()
+			tested = append(tested, path)
+			mu.Unlock()
+			if path == "/dev/sdc" {
+				return errors.New("1 of 1024 chunks failed verification, first at offset 0")
+			}
+			return nil
+		},
+	}
+
+	configs := []poolConfig{
+		{Name: "tank", Disks: []diskSpec{{Dev: "/dev/sda"}, {Dev: "/dev/sdb"}}},
+		{Name: "existing", Disks: []diskSpec{{Dev: "/dev/sde"}}},
+		{Name: "old", Disks: []diskSpec{{Dev: "/dev/sdx"}}},
+		{Name: "bad", Disks: []diskSpec{{Dev: "/dev/sdc"}, {Dev: "/dev/sdd"}}},
+	}
+	state := newRunState(map[string]string{"existing": "123"})
+	errs := runBurnIn(t.Context(), mockProvider, "/fake/zpool", configs, state, 1<<30, 7)
+
+	slices.Sort(tested)
+	if !slices.Equal(tested, []string{"/dev/sda", "/dev/sdb", "/dev/sdc"}) {
+		t.Errorf("Expected only disks of new pools outside exported pools to be tested, got %v", tested)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), `pool "bad": disk /dev/sdc failed burn-in`) {
+		t.Errorf("Expected a single failure for /dev/sdc, got %v", errs)
+	}
+}
+
+func TestBurnInMain_RequiresConfirmation(t *testing.T) {
+	mockProvider := &mockZFSProvider{
+		BurnInDeviceFunc: func(path string, size, seed uint64) error {
+			t.Errorf("BurnInDevice called for %s without confirmation", path)
+			return nil
+		},
+	}
+	configs := []poolConfig{{Name: "tank", Disks: []diskSpec{{Dev: "/dev/sda"}}}}
+
+	t.Setenv("ZPOOL_BURNIN_CONFIRM", "yes")
+	if code := burnInMain(t.Context(), mockProvider, "/fake/zpool", configs); code != 1 {
+		t.Errorf("burnInMain() = %d without the confirmation value, want 1", code)
+	}
+}
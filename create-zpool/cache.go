@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// cacheParamPrefix starts the names of the ZFS module parameters a pool with cache devices may
+// set, see cacheModuleParameters.
+const cacheParamPrefix = "l2arc_"
+
+// validateCacheParams checks the L2ARC module parameters of a pool: they must be l2arc_*
+// parameters with valid values, and they only mean something with cache devices to apply to.
+func validateCacheParams(config poolConfig) error {
+	if len(config.CacheParams) == 0 {
+		return nil
+	}
+	if config.Cache.DiskList == "" {
+		return errors.New("cache parameters require cache devices, configure ZPOOL_<n>_CACHE_DISKS")
+	}
+	for _, name := range slices.Sorted(maps.Keys(config.CacheParams)) {
+		if !strings.HasPrefix(name, cacheParamPrefix) || !moduleParamNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid cache parameter %q, must be an %s* ZFS module parameter", name, cacheParamPrefix)
+		}
+		if value := config.CacheParams[name]; !moduleParamValuePattern.MatchString(value) {
+			return fmt.Errorf("invalid value %q for cache parameter %s", value, name)
+		}
+	}
+	return nil
+}
+
+// cacheModuleParameters adds the L2ARC module parameters of the enabled pools with cache devices
+// to params, the module parameters of the run. The parameters apply to every pool, so pools
+// asking for different values are an error, and so is a ZFS_PARAM_<name> with another value.
+// Pools with invalid parameters are skipped, they fail on their own.
+func cacheModuleParameters(configs []poolConfig, params map[string]string) error {
+	pools := make(map[string]string) // The pool that set each parameter.
+	for _, config := range configs {
+		if config.Disabled || len(config.CacheParams) == 0 || validateCacheParams(config) != nil {
+			continue
+		}
+		for _, name := range slices.Sorted(maps.Keys(config.CacheParams)) {
+			value := config.CacheParams[name]
+			current, ok := params[name]
+			switch {
+			case !ok:
+				params[name], pools[name] = value, config.Name
+			case current == value:
+			case pools[name] == "":
+				return fmt.Errorf("pool %s sets the cache parameter %s=%s, but %s%s is %s", config.Name, name, value, moduleParamEnvPrefix, name, current)
+			default:
+				return fmt.Errorf("pools %s and %s set the cache parameter %s to %s and %s, but it applies to all pools", pools[name], config.Name, name, current, value)
+			}
+		}
+	}
+	return nil
+}
+
+// reconcileCache changes the cache devices of an existing pool to the configured ones. Cache
+// devices only hold copies of the pool's data, so they can be added and removed at any time:
+// configured devices the pool lacks are added with `zpool add`, and cache devices that are not
+// configured are removed with `zpool remove`. A pool without configured cache devices is left
+// alone, so a cache added by hand is kept. Like on creation, every device to add must be usable.
+func reconcileCache(ctx context.Context, provider zfsProvider, zpoolPath string, config poolConfig, state *runState) error {
+	if config.Cache.DiskList == "" {
+		return nil
+	}
+	statuses, err := state.poolStatuses(provider, zpoolPath).Status(ctx, []string{config.Name})
+	if err != nil {
+		slog.Warn("Failed to read the pool status, not checking the cache devices", "pool", config.Name, "error", err)
+		return nil
+	}
+	status, ok := statuses[config.Name]
+	if !ok || status.Vdevs == nil {
+		// Without the vdev tree, e.g. from zpool versions without JSON output, the cache is unknown.
+		return nil
+	}
+	disks, err := config.Cache.disks()
+	if err != nil {
+		return fmt.Errorf("invalid cache disk list %q: %w", config.Cache.DiskList, err)
+	}
+
+	devices, missing := matchClassDevices(provider, status.classVdevs("cache"), disks)
+	if len(missing) > 0 {
+		slog.Info("Probing cache disks to add", "pool", config.Name, "disks", missing)
+		selected, unusable := selectDisks(provider, config.Name, missing, nil, state.usedDisks)
+		if len(unusable) > 0 {
+			for _, dev := range selected {
+				delete(state.usedDisks, dev)
+			}
+			return fmt.Errorf("%d of %d cache disks to add are unusable: %s", len(unusable), len(missing), strings.Join(unusable, ", "))
+		}
+		args := []string{"add"}
+		if ashift := config.classAshift("cache"); ashift != "" && ashift != "0" {
+			args = append(args, "-o", "ashift="+ashift)
+		}
+		args = append(append(args, config.Name, "cache"), selected...)
+		slog.Info("Adding configured cache devices", "pool", config.Name, "args", strings.Join(args, " "))
+		output, err := provider.AddVdevs(ctx, zpoolPath, args)
+		state.invalidatePoolStatuses()
+		if err != nil {
+			return fmt.Errorf("zpool add of cache %s failed: %w. Output: %s", strings.Join(selected, " "), err, string(output))
+		}
+	}
+	for _, device := range devices {
+		if device.wanted {
+			continue
+		}
+		slog.Info("Removing a cache device that is not configured", "pool", config.Name, "device", device.name())
+		output, err := provider.RemoveVdev(ctx, zpoolPath, config.Name, device.name())
+		state.invalidatePoolStatuses()
+		if err != nil {
+			return fmt.Errorf("zpool remove of cache device %s failed: %w. Output: %s", device.name(), err, string(output))
+		}
+	}
+	return nil
+}
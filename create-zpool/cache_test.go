@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParsePoolConfigs_Cache(t *testing.T) {
+	t.Setenv("ZPOOL_0_NAME", "tank")
+	t.Setenv("ZPOOL_0_CACHE_DISKS", "/dev/nvme2n1 /dev/nvme3n1")
+	t.Setenv("ZPOOL_0_CACHE_PARAMS", "l2arc_write_max=67108864, l2arc_rebuild_enabled=1")
+
+	configs := parsePoolConfigs()
+	if len(configs) != 1 {
+		t.Fatalf("parsePoolConfigs() returned %d configs, want 1", len(configs))
+	}
+	c := configs[0]
+	if c.Cache.DiskList != "/dev/nvme2n1 /dev/nvme3n1" || len(c.ParseErrors) != 0 {
+		t.Errorf("Unexpected cache %+v, errors %v", c.Cache, c.ParseErrors)
+	}
+	if want := map[string]string{"l2arc_write_max": "67108864", "l2arc_rebuild_enabled": "1"}; !maps.Equal(c.CacheParams, want) {
+		t.Errorf("CacheParams = %v, want %v", c.CacheParams, want)
+	}
+}
+
+func TestValidateCacheParams(t *testing.T) {
+	cache := vdevSpec{DiskList: "/dev/nvme2n1"}
+	tests := []struct {
+		name    string
+		cache   vdevSpec
+		params  map[string]string
+		wantErr string
+	}{
+		{"none", vdevSpec{}, nil, ""},
+		{"valid", cache, map[string]string{"l2arc_write_max": "67108864"}, ""},
+		{"without cache devices", vdevSpec{}, map[string]string{"l2arc_write_max": "67108864"}, "require cache devices"},
+		{"not an L2ARC parameter", cache, map[string]string{"zfs_arc_max": "1073741824"}, `invalid cache parameter "zfs_arc_max"`},
+		{"invalid value", cache, map[string]string{"l2arc_write_max": "64 M"}, "invalid value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateCacheParams(poolConfig{Name: "tank", Cache: tt.cache, CacheParams: tt.params})
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("validateCacheParams() returned an unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("validateCacheParams() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCacheModuleParameters(t *testing.T) {
+	cache := vdevSpec{DiskList: "/dev/nvme2n1"}
+	configs := []poolConfig{
+		{Name: "tank", Cache: cache, CacheParams: map[string]string{"l2arc_write_max": "67108864", "l2arc_headroom": "4"}},
+		{Name: "fast", Cache: cache, CacheParams: map[string]string{"l2arc_write_max": "67108864"}},
+		// Neither invalid nor disabled pools set parameters.
+		{Name: "bad", CacheParams: map[string]string{"l2arc_noprefetch": "0"}},
+		{Name: "off", Cache: cache, CacheParams: map[string]string{"l2arc_noprefetch": "0"}, Disabled: true},
+	}
+	params := map[string]string{"zfs_arc_max": "1073741824", "l2arc_headroom": "4"}
+	if err := cacheModuleParameters(configs, params); err != nil {
+		t.Fatalf("cacheModuleParameters() returned an unexpected error: %v", err)
+	}
+	if want := map[string]string{"zfs_arc_max": "1073741824", "l2arc_headroom": "4", "l2arc_write_max": "67108864"}; !maps.Equal(params, want) {
+		t.Errorf("Module parameters = %v, want %v", params, want)
+	}
+
+	configs[1].CacheParams["l2arc_write_max"] = "134217728"
+	if err := cacheModuleParameters(configs, map[string]string{}); err == nil || !strings.Contains(err.Error(), "pools tank and fast") {
+		t.Errorf("Expected an error for pools with different values, got %v", err)
+	}
+	if err := cacheModuleParameters(configs[:1], map[string]string{"l2arc_headroom": "8"}); err == nil || !strings.Contains(err.Error(), "ZFS_PARAM_l2arc_headroom is 8") {
+		t.Errorf("Expected an error for a conflicting ZFS_PARAM_l2arc_headroom, got %v", err)
+	}
+}
+
+func TestCreatePool_Cache(t *testing.T) {
+	var created string
+	var added []string
+	mockProvider := &mockZFSProvider{
+		IsBlockDeviceFunc: func(path string) (bool, error) { return path != "/dev/nvme9n1", nil },
+		CreatePoolFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+			created = strings.Join(args, " ")
+			return nil, nil
+		},
+		AddVdevsFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+			added = append(added, strings.Join(args, " "))
+			return nil, nil
+		},
+	}
+	config := poolConfig{
+		Name:   "tank",
+		Type:   "mirror",
+		Ashift: "12",
+		Disks:  []diskSpec{{Dev: "/dev/sda"}, {Dev: "/dev/sdb"}},
+		Log:    vdevSpec{DiskList: "/dev/nvme0n1"},
+		Cache:  vdevSpec{DiskList: "/dev/nvme2n1 /dev/nvme3n1"},
+	}
+	state := newRunState(nil)
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, state); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	if !strings.HasSuffix(created, "tank mirror /dev/sda /dev/sdb log /dev/nvme0n1 cache /dev/nvme2n1 /dev/nvme3n1") || len(added) != 0 {
+		t.Errorf("Unexpected create args %q, added %q", created, added)
+	}
+	if !state.usedDisks["/dev/nvme3n1"] {
+		t.Errorf("Expected the cache disks to be marked as used, got %v", state.usedDisks)
+	}
+
+	// Cache devices with their own ashift are added once the pool exists.
+	config.Cache.Ashift = "9"
+	created, added = "", nil
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newRunState(nil)); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	if strings.Contains(created, "cache") || !slices.Equal(added, []string{"add -o ashift=9 tank cache /dev/nvme2n1 /dev/nvme3n1"}) {
+		t.Errorf("Unexpected create args %q, added %q", created, added)
+	}
+
+	// A missing cache disk fails the pool.
+	config.Cache = vdevSpec{DiskList: "/dev/nvme2n1 /dev/nvme9n1"}
+	created = ""
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newRunState(nil)); err == nil || !strings.Contains(err.Error(), "cache disks are unusable") || created != "" {
+		t.Errorf("Expected no pool and an error for the unusable cache disk, got %q, %v", created, err)
+	}
+}
+
+func TestReconcileCache(t *testing.T) {
+	const cache = `"l2cache": {
+	  "nvme2n1": {"name": "nvme2n1", "vdev_type": "disk", "class": "l2cache", "path": "/dev/nvme2n1"},
+	  "nvme3n1": {"name": "nvme3n1", "vdev_type": "disk", "class": "l2cache", "path": "/dev/nvme3n1"}
+	}`
+	tests := []struct {
+		name         string
+		config       vdevSpec
+		wantCommands []string
+		wantErr      string
+	}{
+		{
+			name:   "cache as configured",
+			config: vdevSpec{DiskList: "/dev/nvme3n1 /dev/nvme2n1"},
+		},
+		{
+			name:         "add a device",
+			config:       vdevSpec{DiskList: "/dev/nvme2n1 /dev/nvme3n1 /dev/nvme4n1"},
+			wantCommands: []string{"add -o ashift=12 tank cache /dev/nvme4n1"},
+		},
+		{
+			name:         "replace a device",
+			config:       vdevSpec{DiskList: "/dev/nvme2n1 /dev/nvme4n1", Ashift: "9"},
+			wantCommands: []string{"add -o ashift=9 tank cache /dev/nvme4n1", "remove /dev/nvme3n1"},
+		},
+		{
+			name: "cache without configuration",
+		},
+		{
+			name:    "unusable device",
+			config:  vdevSpec{DiskList: "/dev/nvme9n1"},
+			wantErr: "1 of 1 cache disks to add are unusable: /dev/nvme9n1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := `{"pools": {"tank": {"name": "tank", "vdevs": {"tank": {"name": "tank", "vdevs": {
+			  "mirror-0": {"name": "mirror-0", "vdevs": {"sda": {"name": "sda"}, "sdb": {"name": "sdb"}}}}}},
+			  ` + cache + `}}}`
+			var commands []string
+			mockProvider := &mockZFSProvider{
+				GetAllPoolStatusFunc: func(ctx context.Context, zpoolPath string) ([]byte, error) { return []byte(status), nil },
+				IsBlockDeviceFunc:    func(path string) (bool, error) { return path != "/dev/nvme9n1", nil },
+				AddVdevsFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+					commands = append(commands, strings.Join(args, " "))
+					return nil, nil
+				},
+				RemoveVdevFunc: func(ctx context.Context, zpoolPath, name, vdev string) ([]byte, error) {
+					commands = append(commands, "remove "+vdev)
+					return nil, nil
+				},
+			}
+			config := poolConfig{Name: "tank", Type: "mirror", Ashift: "12", Cache: tt.config}
+			state := newRunState(map[string]string{"tank": "1234567890"})
+			err := createPool(t.Context(), mockProvider, "/fake/zpool", config, state)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("createPool() returned an unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("createPool() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+			if !slices.Equal(commands, tt.wantCommands) {
+				t.Errorf("Commands = %q, want %q", commands, tt.wantCommands)
+			}
+		})
+	}
+}
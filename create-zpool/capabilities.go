@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// supportedProperty is a property listed in the usage message of `zpool get` or `zfs get`.
+type supportedProperty struct {
+	Name     string
+	Editable bool
+	Values   string // e.g. "on | off" or "<size>".
+}
+
+// parsePropertyHelp parses the property table that `zpool get` and `zfs get` print as part of
+// their usage message. zfs has an additional INHERIT column, which is skipped.
+func parsePropertyHelp(output string) []supportedProperty {
+	var props []supportedProperty
+	inTable := false
+	for line := range strings.Lines(output) {
+		if strings.Contains(strings.ToLower(line), "properties are supported") {
+			inTable = true
+			continue
+		}
+		fields := strings.Fields(line)
+		if !inTable || len(fields) == 0 {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			// The table ends with the first unindented line, e.g. the note on user properties.
+			break
+		}
+		if len(fields) < 2 || fields[0] == "PROPERTY" || (fields[1] != "YES" && fields[1] != "NO") {
+			continue
+		}
+		values := fields[2:]
+		if len(values) > 0 && (values[0] == "YES" || values[0] == "NO") {
+			values = values[1:]
+		}
+		props = append(props, supportedProperty{Name: fields[0], Editable: fields[1] == "YES", Values: strings.Join(values, " ")})
+	}
+	return props
+}
+
+// poolPropertyVariable returns the variable a pool property is configured with, or "" if this
+// tool does not accept it. Every other editable property can be set in ZPOOL_<n>_PROPERTIES.
+func poolPropertyVariable(prop supportedProperty) string {
+	name := prop.Name
+	if name == "ashift" {
+		return "ZPOOL_<n>_ASHIFT"
+	}
+	if managed, ok := managedPoolProperties[name]; ok {
+		return "ZPOOL_<n>_" + managed.envSuffix
+	}
+	if _, ok := reservedPoolProperties[name]; !ok && prop.Editable {
+		return "ZPOOL_<n>_PROPERTIES"
+	}
+	return ""
+}
+
+// datasetPropertyVariable returns the variable a dataset property is configured with, or "" if
+// this tool does not accept it. Every other editable property can be set on zvols.
+func datasetPropertyVariable(prop supportedProperty) string {
+	switch prop.Name {
+	case "mountpoint":
+		return "ZPOOL_<n>_MOUNTPOINT"
+	case "canmount":
+		return "ZPOOL_<n>_CANMOUNT"
+	}
+	if prop.Editable {
+		return "ZPOOL_<n>_ZVOL_<m>_PROPERTIES"
+	}
+	return ""
+}
+
+// capabilitiesMain implements ZPOOL_MODE=capabilities: it prints the vdev types, pool and
+// dataset properties and pool features supported by the local binaries and kernel module,
+// together with the variables this tool accepts them in.
+func capabilitiesMain(ctx context.Context, provider zfsProvider, zpoolPath, zfsPath string, w io.Writer) int {
+	features, err := provider.ListPoolFeatures()
+	if err != nil {
+		slog.Warn("Failed to list the pool features of the kernel module", "error", err)
+	}
+
+	fmt.Fprintln(w, "Vdev types (ZPOOL_<n>_TYPE, empty adds every disk as a vdev of its own):")
+	types := supportedVdevTypes
+	if features != nil && !slices.Contains(features, "draid") {
+		types = slices.DeleteFunc(slices.Clone(types), func(t string) bool { return strings.HasPrefix(t, "draid") })
+	}
+	fmt.Fprintf(w, "  %s\n", strings.Join(types, " "))
+	if len(types) < len(supportedVdevTypes) {
+		fmt.Fprintln(w, "  The loaded kernel module does not support dRAID.")
+	}
+
+	code := 0
+	tables := []struct {
+		title    string
+		binPath  string
+		variable func(supportedProperty) string
+	}{
+		{"Pool properties (zpool get)", zpoolPath, poolPropertyVariable},
+		{"Dataset properties (zfs get)", zfsPath, datasetPropertyVariable},
+	}
+	for _, table := range tables {
+		fmt.Fprintf(w, "\n%s:\n", table.title)
+		if table.binPath == "" {
+			fmt.Fprintln(w, "  zfs binary not found")
+			continue
+		}
+		// The usage message is printed with a non-zero exit code, so the output decides.
+		output, err := provider.GetPropertyHelp(ctx, table.binPath)
+		props := parsePropertyHelp(string(output))
+		if len(props) == 0 {
+			slog.Error("Failed to list the supported properties", "binary", table.binPath, "error", err, "output", strings.TrimSpace(string(output)))
+			code = 1
+			continue
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  PROPERTY\tEDIT\tVALUES\tCONFIGURED WITH")
+		for _, prop := range props {
+			edit, variable := "NO", table.variable(prop)
+			if prop.Editable {
+				edit = "YES"
+			}
+			if variable == "" {
+				variable = "-"
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", prop.Name, edit, prop.Values, variable)
+		}
+		tw.Flush()
+	}
+
+	fmt.Fprintf(w, "\nPool features (%s, restricted with ZPOOL_<n>_COMPATIBILITY):\n", zfsPoolFeaturesPath)
+	if features == nil {
+		fmt.Fprintln(w, "  unknown, is the zfs kernel module loaded?")
+	} else {
+		fmt.Fprintf(w, "  %s\n", strings.Join(slices.Sorted(slices.Values(features)), " "))
+	}
+	return code
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const zpoolGetUsage = `usage:
+	get [-Hp] [-o "all" | field[,...]] <"all" | property[,...]> <pool> ...
+
+the following properties are supported:
+
+	PROPERTY             EDIT   VALUES
+
+	allocated              NO   <size>
+	ashift                YES   <ashift, 9-16, or 0=default>
+	autotrim              YES   on | off
+	comment               YES   <comment-string>
+	failmode              YES   wait | continue | panic
+
+The feature@ properties must be appended with a feature name.
+See zpool-features(7).
+`
+
+const zfsGetUsage = `usage:
+	get [-crHp] [-d max] [-o "all" | field[,...]]
+
+The following properties are supported:
+
+	PROPERTY       EDIT  INHERIT   VALUES
+
+	available        NO       NO   <size>
+	canmount        YES       NO   on | off | noauto
+	compression     YES      YES   on | off | lzjb | gzip | gzip-[1-9] | zle | lz4 | zstd
+
+Sizes are specified in bytes with standard units such as K, M, G, etc.
+`
+
+func TestParsePropertyHelp(t *testing.T) {
+	props := parsePropertyHelp(zfsGetUsage)
+	want := []supportedProperty{
+		{Name: "available", Values: "<size>"},
+		{Name: "canmount", Editable: true, Values: "on | off | noauto"},
+		{Name: "compression", Editable: true, Values: "on | off | lzjb | gzip | gzip-[1-9] | zle | lz4 | zstd"},
+	}
+	if len(props) != len(want) {
+		t.Fatalf("parsePropertyHelp() = %+v, want %+v", props, want)
+	}
+	for i := range want {
+		if props[i] != want[i] {
+			t.Errorf("property %d = %+v, want %+v", i, props[i], want[i])
+		}
+	}
+	if props := parsePropertyHelp(zpoolGetUsage); len(props) != 5 || props[1].Values != "<ashift, 9-16, or 0=default>" {
+		t.Errorf("Unexpected pool properties %+v", props)
+	}
+}
+
+func TestCapabilitiesMain(t *testing.T) {
+	mockProvider := &mockZFSProvider{
+		GetPropertyHelpFunc: func(ctx context.Context, binPath string) ([]byte, error) {
+			if binPath == "/fake/zfs" {
+				return []byte(zfsGetUsage), errors.New("exit status 2")
+			}
+			return []byte(zpoolGetUsage), errors.New("exit status 2")
+		},
+		ListPoolFeaturesFunc: func() ([]string, error) { return []string{"zstd_compress", "async_destroy"}, nil },
+	}
+	var out strings.Builder
+	if code := capabilitiesMain(t.Context(), mockProvider, "/fake/zpool", "/fake/zfs", &out); code != 0 {
+		t.Fatalf("capabilitiesMain() = %d, want 0", code)
+	}
+	for _, want := range []string{
+		"  mirror raidz raidz1 raidz2 raidz3\n  The loaded kernel module does not support dRAID.\n",
+		"autotrim   YES   on | off",
+		"ZPOOL_<n>_AUTOTRIM",
+		"allocated  NO    <size>",
+		"canmount     YES   on | off | noauto",
+		"ZPOOL_<n>_ZVOL_<m>_PROPERTIES",
+		"  async_destroy zstd_compress\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in the output, got:\n%s", want, out.String())
+		}
+	}
+
+	mockProvider.GetPropertyHelpFunc = func(ctx context.Context, binPath string) ([]byte, error) {
+		return []byte("exec format error"), errors.New("exit status 126")
+	}
+	if code := capabilitiesMain(t.Context(), mockProvider, "/fake/zpool", "", &strings.Builder{}); code != 1 {
+		t.Errorf("capabilitiesMain() = %d without a property list, want 1", code)
+	}
+}
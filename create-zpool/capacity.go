@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+const defaultCapacityThresholds = "80,90,95" // Capacity warning thresholds in percent, see ZPOOL_CAPACITY_THRESHOLDS.
+
+// parseCapacityThresholds parses a comma separated list of ascending capacity percentages.
+// An empty list disables capacity warnings.
+func parseCapacityThresholds(s string) ([]int, error) {
+	var thresholds []int
+	for field := range strings.SplitSeq(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		v, err := strconv.Atoi(field)
+		if err != nil || v < 1 || v > 100 {
+			return nil, fmt.Errorf("invalid capacity threshold %q, must be a percentage between 1 and 100", field)
+		}
+		if len(thresholds) > 0 && v <= thresholds[len(thresholds)-1] {
+			return nil, fmt.Errorf("capacity thresholds must be ascending, got %q", s)
+		}
+		thresholds = append(thresholds, v)
+	}
+	return thresholds, nil
+}
+
+// capacityLevel returns how many of the thresholds capacity reached.
+func capacityLevel(capacity int, thresholds []int) int {
+	level := 0
+	for _, t := range thresholds {
+		if capacity >= t {
+			level++
+		}
+	}
+	return level
+}
+
+// checkCapacity warns about pools whose capacity reached one of the thresholds, since ZFS
+// performance degrades sharply as a pool fills up. The warnings escalate with the threshold:
+// the highest one is logged as an error.
+func checkCapacity(ctx context.Context, provider zfsProvider, zpoolPath string, names []string, thresholds []int) {
+	for _, name := range names {
+		props, err := provider.GetPoolProperties(ctx, zpoolPath, name, []string{"capacity"})
+		if err != nil {
+			// Pools that do not exist are already reported by the health check.
+			continue
+		}
+		value := props["capacity"]
+		capacity, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+		if err != nil {
+			slog.Warn("Failed to parse pool capacity", "pool", name, "capacity", value)
+			continue
+		}
+		level := capacityLevel(capacity, thresholds)
+		if level == 0 {
+			continue
+		}
+		logArgs := []any{"pool", name, "capacity", value, "threshold", fmt.Sprintf("%d%%", thresholds[level-1])}
+		if level == len(thresholds) {
+			slog.Error("Pool is almost full", logArgs...)
+		} else {
+			slog.Warn("Pool is filling up", logArgs...)
+		}
+	}
+}
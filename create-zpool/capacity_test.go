@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestParseCapacityThresholds(t *testing.T) {
+	tests := map[string]struct {
+		input   string
+		want    []int
+		wantErr bool
+	}{
+		"default":      {input: defaultCapacityThresholds, want: []int{80, 90, 95}},
+		"spaces":       {input: " 70 , 85 ", want: []int{70, 85}},
+		"disabled":     {input: "", want: nil},
+		"descending":   {input: "90,80", wantErr: true},
+		"out of range": {input: "0,101", wantErr: true},
+		"not a number": {input: "80%", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseCapacityThresholds(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCapacityThresholds(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("parseCapacityThresholds(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCapacityLevel(t *testing.T) {
+	thresholds := []int{80, 90, 95}
+	for capacity, want := range map[int]int{0: 0, 79: 0, 80: 1, 94: 2, 95: 3, 100: 3} {
+		if got := capacityLevel(capacity, thresholds); got != want {
+			t.Errorf("capacityLevel(%d) = %d, want %d", capacity, got, want)
+		}
+	}
+}
+
+func TestCheckCapacity(t *testing.T) {
+	var queried []string
+	mockProvider := &mockZFSProvider{
+		GetPoolPropertiesFunc: func(ctx context.Context, zpoolPath, name string, props []string) (map[string]string, error) {
+			queried = append(queried, name)
+			if !slices.Equal(props, []string{"capacity"}) {
+				t.Errorf("Unexpected properties %v", props)
+			}
+			switch name {
+			case "full":
+				return map[string]string{"capacity": "97%"}, nil
+			case "odd":
+				return map[string]string{"capacity": "-"}, nil
+			}
+			return nil, errors.New("no such pool")
+		},
+	}
+	// Missing pools and unparsable values are skipped without affecting the others.
+	checkCapacity(t.Context(), mockProvider, "/fake/zpool", []string{"missing", "odd", "full"}, []int{80, 90, 95})
+	if !slices.Equal(queried, []string{"missing", "odd", "full"}) {
+		t.Errorf("Expected every pool to be checked, got %v", queried)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// modeComplete is the hidden mode the completion scripts call to complete names from the live
+// system: `__complete pools` lists the imported pools, `__complete devices <pool>` the leaf
+// devices of a pool's data vdevs.
+const modeComplete = "__complete"
+
+// completionShells are the shells `completion` writes scripts for.
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// The completion scripts. %[1]s is replaced with the modes, %[2]s with the shells, %[3]s with
+// the example layouts and %[4]s with their formats.
+const (
+	bashCompletion = `# bash completion for create-zpool, load with: source <(create-zpool completion bash)
+_create_zpool() {
+	local cur=${COMP_WORDS[COMP_CWORD]}
+	case $COMP_CWORD in
+	1) COMPREPLY=($(compgen -W "%[1]s" -- "$cur")) ;;
+	2)
+		case ${COMP_WORDS[1]} in
+		completion) COMPREPLY=($(compgen -W "%[2]s" -- "$cur")) ;;
+		config) COMPREPLY=($(compgen -W "example schema" -- "$cur")) ;;
+		split) COMPREPLY=($(compgen -W "$(create-zpool __complete pools 2>/dev/null)" -- "$cur")) ;;
+		esac
+		;;
+	*)
+		if [[ ${COMP_WORDS[1]} == config && $COMP_CWORD -eq 3 ]]; then
+			COMPREPLY=($(compgen -W "%[3]s" -- "$cur"))
+		elif [[ ${COMP_WORDS[1]} == config && $COMP_CWORD -eq 4 ]]; then
+			COMPREPLY=($(compgen -W "%[4]s" -- "$cur"))
+		elif [[ ${COMP_WORDS[1]} == split && $COMP_CWORD -ge 4 ]]; then
+			COMPREPLY=($(compgen -W "$(create-zpool __complete devices "${COMP_WORDS[2]}" 2>/dev/null)" -- "$cur"))
+		fi
+		;;
+	esac
+}
+complete -F _create_zpool create-zpool
+`
+	zshCompletion = `#compdef create-zpool
+# zsh completion for create-zpool, load with: source <(create-zpool completion zsh)
+_create_zpool() {
+	case $CURRENT in
+	2) compadd -- %[1]s ;;
+	3)
+		case ${words[2]} in
+		completion) compadd -- %[2]s ;;
+		config) compadd -- example schema ;;
+		split) compadd -- ${(f)"$(create-zpool __complete pools 2>/dev/null)"} ;;
+		esac
+		;;
+	*)
+		if [[ ${words[2]} == config && $CURRENT -eq 4 ]]; then
+			compadd -- %[3]s
+		elif [[ ${words[2]} == config && $CURRENT -eq 5 ]]; then
+			compadd -- %[4]s
+		elif [[ ${words[2]} == split && $CURRENT -ge 5 ]]; then
+			compadd -- ${(f)"$(create-zpool __complete devices ${words[3]} 2>/dev/null)"}
+		fi
+		;;
+	esac
+}
+compdef _create_zpool create-zpool
+`
+	fishCompletion = `# fish completion for create-zpool, load with: create-zpool completion fish | source
+complete -c create-zpool -f
+complete -c create-zpool -n __fish_use_subcommand -a "%[1]s"
+complete -c create-zpool -n "__fish_seen_subcommand_from completion" -a "%[2]s"
+complete -c create-zpool -n "__fish_seen_subcommand_from config; and test (count (commandline -opc)) -eq 2" -a "example schema"
+complete -c create-zpool -n "__fish_seen_subcommand_from config; and test (count (commandline -opc)) -eq 3" -a "%[3]s"
+complete -c create-zpool -n "__fish_seen_subcommand_from config; and test (count (commandline -opc)) -eq 4" -a "%[4]s"
+complete -c create-zpool -n "__fish_seen_subcommand_from split; and test (count (commandline -opc)) -eq 2" -a "(create-zpool __complete pools 2>/dev/null)"
+complete -c create-zpool -n "__fish_seen_subcommand_from split; and test (count (commandline -opc)) -ge 4" -a "(create-zpool __complete devices (commandline -opc)[3] 2>/dev/null)"
+`
+)
+
+// completionMain implements `completion <shell>`: it writes the completion script for the
+// shell to w.
+func completionMain(w io.Writer, args []string) int {
+	scripts := map[string]string{"bash": bashCompletion, "zsh": zshCompletion, "fish": fishCompletion}
+	if len(args) != 1 || scripts[args[0]] == "" {
+		slog.Error("Usage: completion <shell>", "valid", completionShells)
+		return 1
+	}
+	fmt.Fprintf(w, scripts[args[0]], strings.Join(allModes, " "), strings.Join(completionShells, " "), strings.Join(configExampleNames(), " "), strings.Join(exampleFormats, " "))
+	return 0
+}
+
+// completeMain implements the hidden __complete mode, writing one candidate per line to w.
+// Failures only print nothing, so that completion never gets in the way.
+func completeMain(ctx context.Context, provider zfsProvider, w io.Writer, args []string) int {
+	zpoolPath, err := resolveBinary(provider, "ZPOOL_BIN", "zpool")
+	if err != nil || len(args) == 0 {
+		return 1
+	}
+	var candidates []string
+	switch {
+	case args[0] == "pools":
+		pools, err := provider.ListPools(ctx, zpoolPath)
+		if err != nil {
+			return 1
+		}
+		candidates = slices.Sorted(maps.Keys(pools))
+	case args[0] == "devices" && len(args) == 2:
+		statuses, err := newPoolStatusCache(provider, zpoolPath).Status(ctx, []string{args[1]})
+		if err != nil || statuses[args[1]] == nil {
+			return 1
+		}
+		for _, vdev := range statuses[args[1]].dataVdevs() {
+			for _, leaf := range vdev.leaves() {
+				candidates = append(candidates, leaf.Name)
+			}
+		}
+	default:
+		return 1
+	}
+	for _, candidate := range candidates {
+		fmt.Fprintln(w, candidate)
+	}
+	return 0
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCompletionMain(t *testing.T) {
+	for _, shell := range completionShells {
+		var out strings.Builder
+		if code := completionMain(&out, []string{shell}); code != 0 {
+			t.Fatalf("completionMain(%s) = %d, want 0", shell, code)
+		}
+		for _, want := range append([]string{"__complete pools", "__complete devices"}, allModes...) {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("Expected %q in the %s completion script", want, shell)
+			}
+		}
+		if strings.Contains(out.String(), "%!") {
+			t.Errorf("Unexpected formatting error in the %s completion script:\n%s", shell, out.String())
+		}
+	}
+	if code := completionMain(&strings.Builder{}, []string{"powershell"}); code != 1 {
+		t.Errorf("completionMain(powershell) = %d, want 1", code)
+	}
+}
+
+func TestCompleteMain(t *testing.T) {
+	mockProvider := &mockZFSProvider{
+		ListPoolsFunc: func(ctx context.Context, zpoolPath string) (map[string]string, error) {
+			return map[string]string{"tank": "1", "data": "2"}, nil
+		},
+		GetAllPoolStatusFunc: func(ctx context.Context, zpoolPath string) ([]byte, error) {
+			return []byte(splitPoolStatusJSON), nil
+		},
+	}
+	tests := []struct {
+		args []string
+		want string
+		code int
+	}{
+		{args: []string{"pools"}, want: "data\ntank\n"},
+		{args: []string{"devices", "tank"}, want: "sda\nsdb\nsdc\nsdd\n"},
+		{args: []string{"devices", "missing"}, code: 1},
+		{args: []string{"datasets"}, code: 1},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		if code := completeMain(t.Context(), mockProvider, &out, tt.args); code != tt.code || out.String() != tt.want {
+			t.Errorf("completeMain(%q) = %d, %q, want %d, %q", tt.args, code, out.String(), tt.code, tt.want)
+		}
+	}
+}
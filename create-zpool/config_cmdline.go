@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// cmdlinePrefix is the prefix of the kernel command line parameters that configure this tool,
+// e.g. talos.zpool.0.name=tank for ZPOOL_0_NAME.
+const cmdlinePrefix = "talos.zpool."
+
+// procCmdlineFile is the kernel command line of the running kernel.
+var procCmdlineFile = "/proc/cmdline"
+
+// cmdlineKeyPattern matches the part of a parameter name after cmdlinePrefix.
+var cmdlineKeyPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// cmdlineConfigSource returns the configuration variables of the kernel command line, for nodes
+// that can only be configured with kernel arguments, e.g. when booted via PXE. A missing
+// procCmdlineFile yields an empty source.
+func cmdlineConfigSource() (configSource, []string, error) {
+	source := configSource{Name: "cmdline", Precedence: precedenceCmdline, Vars: map[string]string{}}
+	data, err := os.ReadFile(procCmdlineFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return source, nil, nil
+	}
+	if err != nil {
+		return source, nil, err
+	}
+	var invalid []string
+	source.Vars, invalid = parseCmdlineConfig(string(data))
+	return source, invalid, nil
+}
+
+// parseCmdlineConfig translates the talos.zpool.* parameters of a kernel command line into the
+// variables they stand for: the rest of the name in upper case with dots and dashes replaced by
+// underscores, e.g. talos.zpool.0.wait-for-disks=2m for ZPOOL_0_WAIT_FOR_DISKS. A parameter
+// without a value is true, like a kernel flag. Parameters with an invalid name are returned as
+// invalid. Later parameters override earlier ones, as they do for the kernel.
+func parseCmdlineConfig(cmdline string) (map[string]string, []string) {
+	vars := make(map[string]string)
+	var invalid []string
+	for _, param := range splitCmdline(cmdline) {
+		name, value, ok := strings.Cut(param, "=")
+		rest, found := strings.CutPrefix(name, cmdlinePrefix)
+		if !found {
+			continue
+		}
+		if !cmdlineKeyPattern.MatchString(rest) {
+			invalid = append(invalid, param)
+			continue
+		}
+		if !ok {
+			value = "true"
+		}
+		vars["ZPOOL_"+strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(rest))] = value
+	}
+	return vars, invalid
+}
+
+// splitCmdline splits a kernel command line into its parameters like the kernel does: at
+// whitespace outside of double quotes, which are removed, so that a parameter like
+// talos.zpool.0.disk.0.model="WDC WD80*" keeps its space.
+func splitCmdline(cmdline string) []string {
+	var params []string
+	var param strings.Builder
+	quoted, inParam := false, false
+	for _, r := range cmdline {
+		switch {
+		case r == '"':
+			quoted, inParam = !quoted, true
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			if inParam {
+				params = append(params, param.String())
+				param.Reset()
+				inParam = false
+			}
+		default:
+			param.WriteRune(r)
+			inParam = true
+		}
+	}
+	if inParam {
+		params = append(params, param.String())
+	}
+	return params
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseCmdlineConfig(t *testing.T) {
+	cmdline := `BOOT_IMAGE=/vmlinuz talos.platform=metal talos.zpool.0.name=tank talos.zpool.0.disks=/dev/sda,/dev/sdb ` +
+		`talos.zpool.0.type=mirror talos.zpool.0.disk.0.model="WDC WD80*" talos.zpool.0.strict-disks talos.zpool.ashift=13 ` +
+		`talos.zpool.0.name=data talos.zpool.=x talos.zpool.0/name=y console=ttyS0` + "\n"
+	vars, invalid := parseCmdlineConfig(cmdline)
+	want := map[string]string{
+		"ZPOOL_0_NAME":         "data", // The last one wins.
+		"ZPOOL_0_DISKS":        "/dev/sda,/dev/sdb",
+		"ZPOOL_0_TYPE":         "mirror",
+		"ZPOOL_0_DISK_0_MODEL": "WDC WD80*",
+		"ZPOOL_0_STRICT_DISKS": "true",
+		"ZPOOL_ASHIFT":         "13",
+	}
+	if !maps.Equal(vars, want) {
+		t.Errorf("parseCmdlineConfig() = %v, want %v", vars, want)
+	}
+	if !slices.Equal(invalid, []string{"talos.zpool.=x", "talos.zpool.0/name=y"}) {
+		t.Errorf("Unexpected invalid parameters %q", invalid)
+	}
+}
+
+func TestCmdlineConfigSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cmdline")
+	old := procCmdlineFile
+	procCmdlineFile = path
+	t.Cleanup(func() { procCmdlineFile = old })
+
+	source, _, err := cmdlineConfigSource()
+	if err != nil || len(source.Vars) != 0 {
+		t.Errorf("Expected an empty source without a command line, got %v, %v", source.Vars, err)
+	}
+	if err := os.WriteFile(path, []byte("quiet talos.zpool.0.name=tank\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	source, _, err = cmdlineConfigSource()
+	if err != nil || source.Vars["ZPOOL_0_NAME"] != "tank" || source.Precedence != precedenceCmdline {
+		t.Errorf("Unexpected source %+v, %v", source, err)
+	}
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Output formats of `config example`.
+const (
+	exampleFormatFile = "file" // An ExtensionServiceConfig document, as applied with talosctl.
+	exampleFormatEnv  = "env"  // Plain KEY=value lines, e.g. for an env file when running the binary by hand.
+	// An ExtensionServiceConfig document with the pools as structured data in a configuration
+	// file that Talos mounts into the container, see loadConfigFile.
+	exampleFormatConfigFile = "configfile"
+)
+
+// exampleFormats are the output formats of `config example`, the default first.
+var exampleFormats = []string{exampleFormatFile, exampleFormatEnv, exampleFormatConfigFile}
+
+var (
+	// examplePoolVar matches the per-pool variables of the examples.
+	examplePoolVar = regexp.MustCompile(`^ZPOOL_([0-9]+)_(.+)$`)
+	// exampleListVar matches the per-pool variables that are entries of a list of the pool object.
+	exampleListVar = regexp.MustCompile(`^(DISK|SIZE)_[0-9]+(_DEV|_MODEL)?$`)
+	// examplePlainValue matches the values YAML reads as the string they are without quotes.
+	examplePlainValue = regexp.MustCompile(`^[A-Za-z0-9/][A-Za-z0-9/_.:-]*$`)
+)
+
+// exampleLine is a line of an example configuration: a variable, a comment, or a blank line
+// if both are empty.
+type exampleLine struct {
+	Comment string
+	Env     string // KEY=value.
+}
+
+// configExample is a sample configuration for a common pool layout.
+type configExample struct {
+	Name        string
+	Description string
+	Lines       []exampleLine
+}
+
+// exampleCommonLines are the optional settings shown at the end of every example.
+var exampleCommonLines = []exampleLine{
+	{},
+	{Comment: "Optional: wait for slow controllers to expose all disks before creating the pool,"},
+	{Comment: "instead of creating it with the disks found so far."},
+	{Env: "ZPOOL_0_STRICT=true"},
+	{Env: "ZPOOL_0_WAIT_FOR_DISKS=2m"},
+	{},
+	{Comment: "Optional: keep running after the pools are up and report their health."},
+	{Comment: "ZPOOL_WATCH_INTERVAL=5m"},
+}
+
+// configExamples are the layouts `config example` knows, in the order they are listed.
+var configExamples = []configExample{
+	{
+		Name:        "single",
+		Description: "one disk without redundancy, e.g. for scratch space",
+		Lines: []exampleLine{
+			{Comment: "A pool named 'scratch' on a single disk. ZFS detects corruption, but cannot repair it."},
+			{Env: "ZPOOL_0_NAME=scratch"},
+			{Comment: "Use stable /dev/disk/by-id paths, /dev/sdX names can change between boots."},
+			{Env: "ZPOOL_0_DISK_0_DEV=/dev/disk/by-id/nvme-Samsung_SSD_980_S1"},
+			{Comment: "4K sectors; the default, set explicitly since it cannot be changed later."},
+			{Env: "ZPOOL_0_ASHIFT=12"},
+			{Comment: "Mounted at /var/mnt/scratch by default, which is shared with the host."},
+			{Env: "ZPOOL_0_AUTOTRIM=on"},
+		},
+	},
+	{
+		Name:        "mirror",
+		Description: "two disks mirroring each other",
+		Lines: []exampleLine{
+			{Comment: "A mirrored pool named 'tank'; it survives the loss of either disk."},
+			{Env: "ZPOOL_0_NAME=tank"},
+			{Env: "ZPOOL_0_TYPE=mirror"},
+			{Env: "ZPOOL_0_ASHIFT=12"},
+			{Comment: "Disks can be given by path or matched by model, and mixed."},
+			{Env: "ZPOOL_0_DISK_0_DEV=/dev/disk/by-id/nvme-Samsung_SSD_980_S1"},
+			{Env: "ZPOOL_0_DISK_1_MODEL=Samsung SSD 980*"},
+			{Comment: "Only accept disks of the expected size when matching by model."},
+			{Env: "ZPOOL_0_SIZE_0=>=900GB"},
+			{Comment: "Let the SSDs reclaim freed blocks."},
+			{Env: "ZPOOL_0_AUTOTRIM=on"},
+			{Comment: "Enforce autotrim on the pool on every boot, not only at creation."},
+			{Env: "ZPOOL_0_RECONCILE=autotrim"},
+		},
+	},
+	{
+		Name:        "raidz2",
+		Description: "six disks with double parity and an SSD cache",
+		Lines: []exampleLine{
+			{Comment: "A raidz2 pool named 'data' of six disks; any two of them can fail."},
+			{Env: "ZPOOL_0_NAME=data"},
+			{Env: "ZPOOL_0_TYPE=raidz2"},
+			{Env: "ZPOOL_0_ASHIFT=12"},
+			{Comment: "The compact form lists all disks in one variable."},
+			{Env: "ZPOOL_0_DISKS=/dev/disk/by-id/ata-HDD_1 /dev/disk/by-id/ata-HDD_2 /dev/disk/by-id/ata-HDD_3 /dev/disk/by-id/ata-HDD_4 /dev/disk/by-id/ata-HDD_5 /dev/disk/by-id/ata-HDD_6"},
+			{Comment: "Spinning disks: zero them once, so that the first resilver doesn't read garbage."},
+			{Env: "ZPOOL_0_INITIALIZE=true"},
+			{Comment: "Grow the pool once all disks of the vdev are replaced by larger ones."},
+			{Env: "ZPOOL_0_PROPERTIES=autoexpand=on"},
+			{Comment: "Optional: a mirrored separate log (SLOG) on fast SSDs for sync-heavy workloads."},
+			{Comment: "ZPOOL_0_LOG_TYPE=mirror"},
+			{Comment: "ZPOOL_0_LOG_DISKS=/dev/disk/by-id/nvme-Optane_1 /dev/disk/by-id/nvme-Optane_2"},
+			{Comment: "A cache (L2ARC) on an SSD for reads that miss the ARC. It only holds copies of the data,"},
+			{Comment: "so it needs no redundancy, and devices can be added or removed later. The L2ARC"},
+			{Comment: "parameter keeps its contents across reboots instead of warming it up again."},
+			{Env: "ZPOOL_0_CACHE_DISKS=/dev/disk/by-id/nvme-Samsung_SSD_990_1"},
+			{Env: "ZPOOL_0_CACHE_PARAMS=l2arc_rebuild_enabled=1"},
+		},
+	},
+	{
+		Name:        "draid",
+		Description: "a dRAID pool with distributed spare capacity for large disk counts",
+		Lines: []exampleLine{
+			{Comment: "A draid2 pool named 'archive'. dRAID rebuilds onto spare space spread over all"},
+			{Comment: "disks, which is much faster than resilvering onto a single spare disk."},
+			{Env: "ZPOOL_0_NAME=archive"},
+			{Env: "ZPOOL_0_TYPE=draid2"},
+			{Comment: "One distributed spare and eight children, i.e. disks, for draid2:8c:1s."},
+			{Env: "ZPOOL_0_DRAID_CHILDREN=8"},
+			{Env: "ZPOOL_0_DRAID_SPARES=1"},
+			{Env: "ZPOOL_0_ASHIFT=12"},
+			{Comment: "Each entry picks another disk of the model, the size filter excludes smaller ones."},
+			{Env: "ZPOOL_0_DISK_0_MODEL=ST16000NM*"},
+			{Env: "ZPOOL_0_DISK_1_MODEL=ST16000NM*"},
+			{Env: "ZPOOL_0_DISK_2_MODEL=ST16000NM*"},
+			{Env: "ZPOOL_0_DISK_3_MODEL=ST16000NM*"},
+			{Env: "ZPOOL_0_DISK_4_MODEL=ST16000NM*"},
+			{Env: "ZPOOL_0_DISK_5_MODEL=ST16000NM*"},
+			{Env: "ZPOOL_0_DISK_6_MODEL=ST16000NM*"},
+			{Env: "ZPOOL_0_DISK_7_MODEL=ST16000NM*"},
+			{Env: "ZPOOL_0_SIZE_0=>=14TB"},
+			{Comment: "Refuse to create the pool with fewer than all eight disks."},
+			{Env: "ZPOOL_0_STRICT=true"},
+		},
+	},
+}
+
+// configExampleNames returns the names of the example layouts.
+func configExampleNames() []string {
+	var names []string
+	for _, example := range configExamples {
+		names = append(names, example.Name)
+	}
+	return names
+}
+
+// findConfigExample returns the example with the given name.
+func findConfigExample(name string) (configExample, bool) {
+	for _, example := range configExamples {
+		if example.Name == name {
+			return example, true
+		}
+	}
+	return configExample{}, false
+}
+
+// writeConfigExample writes the example to w in the given format. The common optional lines
+// that repeat settings of the example itself are left out.
+func writeConfigExample(w io.Writer, example configExample, format string) {
+	lines := example.Lines
+	for _, line := range exampleCommonLines {
+		if line.Env != "" && containsExampleEnv(lines, line.Env) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	if format == exampleFormatConfigFile {
+		writeConfigFileExample(w, example, lines)
+		return
+	}
+	indent := ""
+	if format == exampleFormatFile {
+		fmt.Fprintf(w, "# Example %q: %s.\n# Apply with: talosctl patch mc --patch @zpool-creator.yaml\n", example.Name, example.Description)
+		fmt.Fprint(w, "apiVersion: v1alpha1\nkind: ExtensionServiceConfig\nname: zpool-creator\nenvironment:\n")
+		indent = "  "
+	} else {
+		fmt.Fprintf(w, "# Example %q: %s.\n", example.Name, example.Description)
+	}
+	for _, line := range lines {
+		switch {
+		case line.Comment != "":
+			fmt.Fprintf(w, "%s# %s\n", indent, line.Comment)
+		case line.Env != "" && format == exampleFormatFile:
+			fmt.Fprintf(w, "%s- %s\n", indent, yamlListItem(line.Env))
+		case line.Env != "":
+			fmt.Fprintln(w, line.Env)
+		default:
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// writeConfigFileExample writes the example as an ExtensionServiceConfig document that mounts
+// its pools as the default configuration file. Blocks of lines about global settings stay in the
+// environment of the document.
+func writeConfigFileExample(w io.Writer, example configExample, lines []exampleLine) {
+	var content, environment []string
+	pool, list := "", ""
+	for _, block := range exampleBlocks(lines) {
+		if isGlobalExampleBlock(block) {
+			if len(environment) > 0 {
+				environment = append(environment, "")
+			}
+			for _, line := range block {
+				if line.Comment != "" {
+					environment = append(environment, "  # "+line.Comment)
+				} else {
+					environment = append(environment, "  - "+yamlListItem(line.Env))
+				}
+			}
+			continue
+		}
+		if len(content) > 0 {
+			content = append(content, "")
+		}
+		for _, line := range block {
+			if line.Comment != "" {
+				indent := "        "
+				if pool != "" {
+					indent += "  "
+				}
+				content = append(content, indent+"# "+line.Comment)
+				continue
+			}
+			key, value, _ := strings.Cut(line.Env, "=")
+			m := examplePoolVar.FindStringSubmatch(key)
+			if m == nil {
+				environment = append(environment, "  - "+yamlListItem(line.Env))
+				continue
+			}
+			field, items, ok := examplePoolField(m[2], value)
+			if !ok {
+				content = append(content, fmt.Sprintf("          # %s is only available as a variable.", key))
+				continue
+			}
+			prefix := "          "
+			if m[1] != pool {
+				pool, list, prefix = m[1], "", "        - "
+			}
+			if items == nil {
+				content = append(content, prefix+field+": "+examplePoolValue(value))
+				list = ""
+				continue
+			}
+			if field != list {
+				content = append(content, prefix+field+":")
+				list = field
+			}
+			for _, item := range items {
+				content = append(content, "            "+item)
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "# Example %q: %s.\n# Apply with: talosctl patch mc --patch @zpool-creator.yaml\n", example.Name, example.Description)
+	fmt.Fprint(w, "apiVersion: v1alpha1\nkind: ExtensionServiceConfig\nname: zpool-creator\nconfigFiles:\n")
+	fmt.Fprintf(w, "  # The pools as structured data, read from %s.\n", defaultConfigFile)
+	fmt.Fprintf(w, "  - mountPath: %s\n    content: |\n      pools:\n", defaultConfigFile)
+	for _, line := range content {
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
+	}
+	if len(environment) > 0 {
+		fmt.Fprintln(w, "environment:")
+		for _, line := range environment {
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
+// exampleBlocks splits lines into the blocks separated by blank lines.
+func exampleBlocks(lines []exampleLine) [][]exampleLine {
+	var blocks [][]exampleLine
+	var block []exampleLine
+	for _, line := range append(lines, exampleLine{}) {
+		if line.Comment != "" || line.Env != "" {
+			block = append(block, line)
+		} else if len(block) > 0 {
+			blocks = append(blocks, block)
+			block = nil
+		}
+	}
+	return blocks
+}
+
+// isGlobalExampleBlock reports whether a block of lines is only about global settings: it sets
+// no pool variable, but a global one, possibly in a comment as an optional setting.
+func isGlobalExampleBlock(block []exampleLine) bool {
+	global := false
+	for _, line := range block {
+		kv := line.Env
+		if kv == "" {
+			kv = line.Comment
+		}
+		key, _, ok := strings.Cut(kv, "=")
+		switch {
+		case line.Env != "" && examplePoolVar.MatchString(key):
+			return false
+		case ok && isConfigVar(key) && !strings.Contains(key, " "):
+			global = true
+		}
+	}
+	return global
+}
+
+// examplePoolField returns the field of a pool object that the ZPOOL_<n>_<suffix> variable
+// stands for, see flattenPoolJSON, and the list or map entries the variable adds to the field
+// for structured ones, e.g. the fields of the object of an allocation class. ok is false if the
+// variable has no field.
+func examplePoolField(suffix, value string) (field string, items []string, ok bool) {
+	if exampleListVar.MatchString(suffix) {
+		switch {
+		case strings.HasPrefix(suffix, "SIZE_"):
+			return "sizes", []string{"- " + examplePoolValue(value)}, true
+		case strings.HasSuffix(suffix, "_DEV"):
+			return "disks", []string{"- " + examplePoolValue(value)}, true
+		case strings.HasSuffix(suffix, "_MODEL"):
+			return "disks", []string{"- model: " + examplePoolValue(value)}, true
+		}
+		return "", nil, false
+	}
+	if class, key, ok := strings.Cut(suffix, "_"); ok && slices.Contains(vdevClasses, strings.ToLower(class)) {
+		field = strings.ToLower(class)
+		switch key {
+		case "TYPE", "DISKS", "ASHIFT":
+			return field, []string{strings.ToLower(key) + ": " + examplePoolValue(value)}, true
+		case "PARAMS":
+			items = []string{"params:"}
+			for entry := range strings.SplitSeq(value, ",") {
+				name, v, _ := strings.Cut(entry, "=")
+				items = append(items, "  "+strings.TrimSpace(name)+": "+examplePoolValue(strings.TrimSpace(v)))
+			}
+			return field, items, true
+		}
+	}
+	switch suffix {
+	case "DISKS":
+		return "disks", nil, true
+	case "PROPERTIES":
+		for entry := range strings.SplitSeq(value, ",") {
+			name, v, _ := strings.Cut(entry, "=")
+			items = append(items, strings.TrimSpace(name)+": "+examplePoolValue(strings.TrimSpace(v)))
+		}
+		return "properties", items, true
+	case "AFTER", "RECONCILE":
+		for _, entry := range strings.Split(value, ",") {
+			items = append(items, "- "+examplePoolValue(strings.TrimSpace(entry)))
+		}
+		return strings.ToLower(suffix), items, true
+	}
+	for name, prop := range managedPoolProperties {
+		if prop.envSuffix == suffix {
+			return "properties", []string{name + ": " + examplePoolValue(value)}, true
+		}
+	}
+	for field, s := range poolJSONDraidFields {
+		if s == suffix {
+			return "draid", []string{field + ": " + examplePoolValue(value)}, true
+		}
+	}
+	for field, s := range poolJSONFields {
+		if s == suffix {
+			return field, nil, true
+		}
+	}
+	return "", nil, false
+}
+
+// examplePoolValue returns value as a YAML scalar that reads as the same string, number or
+// boolean. Words that YAML 1.1 reads as booleans, e.g. on, are quoted for other parsers.
+func examplePoolValue(value string) string {
+	if examplePlainValue.MatchString(value) && !slices.Contains([]string{"on", "off", "yes", "no", "y", "n"}, strings.ToLower(value)) {
+		return value
+	}
+	return strconv.Quote(value)
+}
+
+// containsExampleEnv reports whether lines set the variable of kv.
+func containsExampleEnv(lines []exampleLine, kv string) bool {
+	key, _, _ := strings.Cut(kv, "=")
+	for _, line := range lines {
+		if k, _, _ := strings.Cut(line.Env, "="); line.Env != "" && k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// yamlListItem quotes kv if YAML would not read it as a plain string, e.g. because of a
+// `: ` or a leading `[`.
+func yamlListItem(kv string) string {
+	if strings.Contains(kv, ": ") || strings.Contains(kv, " #") || strings.ContainsAny(kv[:1], "[{>'\"&*!|%@`") {
+		return "'" + strings.ReplaceAll(kv, "'", "''") + "'"
+	}
+	return kv
+}
+
+// configMain implements the `config` mode. `config example <layout> [file|env|configfile]`
+// writes a commented sample configuration to w, `config schema` the JSON Schema of the
+// configuration file.
+func configMain(w io.Writer, args []string) int {
+	names := configExampleNames()
+	if len(args) == 1 && args[0] == "schema" {
+		if err := writeConfigFileSchema(w); err != nil {
+			slog.Error("Failed to write the schema", "error", err)
+			return 1
+		}
+		return 0
+	}
+	if len(args) < 2 || args[0] != "example" || len(args) > 3 {
+		slog.Error("Usage: config example <layout> [file|env|configfile] | config schema", "layouts", names)
+		return 1
+	}
+	example, ok := findConfigExample(args[1])
+	if !ok {
+		slog.Error("Unknown example layout", "layout", args[1], "layouts", names)
+		return 1
+	}
+	format := exampleFormatFile
+	if len(args) == 3 {
+		format = args[2]
+	}
+	if !slices.Contains(exampleFormats, format) {
+		slog.Error("Unknown example format", "format", format, "formats", exampleFormats)
+		return 1
+	}
+	writeConfigExample(w, example, format)
+	return 0
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TestConfigExamples checks that every example is a valid configuration in both formats.
+func TestConfigExamples(t *testing.T) {
+	for _, example := range configExamples {
+		t.Run(example.Name, func(t *testing.T) {
+			var out strings.Builder
+			if code := configMain(&out, []string{"example", example.Name, exampleFormatEnv}); code != 0 {
+				t.Fatalf("configMain() = %d, want 0", code)
+			}
+			for line := range strings.Lines(out.String()) {
+				line = strings.TrimSpace(line)
+				if line == "" || strings.HasPrefix(line, "#") {
+					continue
+				}
+				key, value, ok := strings.Cut(line, "=")
+				if !ok {
+					t.Fatalf("Unexpected line %q", line)
+				}
+				t.Setenv(key, value)
+			}
+			configs := parsePoolConfigs()
+			if len(configs) != 1 {
+				t.Fatalf("Expected one pool, got %d", len(configs))
+			}
+			if err := validatePoolConfig(configs[0]); err != nil {
+				t.Errorf("Example is not a valid configuration: %v", err)
+			}
+
+			out.Reset()
+			if code := configMain(&out, []string{"example", example.Name}); code != 0 {
+				t.Fatalf("configMain() = %d, want 0", code)
+			}
+			if !strings.Contains(out.String(), "kind: ExtensionServiceConfig\nname: zpool-creator\nenvironment:\n") ||
+				!strings.Contains(out.String(), "\n  - ZPOOL_0_NAME=") {
+				t.Errorf("Unexpected file form:\n%s", out.String())
+			}
+		})
+	}
+
+	for _, args := range [][]string{nil, {"example"}, {"example", "raid10"}, {"example", "mirror", "json"}, {"show", "mirror"}} {
+		if code := configMain(&strings.Builder{}, args); code != 1 {
+			t.Errorf("configMain(%q) = %d, want 1", args, code)
+		}
+	}
+}
+
+// TestConfigExamples_ConfigFile checks that the configuration file of every example document
+// describes the same pool as the variables of the example.
+func TestConfigExamples_ConfigFile(t *testing.T) {
+	for _, example := range configExamples {
+		t.Run(example.Name, func(t *testing.T) {
+			var out strings.Builder
+			if code := configMain(&out, []string{"example", example.Name, exampleFormatEnv}); code != 0 {
+				t.Fatalf("configMain() = %d, want 0", code)
+			}
+			vars := make(map[string]string)
+			for line := range strings.Lines(out.String()) {
+				if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+					key, value, _ := strings.Cut(line, "=")
+					vars[key] = value
+					t.Setenv(key, value)
+				}
+			}
+			want := parsePoolConfigs()
+
+			out.Reset()
+			if code := configMain(&out, []string{"example", example.Name, exampleFormatConfigFile}); code != 0 {
+				t.Fatalf("configMain() = %d, want 0", code)
+			}
+			var doc struct {
+				Kind        string   `yaml:"kind"`
+				Environment []string `yaml:"environment"`
+				ConfigFiles []struct {
+					Content   string `yaml:"content"`
+					MountPath string `yaml:"mountPath"`
+				} `yaml:"configFiles"`
+			}
+			if err := yaml.Unmarshal([]byte(out.String()), &doc); err != nil {
+				t.Fatalf("Invalid document: %v\n%s", err, out.String())
+			}
+			if doc.Kind != "ExtensionServiceConfig" || len(doc.ConfigFiles) != 1 || doc.ConfigFiles[0].MountPath != defaultConfigFile {
+				t.Fatalf("Unexpected document:\n%s", out.String())
+			}
+			source, errs, err := loadConfigFile(writeConfigFile(t, doc.ConfigFiles[0].Content), true)
+			if err != nil || len(errs) > 0 {
+				t.Fatalf("loadConfigFile() = %v, %v for:\n%s", errs, err, doc.ConfigFiles[0].Content)
+			}
+			for key := range vars {
+				os.Unsetenv(key) // Restored by t.Setenv.
+			}
+			for key, value := range source.Vars {
+				t.Setenv(key, value)
+			}
+			got := parsePoolConfigs()
+			if len(got) != 1 || got[0].Name != want[0].Name || got[0].Type != want[0].Type || got[0].Ashift != want[0].Ashift ||
+				len(got[0].Disks)+len(got[0].DiskList) == 0 || !slices.Equal(got[0].SizeFilters, want[0].SizeFilters) ||
+				!maps.Equal(got[0].Properties, want[0].Properties) || got[0].StrictDisks != want[0].StrictDisks ||
+				got[0].Cache != want[0].Cache || !maps.Equal(got[0].CacheParams, want[0].CacheParams) {
+				t.Errorf("parsePoolConfigs() = %+v, want %+v", got, want)
+			}
+			if err := validatePoolConfig(got[0]); err != nil {
+				t.Errorf("Example is not a valid configuration: %v", err)
+			}
+		})
+	}
+}
+
+func TestYAMLListItem(t *testing.T) {
+	tests := map[string]string{
+		"ZPOOL_0_NAME=tank":             "ZPOOL_0_NAME=tank",
+		"ZPOOL_0_COMMENT=rack 4: left":  "'ZPOOL_0_COMMENT=rack 4: left'",
+		"ZPOOL_0_COMMENT=it's # not":    "'ZPOOL_0_COMMENT=it''s # not'",
+		"ZPOOL_0_DISK_0_MODEL=Samsung*": "ZPOOL_0_DISK_0_MODEL=Samsung*",
+	}
+	for kv, want := range tests {
+		if got := yamlListItem(kv); got != want {
+			t.Errorf("yamlListItem(%q) = %q, want %q", kv, got, want)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigFile is read if it exists, see ZPOOL_CONFIG_FILE.
+const defaultConfigFile = "/usr/local/etc/zpool/config.yaml"
+
+// poolVarPattern matches the variables configuring a single pool: ZPOOL_<n>_* and ZPOOL_CONFIG_<n>.
+var poolVarPattern = regexp.MustCompile(`^ZPOOL_(CONFIG_)?[0-9]+(_|$)`)
+
+// configFile is the document of a configuration file in YAML or JSON, see configFileSchema.
+// Pools take the fields of ZPOOL_CONFIG_<n> objects, settings are global variables by their
+// full name, e.g. ZPOOL_COMMAND_TIMEOUT.
+type configFile struct {
+	Settings map[string]any `yaml:"settings" json:"settings"`
+	Pools    []any          `yaml:"pools" json:"pools"`
+	Nodes    []nodeSection  `yaml:"nodes" json:"nodes"` // Per-node sections, see forNode.
+}
+
+// isPoolVar reports whether a variable configures a single pool.
+func isPoolVar(key string) bool {
+	return poolVarPattern.MatchString(key)
+}
+
+// loadConfigFile reads the configuration file at path into a configuration source of its pools
+// and settings, with the errors found in its pools by index. A file that replaces the pools of
+// the environment shadows all their variables, so that leftovers of an indexed pool in the
+// environment never mix with a pool of the file. A missing file yields no source if it is not
+// required.
+func loadConfigFile(path string, required bool) (*configSource, map[int][]error, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read configuration file: %w", err)
+	}
+	doc, err := decodeConfigFile(path, data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid configuration file %s: %w", path, err)
+	}
+
+	doc, node, err := doc.forNode(currentNodeIdentity())
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid configuration file %s: %w", path, err)
+	}
+
+	source := &configSource{Name: path, Precedence: precedenceFile, Vars: make(map[string]string)}
+	if node != nil {
+		// The name shows which section applies in the log of the effective configuration.
+		source.Name = fmt.Sprintf("%s (node %s)", path, node)
+	}
+	for key, value := range doc.Settings {
+		if err := checkSettingName(key); err != nil {
+			return nil, nil, fmt.Errorf("invalid configuration file %s: %w", path, err)
+		}
+		s, err := yamlScalar(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid configuration file %s: setting %s: %w", path, key, err)
+		}
+		source.Vars[key] = s
+	}
+	// The file may raise the limit for its own pools.
+	limitValue, ok := source.Vars["ZPOOL_MAX_POOLS"]
+	if !ok {
+		limitValue = os.Getenv("ZPOOL_MAX_POOLS")
+	}
+	limit, err := parsePoolLimit(limitValue)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid configuration file %s: %w", path, err)
+	}
+	if len(doc.Pools) > limit {
+		return nil, nil, fmt.Errorf("invalid configuration file %s: at most %d pools are supported, see ZPOOL_MAX_POOLS, got %d", path, limit, len(doc.Pools))
+	}
+
+	errs := make(map[int][]error)
+	for n, pool := range doc.Pools {
+		// A pool object is the JSON object of ZPOOL_CONFIG_<n>, written in YAML.
+		value, err := json.Marshal(pool)
+		var vars map[string]string
+		if err == nil {
+			vars, err = flattenPoolJSON(n, string(value))
+		}
+		if err != nil {
+			errs[n] = append(errs[n], fmt.Errorf("invalid pool %d in %s: %w", n, path, err))
+			// The pool still fails under its name, or its position if it has none.
+			name := fmt.Sprintf("pool %d of %s", n, path)
+			if m, ok := pool.(map[string]any); ok {
+				if s, err := yamlScalar(m["name"]); err == nil && s != "" {
+					name = s
+				}
+			}
+			vars = map[string]string{fmt.Sprintf("ZPOOL_%d_NAME", n): name}
+		}
+		for key, value := range vars {
+			source.Vars[key] = value
+		}
+	}
+	if len(doc.Pools) > 0 {
+		source.Shadows = isPoolVar
+	}
+	return source, errs, nil
+}
+
+// checkSettingName checks that a setting of a configuration file is a global variable by its
+// unprefixed name; the file is read after the namespaced variables were applied.
+func checkSettingName(key string) error {
+	if _, prefixed := stripEnvPrefix(key, envPrefix()); !isConfigVar(key) || isPoolVar(key) || prefixed {
+		return fmt.Errorf("setting %q is no global ZPOOL_* or ZFS_* variable, pools are configured under pools", key)
+	}
+	return nil
+}
+
+// isJSONConfigFile reports whether a configuration file is JSON rather than YAML: by its
+// extension, or for other names by its content starting with an object.
+func isJSONConfigFile(path string, data []byte) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return true
+	case ".yaml", ".yml":
+		return false
+	}
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+}
+
+// decodeConfigFile decodes a configuration file as JSON or YAML, see isJSONConfigFile. Unknown
+// fields are an error in both, so that a typo does not go unnoticed.
+func decodeConfigFile(path string, data []byte) (configFile, error) {
+	var doc configFile
+	if isJSONConfigFile(path, data) {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&doc); err != nil {
+			return configFile{}, fmt.Errorf("invalid JSON: %w", err)
+		}
+		if dec.More() {
+			return configFile{}, errors.New("invalid JSON: data after the top-level object")
+		}
+		return doc, nil
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&doc); err != nil && !errors.Is(err, io.EOF) {
+		return configFile{}, err
+	}
+	return doc, nil
+}
+
+// yamlScalar returns a YAML or JSON string, number or boolean as the string a variable would
+// hold.
+func yamlScalar(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case json.Number:
+		return v.String(), nil
+	}
+	return "", fmt.Errorf("must be a string, number or boolean, got %T", value)
+}
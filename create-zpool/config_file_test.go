@@ -0,0 +1,157 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := writeConfigFile(t, `
+settings:
+  ZPOOL_COMMAND_TIMEOUT: 10m
+  ZFS_PARAM_zfs_arc_max: 17179869184
+pools:
+  - name: tank
+    type: mirror
+    ashift: 12
+    disks: [/dev/sda, /dev/sdb]
+    properties:
+      autotrim: "on"
+  - name: data
+    types: raidz1
+`)
+	source, errs, err := loadConfigFile(path, true)
+	if err != nil {
+		t.Fatalf("loadConfigFile() returned an unexpected error: %v", err)
+	}
+	want := map[string]string{
+		"ZPOOL_COMMAND_TIMEOUT": "10m",
+		"ZFS_PARAM_zfs_arc_max": "17179869184",
+		"ZPOOL_0_NAME":          "tank",
+		"ZPOOL_0_TYPE":          "mirror",
+		"ZPOOL_0_ASHIFT":        "12",
+		"ZPOOL_0_DISKS":         `["/dev/sda","/dev/sdb"]`,
+		"ZPOOL_0_AUTOTRIM":      "on",
+		"ZPOOL_1_NAME":          "data",
+	}
+	for key, value := range want {
+		if source.Vars[key] != value {
+			t.Errorf("%s = %q, want %q", key, source.Vars[key], value)
+		}
+	}
+	if len(source.Vars) != len(want) {
+		t.Errorf("Unexpected variables %v", source.Vars)
+	}
+	// The invalid pool fails under its name instead of being dropped.
+	if len(errs[1]) != 1 || len(errs[0]) != 0 {
+		t.Errorf("Expected an error for pool 1 only, got %v", errs)
+	}
+	if source.Precedence != precedenceFile || source.Shadows == nil {
+		t.Errorf("Expected a file source replacing the pools of the environment, got %+v", source)
+	}
+}
+
+func TestLoadConfigFile_JSON(t *testing.T) {
+	const content = `{"settings": {"ZPOOL_BUSY_RETRIES": 5}, "pools": [{"name": "tank", "ashift": 12, "disks": ["/dev/sda", {"model": "Samsung*"}]}]}`
+	dir := t.TempDir()
+	// Detected by the extension, and by the content for other names.
+	for _, name := range []string{"config.json", "config"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		source, errs, err := loadConfigFile(path, true)
+		if err != nil || len(errs) > 0 {
+			t.Fatalf("%s: loadConfigFile() = %v, %v", name, errs, err)
+		}
+		want := map[string]string{
+			"ZPOOL_BUSY_RETRIES": "5",
+			"ZPOOL_0_NAME":       "tank",
+			"ZPOOL_0_ASHIFT":     "12",
+			"ZPOOL_0_DISKS":      `["/dev/sda",{"model":"Samsung*"}]`,
+		}
+		if !maps.Equal(source.Vars, want) {
+			t.Errorf("%s: got %v, want %v", name, source.Vars, want)
+		}
+	}
+
+	path := filepath.Join(dir, "typo.json")
+	if err := os.WriteFile(path, []byte(`{"pool": []}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadConfigFile(path, true); err == nil {
+		t.Error("Expected an error for an unknown field")
+	}
+}
+
+func TestLoadConfigFile_Errors(t *testing.T) {
+	if source, _, err := loadConfigFile(filepath.Join(t.TempDir(), "missing.yaml"), false); source != nil || err != nil {
+		t.Errorf("Expected no source and no error for a missing optional file, got %v, %v", source, err)
+	}
+	if _, _, err := loadConfigFile(filepath.Join(t.TempDir(), "missing.yaml"), true); err == nil {
+		t.Error("Expected an error for a missing required file")
+	}
+	for name, content := range map[string]string{
+		"unknown section":  "pool:\n  - name: tank\n",
+		"pool setting":     "settings:\n  ZPOOL_0_NAME: tank\n",
+		"unrelated":        "settings:\n  PATH: /bin\n",
+		"nested setting":   "settings:\n  ZPOOL_COMMAND_TIMEOUT: [1m]\n",
+		"invalid document": "pools: {\n",
+		"too many pools":   "settings:\n  ZPOOL_MAX_POOLS: 1\npools:\n  - name: tank\n  - name: data\n",
+	} {
+		if _, _, err := loadConfigFile(writeConfigFile(t, content), true); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	// The file may raise the pool limit for its own pools.
+	t.Setenv("ZPOOL_MAX_POOLS", "1")
+	if _, _, err := loadConfigFile(writeConfigFile(t, "settings:\n  ZPOOL_MAX_POOLS: 2\npools:\n  - name: tank\n  - name: data\n"), true); err != nil {
+		t.Errorf("Expected the pool limit of the file to apply, got %v", err)
+	}
+
+	// Settings alone leave the pools of the environment alone.
+	source, _, err := loadConfigFile(writeConfigFile(t, "settings:\n  ZPOOL_RECOVERY: clear\n"), true)
+	if err != nil || source.Shadows != nil {
+		t.Errorf("Expected a source without shadowing, got %+v, %v", source, err)
+	}
+}
+
+func TestMergeConfigSources_Shadows(t *testing.T) {
+	env := configSource{Name: "env", Precedence: precedenceEnv, Vars: map[string]string{
+		"ZPOOL_0_NAME": "old", "ZPOOL_0_DISK_0_DEV": "/dev/sdz", "ZPOOL_1_NAME": "extra", "ZPOOL_RECOVERY": "clear",
+	}}
+	file := configSource{Name: "config.yaml", Precedence: precedenceFile, Shadows: isPoolVar, Vars: map[string]string{"ZPOOL_0_NAME": "tank"}}
+	cfg := mergeConfigSources(env, file)
+	if cfg.Vars["ZPOOL_0_NAME"] != "tank" || cfg.Vars["ZPOOL_RECOVERY"] != "clear" {
+		t.Errorf("Unexpected variables %v", cfg.Vars)
+	}
+	for _, key := range []string{"ZPOOL_0_DISK_0_DEV", "ZPOOL_1_NAME"} {
+		if _, ok := cfg.Vars[key]; ok {
+			t.Errorf("Expected %s to be shadowed", key)
+		}
+	}
+	if len(cfg.Unset) != 2 || len(cfg.Overrides) != 3 {
+		t.Errorf("Expected 2 shadowed variables and 3 overrides, got %v and %+v", cfg.Unset, cfg.Overrides)
+	}
+
+	// Restored by t.Setenv after the test.
+	for _, key := range []string{"ZPOOL_0_NAME", "ZPOOL_0_DISK_0_DEV", "ZPOOL_1_NAME", "ZPOOL_RECOVERY"} {
+		t.Setenv(key, env.Vars[key])
+	}
+	cfg.apply()
+	if _, ok := os.LookupEnv("ZPOOL_1_NAME"); ok {
+		t.Error("Expected the shadowed variable to be removed from the environment")
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"strings"
+)
+
+// Files of the SMBIOS identity of the node.
+var (
+	dmiSerialFile = "/sys/class/dmi/id/product_serial"
+	dmiUUIDFile   = "/sys/class/dmi/id/product_uuid"
+)
+
+// nodeIdentity identifies the node a configuration file is applied to, see nodeSection.
+type nodeIdentity struct {
+	Hostname string
+	Serial   string // SMBIOS system serial number.
+	UUID     string // SMBIOS system UUID, which Talos reports as the UUID of the node.
+}
+
+// currentNodeIdentity returns the identity of the node. ZPOOL_NODE_HOSTNAME, ZPOOL_NODE_SERIAL
+// and ZPOOL_NODE_UUID override it, e.g. to validate the section of another node.
+func currentNodeIdentity() nodeIdentity {
+	id := nodeIdentity{Hostname: nodeName(), Serial: readDMIFile(dmiSerialFile), UUID: strings.ToLower(readDMIFile(dmiUUIDFile))}
+	for key, field := range map[string]*string{"ZPOOL_NODE_HOSTNAME": &id.Hostname, "ZPOOL_NODE_SERIAL": &id.Serial, "ZPOOL_NODE_UUID": &id.UUID} {
+		if value, ok := lookupEnvTrimmed(key); ok {
+			*field = value
+		}
+	}
+	return id
+}
+
+// readDMIFile returns the trimmed content of an SMBIOS identity file, or "" if it cannot be read.
+func readDMIFile(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// nodeSection is the configuration of the nodes a section of a configuration file matches. A
+// section matches a node if all its given patterns match, see path.Match; at least one must be
+// given. Its settings override those at the top of the file, its pools, if given, replace them.
+type nodeSection struct {
+	Hostname string         `yaml:"hostname" json:"hostname"`
+	Serial   string         `yaml:"serial" json:"serial"`
+	UUID     string         `yaml:"uuid" json:"uuid"`
+	Settings map[string]any `yaml:"settings" json:"settings"`
+	Pools    []any          `yaml:"pools" json:"pools"`
+}
+
+// matches reports whether the section matches the node. Malformed patterns are an error.
+func (n nodeSection) matches(id nodeIdentity) (bool, error) {
+	if n.Hostname == "" && n.Serial == "" && n.UUID == "" {
+		return false, errors.New("one of hostname, serial or uuid is required")
+	}
+	for _, p := range []struct{ pattern, value string }{{n.Hostname, id.Hostname}, {n.Serial, id.Serial}, {strings.ToLower(n.UUID), id.UUID}} {
+		if p.pattern == "" {
+			continue
+		}
+		ok, err := path.Match(p.pattern, p.value)
+		if err != nil {
+			return false, fmt.Errorf("invalid pattern %q: %w", p.pattern, err)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// String describes the section by its patterns, e.g. for log output.
+func (n nodeSection) String() string {
+	var parts []string
+	for _, p := range []struct{ key, pattern string }{{"hostname", n.Hostname}, {"serial", n.Serial}, {"uuid", n.UUID}} {
+		if p.pattern != "" {
+			parts = append(parts, p.key+"="+p.pattern)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// forNode returns the document with the first node section that matches id applied, and that
+// section, or the document itself if none matches. Every section is checked, also those of
+// other nodes, so that a mistake in one shows on all nodes.
+func (doc configFile) forNode(id nodeIdentity) (configFile, *nodeSection, error) {
+	var selected *nodeSection
+	for i := range doc.Nodes {
+		node := &doc.Nodes[i]
+		ok, err := node.matches(id)
+		if err != nil {
+			return configFile{}, nil, fmt.Errorf("node %d: %w", i, err)
+		}
+		for key := range node.Settings {
+			if err := checkSettingName(key); err != nil {
+				return configFile{}, nil, fmt.Errorf("node %d: %w", i, err)
+			}
+		}
+		if ok && selected == nil {
+			selected = node
+		}
+	}
+	if selected == nil {
+		return doc, nil, nil
+	}
+	settings := maps.Clone(doc.Settings)
+	if settings == nil {
+		settings = make(map[string]any)
+	}
+	maps.Copy(settings, selected.Settings)
+	doc.Settings = settings
+	if selected.Pools != nil {
+		doc.Pools = selected.Pools
+	}
+	return doc, selected, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const nodesConfig = `
+settings:
+  ZPOOL_COMMAND_TIMEOUT: 10m
+  ZPOOL_RECOVERY: clear
+pools:
+  - name: tank
+    disks: [/dev/sda]
+nodes:
+  - hostname: storage-*
+    settings:
+      ZPOOL_COMMAND_TIMEOUT: 30m
+    pools:
+      - name: data
+        type: mirror
+        disks: [/dev/sdb, /dev/sdc]
+  - serial: ABC123
+    hostname: other
+    pools: []
+  - uuid: 4C4C4544-0000-1010-8000-B4C04F000000
+    settings:
+      ZPOOL_RECOVERY: none
+`
+
+func TestLoadConfigFile_Nodes(t *testing.T) {
+	path := writeConfigFile(t, nodesConfig)
+	tests := []struct {
+		name     string
+		env      map[string]string
+		source   string
+		timeout  string
+		recovery string
+		pool     string
+	}{
+		{"default", map[string]string{"ZPOOL_NODE_HOSTNAME": "compute-1"}, path, "10m", "clear", "tank"},
+		{"hostname", map[string]string{"ZPOOL_NODE_HOSTNAME": "storage-1"}, path + " (node hostname=storage-*)", "30m", "clear", "data"},
+		// Both patterns of a section must match.
+		{"partial", map[string]string{"ZPOOL_NODE_HOSTNAME": "compute-1", "ZPOOL_NODE_SERIAL": "ABC123"}, path, "10m", "clear", "tank"},
+		{"uuid", map[string]string{"ZPOOL_NODE_HOSTNAME": "compute-1", "ZPOOL_NODE_UUID": "4c4c4544-0000-1010-8000-b4c04f000000"},
+			path + " (node uuid=4C4C4544-0000-1010-8000-B4C04F000000)", "10m", "none", "tank"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			source, errs, err := loadConfigFile(path, true)
+			if err != nil || len(errs) > 0 {
+				t.Fatalf("loadConfigFile() = %v, %v", errs, err)
+			}
+			if source.Name != tt.source || source.Vars["ZPOOL_COMMAND_TIMEOUT"] != tt.timeout || source.Vars["ZPOOL_RECOVERY"] != tt.recovery ||
+				source.Vars["ZPOOL_0_NAME"] != tt.pool {
+				t.Errorf("Unexpected source %q: %v", source.Name, source.Vars)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFile_NodeErrors(t *testing.T) {
+	for name, content := range map[string]string{
+		"no pattern":       "nodes:\n  - settings:\n      ZPOOL_RECOVERY: clear\n",
+		"bad pattern":      "nodes:\n  - hostname: \"storage-[\"\n",
+		"bad node setting": "nodes:\n  - hostname: nomatch\n    settings:\n      ZPOOL_0_NAME: tank\n",
+	} {
+		if _, _, err := loadConfigFile(writeConfigFile(t, content), true); err == nil || !strings.Contains(err.Error(), "node 0") {
+			t.Errorf("%s: expected an error about node 0, got %v", name, err)
+		}
+	}
+}
+
+func TestCurrentNodeIdentity(t *testing.T) {
+	dir := t.TempDir()
+	oldSerial, oldUUID := dmiSerialFile, dmiUUIDFile
+	dmiSerialFile, dmiUUIDFile = filepath.Join(dir, "product_serial"), filepath.Join(dir, "product_uuid")
+	t.Cleanup(func() { dmiSerialFile, dmiUUIDFile = oldSerial, oldUUID })
+	if err := os.WriteFile(dmiSerialFile, []byte("ABC123\n"), 0o400); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dmiUUIDFile, []byte("4C4C4544-0000\n"), 0o400); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ZPOOL_NODE_HOSTNAME", "storage-1")
+
+	id := currentNodeIdentity()
+	if id != (nodeIdentity{Hostname: "storage-1", Serial: "ABC123", UUID: "4c4c4544-0000"}) {
+		t.Errorf("currentNodeIdentity() = %+v", id)
+	}
+}
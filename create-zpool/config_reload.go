@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"log/slog"
+	"os"
+	"syscall"
+)
+
+// startupEnviron is the environment the process was started with, before the configuration
+// sources were applied to it, so that a reloaded run merges them afresh, see reexec.
+var startupEnviron = os.Environ()
+
+// configReloader restarts watch mode with a fresh run when the configuration file changes, so
+// that a new pool definition is picked up without a reboot of the node.
+type configReloader struct {
+	path        string
+	required    bool            // The file was set with ZPOOL_CONFIG_FILE, see loadConfigFile.
+	fingerprint [32]byte        // Of the file the running configuration was read from.
+	events      <-chan struct{} // Changes in the directory of the file, nil if it cannot be watched.
+	exec        func() error    // Replaces the process with a fresh run, see reexec.
+}
+
+// newConfigReloader returns a reloader for the configuration file at path, with the given
+// fingerprint of the file that was read at startup, see fileFingerprint. The file is watched
+// with inotify until ctx is done; where that fails, it is compared on every watch interval.
+func newConfigReloader(ctx context.Context, path string, required bool, fingerprint [32]byte) *configReloader {
+	r := &configReloader{path: path, required: required, fingerprint: fingerprint, exec: reexec}
+	events, err := watchFile(ctx, path)
+	if err != nil {
+		slog.Warn("Cannot watch the configuration file, comparing it on every watch interval instead", "file", path, "error", err)
+	}
+	r.events = events
+	return r
+}
+
+// changes returns the channel of possible changes of the file. It is nil for a nil reloader,
+// which never fires in a select.
+func (r *configReloader) changes() <-chan struct{} {
+	if r == nil {
+		return nil
+	}
+	return r.events
+}
+
+// changed reports whether the content of the file differs from that of the running
+// configuration. A nil reloader never changes.
+func (r *configReloader) changed() bool {
+	return r != nil && fileFingerprint(r.path) != r.fingerprint
+}
+
+// reload restarts the run with the changed file. It only returns if the file is invalid, which
+// keeps the running configuration and is reported once per change, or if the restart failed.
+func (r *configReloader) reload() {
+	r.fingerprint = fileFingerprint(r.path)
+	if _, _, err := loadConfigFile(r.path, r.required); err != nil {
+		slog.Error("The configuration file changed, but is invalid; keeping the running configuration", "file", r.path, "error", err)
+		return
+	}
+	slog.Info("The configuration file changed, restarting the run to apply it", "file", r.path)
+	if err := r.exec(); err != nil {
+		slog.Error("Failed to restart the run, the changed configuration applies after the next service restart", "error", err)
+	}
+}
+
+// fileFingerprint returns the SHA-256 of the content of the file at path, or zero if it cannot
+// be read, e.g. because it does not exist.
+func fileFingerprint(path string) [32]byte {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return [32]byte{}
+	}
+	return sha256.Sum256(data)
+}
+
+// reexec replaces the process with a fresh run of the same binary, arguments and startup
+// environment. The instance lock is released by the exec, since its file is closed on exec,
+// and taken again by the new run.
+func reexec() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(exe, os.Args, startupEnviron)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// watchFile is only supported on Linux.
+func watchFile(ctx context.Context, path string) (<-chan struct{}, error) {
+	return nil, errors.New("watching files is only supported on linux")
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConfigReloader(t *testing.T) {
+	path := writeConfigFile(t, "pools:\n  - name: tank\n")
+	var execs int
+	r := &configReloader{path: path, required: true, fingerprint: fileFingerprint(path), exec: func() error {
+		execs++
+		return errors.New("exec failed")
+	}}
+	if r.changed() {
+		t.Error("Unchanged file reported as changed")
+	}
+
+	// An invalid file keeps the running configuration and is reported once.
+	if err := os.WriteFile(path, []byte("pools: [\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if !r.changed() {
+		t.Fatal("Changed file not reported")
+	}
+	r.reload()
+	if execs != 0 || r.changed() {
+		t.Errorf("Expected no restart for an invalid file, got %d", execs)
+	}
+
+	if err := os.WriteFile(path, []byte("pools:\n  - name: data\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r.reload()
+	if execs != 1 {
+		t.Errorf("Expected a restart, got %d", execs)
+	}
+
+	var nilReloader *configReloader
+	if nilReloader.changed() || nilReloader.changes() != nil {
+		t.Error("A nil reloader must never change")
+	}
+}
+
+func TestWatchMain_Reload(t *testing.T) {
+	path := writeConfigFile(t, "pools:\n  - name: tank\n")
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	var execs atomic.Int32
+	reloader := newConfigReloader(ctx, path, true, fileFingerprint(path))
+	if reloader.events == nil {
+		t.Skip("Watching files is not supported")
+	}
+	reloader.exec = func() error {
+		execs.Add(1)
+		cancel()
+		return nil
+	}
+	mockProvider := &mockZFSProvider{
+		GetAllPoolStatusFunc: func(ctx context.Context, zpoolPath string) ([]byte, error) {
+			return []byte(testPoolStatusJSON), nil
+		},
+	}
+	time.AfterFunc(20*time.Millisecond, func() {
+		if err := os.WriteFile(path, []byte("pools:\n  - name: data\n"), 0o600); err != nil {
+			t.Error(err)
+		}
+	})
+	// The interval is far too long to notice the change by comparing.
+	if code := watchMain(ctx, mockProvider, "/fake/zpool", t.TempDir(), []string{"tank"}, time.Hour, newPoolMonitor(monitorSettings{}), reloader); code != 0 {
+		t.Errorf("watchMain() = %d, want 0", code)
+	}
+	if execs.Load() != 1 {
+		t.Errorf("Expected one restart, got %d", execs.Load())
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"maps"
+	"slices"
+)
+
+// poolSchemaTypes are the JSON types of the scalar fields of a pool object, see poolJSONFields.
+// The loader also takes numbers and booleans as strings, the schema describes the canonical form.
+var poolSchemaTypes = map[string]string{
+	"name":                 "string",
+	"enabled":              "boolean",
+	"priority":             "integer",
+	"type":                 "string",
+	"ashift":               "integer",
+	"mountpoint":           "string",
+	"canmount":             "string",
+	"mount_uid":            "integer",
+	"mount_gid":            "integer",
+	"mount_mode":           "string",
+	"dedup":                "string",
+	"dedup_ack":            "boolean",
+	"special_small_blocks": "string",
+	"recordsize":           "string",
+	"upgrade":              "boolean",
+	"import":               "boolean",
+	"adopt_mountpoint":     "boolean",
+	"staged":               "boolean",
+	"swap_size":            "string",
+	"initialize":           "boolean",
+	"initialize_wait":      "boolean",
+	"erase":                "string",
+	"trim":                 "boolean",
+	"strict":               "boolean",
+	"mixed_vdevs":          "boolean",
+	"strict_disks":         "boolean",
+	"wait_for_disks":       "string",
+	"create_timeout":       "string",
+	"import_timeout":       "string",
+}
+
+// configFileSchema returns the JSON Schema of the configuration file, see loadConfigFile, for
+// tooling that generates or checks configuration files.
+func configFileSchema() map[string]any {
+	scalar := map[string]any{"type": []string{"string", "number", "boolean"}}
+	stringList := map[string]any{"type": "array", "items": map[string]any{"type": "string"}}
+
+	disks := map[string]any{
+		"description": "A compact disk list, or an array of device paths and objects with exactly one of dev or model.",
+		"oneOf": []any{
+			map[string]any{"type": "string"},
+			map[string]any{"type": "array", "items": map[string]any{"oneOf": []any{
+				map[string]any{"type": "string"},
+				map[string]any{"type": "object", "additionalProperties": false, "minProperties": 1, "maxProperties": 1,
+					"properties": map[string]any{"dev": map[string]any{"type": "string"}, "model": map[string]any{"type": "string"}}},
+			}}},
+		},
+	}
+	count := map[string]any{"type": "integer", "minimum": 0}
+	draid := map[string]any{
+		"description":          "The layout of a dRAID type without one, e.g. draid2 with data 4 and spares 1 for draid2:4d:1s.",
+		"type":                 "object",
+		"additionalProperties": false,
+		"properties":           map[string]any{"data": count, "children": count, "spares": count},
+	}
+	ashift := map[string]any{"description": "The ashift of the vdev if it differs from the pool's.", "type": "integer"}
+	vdev := map[string]any{"type": "object", "additionalProperties": false, "required": []string{"disks"},
+		"properties": map[string]any{"type": map[string]any{"type": "string"}, "disks": disks, "ashift": ashift}}
+	cacheVdev := map[string]any{"type": "object", "additionalProperties": false, "required": []string{"disks"},
+		"properties": map[string]any{"disks": disks, "ashift": ashift,
+			"params": map[string]any{"description": "L2ARC module parameters, e.g. l2arc_write_max.", "type": "object", "additionalProperties": scalar,
+				"propertyNames": map[string]any{"pattern": "^" + cacheParamPrefix + "[a-z0-9_]+$"}}}}
+	dataVdev := map[string]any{"type": "object", "additionalProperties": false, "required": []string{"disks"},
+		"properties": map[string]any{"type": map[string]any{"type": "string"}, "disks": disks, "draid": draid, "ashift": ashift}}
+	fields := map[string]any{
+		"disks": disks,
+		"draid": draid,
+		"vdevs": map[string]any{
+			"description": "The data vdevs of a pool with several of them, instead of type and disks.",
+			"type":        "array",
+			"items":       dataVdev,
+		},
+		"log":       vdev,
+		"special":   vdev,
+		"cache":     cacheVdev,
+		"after":     stringList,
+		"reconcile": stringList,
+		"sizes":     stringList,
+		"properties": map[string]any{"type": "object", "additionalProperties": scalar,
+			"propertyNames": map[string]any{"pattern": poolPropertyPattern.String()},
+			"properties":    schemaProperties(slices.Collect(maps.Keys(managedPoolProperties)), scalar)},
+		"zvols": map[string]any{"type": "array", "items": map[string]any{
+			"type": "object", "additionalProperties": false, "required": []string{"name"},
+			"properties": map[string]any{
+				"name":       map[string]any{"type": "string"},
+				"size":       map[string]any{"type": "string"},
+				"preset":     map[string]any{"type": "string"},
+				"properties": map[string]any{"type": "object", "additionalProperties": scalar},
+			},
+		}},
+	}
+	for field, typ := range poolSchemaTypes {
+		fields[field] = map[string]any{"type": typ}
+	}
+
+	settings := map[string]any{
+		"description":          "Global variables by their full name, e.g. ZPOOL_COMMAND_TIMEOUT.",
+		"type":                 "object",
+		"propertyNames":        map[string]any{"pattern": "^(ZPOOL_|ZFS_|ASHIFT$|ASHIFT_)", "not": map[string]any{"pattern": poolVarPattern.String()}},
+		"additionalProperties": scalar,
+	}
+	pools := map[string]any{
+		"description": "The pools, with the fields of ZPOOL_CONFIG_<n> objects.",
+		"type":        "array",
+		"maxItems":    maxPoolLimit, // ZPOOL_MAX_POOLS may be lower.
+		"items":       map[string]any{"type": "object", "additionalProperties": false, "required": []string{"name"}, "properties": fields},
+	}
+	pattern := map[string]any{"type": "string", "minLength": 1}
+	nodes := map[string]any{
+		"description": "Sections for the nodes whose hostname, SMBIOS serial and UUID match the given glob patterns; the first matching one applies.",
+		"type":        "array",
+		"items": map[string]any{
+			"type": "object", "additionalProperties": false,
+			"anyOf":      []any{map[string]any{"required": []string{"hostname"}}, map[string]any{"required": []string{"serial"}}, map[string]any{"required": []string{"uuid"}}},
+			"properties": map[string]any{"hostname": pattern, "serial": pattern, "uuid": pattern, "settings": settings, "pools": pools},
+		},
+	}
+
+	return map[string]any{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"title":                "create-zpool configuration file",
+		"type":                 "object",
+		"additionalProperties": false,
+		"properties":           map[string]any{"settings": settings, "pools": pools, "nodes": nodes},
+	}
+}
+
+// schemaProperties returns the same schema for each of names.
+func schemaProperties(names []string, schema map[string]any) map[string]any {
+	props := make(map[string]any)
+	for _, name := range names {
+		props[name] = schema
+	}
+	return props
+}
+
+// writeConfigFileSchema writes the JSON Schema of the configuration file to w.
+func writeConfigFileSchema(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(configFileSchema())
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// TestConfigFileSchema checks that the schema covers every field of a pool object.
+func TestConfigFileSchema(t *testing.T) {
+	for field := range poolJSONFields {
+		if poolSchemaTypes[field] == "" {
+			t.Errorf("Field %q has no type in the schema", field)
+		}
+	}
+	for field := range poolSchemaTypes {
+		if _, ok := poolJSONFields[field]; !ok {
+			t.Errorf("Field %q of the schema is no pool field", field)
+		}
+	}
+
+	var out strings.Builder
+	if code := configMain(&out, []string{"schema"}); code != 0 {
+		t.Fatalf("configMain() = %d, want 0", code)
+	}
+	var schema struct {
+		Properties struct {
+			Pools struct {
+				Items struct {
+					Properties map[string]json.RawMessage `json:"properties"`
+				} `json:"items"`
+			} `json:"pools"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal([]byte(out.String()), &schema); err != nil {
+		t.Fatalf("Invalid schema: %v", err)
+	}
+	for _, field := range []string{"name", "disks", "properties", "zvols", "wait_for_disks"} {
+		if _, ok := schema.Properties.Pools.Items.Properties[field]; !ok {
+			t.Errorf("Schema lacks pool field %q", field)
+		}
+	}
+}
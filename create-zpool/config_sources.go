@@ -0,0 +1,159 @@
+package main
+
+import (
+	"cmp"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Precedence of the configuration sources, lowest first. Variables are merged one by one: each
+// variable takes the value of the source with the highest precedence that sets it, and variables
+// set by no source keep their built-in defaults. Sources of equal precedence are applied in the
+// order they are given, so the last one wins.
+const (
+	precedencePoolJSON = 1 // Whole pool configurations in ZPOOL_CONFIG_<n>, see poolJSONSources.
+	precedenceCmdline  = 2 // talos.zpool.* parameters of the kernel command line, see cmdlineConfigSource.
+	precedenceEnv      = 3 // The environment of the service, including the namespaced variables.
+	precedenceFile     = 4 // Configuration files.
+)
+
+// redactedValue replaces the values of secret variables in log output.
+const redactedValue = "<redacted>"
+
+// configSource is a set of configuration variables from one place.
+type configSource struct {
+	Name       string // "env", or e.g. the path of a configuration file.
+	Precedence int
+	Vars       map[string]string
+	// Shadows matches the variables of lower precedence the source replaces as a whole, also
+	// where it does not set them, e.g. the pools of the environment for a configuration file
+	// with pools. Nil shadows nothing.
+	Shadows func(key string) bool
+}
+
+// configOverride is a variable of a source that was overridden by a source of higher precedence
+// with a different value, or shadowed by one, with an empty Value.
+type configOverride struct {
+	Variable              string
+	Source, IgnoredSource string // Names of the sources.
+	Value, IgnoredValue   string
+}
+
+// effectiveConfig is the result of merging all configuration sources.
+type effectiveConfig struct {
+	Vars      map[string]string
+	Origins   map[string]string // Name of the source each variable comes from.
+	Sources   []string          // Names of the merged sources, lowest precedence first.
+	Overrides []configOverride
+	Unset     []string // Shadowed variables to remove from the environment.
+}
+
+// isConfigVar reports whether an environment variable of the given name configures this tool,
+// including the namespaced variables, e.g. TALOS_ZPOOL_0_NAME, see applyEnvPrefix.
+func isConfigVar(key string) bool {
+	if target, ok := stripEnvPrefix(key, envPrefix()); ok {
+		key = target
+	}
+	return strings.HasPrefix(key, "ZPOOL_") || strings.HasPrefix(key, "ZFS_") || key == "ASHIFT" || strings.HasPrefix(key, "ASHIFT_")
+}
+
+// isSecretVar reports whether the value of a variable must not be logged.
+func isSecretVar(key string) bool {
+	for _, suffix := range []string{"_PASSWORD", "_AUTHORIZATION", "_TOKEN", "_SECRET"} {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactedEnvValue returns value for logging: secrets are redacted.
+func redactedEnvValue(key, value string) string {
+	if isSecretVar(key) && value != "" {
+		return redactedValue
+	}
+	return value
+}
+
+// envConfigSource returns the configuration variables of the environment.
+func envConfigSource() configSource {
+	vars := make(map[string]string)
+	for _, kv := range os.Environ() {
+		key, value, _ := strings.Cut(kv, "=")
+		// Namespaced variables are part of the configuration through the variables they set.
+		if _, prefixed := stripEnvPrefix(key, envPrefix()); isConfigVar(key) && !prefixed {
+			vars[key] = value
+		}
+	}
+	return configSource{Name: "env", Precedence: precedenceEnv, Vars: vars}
+}
+
+// mergeConfigSources merges sources into the effective configuration, see precedenceEnv.
+func mergeConfigSources(sources ...configSource) effectiveConfig {
+	sources = slices.Clone(sources)
+	slices.SortStableFunc(sources, func(a, b configSource) int { return cmp.Compare(a.Precedence, b.Precedence) })
+
+	cfg := effectiveConfig{Vars: make(map[string]string), Origins: make(map[string]string)}
+	for _, source := range sources {
+		cfg.Sources = append(cfg.Sources, source.Name)
+		if source.Shadows != nil {
+			for _, key := range slices.Sorted(maps.Keys(cfg.Vars)) {
+				if _, ok := source.Vars[key]; ok || !source.Shadows(key) {
+					continue
+				}
+				cfg.Overrides = append(cfg.Overrides, configOverride{Variable: key, Source: source.Name, IgnoredSource: cfg.Origins[key], IgnoredValue: cfg.Vars[key]})
+				cfg.Unset = append(cfg.Unset, key)
+				delete(cfg.Vars, key)
+				delete(cfg.Origins, key)
+			}
+		}
+		for _, key := range slices.Sorted(maps.Keys(source.Vars)) {
+			value := source.Vars[key]
+			if old, ok := cfg.Vars[key]; ok && old != value {
+				cfg.Overrides = append(cfg.Overrides, configOverride{Variable: key, Source: source.Name, IgnoredSource: cfg.Origins[key], Value: value, IgnoredValue: old})
+			}
+			cfg.Vars[key] = value
+			cfg.Origins[key] = source.Name
+		}
+	}
+	return cfg
+}
+
+// apply sets the effective configuration in the environment, which the rest of the tool reads.
+func (c effectiveConfig) apply() {
+	for _, key := range c.Unset {
+		if _, ok := c.Vars[key]; !ok {
+			os.Unsetenv(key)
+		}
+	}
+	for key, value := range c.Vars {
+		if old, ok := os.LookupEnv(key); !ok || old != value {
+			os.Setenv(key, value)
+		}
+	}
+}
+
+// log logs the precedence of the sources, the variables that were overridden, and the effective
+// configuration grouped by source, with secrets redacted.
+func (c effectiveConfig) log() {
+	for _, o := range c.Overrides {
+		slog.Info("Variable overridden by a source with higher precedence", "variable", o.Variable,
+			"source", o.Source, "value", redactedEnvValue(o.Variable, o.Value), "ignored_source", o.IgnoredSource, "ignored_value", redactedEnvValue(o.Variable, o.IgnoredValue))
+	}
+	args := []any{"precedence", strings.Join(append([]string{"defaults"}, c.Sources...), " < ")}
+	for _, source := range c.Sources {
+		var attrs []any
+		for _, key := range slices.Sorted(maps.Keys(c.Vars)) {
+			if c.Origins[key] == source {
+				attrs = append(attrs, slog.String(key, redactedEnvValue(key, c.Vars[key])))
+			}
+		}
+		if len(attrs) > 0 {
+			args = append(args, slog.Group(source, attrs...))
+		}
+	}
+	slog.Info("Effective configuration", args...)
+}
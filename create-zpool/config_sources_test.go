@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestMergeConfigSources(t *testing.T) {
+	env := configSource{Name: "env", Precedence: precedenceEnv, Vars: map[string]string{
+		"ZPOOL_0_NAME": "tank", "ZPOOL_0_TYPE": "mirror", "ZPOOL_SMTP_PASSWORD": "hunter2",
+	}}
+	file := configSource{Name: "/etc/zpool.yaml", Precedence: precedenceFile, Vars: map[string]string{
+		"ZPOOL_0_TYPE": "raidz1", "ZPOOL_0_NAME": "tank", "ZPOOL_SMTP_PASSWORD": "secret",
+	}}
+
+	// The order of the arguments does not matter, only the precedence.
+	cfg := mergeConfigSources(file, env)
+
+	want := map[string][2]string{
+		"ZPOOL_0_NAME":        {"tank", "/etc/zpool.yaml"},
+		"ZPOOL_0_TYPE":        {"raidz1", "/etc/zpool.yaml"},
+		"ZPOOL_SMTP_PASSWORD": {"secret", "/etc/zpool.yaml"},
+	}
+	for key, w := range want {
+		if cfg.Vars[key] != w[0] || cfg.Origins[key] != w[1] {
+			t.Errorf("%s = %q from %q, want %q from %q", key, cfg.Vars[key], cfg.Origins[key], w[0], w[1])
+		}
+	}
+	if got := strings.Join(cfg.Sources, ","); got != "env,/etc/zpool.yaml" {
+		t.Errorf("Sources = %s, want env first", got)
+	}
+	// ZPOOL_0_NAME has the same value in both sources and is no override.
+	if len(cfg.Overrides) != 2 || cfg.Overrides[0].Variable != "ZPOOL_0_TYPE" || cfg.Overrides[0].IgnoredValue != "mirror" {
+		t.Errorf("Unexpected overrides %+v", cfg.Overrides)
+	}
+}
+
+func TestEffectiveConfigLog_RedactsSecrets(t *testing.T) {
+	var buf bytes.Buffer
+	old := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(old) })
+
+	mergeConfigSources(
+		configSource{Name: "env", Precedence: precedenceEnv, Vars: map[string]string{"ZPOOL_0_NAME": "tank", "ZPOOL_WEBHOOK_AUTHORIZATION": "Bearer abc"}},
+		configSource{Name: "file", Precedence: precedenceFile, Vars: map[string]string{"ZPOOL_WEBHOOK_AUTHORIZATION": "Bearer xyz"}},
+	).log()
+
+	out := buf.String()
+	if strings.Contains(out, "abc") || strings.Contains(out, "xyz") {
+		t.Errorf("Expected secrets to be redacted, got:\n%s", out)
+	}
+	for _, want := range []string{`precedence="defaults < env < file"`, "env.ZPOOL_0_NAME=tank", "file.ZPOOL_WEBHOOK_AUTHORIZATION=<redacted>"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in the log, got:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+)
+
+// dryRunCreateArgs returns the arguments of `zpool create` for a dry run with `-n`, which
+// validates the layout, the properties and the devices without creating the pool.
+func dryRunCreateArgs(args []string) []string {
+	return slices.Concat(args[:1], []string{"-n"}, args[1:])
+}
+
+// validateCreate runs the arguments of `zpool create` through `zpool create -n` before anything
+// is done to the disks, so that layouts and properties zpool rejects fail the pool with zpool's
+// own explanation instead of after an erase. Rejections that can only come from the
+// configuration are configuration errors. Failures the real create gets past or retries on its
+// own, like a busy device, existing data on disks that are erased first, or a timeout, are
+// only logged.
+func (s *runState) validateCreate(ctx context.Context, provider zfsProvider, zpoolPath string, config poolConfig, args []string) error {
+	if !s.createDryRun {
+		return nil
+	}
+	createCtx, cancel := withCommandTimeout(ctx, config.CreateTimeout)
+	output, err := provider.DryRunCreatePool(createCtx, zpoolPath, args)
+	cancel()
+	if err == nil {
+		slog.Info("Dry run of zpool create accepted the pool", "pool", config.Name, "layout", string(output))
+		return nil
+	}
+	err = newZpoolCommandError("create -n", err, output)
+	switch {
+	case errors.Is(err, errCommandTimeout), errors.Is(err, errDeviceBusy),
+		config.Erase != eraseNone && (errors.Is(err, errDeviceInUse) || errors.Is(err, errForeignPool)):
+		slog.Warn("Dry run of zpool create failed, creating the pool anyway", "pool", config.Name, "error", err)
+		return nil
+	case errors.Is(err, errDeviceInUse), errors.Is(err, errForeignPool), errors.Is(err, errDeviceMissing):
+		// The state of the disks may change, so a later run may succeed.
+		return fmt.Errorf("dry run of zpool create rejected the pool: %w", err)
+	}
+	return configError{fmt.Errorf("dry run of zpool create rejected the pool: %w", err)}
+}
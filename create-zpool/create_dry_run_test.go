@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestDryRunCreateArgs(t *testing.T) {
+	got := dryRunCreateArgs([]string{"create", "-m", "/var/mnt/tank", "tank", "mirror", "/dev/sda", "/dev/sdb"})
+	want := []string{"create", "-n", "-m", "/var/mnt/tank", "tank", "mirror", "/dev/sda", "/dev/sdb"}
+	if !slices.Equal(got, want) {
+		t.Errorf("dryRunCreateArgs() = %v, want %v", got, want)
+	}
+}
+
+func TestCreatePool_DryRun(t *testing.T) {
+	testCases := []struct {
+		name       string
+		output     string
+		erase      string
+		wantErr    bool
+		wantConfig bool
+		wantCreate bool
+	}{
+		{name: "accepted", wantCreate: true},
+		{name: "invalid property", output: "property 'autotrim' is not a valid pool property", wantErr: true, wantConfig: true},
+		{name: "mismatched layout", output: "invalid vdev specification\nmismatched replication level", wantErr: true, wantConfig: true},
+		{name: "existing data", output: "/dev/sdb is in use and contains a ext4 filesystem", wantErr: true},
+		{name: "existing data erased first", output: "/dev/sdb is in use and contains a ext4 filesystem", erase: eraseDiscard, wantCreate: true},
+		{name: "busy device", output: "cannot open '/dev/sdb': Device or resource busy", wantCreate: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var dryRunArgs []string
+			created := false
+			provider := &mockZFSProvider{
+				DryRunCreatePoolFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+					dryRunArgs = args
+					if tc.output != "" {
+						return []byte(tc.output), errors.New("exit status 1")
+					}
+					return []byte("would create 'tank' with the following layout:"), nil
+				},
+				CreatePoolFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+					if !slices.Equal(args, dryRunArgs) {
+						t.Errorf("Expected the dry run with the arguments of the create, got %v and %v", dryRunArgs, args)
+					}
+					created = true
+					return nil, nil
+				},
+			}
+			config := poolConfig{Name: "tank", Type: "mirror", Disks: []diskSpec{{Dev: "/dev/sda"}, {Dev: "/dev/sdb"}}, Ashift: "12", Erase: tc.erase}
+			state := newRunState(nil)
+			state.createDryRun = true
+
+			err := createPool(t.Context(), provider, "/fake/zpool", config, state)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("createPool() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if errors.As(err, new(configError)) != tc.wantConfig {
+				t.Errorf("Expected a configuration error: %v, got %v", tc.wantConfig, err)
+			}
+			if created != tc.wantCreate {
+				t.Errorf("Expected the pool to be created: %v, got %v", tc.wantCreate, created)
+			}
+			if err != nil && len(state.usedDisks) != 0 {
+				t.Errorf("Expected the disks of a rejected pool to be released, got %v", state.usedDisks)
+			}
+		})
+	}
+}
+
+func TestCreatePool_DryRunDisabled(t *testing.T) {
+	provider := &mockZFSProvider{
+		DryRunCreatePoolFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+			t.Fatal("DryRunCreatePool must not be called when disabled")
+			return nil, nil
+		},
+	}
+	config := poolConfig{Name: "tank", Type: "mirror", Disks: []diskSpec{{Dev: "/dev/sda"}, {Dev: "/dev/sdb"}}, Ashift: "12"}
+	if err := createPool(t.Context(), provider, "/fake/zpool", config, newRunState(nil)); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+}
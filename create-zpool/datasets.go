@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// datasetInfo describes a filesystem dataset as listed by `zfs list`.
+type datasetInfo struct {
+	Name       string
+	Mountpoint string
+	CanMount   string
+	Mounted    bool
+	KeyStatus  string // "available", "unavailable" or "-" for unencrypted datasets.
+}
+
+// parseDatasetList parses the output of `zfs list -H -o name,mountpoint,canmount,mounted,keystatus`.
+func parseDatasetList(output string) []datasetInfo {
+	var datasets []datasetInfo
+	for line := range strings.SplitSeq(output, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 5 {
+			continue
+		}
+		datasets = append(datasets, datasetInfo{
+			Name:       fields[0],
+			Mountpoint: fields[1],
+			CanMount:   fields[2],
+			Mounted:    fields[3] == "yes",
+			KeyStatus:  fields[4],
+		})
+	}
+	return datasets
+}
+
+// automounted reports whether ZFS mounts the dataset at its mountpoint on its own.
+func (d datasetInfo) automounted() bool {
+	return d.CanMount == "on" && filepath.IsAbs(d.Mountpoint)
+}
+
+// mountPoolDatasets makes sure that every dataset of a pool that should be mounted actually is.
+// Missing keys of encrypted datasets are loaded first, then unmounted datasets are mounted
+// parents first, and finally the mount table is checked, since a pool imported with -N or a
+// failed key load leaves workloads with empty directories. In a dry run the mount table is
+// not checked.
+func mountPoolDatasets(ctx context.Context, provider zfsProvider, zfsPath, pool string, dryRun bool) error {
+	datasets, err := provider.ListDatasets(ctx, zfsPath, pool)
+	if err != nil {
+		return fmt.Errorf("failed to list datasets: %w", err)
+	}
+
+	var errs []error
+	if slices.ContainsFunc(datasets, func(d datasetInfo) bool { return d.KeyStatus == "unavailable" }) {
+		slog.Info("Loading encryption keys", "pool", pool)
+		if output, err := provider.LoadKeys(ctx, zfsPath, pool); err != nil {
+			errs = append(errs, fmt.Errorf("failed to load encryption keys: %w, output: %s", err, string(output)))
+		}
+		if !dryRun {
+			if datasets, err = provider.ListDatasets(ctx, zfsPath, pool); err != nil {
+				return errors.Join(append(errs, fmt.Errorf("failed to list datasets: %w", err))...)
+			}
+		}
+	}
+
+	// Sorting by mountpoint mounts parents before the datasets nested below them.
+	slices.SortFunc(datasets, func(a, b datasetInfo) int { return strings.Compare(a.Mountpoint, b.Mountpoint) })
+	var expected []datasetInfo
+	for _, d := range datasets {
+		if !d.automounted() {
+			continue
+		}
+		if d.KeyStatus == "unavailable" && !dryRun {
+			errs = append(errs, fmt.Errorf("dataset %s cannot be mounted, its key is not loaded", d.Name))
+			continue
+		}
+		if !d.Mounted {
+			slog.Info("Mounting dataset", "pool", pool, "dataset", d.Name, "mountpoint", d.Mountpoint)
+			if output, err := provider.MountDataset(ctx, zfsPath, d.Name); err != nil {
+				errs = append(errs, fmt.Errorf("failed to mount dataset %s: %w, output: %s", d.Name, err, string(output)))
+				continue
+			}
+		}
+		expected = append(expected, d)
+	}
+
+	if !dryRun && len(expected) > 0 {
+		mounts, err := mountedPaths()
+		if err != nil {
+			return errors.Join(append(errs, fmt.Errorf("failed to read mount table: %w", err))...)
+		}
+		for _, d := range expected {
+			if !mounts[d.Mountpoint] {
+				errs = append(errs, fmt.Errorf("dataset %s is not in the mount table at %s", d.Name, d.Mountpoint))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseDatasetList(t *testing.T) {
+	output := "tank\t/var/mnt/tank\ton\tyes\t-\n" +
+		"tank/secret\t/var/mnt/tank/secret\ton\tno\tunavailable\n" +
+		"tank/legacy\tlegacy\ton\tno\t-\n"
+	datasets := parseDatasetList(output)
+	if len(datasets) != 3 {
+		t.Fatalf("Expected 3 datasets, got %+v", datasets)
+	}
+	if datasets[1] != (datasetInfo{Name: "tank/secret", Mountpoint: "/var/mnt/tank/secret", CanMount: "on", KeyStatus: "unavailable"}) {
+		t.Errorf("Unexpected dataset %+v", datasets[1])
+	}
+	if !datasets[0].Mounted || !datasets[0].automounted() || datasets[2].automounted() {
+		t.Errorf("Unexpected mount state in %+v", datasets)
+	}
+}
+
+func TestMountPoolDatasets(t *testing.T) {
+	setMountInfo(t, "/var/mnt/tank", "/var/mnt/tank/data")
+
+	keysLoaded := false
+	var mounted []string
+	mockProvider := &mockZFSProvider{
+		ListDatasetsFunc: func(ctx context.Context, zfsPath, pool string) ([]datasetInfo, error) {
+			keyStatus := "unavailable"
+			if keysLoaded {
+				keyStatus = "available"
+			}
+			// Imported with -N: nothing is mounted yet.
+			return []datasetInfo{
+				{Name: "tank/data", Mountpoint: "/var/mnt/tank/data", CanMount: "on", KeyStatus: keyStatus},
+				{Name: "tank", Mountpoint: "/var/mnt/tank", CanMount: "on", KeyStatus: "-"},
+				{Name: "tank/noauto", Mountpoint: "/var/mnt/tank/noauto", CanMount: "noauto", KeyStatus: "-"},
+			}, nil
+		},
+		LoadKeysFunc: func(ctx context.Context, zfsPath, pool string) ([]byte, error) {
+			keysLoaded = true
+			return nil, nil
+		},
+		MountDatasetFunc: func(ctx context.Context, zfsPath, dataset string) ([]byte, error) {
+			mounted = append(mounted, dataset)
+			return nil, nil
+		},
+	}
+
+	if err := mountPoolDatasets(t.Context(), mockProvider, "/fake/zfs", "tank", false); err != nil {
+		t.Fatalf("mountPoolDatasets() returned an unexpected error: %v", err)
+	}
+	if !keysLoaded {
+		t.Error("Expected the keys to be loaded before mounting")
+	}
+	if !slices.Equal(mounted, []string{"tank", "tank/data"}) {
+		t.Errorf("Expected the parent to be mounted first and noauto datasets to be skipped, got %v", mounted)
+	}
+}
+
+func TestMountPoolDatasets_Failures(t *testing.T) {
+	// zfs believes the dataset is mounted, but it is missing from the mount table.
+	setMountInfo(t)
+
+	var mounted []string
+	mockProvider := &mockZFSProvider{
+		ListDatasetsFunc: func(ctx context.Context, zfsPath, pool string) ([]datasetInfo, error) {
+			return []datasetInfo{
+				{Name: "tank", Mountpoint: "/var/mnt/tank", CanMount: "on", Mounted: true, KeyStatus: "-"},
+				{Name: "tank/secret", Mountpoint: "/var/mnt/tank/secret", CanMount: "on", KeyStatus: "unavailable"},
+				{Name: "tank/busy", Mountpoint: "/var/mnt/tank/busy", CanMount: "on", KeyStatus: "-"},
+			}, nil
+		},
+		LoadKeysFunc: func(ctx context.Context, zfsPath, pool string) ([]byte, error) {
+			return []byte("Key load error: keylocation=prompt"), errors.New("exit status 255")
+		},
+		MountDatasetFunc: func(ctx context.Context, zfsPath, dataset string) ([]byte, error) {
+			mounted = append(mounted, dataset)
+			return []byte("directory is not empty"), errors.New("exit status 1")
+		},
+	}
+
+	err := mountPoolDatasets(t.Context(), mockProvider, "/fake/zfs", "tank", false)
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	for _, want := range []string{
+		"failed to load encryption keys",
+		"dataset tank/secret cannot be mounted",
+		"failed to mount dataset tank/busy",
+		"dataset tank is not in the mount table",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to contain %q, got: %v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "tank/busy is not in the mount table") {
+		t.Errorf("Expected a failed mount to be reported once, got: %v", err)
+	}
+	if !slices.Equal(mounted, []string{"tank/busy"}) {
+		t.Errorf("Expected only tank/busy to be mounted, got %v", mounted)
+	}
+}
+
+func TestMountPoolDatasets_Plan(t *testing.T) {
+	mockProvider := &mockZFSProvider{
+		ListDatasetsFunc: func(ctx context.Context, zfsPath, pool string) ([]datasetInfo, error) {
+			if pool == "new" {
+				t.Error("Expected planned pools not to be listed")
+			}
+			return []datasetInfo{{Name: "tank/secret", Mountpoint: "/var/mnt/tank/secret", CanMount: "on", KeyStatus: "unavailable"}}, nil
+		},
+	}
+	planner := newPlanningProvider(mockProvider)
+	planner.planned["new"] = true
+
+	for _, pool := range []string{"tank", "new"} {
+		planner.setPool(pool)
+		if err := mountPoolDatasets(t.Context(), planner, "/fake/zfs", pool, true); err != nil {
+			t.Fatalf("mountPoolDatasets() returned an unexpected error: %v", err)
+		}
+	}
+	want := []planAction{
+		{Pool: "tank", Action: "load-keys"},
+		{Pool: "tank", Action: "mount", Dataset: "tank/secret"},
+	}
+	if !slices.EqualFunc(planner.actions, want, func(a, b planAction) bool { return a.Pool == b.Pool && a.Action == b.Action && a.Dataset == b.Dataset }) {
+		t.Errorf("Planned actions = %+v, want %+v", planner.actions, want)
+	}
+}
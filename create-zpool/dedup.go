@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+const (
+	// ddtEntrySize is the memory a dedup table entry takes, and dedupBlockSize the average block
+	// size assumed for the estimate. Together they are the common rule of thumb of 5 GiB of RAM
+	// per TiB of deduplicated data; small records need more.
+	ddtEntrySize   = 320
+	dedupBlockSize = 64 << 10
+)
+
+// dedupChecksums are the checksums the dedup property accepts, each optionally with ",verify".
+var dedupChecksums = []string{"sha256", "sha512", "skein", "edonr", "blake3"}
+
+// isValidDedup checks a value of the dedup property, see zfsprops(7). Empty keeps the default.
+func isValidDedup(dedup string) bool {
+	switch dedup {
+	case "", "off", "on", "verify":
+		return true
+	}
+	checksum, verify, ok := strings.Cut(dedup, ",")
+	return slices.Contains(dedupChecksums, checksum) && (!ok || verify == "verify")
+}
+
+// dedupEnabled reports whether the configured dedup property enables deduplication.
+func dedupEnabled(dedup string) bool {
+	return dedup != "" && dedup != "off"
+}
+
+// validateDedup checks the dedup configuration of a pool. Enabling dedup needs an explicit
+// acknowledgment of its memory cost, since a dedup table that does not fit into RAM slows down
+// every write of the pool for good.
+func validateDedup(config poolConfig) error {
+	if !isValidDedup(config.Dedup) {
+		return fmt.Errorf("invalid dedup value: %q", config.Dedup)
+	}
+	if dedupEnabled(config.Dedup) && !config.DedupAck {
+		return fmt.Errorf("dedup=%s requires ZPOOL_<n>_DEDUP_ACK=true to acknowledge that the dedup table needs about 5 GiB of RAM per TiB of data", config.Dedup)
+	}
+	return nil
+}
+
+// dataCapacity estimates how much data a vdev of the given type and disk sizes can hold: the
+// disks minus their redundancy, with every disk counted as large as the smallest one for
+// redundant vdevs.
+func dataCapacity(vdevType string, sizes []uint64) uint64 {
+	if len(sizes) == 0 {
+		return 0
+	}
+	n, smallest := uint64(len(sizes)), slices.Min(sizes)
+	var parity uint64
+	switch normalizeVdevType(vdevType) {
+	case "":
+		var total uint64
+		for _, size := range sizes {
+			total += size
+		}
+		return total
+	case "mirror":
+		return smallest
+	case "raidz1":
+		parity = 1
+	case "raidz2":
+		parity = 2
+	case "raidz3":
+		parity = 3
+	}
+	if parity > 0 {
+		return (n - min(parity, n-1)) * smallest
+	}
+	layout, ok, err := parseDraidType(vdevType)
+	if !ok || err != nil {
+		return n * smallest
+	}
+	data := uint64(layout.Data)
+	if data == 0 {
+		data = uint64(max(min(len(sizes)-layout.Spares-layout.Parity, draidDefaultData), 1))
+	}
+	children := n - min(uint64(layout.Spares), n-1)
+	return children * data / (data + uint64(layout.Parity)) * smallest
+}
+
+// vdevsCapacity returns the usable data capacity of vdevs built from disks of the given sizes,
+// which are those of all vdevs in order, see vdevCreateArgs.
+func vdevsCapacity(vdevs []vdevSpec, sizes []uint64) uint64 {
+	var capacity uint64
+	for _, vdev := range vdevs {
+		disks, _ := vdev.disks()
+		n := min(len(disks), len(sizes))
+		capacity += dataCapacity(vdev.Type, sizes[:n])
+		sizes = sizes[n:]
+	}
+	return capacity
+}
+
+// checkDedupMemory estimates the memory the dedup table of a new pool on disks needs once the
+// pool is full and logs it. A table larger than the node's RAM fails the pool, one larger than
+// a quarter of it is warned about.
+func checkDedupMemory(provider zfsProvider, config poolConfig, disks []string) error {
+	if !dedupEnabled(config.Dedup) {
+		return nil
+	}
+	var sizes []uint64
+	for _, disk := range disks {
+		size, err := provider.GetDiskSize(disk)
+		if err != nil {
+			return fmt.Errorf("failed to get the size of %s to estimate the dedup table: %w", disk, err)
+		}
+		sizes = append(sizes, size)
+	}
+	memTotal, err := provider.GetMemTotal()
+	if err != nil {
+		return fmt.Errorf("failed to determine total memory to estimate the dedup table: %w", err)
+	}
+	capacity := dataCapacity(config.Type, sizes)
+	if len(config.Vdevs) > 0 {
+		capacity = vdevsCapacity(config.Vdevs, sizes)
+	}
+	estimate := capacity / dedupBlockSize * ddtEntrySize
+
+	slog.Info("Estimated memory of the dedup table of the full pool", "pool", config.Name, "dedup", config.Dedup,
+		"data_capacity", formatSize(capacity), "dedup_table", formatSize(estimate), "memory", formatSize(memTotal))
+	if estimate > memTotal {
+		// Only a different configuration or more memory help, so retrying is pointless.
+		return configError{fmt.Errorf("the dedup table of the full pool is estimated at %s, more than the node's %s of RAM; add memory, use fewer or smaller disks, or leave dedup off",
+			formatSize(estimate), formatSize(memTotal))}
+	}
+	if estimate > memTotal/4 {
+		slog.Warn("The dedup table of the full pool would take more than a quarter of the node's memory", "pool", config.Name, "dedup_table", formatSize(estimate), "memory", formatSize(memTotal))
+	}
+	return nil
+}
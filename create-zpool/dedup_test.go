@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidateDedup(t *testing.T) {
+	testCases := []struct {
+		name   string
+		config poolConfig
+		fail   bool
+	}{
+		{"unset", poolConfig{}, false},
+		{"off without acknowledgment", poolConfig{Dedup: "off"}, false},
+		{"on with acknowledgment", poolConfig{Dedup: "on", DedupAck: true}, false},
+		{"checksum with verify", poolConfig{Dedup: "blake3,verify", DedupAck: true}, false},
+		{"on without acknowledgment", poolConfig{Dedup: "on"}, true},
+		{"unknown checksum", poolConfig{Dedup: "md5", DedupAck: true}, true},
+		{"invalid suffix", poolConfig{Dedup: "sha256,fast", DedupAck: true}, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validateDedup(tc.config); (err != nil) != tc.fail {
+				t.Errorf("validateDedup(%+v) = %v, want failure %v", tc.config, err, tc.fail)
+			}
+		})
+	}
+}
+
+func TestDataCapacity(t *testing.T) {
+	const tib = 1 << 40
+	testCases := []struct {
+		vdevType string
+		disks    int
+		want     uint64
+	}{
+		{"", 3, 3 * tib},
+		{"mirror", 3, tib},
+		{"raidz", 4, 3 * tib},
+		{"raidz2", 6, 4 * tib},
+		{"draid2:4d:1s", 13, 8 * tib},
+	}
+	for _, tc := range testCases {
+		sizes := slices.Repeat([]uint64{tib}, tc.disks)
+		if got := dataCapacity(tc.vdevType, sizes); got != tc.want {
+			t.Errorf("dataCapacity(%q, %d disks) = %d, want %d", tc.vdevType, tc.disks, got, tc.want)
+		}
+	}
+}
+
+func TestCheckDedupMemory(t *testing.T) {
+	// The mock has 16 GiB of RAM and disks of 1 TiB, about 5 GiB of dedup table each.
+	provider := &mockZFSProvider{}
+	disks := func(n int) []string { return slices.Repeat([]string{"/dev/sda"}, n) }
+
+	if err := checkDedupMemory(provider, poolConfig{Name: "tank", Type: "raidz", Dedup: "on"}, disks(4)); err != nil {
+		t.Errorf("Expected a dedup table of 15 GiB to fit into 16 GiB, got %v", err)
+	}
+	err := checkDedupMemory(provider, poolConfig{Name: "tank", Dedup: "on"}, disks(4))
+	if err == nil || !strings.Contains(err.Error(), "estimated at 20 GiB, more than the node's 16 GiB of RAM") {
+		t.Fatalf("Expected the dedup table of 4 TiB of data to be refused, got %v", err)
+	}
+	if !errors.As(err, new(configError)) {
+		t.Errorf("Expected a configuration error, got %T", err)
+	}
+	if err := checkDedupMemory(provider, poolConfig{Name: "tank", Dedup: "off"}, disks(40)); err != nil {
+		t.Errorf("Expected no check without dedup, got %v", err)
+	}
+}
+
+func TestCreatePool_Dedup(t *testing.T) {
+	var createArgs []string
+	mockProvider := &mockZFSProvider{
+		CreatePoolFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+			createArgs = args
+			return nil, nil
+		},
+	}
+	config := poolConfig{Name: "tank", Type: "mirror", Disks: []diskSpec{{Dev: "/dev/sda"}, {Dev: "/dev/sdb"}}, Ashift: "12", Dedup: "on", DedupAck: true}
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newRunState(nil)); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	if !strings.Contains(strings.Join(createArgs, " "), "-O dedup=on") {
+		t.Errorf("Expected dedup to be set on the root dataset, got %v", createArgs)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	diagnosticsDir       = "diagnostics" // Directory in the state directory holding diagnostic bundles.
+	maxDiagnosticBundles = 20            // Diagnostic bundles kept, older ones are removed.
+)
+
+// importDevicePath returns the device node for a device name listed by `zpool import`, which
+// prints names relative to the directory the device was found in.
+func importDevicePath(provider zfsProvider, name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	for _, dir := range []string{"/dev/disk/by-id", "/dev/disk/by-path", "/dev/disk/by-partuuid"} {
+		path := filepath.Join(dir, name)
+		if ok, err := provider.IsBlockDevice(path); err == nil && ok {
+			return path
+		}
+	}
+	return filepath.Join("/dev", name)
+}
+
+// renderDiagnostics gathers what is needed to diagnose a failed create or import without
+// console access: a summary of every device and its ZFS labels as read by `zdb -l`. Without
+// zdbPath the labels are left out.
+func renderDiagnostics(ctx context.Context, provider zfsProvider, zdbPath, pool, operation string, devices []string, cause error, now time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "pool: %s\noperation: %s\ntime: %s\nerror: %v\n", pool, operation, now.UTC().Format(time.RFC3339), cause)
+
+	b.WriteString("\n== devices ==\n")
+	for _, dev := range devices {
+		fmt.Fprintf(&b, "%s:\n", dev)
+		if resolved, err := provider.EvalSymlinks(dev); err != nil {
+			fmt.Fprintf(&b, "  resolved: error: %v\n", err)
+		} else {
+			fmt.Fprintf(&b, "  resolved: %s\n", resolved)
+		}
+		if ok, err := provider.IsBlockDevice(dev); err != nil {
+			fmt.Fprintf(&b, "  block device: error: %v\n", err)
+		} else {
+			fmt.Fprintf(&b, "  block device: %t\n", ok)
+		}
+		if size, err := provider.GetDiskSize(dev); err != nil {
+			fmt.Fprintf(&b, "  size: error: %v\n", err)
+		} else {
+			fmt.Fprintf(&b, "  size: %d\n", size)
+		}
+		if info, err := provider.GetQueueInfo(dev); err != nil {
+			fmt.Fprintf(&b, "  queue: error: %v\n", err)
+		} else {
+			fmt.Fprintf(&b, "  rotational: %t\n  discard max bytes: %d\n", info.Rotational, info.DiscardMaxBytes)
+		}
+	}
+
+	if zdbPath == "" {
+		b.WriteString("\nzdb binary not found, labels were not collected.\n")
+		return b.String()
+	}
+	for _, dev := range devices {
+		fmt.Fprintf(&b, "\n== zdb -l %s ==\n", dev)
+		output, err := provider.ReadDeviceLabels(ctx, zdbPath, dev)
+		b.Write(output)
+		if err != nil {
+			fmt.Fprintf(&b, "error: %v\n", err)
+		}
+	}
+	return b.String()
+}
+
+// collectDiagnostics writes a diagnostic bundle for a failed create or import of pool into
+// the diagnostics directory, so that support requests contain the device state at the time of
+// the failure. Only the newest maxDiagnosticBundles bundles are kept. Failures to write the
+// bundle are only logged, since the original error is what matters.
+func (s *runState) collectDiagnostics(ctx context.Context, provider zfsProvider, pool, operation string, devices []string, cause error) {
+	if s.diagnosticsDir == "" || s.dryRun {
+		return
+	}
+	now := time.Now()
+	content := renderDiagnostics(ctx, provider, s.zdbPath, pool, operation, devices, cause, now)
+	if err := os.MkdirAll(s.diagnosticsDir, 0o700); err != nil {
+		slog.Warn("Failed to create diagnostics directory", "path", s.diagnosticsDir, "error", err)
+		return
+	}
+	path := filepath.Join(s.diagnosticsDir, fmt.Sprintf("%s-%s-%s.txt", pool, operation, now.UTC().Format("20060102T150405.000Z")))
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		slog.Warn("Failed to write diagnostic bundle", "path", path, "error", err)
+		return
+	}
+	slog.Info("Wrote diagnostic bundle", "pool", pool, "operation", operation, "path", path)
+	pruneDiagnostics(s.diagnosticsDir, maxDiagnosticBundles)
+}
+
+// pruneDiagnostics removes all but the newest keep bundles from dir.
+func pruneDiagnostics(dir string, keep int) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		slog.Warn("Failed to list diagnostic bundles", "path", dir, "error", err)
+		return
+	}
+	type bundle struct {
+		name    string
+		modTime time.Time
+	}
+	var bundles []bundle
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		bundles = append(bundles, bundle{e.Name(), info.ModTime()})
+	}
+	if len(bundles) <= keep {
+		return
+	}
+	slices.SortFunc(bundles, func(a, b bundle) int {
+		return cmp.Or(b.modTime.Compare(a.modTime), strings.Compare(b.name, a.name))
+	})
+	for _, old := range bundles[keep:] {
+		if err := os.Remove(filepath.Join(dir, old.name)); err != nil {
+			slog.Warn("Failed to remove old diagnostic bundle", "path", old.name, "error", err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportDevicePath(t *testing.T) {
+	mockProvider := &mockZFSProvider{
+		IsBlockDeviceFunc: func(path string) (bool, error) {
+			return path == "/dev/disk/by-id/ata-disk1", nil
+		},
+	}
+	for name, want := range map[string]string{
+		"ata-disk1":      "/dev/disk/by-id/ata-disk1",
+		"sda":            "/dev/sda",
+		"/dev/nvme0n1p1": "/dev/nvme0n1p1",
+	} {
+		if got := importDevicePath(mockProvider, name); got != want {
+			t.Errorf("importDevicePath(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestRenderDiagnostics(t *testing.T) {
+	var labelled []string
+	mockProvider := &mockZFSProvider{
+		EvalSymlinksFunc: func(path string) (string, error) { return "/dev/sda", nil },
+		GetDiskSizeFunc: func(path string) (uint64, error) {
+			if path == "/dev/missing" {
+				return 0, errors.New("no such device")
+			}
+			return 4096, nil
+		},
+		ReadDeviceLabelsFunc: func(ctx context.Context, zdbPath, device string) ([]byte, error) {
+			labelled = append(labelled, device)
+			return []byte("failed to unpack label 0\n"), errors.New("exit status 2")
+		},
+	}
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	devices := []string{"/dev/disk/by-id/ata-disk1", "/dev/missing"}
+	got := renderDiagnostics(t.Context(), mockProvider, "/fake/zdb", "tank", "create", devices, errors.New("zpool create command failed"), now)
+	for _, want := range []string{
+		"pool: tank\noperation: create\ntime: 2026-10-14T10:00:00Z\nerror: zpool create command failed\n",
+		"/dev/disk/by-id/ata-disk1:\n  resolved: /dev/sda\n",
+		"  size: 4096\n",
+		"  size: error: no such device\n",
+		"== zdb -l /dev/missing ==\nfailed to unpack label 0\nerror: exit status 2\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected diagnostics to contain %q, got:\n%s", want, got)
+		}
+	}
+	if len(labelled) != 2 {
+		t.Errorf("Expected labels of every device to be read, got %v", labelled)
+	}
+
+	// Without zdb only the device summary is collected.
+	labelled = nil
+	got = renderDiagnostics(t.Context(), mockProvider, "", "tank", "import", devices, errors.New("failed"), now)
+	if len(labelled) != 0 || !strings.Contains(got, "zdb binary not found") {
+		t.Errorf("Expected no labels without zdb, got:\n%s", got)
+	}
+}
+
+func TestCollectDiagnostics(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), diagnosticsDir)
+	state := newRunState(nil)
+	state.diagnosticsDir = dir
+
+	state.dryRun = true
+	state.collectDiagnostics(t.Context(), &mockZFSProvider{}, "tank", "create", []string{"/dev/sda"}, errors.New("failed"))
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("Expected no diagnostics in a dry run, got %v", err)
+	}
+
+	state.dryRun = false
+	for i := range maxDiagnosticBundles + 2 {
+		state.collectDiagnostics(t.Context(), &mockZFSProvider{}, "tank", "create", []string{"/dev/sda"}, fmt.Errorf("failure %d", i))
+		time.Sleep(time.Millisecond)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != maxDiagnosticBundles {
+		t.Fatalf("Expected %d bundles to be kept, got %d", maxDiagnosticBundles, len(entries))
+	}
+	newest, err := os.ReadFile(filepath.Join(dir, entries[len(entries)-1].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(newest), fmt.Sprintf("error: failure %d\n", maxDiagnosticBundles+1)) {
+		t.Errorf("Expected the newest bundle to be kept, got:\n%s", newest)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// layoutFields are the drift fields that are summarized as a single layout line in the diff.
+var layoutFields = map[string]bool{"type": true, "vdevs": true, "disk count": true, "disk": true}
+
+// describeConfiguredLayout renders the configured vdev type and disks, e.g. "mirror /dev/sda /dev/sdb",
+// or "mirror(/dev/sda /dev/sdb) mirror(/dev/sdc /dev/sdd)" for several vdevs.
+func describeConfiguredLayout(config poolConfig) string {
+	describeDisks := func(disks []diskSpec) []string {
+		var names []string
+		for _, disk := range disks {
+			if disk.Dev != "" {
+				names = append(names, disk.Dev)
+			} else {
+				names = append(names, "model "+strconv.Quote(disk.Model))
+			}
+		}
+		return names
+	}
+
+	if len(config.Vdevs) > 0 {
+		var parts []string
+		for _, vdev := range config.Vdevs {
+			disks, err := vdev.disks()
+			if err != nil {
+				return "vdevs (invalid disk list)"
+			}
+			parts = append(parts, describeVdevType(normalizeVdevType(vdev.Type))+"("+strings.Join(describeDisks(disks), " ")+")")
+		}
+		return strings.Join(parts, " ")
+	}
+	vdevType := describeVdevType(normalizeVdevType(config.Type))
+	disks, err := configuredDisks(config)
+	if err != nil {
+		return vdevType + " (invalid disk list)"
+	}
+	return strings.Join(append([]string{vdevType}, describeDisks(disks)...), " ")
+}
+
+// describeActualLayout renders the data vdevs of a pool, e.g. "mirror sda sdb(UNAVAIL)" or
+// "mirror-0(sda sdb) mirror-1(sdc sdd)" for several vdevs.
+func describeActualLayout(vdevs []*vdevStatus) string {
+	describeLeaves := func(leaves []*vdevStatus) []string {
+		var names []string
+		for _, leaf := range leaves {
+			name := leaf.Name
+			if leaf.Path != "" {
+				name = filepath.Base(leaf.Path)
+			}
+			if leaf.State != "" && leaf.State != "ONLINE" {
+				name += "(" + leaf.State + ")"
+			}
+			names = append(names, name)
+		}
+		return names
+	}
+
+	vdevType, leaves := actualLayout(vdevs)
+	if len(vdevs) == 1 || vdevType == "" {
+		return strings.Join(append([]string{describeVdevType(vdevType)}, describeLeaves(leaves)...), " ")
+	}
+	var parts []string
+	for _, vdev := range vdevs {
+		parts = append(parts, vdev.Name+"("+strings.Join(describeLeaves(vdev.leaves()), " ")+")")
+	}
+	return strings.Join(parts, " ")
+}
+
+// writePoolDiff writes the human readable differences of a single pool to w.
+func writePoolDiff(w io.Writer, config poolConfig, items []driftItem, status *poolStatus) {
+	if len(items) == 0 {
+		fmt.Fprintf(w, "pool %s: in sync\n", config.Name)
+		return
+	}
+	if len(items) == 1 && items[0].Field == "pool" {
+		fmt.Fprintf(w, "pool %s: config wants %s; actual is %s\n", config.Name, items[0].Want, items[0].Have)
+		return
+	}
+
+	fmt.Fprintf(w, "pool %s:\n", config.Name)
+	layoutShown := false
+	for _, item := range items {
+		if layoutFields[item.Field] && status != nil {
+			if !layoutShown {
+				fmt.Fprintf(w, "  layout: config wants %s; actual is %s\n", describeConfiguredLayout(config), describeActualLayout(status.dataVdevs()))
+				layoutShown = true
+			}
+			continue
+		}
+		fmt.Fprintf(w, "  %s: config wants %s; actual is %s\n", item.Field, item.Want, item.Have)
+	}
+}
+
+// diffMain implements the diff mode: it runs the same comparison as the audit and writes a
+// human readable diff per pool to w. It returns the process exit code, which is only non-zero
+// if the system could not be inspected.
+func diffMain(ctx context.Context, provider zfsProvider, zpoolPath, zfsPath string, configs []poolConfig, w io.Writer) int {
+	existingPools, err := provider.ListPools(ctx, zpoolPath)
+	if err != nil {
+		slog.Error("Failed to list existing pools", "error", err)
+		return 1
+	}
+	state := newRunState(existingPools)
+	cache := newPoolStatusCache(provider, zpoolPath)
+
+	code := 0
+	for _, config := range configs {
+		items, err := auditPool(ctx, provider, zpoolPath, zfsPath, config, state, cache)
+		if err != nil {
+			fmt.Fprintf(w, "pool %s: error: %v\n", config.Name, err)
+			code = 1
+			continue
+		}
+		var status *poolStatus
+		if statuses, err := cache.Status(ctx, []string{config.Name}); err == nil {
+			status = statuses[config.Name]
+		}
+		writePoolDiff(w, config, items, status)
+	}
+	return code
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestDiffMain(t *testing.T) {
+	mockProvider := &mockZFSProvider{
+		ListPoolsFunc: func(ctx context.Context, zpoolPath string) (map[string]string, error) {
+			return map[string]string{"tank": "1234567890", "data": "42"}, nil
+		},
+		GetAllPoolStatusFunc: func(ctx context.Context, zpoolPath string) ([]byte, error) {
+			return []byte(testPoolStatusJSON), nil
+		},
+		GetPoolPropertiesFunc: func(ctx context.Context, zpoolPath, name string, props []string) (map[string]string, error) {
+			return map[string]string{"ashift": "12", "autotrim": "off"}, nil
+		},
+	}
+
+	configs := []poolConfig{
+		{Name: "tank", Type: "mirror", Disks: []diskSpec{{Dev: "/dev/sda"}, {Dev: "/dev/sdc"}}, Ashift: "12", Properties: map[string]string{"autotrim": "on"}},
+		{Name: "data", Ashift: "12"},
+		{Name: "backup", Ashift: "12"},
+	}
+	var out strings.Builder
+	if code := diffMain(t.Context(), mockProvider, "/fake/zpool", "", configs, &out); code != 0 {
+		t.Fatalf("diffMain() = %d, want 0", code)
+	}
+
+	want := `pool tank:
+  health: config wants ONLINE; actual is DEGRADED
+  layout: config wants mirror /dev/sda /dev/sdc; actual is mirror sda1 sdb1(UNAVAIL)
+  property autotrim: config wants on; actual is off
+pool data: in sync
+pool backup: config wants imported; actual is missing
+`
+	if out.String() != want {
+		t.Errorf("diffMain() output =\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestDescribeActualLayout(t *testing.T) {
+	vdevs := []*vdevStatus{
+		{Name: "mirror-0", Vdevs: map[string]*vdevStatus{"sda": {Name: "sda", State: "ONLINE"}, "sdb": {Name: "sdb", State: "ONLINE"}}},
+		{Name: "mirror-1", Vdevs: map[string]*vdevStatus{"sdc": {Name: "sdc", State: "FAULTED"}, "sdd": {Name: "sdd", State: "ONLINE"}}},
+	}
+	if got, want := describeActualLayout(vdevs), "mirror-0(sda sdb) mirror-1(sdc(FAULTED) sdd)"; got != want {
+		t.Errorf("describeActualLayout() = %q, want %q", got, want)
+	}
+	single := []*vdevStatus{{Name: "sda", State: "ONLINE"}}
+	if got, want := describeActualLayout(single), "stripe sda"; got != want {
+		t.Errorf("describeActualLayout() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// diskTemplatePattern matches the placeholders in disk values, e.g. ${SERIAL}.
+var diskTemplatePattern = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// facts returns the node facts the placeholders of disk values stand for.
+func (id nodeIdentity) facts() map[string]string {
+	return map[string]string{"HOSTNAME": id.Hostname, "SERIAL": id.Serial, "UUID": id.UUID}
+}
+
+// expandDiskTemplate replaces the placeholders in a disk value with the facts of the node, so
+// that one configuration can serve many machines, e.g. /dev/disk/by-id/nvme-Samsung_${SERIAL}.
+// facts is only called if value has a placeholder. Unknown placeholders and facts the node lacks
+// are an error, since a path with a hole in it could match the wrong disk. In a JSON disk list,
+// the facts are escaped as JSON strings.
+func expandDiskTemplate(value string, facts func() map[string]string) (string, error) {
+	if !strings.Contains(value, "${") {
+		return value, nil
+	}
+	jsonList, known := strings.HasPrefix(value, "["), facts()
+	var err error
+	expanded := diskTemplatePattern.ReplaceAllStringFunc(value, func(placeholder string) string {
+		name := diskTemplatePattern.FindStringSubmatch(placeholder)[1]
+		fact, ok := known[name]
+		switch {
+		case !ok:
+			err = fmt.Errorf("unknown placeholder %s in %q, valid are %v", placeholder, value, slices.Sorted(maps.Keys(known)))
+		case fact == "" && err == nil:
+			err = fmt.Errorf("placeholder %s in %q is unknown on this node", placeholder, value)
+		case jsonList:
+			quoted, _ := json.Marshal(fact)
+			return string(quoted[1 : len(quoted)-1])
+		}
+		return fact
+	})
+	if err != nil {
+		return value, err
+	}
+	return expanded, nil
+}
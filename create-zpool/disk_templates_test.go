@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpandDiskTemplate(t *testing.T) {
+	facts := func() map[string]string {
+		return map[string]string{"HOSTNAME": "storage-1", "SERIAL": `AB"12`, "UUID": ""}
+	}
+	tests := []struct {
+		value, want, wantErr string
+	}{
+		{"/dev/sda", "/dev/sda", ""},
+		{"/dev/disk/by-id/nvme-Samsung_${HOSTNAME}", "/dev/disk/by-id/nvme-Samsung_storage-1", ""},
+		{"${HOSTNAME}-${SERIAL}", `storage-1-AB"12`, ""},
+		// Facts are escaped in JSON disk lists.
+		{`["/dev/sda",{"model":"x_${SERIAL}"}]`, `["/dev/sda",{"model":"x_AB\"12"}]`, ""},
+		{"/dev/${DISK}", "/dev/${DISK}", "unknown placeholder ${DISK}"},
+		{"/dev/${UUID}", "/dev/${UUID}", "is unknown on this node"},
+		// A value without a complete placeholder is taken as is.
+		{"/dev/${SERIAL", "/dev/${SERIAL", ""},
+	}
+	for _, tt := range tests {
+		got, err := expandDiskTemplate(tt.value, facts)
+		if got != tt.want || (err == nil) != (tt.wantErr == "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("expandDiskTemplate(%q) = %q, %v, want %q, %q", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParsePoolConfigs_DiskTemplates(t *testing.T) {
+	t.Setenv("ZPOOL_NODE_HOSTNAME", "storage-1")
+	t.Setenv("ZPOOL_NODE_SERIAL", "S123")
+	t.Setenv("ZPOOL_0_NAME", "tank")
+	t.Setenv("ZPOOL_0_DISK_0_DEV", "/dev/disk/by-id/nvme-Samsung_${SERIAL}")
+	t.Setenv("ZPOOL_0_DISK_1_MODEL", "${HOSTNAME}*")
+	t.Setenv("ZPOOL_0_DISKS", "/dev/disk/by-partlabel/${HOSTNAME}-data")
+	t.Setenv("ZPOOL_1_NAME", "broken")
+	t.Setenv("ZPOOL_1_DISKS", "/dev/${SERIALS}")
+
+	configs := parsePoolConfigs()
+	if len(configs) != 2 {
+		t.Fatalf("Expected 2 pools, got %d", len(configs))
+	}
+	tank := configs[0]
+	if len(tank.ParseErrors) > 0 || tank.Disks[0].Dev != "/dev/disk/by-id/nvme-Samsung_S123" || tank.Disks[1].Model != "storage-1*" ||
+		tank.DiskList != "/dev/disk/by-partlabel/storage-1-data" {
+		t.Errorf("Unexpected pool %+v", tank)
+	}
+	if broken := configs[1]; len(broken.ParseErrors) != 1 || !strings.Contains(broken.ParseErrors[0].Error(), "${SERIALS}") {
+		t.Errorf("Expected an unknown placeholder error, got %v", broken.ParseErrors)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Bounds of dRAID layouts, as enforced by zpool create.
+const (
+	draidDefaultData = 8   // Data disks per redundancy group if not given.
+	draidMaxChildren = 255 // Most disks a dRAID vdev can have.
+	draidMaxSpares   = 100 // Most distributed spares of a dRAID vdev.
+)
+
+// draidLayout is the layout of a dRAID vdev as given in its type, e.g. draid2:4d:10c:1s. Zero
+// Data and Children mean the zpool defaults: as many data disks as fit, up to eight, and all
+// disks of the vdev as children.
+type draidLayout struct {
+	Parity   int // Parity disks per redundancy group, 1 to 3.
+	Data     int // Data disks per redundancy group.
+	Children int // Disks of the vdev, including the distributed spares.
+	Spares   int // Distributed spares.
+}
+
+// parseDraidType parses a dRAID vdev type: draid, draid1, draid2 or draid3, optionally followed
+// by :<n>d, :<n>c and :<n>s in any order. ok is false if vdevType is no dRAID type at all.
+func parseDraidType(vdevType string) (layout draidLayout, ok bool, err error) {
+	if !strings.HasPrefix(vdevType, "draid") {
+		return draidLayout{}, false, nil
+	}
+	base, params, _ := strings.Cut(vdevType, ":")
+	switch base {
+	case "draid", "draid1":
+		layout.Parity = 1
+	case "draid2":
+		layout.Parity = 2
+	case "draid3":
+		layout.Parity = 3
+	default:
+		return draidLayout{}, true, fmt.Errorf("the parity must be draid, draid1, draid2 or draid3, got %q", base)
+	}
+	if params == "" {
+		return layout, true, nil
+	}
+	seen := make(map[byte]bool)
+	for _, param := range strings.Split(params, ":") {
+		if len(param) < 2 {
+			return draidLayout{}, true, fmt.Errorf("invalid parameter %q, expected e.g. 4d, 10c or 1s", param)
+		}
+		suffix := param[len(param)-1]
+		n, err := strconv.Atoi(param[:len(param)-1])
+		if err != nil || n < 0 || (suffix != 's' && n == 0) {
+			return draidLayout{}, true, fmt.Errorf("invalid parameter %q, expected a positive count", param)
+		}
+		if seen[suffix] {
+			return draidLayout{}, true, fmt.Errorf("parameter %q given twice", param)
+		}
+		seen[suffix] = true
+		switch suffix {
+		case 'd':
+			layout.Data = n
+		case 'c':
+			layout.Children = n
+		case 's':
+			layout.Spares = n
+		default:
+			return draidLayout{}, true, fmt.Errorf("invalid parameter %q, the suffix must be d (data), c (children) or s (spares)", param)
+		}
+	}
+	return layout, true, nil
+}
+
+// draidSettings are the variables of a dRAID layout given apart from the type, by their suffix,
+// e.g. ZPOOL_<n>_DRAID_CHILDREN, and the parameter of the type each stands for.
+var draidSettings = []struct{ Suffix, Param string }{
+	{"DRAID_DATA", "d"},
+	{"DRAID_CHILDREN", "c"},
+	{"DRAID_SPARES", "s"},
+}
+
+// draidTypeWithSettings appends the dRAID layout given in the <prefix>DRAID_* variables to
+// vdevType, e.g. draid2 with ZPOOL_0_DRAID_DATA=4 and ZPOOL_0_DRAID_SPARES=1 becomes
+// draid2:4d:1s. The variables need a dRAID type without a layout of its own; without them,
+// vdevType is returned as it is.
+func draidTypeWithSettings(vdevType, prefix string) (string, error) {
+	var params strings.Builder
+	for _, setting := range draidSettings {
+		if _, ok := lookupEnvTrimmed(prefix + setting.Suffix); !ok {
+			continue
+		}
+		n, err := getEnvUint(prefix+setting.Suffix, 0)
+		if err != nil {
+			return vdevType, err
+		}
+		fmt.Fprintf(&params, ":%d%s", n, setting.Param)
+	}
+	if params.Len() == 0 {
+		return vdevType, nil
+	}
+	if _, ok, _ := parseDraidType(vdevType); !ok {
+		return vdevType, fmt.Errorf("%sDRAID_* require a dRAID type in %sTYPE, got %q", prefix, prefix, vdevType)
+	}
+	if strings.Contains(vdevType, ":") {
+		return vdevType, fmt.Errorf("%sDRAID_* cannot be combined with the layout in %sTYPE=%s", prefix, prefix, vdevType)
+	}
+	return vdevType + params.String(), nil
+}
+
+// validate checks that the layout is consistent in itself, which is all that can be checked
+// before the disks are known.
+func (l draidLayout) validate() error {
+	if l.Spares > draidMaxSpares {
+		return fmt.Errorf("dRAID supports at most %d distributed spares, got %d", draidMaxSpares, l.Spares)
+	}
+	if l.Children > draidMaxChildren {
+		return fmt.Errorf("dRAID supports at most %d children, got %d", draidMaxChildren, l.Children)
+	}
+	if l.Children > 0 {
+		return l.validateDisks(l.Children)
+	}
+	return nil
+}
+
+// validateDisks checks the layout against the number of disks the vdev is created from, with
+// the explanation zpool's own error lacks.
+func (l draidLayout) validateDisks(disks int) error {
+	if l.Children > 0 && l.Children != disks {
+		return fmt.Errorf("the dRAID layout declares %d children, but %d disks were selected; the children count must equal the number of disks", l.Children, disks)
+	}
+	if disks > draidMaxChildren {
+		return fmt.Errorf("dRAID supports at most %d children, but %d disks were selected", draidMaxChildren, disks)
+	}
+	data := l.Data
+	if data == 0 {
+		// zpool fits as many data disks as possible, up to the default.
+		data = min(disks-l.Spares-l.Parity, draidDefaultData)
+		if data < 1 {
+			return fmt.Errorf("%d disks are too few for dRAID with %d parity and %d spares: at least %d are required (1 data + %d parity + %d spares)",
+				disks, l.Parity, l.Spares, 1+l.Parity+l.Spares, l.Parity, l.Spares)
+		}
+	}
+	if need := data + l.Parity + l.Spares; disks < need {
+		return fmt.Errorf("%d disks are too few for the dRAID layout: at least %d are required (%d data + %d parity + %d spares)",
+			disks, need, data, l.Parity, l.Spares)
+	}
+	return nil
+}
+
+// validateDraidChildren checks the children count of the dRAID layout of a pool against its
+// configured disk entries, each of which selects one disk, so that a mismatch is reported as a
+// configuration error rather than once disks were selected. The vdevs of a pool with several are
+// checked by validateVdevs.
+func validateDraidChildren(config poolConfig) error {
+	layout, ok, err := parseDraidType(config.Type)
+	if !ok || err != nil || layout.Children == 0 {
+		return nil
+	}
+	disks, err := configuredDisks(config)
+	if err != nil || len(disks) == 0 {
+		return nil
+	}
+	if len(disks) != layout.Children {
+		return fmt.Errorf("invalid dRAID type %q: the dRAID layout declares %d children, but %d disks are configured", config.Type, layout.Children, len(disks))
+	}
+	return nil
+}
+
+// validateDraidType checks a dRAID vdev type in itself, and against the number of disks if
+// disks is positive. Other vdev types are accepted as they are.
+func validateDraidType(vdevType string, disks int) error {
+	layout, ok, err := parseDraidType(vdevType)
+	if !ok {
+		return nil
+	}
+	if err == nil {
+		err = layout.validate()
+	}
+	if err == nil && disks > 0 {
+		err = layout.validateDisks(disks)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid dRAID type %q: %w", vdevType, err)
+	}
+	return nil
+}
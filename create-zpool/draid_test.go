@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseDraidType(t *testing.T) {
+	testCases := []struct {
+		input string
+		want  draidLayout
+		fail  bool
+	}{
+		{"draid", draidLayout{Parity: 1}, false},
+		{"draid3", draidLayout{Parity: 3}, false},
+		{"draid2:4d:1s:10c", draidLayout{Parity: 2, Data: 4, Children: 10, Spares: 1}, false},
+		{"draid1:0s", draidLayout{Parity: 1}, false},
+		{"draid4", draidLayout{}, true},
+		{"draid2:4x", draidLayout{}, true},
+		{"draid2:d", draidLayout{}, true},
+		{"draid2:0d", draidLayout{}, true},
+		{"draid2:4d:5d", draidLayout{}, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			got, ok, err := parseDraidType(tc.input)
+			if !ok {
+				t.Fatalf("parseDraidType(%q) did not recognize a dRAID type", tc.input)
+			}
+			if tc.fail {
+				if err == nil {
+					t.Errorf("parseDraidType(%q) expected an error, got %+v", tc.input, got)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Errorf("parseDraidType(%q) = %+v, %v; want %+v", tc.input, got, err, tc.want)
+			}
+		})
+	}
+	if _, ok, _ := parseDraidType("raidz2"); ok {
+		t.Error("Expected raidz2 not to be parsed as dRAID")
+	}
+}
+
+func TestValidateDraidType(t *testing.T) {
+	testCases := []struct {
+		name    string
+		input   string
+		disks   int
+		wantErr string
+	}{
+		{"defaults fit", "draid2", 11, ""},
+		{"defaults shrink the data disks", "draid2", 4, ""},
+		{"too few disks for the defaults", "draid2", 2, "at least 3 are required (1 data + 2 parity + 0 spares)"},
+		{"layout fits", "draid2:4d:1s:10c", 10, ""},
+		{"children mismatch", "draid2:4d:1s:10c", 8, "declares 10 children, but 8 disks were selected"},
+		{"children too few for the layout", "draid2:8d:2s:10c", 0, "at least 12 are required (8 data + 2 parity + 2 spares)"},
+		{"too few disks for the data", "draid1:8d", 6, "at least 9 are required (8 data + 1 parity + 0 spares)"},
+		{"too many children", "draid1:300c", 0, "at most 255 children"},
+		{"not dRAID", "mirror", 1, ""},
+		{"layout without disks", "draid2:4d:1s:10c", 0, ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateDraidType(tc.input, tc.disks)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateDraidType(%q, %d) returned an unexpected error: %v", tc.input, tc.disks, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("validateDraidType(%q, %d) = %v, want an error containing %q", tc.input, tc.disks, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestCreatePool_DraidTooFewDisks(t *testing.T) {
+	mockProvider := &mockZFSProvider{
+		CreatePoolFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+			t.Fatal("CreatePool must not be called for a dRAID layout that does not fit the disks")
+			return nil, nil
+		},
+	}
+	state := newRunState(nil)
+	config := poolConfig{Name: "archive", Type: "draid2:4d", Disks: []diskSpec{{Dev: "/dev/sda"}, {Dev: "/dev/sdb"}, {Dev: "/dev/sdc"}}, Ashift: "12"}
+	err := createPool(t.Context(), mockProvider, "/fake/zpool", config, state)
+	if err == nil || !strings.Contains(err.Error(), "at least 6 are required") {
+		t.Fatalf("Expected an explanatory dRAID error, got %v", err)
+	}
+	if len(state.usedDisks) != 0 {
+		t.Errorf("Expected the disks to be left to other pools, got %v", state.usedDisks)
+	}
+}
+
+func TestDraidTypeWithSettings(t *testing.T) {
+	testCases := []struct {
+		name     string
+		vdevType string
+		env      map[string]string
+		want     string
+		fail     bool
+	}{
+		{"no settings", "draid2", nil, "draid2", false},
+		{"no settings for mirror", "mirror", nil, "mirror", false},
+		{"all", "draid2", map[string]string{"DRAID_DATA": "4", "DRAID_CHILDREN": "10", "DRAID_SPARES": "1"}, "draid2:4d:10c:1s", false},
+		{"no spares", "draid", map[string]string{"DRAID_SPARES": " 0 "}, "draid:0s", false},
+		{"not draid", "raidz2", map[string]string{"DRAID_DATA": "4"}, "", true},
+		{"no type", "", map[string]string{"DRAID_CHILDREN": "8"}, "", true},
+		{"layout in type", "draid2:4d", map[string]string{"DRAID_SPARES": "1"}, "", true},
+		{"negative", "draid2", map[string]string{"DRAID_DATA": "-4"}, "", true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, setting := range draidSettings {
+				t.Setenv("ZPOOL_0_"+setting.Suffix, tc.env[setting.Suffix])
+			}
+			got, err := draidTypeWithSettings(tc.vdevType, "ZPOOL_0_")
+			if tc.fail {
+				if err == nil {
+					t.Errorf("draidTypeWithSettings(%q) expected an error, got %q", tc.vdevType, got)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Errorf("draidTypeWithSettings(%q) = %q, %v; want %q", tc.vdevType, got, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestParsePoolConfigs_DraidSettings(t *testing.T) {
+	t.Setenv("ZPOOL_0_NAME", "archive")
+	t.Setenv("ZPOOL_0_TYPE", "draid2")
+	t.Setenv("ZPOOL_0_DRAID_CHILDREN", "10")
+	t.Setenv("ZPOOL_0_DRAID_SPARES", "1")
+	t.Setenv("ZPOOL_0_DISKS", "/dev/sda /dev/sdb /dev/sdc /dev/sdd /dev/sde /dev/sdf /dev/sdg /dev/sdh")
+
+	configs := parsePoolConfigs()
+	if len(configs) != 1 || configs[0].Type != "draid2:10c:1s" {
+		t.Fatalf("parsePoolConfigs() = %+v, want a draid2:10c:1s pool", configs)
+	}
+	// Eight configured disks can never make ten children, no restart fixes that.
+	err := validatePoolConfig(configs[0])
+	var cfgErr configError
+	if !errors.As(err, &cfgErr) || !strings.Contains(err.Error(), "declares 10 children, but 8 disks are configured") {
+		t.Errorf("validatePoolConfig() = %v, want a configuration error for the children", err)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TLS modes for the SMTP connection, selected with ZPOOL_SMTP_TLS.
+const (
+	smtpTLSStartTLS = "starttls" // Upgrade the connection with STARTTLS, which the server must offer.
+	smtpTLSImplicit = "tls"      // Connect with TLS right away, usually on port 465.
+	smtpTLSNone     = "none"     // Send in plain text, only for relays on a trusted network.
+)
+
+// smtpSettings configures mail notifications. An empty Host disables them.
+type smtpSettings struct {
+	Host     string
+	Port     int
+	TLS      string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// parseSMTPSettings reads ZPOOL_SMTP_HOST, ZPOOL_SMTP_PORT, ZPOOL_SMTP_TLS, ZPOOL_SMTP_USERNAME,
+// ZPOOL_SMTP_PASSWORD, ZPOOL_SMTP_FROM and ZPOOL_SMTP_TO.
+func parseSMTPSettings() (smtpSettings, error) {
+	s := smtpSettings{
+		Host:     strings.TrimSpace(os.Getenv("ZPOOL_SMTP_HOST")),
+		TLS:      strings.ToLower(getEnv("ZPOOL_SMTP_TLS", smtpTLSStartTLS)),
+		Username: os.Getenv("ZPOOL_SMTP_USERNAME"),
+		Password: os.Getenv("ZPOOL_SMTP_PASSWORD"),
+		From:     strings.TrimSpace(os.Getenv("ZPOOL_SMTP_FROM")),
+	}
+	if s.Host == "" {
+		return s, nil
+	}
+	if !slices.Contains([]string{smtpTLSStartTLS, smtpTLSImplicit, smtpTLSNone}, s.TLS) {
+		return s, fmt.Errorf("invalid ZPOOL_SMTP_TLS %q, must be one of %s, %s, %s", s.TLS, smtpTLSStartTLS, smtpTLSImplicit, smtpTLSNone)
+	}
+	defaultPort := "587"
+	if s.TLS == smtpTLSImplicit {
+		defaultPort = "465"
+	}
+	port, err := strconv.Atoi(getEnv("ZPOOL_SMTP_PORT", defaultPort))
+	if err != nil || port < 1 || port > 65535 {
+		return s, fmt.Errorf("invalid ZPOOL_SMTP_PORT %q", os.Getenv("ZPOOL_SMTP_PORT"))
+	}
+	s.Port = port
+	for _, to := range strings.Split(os.Getenv("ZPOOL_SMTP_TO"), ",") {
+		if to = strings.TrimSpace(to); to != "" {
+			s.To = append(s.To, to)
+		}
+	}
+	if s.From == "" || len(s.To) == 0 {
+		return s, errors.New("ZPOOL_SMTP_FROM and ZPOOL_SMTP_TO are required with ZPOOL_SMTP_HOST")
+	}
+	if strings.ContainsAny(s.From+strings.Join(s.To, ""), "\r\n") {
+		return s, errors.New("ZPOOL_SMTP_FROM and ZPOOL_SMTP_TO must not contain line breaks")
+	}
+	return s, nil
+}
+
+// enabled reports whether mail notifications are configured.
+func (s smtpSettings) enabled() bool {
+	return s.Host != ""
+}
+
+func (s smtpSettings) Name() string { return "email" }
+
+// renderMail renders a notification as a plain text mail.
+func (s smtpSettings) renderMail(n notification) []byte {
+	// The subject is built from fixed words, pool names and the node name, none of which
+	// can contain line breaks, so no header injection is possible.
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", s.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&b, "Subject: [%s] %s\r\n", n.Node, n.summary())
+	fmt.Fprintf(&b, "Date: %s\r\n", n.Time.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	for line := range strings.Lines(n.Message) {
+		b.WriteString(strings.TrimRight(line, "\r\n") + "\r\n")
+	}
+	fmt.Fprintf(&b, "\r\nNode: %s\r\nPool: %s\r\nEvent: %s\r\nSeverity: %s\r\nTime: %s\r\n",
+		n.Node, n.Pool, n.Event, n.Severity, n.Time.UTC().Format(time.RFC3339))
+	return []byte(b.String())
+}
+
+// Notify mails a notification to every recipient. Authentication requires TLS, unless the
+// server is on localhost.
+func (s smtpSettings) Notify(ctx context.Context, n notification) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(s.Host, strconv.Itoa(s.Port)))
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	tlsConfig := &tls.Config{ServerName: s.Host, MinVersion: tls.VersionTLS12}
+	if s.TLS == smtpTLSImplicit {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("SMTP handshake failed: %w", err)
+	}
+	defer client.Close()
+
+	if s.TLS == smtpTLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("SMTP server does not offer STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS failed: %w", err)
+		}
+	}
+	if s.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+	if err := client.Mail(s.From); err != nil {
+		return fmt.Errorf("SMTP MAIL FROM failed: %w", err)
+	}
+	for _, to := range s.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("SMTP RCPT TO %s failed: %w", to, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP DATA failed: %w", err)
+	}
+	if _, err := w.Write(s.renderMail(n)); err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("SMTP server rejected the mail: %w", err)
+	}
+	return client.Quit()
+}
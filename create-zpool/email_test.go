@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSMTPSettings(t *testing.T) {
+	if s, err := parseSMTPSettings(); err != nil || s.enabled() {
+		t.Fatalf("Expected mail to be disabled without ZPOOL_SMTP_HOST, got %+v, %v", s, err)
+	}
+
+	t.Setenv("ZPOOL_SMTP_HOST", "mail.example.com")
+	if _, err := parseSMTPSettings(); err == nil {
+		t.Error("Expected an error without sender and recipients")
+	}
+
+	t.Setenv("ZPOOL_SMTP_FROM", "node@example.com")
+	t.Setenv("ZPOOL_SMTP_TO", "ops@example.com, storage@example.com")
+	s, err := parseSMTPSettings()
+	if err != nil {
+		t.Fatalf("parseSMTPSettings() returned an unexpected error: %v", err)
+	}
+	if s.Port != 587 || s.TLS != smtpTLSStartTLS || len(s.To) != 2 || s.To[1] != "storage@example.com" {
+		t.Errorf("Unexpected settings %+v", s)
+	}
+
+	t.Setenv("ZPOOL_SMTP_TLS", "TLS")
+	if s, err := parseSMTPSettings(); err != nil || s.Port != 465 {
+		t.Errorf("Expected port 465 for implicit TLS, got %+v, %v", s, err)
+	}
+	t.Setenv("ZPOOL_SMTP_TLS", "ssl")
+	if _, err := parseSMTPSettings(); err == nil {
+		t.Error("Expected an error for an invalid TLS mode")
+	}
+}
+
+// fakeSMTPServer accepts a single mail without TLS or authentication and returns its content.
+func fakeSMTPServer(t *testing.T) (port int, mail <-chan string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	ch := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ESMTP")
+		var data strings.Builder
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line + " x")[0]); cmd {
+			case "EHLO":
+				reply("250 localhost")
+			case "MAIL", "RCPT":
+				data.WriteString(strings.TrimSpace(line) + "\n")
+				reply("250 OK")
+			case "DATA":
+				reply("354 Go ahead")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				reply("250 Queued")
+			case "QUIT":
+				reply("221 Bye")
+				ch <- data.String()
+				return
+			default:
+				reply("502 Not implemented")
+			}
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port, ch
+}
+
+func TestSMTPNotify(t *testing.T) {
+	port, mail := fakeSMTPServer(t)
+	s := smtpSettings{Host: "127.0.0.1", Port: port, TLS: smtpTLSNone, From: "node@example.com", To: []string{"ops@example.com", "storage@example.com"}}
+	n := notification{Time: time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC), Severity: severityCritical, Event: eventScrubErrors, Node: "node1", Pool: "tank",
+		Message: "The scrub of pool tank found errors.\n.leading dot"}
+	if err := s.Notify(t.Context(), n); err != nil {
+		t.Fatalf("sendMail() returned an unexpected error: %v", err)
+	}
+
+	got := <-mail
+	for _, want := range []string{
+		"MAIL FROM:<node@example.com>",
+		"RCPT TO:<ops@example.com>\nRCPT TO:<storage@example.com>\n",
+		"To: ops@example.com, storage@example.com\r\n",
+		"Subject: [node1] CRITICAL: pool tank: scrub-errors\r\n",
+		"Date: Wed, 14 Oct 2026 10:00:00 +0000\r\n",
+		"The scrub of pool tank found errors.\r\n..leading dot\r\n",
+		"Severity: critical\r\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected the mail to contain %q, got:\n%s", want, got)
+		}
+	}
+
+	// STARTTLS is required unless disabled.
+	port, _ = fakeSMTPServer(t)
+	s.Port, s.TLS = port, smtpTLSStartTLS
+	if err := s.Notify(t.Context(), n); err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("Expected an error without STARTTLS support, got %v", err)
+	}
+}
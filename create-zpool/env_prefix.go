@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// defaultEnvPrefix namespaces the variables of this tool, see ZPOOL_ENV_PREFIX.
+const defaultEnvPrefix = "TALOS_ZPOOL_"
+
+// envConflict is a prefixed variable that overrode an unprefixed one with a different value.
+type envConflict struct {
+	Prefixed, Unprefixed string
+	Value, Ignored       string
+}
+
+// unprefixedEnvName maps the name of a prefixed variable, without its prefix, to the variable it
+// sets: ZFS_* variables keep their name, everything else is a ZPOOL_* variable. With the default
+// prefix, TALOS_ZPOOL_0_NAME sets ZPOOL_0_NAME and TALOS_ZPOOL_ZFS_BIN sets ZFS_BIN.
+func unprefixedEnvName(name string) string {
+	if strings.HasPrefix(name, "ZFS_") {
+		return name
+	}
+	return "ZPOOL_" + name
+}
+
+// envPrefix returns the prefix of the namespaced variables, see ZPOOL_ENV_PREFIX; empty disables them.
+func envPrefix() string {
+	return getEnv("ZPOOL_ENV_PREFIX", defaultEnvPrefix)
+}
+
+// stripEnvPrefix returns the variable that key, a variable starting with prefix, sets, see
+// unprefixedEnvName. ok is false for all other variables.
+func stripEnvPrefix(key, prefix string) (target string, ok bool) {
+	name, found := strings.CutPrefix(key, prefix)
+	if prefix == "" || !found || name == "" {
+		return "", false
+	}
+	return unprefixedEnvName(name), true
+}
+
+// applyEnvPrefix sets the variable behind every variable starting with prefix, so that the rest
+// of the tool only reads the unprefixed names. The prefixed variables avoid collisions with other
+// extensions reading generic names like ASHIFT; they take precedence over the unprefixed names,
+// which keep working. Overridden unprefixed variables with a different value are returned.
+func applyEnvPrefix(prefix string) []envConflict {
+	if prefix == "" {
+		return nil
+	}
+	var conflicts []envConflict
+	for _, kv := range os.Environ() {
+		key, value, _ := strings.Cut(kv, "=")
+		target, ok := stripEnvPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		if old, ok := os.LookupEnv(target); ok && old != value {
+			conflicts = append(conflicts, envConflict{Prefixed: key, Unprefixed: target, Value: value, Ignored: old})
+		}
+		os.Setenv(target, value)
+	}
+	return conflicts
+}
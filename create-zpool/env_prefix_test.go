@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestApplyEnvPrefix(t *testing.T) {
+	for _, key := range []string{"ZPOOL_0_NAME", "ZPOOL_ASHIFT", "ZFS_BIN"} {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+	t.Setenv("TALOS_ZPOOL_0_NAME", "tank")
+	t.Setenv("TALOS_ZPOOL_ASHIFT", "13")
+	t.Setenv("TALOS_ZPOOL_ZFS_BIN", "/usr/local/sbin/zfs")
+	t.Setenv("ZPOOL_0_NAME", "old")
+	t.Setenv("TALOS_ZPOOL_", "ignored")
+
+	conflicts := applyEnvPrefix(defaultEnvPrefix)
+
+	for key, want := range map[string]string{"ZPOOL_0_NAME": "tank", "ZPOOL_ASHIFT": "13", "ZFS_BIN": "/usr/local/sbin/zfs"} {
+		if got := os.Getenv(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	if len(conflicts) != 1 || conflicts[0] != (envConflict{Prefixed: "TALOS_ZPOOL_0_NAME", Unprefixed: "ZPOOL_0_NAME", Value: "tank", Ignored: "old"}) {
+		t.Errorf("Unexpected conflicts %+v", conflicts)
+	}
+}
+
+func TestApplyEnvPrefix_Custom(t *testing.T) {
+	t.Setenv("ZPOOL_1_NAME", "")
+	os.Unsetenv("ZPOOL_1_NAME")
+	t.Setenv("ACME_ZPOOL_1_NAME", "fast")
+	t.Setenv("TALOS_ZPOOL_1_NAME", "ignored")
+
+	if conflicts := applyEnvPrefix("ACME_ZPOOL_"); len(conflicts) != 0 {
+		t.Errorf("Unexpected conflicts %+v", conflicts)
+	}
+	if got := os.Getenv("ZPOOL_1_NAME"); got != "fast" {
+		t.Errorf("ZPOOL_1_NAME = %q, want %q", got, "fast")
+	}
+}
+
+func TestIsConfigVar_Prefixed(t *testing.T) {
+	for key, want := range map[string]bool{"TALOS_ZPOOL_0_NAME": true, "TALOS_ZPOOL_ZFS_BIN": true, "TALOS_ZPOOL_": false, "TALOS_OTHER": false} {
+		if got := isConfigVar(key); got != want {
+			t.Errorf("isConfigVar(%q) = %v, want %v", key, got, want)
+		}
+	}
+
+	t.Setenv("ZPOOL_ENV_PREFIX", "ACME_ZPOOL_")
+	if !isConfigVar("ACME_ZPOOL_0_NAME") || isConfigVar("TALOS_ZPOOL_0_NAME") {
+		t.Error("Expected only the variables of the custom prefix to be configuration variables")
+	}
+	t.Setenv("ACME_ZPOOL_0_TYPE", "bogus")
+	before := configFingerprint("")
+	t.Setenv("ACME_ZPOOL_0_TYPE", "mirror")
+	if configFingerprint("") == before {
+		t.Error("Expected a changed prefixed variable to change the fingerprint")
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// Erase modes for ZPOOL_<n>_ERASE.
+const (
+	eraseNone    = ""        // Devices are used as they are.
+	eraseDiscard = "discard" // Discard all blocks of the device (like blkdiscard).
+	eraseSecure  = "secure"  // Securely discard all blocks, including remapped copies (like blkdiscard -s).
+)
+
+// isValidEraseMode checks if the value is a supported ZPOOL_<n>_ERASE mode.
+func isValidEraseMode(mode string) bool {
+	return mode == eraseNone || mode == eraseDiscard || mode == eraseSecure
+}
+
+// eraseDisks discards the contents of the disks concurrently, see diskSlots, before they are
+// handed to `zpool create`. Since the erase was asked for explicitly, a device that does not
+// support the requested discard fails the pool rather than being used with its old contents.
+func eraseDisks(provider zfsProvider, pool, mode string, disks []string) error {
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs []error
+		sem  = make(chan struct{}, diskSlots(len(disks)))
+	)
+	for _, disk := range disks {
+		wg.Go(func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			slog.Info("Erasing disk", "pool", pool, "device", disk, "mode", mode)
+			if err := provider.DiscardDevice(disk, mode == eraseSecure); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("failed to erase %s: %w", disk, err))
+				mu.Unlock()
+				return
+			}
+			slog.Info("Disk erased", "pool", pool, "device", disk)
+		})
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// trimDisks issues a full-device discard on every solid-state disk that supports it, restoring
+// the factory performance of worn drives. Rotational disks and disks without discard support are
+// skipped. Trimming is an optimization, so failures are logged instead of failing the pool.
+func trimDisks(provider zfsProvider, pool string, disks []string) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, diskSlots(len(disks)))
+	for _, disk := range disks {
+		info, err := provider.GetQueueInfo(disk)
+		if err != nil {
+			slog.Warn("Skipping trim, cannot determine discard support", "pool", pool, "device", disk, "error", err)
+			continue
+		}
+		if info.Rotational {
+			slog.Info("Skipping trim of rotational disk", "pool", pool, "device", disk)
+			continue
+		}
+		if info.DiscardMaxBytes == 0 {
+			slog.Info("Skipping trim, disk does not support discard", "pool", pool, "device", disk)
+			continue
+		}
+		wg.Go(func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			slog.Info("Trimming disk", "pool", pool, "device", disk)
+			if err := provider.DiscardDevice(disk, false); err != nil {
+				slog.Warn("Failed to trim disk", "pool", pool, "device", disk, "error", err)
+				return
+			}
+			slog.Info("Disk trimmed", "pool", pool, "device", disk)
+		})
+	}
+	wg.Wait()
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCreatePool_Erase(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		erased []string
+	)
+	created := false
+	mockProvider := &mockZFSProvider{
+		DiscardDeviceFunc: func(path string, secure bool) error {
+			if created {
+				t.Errorf("Disk %s erased after the pool was created", path)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			mode := "discard"
+			if secure {
+				mode = "secure"
+			}
+			erased = append(erased, path+":"+mode)
+			return nil
+		},
+		CreatePoolFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+			created = true
+			return nil, nil
+		},
+	}
+
+	config := poolConfig{Name: "tank", Type: "mirror", Disks: []diskSpec{{Dev: "/dev/sda"}, {Dev: "/dev/sdb"}}, Ashift: "12", Erase: eraseSecure}
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newRunState(nil)); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	slices.Sort(erased)
+	if !slices.Equal(erased, []string{"/dev/sda:secure", "/dev/sdb:secure"}) {
+		t.Errorf("Expected both disks to be securely erased, got %v", erased)
+	}
+}
+
+func TestCreatePool_EraseFailure(t *testing.T) {
+	created := false
+	mockProvider := &mockZFSProvider{
+		DiscardDeviceFunc: func(path string, secure bool) error {
+			if path == "/dev/sdb" {
+				return errors.New("operation not supported")
+			}
+			return nil
+		},
+		CreatePoolFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+			created = true
+			return nil, nil
+		},
+	}
+
+	config := poolConfig{Name: "tank", Disks: []diskSpec{{Dev: "/dev/sda"}, {Dev: "/dev/sdb"}}, Ashift: "12", Erase: eraseDiscard}
+	err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newRunState(nil))
+	if err == nil || !strings.Contains(err.Error(), "failed to erase /dev/sdb") {
+		t.Errorf("Expected an erase error for /dev/sdb, got %v", err)
+	}
+	if created {
+		t.Error("Pool must not be created when erasing a disk failed")
+	}
+}
+
+func TestCreatePool_InvalidEraseMode(t *testing.T) {
+	mockProvider := &mockZFSProvider{
+		DiscardDeviceFunc: func(path string, secure bool) error {
+			t.Errorf("DiscardDevice called for %s despite an invalid mode", path)
+			return nil
+		},
+	}
+
+	config := poolConfig{Name: "tank", Disks: []diskSpec{{Dev: "/dev/sda"}}, Ashift: "12", Erase: "shred"}
+	err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newRunState(nil))
+	if err == nil || !strings.Contains(err.Error(), "invalid erase mode") {
+		t.Errorf("Expected an invalid erase mode error, got %v", err)
+	}
+}
+
+func TestCreatePool_Trim(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		trimmed []string
+	)
+	queues := map[string]queueInfo{
+		"/dev/nvme0n1": {DiscardMaxBytes: 2199023255040},
+		"/dev/sda":     {Rotational: true},
+		"/dev/sdb":     {DiscardMaxBytes: 0},
+	}
+	mockProvider := &mockZFSProvider{
+		GetQueueInfoFunc: func(path string) (queueInfo, error) {
+			return queues[path], nil
+		},
+		DiscardDeviceFunc: func(path string, secure bool) error {
+			if secure {
+				t.Errorf("Trim of %s must not use secure discard", path)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			trimmed = append(trimmed, path)
+			return nil
+		},
+	}
+
+	config := poolConfig{Name: "tank", Disks: []diskSpec{{Dev: "/dev/nvme0n1"}, {Dev: "/dev/sda"}, {Dev: "/dev/sdb"}}, Ashift: "12", Trim: true}
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newRunState(nil)); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	if !slices.Equal(trimmed, []string{"/dev/nvme0n1"}) {
+		t.Errorf("Expected only the SSD with discard support to be trimmed, got %v", trimmed)
+	}
+
+	// A failed trim does not prevent the pool from being created.
+	created := false
+	mockProvider.DiscardDeviceFunc = func(path string, secure bool) error {
+		return errors.New("input/output error")
+	}
+	mockProvider.CreatePoolFunc = func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+		created = true
+		return nil, nil
+	}
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newRunState(nil)); err != nil {
+		t.Fatalf("createPool() returned an unexpected error after a failed trim: %v", err)
+	}
+	if !created {
+		t.Error("Expected the pool to be created despite the failed trim")
+	}
+}
+
+func TestLiveZFSProvider_GetQueueInfo(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldPath := sysClassBlockPath
+	sysClassBlockPath = tmpDir
+	t.Cleanup(func() {
+		sysClassBlockPath = oldPath
+	})
+
+	queueDir := filepath.Join(tmpDir, "nvme0n1", "queue")
+	if err := os.MkdirAll(queueDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range map[string]string{"rotational": "0\n", "discard_max_bytes": "2199023255040\n"} {
+		if err := os.WriteFile(filepath.Join(queueDir, name), []byte(value), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	device := filepath.Join(tmpDir, "nvme0n1-link")
+	if err := os.Symlink(filepath.Join(tmpDir, "nvme0n1"), device); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := (&liveZFSProvider{}).GetQueueInfo(device)
+	if err != nil {
+		t.Fatalf("GetQueueInfo() returned an unexpected error: %v", err)
+	}
+	if info != (queueInfo{DiscardMaxBytes: 2199023255040}) {
+		t.Errorf("GetQueueInfo() = %+v", info)
+	}
+}
+
+func TestLiveZFSProvider_GetQueueInfo_Partition(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldPath := sysClassBlockPath
+	sysClassBlockPath = tmpDir
+	t.Cleanup(func() {
+		sysClassBlockPath = oldPath
+	})
+
+	// Like sysfs, the partition is a subdirectory of its disk and linked from the class directory.
+	diskDir := filepath.Join(tmpDir, "devices", "sda")
+	if err := os.MkdirAll(filepath.Join(diskDir, "queue"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(diskDir, "sda1"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range map[string]string{"queue/rotational": "1\n", "queue/discard_max_bytes": "0\n", "sda1/partition": "1\n"} {
+		if err := os.WriteFile(filepath.Join(diskDir, name), []byte(value), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(diskDir, "sda1"), filepath.Join(tmpDir, "sda1")); err != nil {
+		t.Fatal(err)
+	}
+	device := filepath.Join(t.TempDir(), "sda1")
+	if err := os.WriteFile(device, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := (&liveZFSProvider{}).GetQueueInfo(device)
+	if err != nil {
+		t.Fatalf("GetQueueInfo() returned an unexpected error: %v", err)
+	}
+	if info != (queueInfo{Rotational: true}) {
+		t.Errorf("GetQueueInfo() = %+v", info)
+	}
+}
+
+func TestLiveZFSProvider_GetDiskModel_Partition(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldPath := sysClassBlockPath
+	sysClassBlockPath = tmpDir
+	t.Cleanup(func() {
+		sysClassBlockPath = oldPath
+	})
+
+	diskDir := filepath.Join(tmpDir, "devices", "sdb")
+	if err := os.MkdirAll(filepath.Join(diskDir, "device"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(diskDir, "sdb1"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range map[string]string{"device/model": "WDC WD80EFZZ   \n", "sdb1/partition": "1\n"} {
+		if err := os.WriteFile(filepath.Join(diskDir, name), []byte(value), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(diskDir, "sdb1"), filepath.Join(tmpDir, "sdb1")); err != nil {
+		t.Fatal(err)
+	}
+	device := filepath.Join(t.TempDir(), "sdb1")
+	if err := os.WriteFile(device, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := (&liveZFSProvider{}).GetDiskModel(device)
+	if err != nil || model != "WDC WD80EFZZ" {
+		t.Errorf("GetDiskModel() = %q, %v; want the model of the partition's disk", model, err)
+	}
+}
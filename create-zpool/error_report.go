@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// Categories of the errors in an error report.
+const (
+	errorCategoryConfig  = "config"  // Invalid configuration, see configError.
+	errorCategoryZpool   = "zpool"   // A failed zpool command, see zpoolCommandError.
+	errorCategoryTimeout = "timeout" // An external command exceeded its deadline.
+	errorCategoryOther   = "other"
+)
+
+// zpoolErrorCodes are stable remediation codes for the typed causes of failed zpool commands,
+// for automation to act on instead of the hint text.
+var zpoolErrorCodes = map[error]string{
+	errDeviceInUse:          "device-in-use",
+	errForeignPool:          "foreign-pool",
+	errInsufficientReplicas: "insufficient-replicas",
+	errDeviceMissing:        "device-missing",
+	errDeviceTooSmall:       "device-too-small",
+	errMismatchedLayout:     "mismatched-layout",
+	errDeviceBusy:           "device-busy",
+	errPoolExists:           "pool-exists",
+}
+
+// poolError is an error of processing a single pool.
+type poolError struct {
+	Pool string
+	Err  error
+}
+
+func (e *poolError) Error() string { return fmt.Sprintf("pool %q: %v", e.Pool, e.Err) }
+func (e *poolError) Unwrap() error { return e.Err }
+
+// errorRecord is a single error of a failed run as written to the error report.
+type errorRecord struct {
+	Category string   `json:"category"`
+	Code     string   `json:"code,omitempty"` // Remediation code, see zpoolErrorCodes.
+	Pool     string   `json:"pool,omitempty"`
+	Devices  []string `json:"devices,omitempty"` // Devices of the failed zpool command.
+	Command  string   `json:"command,omitempty"` // The failed zpool subcommand, e.g. "create".
+	Output   string   `json:"output,omitempty"`  // Output of the failed zpool command.
+	Hint     string   `json:"hint,omitempty"`
+	Message  string   `json:"message"`
+}
+
+// errorReport is the machine-readable document describing a failed run.
+type errorReport struct {
+	Time     time.Time     `json:"time"`
+	Node     string        `json:"node"`
+	ExitCode int           `json:"exit_code"`
+	Errors   []errorRecord `json:"errors"`
+}
+
+// newErrorRecord classifies err for the error report.
+func newErrorRecord(err error) errorRecord {
+	record := errorRecord{Category: errorCategoryOther, Message: err.Error()}
+	var pe *poolError
+	if errors.As(err, &pe) {
+		record.Pool = pe.Pool
+	}
+	var cmdErr *zpoolCommandError
+	if errors.As(err, &cmdErr) {
+		record.Category, record.Code = errorCategoryZpool, zpoolErrorCodes[cmdErr.Cause]
+		record.Devices, record.Command, record.Output, record.Hint = cmdErr.Devices, cmdErr.Command, cmdErr.Output, cmdErr.Hint
+	}
+	switch {
+	case errors.As(err, new(configError)):
+		record.Category, record.Code = errorCategoryConfig, "invalid-config"
+	case errors.Is(err, errCommandTimeout):
+		record.Category, record.Code = errorCategoryTimeout, "command-timeout"
+	case errors.Is(err, errGUIDMismatch):
+		record.Code = "guid-mismatch"
+	}
+	return record
+}
+
+// newErrorReport returns the error report of a run that failed with errs and exit code.
+func newErrorReport(errs []error, code int) errorReport {
+	report := errorReport{Time: time.Now().UTC(), Node: nodeName(), ExitCode: code, Errors: []errorRecord{}}
+	for _, err := range errs {
+		report.Errors = append(report.Errors, newErrorRecord(err))
+	}
+	return report
+}
+
+// writeErrorReport writes report as a single line of JSON to w, and atomically to path unless
+// it is empty.
+func writeErrorReport(w io.Writer, path string, report errorReport) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if path == "" {
+		return nil
+	}
+	return writeFileAtomic(path, data)
+}
+
+// clearErrorReport removes the error report of an earlier failed run, so that a stale report
+// is never mistaken for the state of a node whose pools have converged since.
+func clearErrorReport(path string) error {
+	if path == "" {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewErrorRecord(t *testing.T) {
+	inUse := withDevices(newZpoolCommandError("create", errors.New("exit status 1"), []byte("/dev/sdb is in use and contains a ext4 filesystem")), []string{"/dev/sda", "/dev/sdb"})
+	timedOut := newZpoolCommandError("import", fmt.Errorf("%w after 5m0s: zpool import 123", errCommandTimeout), nil)
+
+	testCases := []struct {
+		name string
+		err  error
+		want errorRecord
+	}{
+		{"zpool error", &poolError{Pool: "tank", Err: inUse}, errorRecord{
+			Category: errorCategoryZpool, Code: "device-in-use", Pool: "tank", Devices: []string{"/dev/sda", "/dev/sdb"},
+			Command: "create", Output: "/dev/sdb is in use and contains a ext4 filesystem",
+		}},
+		{"configuration error", &poolError{Pool: "tank", Err: configError{errors.New("invalid ashift value")}}, errorRecord{
+			Category: errorCategoryConfig, Code: "invalid-config", Pool: "tank",
+		}},
+		{"timeout", &poolError{Pool: "tank", Err: timedOut}, errorRecord{Category: errorCategoryTimeout, Code: "command-timeout", Pool: "tank", Command: "import"}},
+		{"other", errors.New("failed to write PersistentVolume"), errorRecord{Category: errorCategoryOther}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := newErrorRecord(tc.err)
+			if got.Message != tc.err.Error() {
+				t.Errorf("Message = %q, want the error text %q", got.Message, tc.err.Error())
+			}
+			got.Message, got.Hint = "", ""
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Errorf("newErrorRecord() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+	if newErrorRecord(&poolError{Pool: "tank", Err: inUse}).Hint == "" {
+		t.Error("Expected the remediation hint of a recognized zpool error")
+	}
+}
+
+func TestWriteErrorReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "error.json")
+	var stderr bytes.Buffer
+	report := newErrorReport([]error{&poolError{Pool: "tank", Err: errors.New("no usable block devices found")}}, exitRetryable)
+	if err := writeErrorReport(&stderr, path, report); err != nil {
+		t.Fatalf("writeErrorReport() returned an unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, stderr.Bytes()) || bytes.Count(data, []byte("\n")) != 1 {
+		t.Errorf("Expected the same single line of JSON on stderr and in the file, got %q and %q", stderr.String(), data)
+	}
+	var got errorReport
+	if err := json.Unmarshal(data, &got); err != nil || got.ExitCode != exitRetryable || len(got.Errors) != 1 || got.Errors[0].Pool != "tank" {
+		t.Errorf("Unexpected report %+v, %v", got, err)
+	}
+
+	if err := clearErrorReport(path); err != nil {
+		t.Fatalf("clearErrorReport() returned an unexpected error: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the report to be removed, got %v", err)
+	}
+	if err := clearErrorReport(path); err != nil {
+		t.Errorf("Expected no error without a report, got %v", err)
+	}
+}
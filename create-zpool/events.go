@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Severities of notifications, from least to most severe.
+const (
+	severityInfo     = "info"
+	severityWarning  = "warning"
+	severityCritical = "critical"
+)
+
+// Events that notifications are sent for.
+const (
+	eventPoolUnhealthy    = "pool-unhealthy"     // A pool is no longer ONLINE or has data errors.
+	eventPoolHealthy      = "pool-healthy"       // An unhealthy pool is healthy again.
+	eventScrubErrors      = "scrub-errors"       // A scrub finished and found errors.
+	eventPoolCreateFailed = "pool-create-failed" // Creating, importing or reconciling a pool failed.
+	eventGUIDMismatch     = "pool-guid-mismatch" // A pool carries another GUID than the one pinned for its name.
+	eventPoolQuarantined  = "pool-quarantined"   // The configuration of a pool is invalid, see quarantinePools.
+)
+
+// notification is an event worth telling an operator about.
+type notification struct {
+	Time     time.Time `json:"time"`
+	Severity string    `json:"severity"`
+	Event    string    `json:"event"`
+	Node     string    `json:"node"` // Filled in by notifyAll if empty.
+	Pool     string    `json:"pool"`
+	Message  string    `json:"message"`
+}
+
+// summary returns a single line describing the notification, e.g. for a mail subject.
+func (n notification) summary() string {
+	return fmt.Sprintf("%s: pool %s: %s", strings.ToUpper(n.Severity), n.Pool, n.Event)
+}
+
+// poolEvents compares the pool statuses with the conditions already notified about, kept in
+// notified, and returns notifications for pools that became unhealthy, changed their unhealthy
+// state or recovered, and for scrubs that finished with errors. notified is updated, so that
+// every condition is only notified once, also across boots.
+func poolEvents(statuses map[string]*poolStatus, notified map[string]string, now time.Time) []notification {
+	var events []notification
+	for _, name := range slices.Sorted(maps.Keys(statuses)) {
+		status := statuses[name]
+		key := "health/" + name
+		if status.healthy() {
+			if _, ok := notified[key]; ok {
+				delete(notified, key)
+				events = append(events, notification{Time: now, Severity: severityInfo, Event: eventPoolHealthy, Pool: name,
+					Message: fmt.Sprintf("Pool %s is %s again.", name, status.State)})
+			}
+		} else if condition := status.State + "/" + status.ErrorCount; notified[key] != condition {
+			notified[key] = condition
+			severity := severityCritical
+			if status.State == "DEGRADED" || status.State == "ONLINE" {
+				severity = severityWarning
+			}
+			events = append(events, notification{Time: now, Severity: severity, Event: eventPoolUnhealthy, Pool: name,
+				Message: fmt.Sprintf("Pool %s is %s with %s data errors.\nStatus: %s\nAction: %s", name, status.State, status.ErrorCount, status.Status, status.Action)})
+		}
+
+		scan := status.Scan
+		if scan == nil || scan.Function != "SCRUB" || scan.State != "FINISHED" || scan.Errors == "" || scan.Errors == "0" {
+			continue
+		}
+		if key := "scrub/" + name; notified[key] != scan.EndTime {
+			notified[key] = scan.EndTime
+			events = append(events, notification{Time: now, Severity: severityCritical, Event: eventScrubErrors, Pool: name,
+				Message: fmt.Sprintf("The scrub of pool %s finished at %s with %s errors.", name, scan.EndTime, scan.Errors)})
+		}
+	}
+	return events
+}
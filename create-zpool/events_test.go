@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPoolEvents(t *testing.T) {
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	notified := make(map[string]string)
+	statuses := map[string]*poolStatus{
+		"tank": {Name: "tank", State: "DEGRADED", ErrorCount: "0", Status: "One or more devices has been removed."},
+		"fast": {Name: "fast", State: "ONLINE", ErrorCount: "0",
+			Scan: &scanStats{Function: "SCRUB", State: "FINISHED", EndTime: "Tue Oct 13 02:00:00 2026", Errors: "3"}},
+	}
+
+	events := poolEvents(statuses, notified, now)
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %+v", events)
+	}
+	if e := events[0]; e.Event != eventScrubErrors || e.Pool != "fast" || e.Severity != severityCritical || !strings.Contains(e.Message, "with 3 errors") {
+		t.Errorf("Unexpected scrub event %+v", e)
+	}
+	if e := events[1]; e.Event != eventPoolUnhealthy || e.Pool != "tank" || e.Severity != severityWarning || !strings.Contains(e.Message, "has been removed") {
+		t.Errorf("Unexpected health event %+v", e)
+	}
+
+	// Unchanged conditions are not notified again.
+	if events := poolEvents(statuses, notified, now); len(events) != 0 {
+		t.Errorf("Expected no repeated events, got %+v", events)
+	}
+
+	// A worse state is notified again, and recovery once.
+	statuses["tank"].State = "FAULTED"
+	if events := poolEvents(statuses, notified, now); len(events) != 1 || events[0].Severity != severityCritical {
+		t.Errorf("Expected a critical event for the faulted pool, got %+v", events)
+	}
+	statuses["tank"].State = "ONLINE"
+	events = poolEvents(statuses, notified, now)
+	if len(events) != 1 || events[0].Event != eventPoolHealthy || events[0].Severity != severityInfo {
+		t.Errorf("Expected a recovery event, got %+v", events)
+	}
+	if events := poolEvents(statuses, notified, now); len(events) != 0 {
+		t.Errorf("Expected no events for healthy pools, got %+v", events)
+	}
+
+	// A new scrub with errors is notified again.
+	statuses["fast"].Scan.EndTime = "Tue Oct 20 02:00:00 2026"
+	if events := poolEvents(statuses, notified, now); len(events) != 1 || events[0].Event != eventScrubErrors {
+		t.Errorf("Expected an event for the new scrub, got %+v", events)
+	}
+}
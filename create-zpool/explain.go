@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// classKeywords start the vdevs of an allocation class in the arguments of `zpool create`,
+// optionally followed by their type, e.g. "log mirror".
+var classKeywords = []string{"log", "cache", "special", "dedup", "spare"}
+
+// vdevKeywords start a new vdev in the arguments of `zpool create`; devices outside of one are
+// striped, each forming a vdev of its own. dRAID types with parameters, e.g. draid2:8d, are
+// matched by prefix.
+var vdevKeywords = slices.Concat(classKeywords, supportedVdevTypes)
+
+// createFlags are the options of `zpool create` without a value.
+var createFlags = []string{"-f", "-n"}
+
+// vdevGroup is a vdev in the arguments of `zpool create`.
+type vdevGroup struct {
+	Type    string // e.g. "mirror", "log mirror", or "stripe" for plain disks, each of which is a vdev.
+	Devices []string
+}
+
+// parseCreateArgs splits the arguments of `zpool create`, or those of `zpool add` and `zpool
+// attach`, into the options, the pool name and the vdevs.
+func parseCreateArgs(args []string) (opts [][2]string, name string, vdevs []vdevGroup) {
+	i := 0
+	if len(args) > 0 && slices.Contains([]string{"create", "add", "attach"}, args[0]) {
+		i = 1
+	}
+	for i < len(args) && strings.HasPrefix(args[i], "-") {
+		if slices.Contains(createFlags, args[i]) {
+			opts = append(opts, [2]string{args[i], ""})
+			i++
+			continue
+		}
+		if i+1 >= len(args) {
+			return opts, "", nil
+		}
+		opts = append(opts, [2]string{args[i], args[i+1]})
+		i += 2
+	}
+	if i >= len(args) {
+		return opts, "", nil
+	}
+	name = args[i]
+	for _, arg := range args[i+1:] {
+		last := len(vdevs) - 1
+		switch {
+		case last >= 0 && len(vdevs[last].Devices) == 0 && slices.Contains(classKeywords, vdevs[last].Type) &&
+			(slices.Contains(supportedVdevTypes, arg) || strings.HasPrefix(arg, "draid")):
+			vdevs[last].Type += " " + arg
+		case slices.Contains(vdevKeywords, arg) || strings.HasPrefix(arg, "draid"):
+			vdevs = append(vdevs, vdevGroup{Type: arg})
+		case len(vdevs) == 0:
+			vdevs = append(vdevs, vdevGroup{Type: "stripe", Devices: []string{arg}})
+		default:
+			vdevs[last].Devices = append(vdevs[last].Devices, arg)
+		}
+	}
+	return opts, name, vdevs
+}
+
+// describeVdevs summarizes vdevs, e.g. "2x mirror from 4 disks (/dev/sda, ...)". Consecutive
+// vdevs of the same type and width are counted together.
+func describeVdevs(vdevs []vdevGroup) string {
+	var parts []string
+	for i := 0; i < len(vdevs); {
+		j := i + 1
+		for j < len(vdevs) && vdevs[j].Type == vdevs[i].Type && len(vdevs[j].Devices) == len(vdevs[i].Devices) {
+			j++
+		}
+		var devices []string
+		for _, vdev := range vdevs[i:j] {
+			devices = append(devices, vdev.Devices...)
+		}
+		kind := vdevs[i].Type
+		if j-i > 1 {
+			kind = fmt.Sprintf("%dx %s", j-i, kind)
+		}
+		parts = append(parts, fmt.Sprintf("%s from %s (%s)", kind, pluralize(len(devices), "disk"), strings.Join(devices, ", ")))
+		i = j
+	}
+	return strings.Join(parts, " plus ")
+}
+
+// pluralize returns e.g. "1 disk" or "4 disks".
+func pluralize(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// formatSize formats a byte count with binary units, e.g. "20 GiB" or "1.5 TiB".
+func formatSize(size uint64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+	value, unit := float64(size), 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	return strings.TrimSuffix(fmt.Sprintf("%.1f", value), ".0") + " " + units[unit]
+}
+
+// explainCreate narrates a `zpool create`, e.g. "create mirror from 2 disks (...), ashift 12,
+// mounted at /var/mnt/tank".
+func explainCreate(args []string) string {
+	opts, _, vdevs := parseCreateArgs(args)
+	parts := []string{"create " + describeVdevs(vdevs)}
+	var staged string
+	for _, opt := range opts {
+		switch opt[0] {
+		case "-m":
+			if opt[1] == "none" || opt[1] == "legacy" {
+				parts = append(parts, "mountpoint "+opt[1])
+			} else {
+				parts = append(parts, "mounted at "+opt[1])
+			}
+		case "-R":
+			staged = "staged under " + opt[1] + " and imported at its mountpoints once validated"
+		case "-f":
+			parts = append(parts, "forcing vdevs of different redundancy")
+		case "-o", "-O":
+			prop, value, _ := strings.Cut(opt[1], "=")
+			parts = append(parts, prop+" "+value)
+		}
+	}
+	if staged != "" {
+		parts = append(parts, staged)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// explainAshift returns the ashift among the options of a `zpool add` or `zpool attach`, e.g.
+// ", ashift 12", or nothing without one.
+func explainAshift(opts [][2]string) string {
+	for _, opt := range opts {
+		if ashift, ok := strings.CutPrefix(opt[1], "ashift="); ok && opt[0] == "-o" {
+			return ", ashift " + ashift
+		}
+	}
+	return ""
+}
+
+// explainAction narrates a single plan action in plain language.
+func explainAction(action planAction) string {
+	switch action.Action {
+	case "create":
+		return explainCreate(action.Args)
+	case "import":
+		return "import the exported pool with id " + action.ID
+	case "import-readonly":
+		return "import the pool with id " + action.ID + " read-only for recovery"
+	case "export":
+		return "export the pool"
+	case "clear":
+		return "clear the pool errors to resume it"
+	case "add":
+		opts, _, vdevs := parseCreateArgs(action.Args)
+		return "add " + describeVdevs(vdevs) + explainAshift(opts)
+	case "attach":
+		if n := len(action.Args); n >= 4 {
+			opts, _, _ := parseCreateArgs(action.Args)
+			return fmt.Sprintf("attach %s to %s, mirroring it", action.Args[n-1], action.Args[n-2]) + explainAshift(opts)
+		}
+	case "detach":
+		if n := len(action.Args); n >= 3 {
+			return fmt.Sprintf("detach %s from its mirror", action.Args[n-1])
+		}
+	case "remove":
+		if n := len(action.Args); n >= 3 {
+			return fmt.Sprintf("remove %s from the pool", action.Args[n-1])
+		}
+	case "set-property":
+		return fmt.Sprintf("set pool property %s to %s", action.Property, action.Value)
+	case "set-dataset-property":
+		return fmt.Sprintf("set property %s of dataset %s to %s", action.Property, action.Dataset, action.Value)
+	case "initialize":
+		return "start initializing (zeroing) all disks"
+	case "discard":
+		return "discard all blocks of " + action.Device
+	case "secure-discard":
+		return "securely erase " + action.Device
+	case "set-ownership":
+		return fmt.Sprintf("set %s on %s", action.Value, action.Path)
+	case "set-module-parameter":
+		return fmt.Sprintf("set ZFS module parameter %s to %s", action.Property, action.Value)
+	case "load-keys":
+		return "load the encryption keys"
+	case "mount":
+		return "mount dataset " + action.Dataset
+	case "snapshot":
+		return "take snapshot " + action.Dataset
+	case "checkpoint":
+		return "take a pool checkpoint"
+	case "discard-checkpoint":
+		return "discard the pool checkpoint"
+	case "upgrade":
+		return "enable all supported pool features, which cannot be undone"
+	case "create-volume":
+		text := "create zvol " + action.Dataset
+		if size, err := strconv.ParseUint(action.Value, 10, 64); err == nil {
+			text += " of " + formatSize(size)
+		}
+		var props []string
+		for i := 1; i < len(action.Args); i += 2 {
+			props = append(props, action.Args[i])
+		}
+		if len(props) > 0 {
+			text += " with " + strings.Join(props, ", ")
+		}
+		return text
+	case "format-swap":
+		return "format " + action.Device + " as swap"
+	case "enable-swap":
+		return "enable swap on " + action.Device
+	}
+	return action.Action
+}
+
+// writeExplanation writes the plan as one sentence per action, prefixed with the pool it
+// belongs to. pools lists all configured pools, so those without changes are named as well.
+func writeExplanation(w io.Writer, doc plan, pools []string) {
+	changed := make(map[string]bool)
+	for _, action := range doc.Actions {
+		changed[action.Pool] = true
+		if action.Pool == "" {
+			fmt.Fprintf(w, "node: %s\n", explainAction(action))
+		} else {
+			fmt.Fprintf(w, "pool %s: %s\n", action.Pool, explainAction(action))
+		}
+	}
+	for _, pool := range pools {
+		if !changed[pool] {
+			fmt.Fprintf(w, "pool %s: no changes\n", pool)
+		}
+	}
+	for _, err := range doc.Errors {
+		fmt.Fprintf(w, "error: %s\n", err)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExplainCreate(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "mirrors",
+			args: []string{"create", "-m", "/var/mnt/tank", "-o", "ashift=12", "-O", "compression=zstd", "tank", "mirror", "/dev/sda", "/dev/sdb", "mirror", "/dev/sdc", "/dev/sdd"},
+			want: "create 2x mirror from 4 disks (/dev/sda, /dev/sdb, /dev/sdc, /dev/sdd), mounted at /var/mnt/tank, ashift 12, compression zstd",
+		},
+		{
+			name: "stripe",
+			args: []string{"create", "-m", "none", "scratch", "/dev/sda", "/dev/sdb"},
+			want: "create stripe from 2 disks (/dev/sda, /dev/sdb), mountpoint none",
+		},
+		{
+			name: "draid staged",
+			args: []string{"create", "-R", "/var/lib/create-zpool/staging", "-m", "/var/mnt/archive", "archive", "draid2", "/dev/sda", "/dev/sdb", "/dev/sdc", "/dev/sdd"},
+			want: "create draid2 from 4 disks (/dev/sda, /dev/sdb, /dev/sdc, /dev/sdd), mounted at /var/mnt/archive, staged under /var/lib/create-zpool/staging and imported at its mountpoints once validated",
+		},
+		{
+			name: "mixed widths",
+			args: []string{"create", "tank", "mirror", "/dev/sda", "/dev/sdb", "mirror", "/dev/sdc", "/dev/sdd", "/dev/sde"},
+			want: "create mirror from 2 disks (/dev/sda, /dev/sdb) plus mirror from 3 disks (/dev/sdc, /dev/sdd, /dev/sde)",
+		},
+		{
+			name: "forced",
+			args: []string{"create", "-m", "none", "-f", "-o", "ashift=12", "tank", "raidz2", "/dev/sda", "/dev/sdb", "/dev/sdc", "/dev/sdd", "mirror", "/dev/sde", "/dev/sdf"},
+			want: "create raidz2 from 4 disks (/dev/sda, /dev/sdb, /dev/sdc, /dev/sdd) plus mirror from 2 disks (/dev/sde, /dev/sdf), mountpoint none, forcing vdevs of different redundancy, ashift 12",
+		},
+		{
+			name: "allocation classes",
+			args: []string{"create", "tank", "mirror", "/dev/sda", "/dev/sdb", "log", "/dev/nvme0n1", "special", "mirror", "/dev/nvme1n1", "/dev/nvme2n1"},
+			want: "create mirror from 2 disks (/dev/sda, /dev/sdb) plus log from 1 disk (/dev/nvme0n1) plus special mirror from 2 disks (/dev/nvme1n1, /dev/nvme2n1)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := explainCreate(tt.args); got != tt.want {
+				t.Errorf("explainCreate() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := map[uint64]string{
+		512:           "512 B",
+		4 << 10:       "4 KiB",
+		20 << 30:      "20 GiB",
+		1<<30 + 1<<20: "1 GiB",
+		1536 << 20:    "1.5 GiB",
+		3 << 39:       "1.5 TiB",
+	}
+	for size, want := range tests {
+		if got := formatSize(size); got != want {
+			t.Errorf("formatSize(%d) = %q, want %q", size, got, want)
+		}
+	}
+}
+
+func TestWritePlan_Explain(t *testing.T) {
+	planner := newPlanningProvider(&mockZFSProvider{})
+	planner.explain = true
+	planner.pools = []string{"tank", "data", "backup"}
+	if err := planner.WriteModuleParameter("zfs_arc_max", "17179869184"); err != nil {
+		t.Fatal(err)
+	}
+	planner.setPool("tank")
+	planner.CreatePool(t.Context(), "/fake/zpool", []string{"create", "-m", "/var/mnt/tank", "-o", "ashift=12", "tank", "mirror", "/dev/sda", "/dev/sdb"})
+	planner.CreateVolume(t.Context(), "/fake/zfs", "tank/swap", 8<<30, map[string]string{"volblocksize": "4K"})
+	planner.setPool("data")
+	planner.ImportPool(t.Context(), "/fake/zpool", "5093713158247845377")
+	planner.SetPoolProperty(t.Context(), "/fake/zpool", "data", "autotrim", "on")
+	planner.AttachDevice(t.Context(), "/fake/zpool", "data", "/dev/nvme0n1", "/dev/nvme1n1", "12")
+	planner.AddVdevs(t.Context(), "/fake/zpool", []string{"add", "-o", "ashift=12", "data", "log", "mirror", "/dev/nvme2n1", "/dev/nvme3n1"})
+
+	path := filepath.Join(t.TempDir(), "plan.txt")
+	if err := planner.writePlan(path, []error{errors.New(`pool "backup": no disks found`)}); err != nil {
+		t.Fatalf("writePlan() returned an unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"node: set ZFS module parameter zfs_arc_max to 17179869184",
+		"pool tank: create mirror from 2 disks (/dev/sda, /dev/sdb), mounted at /var/mnt/tank, ashift 12",
+		"pool tank: create zvol tank/swap of 8 GiB with volblocksize=4K",
+		"pool data: import the exported pool with id 5093713158247845377",
+		"pool data: set pool property autotrim to on",
+		"pool data: attach /dev/nvme1n1 to /dev/nvme0n1, mirroring it, ashift 12",
+		"pool data: add log mirror from 2 disks (/dev/nvme2n1, /dev/nvme3n1), ashift 12",
+		"pool backup: no changes",
+		`error: pool "backup": no disks found`,
+		"",
+	}, "\n")
+	if string(data) != want {
+		t.Errorf("Explanation =\n%s\nwant\n%s", data, want)
+	}
+}
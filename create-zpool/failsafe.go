@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// settingsCheck handles invalid global settings of a run, see ZPOOL_FAIL_SAFE. Without fail-safe
+// mode an invalid setting ends the run with exitConfigError before any pool is touched; in
+// fail-safe mode its built-in default is used instead and the error is reported with the run,
+// so that a single typo does not keep all pools of the node from being imported.
+type settingsCheck struct {
+	failSafe bool
+	exit     func(int) // Ends the run with an exit code.
+	errs     []error   // Settings replaced by their defaults.
+}
+
+// invalid reports an invalid setting. It only returns in fail-safe mode, in which the caller
+// goes on with the default.
+func (c *settingsCheck) invalid(msg string, err error) {
+	if !c.failSafe {
+		slog.Error(msg, "error", err)
+		c.exit(exitConfigError)
+		return
+	}
+	slog.Error(msg+", using the default in fail-safe mode", "error", err)
+	c.errs = append(c.errs, configError{fmt.Errorf("%s: %w", msg, err)})
+}
+
+// quarantinePools separates the pool configurations that are invalid in themselves, found
+// without looking at the system, from the valid ones in fail-safe mode. The quarantined pools
+// are not processed at all: they are returned as errors and alerted about with notifications,
+// while the valid pools are imported and created as usual.
+func quarantinePools(configs []poolConfig) (valid []poolConfig, errs []error, notifications []notification) {
+	for _, config := range configs {
+		err := validatePoolConfig(config)
+		if err == nil {
+			valid = append(valid, config)
+			continue
+		}
+		slog.Error("Quarantined invalid pool configuration, not touching the pool", "pool", config.Name, "error", err)
+		errs = append(errs, &poolError{Pool: config.Name, Err: err})
+		notifications = append(notifications, notification{Time: time.Now(), Severity: severityCritical, Event: eventPoolQuarantined, Pool: config.Name,
+			Message: fmt.Sprintf("The configuration of pool %s is invalid and was quarantined, the other pools are processed: %v", config.Name, err)})
+	}
+	return valid, errs, notifications
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSettingsCheck(t *testing.T) {
+	exitCode := -1
+	strict := &settingsCheck{exit: func(code int) { exitCode = code }}
+	strict.invalid("Invalid busy retries", errors.New(`invalid non-negative integer "x" for ZPOOL_BUSY_RETRIES`))
+	if exitCode != exitConfigError || len(strict.errs) != 0 {
+		t.Errorf("Expected the run to end with %d, got %d and %v", exitConfigError, exitCode, strict.errs)
+	}
+
+	exitCode = -1
+	failSafe := &settingsCheck{failSafe: true, exit: func(code int) { exitCode = code }}
+	failSafe.invalid("Invalid busy retries", errors.New(`invalid non-negative integer "x" for ZPOOL_BUSY_RETRIES`))
+	if exitCode != -1 {
+		t.Errorf("Expected the run to go on in fail-safe mode, got exit code %d", exitCode)
+	}
+	if len(failSafe.errs) != 1 || !errors.As(failSafe.errs[0], new(configError)) {
+		t.Errorf("Expected the setting to be reported as a configuration error, got %v", failSafe.errs)
+	}
+}
+
+func TestQuarantinePools(t *testing.T) {
+	configs := []poolConfig{
+		{Name: "tank", Ashift: "12"},
+		{Name: "data", Ashift: "12", Type: "raidz9"},
+		{Name: "backup", Ashift: "12", ParseErrors: []error{errors.New("invalid ZPOOL_2_ERASE")}},
+	}
+	valid, errs, notifications := quarantinePools(configs)
+	if len(valid) != 1 || valid[0].Name != "tank" {
+		t.Errorf("Expected only tank to be processed, got %+v", valid)
+	}
+	if len(errs) != 2 || len(notifications) != 2 {
+		t.Fatalf("Expected 2 quarantined pools, got %v and %+v", errs, notifications)
+	}
+	var pe *poolError
+	if !errors.As(errs[0], &pe) || pe.Pool != "data" || !errors.As(errs[0], new(configError)) {
+		t.Errorf("Expected a configuration error of pool data, got %v", errs[0])
+	}
+	if n := notifications[1]; n.Pool != "backup" || n.Event != eventPoolQuarantined || n.Severity != severityCritical {
+		t.Errorf("Unexpected notification %+v", n)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Policies for a pool whose GUID differs from the one pinned for its name, see ZPOOL_GUID_MISMATCH.
+const (
+	guidMismatchRefuse = "refuse" // Fail the pool without touching it.
+	guidMismatchWarn   = "warn"   // Alert, but manage the pool anyway and keep the old pin.
+	guidMismatchAccept = "accept" // Pin the new GUID, e.g. after intentionally recreating the pool.
+)
+
+// guidMismatchPolicies lists the supported policies for mismatching pool GUIDs.
+var guidMismatchPolicies = []string{guidMismatchRefuse, guidMismatchWarn, guidMismatchAccept}
+
+// errGUIDMismatch is returned (wrapped) for a pool refused because of a mismatching GUID.
+var errGUIDMismatch = errors.New("the pool GUID does not match the pinned GUID")
+
+// isValidGUIDMismatchPolicy checks if the given policy for mismatching GUIDs is supported.
+func isValidGUIDMismatchPolicy(policy string) bool {
+	return slices.Contains(guidMismatchPolicies, policy)
+}
+
+// pinGUID records guid as the GUID of the pool name, to be saved to the state file by
+// updateGUIDState.
+func (s *runState) pinGUID(name, guid string) {
+	if s.pinnedGUIDs == nil {
+		return
+	}
+	if s.pinnedGUIDs[name] != guid {
+		s.pinnedGUIDs[name] = guid
+		s.guidsChanged = true
+	}
+}
+
+// verifyPoolGUID checks the GUID of the pool found under the name of config against the GUID
+// pinned for that name when it was created or first seen, so that a different pool, e.g. on
+// disks moved over from another node, is never mistaken for the expected one. A pool seen for
+// the first time is pinned. A mismatch is alerted about and handled as configured by
+// guidPolicy; only refuse fails the pool.
+func (s *runState) verifyPoolGUID(name, guid string) error {
+	if s.pinnedGUIDs == nil || guid == "" {
+		return nil
+	}
+	pinned, ok := s.pinnedGUIDs[name]
+	if !ok {
+		slog.Info("Pinned pool GUID", "pool", name, "guid", guid)
+		s.pinGUID(name, guid)
+		return nil
+	}
+	if pinned == guid {
+		return nil
+	}
+
+	if s.guidPolicy == guidMismatchAccept {
+		slog.Warn("Pool GUID changed, pinning the new one", "pool", name, "guid", guid, "pinned_guid", pinned)
+		s.pinGUID(name, guid)
+		return nil
+	}
+	message := fmt.Sprintf("The pool named %s has GUID %s, but GUID %s is pinned for this name: a different pool, e.g. on disks moved over from another node, is using the name.", name, guid, pinned)
+	slog.Error("Pool GUID does not match the pinned GUID", "pool", name, "guid", guid, "pinned_guid", pinned, "policy", s.guidPolicy,
+		"hint", "If the pool was replaced on purpose, run once with ZPOOL_GUID_MISMATCH=accept to pin the new GUID.")
+	s.notifications = append(s.notifications, notification{Time: time.Now(), Severity: severityCritical, Event: eventGUIDMismatch, Pool: name, Message: message})
+	if s.guidPolicy == guidMismatchWarn {
+		return nil
+	}
+	return fmt.Errorf("%w: pool with GUID %s found, GUID %s is pinned; set ZPOOL_GUID_MISMATCH=accept once if the pool was replaced on purpose", errGUIDMismatch, guid, pinned)
+}
+
+// updateGUIDState saves the pins of a run to the state file in stateDir.
+func updateGUIDState(stateDir string, pinned map[string]string) error {
+	st, err := loadState(stateDir)
+	if err != nil {
+		return err
+	}
+	if maps.Equal(st.GUIDs, pinned) {
+		return nil
+	}
+	st.GUIDs = pinned
+	return saveState(stateDir, st)
+}
+
+// pinCreatedPool pins the GUID of a pool created in this run. A GUID that cannot be read is
+// pinned the next time the pool is seen instead.
+func (s *runState) pinCreatedPool(ctx context.Context, provider zfsProvider, zpoolPath, name string) {
+	if s.pinnedGUIDs == nil || s.dryRun {
+		return
+	}
+	props, err := provider.GetPoolProperties(ctx, zpoolPath, name, []string{"guid"})
+	if err != nil || props["guid"] == "" {
+		slog.Warn("Failed to read the GUID of the new pool, pinning it on the next run", "pool", name, "error", err)
+		return
+	}
+	slog.Info("Pinned pool GUID", "pool", name, "guid", props["guid"])
+	s.pinGUID(name, props["guid"])
+}
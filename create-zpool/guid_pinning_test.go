@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"testing"
+)
+
+func TestVerifyPoolGUID(t *testing.T) {
+	testCases := []struct {
+		policy    string
+		guid      string
+		wantErr   bool
+		wantPin   string
+		wantAlert bool
+	}{
+		{guidMismatchRefuse, "111", false, "111", false},
+		{guidMismatchRefuse, "222", true, "111", true},
+		{guidMismatchWarn, "222", false, "111", true},
+		{guidMismatchAccept, "222", false, "222", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.policy+"/"+tc.guid, func(t *testing.T) {
+			state := newRunState(map[string]string{"tank": tc.guid})
+			state.pinnedGUIDs, state.guidPolicy = map[string]string{"tank": "111"}, tc.policy
+
+			err := state.verifyPoolGUID("tank", tc.guid)
+			if (err != nil) != tc.wantErr || (err != nil && !errors.Is(err, errGUIDMismatch)) {
+				t.Fatalf("verifyPoolGUID() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if state.pinnedGUIDs["tank"] != tc.wantPin {
+				t.Errorf("Pinned GUID = %s, want %s", state.pinnedGUIDs["tank"], tc.wantPin)
+			}
+			if alerted := len(state.notifications) == 1 && state.notifications[0].Event == eventGUIDMismatch; alerted != tc.wantAlert {
+				t.Errorf("Expected an alert: %v, got %+v", tc.wantAlert, state.notifications)
+			}
+		})
+	}
+
+	state := newRunState(nil)
+	state.pinnedGUIDs = map[string]string{}
+	if err := state.verifyPoolGUID("data", "333"); err != nil || state.pinnedGUIDs["data"] != "333" || !state.guidsChanged {
+		t.Errorf("Expected a pool seen for the first time to be pinned, got %v, %v", err, state.pinnedGUIDs)
+	}
+}
+
+func TestCreatePool_GUIDMismatch(t *testing.T) {
+	// The name is taken by an imported pool that is not the one created on this node.
+	provider := &mockZFSProvider{
+		SetPoolPropertyFunc: func(ctx context.Context, zpoolPath, name, prop, value string) ([]byte, error) {
+			t.Fatalf("A pool with a mismatching GUID must not be touched, got %s=%s", prop, value)
+			return nil, nil
+		},
+	}
+	state := newRunState(map[string]string{"tank": "222"})
+	state.pinnedGUIDs, state.guidPolicy = map[string]string{"tank": "111"}, guidMismatchRefuse
+	config := poolConfig{Name: "tank", Type: "mirror", Disks: []diskSpec{{Dev: "/dev/sda"}, {Dev: "/dev/sdb"}}, Ashift: "12", Reconcile: []string{"autotrim"}, Properties: map[string]string{"autotrim": "on"}}
+	if err := createPool(t.Context(), provider, "/fake/zpool", config, state); !errors.Is(err, errGUIDMismatch) {
+		t.Fatalf("Expected errGUIDMismatch, got %v", err)
+	}
+	if record := newErrorRecord(&poolError{Pool: "tank", Err: errGUIDMismatch}); record.Code != "guid-mismatch" {
+		t.Errorf("Expected the guid-mismatch code in the error report, got %+v", record)
+	}
+}
+
+func TestImportExportedPool_GUIDMismatch(t *testing.T) {
+	provider := &mockZFSProvider{
+		ListImportablePoolsFunc: func(ctx context.Context, zpoolPath string) ([]importablePool, error) {
+			return []importablePool{{Name: "tank", ID: "222", State: "ONLINE"}}, nil
+		},
+		ImportPoolFunc: func(ctx context.Context, zpoolPath, id string) ([]byte, error) {
+			t.Fatalf("A pool with a mismatching GUID must not be imported, got %s", id)
+			return nil, nil
+		},
+	}
+	state := newRunState(nil)
+	state.pinnedGUIDs, state.guidPolicy = map[string]string{"tank": "111"}, guidMismatchRefuse
+	if _, err := importExportedPool(t.Context(), provider, "/fake/zpool", poolConfig{Name: "tank"}, state); !errors.Is(err, errGUIDMismatch) {
+		t.Fatalf("Expected errGUIDMismatch, got %v", err)
+	}
+}
+
+func TestCreatePool_PinsCreatedPool(t *testing.T) {
+	provider := &mockZFSProvider{
+		GetPoolPropertiesFunc: func(ctx context.Context, zpoolPath, name string, props []string) (map[string]string, error) {
+			return map[string]string{"guid": "444"}, nil
+		},
+	}
+	state := newRunState(nil)
+	state.pinnedGUIDs = map[string]string{"tank": "111"}
+	config := poolConfig{Name: "tank", Type: "mirror", Disks: []diskSpec{{Dev: "/dev/sda"}, {Dev: "/dev/sdb"}}, Ashift: "12"}
+	if err := createPool(t.Context(), provider, "/fake/zpool", config, state); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	if state.pinnedGUIDs["tank"] != "444" {
+		t.Errorf("Expected the GUID of the new pool to be pinned, got %v", state.pinnedGUIDs)
+	}
+
+	dir := t.TempDir()
+	if err := updateGUIDState(dir, state.pinnedGUIDs); err != nil {
+		t.Fatal(err)
+	}
+	st, err := loadState(dir)
+	if err != nil || !maps.Equal(st.GUIDs, state.pinnedGUIDs) {
+		t.Errorf("Expected the pins to be saved, got %v, %v", st.GUIDs, err)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const defaultHeartbeatInterval = time.Minute // How often watch mode updates the heartbeat file, see ZPOOL_HEARTBEAT_INTERVAL.
+
+// heartbeat is the JSON document written to ZPOOL_HEARTBEAT_FILE in watch mode. It is written
+// from the watch loop itself, so a process that is alive but wedged stops updating it.
+type heartbeat struct {
+	Time      time.Time `json:"time"`                // When the heartbeat was written.
+	Started   time.Time `json:"started"`             // When the process started and ran the configured pools.
+	LastCheck time.Time `json:"last_check,omitzero"` // When the pools were last checked.
+	NextCheck time.Time `json:"next_check,omitzero"` // When the pools are checked next.
+	Paused    bool      `json:"paused"`              // Checks are skipped because of the pause file.
+	PID       int       `json:"pid"`
+}
+
+// writeHeartbeat atomically replaces path with hb, which also updates its modification time
+// for monitors that only look at the age of the file.
+func writeHeartbeat(path string, hb heartbeat) error {
+	data, err := json.Marshal(hb)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
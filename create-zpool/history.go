@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const (
+	historyTimeLayout = "2006-01-02.15:04:05" // Timestamp format of `zpool history`.
+	maxHistoryEntries = 1000                  // History entries kept per pool in the state file.
+)
+
+// historyEntry is a single entry of `zpool history -il`.
+type historyEntry struct {
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"` // The command or internal event, followed by the user and host.
+}
+
+// internal reports whether the entry is an internal event rather than a command.
+func (e historyEntry) internal() bool {
+	return strings.HasPrefix(e.Command, "[internal ")
+}
+
+// parsePoolHistory parses the output of `zpool history -il`. Lines without a timestamp, like
+// the "History for" header, are skipped. zpool prints local time.
+func parsePoolHistory(output string) []historyEntry {
+	var entries []historyEntry
+	for line := range strings.SplitSeq(output, "\n") {
+		timestamp, command, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			continue
+		}
+		t, err := time.ParseInLocation(historyTimeLayout, timestamp, time.Local)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, historyEntry{Time: t, Command: command})
+	}
+	return entries
+}
+
+// newHistoryEntries returns the entries of history that this process is responsible for:
+// those after the newest entry already stored and not older than since, the start of the
+// process. Commands issued by an administrator between runs are therefore not recorded.
+func newHistoryEntries(stored, history []historyEntry, since time.Time) []historyEntry {
+	start := 0
+	if n := len(stored); n > 0 {
+		last := stored[n-1]
+		for i := len(history) - 1; i >= 0; i-- {
+			if history[i].Time.Equal(last.Time) && history[i].Command == last.Command {
+				start = i + 1
+				break
+			}
+		}
+	}
+	// The history has a resolution of seconds.
+	cutoff := since.Truncate(time.Second)
+	var entries []historyEntry
+	for _, e := range history[start:] {
+		if !e.Time.Before(cutoff) {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// recordPoolHistory appends the commands this process issued on the named pools, and the
+// internal events they caused, from `zpool history` to the state file and the log. This
+// keeps a durable audit trail of what was done to each pool and when, also for pools that are
+// no longer configured. The newest maxHistoryEntries entries are kept per pool.
+func recordPoolHistory(ctx context.Context, provider zfsProvider, zpoolPath, stateDir string, names []string, since time.Time) {
+	st, err := loadState(stateDir)
+	if err != nil {
+		slog.Warn("Failed to load state, not recording pool history", "state_dir", stateDir, "error", err)
+		return
+	}
+
+	changed := false
+	for _, name := range names {
+		output, err := provider.GetPoolHistory(ctx, zpoolPath, name)
+		if err != nil {
+			// Pools that do not exist are already reported by the health check.
+			continue
+		}
+		entries := newHistoryEntries(st.History[name], parsePoolHistory(string(output)), since)
+		if len(entries) == 0 {
+			continue
+		}
+		for _, e := range entries {
+			if e.internal() {
+				slog.Debug("Pool history", "pool", name, "time", e.Time, "event", e.Command)
+			} else {
+				slog.Info("Pool history", "pool", name, "time", e.Time, "command", e.Command)
+			}
+		}
+		history := append(st.History[name], entries...)
+		if len(history) > maxHistoryEntries {
+			history = history[len(history)-maxHistoryEntries:]
+		}
+		st.History[name] = history
+		changed = true
+	}
+	if changed {
+		if err := saveState(stateDir, st); err != nil {
+			slog.Warn("Failed to save pool history", "state_dir", stateDir, "error", err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+const testPoolHistory = `History for 'tank':
+2026-10-13.08:00:00 zpool create -o ashift=12 tank mirror /dev/sda /dev/sdb [user 0 (root) on node1:linux]
+2026-10-14.09:59:59 zpool set comment=manual tank [user 0 (root) on node1:linux]
+2026-10-14.10:00:00 zpool import -N 1234 [user 0 (root) on node1:linux]
+2026-10-14.10:00:01 [internal pool import txg:42] pool spa 5000; zfs spa 5000; zpl 5 [user 0 (root) on node1]
+2026-10-14.10:00:05 zpool set autotrim=on tank [user 0 (root) on node1:linux]
+
+`
+
+func TestParsePoolHistory(t *testing.T) {
+	entries := parsePoolHistory(testPoolHistory)
+	if len(entries) != 5 {
+		t.Fatalf("Expected 5 entries, got %+v", entries)
+	}
+	want := time.Date(2026, 10, 14, 10, 0, 1, 0, time.Local)
+	if !entries[3].Time.Equal(want) || !entries[3].internal() || entries[2].internal() {
+		t.Errorf("Unexpected entries %+v", entries[2:4])
+	}
+	if entries[4].Command != "zpool set autotrim=on tank [user 0 (root) on node1:linux]" {
+		t.Errorf("Unexpected command %q", entries[4].Command)
+	}
+}
+
+func TestNewHistoryEntries(t *testing.T) {
+	history := parsePoolHistory(testPoolHistory)
+	since := time.Date(2026, 10, 14, 10, 0, 0, 500, time.Local)
+
+	// Without stored entries, only those since the start of the process are new.
+	if got := newHistoryEntries(nil, history, since); len(got) != 3 || got[0].Command != history[2].Command {
+		t.Errorf("Unexpected new entries %+v", got)
+	}
+	// Stored entries are not recorded again, even with a different location.
+	stored := []historyEntry{{Time: history[3].Time.UTC(), Command: history[3].Command}}
+	if got := newHistoryEntries(stored, history, since); len(got) != 1 || got[0] != history[4] {
+		t.Errorf("Unexpected new entries %+v", got)
+	}
+	// Entries older than the process are not recorded either, like commands between runs.
+	stored = []historyEntry{history[0]}
+	if got := newHistoryEntries(stored, history, since); len(got) != 3 {
+		t.Errorf("Unexpected new entries %+v", got)
+	}
+}
+
+func TestRecordPoolHistory(t *testing.T) {
+	stateDir := t.TempDir()
+	output := testPoolHistory
+	mockProvider := &mockZFSProvider{
+		GetPoolHistoryFunc: func(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+			if name != "tank" {
+				return nil, errors.New("no such pool")
+			}
+			return []byte(output), nil
+		},
+	}
+	if err := saveState(stateDir, &persistentState{History: map[string][]historyEntry{"old": {{Command: "zpool create old"}}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	since := time.Date(2026, 10, 14, 10, 0, 0, 0, time.Local)
+	names := []string{"tank", "missing"}
+	recordPoolHistory(t.Context(), mockProvider, "/fake/zpool", stateDir, names, since)
+	output += "2026-10-14.10:05:00 zpool clear tank sda [user 0 (root) on node1:linux]\n"
+	recordPoolHistory(t.Context(), mockProvider, "/fake/zpool", stateDir, names, since)
+
+	st, err := loadState(stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.History["old"]) != 1 {
+		t.Errorf("Expected the history of unconfigured pools to be kept, got %+v", st.History["old"])
+	}
+	got := st.History["tank"]
+	if len(got) != 4 || got[0].Command != "zpool import -N 1234 [user 0 (root) on node1:linux]" || got[3].Command != "zpool clear tank sda [user 0 (root) on node1:linux]" {
+		t.Errorf("Unexpected recorded history %+v", got)
+	}
+}
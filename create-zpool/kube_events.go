@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const kubeEventComponent = "zpool-creator" // Reporting component of the Kubernetes Events.
+
+// kubeEventNotifier creates Kubernetes Events on the Node object, so that pool events show up
+// in `kubectl describe node` and in event based alerting. The service runs outside of any pod,
+// so the API server and credentials are configured explicitly, e.g. with the kubelet client
+// certificate, which may create events for its own node.
+type kubeEventNotifier struct {
+	Server    string // Base URL of the API server, e.g. https://10.0.0.1:6443.
+	Namespace string
+	Node      string // Name of the Node object the events are about.
+	client    *http.Client
+	token     string
+}
+
+// parseKubeEventSettings reads ZPOOL_K8S_EVENTS_SERVER, ZPOOL_K8S_EVENTS_CA_FILE, either
+// ZPOOL_K8S_EVENTS_TOKEN_FILE or ZPOOL_K8S_EVENTS_CERT_FILE and ZPOOL_K8S_EVENTS_KEY_FILE,
+// ZPOOL_K8S_EVENTS_NAMESPACE and ZPOOL_K8S_EVENTS_NODE. An unset server disables the sink.
+func parseKubeEventSettings() (*kubeEventNotifier, error) {
+	server := strings.TrimSpace(os.Getenv("ZPOOL_K8S_EVENTS_SERVER"))
+	if server == "" {
+		return nil, nil
+	}
+	if u, err := url.Parse(server); err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid ZPOOL_K8S_EVENTS_SERVER %q, must be an https URL", server)
+	}
+	n := &kubeEventNotifier{
+		Server:    strings.TrimRight(server, "/"),
+		Namespace: getEnv("ZPOOL_K8S_EVENTS_NAMESPACE", "default"),
+		Node:      getEnv("ZPOOL_K8S_EVENTS_NODE", nodeName()),
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile := os.Getenv("ZPOOL_K8S_EVENTS_CA_FILE"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ZPOOL_K8S_EVENTS_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ZPOOL_K8S_EVENTS_CA_FILE %q", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	tokenFile, certFile, keyFile := os.Getenv("ZPOOL_K8S_EVENTS_TOKEN_FILE"), os.Getenv("ZPOOL_K8S_EVENTS_CERT_FILE"), os.Getenv("ZPOOL_K8S_EVENTS_KEY_FILE")
+	switch {
+	case tokenFile != "":
+		token, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ZPOOL_K8S_EVENTS_TOKEN_FILE: %w", err)
+		}
+		n.token = strings.TrimSpace(string(token))
+	case certFile != "":
+		if keyFile == "" {
+			// The kubelet keeps certificate and key in a single file.
+			keyFile = certFile
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the Kubernetes client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	default:
+		return nil, errors.New("ZPOOL_K8S_EVENTS_SERVER requires ZPOOL_K8S_EVENTS_TOKEN_FILE or ZPOOL_K8S_EVENTS_CERT_FILE")
+	}
+	n.client = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	return n, nil
+}
+
+// enabled reports whether the sink is configured.
+func (k *kubeEventNotifier) enabled() bool {
+	return k != nil
+}
+
+func (k *kubeEventNotifier) Name() string { return "kubernetes-events" }
+
+// eventReason converts an event name like "pool-unhealthy" into an event reason like "PoolUnhealthy".
+func eventReason(event string) string {
+	var b strings.Builder
+	for word := range strings.SplitSeq(event, "-") {
+		if word != "" {
+			b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+		}
+	}
+	return b.String()
+}
+
+// Notify creates an Event about the Node. Info notifications are Normal events, all others
+// Warning events.
+func (k *kubeEventNotifier) Notify(ctx context.Context, n notification) error {
+	eventType := "Warning"
+	if n.Severity == severityInfo {
+		eventType = "Normal"
+	}
+	timestamp := n.Time.UTC().Format("2006-01-02T15:04:05Z")
+	event := map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Event",
+		"metadata": map[string]any{
+			"generateName": "zpool-" + strings.ToLower(n.Pool) + ".",
+			"namespace":    k.Namespace,
+		},
+		// The kubelet uses the node name as UID for events about its Node.
+		"involvedObject":     map[string]any{"apiVersion": "v1", "kind": "Node", "name": k.Node, "uid": k.Node},
+		"reason":             eventReason(n.Event),
+		"message":            fmt.Sprintf("Pool %s: %s", n.Pool, n.Message),
+		"type":               eventType,
+		"source":             map[string]any{"component": kubeEventComponent, "host": k.Node},
+		"reportingComponent": kubeEventComponent,
+		"reportingInstance":  k.Node,
+		"firstTimestamp":     timestamp,
+		"lastTimestamp":      timestamp,
+		"count":              1,
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.Server+"/api/v1/namespaces/"+url.PathEscape(k.Namespace)+"/events", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if k.token != "" {
+		req.Header.Set("Authorization", "Bearer "+k.token)
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("API server returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const nodeLabelPrefix = "zfs.talos.dev/" // Prefix of the node labels describing the pools.
+
+var (
+	// invalidLabelChars matches characters that are not allowed in the name part of a label key.
+	invalidLabelChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+	// labelValuePattern matches a valid label value.
+	labelValuePattern = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9_.-]{0,61}[A-Za-z0-9])?)?$`)
+)
+
+// Media types reported in the media label.
+const (
+	mediaNVMe  = "nvme"
+	mediaSSD   = "ssd"
+	mediaHDD   = "hdd"
+	mediaMixed = "mixed"
+)
+
+// leafMedia classifies the device of a leaf vdev as nvme, ssd or hdd.
+func leafMedia(provider zfsProvider, leaf *vdevStatus) (string, error) {
+	path := leaf.Path
+	if path == "" {
+		path = filepath.Join("/dev", leaf.Name)
+	}
+	resolved, err := provider.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(filepath.Base(resolved), "nvme") {
+		return mediaNVMe, nil
+	}
+	info, err := provider.GetQueueInfo(resolved)
+	if err != nil {
+		return "", err
+	}
+	if info.Rotational {
+		return mediaHDD, nil
+	}
+	return mediaSSD, nil
+}
+
+// poolMedia returns the media type of the data vdevs of a pool, "mixed" if they differ.
+func poolMedia(provider zfsProvider, vdevs []*vdevStatus) (string, error) {
+	_, leaves := actualLayout(vdevs)
+	media := ""
+	for _, leaf := range leaves {
+		m, err := leafMedia(provider, leaf)
+		if err != nil {
+			return "", fmt.Errorf("failed to determine media of %s: %w", leaf.Name, err)
+		}
+		if media != "" && media != m {
+			return mediaMixed, nil
+		}
+		media = m
+	}
+	return media, nil
+}
+
+// poolLabels returns the node labels describing a pool: its media type, redundancy (the data
+// vdev type) and whether its root dataset is encrypted. Without zfsPath the encryption label
+// is left out. Characteristics that cannot be determined are logged and skipped.
+func poolLabels(ctx context.Context, provider zfsProvider, zfsPath string, status *poolStatus) (map[string]string, error) {
+	name := invalidLabelChars.ReplaceAllString(status.Name, "-")
+	name = strings.Trim(name, "-_.")
+	if name == "" || len(name)+len(".redundancy") > 63 {
+		return nil, fmt.Errorf("name cannot be used in a label key")
+	}
+	key := func(attr string) string { return nodeLabelPrefix + name + "." + attr }
+
+	labels := make(map[string]string)
+	vdevs := status.dataVdevs()
+	if len(vdevs) > 0 {
+		media, err := poolMedia(provider, vdevs)
+		if err != nil {
+			slog.Warn("Not labeling pool media", "pool", status.Name, "error", err)
+		} else if media != "" {
+			labels[key("media")] = media
+		}
+		vdevType, _ := actualLayout(vdevs)
+		redundancy := describeVdevType(vdevType)
+		if strings.Contains(redundancy, "+") {
+			redundancy = "mixed"
+		}
+		labels[key("redundancy")] = redundancy
+	}
+	if zfsPath != "" {
+		props, err := provider.GetDatasetProperties(ctx, zfsPath, status.Name, []string{"encryption"})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption: %w", err)
+		}
+		labels[key("encrypted")] = strconv.FormatBool(props["encryption"] != "" && props["encryption"] != "off")
+	}
+
+	for k, v := range labels {
+		if !labelValuePattern.MatchString(v) {
+			slog.Warn("Dropping invalid label value", "pool", status.Name, "label", k, "value", v)
+			delete(labels, k)
+		}
+	}
+	return labels, nil
+}
+
+// renderNodeLabelsPatch renders labels as a Talos machine configuration patch.
+func renderNodeLabelsPatch(labels map[string]string) string {
+	var b strings.Builder
+	b.WriteString("machine:\n  nodeLabels:")
+	if len(labels) == 0 {
+		b.WriteString(" {}")
+	}
+	b.WriteString("\n")
+	for _, k := range slices.Sorted(maps.Keys(labels)) {
+		fmt.Fprintf(&b, "    %s: %s\n", strconv.Quote(k), strconv.Quote(labels[k]))
+	}
+	return b.String()
+}
+
+// writeNodeLabels determines the labels of the given pools and writes them to path as a
+// Talos machine configuration patch, which can be applied with `talosctl patch mc`. Pools
+// that cannot be inspected are reported as errors and left out of the patch.
+func writeNodeLabels(ctx context.Context, provider zfsProvider, zpoolPath, zfsPath, path string, pools []string) []error {
+	statuses, err := newPoolStatusCache(provider, zpoolPath).Status(ctx, pools)
+	if err != nil {
+		return []error{fmt.Errorf("cannot determine node labels: %w", err)}
+	}
+
+	var errs []error
+	labels := make(map[string]string)
+	for _, pool := range pools {
+		status, ok := statuses[pool]
+		if !ok {
+			errs = append(errs, fmt.Errorf("pool %q: not imported, no node labels rendered", pool))
+			continue
+		}
+		found, err := poolLabels(ctx, provider, zfsPath, status)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("pool %q: %w", pool, err))
+			continue
+		}
+		maps.Copy(labels, found)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return append(errs, fmt.Errorf("failed to create directory for node labels: %w", err))
+	}
+	if err := os.WriteFile(path, []byte(renderNodeLabelsPatch(labels)), 0o644); err != nil {
+		return append(errs, fmt.Errorf("failed to write node labels: %w", err))
+	}
+	slog.Info("Rendered node labels", "path", path, "labels", labels)
+	return errs
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteNodeLabels(t *testing.T) {
+	mockProvider := &mockZFSProvider{
+		GetAllPoolStatusFunc: func(ctx context.Context, zpoolPath string) ([]byte, error) {
+			return []byte(testPoolStatusJSON), nil
+		},
+		EvalSymlinksFunc: func(path string) (string, error) {
+			return path, nil
+		},
+		GetQueueInfoFunc: func(path string) (queueInfo, error) {
+			return queueInfo{Rotational: true}, nil
+		},
+		GetDatasetPropertiesFunc: func(ctx context.Context, zfsPath, dataset string, props []string) (map[string]string, error) {
+			return map[string]string{"encryption": "aes-256-gcm"}, nil
+		},
+	}
+	path := filepath.Join(t.TempDir(), "labels", "patch.yaml")
+
+	errs := writeNodeLabels(t.Context(), mockProvider, "/fake/zpool", "/fake/zfs", path, []string{"tank", "missing"})
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), `pool "missing"`) {
+		t.Errorf("Expected a single error for the missing pool, got %v", errs)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `machine:
+  nodeLabels:
+    "zfs.talos.dev/tank.encrypted": "true"
+    "zfs.talos.dev/tank.media": "hdd"
+    "zfs.talos.dev/tank.redundancy": "mirror"
+`
+	if string(data) != want {
+		t.Errorf("Rendered patch =\n%s\nwant\n%s", data, want)
+	}
+}
+
+func TestPoolLabels(t *testing.T) {
+	pools, err := parsePoolStatusJSON([]byte(testPoolStatusJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tank := pools["tank"]
+
+	tests := map[string]struct {
+		rotational map[string]bool
+		queueErr   error
+		zfsPath    string
+		want       map[string]string
+	}{
+		"ssd without zfs": {
+			rotational: map[string]bool{},
+			want:       map[string]string{"zfs.talos.dev/tank.media": "ssd", "zfs.talos.dev/tank.redundancy": "mirror"},
+		},
+		"mixed media": {
+			rotational: map[string]bool{"/dev/sda1": true},
+			zfsPath:    "/fake/zfs",
+			want: map[string]string{
+				"zfs.talos.dev/tank.media":      "mixed",
+				"zfs.talos.dev/tank.redundancy": "mirror",
+				"zfs.talos.dev/tank.encrypted":  "false",
+			},
+		},
+		"unknown media": {
+			queueErr: errors.New("no queue"),
+			want:     map[string]string{"zfs.talos.dev/tank.redundancy": "mirror"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			mockProvider := &mockZFSProvider{
+				EvalSymlinksFunc: func(path string) (string, error) {
+					return path, nil
+				},
+				GetQueueInfoFunc: func(path string) (queueInfo, error) {
+					return queueInfo{Rotational: tt.rotational[path]}, tt.queueErr
+				},
+				GetDatasetPropertiesFunc: func(ctx context.Context, zfsPath, dataset string, props []string) (map[string]string, error) {
+					return map[string]string{"encryption": "off"}, nil
+				},
+			}
+			got, err := poolLabels(t.Context(), mockProvider, tt.zfsPath, tank)
+			if err != nil {
+				t.Fatalf("poolLabels() returned an unexpected error: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("poolLabels() = %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("poolLabels()[%q] = %q, want %q", k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestLeafMedia_NVMe(t *testing.T) {
+	mockProvider := &mockZFSProvider{
+		EvalSymlinksFunc: func(path string) (string, error) {
+			return "/dev/nvme0n1p1", nil
+		},
+		GetQueueInfoFunc: func(path string) (queueInfo, error) {
+			t.Error("Expected NVMe devices to be recognized by name")
+			return queueInfo{}, nil
+		},
+	}
+	media, err := leafMedia(mockProvider, &vdevStatus{Name: "nvme-Samsung_SSD_990", Path: "/dev/disk/by-id/nvme-Samsung_SSD_990-part1"})
+	if err != nil || media != mediaNVMe {
+		t.Errorf("leafMedia() = %q, %v, want %q", media, err, mediaNVMe)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// legacyPoolVars are the single-pool variables of earlier extension versions, mapped to the
+// indexed variables that replace them.
+var legacyPoolVars = map[string]string{
+	"ZPOOL_NAME":  "ZPOOL_0_NAME",
+	"ZPOOL_TYPE":  "ZPOOL_0_TYPE",
+	"ZPOOL_DISKS": "ZPOOL_0_DISKS",
+	"ASHIFT":      "ZPOOL_ASHIFT",
+}
+
+// parseLegacyPoolConfig builds a virtual index-0 pool from the legacy single-pool variables,
+// so that deployments of earlier versions keep their pool after upgrading. Everything but the
+// name, type and disks is read like for an indexed pool, e.g. ZPOOL_STRICT and the placeholders
+// in the disks. It is only used if no indexed pool is configured, and logs a deprecation warning
+// for every legacy variable set.
+func parseLegacyPoolConfig(defaults poolDefaults) (poolConfig, bool) {
+	name := strings.TrimSpace(os.Getenv("ZPOOL_NAME"))
+	if name == "" {
+		return poolConfig{}, false
+	}
+	// ASHIFT is reported by lookupAliased along with the other ashift aliases.
+	for _, key := range []string{"ZPOOL_NAME", "ZPOOL_TYPE", "ZPOOL_DISKS"} {
+		if _, ok := os.LookupEnv(key); ok {
+			slog.Warn("Deprecated variable, please migrate to the indexed configuration", "variable", key, "replacement", legacyPoolVars[key])
+		}
+	}
+
+	config := parsePoolConfig(0, name, defaults)
+	if poolType := strings.TrimSpace(os.Getenv("ZPOOL_TYPE")); poolType != "" {
+		var err error
+		if config.Type, err = draidTypeWithSettings(poolType, "ZPOOL_0_"); err != nil {
+			config.ParseErrors = append(config.ParseErrors, err)
+		}
+	}
+	if diskList := strings.TrimSpace(os.Getenv("ZPOOL_DISKS")); diskList != "" {
+		var err error
+		if config.DiskList, err = expandDiskTemplate(diskList, defaults.facts); err != nil {
+			config.ParseErrors = append(config.ParseErrors, err)
+		}
+	}
+	return config, true
+}
+
+// lookupAliased returns the value of the first of keys that is set, in order of precedence.
+// The first key is the documented name, the others are aliases accepted for compatibility.
+// Using an alias logs a deprecation warning, and aliases with a different value than the one
+// used log a conflict warning.
+func lookupAliased(keys ...string) (string, bool) {
+	var value, used string
+	found := false
+	for _, key := range keys {
+		v, ok := lookupEnvTrimmed(key)
+		if !ok || v == "" {
+			continue
+		}
+		if !found {
+			value, used, found = v, key, true
+			if key != keys[0] {
+				slog.Warn("Deprecated variable, please migrate to the documented name", "variable", key, "replacement", keys[0])
+			}
+			continue
+		}
+		if v != value {
+			slog.Warn("Conflicting variables, ignoring the one with lower precedence", "used", used, "value", value, "ignored", key, "ignored_value", v)
+		}
+	}
+	return value, found
+}
+
+// globalAshiftSetting resolves the global ashift: ZPOOL_ASHIFT, then the legacy ASHIFT.
+func globalAshiftSetting() string {
+	if v, ok := lookupAliased("ZPOOL_ASHIFT", "ASHIFT"); ok {
+		return v
+	}
+	return defaultAshift
+}
+
+// poolAshiftSetting resolves the ashift of pool i. ZPOOL_<n>_ASHIFT takes precedence over the
+// aliases ZPOOL_ASHIFT_<n> and ASHIFT_<n>; without any of them, the global value applies.
+func poolAshiftSetting(i int, global string) string {
+	if v, ok := lookupAliased(fmt.Sprintf("ZPOOL_%d_ASHIFT", i), fmt.Sprintf("ZPOOL_ASHIFT_%d", i), fmt.Sprintf("ASHIFT_%d", i)); ok {
+		return v
+	}
+	return global
+}
@@ -0,0 +1,89 @@
+package main
+
+import "testing"
+
+func TestParsePoolConfigs_Legacy(t *testing.T) {
+	t.Setenv("ZPOOL_NAME", "tank")
+	t.Setenv("ZPOOL_TYPE", "mirror")
+	t.Setenv("ZPOOL_DISKS", "/dev/sda /dev/sdb")
+	t.Setenv("ASHIFT", "13")
+
+	configs := parsePoolConfigs()
+	if len(configs) != 1 {
+		t.Fatalf("parsePoolConfigs() returned %d configs, want 1 legacy pool", len(configs))
+	}
+	c := configs[0]
+	if c.Name != "tank" || c.Type != "mirror" || c.DiskList != "/dev/sda /dev/sdb" || c.Ashift != "13" || !c.Import {
+		t.Errorf("Legacy pool config is incorrect: got %+v", c)
+	}
+
+	// The current global variable takes precedence over the legacy one.
+	t.Setenv("ZPOOL_ASHIFT", "12")
+	if configs := parsePoolConfigs(); configs[0].Ashift != "12" {
+		t.Errorf("Expected ZPOOL_ASHIFT to take precedence over ASHIFT, got %q", configs[0].Ashift)
+	}
+}
+
+func TestParsePoolConfigs_LegacyGlobals(t *testing.T) {
+	t.Setenv("ZPOOL_NAME", "tank")
+	t.Setenv("ZPOOL_DISKS", "/dev/disk/by-partlabel/${HOSTNAME}-data")
+	t.Setenv("ZPOOL_NODE_HOSTNAME", "storage-1")
+	t.Setenv("ZPOOL_STRICT", "true")
+
+	configs := parsePoolConfigs()
+	if len(configs) != 1 {
+		t.Fatalf("parsePoolConfigs() returned %d configs, want 1 legacy pool", len(configs))
+	}
+	c := configs[0]
+	if c.DiskList != "/dev/disk/by-partlabel/storage-1-data" || !c.StrictDisks || len(c.ParseErrors) != 0 {
+		t.Errorf("Expected the placeholder to be expanded and strict mode to apply, got %+v", c)
+	}
+
+	// Unknown placeholders and an invalid ZPOOL_STRICT fail the legacy pool like an indexed one.
+	t.Setenv("ZPOOL_DISKS", "/dev/${DISK}")
+	t.Setenv("ZPOOL_STRICT", "maybe")
+	if configs := parsePoolConfigs(); len(configs[0].ParseErrors) != 2 {
+		t.Errorf("Expected errors for the placeholder and ZPOOL_STRICT, got %v", configs[0].ParseErrors)
+	}
+}
+
+func TestParsePoolConfigs_LegacyIgnoredWithIndexedPools(t *testing.T) {
+	t.Setenv("ZPOOL_NAME", "legacy")
+	t.Setenv("ZPOOL_0_NAME", "tank")
+
+	configs := parsePoolConfigs()
+	if len(configs) != 1 || configs[0].Name != "tank" {
+		t.Errorf("Expected only the indexed pool, got %+v", configs)
+	}
+}
+
+func TestAshiftAliases(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"default", nil, defaultAshift},
+		{"global", map[string]string{"ZPOOL_ASHIFT": "13"}, "13"},
+		{"legacy global", map[string]string{"ASHIFT": "9"}, "9"},
+		{"global over legacy global", map[string]string{"ZPOOL_ASHIFT": "13", "ASHIFT": "9"}, "13"},
+		{"per pool", map[string]string{"ZPOOL_0_ASHIFT": "14", "ZPOOL_ASHIFT": "13"}, "14"},
+		{"ZPOOL_ASHIFT_n alias", map[string]string{"ZPOOL_ASHIFT_0": "14", "ZPOOL_ASHIFT": "13"}, "14"},
+		{"ASHIFT_n alias", map[string]string{"ASHIFT_0": "14"}, "14"},
+		{"documented name wins", map[string]string{"ZPOOL_0_ASHIFT": "12", "ZPOOL_ASHIFT_0": "13", "ASHIFT_0": "14"}, "12"},
+		{"ZPOOL_ASHIFT_n over ASHIFT_n", map[string]string{"ZPOOL_ASHIFT_0": "13", "ASHIFT_0": "14"}, "13"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			t.Setenv("ZPOOL_0_NAME", "tank")
+
+			configs := parsePoolConfigs()
+			if len(configs) != 1 || configs[0].Ashift != tt.want {
+				t.Errorf("parsePoolConfigs() ashift = %+v, want %s", configs, tt.want)
+			}
+		})
+	}
+}
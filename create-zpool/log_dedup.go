@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLogDedupWindow = 15 * time.Minute // Default window for log deduplication, see ZPOOL_LOG_DEDUP_WINDOW.
+	logBurstPerMessage    = 10               // Records with the same message but different attributes allowed per window.
+	logDedupMaxEntries    = 1024             // Tracked records before expired entries are pruned.
+)
+
+// dedupEntry tracks a single record or message within the current window.
+type dedupEntry struct {
+	start      time.Time // Start of the current window.
+	count      int       // Records let through in the current window.
+	suppressed int       // Records dropped in the current window.
+}
+
+// dedupState is shared between a dedupHandler and the handlers derived from it.
+type dedupState struct {
+	mu       sync.Mutex
+	records  map[string]*dedupEntry // By level, message and attributes.
+	messages map[string]*dedupEntry // By level and message only.
+}
+
+// dedupHandler is a slog.Handler that keeps repeated records from flooding the console.
+// An identical record is only logged once per window, and at most logBurstPerMessage
+// records with the same message are logged per window. The next record that gets through
+// carries the number of identical records dropped before it as the "repeated" attribute, and
+// the number of records dropped by the per-message limit as "suppressed_similar".
+type dedupHandler struct {
+	next   slog.Handler
+	window time.Duration
+	prefix string // Attributes and groups added with WithAttrs and WithGroup, part of the key.
+	state  *dedupState
+}
+
+// newDedupHandler wraps next with deduplication over window.
+func newDedupHandler(next slog.Handler, window time.Duration) *dedupHandler {
+	return &dedupHandler{
+		next:   next,
+		window: window,
+		state:  &dedupState{records: make(map[string]*dedupEntry), messages: make(map[string]*dedupEntry)},
+	}
+}
+
+// Enabled implements slog.Handler.
+func (h *dedupHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler.
+func (h *dedupHandler) Handle(ctx context.Context, r slog.Record) error {
+	messageKey := h.prefix + r.Level.String() + "\x00" + r.Message
+	var attrs strings.Builder
+	r.Attrs(func(a slog.Attr) bool {
+		attrs.WriteString(a.String())
+		attrs.WriteByte('\x00')
+		return true
+	})
+	recordKey := messageKey + "\x00" + attrs.String()
+
+	h.state.mu.Lock()
+	repeated, similar, ok := h.admit(recordKey, messageKey, r.Time)
+	h.state.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if repeated > 0 {
+		r.AddAttrs(slog.Int("repeated", repeated))
+	}
+	if similar > 0 {
+		r.AddAttrs(slog.Int("suppressed_similar", similar))
+	}
+	return h.next.Handle(ctx, r)
+}
+
+// admit decides whether a record gets through. It returns the number of identical records and
+// of other records with the same message dropped before it. It must be called with the state locked.
+func (h *dedupHandler) admit(recordKey, messageKey string, now time.Time) (repeated, similar int, ok bool) {
+	s := h.state
+	if len(s.records) > logDedupMaxEntries {
+		h.prune(now)
+	}
+
+	record := h.entry(s.records, recordKey, now)
+	message := h.entry(s.messages, messageKey, now)
+	if record.count > 0 {
+		record.suppressed++
+		return 0, 0, false
+	}
+	if message.count >= logBurstPerMessage {
+		message.suppressed++
+		return 0, 0, false
+	}
+	record.count++
+	message.count++
+
+	// The summaries are reported once, on the record that ends the suppression.
+	repeated, similar = record.suppressed, message.suppressed
+	record.suppressed = 0
+	message.suppressed = 0
+	return repeated, similar, true
+}
+
+// entry returns the entry for key, starting a new window if the current one has expired.
+// The dropped count of an expired window is carried over so it can still be reported.
+func (h *dedupHandler) entry(entries map[string]*dedupEntry, key string, now time.Time) *dedupEntry {
+	e, ok := entries[key]
+	if !ok {
+		e = &dedupEntry{start: now}
+		entries[key] = e
+	} else if now.Sub(e.start) >= h.window {
+		e.start = now
+		e.count = 0
+	}
+	return e
+}
+
+// prune drops entries whose window has expired without anything being dropped.
+func (h *dedupHandler) prune(now time.Time) {
+	for _, entries := range []map[string]*dedupEntry{h.state.records, h.state.messages} {
+		for key, e := range entries {
+			if now.Sub(e.start) >= h.window && e.suppressed == 0 {
+				delete(entries, key)
+			}
+		}
+	}
+}
+
+// WithAttrs implements slog.Handler.
+func (h *dedupHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var prefix strings.Builder
+	prefix.WriteString(h.prefix)
+	for _, a := range attrs {
+		prefix.WriteString(a.String())
+		prefix.WriteByte('\x00')
+	}
+	return &dedupHandler{next: h.next.WithAttrs(attrs), window: h.window, prefix: prefix.String(), state: h.state}
+}
+
+// WithGroup implements slog.Handler.
+func (h *dedupHandler) WithGroup(name string) slog.Handler {
+	return &dedupHandler{next: h.next.WithGroup(name), window: h.window, prefix: h.prefix + name + ".\x00", state: h.state}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDedupHandler(t *testing.T) {
+	var buf bytes.Buffer
+	text := slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	h := newDedupHandler(text, time.Minute)
+	start := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	log := func(offset time.Duration, msg string, args ...any) {
+		r := slog.NewRecord(start.Add(offset), slog.LevelWarn, msg, 0)
+		r.Add(args...)
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A persistently missing disk is reported every poll interval.
+	for i := range 5 {
+		log(time.Duration(i)*10*time.Second, "Device not found", "device", "/dev/sdb")
+	}
+	log(20*time.Second, "Device not found", "device", "/dev/sdc")
+	log(70*time.Second, "Device not found", "device", "/dev/sdb")
+
+	want := `level=WARN msg="Device not found" device=/dev/sdb
+level=WARN msg="Device not found" device=/dev/sdc
+level=WARN msg="Device not found" device=/dev/sdb repeated=4
+`
+	if buf.String() != want {
+		t.Errorf("Deduplicated output =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestDedupHandler_PerMessageLimit(t *testing.T) {
+	var buf bytes.Buffer
+	h := newDedupHandler(slog.NewTextHandler(&buf, nil), time.Minute)
+	logger := slog.New(h)
+
+	for i := range logBurstPerMessage + 5 {
+		logger.Warn("Waiting for disks", "remaining", fmt.Sprintf("%ds", 100-i))
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != logBurstPerMessage {
+		t.Errorf("Expected %d lines within the window, got %d:\n%s", logBurstPerMessage, lines, buf.String())
+	}
+
+	// Records with different messages or attributes added with With are tracked separately.
+	buf.Reset()
+	logger.With("pool", "tank").Warn("Waiting for disks", "remaining", "100s")
+	logger.Info("Waiting for disks", "remaining", "100s")
+	if lines := strings.Count(buf.String(), "\n"); lines != 2 {
+		t.Errorf("Expected unrelated records to be logged, got:\n%s", buf.String())
+	}
+}
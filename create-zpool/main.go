@@ -1,252 +1,1645 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"math"
 	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
 )
 
 const (
 	defaultPoolName = "tank"
 	defaultAshift   = "12"
-	maxPools        = 42 // Sanity limit for the number of pools to create.
+	defaultMountDir = "/var/mnt" // Parent directory of default pool mountpoints, shared with the host.
+	defaultMaxPools = 42         // Default number of pool indices that are read, see ZPOOL_MAX_POOLS.
+	maxPoolLimit    = 1000       // Sanity limit of ZPOOL_MAX_POOLS.
+
+	defaultProbeParallelism = 8 // Maximum number of devices probed concurrently per pool, see ZPOOL_PROBE_PARALLELISM.
+
+	defaultCommandTimeout = 5 * time.Minute // Deadline for each external command, see ZPOOL_COMMAND_TIMEOUT.
+
+	defaultUdevSettleTimeout = 30 * time.Second // Wait for udev events to be processed, see ZPOOL_UDEV_SETTLE_TIMEOUT.
+
+	defaultBusyRetries    = 3               // Retries of zpool create while a device is busy, see ZPOOL_BUSY_RETRIES.
+	defaultBusyRetryDelay = 5 * time.Second // Delay before each of those retries, see ZPOOL_BUSY_RETRY_DELAY.
+
+	exitLocked = 3 // Exit code when another instance held the lock for longer than ZPOOL_LOCK_WAIT.
+)
+
+// Modes of operation selected with ZPOOL_MODE.
+const (
+	modeCreate       = "create"       // Create, import and reconcile the configured pools (default).
+	modeBurnIn       = "burnin"       // Destructively test candidate disks without creating pools.
+	modeAudit        = "audit"        // Report differences between the configuration and the system without changes.
+	modePlan         = "plan"         // Write the changes a create run would make as JSON without making them.
+	modeExplain      = "explain"      // Describe the changes a create run would make in plain language, see writeExplanation.
+	modeDiff         = "diff"         // Print a human readable diff between the configuration and the system.
+	modeSplit        = "split"        // Split a mirrored pool into a new, exported pool, see splitMain.
+	modeVersion      = "version"      // Print the build metadata and exit.
+	modeSelftest     = "selftest"     // Check all prerequisites and print a pass/fail checklist, see runSelftest.
+	modeCompletion   = "completion"   // Print a shell completion script, see completionMain.
+	modeConfig       = "config"       // Print an example configuration, see configMain.
+	modeCapabilities = "capabilities" // Print the vdev types, properties and features the local ZFS supports.
+	modeValidate     = "validate"     // Check the configuration offline and print a report, see validateMain.
 )
 
+// allModes are the modes accepted by ZPOOL_MODE and as the first command line argument.
+var allModes = []string{modeCreate, modePlan, modeExplain, modeBurnIn, modeAudit, modeDiff, modeSplit, modeVersion, modeSelftest, modeCompletion, modeConfig, modeCapabilities, modeValidate}
+
+// instanceLock is the lock file held for the whole run, see acquireLock.
+var instanceLock *os.File
+
+// diskWaitPollInterval is how often missing disks are probed again while waiting for them.
+var diskWaitPollInterval = 5 * time.Second
+
 // diskSpec defines a target disk declaration which can be defined by explicit path (dev) or dynamic query (model).
 type diskSpec struct {
-	Dev   string // Explicit block device path (e.g. "/dev/sda")
-	Model string // Dynamic disk model query (e.g. "Dell DC NVMe CD8*")
+	Dev   string `json:"dev,omitempty"`   // Explicit block device path (e.g. "/dev/sda")
+	Model string `json:"model,omitempty"` // Dynamic disk model query (e.g. "Dell DC NVMe CD8*")
 }
 
 // poolConfig holds the configuration for a single ZFS pool.
 type poolConfig struct {
-	Name        string     // Name of the ZFS pool (e.g., "tank").
-	Type        string     // Type of the vdev (e.g., "mirror", "raidz", "draid"). Can be empty for single-disk vdevs.
-	Disks       []diskSpec // List of ordered disk specifications.
-	SizeFilters []string   // List of pool-wide size filter conditions.
-	Ashift      string     // ashift property for the pool, specifying the sector size alignment (e.g., "12" for 4K).
+	Name        string            // Name of the ZFS pool (e.g., "tank").
+	Type        string            // Type of the vdev (e.g., "mirror", "raidz", "draid"). Can be empty for single-disk vdevs.
+	Disks       []diskSpec        // List of ordered disk specifications.
+	DiskList    string            // Raw compact disk list (separated device paths or JSON array), appended after Disks.
+	SizeFilters []string          // List of pool-wide size filter conditions.
+	Ashift      string            // ashift property for the pool, specifying the sector size alignment (e.g., "12" for 4K).
+	Mountpoint  string            // Mountpoint of the root dataset ("none", "legacy" or an absolute path). Defaults to /var/mnt/<name>.
+	CanMount    string            // canmount property of the root dataset ("on", "off", "noauto"). Empty keeps the zfs default.
+	StrictDisks bool              // Abort creation if any configured disk is unusable instead of skipping it, see ZPOOL_STRICT.
+	Vdevs       []vdevSpec        // Data vdevs of a pool with several of them, instead of Type and the disks.
+	MixedVdevs  bool              // Create Vdevs of different redundancy, which zpool refuses without -f.
+	Log         vdevSpec          // Separate intent log (SLOG); without disks, the pool has none.
+	Special     vdevSpec          // Special allocation class vdev for metadata and small blocks; without disks, the pool has none.
+	SmallBlocks string            // special_small_blocks property of the root dataset, set at creation (e.g. "32K"). Needs Special.
+	Cache       vdevSpec          // L2ARC cache devices; without disks, the pool has none.
+	CacheParams map[string]string // l2arc_* ZFS module parameters to apply if the pool has cache devices, see cacheModuleParameters.
+	Recordsize  string            // recordsize property of the root dataset, set at creation (e.g. "1M"). Empty keeps the zfs default.
+	Properties  map[string]string // Pool properties set at creation (e.g. "autotrim": "on").
+	Reconcile   []string          // Properties to enforce on an already existing pool with `zpool set`.
+	Disabled    bool              // Skip this pool entirely, e.g. during hardware maintenance.
+	Import      bool              // Import an exported pool with the same name instead of creating a new one.
+	Erase       string            // Discard the selected disks before creation ("discard", "secure"). Empty disables.
+	Trim        bool              // Trim solid-state disks that support discard right before creation.
+	Dedup       string            // dedup property of the root dataset, set at creation. Empty keeps the zfs default.
+	DedupAck    bool              // Acknowledges the memory cost of dedup, which is refused without it.
+	MountOwner  *mountOwnership   // Ownership and mode enforced on the mountpoint directory. Nil leaves it alone.
+	Staged      bool              // Create the pool under an altroot and import it at its mountpoints once validated.
+	Adopt       bool              // Move the root dataset of an existing pool to the configured mountpoint, see adoptMountpoint.
+	SwapSize    string            // Size of the swap zvol, e.g. "8G" or a multiple of RAM like "0.5x". Empty disables swap.
+	Volumes     []volumeConfig    // zvols created in the pool.
+	Upgrade     bool              // Enable all supported features of an existing pool, see upgradePool.
+	Priority    int               // Pools with a higher priority are processed first, see orderPoolConfigs.
+	After       []string          // Pools that are processed before this one; if one of them fails, so does this pool.
+
+	Initialize     bool // Run `zpool initialize` on the pool right after creating it.
+	InitializeWait bool // Keep reporting initialization progress until it completed.
+	// WaitForDisks is how long to wait for all configured disks to become usable. If they do not,
+	// the pool is left alone entirely. Zero disables waiting.
+	WaitForDisks time.Duration
+	// CreateTimeout and ImportTimeout are the deadlines of `zpool create` and `zpool import` of
+	// this pool, overriding ZPOOL_COMMAND_TIMEOUT. Zero keeps the global timeout.
+	CreateTimeout time.Duration
+	ImportTimeout time.Duration
+
+	ParseErrors []error // Invalid values found while reading the configuration; the pool fails with these.
 }
 
 func main() {
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
-	slog.SetDefault(logger)
-
-	slog.Info("Talos ZFS Pool Extension: Starting ZFS Pool Creation")
+	// Prefixed variables are applied first, so that everything below reads the unprefixed names.
+	envConflicts := applyEnvPrefix(envPrefix())
+	poolSources, jsonErrors := poolJSONSources()
+	poolJSONErrors = jsonErrors
+	cmdlineSource, invalidCmdline, cmdlineErr := cmdlineConfigSource()
+	sources := append(poolSources, cmdlineSource, envConfigSource())
+	configFilePath, configFileRequired := lookupEnvTrimmed("ZPOOL_CONFIG_FILE")
+	if !configFileRequired {
+		configFilePath = defaultConfigFile
+	}
+	fileSource, fileErrors, configFileErr := loadConfigFile(configFilePath, configFileRequired)
+	configFileSum := fileFingerprint(configFilePath)
+	if fileSource != nil {
+		sources = append(sources, *fileSource)
+		if fileSource.Shadows != nil {
+			// The pools of the file replace those of the environment, including their errors.
+			poolJSONErrors = fileErrors
+		}
+	}
+	effectiveConfig := mergeConfigSources(sources...)
+	effectiveConfig.apply()
 
-	provider := &liveZFSProvider{}
+	// A mode given on the command line, e.g. `create-zpool diff`, overrides ZPOOL_MODE.
+	mode := getEnv("ZPOOL_MODE", modeCreate)
+	if len(os.Args) > 1 {
+		mode = os.Args[1]
+	}
+	printVersion, err := getEnvBool("ZPOOL_PRINT_VERSION", false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if mode == modeVersion || printVersion {
+		printBuildInfo(os.Stdout, currentBuildInfo())
+		os.Exit(0)
+	}
+	switch mode {
+	case modeCompletion:
+		os.Exit(completionMain(os.Stdout, os.Args[min(len(os.Args), 2):]))
+	case modeConfig:
+		os.Exit(configMain(os.Stdout, os.Args[min(len(os.Args), 2):]))
+	case modeComplete:
+		os.Exit(completeMain(context.Background(), &liveZFSProvider{commandTimeout: defaultCommandTimeout}, os.Stdout, os.Args[min(len(os.Args), 2):]))
+	}
+	planFile := strings.TrimSpace(os.Getenv("ZPOOL_PLAN_FILE"))
 
-	zpoolPath, err := provider.LookPath("zpool")
+	// Modes that print a document to stdout keep the log output on stderr.
+	logOutput := os.Stdout
+	if mode == modeDiff || mode == modeSelftest || mode == modeCapabilities || mode == modeValidate || ((mode == modePlan || mode == modeExplain) && planFile == "") {
+		logOutput = os.Stderr
+	}
+	var handler slog.Handler = slog.NewTextHandler(logOutput, nil)
+	slog.SetDefault(slog.New(handler))
+	dedupWindow, err := getEnvDuration("ZPOOL_LOG_DEDUP_WINDOW", defaultLogDedupWindow)
 	if err != nil {
-		slog.Error("zpool binary not found in PATH", "error", err, "PATH", os.Getenv("PATH"))
+		slog.Error("Invalid log deduplication window", "error", err)
+		os.Exit(1)
+	}
+	if dedupWindow > 0 {
+		slog.SetDefault(slog.New(newDedupHandler(handler, dedupWindow)))
+	}
+	if mode == modeValidate {
+		// An invalid configuration file is part of the report.
+		os.Exit(validateMain(os.Stdout, effectiveConfig.Sources, configFileErr))
+	}
+	if configFileErr != nil {
+		slog.Error("Invalid configuration file", "error", configFileErr)
+		os.Exit(1)
+	}
+	if cmdlineErr != nil {
+		slog.Warn("Failed to read the kernel command line, ignoring its talos.zpool.* parameters", "file", procCmdlineFile, "error", cmdlineErr)
+	}
+	for _, param := range invalidCmdline {
+		slog.Warn("Ignoring kernel command line parameter with an invalid name", "parameter", param)
+	}
+	for _, c := range envConflicts {
+		slog.Warn("Conflicting variables, ignoring the one with lower precedence", "used", c.Prefixed, "value", c.Value, "ignored", c.Unprefixed, "ignored_value", c.Ignored)
+	}
+	if err := parseParallelismLimits(); err != nil {
+		slog.Error("Invalid parallelism limits", "error", err)
 		os.Exit(1)
 	}
+
+	slog.Info("Talos ZFS Pool Extension: Starting ZFS Pool Creation", currentBuildInfo().logArgs()...)
+	effectiveConfig.log()
+
+	if mode == modeSelftest {
+		os.Exit(selftestMain(context.Background(), &liveZFSProvider{commandTimeout: defaultCommandTimeout}, os.Stdout))
+	}
+
+	stateDir := getEnv("ZPOOL_STATE_DIR", defaultStateDir)
+
+	// A failed create run is restarted by the service manager. finish records a backoff for the
+	// next start, so that a run that keeps failing is not repeated in a tight loop.
+	var backoff backoffSettings
+	finish := func(code int) int {
+		if mode != modeCreate {
+			return code
+		}
+		return finishRun(stateDir, configFilePath, backoff, code)
+	}
+
+	// The pause file stops everything that could touch a disk. The read-only modes keep
+	// working, since they are most useful during exactly the recovery work it is meant for.
+	if mode != modeAudit && mode != modeDiff && mode != modeCapabilities {
+		isPaused, err := paused(stateDir)
+		if err != nil {
+			slog.Error("Cannot determine whether execution is paused, not touching any disk", "state_dir", stateDir, "error", err)
+			os.Exit(1)
+		}
+		if isPaused {
+			slog.Info("Pause file found, doing nothing.", "file", filepath.Join(stateDir, pauseFile))
+			os.Exit(0)
+		}
+		if mode == modeCreate {
+			if backoff, err = parseBackoffSettings(); err != nil {
+				slog.Error("Invalid retry backoff, using the defaults", "error", err)
+			}
+			waitForBackoff(stateDir, configFilePath)
+		}
+
+		lockWait, err := getEnvDuration("ZPOOL_LOCK_WAIT", defaultLockWait)
+		if err != nil {
+			slog.Error("Invalid lock wait", "error", err)
+			os.Exit(1)
+		}
+		// The lock is released when the process exits. The file is kept in a package variable,
+		// so that it is never garbage collected and closed while the run is in progress.
+		if instanceLock, err = acquireLock(stateDir, lockWait); err != nil {
+			if errors.Is(err, errLocked) {
+				slog.Error("Another instance is still running, exiting without touching any disk.", "waited", lockWait)
+				os.Exit(exitLocked)
+			}
+			slog.Error("Failed to acquire the instance lock", "state_dir", stateDir, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	settings := &settingsCheck{exit: func(code int) { os.Exit(finish(code)) }}
+	if settings.failSafe, err = getEnvBool("ZPOOL_FAIL_SAFE", false); err != nil {
+		// A typo in the switch itself must not take down the node's storage either.
+		settings.failSafe = true
+		settings.invalid("Invalid fail-safe setting", err)
+	}
+	commandTimeout, err := getEnvDuration("ZPOOL_COMMAND_TIMEOUT", defaultCommandTimeout)
+	if err != nil {
+		settings.invalid("Invalid command timeout", err)
+	}
+
+	ctx := context.Background()
+	provider := &liveZFSProvider{commandTimeout: commandTimeout}
+
+	zpoolPath, err := resolveBinary(provider, "ZPOOL_BIN", "zpool")
+	if err != nil {
+		slog.Error("zpool binary not found", "error", err, "PATH", os.Getenv("PATH"))
+		os.Exit(finish(exitRetryable))
+	}
 	slog.Info("Found zpool binary", "path", zpoolPath)
 
+	// The zfs binary is optional; only an explicitly configured but unusable ZFS_BIN is fatal.
+	zfsPath, err := resolveBinary(provider, "ZFS_BIN", "zfs")
+	if err != nil {
+		if _, ok := os.LookupEnv("ZFS_BIN"); ok {
+			slog.Error("Configured zfs binary is not usable", "error", err)
+			os.Exit(finish(exitConfigError))
+		}
+		slog.Info("zfs binary not found, dataset operations are unavailable", "error", err)
+		zfsPath = ""
+	} else {
+		slog.Info("Found zfs binary", "path", zfsPath)
+	}
+
+	version := logZFSVersion(ctx, provider, zpoolPath)
+	mismatchPolicy := getEnv("ZPOOL_VERSION_MISMATCH", versionMismatchWarn)
+	if mismatchPolicy != versionMismatchWarn && mismatchPolicy != versionMismatchRefuse {
+		settings.invalid("Invalid ZPOOL_VERSION_MISMATCH", fmt.Errorf("must be warn or refuse, got %q", mismatchPolicy))
+		mismatchPolicy = versionMismatchWarn
+	}
+	if err := checkVersionMismatch(version); err != nil {
+		if mismatchPolicy == versionMismatchRefuse {
+			slog.Error("Refusing to modify pools with mismatched ZFS versions", "error", err)
+			os.Exit(finish(exitRetryable))
+		}
+		slog.Warn("ZFS version mismatch, pool features may behave unexpectedly", "error", err)
+	}
+	// Discovery is done, nothing below needs more than managing pools.
+	dropCaps, err := getEnvBool("ZPOOL_DROP_CAPABILITIES", true)
+	if err != nil {
+		settings.invalid("Invalid capability setting", err)
+	}
+	if dropCaps {
+		minimizePrivileges()
+	}
+
+	// These modes work without a pool configuration.
+	switch mode {
+	case modeSplit:
+		os.Exit(splitMain(ctx, provider, zpoolPath, os.Args[min(len(os.Args), 2):]))
+	case modeCapabilities:
+		os.Exit(capabilitiesMain(ctx, provider, zpoolPath, zfsPath, os.Stdout))
+	}
+
+	if _, err := poolLimit(); err != nil {
+		settings.invalid("Invalid pool limit", err)
+	}
+	if _, err := getEnvBool("ZPOOL_SKIP_GAPS", false); err != nil {
+		settings.invalid("Invalid gap setting", err)
+	}
 	configs := parsePoolConfigs()
 	if len(configs) == 0 {
-		slog.Info("No pool configurations found (e.g., ZPOOL_NAME_0 is not set). Exiting cleanly.")
-		os.Exit(0)
+		slog.Info("No pool configurations found (e.g., ZPOOL_0_NAME is not set). Exiting cleanly.")
+		os.Exit(finish(exitConverged))
+	}
+
+	configs, poolNames := enabledPoolConfigs(configs)
+
+	// In plan and explain mode the regular create run is performed against a provider that
+	// records changes instead of making them.
+	var executor zfsProvider = provider
+	var planner *planningProvider
+	switch mode {
+	case modeCreate:
+	case modePlan, modeExplain:
+		planner = newPlanningProvider(provider)
+		planner.explain = mode == modeExplain
+		planner.pools = poolNames
+		executor = planner
+		for i := range configs {
+			configs[i].InitializeWait = false
+		}
+	case modeBurnIn:
+		os.Exit(burnInMain(ctx, provider, zpoolPath, configs))
+	case modeAudit:
+		os.Exit(auditMain(ctx, provider, zpoolPath, zfsPath, configs))
+	case modeDiff:
+		os.Exit(diffMain(ctx, provider, zpoolPath, zfsPath, configs, os.Stdout))
+	default:
+		slog.Error("Invalid ZPOOL_MODE", "mode", mode, "valid", allModes)
+		os.Exit(1)
+	}
+
+	if progressInterval, err = getEnvDuration("ZPOOL_PROGRESS_INTERVAL", defaultProgressInterval); err != nil {
+		settings.invalid("Invalid progress interval", err)
+	}
+	watchdogTimeout, err := getEnvDuration("ZPOOL_WATCHDOG_TIMEOUT", defaultWatchdogTimeout)
+	if err != nil {
+		settings.invalid("Invalid watchdog timeout", err)
+	}
+	if watchdogTimeout > 0 {
+		activeWatchdog = newWatchdog(os.Stderr, os.Exit)
+		go activeWatchdog.run(ctx)
+	}
+	leavePhase := activeWatchdog.enter("startup", phaseTimeout(watchdogTimeout, commandTimeout))
+
+	firstBootOnly, err := getEnvBool("ZPOOL_FIRST_BOOT_ONLY", false)
+	if err != nil {
+		settings.invalid("Invalid first-boot setting", err)
+	}
+	watchInterval, err := getEnvDuration("ZPOOL_WATCH_INTERVAL", 0)
+	if err != nil {
+		settings.invalid("Invalid watch interval", err)
+	}
+	monitorSettings, err := parseMonitorSettings()
+	if err != nil {
+		settings.invalid("Invalid monitoring settings", err)
+		// Partially parsed settings cannot be trusted, so all checks are off.
+		monitorSettings = disabledMonitorSettings()
+	}
+	monitor := newPoolMonitor(monitorSettings)
+	monitor.version = version
+	reloadConfig, err := getEnvBool("ZPOOL_RELOAD", true)
+	if err != nil {
+		settings.invalid("Invalid reload setting", err)
+	}
+	var reloader *configReloader
+	if reloadConfig && watchInterval > 0 && planner == nil {
+		reloader = newConfigReloader(ctx, configFilePath, configFileRequired, configFileSum)
+	}
+	firstBootCompleted := false
+	if firstBootOnly {
+		if firstBootCompleted, err = firstBootDone(stateDir); err != nil {
+			slog.Error("Failed to check first-boot state", "state_dir", stateDir, "error", err)
+			os.Exit(finish(exitRetryable))
+		}
+		if firstBootCompleted {
+			slog.Info("First boot already completed, only importing, recovering and mounting existing pools.", "state_dir", stateDir)
+		}
 	}
 
-	usedDisks := make(map[string]bool)
+	// Tune the ZFS module before any pool work, so that imports and creation already use the settings.
 	var allErrors []error
+	moduleParams, paramErrs := parseModuleParameters()
+	allErrors = append(allErrors, paramErrs...)
+	if err := arcMaxFromPercent(provider, moduleParams); err != nil {
+		allErrors = append(allErrors, err)
+	}
+	if err := cacheModuleParameters(configs, moduleParams); err != nil {
+		allErrors = append(allErrors, configError{err})
+	}
+	if len(moduleParams) > 0 {
+		allErrors = append(allErrors, applyModuleParameters(executor, moduleParams)...)
+	}
+
+	existingPools, err := provider.ListPools(ctx, zpoolPath)
+	if err != nil {
+		slog.Error("Failed to list existing pools", "error", err)
+		os.Exit(finish(exitRetryable))
+	}
+	slog.Info("Found existing pools", "count", len(existingPools))
+
+	state := newRunState(existingPools)
+	state.dryRun = planner != nil
+	state.skipCreate = firstBootCompleted
+	if state.udevSettleTimeout, err = getEnvDuration("ZPOOL_UDEV_SETTLE_TIMEOUT", defaultUdevSettleTimeout); err != nil {
+		settings.invalid("Invalid udev settle timeout", err)
+	}
+	state.recovery = getEnv("ZPOOL_RECOVERY", recoveryNone)
+	if !isValidRecoveryPolicy(state.recovery) {
+		settings.invalid("Invalid ZPOOL_RECOVERY", fmt.Errorf("unsupported policy %q, valid are %v", state.recovery, recoveryPolicies))
+		state.recovery = recoveryNone
+	}
+	if state.busyRetries, err = getEnvUint("ZPOOL_BUSY_RETRIES", defaultBusyRetries); err != nil {
+		settings.invalid("Invalid busy retries", err)
+	}
+	if state.busyRetryDelay, err = getEnvDuration("ZPOOL_BUSY_RETRY_DELAY", defaultBusyRetryDelay); err != nil {
+		settings.invalid("Invalid busy retry delay", err)
+	}
+	if state.createDryRun, err = getEnvBool("ZPOOL_CREATE_DRY_RUN", true); err != nil {
+		settings.invalid("Invalid create dry run setting", err)
+	}
+	state.logLatencyPolicy = getEnv("ZPOOL_LOG_LATENCY_CHECK", logLatencyWarn)
+	if !isValidLogLatencyPolicy(state.logLatencyPolicy) {
+		settings.invalid("Invalid ZPOOL_LOG_LATENCY_CHECK", fmt.Errorf("unsupported policy %q, valid are %v", state.logLatencyPolicy, logLatencyPolicies))
+		state.logLatencyPolicy = logLatencyWarn
+	}
+	if state.logMaxLatency, err = getEnvDuration("ZPOOL_LOG_MAX_LATENCY", defaultLogMaxLatency); err != nil {
+		settings.invalid("Invalid log latency limit", err)
+	}
+	state.guidPolicy = getEnv("ZPOOL_GUID_MISMATCH", guidMismatchRefuse)
+	if !isValidGUIDMismatchPolicy(state.guidPolicy) {
+		settings.invalid("Invalid ZPOOL_GUID_MISMATCH", fmt.Errorf("unsupported policy %q, valid are %v", state.guidPolicy, guidMismatchPolicies))
+		state.guidPolicy = guidMismatchRefuse
+	}
+	if st, err := loadState(stateDir); err != nil {
+		slog.Warn("Failed to load state, not verifying pool GUIDs", "state_dir", stateDir, "error", err)
+	} else {
+		state.pinnedGUIDs = st.GUIDs
+	}
+	state.zfsPath = zfsPath
+	state.stateDir = stateDir
+	state.stagingDir = filepath.Join(stateDir, stagingDir)
+	diagnostics, err := getEnvBool("ZPOOL_DIAGNOSTICS", true)
+	if err != nil {
+		settings.invalid("Invalid diagnostics setting", err)
+	}
+	if diagnostics {
+		state.diagnosticsDir = filepath.Join(stateDir, diagnosticsDir)
+		if state.zdbPath, err = resolveBinary(provider, "ZDB_BIN", "zdb"); err != nil {
+			slog.Info("zdb binary not found, diagnostic bundles will not contain device labels", "error", err)
+			state.zdbPath = ""
+		}
+	}
+	state.settleUdev(ctx, provider, "before probing disks")
+	leavePhase()
+	var readyPools []string
+	failedPools := make(map[string]bool)
+	allErrors = append(allErrors, settings.errs...)
+	if settings.failSafe {
+		var quarantined []error
+		var notifications []notification
+		configs, quarantined, notifications = quarantinePools(configs)
+		for _, err := range quarantined {
+			var pe *poolError
+			if errors.As(err, &pe) {
+				failedPools[pe.Pool] = true
+			}
+		}
+		allErrors = append(allErrors, quarantined...)
+		if !state.dryRun {
+			for _, n := range notifications {
+				monitor.notify(ctx, n)
+			}
+		}
+	}
 	for _, config := range configs {
 		slog.Info("Processing pool configuration", "pool", config.Name)
-		err := createPool(provider, zpoolPath, config, usedDisks)
+		if planner != nil {
+			planner.setPool(config.Name)
+		}
+		var err error
+		if dep := failedDependency(config, failedPools); dep != "" {
+			err = fmt.Errorf("pool %q, which this pool is configured to come after, failed", dep)
+		} else {
+			leavePhase := activeWatchdog.enter("pool "+config.Name, phaseTimeout(watchdogTimeout, commandTimeout, config.CreateTimeout, config.ImportTimeout))
+			stopHeartbeat := startHeartbeat("Still working on pool", "pool", config.Name)
+			err = createPool(ctx, executor, zpoolPath, config, state)
+			stopHeartbeat()
+			leavePhase()
+		}
+		if !state.dryRun {
+			for _, n := range state.notifications {
+				monitor.notify(ctx, n)
+			}
+		}
+		state.notifications = nil
+		if err != nil {
+			failedPools[config.Name] = true
+			logArgs := []any{"pool", config.Name, "error", err}
+			if hint := errorHint(err); hint != "" {
+				logArgs = append(logArgs, "hint", hint)
+			}
+			slog.Error("Failed to create pool", logArgs...)
+			// A GUID mismatch was notified about already.
+			if !state.dryRun && !errors.Is(err, errGUIDMismatch) {
+				monitor.notify(ctx, notification{Time: time.Now(), Severity: severityCritical, Event: eventPoolCreateFailed, Pool: config.Name,
+					Message: fmt.Sprintf("Creating, importing or reconciling pool %s failed: %v", config.Name, err)})
+			}
+			allErrors = append(allErrors, &poolError{Pool: config.Name, Err: err})
+			continue
+		}
+		if !state.notCreated[config.Name] {
+			readyPools = append(readyPools, config.Name)
+		}
+	}
+
+	mountDatasets, err := getEnvBool("ZPOOL_MOUNT_DATASETS", true)
+	if err != nil {
+		allErrors = append(allErrors, err)
+	} else if mountDatasets && zfsPath == "" {
+		slog.Warn("zfs binary not found, not checking that datasets are mounted")
+	} else if mountDatasets {
+		for _, name := range readyPools {
+			if planner != nil {
+				planner.setPool(name)
+			}
+			leavePhase := activeWatchdog.enter("datasets of pool "+name, phaseTimeout(watchdogTimeout, commandTimeout))
+			if err := mountPoolDatasets(ctx, executor, zfsPath, name, state.dryRun); err != nil {
+				slog.Error("Failed to mount datasets", "pool", name, "error", err)
+				allErrors = append(allErrors, &poolError{Pool: name, Err: err})
+			}
+			leavePhase()
+		}
+	}
+
+	for _, config := range configs {
+		if (len(config.Volumes) == 0 && config.SwapSize == "") || !slices.Contains(readyPools, config.Name) {
+			continue
+		}
+		if planner != nil {
+			planner.setPool(config.Name)
+		}
+		leavePhase := activeWatchdog.enter("zvols of pool "+config.Name, phaseTimeout(watchdogTimeout, commandTimeout))
+		if err := state.ensureVolumes(ctx, executor, config); err != nil {
+			slog.Error("Failed to provision zvols", "pool", config.Name, "error", err)
+			allErrors = append(allErrors, &poolError{Pool: config.Name, Err: err})
+		}
+		if err := state.ensureSwap(ctx, executor, config); err != nil {
+			slog.Error("Failed to provision swap", "pool", config.Name, "error", err)
+			allErrors = append(allErrors, &poolError{Pool: config.Name, Err: err})
+		}
+		leavePhase()
+	}
+
+	if planner != nil {
+		os.Exit(finishPlan(planner, planFile, allErrors))
+	}
+
+	leavePhase = activeWatchdog.enter("reporting", phaseTimeout(watchdogTimeout, commandTimeout))
+	cleanupMountpoints, err := getEnvBool("ZPOOL_CLEANUP_MOUNTPOINTS", false)
+	if err != nil {
+		allErrors = append(allErrors, err)
+	}
+	if cleanupMountpoints || len(state.createdMountpoints) > 0 {
+		if err := updateMountpointState(stateDir, state.createdMountpoints, cleanupMountpoints, poolNames); err != nil {
+			allErrors = append(allErrors, err)
+		}
+	}
+
+	if state.guidsChanged {
+		if err := updateGUIDState(stateDir, state.pinnedGUIDs); err != nil {
+			allErrors = append(allErrors, fmt.Errorf("failed to save pinned pool GUIDs: %w", err))
+		}
+	}
+
+	pvSettings, err := parsePVSettings()
+	if err != nil {
+		allErrors = append(allErrors, err)
+	} else if pvSettings.Dir != "" {
+		allErrors = append(allErrors, writePersistentVolumes(ctx, provider, zfsPath, pvSettings, readyPools)...)
+	}
+
+	if labelsFile := os.Getenv("ZPOOL_LABELS_FILE"); labelsFile != "" {
+		allErrors = append(allErrors, writeNodeLabels(ctx, provider, zpoolPath, zfsPath, labelsFile, readyPools)...)
+	}
+
+	monitor.check(ctx, provider, zpoolPath, stateDir, poolNames)
+	leavePhase()
+
+	if firstBootOnly && !firstBootCompleted {
+		recorded, err := recordFirstBoot(stateDir, allErrors)
 		if err != nil {
-			slog.Error("Failed to create pool", "pool", config.Name, "error", err)
-			allErrors = append(allErrors, fmt.Errorf("pool %q: %w", config.Name, err))
+			allErrors = append(allErrors, fmt.Errorf("failed to record first-boot completion: %w", err))
+		} else if recorded {
+			slog.Info("Recorded first-boot completion, future runs will skip pool creation.", "state_dir", stateDir)
 		}
 	}
 
+	errorFile := os.Getenv("ZPOOL_ERROR_FILE")
 	if len(allErrors) > 0 {
-		slog.Error("One or more pools failed to create.", "error_count", len(allErrors))
+		slog.Error("One or more configuration steps failed.", "error_count", len(allErrors))
 		for _, e := range allErrors {
 			slog.Error("Detailed error", "error", e)
 		}
-		os.Exit(1)
+		code := runExitCode(allErrors)
+		if err := writeErrorReport(os.Stderr, errorFile, newErrorReport(allErrors, code)); err != nil {
+			slog.Error("Failed to write the error report", "path", errorFile, "error", err)
+		}
+		os.Exit(finish(code))
+	}
+	if err := clearErrorReport(errorFile); err != nil {
+		slog.Warn("Failed to remove the error report of an earlier run", "path", errorFile, "error", err)
 	}
 
 	slog.Info("Talos ZFS Pool Extension: All pools processed successfully. Finished.")
+	finish(exitConverged)
+	if watchInterval > 0 {
+		os.Exit(watchMain(ctx, provider, zpoolPath, stateDir, poolNames, watchInterval, monitor, reloader))
+	}
 }
 
 // parsePoolConfigs reads nested indexed environment variables (ZPOOL_<n>_NAME, ZPOOL_<n>_DISK_<m>_DEV, etc.)
 // and returns a slice of poolConfig structs.
 func parsePoolConfigs() []poolConfig {
 	var configs []poolConfig
-	globalAshift := getEnv("ZPOOL_ASHIFT", defaultAshift)
+	defaults := readPoolDefaults()
 
-	for i := range maxPools {
+	// Invalid settings are reported by main and validate; the defaults apply here.
+	limit, _ := poolLimit()
+	skipGaps, _ := getEnvBool("ZPOOL_SKIP_GAPS", false)
+	configured := configuredPoolIndices()
+
+	for i := range limit {
 		poolNameKey := fmt.Sprintf("ZPOOL_%d_NAME", i)
 		poolName := os.Getenv(poolNameKey)
 
 		if poolName == "" {
-			// This is the normal exit condition, no more pools are defined.
+			if errs := poolJSONErrors[i]; len(errs) > 0 {
+				// The pool is configured, but its name could not be read. Fail it instead of
+				// silently ignoring it and all pools after it.
+				configs = append(configs, poolConfig{Name: fmt.Sprintf("ZPOOL_CONFIG_%d", i), ParseErrors: errs})
+				continue
+			}
+			later := poolIndicesFrom(configured, i+1, limit)
+			if len(later) == 0 {
+				// This is the normal exit condition, no more pools are defined.
+				break
+			}
+			if !skipGaps {
+				slog.Warn("Ignoring the pools after a gap in the pool indices, set ZPOOL_SKIP_GAPS=true to read them", "missing", poolNameKey, "ignored", later)
+				break
+			}
+			slog.Warn("Skipping a gap in the pool indices", "missing", poolNameKey)
+			continue
+		}
+
+		configs = append(configs, parsePoolConfig(i, poolName, defaults))
+	}
+
+	if beyond := poolIndicesFrom(configured, limit, math.MaxInt); len(beyond) > 0 {
+		slog.Warn("Reached the maximum number of pools allowed, ignoring further configurations. Raise ZPOOL_MAX_POOLS to read them.", "limit", limit, "ignored", beyond)
+	}
+
+	if len(configs) == 0 {
+		if legacy, ok := parseLegacyPoolConfig(defaults); ok {
+			configs = append(configs, legacy)
+		}
+	} else if _, ok := os.LookupEnv("ZPOOL_NAME"); ok {
+		slog.Warn("Ignoring deprecated ZPOOL_NAME, since indexed pools are configured", "pools", len(configs))
+	}
+
+	return orderPoolConfigs(configs)
+}
+
+// poolDefaults are the global settings that every pool inherits, read once per parse.
+type poolDefaults struct {
+	ashift string
+	strict bool
+	// strictErr is set if ZPOOL_STRICT is invalid, which fails every pool rather than letting
+	// them be created short of disks.
+	strictErr error
+	// facts are the node facts for the placeholders in disk values, only read if one is used.
+	facts func() map[string]string
+}
+
+// readPoolDefaults reads the global settings that the pools inherit.
+func readPoolDefaults() poolDefaults {
+	strict, strictErr := getEnvBool("ZPOOL_STRICT", false)
+	return poolDefaults{
+		ashift:    globalAshiftSetting(),
+		strict:    strict,
+		strictErr: strictErr,
+		facts:     sync.OnceValue(func() map[string]string { return currentNodeIdentity().facts() }),
+	}
+}
+
+// parsePoolConfig reads the configuration of pool i, named poolName, from the ZPOOL_<i>_*
+// variables on top of the global defaults.
+func parsePoolConfig(i int, poolName string, defaults poolDefaults) poolConfig {
+	facts := defaults.facts
+	poolTypeKey := fmt.Sprintf("ZPOOL_%d_TYPE", i)
+	poolType := os.Getenv(poolTypeKey)
+
+	ashift := poolAshiftSetting(i, defaults.ashift)
+
+	config := poolConfig{
+		Name:       poolName,
+		Type:       poolType,
+		Ashift:     ashift,
+		Mountpoint: strings.TrimSpace(os.Getenv(fmt.Sprintf("ZPOOL_%d_MOUNTPOINT", i))),
+		CanMount:   strings.TrimSpace(os.Getenv(fmt.Sprintf("ZPOOL_%d_CANMOUNT", i))),
+	}
+	config.ParseErrors = append(config.ParseErrors, poolJSONErrors[i]...)
+	var err error
+	if config.Type, err = draidTypeWithSettings(poolType, fmt.Sprintf("ZPOOL_%d_", i)); err != nil {
+		config.ParseErrors = append(config.ParseErrors, err)
+	}
+	var errs []error
+	config.MountOwner, errs = parseMountOwnership(i)
+	config.ParseErrors = append(config.ParseErrors, errs...)
+
+	// Parse nested disks
+	for j := 0; ; j++ {
+		devKey := fmt.Sprintf("ZPOOL_%d_DISK_%d_DEV", i, j)
+		modelKey := fmt.Sprintf("ZPOOL_%d_DISK_%d_MODEL", i, j)
+
+		devVal := os.Getenv(devKey)
+		modelVal := os.Getenv(modelKey)
+
+		if devVal == "" && modelVal == "" {
 			break
 		}
 
-		poolTypeKey := fmt.Sprintf("ZPOOL_%d_TYPE", i)
-		poolType := os.Getenv(poolTypeKey)
+		disk := diskSpec{Dev: strings.TrimSpace(devVal), Model: strings.TrimSpace(modelVal)}
+		for _, field := range []*string{&disk.Dev, &disk.Model} {
+			expanded, err := expandDiskTemplate(*field, facts)
+			if err != nil {
+				config.ParseErrors = append(config.ParseErrors, err)
+			}
+			*field = expanded
+		}
+		config.Disks = append(config.Disks, disk)
+	}
 
-		poolAshiftKey := fmt.Sprintf("ZPOOL_%d_ASHIFT", i)
-		ashift := getEnv(poolAshiftKey, globalAshift)
+	diskList, err := expandDiskTemplate(strings.TrimSpace(os.Getenv(fmt.Sprintf("ZPOOL_%d_DISKS", i))), facts)
+	if err != nil {
+		config.ParseErrors = append(config.ParseErrors, err)
+	}
+	config.DiskList = diskList
+	config.Vdevs, errs = parseVdevSpecs(i, facts)
+	config.ParseErrors = append(config.ParseErrors, errs...)
+	if config.Log, err = parseClassVdev(i, "log", facts); err != nil {
+		config.ParseErrors = append(config.ParseErrors, err)
+	}
+	if config.Special, err = parseClassVdev(i, "special", facts); err != nil {
+		config.ParseErrors = append(config.ParseErrors, err)
+	}
+	if config.Cache, err = parseClassVdev(i, "cache", facts); err != nil {
+		config.ParseErrors = append(config.ParseErrors, err)
+	}
+	config.CacheParams = make(map[string]string)
+	config.ParseErrors = append(config.ParseErrors, parsePoolPropertyList(fmt.Sprintf("ZPOOL_%d_CACHE_PARAMS", i), config.CacheParams)...)
+	config.SmallBlocks = strings.TrimSpace(os.Getenv(fmt.Sprintf("ZPOOL_%d_SPECIAL_SMALL_BLOCKS", i)))
+	config.Recordsize = strings.TrimSpace(os.Getenv(fmt.Sprintf("ZPOOL_%d_RECORDSIZE", i)))
+	if config.MixedVdevs, err = getEnvBool(fmt.Sprintf("ZPOOL_%d_MIXED_VDEVS", i), false); err != nil {
+		config.ParseErrors = append(config.ParseErrors, err)
+	}
 
-		config := poolConfig{
-			Name:   poolName,
-			Type:   poolType,
-			Ashift: ashift,
+	enabled, err := getEnvBool(fmt.Sprintf("ZPOOL_%d_ENABLED", i), true)
+	if err != nil {
+		config.ParseErrors = append(config.ParseErrors, err)
+	}
+	config.Disabled = !enabled
+
+	config.StrictDisks = defaults.strict
+	if defaults.strictErr != nil {
+		config.ParseErrors = append(config.ParseErrors, defaults.strictErr)
+	}
+	if v, ok := lookupAliased(fmt.Sprintf("ZPOOL_%d_STRICT", i), fmt.Sprintf("ZPOOL_%d_STRICT_DISKS", i)); ok {
+		if config.StrictDisks, err = strconv.ParseBool(v); err != nil {
+			config.ParseErrors = append(config.ParseErrors, fmt.Errorf("invalid boolean value %q for ZPOOL_%d_STRICT", v, i))
 		}
+	}
 
-		// Parse nested disks
-		for j := 0; ; j++ {
-			devKey := fmt.Sprintf("ZPOOL_%d_DISK_%d_DEV", i, j)
-			modelKey := fmt.Sprintf("ZPOOL_%d_DISK_%d_MODEL", i, j)
+	config.Properties, config.Reconcile, errs = parsePoolProperties(i)
+	config.ParseErrors = append(config.ParseErrors, errs...)
 
-			devVal := os.Getenv(devKey)
-			modelVal := os.Getenv(modelKey)
+	importPool, err := getEnvBool(fmt.Sprintf("ZPOOL_%d_IMPORT", i), true)
+	if err != nil {
+		config.ParseErrors = append(config.ParseErrors, err)
+	}
+	config.Import = importPool
 
-			if devVal == "" && modelVal == "" {
-				break
+	staged, err := getEnvBool(fmt.Sprintf("ZPOOL_%d_STAGED", i), false)
+	if err != nil {
+		config.ParseErrors = append(config.ParseErrors, err)
+	}
+	config.Staged = staged
+
+	adopt, err := getEnvBool(fmt.Sprintf("ZPOOL_%d_ADOPT_MOUNTPOINT", i), false)
+	if err != nil {
+		config.ParseErrors = append(config.ParseErrors, err)
+	}
+	config.Adopt = adopt
+
+	upgrade, err := getEnvBool(fmt.Sprintf("ZPOOL_%d_UPGRADE", i), false)
+	if err != nil {
+		config.ParseErrors = append(config.ParseErrors, err)
+	}
+	config.Upgrade = upgrade
+
+	config.Priority, config.After, err = parsePoolOrder(i)
+	if err != nil {
+		config.ParseErrors = append(config.ParseErrors, err)
+	}
+
+	config.Volumes, errs = parseVolumeConfigs(i)
+	config.ParseErrors = append(config.ParseErrors, errs...)
+
+	config.SwapSize = strings.TrimSpace(os.Getenv(fmt.Sprintf("ZPOOL_%d_SWAP_SIZE", i)))
+	if config.SwapSize != "" {
+		if _, _, err := parseSwapSize(config.SwapSize); err != nil {
+			config.ParseErrors = append(config.ParseErrors, fmt.Errorf("invalid ZPOOL_%d_SWAP_SIZE: %w", i, err))
+		}
+	}
+
+	initialize, err := getEnvBool(fmt.Sprintf("ZPOOL_%d_INITIALIZE", i), false)
+	if err != nil {
+		config.ParseErrors = append(config.ParseErrors, err)
+	}
+	config.Initialize = initialize
+
+	initializeWait, err := getEnvBool(fmt.Sprintf("ZPOOL_%d_INITIALIZE_WAIT", i), false)
+	if err != nil {
+		config.ParseErrors = append(config.ParseErrors, err)
+	}
+	config.InitializeWait = initializeWait
+
+	wait, err := getEnvDuration(fmt.Sprintf("ZPOOL_%d_WAIT_FOR_DISKS", i), 0)
+	if err != nil {
+		config.ParseErrors = append(config.ParseErrors, err)
+	}
+	config.WaitForDisks = wait
+
+	if config.CreateTimeout, err = getEnvDuration(fmt.Sprintf("ZPOOL_%d_CREATE_TIMEOUT", i), 0); err != nil {
+		config.ParseErrors = append(config.ParseErrors, err)
+	}
+	if config.ImportTimeout, err = getEnvDuration(fmt.Sprintf("ZPOOL_%d_IMPORT_TIMEOUT", i), 0); err != nil {
+		config.ParseErrors = append(config.ParseErrors, err)
+	}
+
+	config.Erase = strings.ToLower(strings.TrimSpace(os.Getenv(fmt.Sprintf("ZPOOL_%d_ERASE", i))))
+
+	trim, err := getEnvBool(fmt.Sprintf("ZPOOL_%d_TRIM", i), false)
+	if err != nil {
+		config.ParseErrors = append(config.ParseErrors, err)
+	}
+	config.Trim = trim
+
+	config.Dedup = strings.ToLower(strings.TrimSpace(os.Getenv(fmt.Sprintf("ZPOOL_%d_DEDUP", i))))
+	dedupAck, err := getEnvBool(fmt.Sprintf("ZPOOL_%d_DEDUP_ACK", i), false)
+	if err != nil {
+		config.ParseErrors = append(config.ParseErrors, err)
+	}
+	config.DedupAck = dedupAck
+
+	// Parse nested size filters
+	for j := 0; ; j++ {
+		sizeKey := fmt.Sprintf("ZPOOL_%d_SIZE_%d", i, j)
+		sizeVal := os.Getenv(sizeKey)
+		if sizeVal == "" {
+			break
+		}
+		config.SizeFilters = append(config.SizeFilters, strings.TrimSpace(sizeVal))
+	}
+
+	return config
+}
+
+// runState holds the state shared between all pool configurations processed in a single run.
+type runState struct {
+	existingPools map[string]string // Imported pools, mapping pool name to GUID as returned by ListPools.
+	usedDisks     map[string]bool   // Canonical device paths already picked by a pool configuration.
+
+	importScanned bool             // Whether the exported pool scan has run.
+	importable    []importablePool // Result of the exported pool scan.
+
+	dryRun     bool            // Only a plan is computed; never wait for devices to appear.
+	skipCreate bool            // No pool is created, see ZPOOL_FIRST_BOOT_ONLY; existing ones are still imported and reconciled.
+	notCreated map[string]bool // Missing pools left alone because of skipCreate, which have no datasets to mount.
+
+	udevSettleTimeout time.Duration // How long to wait for udev after device changes, 0 disables.
+	recovery          string        // Recovery policy for suspended or faulted pools, see recoverPool.
+	busyRetries       uint64        // How often zpool create is retried while a device is busy.
+	busyRetryDelay    time.Duration // Delay before each of those retries.
+	createDryRun      bool          // Whether zpool create -n validates each pool before its disks are touched.
+	logLatencyPolicy  string        // What to do with log devices that are slow at synchronous writes, see checkLogLatency.
+	logMaxLatency     time.Duration // Median synchronous write latency above which a log device is too slow.
+	diagnosticsDir    string        // Where to write diagnostic bundles for failed creates and imports, empty disables.
+	zdbPath           string        // Path of the zdb binary for diagnostics, empty if not found.
+	zfsPath           string        // Path of the zfs binary, empty if not found.
+	stagingDir        string        // Parent directory of the altroots of staged pools.
+	stateDir          string        // Persistent state directory, see ZPOOL_STATE_DIR.
+
+	createdMountpoints map[string]string // Mountpoint directories of the pools created in this run.
+
+	pinnedGUIDs   map[string]string // GUIDs pinned for pool names, nil disables pinning, see verifyPoolGUID.
+	guidPolicy    string            // What to do with a pool whose GUID differs from its pin.
+	guidsChanged  bool              // Whether pinnedGUIDs has to be saved.
+	notifications []notification    // Notifications to send once the current pool is processed.
+
+	statuses *poolStatusCache // Status of the imported pools, shared by the checks of existing pools, see poolStatuses.
+}
+
+// newRunState creates the run state for the given imported pools.
+func newRunState(existingPools map[string]string) *runState {
+	if existingPools == nil {
+		existingPools = make(map[string]string)
+	}
+	return &runState{existingPools: existingPools, usedDisks: make(map[string]bool), notCreated: make(map[string]bool)}
+}
+
+// poolStatuses returns the status cache of the run, creating it on first use. Everything that
+// changes the pool list or the vdev tree has to invalidate it.
+func (s *runState) poolStatuses(provider zfsProvider, zpoolPath string) *poolStatusCache {
+	if s.statuses == nil {
+		s.statuses = newPoolStatusCache(provider, zpoolPath)
+	}
+	return s.statuses
+}
+
+// invalidatePoolStatuses drops the cached pool status after a change to a pool.
+func (s *runState) invalidatePoolStatuses() {
+	if s.statuses != nil {
+		s.statuses.Invalidate()
+	}
+}
+
+// recordCreatedMountpoint remembers the mountpoint directory of a pool created in this run.
+func (s *runState) recordCreatedMountpoint(pool, mountpoint string) {
+	if s.createdMountpoints == nil {
+		s.createdMountpoints = make(map[string]string)
+	}
+	s.createdMountpoints[pool] = mountpoint
+}
+
+// settleUdev waits for udev to process pending events, so that by-id symlinks and other device
+// nodes exist before disks are probed or downstream consumers look for them. A udev that does
+// not settle is only logged: the devices may well be there anyway.
+func (s *runState) settleUdev(ctx context.Context, provider zfsProvider, reason string) {
+	if s.udevSettleTimeout <= 0 {
+		return
+	}
+	if err := provider.SettleUdev(ctx, s.udevSettleTimeout); err != nil {
+		slog.Warn("Failed to wait for udev", "reason", reason, "error", err)
+	}
+}
+
+// withCommandTimeout returns ctx with the given deadline for a single command, or ctx itself if
+// timeout is zero so that the provider applies ZPOOL_COMMAND_TIMEOUT.
+func withCommandTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// runCreate runs `zpool create` with args, each attempt with the given timeout (see
+// withCommandTimeout). While it fails because a device is busy, e.g. held by udev, partprobe or
+// multipath assembly during boot, it is retried up to busyRetries times after busyRetryDelay,
+// waiting for udev before each retry.
+func (s *runState) runCreate(ctx context.Context, provider zfsProvider, zpoolPath, pool string, args []string, timeout time.Duration) error {
+	for attempt := uint64(1); ; attempt++ {
+		createCtx, cancel := withCommandTimeout(ctx, timeout)
+		output, err := provider.CreatePool(createCtx, zpoolPath, args)
+		cancel()
+		if err == nil {
+			slog.Info("Zpool create command output", "pool", pool, "output", string(output))
+			return nil
+		}
+		err = newZpoolCommandError("create", err, output)
+		if !errors.Is(err, errDeviceBusy) || attempt > s.busyRetries {
+			return err
+		}
+		slog.Warn("A device is busy, retrying pool creation", "pool", pool, "attempt", attempt, "retries", s.busyRetries, "delay", s.busyRetryDelay, "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(s.busyRetryDelay):
+		}
+		activeWatchdog.progressed()
+		s.settleUdev(ctx, provider, "before retrying pool creation")
+	}
+}
+
+// createPool handles the logic for creating a single ZFS pool.
+func createPool(ctx context.Context, provider zfsProvider, zpoolPath string, config poolConfig, state *runState) error {
+	if err := validatePoolConfig(config); err != nil {
+		return err
+	}
+	mountpoint := config.Mountpoint
+	if mountpoint == "" {
+		mountpoint = filepath.Join(defaultMountDir, config.Name)
+	}
+	disks, err := configuredDisks(config)
+	if err != nil {
+		return err
+	}
+
+	// Check if the pool already exists
+	guid, exists := state.existingPools[config.Name]
+	if !exists && config.Import {
+		imported, err := importExportedPool(ctx, provider, zpoolPath, config, state)
+		if err != nil {
+			return err
+		}
+		if imported {
+			guid, exists = state.existingPools[config.Name], true
+			state.settleUdev(ctx, provider, "after import")
+		}
+	} else if exists {
+		// An imported pool was verified before its import.
+		if err := state.verifyPoolGUID(config.Name, guid); err != nil {
+			return err
+		}
+	}
+	if exists {
+		if err := recoverPool(ctx, provider, state.poolStatuses(provider, zpoolPath), zpoolPath, config.Name, guid, state.recovery, state.dryRun); err != nil {
+			return err
+		}
+		if err := state.adoptMountpoint(ctx, provider, config, mountpoint); err != nil {
+			return err
+		}
+		if err := ensureMountOwnership(provider, config, mountpoint); err != nil {
+			return err
+		}
+		if err := state.upgradePool(ctx, provider, zpoolPath, config); err != nil {
+			return err
+		}
+		if err := reconcileLog(ctx, provider, zpoolPath, config, state); err != nil {
+			return err
+		}
+		if err := reconcileCache(ctx, provider, zpoolPath, config, state); err != nil {
+			return err
+		}
+		if len(config.Reconcile) == 0 {
+			slog.Info("ZFS pool already exists. Nothing to do.", "pool", config.Name, "guid", guid)
+			return nil
+		}
+		slog.Info("ZFS pool already exists. Reconciling properties.", "pool", config.Name, "guid", guid, "properties", config.Reconcile)
+		return reconcilePoolProperties(ctx, provider, zpoolPath, config)
+	}
+
+	if len(disks) == 0 {
+		slog.Info("No disks specified for pool. Skipping.", "pool", config.Name)
+		return nil
+	}
+	if state.skipCreate {
+		slog.Warn("Pool does not exist, but the first boot already completed. Not creating it.", "pool", config.Name)
+		state.notCreated[config.Name] = true
+		return nil
+	}
+
+	sizeConds, err := parseSizeConditions(config.SizeFilters)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Probing specified disks", "pool", config.Name, "disks", disks)
+	usedDisks := state.usedDisks
+	disksToUse, unusable := selectDisks(provider, config.Name, disks, sizeConds, usedDisks)
+	if config.WaitForDisks > 0 && len(unusable) > 0 {
+		if state.dryRun {
+			// A plan reflects the disks present right now, so it never waits for missing ones.
+			for _, dev := range disksToUse {
+				delete(usedDisks, dev)
 			}
+		} else {
+			var err error
+			disksToUse, unusable, err = waitForAllDisks(ctx, provider, config, disks, sizeConds, usedDisks, disksToUse, unusable)
+			if err != nil {
+				return err
+			}
+		}
+		if len(unusable) > 0 && !config.StrictDisks {
+			slog.Warn("Not all configured disks became available in time. Leaving pool alone.", "pool", config.Name, "wait", config.WaitForDisks, "unusable", unusable)
+			if state.dryRun {
+				state.notCreated[config.Name] = true
+				return nil
+			}
+			// The pool is still missing, so the run has not converged and is retried.
+			return fmt.Errorf("%d of %d configured disks did not become usable within %s: %s", len(unusable), len(disks), config.WaitForDisks, strings.Join(unusable, ", "))
+		}
+	}
+	if (config.StrictDisks || len(config.Vdevs) > 0) && len(unusable) > 0 {
+		// Nothing has been done to the picked disks yet, so leave them to other pools.
+		for _, dev := range disksToUse {
+			delete(usedDisks, dev)
+		}
+		if !config.StrictDisks {
+			// Building the vdevs from the remaining disks would move disks between them.
+			return fmt.Errorf("%d of %d configured disks are unusable, the vdevs need all of them: %s", len(unusable), len(disks), strings.Join(unusable, ", "))
+		}
+		return fmt.Errorf("strict disk mode: %d of %d configured disks are unusable: %s", len(unusable), len(disks), strings.Join(unusable, ", "))
+	}
 
-			config.Disks = append(config.Disks, diskSpec{
-				Dev:   strings.TrimSpace(devVal),
-				Model: strings.TrimSpace(modelVal),
-			})
+	if len(disksToUse) == 0 {
+		return errors.New("no usable block devices found from the provided list")
+	}
+	var vdevArgs []string
+	var additions []vdevAddition
+	if len(config.Vdevs) > 0 {
+		var groups [][]string
+		groups, err = vdevCreateArgs(config.Vdevs, disksToUse)
+		for m, group := range groups {
+			if addedLater(config, config.Vdevs[m]) {
+				additions = append(additions, vdevAddition{Ashift: config.Vdevs[m].Ashift, Args: group})
+			} else {
+				vdevArgs = append(vdevArgs, group...)
+			}
+		}
+	} else {
+		// zpool's own errors for a dRAID layout that does not fit the disks are hard to make sense of.
+		err = validateDraidType(config.Type, len(disksToUse))
+		if config.Type != "" {
+			vdevArgs = append(vdevArgs, config.Type)
 		}
+		vdevArgs = append(vdevArgs, disksToUse...)
+	}
+	if err == nil {
+		err = checkDedupMemory(provider, config, disksToUse)
+	}
+	for _, class := range vdevClasses {
+		vdev := config.classVdev(class)
+		if err != nil || vdev.DiskList == "" {
+			continue
+		}
+		var classDisks []string
+		if classDisks, err = selectClassDisks(provider, config.Name, class, vdev, usedDisks); err == nil {
+			if addedLater(config, vdev) {
+				additions = append(additions, vdevAddition{Ashift: vdev.Ashift, Args: classVdevArgs(class, vdev, classDisks)})
+			} else {
+				vdevArgs = append(vdevArgs, classVdevArgs(class, vdev, classDisks)...)
+			}
+			disksToUse = append(disksToUse, classDisks...)
+			if class == "log" {
+				err = state.checkLogLatency(provider, config.Name, classDisks)
+			}
+		}
+	}
+	if err != nil {
+		for _, dev := range disksToUse {
+			delete(usedDisks, dev)
+		}
+		return err
+	}
 
-		// Parse nested size filters
-		for j := 0; ; j++ {
-			sizeKey := fmt.Sprintf("ZPOOL_%d_SIZE_%d", i, j)
-			sizeVal := os.Getenv(sizeKey)
-			if sizeVal == "" {
-				break
+	args := []string{"create", "-m", mountpoint, "-o", "ashift=" + config.Ashift}
+	if config.MixedVdevs {
+		args = append(args, "-f")
+	}
+	args = append(args, poolPropertyArgs(config.Properties)...)
+	if config.CanMount != "" {
+		args = append(args, "-O", "canmount="+config.CanMount)
+	}
+	if config.Dedup != "" {
+		args = append(args, "-O", "dedup="+config.Dedup)
+	}
+	if config.Recordsize != "" {
+		args = append(args, "-O", "recordsize="+config.Recordsize)
+	}
+	if config.SmallBlocks != "" {
+		args = append(args, "-O", "special_small_blocks="+config.SmallBlocks)
+	}
+	args = append(args, config.Name)
+	args = append(args, vdevArgs...)
+	var altroot string
+	if config.Staged {
+		if state.stagingDir == "" {
+			for _, dev := range disksToUse {
+				delete(usedDisks, dev)
 			}
-			config.SizeFilters = append(config.SizeFilters, strings.TrimSpace(sizeVal))
+			return errors.New("staged creation requires a state directory")
+		}
+		altroot = filepath.Join(state.stagingDir, config.Name)
+		args = stagedCreateArgs(args, altroot)
+	}
+
+	if err := state.validateCreate(ctx, provider, zpoolPath, config, args); err != nil {
+		// Nothing has been done to the disks yet, so leave them to other pools.
+		for _, dev := range disksToUse {
+			delete(usedDisks, dev)
 		}
+		return withDevices(err, disksToUse)
+	}
 
-		configs = append(configs, config)
+	if config.Erase != eraseNone {
+		if err := eraseDisks(provider, config.Name, config.Erase, disksToUse); err != nil {
+			return err
+		}
+	} else if config.Trim {
+		// An erase already discarded every block, so trimming is only needed without one.
+		trimDisks(provider, config.Name, disksToUse)
+	}
+
+	// Create ZFS pool
+	slog.Info("Creating ZFS pool", "pool", config.Name, "ashift", config.Ashift, "type", config.Type, "mountpoint", mountpoint)
+	if filepath.IsAbs(mountpoint) && !strings.HasPrefix(mountpoint, defaultMountDir+"/") {
+		slog.Warn("Mountpoint is outside of the directory shared with the host and will not be visible to workloads", "pool", config.Name, "mountpoint", mountpoint, "shared_dir", defaultMountDir)
+	}
+	slog.Info("Running zpool command", "pool", config.Name, "args", strings.Join(args, " "))
+	if err := state.runCreate(ctx, provider, zpoolPath, config.Name, args, config.CreateTimeout); err != nil {
+		err = withDevices(err, disksToUse)
+		state.collectDiagnostics(ctx, provider, config.Name, "create", disksToUse, err)
+		return err
+	}
+	if config.Staged {
+		if err := state.promoteStagedPool(ctx, provider, zpoolPath, config, altroot); err != nil {
+			return fmt.Errorf("staged creation failed: %w", err)
+		}
+	}
+	if err := addVdevs(ctx, provider, zpoolPath, config, additions); err != nil {
+		return fmt.Errorf("pool created but %w", err)
+	}
+	slog.Info("ZFS pool created successfully", "pool", config.Name)
+	state.invalidatePoolStatuses()
+	state.pinCreatedPool(ctx, provider, zpoolPath, config.Name)
+	state.settleUdev(ctx, provider, "after create")
+	if filepath.IsAbs(mountpoint) {
+		state.recordCreatedMountpoint(config.Name, mountpoint)
+	}
+	if err := ensureMountOwnership(provider, config, mountpoint); err != nil {
+		return fmt.Errorf("pool created but %w", err)
 	}
 
-	// After the loop, check if the reason for stopping was hitting the limit.
-	if os.Getenv(fmt.Sprintf("ZPOOL_%d_NAME", maxPools)) != "" {
-		slog.Warn("Reached the maximum number of pools allowed, ignoring further configurations.", "limit", maxPools)
+	if config.Initialize {
+		// The pool itself is usable at this point, so an initialization failure is reported
+		// without undoing the creation.
+		if err := initializePool(ctx, provider, zpoolPath, config); err != nil {
+			return fmt.Errorf("pool created but initialization failed: %w", err)
+		}
 	}
 
-	return configs
+	return nil
 }
 
-// createPool handles the logic for creating a single ZFS pool.
-func createPool(provider zfsProvider, zpoolPath string, config poolConfig, usedDisks map[string]bool) error {
-	// Validate inputs
+// validatePoolConfig checks the configuration of a pool for invalid values without looking at the system.
+func validatePoolConfig(config poolConfig) (err error) {
+	// All of these are configuration errors, which no restart of the service fixes.
+	defer func() {
+		if err != nil {
+			err = configError{err}
+		}
+	}()
+	if err := errors.Join(config.ParseErrors...); err != nil {
+		return err
+	}
 	if !isValidZpoolName(config.Name) {
 		return fmt.Errorf("invalid name: %q", config.Name)
 	}
 	if !isValidZpoolType(config.Type) {
-		return fmt.Errorf("invalid type: %q", config.Type)
+		return fmt.Errorf("invalid type: %q, run `create-zpool capabilities` for the supported ones", config.Type)
+	}
+	if err := validateDraidType(config.Type, 0); err != nil {
+		return err
+	}
+	if err := validateDraidChildren(config); err != nil {
+		return err
 	}
 	if !isValidAshift(config.Ashift) {
 		return fmt.Errorf("invalid ashift value: %q", config.Ashift)
 	}
-	if len(config.Disks) == 0 {
-		slog.Info("No disks specified for pool. Skipping.", "pool", config.Name)
-		return nil
+	mountpoint := config.Mountpoint
+	if mountpoint == "" {
+		mountpoint = filepath.Join(defaultMountDir, config.Name)
+	}
+	if !isValidMountpoint(mountpoint) {
+		return fmt.Errorf("invalid mountpoint: %q", config.Mountpoint)
+	}
+	if !isValidCanMount(config.CanMount) {
+		return fmt.Errorf("invalid canmount value: %q", config.CanMount)
+	}
+	if !isValidEraseMode(config.Erase) {
+		return fmt.Errorf("invalid erase mode: %q", config.Erase)
 	}
+	if err := validateDedup(config); err != nil {
+		return err
+	}
+	if err := validateVdevs(config); err != nil {
+		return err
+	}
+	// Invalid data disks are reported by configuredDisks wherever they are used.
+	taken, _ := configuredDisks(config)
+	for _, class := range vdevClasses {
+		vdev := config.classVdev(class)
+		if err := validateClassVdev(class, vdev, taken); err != nil {
+			return err
+		}
+		disks, _ := vdev.disks()
+		taken = append(taken, disks...)
+	}
+	if err := validateSpecialRedundancy(config); err != nil {
+		return err
+	}
+	if err := validateSmallBlocks(config); err != nil {
+		return err
+	}
+	if err := validateCacheParams(config); err != nil {
+		return err
+	}
+	return validatePoolProperties(config.Properties, config.Reconcile)
+}
 
-	// Check if the pool already exists
-	if provider.PoolExists(config.Name, zpoolPath) {
-		slog.Info("ZFS pool already exists. Nothing to do.", "pool", config.Name)
-		return nil
+// enabledPoolConfigs drops disabled pool configurations and returns the remaining ones together
+// with the names of all configured pools. Disabled pools keep their name in the list, so that
+// they are still reported and their mountpoints are not mistaken for stale ones.
+func enabledPoolConfigs(configs []poolConfig) ([]poolConfig, []string) {
+	var enabled []poolConfig
+	var names []string
+	for _, config := range configs {
+		names = append(names, config.Name)
+		if config.Disabled {
+			slog.Info("Pool configuration is disabled, skipping it.", "pool", config.Name)
+			continue
+		}
+		enabled = append(enabled, config)
+	}
+	return enabled, names
+}
+
+// configuredDisks returns the indexed disks of a pool followed by those of its compact disk list
+// and those of its vdevs.
+func configuredDisks(config poolConfig) ([]diskSpec, error) {
+	disks := config.Disks
+	if config.DiskList != "" {
+		listed, err := parseDiskList(config.DiskList)
+		if err != nil {
+			return nil, fmt.Errorf("invalid disk list %q: %w", config.DiskList, err)
+		}
+		disks = append(slices.Clip(disks), listed...)
+	}
+	if len(config.Vdevs) > 0 {
+		listed, err := vdevDisks(config.Vdevs)
+		if err != nil {
+			return nil, err
+		}
+		disks = append(slices.Clip(disks), listed...)
 	}
+	return disks, nil
+}
 
-	// Parse size conditions if specified
+// parseSizeConditions parses the pool-wide size filters of a pool.
+func parseSizeConditions(filters []string) ([]sizeCondition, error) {
 	var sizeConds []sizeCondition
-	for _, condStr := range config.SizeFilters {
+	for _, condStr := range filters {
 		cond, err := parseSizeCondition(condStr)
 		if err != nil {
-			return fmt.Errorf("invalid size filter condition %q: %w", condStr, err)
+			return nil, fmt.Errorf("invalid size filter condition %q: %w", condStr, err)
 		}
 		sizeConds = append(sizeConds, cond)
 	}
+	return sizeConds, nil
+}
+
+// selectDisks probes explicit devices concurrently, then picks the disks to use in the exact
+// ordered declaration, resolving model queries against the disks not yet in usedDisks.
+// Picked disks are marked as used. Entries that could not be used are returned as
+// human-readable descriptions in unusable.
+func selectDisks(provider zfsProvider, pool string, disks []diskSpec, sizeConds []sizeCondition, usedDisks map[string]bool) (selected, unusable []string) {
+	probes := probeDevices(provider, disks, sizeConds, probeParallelism)
 
-	// Probe for specified disks in the exact ordered declaration
-	slog.Info("Probing specified disks", "pool", config.Name, "disks", config.Disks)
-	var disksToUse []string
-	for _, disk := range config.Disks {
+	for i, disk := range disks {
 		if disk.Dev != "" {
-			canonicalDev, err := provider.EvalSymlinks(disk.Dev)
-			if err != nil {
-				slog.Warn("Error resolving symlink for device. Skipping.", "pool", config.Name, "device", disk.Dev, "error", err)
+			probe := probes[i]
+			if probe.problem != "" {
+				logArgs := []any{"pool", pool, "device", probe.device}
+				if probe.err != nil {
+					logArgs = append(logArgs, "error", probe.err)
+				}
+				slog.Warn(probe.problem, logArgs...)
+				unusable = append(unusable, disk.Dev)
 				continue
 			}
-
-			isBlock, err := provider.IsBlockDevice(canonicalDev)
-			if err != nil {
-				slog.Warn("Error checking device. Skipping.", "pool", config.Name, "device", canonicalDev, "error", err)
+			if usedDisks[probe.device] {
+				slog.Warn("Device is already used by another configuration or disk. Skipping.", "pool", pool, "device", probe.device)
+				unusable = append(unusable, disk.Dev)
 				continue
 			}
-			if isBlock {
-				if usedDisks[canonicalDev] {
-					slog.Warn("Device is already used by another configuration or disk. Skipping.", "pool", config.Name, "device", canonicalDev)
-					continue
-				}
-				if !diskMatchesSize(provider, canonicalDev, sizeConds) {
-					slog.Warn("Device size does not match size conditions. Skipping.", "pool", config.Name, "device", canonicalDev)
-					continue
-				}
-				slog.Info("Found block device", "pool", config.Name, "device", canonicalDev)
-				disksToUse = append(disksToUse, canonicalDev)
-				usedDisks[canonicalDev] = true
-			} else {
-				slog.Warn("Device is not a block device or does not exist. Skipping.", "pool", config.Name, "device", canonicalDev)
-			}
+			slog.Info("Found block device", "pool", pool, "device", probe.device)
+			selected = append(selected, probe.device)
+			usedDisks[probe.device] = true
 		} else if disk.Model != "" {
 			resolved, err := provider.ResolveDiskByModel(disk.Model, sizeConds, usedDisks)
 			if err != nil {
-				slog.Warn("Error resolving disk by model. Skipping.", "pool", config.Name, "model", disk.Model, "error", err)
+				slog.Warn("Error resolving disk by model. Skipping.", "pool", pool, "model", disk.Model, "error", err)
+				unusable = append(unusable, "model "+strconv.Quote(disk.Model))
 				continue
 			}
-			slog.Info("Resolved model to block device", "pool", config.Name, "model", disk.Model, "device", resolved)
-			disksToUse = append(disksToUse, resolved)
+			slog.Info("Resolved model to block device", "pool", pool, "model", disk.Model, "device", resolved)
+			selected = append(selected, resolved)
 			usedDisks[resolved] = true
 		}
 	}
 
-	if len(disksToUse) == 0 {
-		return errors.New("no usable block devices found from the provided list")
+	return selected, unusable
+}
+
+// waitForAllDisks re-runs disk selection until every configured disk is usable or
+// config.WaitForDisks has elapsed. selected and unusable are the result of the initial
+// selection. If disks are still missing at the deadline, none of them are left marked as used.
+func waitForAllDisks(ctx context.Context, provider zfsProvider, config poolConfig, disks []diskSpec, sizeConds []sizeCondition, usedDisks map[string]bool, selected, unusable []string) ([]string, []string, error) {
+	deadline := time.Now().Add(config.WaitForDisks)
+	for len(unusable) > 0 {
+		// Release the partial selection so it can be redone from scratch.
+		for _, dev := range selected {
+			delete(usedDisks, dev)
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, unusable, nil
+		}
+		slog.Info("Waiting for all configured disks to become available", "pool", config.Name, "unusable", unusable, "remaining", remaining.Round(time.Second))
+
+		select {
+		case <-ctx.Done():
+			return nil, unusable, ctx.Err()
+		case <-time.After(min(diskWaitPollInterval, remaining)):
+		}
+		activeWatchdog.progressed()
+		selected, unusable = selectDisks(provider, config.Name, disks, sizeConds, usedDisks)
 	}
+	return selected, nil, nil
+}
 
-	// Create ZFS pool
-	slog.Info("Creating ZFS pool", "pool", config.Name, "ashift", config.Ashift, "type", config.Type)
+// diskProbe holds the outcome of probing an explicitly configured device.
+type diskProbe struct {
+	device  string // Canonical device path, or the configured path if it could not be resolved.
+	problem string // Log message describing why the device is unusable; empty if it can be used.
+	err     error  // Underlying error for the problem, if any.
+}
 
-	args := []string{"create", "-m", "/var/mnt/" + config.Name, "-o", "ashift=" + config.Ashift, config.Name}
-	if config.Type != "" {
-		args = append(args, config.Type)
+// probeDevices probes all explicitly configured devices in disks, running at most
+// parallelism probes at a time. The results are indexed like disks; entries for
+// model based specs are left empty, since those depend on previously picked disks.
+func probeDevices(provider zfsProvider, disks []diskSpec, sizeConds []sizeCondition, parallelism int) []diskProbe {
+	results := make([]diskProbe, len(disks))
+	sem := make(chan struct{}, max(parallelism, 1))
+
+	var wg sync.WaitGroup
+	for i, disk := range disks {
+		if disk.Dev == "" {
+			continue
+		}
+		wg.Go(func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = probeDevice(provider, disk.Dev, sizeConds)
+		})
 	}
-	args = append(args, disksToUse...)
+	wg.Wait()
 
-	slog.Info("Running zpool command", "pool", config.Name, "args", strings.Join(args, " "))
-	output, err := provider.CreatePool(zpoolPath, args)
+	return results
+}
+
+// probeDevice resolves dev to its canonical path and checks that it is a block device
+// meeting all size conditions.
+func probeDevice(provider zfsProvider, dev string, sizeConds []sizeCondition) diskProbe {
+	canonicalDev, err := provider.EvalSymlinks(dev)
 	if err != nil {
-		return fmt.Errorf("zpool create command failed: %w. Output: %s", err, string(output))
+		return diskProbe{device: dev, problem: "Error resolving symlink for device. Skipping.", err: err}
 	}
-	slog.Info("Zpool create command output", "pool", config.Name, "output", string(output))
-	slog.Info("ZFS pool created successfully", "pool", config.Name)
 
-	// Show status
-	slog.Info("Showing pool status", "pool", config.Name)
-	statusOutput, err := provider.GetPoolStatus(config.Name, zpoolPath)
+	isBlock, err := provider.IsBlockDevice(canonicalDev)
 	if err != nil {
-		slog.Warn("Failed to show pool status, but pool may have been created.", "pool", config.Name, "error", err, "output", string(statusOutput))
-	} else {
-		slog.Info("Zpool status", "pool", config.Name, "status", string(statusOutput))
+		return diskProbe{device: canonicalDev, problem: "Error checking device. Skipping.", err: err}
+	}
+	if !isBlock {
+		return diskProbe{device: canonicalDev, problem: "Device is not a block device or does not exist. Skipping."}
+	}
+	if !diskMatchesSize(provider, canonicalDev, sizeConds) {
+		return diskProbe{device: canonicalDev, problem: "Device size does not match size conditions. Skipping."}
 	}
 
-	return nil
+	return diskProbe{device: canonicalDev}
+}
+
+// parseDiskList parses a compact disk list as used by ZPOOL_<n>_DISKS.
+// A value starting with "[" is decoded as a JSON array whose elements are either
+// device path strings or objects with a "dev" or "model" key. Any other value is
+// treated as a list of device paths separated by whitespace, commas or semicolons, as
+// templating tools often join lists with commas.
+func parseDiskList(s string) ([]diskSpec, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "[") {
+		var disks []diskSpec
+		for _, dev := range strings.FieldsFunc(s, isDiskListSeparator) {
+			disks = append(disks, diskSpec{Dev: dev})
+		}
+		return disks, nil
+	}
+
+	var entries []json.RawMessage
+	if err := json.Unmarshal([]byte(s), &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON disk list: %w", err)
+	}
+
+	disks := make([]diskSpec, 0, len(entries))
+	for i, entry := range entries {
+		var disk diskSpec
+		var dev string
+		if err := json.Unmarshal(entry, &dev); err == nil {
+			disk.Dev = dev
+		} else if err := json.Unmarshal(entry, &disk); err != nil {
+			return nil, fmt.Errorf("entry %d must be a device path or an object with \"dev\" or \"model\": %w", i, err)
+		}
+
+		disk.Dev = strings.TrimSpace(disk.Dev)
+		disk.Model = strings.TrimSpace(disk.Model)
+		if (disk.Dev == "") == (disk.Model == "") {
+			return nil, fmt.Errorf("entry %d must define exactly one of \"dev\" or \"model\"", i)
+		}
+		disks = append(disks, disk)
+	}
+	return disks, nil
+}
+
+// isDiskListSeparator reports whether r separates the entries of a plain disk list.
+func isDiskListSeparator(r rune) bool {
+	return r == ',' || r == ';' || unicode.IsSpace(r)
+}
+
+// resolveBinary returns the path of the named binary. If envKey is set, its value must be an
+// absolute path to an executable file and is used as is; otherwise the binary is looked up in PATH.
+func resolveBinary(provider zfsProvider, envKey, name string) (string, error) {
+	path, ok := os.LookupEnv(envKey)
+	if !ok {
+		return provider.LookPath(name)
+	}
+
+	path = strings.TrimSpace(path)
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("%s must be an absolute path, got %q", envKey, path)
+	}
+	// LookPath on a path containing a slash only checks that it is an executable file.
+	resolved, err := provider.LookPath(path)
+	if err != nil {
+		return "", fmt.Errorf("%s=%q is not an executable file: %w", envKey, path, err)
+	}
+	return resolved, nil
+}
+
+// parseDuration parses a Go duration string (e.g. "90s", "5m"). A plain "0" disables the
+// corresponding deadline, and negative durations are rejected.
+func parseDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("duration %q must not be negative", s)
+	}
+	return d, nil
+}
+
+// getEnvBool reads a boolean environment variable as accepted by strconv.ParseBool.
+// Unset or empty variables return fallback.
+func getEnvBool(key string, fallback bool) (bool, error) {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fallback, fmt.Errorf("invalid boolean value %q for %s", value, key)
+	}
+	return b, nil
+}
+
+// getEnvDuration reads a duration environment variable using parseDuration.
+// Unset or empty variables return fallback.
+func getEnvDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := parseDuration(value)
+	if err != nil {
+		return fallback, fmt.Errorf("invalid duration %q for %s: %w", value, key, err)
+	}
+	return d, nil
+}
+
+// getEnvUint reads a non-negative integer environment variable.
+// Unset or empty variables return fallback.
+func getEnvUint(key string, fallback uint64) (uint64, error) {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return fallback, fmt.Errorf("invalid non-negative integer %q for %s", value, key)
+	}
+	return n, nil
+}
+
+// lookupEnvTrimmed returns the trimmed value of an environment variable and whether it is set
+// to a non-empty value.
+func lookupEnvTrimmed(key string) (string, bool) {
+	value := strings.TrimSpace(os.Getenv(key))
+	return value, value != ""
 }
 
 func getEnv(key, fallback string) string {
@@ -295,22 +1688,17 @@ func isValidZpoolName(name string) bool {
 	return true
 }
 
-// isValidZpoolType checks if the zpool type is one of the allowed values.
+// supportedVdevTypes are the accepted values of ZPOOL_<n>_TYPE besides the empty one, which
+// adds every disk as a vdev of its own.
+var supportedVdevTypes = []string{"mirror", "raidz", "raidz1", "raidz2", "raidz3", "draid", "draid1", "draid2", "draid3"}
+
+// isValidZpoolType checks if the zpool type is one of the allowed values. dRAID types may carry
+// a layout, e.g. draid2:4d:10c:1s, which validateDraidType checks.
 func isValidZpoolType(poolType string) bool {
-	allowedTypes := map[string]bool{
-		"":       true, // No type, for single disk or complex vdevs
-		"mirror": true,
-		"raidz":  true,
-		"raidz1": true,
-		"raidz2": true,
-		"raidz3": true,
-		"draid":  true,
-		"draid1": true,
-		"draid2": true,
-		"draid3": true,
+	if base, _, ok := strings.Cut(poolType, ":"); ok && strings.HasPrefix(base, "draid") {
+		poolType = base
 	}
-	_, ok := allowedTypes[poolType]
-	return ok
+	return poolType == "" || slices.Contains(supportedVdevTypes, poolType)
 }
 
 // isValidAshift checks if the ashift value is a valid integer.
@@ -319,6 +1707,24 @@ func isValidAshift(ashift string) bool {
 	return err == nil
 }
 
+// isValidMountpoint checks if the mountpoint is "none", "legacy" or a clean absolute path.
+func isValidMountpoint(mountpoint string) bool {
+	if mountpoint == "none" || mountpoint == "legacy" {
+		return true
+	}
+	return filepath.IsAbs(mountpoint) && filepath.Clean(mountpoint) == mountpoint
+}
+
+// isValidCanMount checks if the canmount value is one of the values accepted by zfsprops(7).
+// An empty value leaves the property at its default.
+func isValidCanMount(canMount string) bool {
+	switch canMount {
+	case "", "on", "off", "noauto":
+		return true
+	}
+	return false
+}
+
 // diskMatchesSize checks if the block device meets all specified size conditions.
 func diskMatchesSize(provider zfsProvider, path string, conds []sizeCondition) bool {
 	if len(conds) == 0 {
@@ -1,23 +1,79 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 type mockZFSProvider struct {
-	LookPathFunc           func(file string) (string, error)
-	PoolExistsFunc         func(name, zpoolPath string) bool
-	CreatePoolFunc         func(zpoolPath string, args []string) ([]byte, error)
-	GetPoolStatusFunc      func(name, zpoolPath string) ([]byte, error)
-	IsBlockDeviceFunc      func(path string) (bool, error)
-	ResolveDiskByModelFunc func(model string, sizeConds []sizeCondition, usedDisks map[string]bool) (string, error)
-	GetDiskSizeFunc        func(path string) (uint64, error)
-	EvalSymlinksFunc       func(path string) (string, error)
+	LookPathFunc              func(file string) (string, error)
+	ListPoolsFunc             func(ctx context.Context, zpoolPath string) (map[string]string, error)
+	CreatePoolFunc            func(ctx context.Context, zpoolPath string, args []string) ([]byte, error)
+	AddVdevsFunc              func(ctx context.Context, zpoolPath string, args []string) ([]byte, error)
+	GetPoolStatusFunc         func(ctx context.Context, name, zpoolPath string) ([]byte, error)
+	GetAllPoolStatusFunc      func(ctx context.Context, zpoolPath string) ([]byte, error)
+	ListImportablePoolsFunc   func(ctx context.Context, zpoolPath string) ([]importablePool, error)
+	ImportPoolFunc            func(ctx context.Context, zpoolPath, id string) ([]byte, error)
+	GetPoolPropertiesFunc     func(ctx context.Context, zpoolPath, name string, props []string) (map[string]string, error)
+	SetPoolPropertyFunc       func(ctx context.Context, zpoolPath, name, prop, value string) ([]byte, error)
+	InitializePoolFunc        func(ctx context.Context, zpoolPath, name string) ([]byte, error)
+	GetInitializeStatusFunc   func(ctx context.Context, zpoolPath, name string) ([]byte, error)
+	ReadModuleParameterFunc   func(name string) (string, error)
+	WriteModuleParameterFunc  func(name, value string) error
+	GetMemTotalFunc           func() (uint64, error)
+	GetVersionFunc            func(ctx context.Context, zpoolPath string) ([]byte, error)
+	ListPoolFeaturesFunc      func() ([]string, error)
+	DiscardDeviceFunc         func(path string, secure bool) error
+	GetQueueInfoFunc          func(path string) (queueInfo, error)
+	GetDiskModelFunc          func(path string) (string, error)
+	BurnInDeviceFunc          func(path string, size, seed uint64) error
+	ProbeSyncWriteLatencyFunc func(path string, writes int) (time.Duration, error)
+	GetDatasetPropertiesFunc  func(ctx context.Context, zfsPath, dataset string, props []string) (map[string]string, error)
+	EnsureOwnershipFunc       func(path string, uid, gid, mode int) (bool, error)
+	ListDatasetsFunc          func(ctx context.Context, zfsPath, pool string) ([]datasetInfo, error)
+	LoadKeysFunc              func(ctx context.Context, zfsPath, pool string) ([]byte, error)
+	MountDatasetFunc          func(ctx context.Context, zfsPath, dataset string) ([]byte, error)
+	SettleUdevFunc            func(ctx context.Context, timeout time.Duration) error
+	TriggerVolumeEventsFunc   func() error
+	ImportPoolReadOnlyFunc    func(ctx context.Context, zpoolPath, id string) ([]byte, error)
+	ExportPoolFunc            func(ctx context.Context, zpoolPath, name string) ([]byte, error)
+	ClearPoolFunc             func(ctx context.Context, zpoolPath, name string) ([]byte, error)
+	ClearDeviceFunc           func(ctx context.Context, zpoolPath, name, device string) ([]byte, error)
+	OfflineDeviceFunc         func(ctx context.Context, zpoolPath, name, device string) ([]byte, error)
+	ReplaceDeviceFunc         func(ctx context.Context, zpoolPath, name, device, replacement string) ([]byte, error)
+	AttachDeviceFunc          func(ctx context.Context, zpoolPath, name, device, newDevice, ashift string) ([]byte, error)
+	DetachDeviceFunc          func(ctx context.Context, zpoolPath, name, device string) ([]byte, error)
+	RemoveVdevFunc            func(ctx context.Context, zpoolPath, name, vdev string) ([]byte, error)
+	GetPoolUsageFunc          func(ctx context.Context, zpoolPath, name string) (poolSample, error)
+	GetPoolHistoryFunc        func(ctx context.Context, zpoolPath, name string) ([]byte, error)
+	ReadDeviceLabelsFunc      func(ctx context.Context, zdbPath, device string) ([]byte, error)
+	ListVolumesFunc           func(ctx context.Context, zfsPath, pool string) (map[string]string, error)
+	CreateVolumeFunc          func(ctx context.Context, zfsPath, dataset string, size uint64, props map[string]string) ([]byte, error)
+	IsSwapFormattedFunc       func(path string) (formatted, blank bool, err error)
+	FormatSwapFunc            func(path string) error
+	EnableSwapFunc            func(path string) error
+	SnapshotRecursiveFunc     func(ctx context.Context, zfsPath, snapshot string) ([]byte, error)
+	CheckpointPoolFunc        func(ctx context.Context, zpoolPath, name string) ([]byte, error)
+	DiscardCheckpointFunc     func(ctx context.Context, zpoolPath, name string) ([]byte, error)
+	UpgradePoolFunc           func(ctx context.Context, zpoolPath, name string) ([]byte, error)
+	SplitPoolFunc             func(ctx context.Context, zpoolPath, name, newName string, devices []string) ([]byte, error)
+	IsCharDeviceFunc          func(path string) (bool, error)
+	GetPropertyHelpFunc       func(ctx context.Context, binPath string) ([]byte, error)
+	SetDatasetPropertyFunc    func(ctx context.Context, zfsPath, dataset, prop, value string) ([]byte, error)
+	DryRunCreatePoolFunc      func(ctx context.Context, zpoolPath string, args []string) ([]byte, error)
+	IsBlockDeviceFunc         func(path string) (bool, error)
+	ResolveDiskByModelFunc    func(model string, sizeConds []sizeCondition, usedDisks map[string]bool) (string, error)
+	GetDiskSizeFunc           func(path string) (uint64, error)
+	EvalSymlinksFunc          func(path string) (string, error)
 }
 
 func (m *mockZFSProvider) LookPath(file string) (string, error) {
@@ -27,27 +83,384 @@ func (m *mockZFSProvider) LookPath(file string) (string, error) {
 	return "/fake/zpool", nil
 }
 
-func (m *mockZFSProvider) PoolExists(name, zpoolPath string) bool {
-	if m.PoolExistsFunc != nil {
-		return m.PoolExistsFunc(name, zpoolPath)
+func (m *mockZFSProvider) ListPools(ctx context.Context, zpoolPath string) (map[string]string, error) {
+	if m.ListPoolsFunc != nil {
+		return m.ListPoolsFunc(ctx, zpoolPath)
 	}
-	return false
+	return map[string]string{}, nil
 }
 
-func (m *mockZFSProvider) CreatePool(zpoolPath string, args []string) ([]byte, error) {
+func (m *mockZFSProvider) CreatePool(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
 	if m.CreatePoolFunc != nil {
-		return m.CreatePoolFunc(zpoolPath, args)
+		return m.CreatePoolFunc(ctx, zpoolPath, args)
 	}
 	return []byte("Pool created successfully"), nil
 }
 
-func (m *mockZFSProvider) GetPoolStatus(name, zpoolPath string) ([]byte, error) {
+func (m *mockZFSProvider) GetPoolStatus(ctx context.Context, name, zpoolPath string) ([]byte, error) {
 	if m.GetPoolStatusFunc != nil {
-		return m.GetPoolStatusFunc(name, zpoolPath)
+		return m.GetPoolStatusFunc(ctx, name, zpoolPath)
 	}
 	return []byte("Pool is online"), nil
 }
 
+func (m *mockZFSProvider) AddVdevs(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+	if m.AddVdevsFunc != nil {
+		return m.AddVdevsFunc(ctx, zpoolPath, args)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) GetAllPoolStatus(ctx context.Context, zpoolPath string) ([]byte, error) {
+	if m.GetAllPoolStatusFunc != nil {
+		return m.GetAllPoolStatusFunc(ctx, zpoolPath)
+	}
+	return []byte(`{"pools": {}}`), nil
+}
+
+func (m *mockZFSProvider) ListImportablePools(ctx context.Context, zpoolPath string) ([]importablePool, error) {
+	if m.ListImportablePoolsFunc != nil {
+		return m.ListImportablePoolsFunc(ctx, zpoolPath)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) ImportPool(ctx context.Context, zpoolPath, id string) ([]byte, error) {
+	if m.ImportPoolFunc != nil {
+		return m.ImportPoolFunc(ctx, zpoolPath, id)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) GetPoolProperties(ctx context.Context, zpoolPath, name string, props []string) (map[string]string, error) {
+	if m.GetPoolPropertiesFunc != nil {
+		return m.GetPoolPropertiesFunc(ctx, zpoolPath, name, props)
+	}
+	return map[string]string{}, nil
+}
+
+func (m *mockZFSProvider) SetPoolProperty(ctx context.Context, zpoolPath, name, prop, value string) ([]byte, error) {
+	if m.SetPoolPropertyFunc != nil {
+		return m.SetPoolPropertyFunc(ctx, zpoolPath, name, prop, value)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) InitializePool(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+	if m.InitializePoolFunc != nil {
+		return m.InitializePoolFunc(ctx, zpoolPath, name)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) GetInitializeStatus(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+	if m.GetInitializeStatusFunc != nil {
+		return m.GetInitializeStatusFunc(ctx, zpoolPath, name)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) ReadModuleParameter(name string) (string, error) {
+	if m.ReadModuleParameterFunc != nil {
+		return m.ReadModuleParameterFunc(name)
+	}
+	return "", nil
+}
+
+func (m *mockZFSProvider) WriteModuleParameter(name, value string) error {
+	if m.WriteModuleParameterFunc != nil {
+		return m.WriteModuleParameterFunc(name, value)
+	}
+	return nil
+}
+
+func (m *mockZFSProvider) GetMemTotal() (uint64, error) {
+	if m.GetMemTotalFunc != nil {
+		return m.GetMemTotalFunc()
+	}
+	return 16 << 30, nil
+}
+
+func (m *mockZFSProvider) GetVersion(ctx context.Context, zpoolPath string) ([]byte, error) {
+	if m.GetVersionFunc != nil {
+		return m.GetVersionFunc(ctx, zpoolPath)
+	}
+	return []byte("zfs-2.2.6-1\nzfs-kmod-2.2.6-1\n"), nil
+}
+
+func (m *mockZFSProvider) ListPoolFeatures() ([]string, error) {
+	if m.ListPoolFeaturesFunc != nil {
+		return m.ListPoolFeaturesFunc()
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) DiscardDevice(path string, secure bool) error {
+	if m.DiscardDeviceFunc != nil {
+		return m.DiscardDeviceFunc(path, secure)
+	}
+	return nil
+}
+
+func (m *mockZFSProvider) GetQueueInfo(path string) (queueInfo, error) {
+	if m.GetQueueInfoFunc != nil {
+		return m.GetQueueInfoFunc(path)
+	}
+	return queueInfo{DiscardMaxBytes: 2147450880}, nil
+}
+
+func (m *mockZFSProvider) GetDiskModel(path string) (string, error) {
+	if m.GetDiskModelFunc != nil {
+		return m.GetDiskModelFunc(path)
+	}
+	return "", errors.New("no model")
+}
+
+func (m *mockZFSProvider) BurnInDevice(path string, size, seed uint64) error {
+	if m.BurnInDeviceFunc != nil {
+		return m.BurnInDeviceFunc(path, size, seed)
+	}
+	return nil
+}
+
+func (m *mockZFSProvider) ProbeSyncWriteLatency(path string, writes int) (time.Duration, error) {
+	if m.ProbeSyncWriteLatencyFunc != nil {
+		return m.ProbeSyncWriteLatencyFunc(path, writes)
+	}
+	return 50 * time.Microsecond, nil
+}
+
+func (m *mockZFSProvider) GetDatasetProperties(ctx context.Context, zfsPath, dataset string, props []string) (map[string]string, error) {
+	if m.GetDatasetPropertiesFunc != nil {
+		return m.GetDatasetPropertiesFunc(ctx, zfsPath, dataset, props)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) EnsureOwnership(path string, uid, gid, mode int) (bool, error) {
+	if m.EnsureOwnershipFunc != nil {
+		return m.EnsureOwnershipFunc(path, uid, gid, mode)
+	}
+	return false, nil
+}
+
+func (m *mockZFSProvider) ListDatasets(ctx context.Context, zfsPath, pool string) ([]datasetInfo, error) {
+	if m.ListDatasetsFunc != nil {
+		return m.ListDatasetsFunc(ctx, zfsPath, pool)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) LoadKeys(ctx context.Context, zfsPath, pool string) ([]byte, error) {
+	if m.LoadKeysFunc != nil {
+		return m.LoadKeysFunc(ctx, zfsPath, pool)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) MountDataset(ctx context.Context, zfsPath, dataset string) ([]byte, error) {
+	if m.MountDatasetFunc != nil {
+		return m.MountDatasetFunc(ctx, zfsPath, dataset)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) SettleUdev(ctx context.Context, timeout time.Duration) error {
+	if m.SettleUdevFunc != nil {
+		return m.SettleUdevFunc(ctx, timeout)
+	}
+	return nil
+}
+
+func (m *mockZFSProvider) TriggerVolumeEvents() error {
+	if m.TriggerVolumeEventsFunc != nil {
+		return m.TriggerVolumeEventsFunc()
+	}
+	return nil
+}
+
+func (m *mockZFSProvider) ImportPoolReadOnly(ctx context.Context, zpoolPath, id string) ([]byte, error) {
+	if m.ImportPoolReadOnlyFunc != nil {
+		return m.ImportPoolReadOnlyFunc(ctx, zpoolPath, id)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) ExportPool(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+	if m.ExportPoolFunc != nil {
+		return m.ExportPoolFunc(ctx, zpoolPath, name)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) ClearPool(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+	if m.ClearPoolFunc != nil {
+		return m.ClearPoolFunc(ctx, zpoolPath, name)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) ClearDevice(ctx context.Context, zpoolPath, name, device string) ([]byte, error) {
+	if m.ClearDeviceFunc != nil {
+		return m.ClearDeviceFunc(ctx, zpoolPath, name, device)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) OfflineDevice(ctx context.Context, zpoolPath, name, device string) ([]byte, error) {
+	if m.OfflineDeviceFunc != nil {
+		return m.OfflineDeviceFunc(ctx, zpoolPath, name, device)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) ReplaceDevice(ctx context.Context, zpoolPath, name, device, replacement string) ([]byte, error) {
+	if m.ReplaceDeviceFunc != nil {
+		return m.ReplaceDeviceFunc(ctx, zpoolPath, name, device, replacement)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) AttachDevice(ctx context.Context, zpoolPath, name, device, newDevice, ashift string) ([]byte, error) {
+	if m.AttachDeviceFunc != nil {
+		return m.AttachDeviceFunc(ctx, zpoolPath, name, device, newDevice, ashift)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) DetachDevice(ctx context.Context, zpoolPath, name, device string) ([]byte, error) {
+	if m.DetachDeviceFunc != nil {
+		return m.DetachDeviceFunc(ctx, zpoolPath, name, device)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) RemoveVdev(ctx context.Context, zpoolPath, name, vdev string) ([]byte, error) {
+	if m.RemoveVdevFunc != nil {
+		return m.RemoveVdevFunc(ctx, zpoolPath, name, vdev)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) GetPoolUsage(ctx context.Context, zpoolPath, name string) (poolSample, error) {
+	if m.GetPoolUsageFunc != nil {
+		return m.GetPoolUsageFunc(ctx, zpoolPath, name)
+	}
+	return poolSample{}, errors.New("GetPoolUsageFunc not implemented")
+}
+
+func (m *mockZFSProvider) GetPoolHistory(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+	if m.GetPoolHistoryFunc != nil {
+		return m.GetPoolHistoryFunc(ctx, zpoolPath, name)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) ReadDeviceLabels(ctx context.Context, zdbPath, device string) ([]byte, error) {
+	if m.ReadDeviceLabelsFunc != nil {
+		return m.ReadDeviceLabelsFunc(ctx, zdbPath, device)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) ListVolumes(ctx context.Context, zfsPath, pool string) (map[string]string, error) {
+	if m.ListVolumesFunc != nil {
+		return m.ListVolumesFunc(ctx, zfsPath, pool)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) CreateVolume(ctx context.Context, zfsPath, dataset string, size uint64, props map[string]string) ([]byte, error) {
+	if m.CreateVolumeFunc != nil {
+		return m.CreateVolumeFunc(ctx, zfsPath, dataset, size, props)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) IsSwapFormatted(path string) (formatted, blank bool, err error) {
+	if m.IsSwapFormattedFunc != nil {
+		return m.IsSwapFormattedFunc(path)
+	}
+	return false, false, nil
+}
+
+func (m *mockZFSProvider) FormatSwap(path string) error {
+	if m.FormatSwapFunc != nil {
+		return m.FormatSwapFunc(path)
+	}
+	return nil
+}
+
+func (m *mockZFSProvider) EnableSwap(path string) error {
+	if m.EnableSwapFunc != nil {
+		return m.EnableSwapFunc(path)
+	}
+	return nil
+}
+
+func (m *mockZFSProvider) SnapshotRecursive(ctx context.Context, zfsPath, snapshot string) ([]byte, error) {
+	if m.SnapshotRecursiveFunc != nil {
+		return m.SnapshotRecursiveFunc(ctx, zfsPath, snapshot)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) CheckpointPool(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+	if m.CheckpointPoolFunc != nil {
+		return m.CheckpointPoolFunc(ctx, zpoolPath, name)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) DiscardCheckpoint(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+	if m.DiscardCheckpointFunc != nil {
+		return m.DiscardCheckpointFunc(ctx, zpoolPath, name)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) UpgradePool(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+	if m.UpgradePoolFunc != nil {
+		return m.UpgradePoolFunc(ctx, zpoolPath, name)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) SplitPool(ctx context.Context, zpoolPath, name, newName string, devices []string) ([]byte, error) {
+	if m.SplitPoolFunc != nil {
+		return m.SplitPoolFunc(ctx, zpoolPath, name, newName, devices)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) IsCharDevice(path string) (bool, error) {
+	if m.IsCharDeviceFunc != nil {
+		return m.IsCharDeviceFunc(path)
+	}
+	return true, nil
+}
+
+func (m *mockZFSProvider) GetPropertyHelp(ctx context.Context, binPath string) ([]byte, error) {
+	if m.GetPropertyHelpFunc != nil {
+		return m.GetPropertyHelpFunc(ctx, binPath)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) SetDatasetProperty(ctx context.Context, zfsPath, dataset, prop, value string) ([]byte, error) {
+	if m.SetDatasetPropertyFunc != nil {
+		return m.SetDatasetPropertyFunc(ctx, zfsPath, dataset, prop, value)
+	}
+	return nil, nil
+}
+
+func (m *mockZFSProvider) DryRunCreatePool(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+	if m.DryRunCreatePoolFunc != nil {
+		return m.DryRunCreatePoolFunc(ctx, zpoolPath, args)
+	}
+	return nil, nil
+}
+
 func (m *mockZFSProvider) IsBlockDevice(path string) (bool, error) {
 	if m.IsBlockDeviceFunc != nil {
 		return m.IsBlockDeviceFunc(path)
@@ -130,6 +543,8 @@ func TestIsValidZpoolType(t *testing.T) {
 		{"valid raidz2", "raidz2", true},
 		{"valid raidz3", "raidz3", true},
 		{"valid draid", "draid", true},
+		{"draid with layout", "draid2:4d:10c:1s", true},
+		{"raidz with parameters", "raidz2:4d", false},
 		{"invalid type", "raid0", false},
 		{"misspelled", "miror", false},
 	}
@@ -169,6 +584,42 @@ func TestIsValidAshift(t *testing.T) {
 	}
 }
 
+func TestIsValidMountpoint(t *testing.T) {
+	testCases := []struct {
+		input string
+		want  bool
+	}{
+		{"none", true},
+		{"legacy", true},
+		{"/var/mnt/tank", true},
+		{"/", true},
+		{"var/mnt/tank", false},
+		{"/var/mnt/../etc", false},
+		{"/var/mnt/tank/", false},
+		{"", false},
+		{"off", false},
+	}
+
+	for _, tc := range testCases {
+		if got := isValidMountpoint(tc.input); got != tc.want {
+			t.Errorf("isValidMountpoint(%q) = %v; want %v", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestIsValidCanMount(t *testing.T) {
+	for _, v := range []string{"", "on", "off", "noauto"} {
+		if !isValidCanMount(v) {
+			t.Errorf("isValidCanMount(%q) = false; want true", v)
+		}
+	}
+	for _, v := range []string{"yes", "OFF", "none"} {
+		if isValidCanMount(v) {
+			t.Errorf("isValidCanMount(%q) = true; want false", v)
+		}
+	}
+}
+
 // --- Fuzz Test ---
 
 func FuzzIsValidZpoolName(f *testing.F) {
@@ -210,6 +661,7 @@ func TestParsePoolConfigs(t *testing.T) {
 	os.Setenv("ZPOOL_1_NAME", "tank1")
 	os.Setenv("ZPOOL_1_DISK_0_MODEL", "Samsung*")
 	os.Setenv("ZPOOL_1_DISK_1_MODEL", "Dell*")
+	os.Setenv("ZPOOL_1_DISKS", ` ["/dev/sdc"] `)
 	os.Setenv("ZPOOL_ASHIFT", "12") // Global ashift
 
 	// Clean up env vars after test
@@ -224,6 +676,7 @@ func TestParsePoolConfigs(t *testing.T) {
 		os.Unsetenv("ZPOOL_1_NAME")
 		os.Unsetenv("ZPOOL_1_DISK_0_MODEL")
 		os.Unsetenv("ZPOOL_1_DISK_1_MODEL")
+		os.Unsetenv("ZPOOL_1_DISKS")
 		os.Unsetenv("ZPOOL_ASHIFT")
 	}()
 
@@ -251,33 +704,54 @@ func TestParsePoolConfigs(t *testing.T) {
 	if len(configs[1].Disks) != 2 || configs[1].Disks[0].Model != "Samsung*" || configs[1].Disks[1].Model != "Dell*" {
 		t.Errorf("config 1 disks are incorrect: got %v", configs[1].Disks)
 	}
+	if !configs[0].Import || !configs[1].Import {
+		t.Errorf("Expected importing exported pools to be enabled by default")
+	}
+	if configs[1].DiskList != `["/dev/sdc"]` {
+		t.Errorf("config 1 disk list is incorrect: got %q", configs[1].DiskList)
+	}
 }
 
 func TestParsePoolConfigs_Limit(t *testing.T) {
 	// Set more environment variables than the MaxPools limit
-	for i := 0; i <= maxPools; i++ {
+	for i := 0; i <= defaultMaxPools; i++ {
 		os.Setenv(fmt.Sprintf("ZPOOL_%d_NAME", i), fmt.Sprintf("pool%d", i))
 	}
 	defer func() {
-		for i := 0; i <= maxPools; i++ {
+		for i := 0; i <= defaultMaxPools; i++ {
 			os.Unsetenv(fmt.Sprintf("ZPOOL_%d_NAME", i))
 		}
 	}()
 
 	configs := parsePoolConfigs()
 
-	if len(configs) != maxPools {
-		t.Fatalf("parsePoolConfigs() returned %d configs, want %d (MaxPools limit)", len(configs), maxPools)
+	if len(configs) != defaultMaxPools {
+		t.Fatalf("parsePoolConfigs() returned %d configs, want %d (MaxPools limit)", len(configs), defaultMaxPools)
 	}
 
 	// Check if the last parsed pool is the one just before the limit
-	expectedLastName := fmt.Sprintf("pool%d", maxPools-1)
-	actualLastName := configs[maxPools-1].Name
+	expectedLastName := fmt.Sprintf("pool%d", defaultMaxPools-1)
+	actualLastName := configs[defaultMaxPools-1].Name
 	if actualLastName != expectedLastName {
 		t.Errorf("Last parsed pool name is incorrect: got %q, want %q", actualLastName, expectedLastName)
 	}
 }
 
+func TestEnabledPoolConfigs(t *testing.T) {
+	t.Setenv("ZPOOL_0_NAME", "tank")
+	t.Setenv("ZPOOL_1_NAME", "maintenance")
+	t.Setenv("ZPOOL_1_ENABLED", "false")
+	t.Setenv("ZPOOL_2_NAME", "data")
+
+	configs, names := enabledPoolConfigs(parsePoolConfigs())
+	if len(configs) != 2 || configs[0].Name != "tank" || configs[1].Name != "data" {
+		t.Errorf("enabledPoolConfigs() configs = %+v, want tank and data", configs)
+	}
+	if !slices.Equal(names, []string{"tank", "maintenance", "data"}) {
+		t.Errorf("enabledPoolConfigs() names = %v, want all configured pools", names)
+	}
+}
+
 func TestCreatePool_Success(t *testing.T) {
 	mockProvider := &mockZFSProvider{}
 	config := poolConfig{
@@ -288,7 +762,7 @@ func TestCreatePool_Success(t *testing.T) {
 	}
 
 	usedDisks := make(map[string]bool)
-	err := createPool(mockProvider, "/fake/zpool", config, usedDisks)
+	err := createPool(t.Context(), mockProvider, "/fake/zpool", config, &runState{usedDisks: usedDisks})
 	if err != nil {
 		t.Fatalf("createPool() returned an unexpected error: %v", err)
 	}
@@ -298,9 +772,219 @@ func TestCreatePool_Success(t *testing.T) {
 	}
 }
 
+func TestCreatePool_AlreadyExists(t *testing.T) {
+	mockProvider := &mockZFSProvider{
+		CreatePoolFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+			t.Fatalf("CreatePool must not be called for an existing pool, got args %v", args)
+			return nil, nil
+		},
+	}
+	config := poolConfig{Name: "tank", Disks: []diskSpec{{Dev: "/dev/sda"}}, Ashift: "12"}
+	existingPools := map[string]string{"tank": "1234567890"}
+
+	usedDisks := make(map[string]bool)
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, &runState{existingPools: existingPools, usedDisks: usedDisks}); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	if len(usedDisks) != 0 {
+		t.Errorf("Expected no disks to be marked as used, got %v", usedDisks)
+	}
+}
+
+func TestCreatePool_FirstBootCompleted(t *testing.T) {
+	var set []string
+	mockProvider := &mockZFSProvider{
+		CreatePoolFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+			t.Fatalf("CreatePool must not be called once the first boot completed, got args %v", args)
+			return nil, nil
+		},
+		GetPoolPropertiesFunc: func(ctx context.Context, zpoolPath, name string, props []string) (map[string]string, error) {
+			return map[string]string{"autotrim": "off"}, nil
+		},
+		SetPoolPropertyFunc: func(ctx context.Context, zpoolPath, name, prop, value string) ([]byte, error) {
+			set = append(set, name+":"+prop+"="+value)
+			return nil, nil
+		},
+	}
+	state := newRunState(map[string]string{"tank": "1"})
+	state.skipCreate = true
+
+	missing := poolConfig{Name: "data", Disks: []diskSpec{{Dev: "/dev/sdb"}}, Ashift: "12"}
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", missing, state); err != nil {
+		t.Fatalf("createPool() of a missing pool returned an unexpected error: %v", err)
+	}
+	if !state.notCreated["data"] || len(state.usedDisks) != 0 {
+		t.Errorf("Expected the missing pool to be left alone, got notCreated %v and used disks %v", state.notCreated, state.usedDisks)
+	}
+
+	existing := poolConfig{Name: "tank", Disks: []diskSpec{{Dev: "/dev/sda"}}, Ashift: "12",
+		Properties: map[string]string{"autotrim": "on"}, Reconcile: []string{"autotrim"}}
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", existing, state); err != nil {
+		t.Fatalf("createPool() of an existing pool returned an unexpected error: %v", err)
+	}
+	if strings.Join(set, " ") != "tank:autotrim=on" || state.notCreated["tank"] {
+		t.Errorf("Expected the existing pool to be reconciled, got %v", set)
+	}
+}
+
+func TestLiveZFSProvider_RunCommandTimeout(t *testing.T) {
+	sleepPath, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep binary not available")
+	}
+
+	provider := &liveZFSProvider{commandTimeout: 50 * time.Millisecond}
+	start := time.Now()
+	_, err = provider.runCommand(t.Context(), true, sleepPath, "10")
+	if !errors.Is(err, errCommandTimeout) {
+		t.Fatalf("Expected errCommandTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > commandWaitDelay {
+		t.Errorf("Expected runCommand to return promptly after the timeout, took %s", elapsed)
+	}
+
+	// A deadline already carried by the context takes precedence over the provider timeout.
+	provider.commandTimeout = time.Hour
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := provider.runCommand(ctx, true, sleepPath, "10"); !errors.Is(err, errCommandTimeout) {
+		t.Fatalf("Expected errCommandTimeout from context deadline, got %v", err)
+	}
+
+	// Commands finishing in time are unaffected.
+	provider.commandTimeout = 10 * time.Second
+	if _, err := provider.runCommand(t.Context(), true, sleepPath, "0"); err != nil {
+		t.Fatalf("Expected command to succeed, got %v", err)
+	}
+}
+
+func TestResolveBinary(t *testing.T) {
+	mockProvider := &mockZFSProvider{
+		LookPathFunc: func(file string) (string, error) {
+			switch file {
+			case "zpool":
+				return "/usr/local/sbin/zpool", nil
+			case "/opt/zfs/bin/zpool":
+				return file, nil
+			}
+			return "", errors.New("executable file not found")
+		},
+	}
+
+	t.Run("PATH lookup without override", func(t *testing.T) {
+		got, err := resolveBinary(mockProvider, "ZPOOL_BIN", "zpool")
+		if err != nil || got != "/usr/local/sbin/zpool" {
+			t.Errorf("resolveBinary() = %q, %v; want /usr/local/sbin/zpool", got, err)
+		}
+	})
+
+	t.Run("valid override", func(t *testing.T) {
+		t.Setenv("ZPOOL_BIN", "/opt/zfs/bin/zpool")
+		got, err := resolveBinary(mockProvider, "ZPOOL_BIN", "zpool")
+		if err != nil || got != "/opt/zfs/bin/zpool" {
+			t.Errorf("resolveBinary() = %q, %v; want /opt/zfs/bin/zpool", got, err)
+		}
+	})
+
+	t.Run("relative override", func(t *testing.T) {
+		t.Setenv("ZPOOL_BIN", "bin/zpool")
+		if _, err := resolveBinary(mockProvider, "ZPOOL_BIN", "zpool"); err == nil {
+			t.Error("Expected an error for a relative override")
+		}
+	})
+
+	t.Run("missing override", func(t *testing.T) {
+		t.Setenv("ZFS_BIN", "/opt/zfs/bin/zfs")
+		if _, err := resolveBinary(mockProvider, "ZFS_BIN", "zfs"); err == nil {
+			t.Error("Expected an error for a non-executable override")
+		}
+	})
+}
+
+func TestParseDuration(t *testing.T) {
+	testCases := []struct {
+		input string
+		want  time.Duration
+		fail  bool
+	}{
+		{"90s", 90 * time.Second, false},
+		{" 5m ", 5 * time.Minute, false},
+		{"0", 0, false},
+		{"-1s", 0, true},
+		{"five minutes", 0, true},
+		{"", 0, true},
+	}
+
+	for _, tc := range testCases {
+		got, err := parseDuration(tc.input)
+		if tc.fail {
+			if err == nil {
+				t.Errorf("parseDuration(%q) expected error, got nil", tc.input)
+			}
+		} else if err != nil || got != tc.want {
+			t.Errorf("parseDuration(%q) = %v, %v; want %v", tc.input, got, err, tc.want)
+		}
+	}
+}
+
+func TestParsePoolList(t *testing.T) {
+	output := "tank\t1234567890\nmy pool\t42\n\n"
+	pools := parsePoolList(output)
+	if len(pools) != 2 || pools["tank"] != "1234567890" || pools["my pool"] != "42" {
+		t.Errorf("parsePoolList() = %v; want tank and my pool with GUIDs", pools)
+	}
+}
+
+func TestCreatePool_MountOptions(t *testing.T) {
+	testCases := []struct {
+		name   string
+		config poolConfig
+		want   string
+	}{
+		{
+			"default mountpoint",
+			poolConfig{Name: "tank", Disks: []diskSpec{{Dev: "/dev/sda"}}, Ashift: "12"},
+			"create -m /var/mnt/tank -o ashift=12 tank /dev/sda",
+		},
+		{
+			"no mountpoint and canmount off",
+			poolConfig{Name: "zvols", Disks: []diskSpec{{Dev: "/dev/sda"}}, Ashift: "12", Mountpoint: "none", CanMount: "off"},
+			"create -m none -o ashift=12 -O canmount=off zvols /dev/sda",
+		},
+		{
+			"custom mountpoint",
+			poolConfig{Name: "data", Disks: []diskSpec{{Dev: "/dev/sda"}}, Ashift: "12", Mountpoint: "/var/mnt/storage/data"},
+			"create -m /var/mnt/storage/data -o ashift=12 data /dev/sda",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var gotArgs string
+			mockProvider := &mockZFSProvider{
+				CreatePoolFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+					gotArgs = strings.Join(args, " ")
+					return nil, nil
+				},
+			}
+			if err := createPool(t.Context(), mockProvider, "/fake/zpool", tc.config, newRunState(nil)); err != nil {
+				t.Fatalf("createPool() returned an unexpected error: %v", err)
+			}
+			if gotArgs != tc.want {
+				t.Errorf("zpool args = %q; want %q", gotArgs, tc.want)
+			}
+		})
+	}
+
+	invalid := poolConfig{Name: "tank", Disks: []diskSpec{{Dev: "/dev/sda"}}, Ashift: "12", CanMount: "maybe"}
+	if err := createPool(t.Context(), &mockZFSProvider{}, "/fake/zpool", invalid, newRunState(nil)); err == nil {
+		t.Error("Expected an error for an invalid canmount value")
+	}
+}
+
 func TestCreatePool_PartialFailure(t *testing.T) {
 	mockProvider := &mockZFSProvider{
-		CreatePoolFunc: func(zpoolPath string, args []string) ([]byte, error) {
+		CreatePoolFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
 			// Fail only for a specific pool
 			if strings.Contains(strings.Join(args, " "), "badpool") {
 				return []byte("Error output"), errors.New("zpool command failed")
@@ -318,7 +1002,7 @@ func TestCreatePool_PartialFailure(t *testing.T) {
 	usedDisks := make(map[string]bool)
 	var allErrors []error
 	for _, config := range configs {
-		err := createPool(mockProvider, "/fake/zpool", config, usedDisks)
+		err := createPool(t.Context(), mockProvider, "/fake/zpool", config, &runState{usedDisks: usedDisks})
 		if err != nil {
 			allErrors = append(allErrors, fmt.Errorf("pool %q: %w", config.Name, err))
 		}
@@ -349,7 +1033,7 @@ func TestCreatePool_DiskNotBlockDevice(t *testing.T) {
 
 	// We need to capture the arguments passed to CreatePool to see what disks were used
 	var createPoolDisks []string
-	mockProvider.CreatePoolFunc = func(zpoolPath string, args []string) ([]byte, error) {
+	mockProvider.CreatePoolFunc = func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
 		// A bit of a hacky way to find the disk arguments
 		for _, arg := range args {
 			if strings.HasPrefix(arg, "/dev/") {
@@ -360,7 +1044,7 @@ func TestCreatePool_DiskNotBlockDevice(t *testing.T) {
 	}
 
 	usedDisks := make(map[string]bool)
-	err := createPool(mockProvider, "/fake/zpool", config, usedDisks)
+	err := createPool(t.Context(), mockProvider, "/fake/zpool", config, &runState{usedDisks: usedDisks})
 	if err != nil {
 		t.Fatalf("createPool() returned an unexpected error: %v", err)
 	}
@@ -373,6 +1057,229 @@ func TestCreatePool_DiskNotBlockDevice(t *testing.T) {
 	}
 }
 
+func TestProbeDevices_BoundedAndOrdered(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	mockProvider := &mockZFSProvider{
+		IsBlockDeviceFunc: func(path string) (bool, error) {
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			// Finish later disks first to make any ordering bug visible
+			if path == "/dev/sda" {
+				time.Sleep(20 * time.Millisecond)
+			}
+			time.Sleep(5 * time.Millisecond)
+			return path != "/dev/sdc", nil
+		},
+	}
+
+	disks := []diskSpec{{Dev: "/dev/sda"}, {Model: "Dell*"}, {Dev: "/dev/sdb"}, {Dev: "/dev/sdc"}, {Dev: "/dev/sdd"}, {Dev: "/dev/sde"}}
+	probes := probeDevices(mockProvider, disks, nil, 2)
+
+	if len(probes) != len(disks) {
+		t.Fatalf("Expected %d probe results, got %d", len(disks), len(probes))
+	}
+	if got := peak.Load(); got > 2 {
+		t.Errorf("Expected at most 2 concurrent probes, got %d", got)
+	}
+	if probes[1] != (diskProbe{}) {
+		t.Errorf("Expected empty probe result for model spec, got %+v", probes[1])
+	}
+	for i, dev := range []string{"/dev/sda", "", "/dev/sdb", "/dev/sdc", "/dev/sdd", "/dev/sde"} {
+		if dev != "" && probes[i].device != dev {
+			t.Errorf("probe %d device = %q; want %q", i, probes[i].device, dev)
+		}
+	}
+	if probes[3].problem == "" {
+		t.Errorf("Expected /dev/sdc to be reported as unusable, got %+v", probes[3])
+	}
+}
+
+func TestCreatePool_StrictDisks(t *testing.T) {
+	mockProvider := &mockZFSProvider{
+		IsBlockDeviceFunc: func(path string) (bool, error) {
+			return path != "/dev/sdb", nil
+		},
+		CreatePoolFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+			t.Fatalf("CreatePool must not be called in strict mode with a missing disk, got args %v", args)
+			return nil, nil
+		},
+	}
+	config := poolConfig{
+		Name:        "strictpool",
+		Type:        "mirror",
+		Disks:       []diskSpec{{Dev: "/dev/sda"}, {Dev: "/dev/sdb"}},
+		Ashift:      "12",
+		StrictDisks: true,
+	}
+
+	usedDisks := make(map[string]bool)
+	err := createPool(t.Context(), mockProvider, "/fake/zpool", config, &runState{usedDisks: usedDisks})
+	if err == nil || !strings.Contains(err.Error(), "/dev/sdb") {
+		t.Fatalf("Expected strict mode error naming /dev/sdb, got %v", err)
+	}
+	if len(usedDisks) != 0 {
+		t.Errorf("Expected picked disks to be released after a strict abort, got %v", usedDisks)
+	}
+
+	// Without strict mode, the pool is created from the remaining disk.
+	config.StrictDisks = false
+	mockProvider.CreatePoolFunc = nil
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, &runState{usedDisks: usedDisks}); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+}
+
+func TestCreatePool_WaitForDisks(t *testing.T) {
+	oldInterval := diskWaitPollInterval
+	diskWaitPollInterval = 5 * time.Millisecond
+	t.Cleanup(func() {
+		diskWaitPollInterval = oldInterval
+	})
+
+	t.Run("disk appears within the wait", func(t *testing.T) {
+		var probes atomic.Int32
+		var gotArgs string
+		mockProvider := &mockZFSProvider{
+			IsBlockDeviceFunc: func(path string) (bool, error) {
+				// /dev/sdb shows up on the third probe round
+				if path == "/dev/sdb" {
+					return probes.Add(1) >= 3, nil
+				}
+				return true, nil
+			},
+			CreatePoolFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+				gotArgs = strings.Join(args, " ")
+				return nil, nil
+			},
+		}
+		config := poolConfig{Name: "tank", Type: "mirror", Disks: []diskSpec{{Dev: "/dev/sda"}, {Dev: "/dev/sdb"}}, Ashift: "12", WaitForDisks: time.Second}
+
+		usedDisks := make(map[string]bool)
+		if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, &runState{usedDisks: usedDisks}); err != nil {
+			t.Fatalf("createPool() returned an unexpected error: %v", err)
+		}
+		if !strings.HasSuffix(gotArgs, "mirror /dev/sda /dev/sdb") {
+			t.Errorf("Expected pool to be created with both disks, got args %q", gotArgs)
+		}
+		if !usedDisks["/dev/sda"] || !usedDisks["/dev/sdb"] {
+			t.Errorf("Expected both disks to be marked as used, got %v", usedDisks)
+		}
+	})
+
+	t.Run("disk never appears", func(t *testing.T) {
+		mockProvider := &mockZFSProvider{
+			IsBlockDeviceFunc: func(path string) (bool, error) {
+				return path != "/dev/sdb", nil
+			},
+			CreatePoolFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+				t.Fatalf("CreatePool must not be called when disks are missing after the wait, got args %v", args)
+				return nil, nil
+			},
+		}
+		config := poolConfig{Name: "tank", Type: "mirror", Disks: []diskSpec{{Dev: "/dev/sda"}, {Dev: "/dev/sdb"}}, Ashift: "12", WaitForDisks: 30 * time.Millisecond}
+
+		usedDisks := make(map[string]bool)
+		err := createPool(t.Context(), mockProvider, "/fake/zpool", config, &runState{usedDisks: usedDisks})
+		if err == nil || !strings.Contains(err.Error(), "/dev/sdb") {
+			t.Fatalf("Expected an error naming /dev/sdb, got %v", err)
+		}
+		if code := runExitCode([]error{err}); code != exitRetryable {
+			t.Errorf("Expected the run to be retried, got exit code %d", code)
+		}
+		if len(usedDisks) != 0 {
+			t.Errorf("Expected no disks to remain marked as used, got %v", usedDisks)
+		}
+		// The first boot is not complete while the pool is missing.
+		stateDir := t.TempDir()
+		if recorded, recordErr := recordFirstBoot(stateDir, []error{err}); recorded || recordErr != nil {
+			t.Errorf("recordFirstBoot() = %v, %v; want false, nil", recorded, recordErr)
+		}
+		if done, _ := firstBootDone(stateDir); done {
+			t.Error("Expected no first-boot marker after the pool was left alone")
+		}
+
+		// In strict mode, the pool fails as well.
+		config.StrictDisks = true
+		if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, &runState{usedDisks: usedDisks}); err == nil || !strings.Contains(err.Error(), "/dev/sdb") {
+			t.Fatalf("Expected strict mode error naming /dev/sdb, got %v", err)
+		}
+	})
+}
+
+func TestParsePoolConfigs_Strict(t *testing.T) {
+	t.Setenv("ZPOOL_STRICT", "true")
+	t.Setenv("ZPOOL_0_NAME", "tank")
+	t.Setenv("ZPOOL_1_NAME", "scratch")
+	t.Setenv("ZPOOL_1_STRICT", "false")
+	t.Setenv("ZPOOL_2_NAME", "legacy")
+	t.Setenv("ZPOOL_2_STRICT_DISKS", "false")
+	t.Setenv("ZPOOL_3_NAME", "broken")
+	t.Setenv("ZPOOL_3_STRICT", "maybe")
+
+	configs := parsePoolConfigs()
+	if len(configs) != 4 {
+		t.Fatalf("Expected 4 pools, got %d", len(configs))
+	}
+	for i, want := range []bool{true, false, false} {
+		if configs[i].StrictDisks != want || len(configs[i].ParseErrors) > 0 {
+			t.Errorf("Pool %s: StrictDisks = %v, errors %v; want %v", configs[i].Name, configs[i].StrictDisks, configs[i].ParseErrors, want)
+		}
+	}
+	if errs := configs[3].ParseErrors; len(errs) != 1 || !strings.Contains(errs[0].Error(), "ZPOOL_3_STRICT") {
+		t.Errorf("Expected an invalid boolean error, got %v", errs)
+	}
+
+	// An invalid global value fails every pool.
+	t.Setenv("ZPOOL_STRICT", "maybe")
+	for _, config := range parsePoolConfigs() {
+		if !slices.ContainsFunc(config.ParseErrors, func(err error) bool { return strings.Contains(err.Error(), "ZPOOL_STRICT") }) {
+			t.Errorf("Pool %s: expected the ZPOOL_STRICT error, got %v", config.Name, config.ParseErrors)
+		}
+	}
+}
+
+func TestCreatePool_ParseErrors(t *testing.T) {
+	config := poolConfig{
+		Name:        "tank",
+		Disks:       []diskSpec{{Dev: "/dev/sda"}},
+		Ashift:      "12",
+		ParseErrors: []error{errors.New(`invalid boolean value "maybe" for ZPOOL_0_STRICT_DISKS`)},
+	}
+	err := createPool(t.Context(), &mockZFSProvider{}, "/fake/zpool", config, newRunState(nil))
+	if err == nil || !strings.Contains(err.Error(), "ZPOOL_0_STRICT_DISKS") {
+		t.Fatalf("Expected the parse error to be returned, got %v", err)
+	}
+}
+
+func TestGetEnvBool(t *testing.T) {
+	t.Setenv("TEST_BOOL_TRUE", "true")
+	t.Setenv("TEST_BOOL_ONE", " 1 ")
+	t.Setenv("TEST_BOOL_EMPTY", "")
+	t.Setenv("TEST_BOOL_INVALID", "maybe")
+
+	if got, err := getEnvBool("TEST_BOOL_TRUE", false); err != nil || !got {
+		t.Errorf("getEnvBool(true) = %v, %v; want true", got, err)
+	}
+	if got, err := getEnvBool("TEST_BOOL_ONE", false); err != nil || !got {
+		t.Errorf("getEnvBool(1) = %v, %v; want true", got, err)
+	}
+	if got, err := getEnvBool("TEST_BOOL_EMPTY", true); err != nil || !got {
+		t.Errorf("getEnvBool(empty) = %v, %v; want fallback true", got, err)
+	}
+	if got, err := getEnvBool("TEST_BOOL_UNSET", false); err != nil || got {
+		t.Errorf("getEnvBool(unset) = %v, %v; want fallback false", got, err)
+	}
+	if _, err := getEnvBool("TEST_BOOL_INVALID", false); err == nil {
+		t.Error("getEnvBool(maybe) expected an error")
+	}
+}
+
 func TestLiveZFSProvider_ResolveDiskByModel(t *testing.T) {
 	// Create a temporary directory to mock /sys/block
 	tmpDir := t.TempDir()
@@ -621,6 +1528,89 @@ func TestParseSizeCondition(t *testing.T) {
 	}
 }
 
+func TestParseDiskList(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+		want  []diskSpec
+		fail  bool
+	}{
+		{"whitespace list", "/dev/sda  /dev/sdb\t/dev/sdc", []diskSpec{{Dev: "/dev/sda"}, {Dev: "/dev/sdb"}, {Dev: "/dev/sdc"}}, false},
+		{"comma list", "/dev/sda,/dev/sdb, /dev/sdc,", []diskSpec{{Dev: "/dev/sda"}, {Dev: "/dev/sdb"}, {Dev: "/dev/sdc"}}, false},
+		{"mixed separators", "/dev/sda;/dev/sdb\n/dev/sdc ,, /dev/sdd", []diskSpec{{Dev: "/dev/sda"}, {Dev: "/dev/sdb"}, {Dev: "/dev/sdc"}, {Dev: "/dev/sdd"}}, false},
+		{"json strings", `["/dev/sda", "/dev/disk/by-id/nvme-Samsung SSD 980"]`, []diskSpec{{Dev: "/dev/sda"}, {Dev: "/dev/disk/by-id/nvme-Samsung SSD 980"}}, false},
+		{"json objects", `[{"dev": "/dev/sda"}, {"model": "Dell DC NVMe CD8*"}]`, []diskSpec{{Dev: "/dev/sda"}, {Model: "Dell DC NVMe CD8*"}}, false},
+		{"json empty array", `[]`, []diskSpec{}, false},
+		{"json malformed", `["/dev/sda"`, nil, true},
+		{"json number entry", `[42]`, nil, true},
+		{"json empty string entry", `[""]`, nil, true},
+		{"json object with both keys", `[{"dev": "/dev/sda", "model": "Dell*"}]`, nil, true},
+		{"json object without keys", `[{}]`, nil, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseDiskList(tc.input)
+			if tc.fail {
+				if err == nil {
+					t.Errorf("parseDiskList(%q) expected error, got %v", tc.input, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseDiskList(%q) returned unexpected error: %v", tc.input, err)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("parseDiskList(%q) = %v; want %v", tc.input, got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("parseDiskList(%q)[%d] = %+v; want %+v", tc.input, i, got[i], tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCreatePool_DiskList(t *testing.T) {
+	mockProvider := &mockZFSProvider{}
+	config := poolConfig{
+		Name:     "listpool",
+		Type:     "mirror",
+		Disks:    []diskSpec{{Dev: "/dev/sda"}},
+		DiskList: `["/dev/sdb", {"model": "Dell*"}]`,
+		Ashift:   "12",
+	}
+
+	var createPoolDisks []string
+	mockProvider.CreatePoolFunc = func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+		for _, arg := range args {
+			if strings.HasPrefix(arg, "/dev/") {
+				createPoolDisks = append(createPoolDisks, arg)
+			}
+		}
+		return nil, nil
+	}
+
+	err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newRunState(nil))
+	if err != nil {
+		t.Fatalf("createPool failed: %v", err)
+	}
+
+	want := []string{"/dev/sda", "/dev/sdb", "/dev/fake-Dell*"}
+	if strings.Join(createPoolDisks, " ") != strings.Join(want, " ") {
+		t.Errorf("Expected disks %v, got %v", want, createPoolDisks)
+	}
+}
+
+func TestCreatePool_InvalidDiskList(t *testing.T) {
+	config := poolConfig{Name: "listpool", DiskList: `["/dev/sda"`, Ashift: "12"}
+	err := createPool(t.Context(), &mockZFSProvider{}, "/fake/zpool", config, newRunState(nil))
+	if err == nil || !strings.Contains(err.Error(), "invalid disk list") {
+		t.Fatalf("Expected invalid disk list error, got %v", err)
+	}
+}
+
 func TestCreatePool_ResolveByModel_Success(t *testing.T) {
 	mockProvider := &mockZFSProvider{
 		ResolveDiskByModelFunc: func(model string, sizeConds []sizeCondition, usedDisks map[string]bool) (string, error) {
@@ -642,7 +1632,7 @@ func TestCreatePool_ResolveByModel_Success(t *testing.T) {
 
 	usedDisks := make(map[string]bool)
 	var createPoolDisks []string
-	mockProvider.CreatePoolFunc = func(zpoolPath string, args []string) ([]byte, error) {
+	mockProvider.CreatePoolFunc = func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
 		for _, arg := range args {
 			if strings.HasPrefix(arg, "/dev/") {
 				createPoolDisks = append(createPoolDisks, arg)
@@ -651,7 +1641,7 @@ func TestCreatePool_ResolveByModel_Success(t *testing.T) {
 		return nil, nil
 	}
 
-	err := createPool(mockProvider, "/fake/zpool", config, usedDisks)
+	err := createPool(t.Context(), mockProvider, "/fake/zpool", config, &runState{usedDisks: usedDisks})
 	if err != nil {
 		t.Fatalf("createPool() returned an unexpected error: %v", err)
 	}
@@ -686,7 +1676,7 @@ func TestCreatePool_HybridOrder(t *testing.T) {
 
 	usedDisks := make(map[string]bool)
 	var createPoolDisks []string
-	mockProvider.CreatePoolFunc = func(zpoolPath string, args []string) ([]byte, error) {
+	mockProvider.CreatePoolFunc = func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
 		for _, arg := range args {
 			if strings.HasPrefix(arg, "/dev/") {
 				createPoolDisks = append(createPoolDisks, arg)
@@ -695,7 +1685,7 @@ func TestCreatePool_HybridOrder(t *testing.T) {
 		return nil, nil
 	}
 
-	err := createPool(mockProvider, "/fake/zpool", config, usedDisks)
+	err := createPool(t.Context(), mockProvider, "/fake/zpool", config, &runState{usedDisks: usedDisks})
 	if err != nil {
 		t.Fatalf("createPool failed: %v", err)
 	}
@@ -725,7 +1715,7 @@ func TestCreatePool_SymlinkDuplicateTracking(t *testing.T) {
 
 	usedDisks := make(map[string]bool)
 	var createPoolDisks []string
-	mockProvider.CreatePoolFunc = func(zpoolPath string, args []string) ([]byte, error) {
+	mockProvider.CreatePoolFunc = func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
 		for _, arg := range args {
 			if strings.HasPrefix(arg, "/dev/") {
 				createPoolDisks = append(createPoolDisks, arg)
@@ -734,7 +1724,7 @@ func TestCreatePool_SymlinkDuplicateTracking(t *testing.T) {
 		return nil, nil
 	}
 
-	err := createPool(mockProvider, "/fake/zpool", config, usedDisks)
+	err := createPool(t.Context(), mockProvider, "/fake/zpool", config, &runState{usedDisks: usedDisks})
 	if err != nil {
 		t.Fatalf("createPool failed: %v", err)
 	}
@@ -748,3 +1738,124 @@ func TestCreatePool_SymlinkDuplicateTracking(t *testing.T) {
 		t.Errorf("Expected disk '/dev/sda' to be used, but got %q", createPoolDisks[0])
 	}
 }
+
+func TestCreatePool_SettlesUdev(t *testing.T) {
+	var settled []time.Duration
+	mockProvider := &mockZFSProvider{
+		SettleUdevFunc: func(ctx context.Context, timeout time.Duration) error {
+			settled = append(settled, timeout)
+			return errors.New("udev queue did not settle")
+		},
+	}
+	config := poolConfig{Name: "tank", Disks: []diskSpec{{Dev: "/dev/sda"}}, Ashift: "12"}
+
+	state := newRunState(nil)
+	state.udevSettleTimeout = time.Second
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, state); err != nil {
+		t.Fatalf("createPool() must not fail when udev does not settle: %v", err)
+	}
+	if !slices.Equal(settled, []time.Duration{time.Second}) {
+		t.Errorf("Expected udev to be settled once after creation, got %v", settled)
+	}
+
+	// Disabled with a zero timeout.
+	settled = nil
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newRunState(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if len(settled) != 0 {
+		t.Errorf("Expected no udev settle with a zero timeout, got %v", settled)
+	}
+}
+
+func TestCreatePool_RetriesWhileBusy(t *testing.T) {
+	calls, settled := 0, 0
+	busyFor := 2
+	mockProvider := &mockZFSProvider{
+		CreatePoolFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+			calls++
+			if calls <= busyFor {
+				return []byte("cannot open '/dev/sda': Device or resource busy"), errors.New("exit status 1")
+			}
+			return nil, nil
+		},
+		SettleUdevFunc: func(ctx context.Context, timeout time.Duration) error {
+			settled++
+			return nil
+		},
+	}
+	config := poolConfig{Name: "tank", Disks: []diskSpec{{Dev: "/dev/sda"}}, Ashift: "12"}
+	newState := func() *runState {
+		state := newRunState(nil)
+		state.busyRetries = 2
+		state.busyRetryDelay = time.Millisecond
+		state.udevSettleTimeout = time.Second
+		return state
+	}
+
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newState()); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	// One settle before each retry and one after creation.
+	if calls != 3 || settled != 3 {
+		t.Errorf("Expected 3 create attempts and 3 udev settles, got %d and %d", calls, settled)
+	}
+
+	// The retries are bounded.
+	calls, busyFor = 0, 10
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newState()); !errors.Is(err, errDeviceBusy) {
+		t.Errorf("Expected errDeviceBusy after the last retry, got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 create attempts, got %d", calls)
+	}
+
+	// Other failures are not retried.
+	calls = 0
+	mockProvider.CreatePoolFunc = func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+		calls++
+		return []byte("/dev/sda is in use and contains an unknown filesystem."), errors.New("exit status 1")
+	}
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newState()); !errors.Is(err, errDeviceInUse) {
+		t.Errorf("Expected errDeviceInUse, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected a single create attempt, got %d", calls)
+	}
+}
+
+func TestLiveZFSProvider_SettleUdev(t *testing.T) {
+	udevDir := t.TempDir()
+	oldPath, oldInterval := udevQueuePath, udevSettlePollInterval
+	udevQueuePath = filepath.Join(udevDir, "queue")
+	udevSettlePollInterval = time.Millisecond
+	t.Cleanup(func() {
+		udevQueuePath, udevSettlePollInterval = oldPath, oldInterval
+	})
+	p := &liveZFSProvider{}
+
+	if err := p.SettleUdev(t.Context(), time.Second); err != nil {
+		t.Errorf("SettleUdev() with an empty queue returned an error: %v", err)
+	}
+
+	if err := os.WriteFile(udevQueuePath, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SettleUdev(t.Context(), 20*time.Millisecond); err == nil {
+		t.Error("Expected SettleUdev() to time out while events are queued")
+	}
+
+	queue := udevQueuePath
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		os.Remove(queue)
+	}()
+	if err := p.SettleUdev(t.Context(), 5*time.Second); err != nil {
+		t.Errorf("Expected SettleUdev() to return once the queue drained, got %v", err)
+	}
+
+	udevQueuePath = filepath.Join(udevDir, "missing", "queue")
+	if err := p.SettleUdev(t.Context(), time.Second); err == nil {
+		t.Error("Expected an error without udev state")
+	}
+}
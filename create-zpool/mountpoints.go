@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var mountInfoPath = "/proc/self/mountinfo"
+
+// mountedPaths returns the set of mount points listed in mountinfo.
+func mountedPaths() (map[string]bool, error) {
+	f, err := os.Open(mountInfoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mounts := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// The fifth field is the mount point, with spaces and similar characters octal escaped.
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		mounts[unescapeMountInfo(fields[4])] = true
+	}
+	return mounts, scanner.Err()
+}
+
+// unescapeMountInfo decodes the \NNN octal escapes used in mountinfo paths.
+func unescapeMountInfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// recordCreatedMountpoints adds the mountpoints of pools created in this run to st.
+// It reports whether st changed.
+func recordCreatedMountpoints(st *persistentState, created map[string]string) bool {
+	changed := false
+	for pool, mountpoint := range created {
+		if st.Mountpoints[pool] != mountpoint {
+			st.Mountpoints[pool] = mountpoint
+			changed = true
+		}
+	}
+	return changed
+}
+
+// cleanupStaleMountpoints removes the recorded mountpoint directories of pools that are no
+// longer configured, as long as they are empty and nothing is mounted on them. Entries whose
+// directory is gone are forgotten. It reports whether st changed.
+func cleanupStaleMountpoints(st *persistentState, configured []string) (bool, error) {
+	mounts, err := mountedPaths()
+	if err != nil {
+		return false, fmt.Errorf("failed to read mount table: %w", err)
+	}
+
+	changed := false
+	for _, pool := range slices.Sorted(maps.Keys(st.Mountpoints)) {
+		if slices.Contains(configured, pool) {
+			continue
+		}
+		dir := filepath.Clean(st.Mountpoints[pool])
+		if mounts[dir] {
+			slog.Info("Stale mountpoint is still mounted, keeping it", "pool", pool, "mountpoint", dir)
+			continue
+		}
+		err := removeEmptyDir(dir)
+		switch {
+		case err == nil:
+			slog.Info("Removed stale mountpoint directory", "pool", pool, "mountpoint", dir)
+		case errors.Is(err, fs.ErrNotExist):
+			slog.Info("Stale mountpoint directory is already gone", "pool", pool, "mountpoint", dir)
+		default:
+			// Most likely not empty; the directory is left alone and stays tracked.
+			slog.Warn("Keeping stale mountpoint directory", "pool", pool, "mountpoint", dir, "error", err)
+			continue
+		}
+		delete(st.Mountpoints, pool)
+		changed = true
+	}
+	return changed, nil
+}
+
+// removeEmptyDir removes dir if it is an empty directory. Anything else is refused.
+func removeEmptyDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return os.Remove(dir)
+}
+
+// updateMountpointState records the mountpoints of newly created pools in the state file and,
+// if cleanup is set, removes the stale mountpoint directories of pools that are no longer configured.
+func updateMountpointState(stateDir string, created map[string]string, cleanup bool, configured []string) error {
+	st, err := loadState(stateDir)
+	if err != nil {
+		return err
+	}
+	changed := recordCreatedMountpoints(st, created)
+	if cleanup {
+		removed, err := cleanupStaleMountpoints(st, configured)
+		if err != nil {
+			return err
+		}
+		changed = changed || removed
+	}
+	if !changed {
+		return nil
+	}
+	return saveState(stateDir, st)
+}
+
+// mountOwnership is the owner, group and mode requested for a mountpoint directory.
+// Negative values leave the corresponding attribute alone.
+type mountOwnership struct {
+	UID  int
+	GID  int
+	Mode int
+}
+
+// parseMountOwnership reads ZPOOL_<n>_MOUNT_UID, ZPOOL_<n>_MOUNT_GID and ZPOOL_<n>_MOUNT_MODE.
+// It returns nil if none of them is set.
+func parseMountOwnership(i int) (*mountOwnership, []error) {
+	owner := &mountOwnership{UID: -1, GID: -1, Mode: -1}
+	set := false
+	var errs []error
+	for _, field := range []struct {
+		suffix string
+		base   int
+		max    uint64
+		dst    *int
+	}{
+		{"MOUNT_UID", 10, 1<<32 - 2, &owner.UID},
+		{"MOUNT_GID", 10, 1<<32 - 2, &owner.GID},
+		{"MOUNT_MODE", 8, 0o7777, &owner.Mode},
+	} {
+		key := fmt.Sprintf("ZPOOL_%d_%s", i, field.suffix)
+		value, ok := lookupEnvTrimmed(key)
+		if !ok || value == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(value, field.base, 64)
+		if err != nil || n > field.max {
+			errs = append(errs, fmt.Errorf("invalid %s %q", key, value))
+			continue
+		}
+		*field.dst = int(n)
+		set = true
+	}
+	if !set {
+		return nil, errs
+	}
+	return owner, errs
+}
+
+// ensureMountOwnership applies the configured ownership to the pool's mountpoint directory.
+// Pools without a mountpoint directory (none or legacy) are left alone.
+func ensureMountOwnership(provider zfsProvider, config poolConfig, mountpoint string) error {
+	owner := config.MountOwner
+	if owner == nil {
+		return nil
+	}
+	if !filepath.IsAbs(mountpoint) {
+		slog.Warn("Mountpoint ownership is configured but the pool has no mountpoint directory", "pool", config.Name, "mountpoint", mountpoint)
+		return nil
+	}
+	changed, err := provider.EnsureOwnership(mountpoint, owner.UID, owner.GID, owner.Mode)
+	if err != nil {
+		return fmt.Errorf("failed to set ownership of mountpoint %s: %w", mountpoint, err)
+	}
+	if changed {
+		slog.Info("Updated mountpoint ownership", "pool", config.Name, "mountpoint", mountpoint, "uid", owner.UID, "gid", owner.GID, "mode", fmt.Sprintf("%04o", max(owner.Mode, 0)))
+	}
+	return nil
+}
+
+// adoptMountpoint moves the root dataset of an existing pool, e.g. one imported after it was
+// created elsewhere, to the configured mountpoint if config.Adopt is set, so that it is mounted
+// like the pools this tool creates. Child datasets inheriting their mountpoint move with it.
+func (s *runState) adoptMountpoint(ctx context.Context, provider zfsProvider, config poolConfig, mountpoint string) error {
+	if !config.Adopt {
+		return nil
+	}
+	if s.zfsPath == "" {
+		return errors.New("adopting the mountpoint requires the zfs binary")
+	}
+	props, err := provider.GetDatasetProperties(ctx, s.zfsPath, config.Name, []string{"mountpoint"})
+	if err != nil {
+		return fmt.Errorf("failed to read the mountpoint: %w", err)
+	}
+	current := props["mountpoint"]
+	if current != mountpoint {
+		slog.Warn("Moving the root dataset of the pool to the configured mountpoint; workloads using the old path lose access to it",
+			"pool", config.Name, "from", current, "to", mountpoint)
+		if output, err := provider.SetDatasetProperty(ctx, s.zfsPath, config.Name, "mountpoint", mountpoint); err != nil {
+			return fmt.Errorf("failed to set the mountpoint: %w, output: %s", err, string(output))
+		}
+	}
+	if filepath.IsAbs(mountpoint) {
+		s.recordCreatedMountpoint(config.Name, mountpoint)
+	}
+	return nil
+}
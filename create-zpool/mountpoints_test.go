@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func setMountInfo(t *testing.T, mounts ...string) {
+	t.Helper()
+	var content string
+	for i, mount := range mounts {
+		content += fmt.Sprintf("%d 1 0:%d / %s rw,relatime shared:1 - zfs pool rw\n", 100+i, 50+i, mount)
+	}
+	path := filepath.Join(t.TempDir(), "mountinfo")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	oldPath := mountInfoPath
+	mountInfoPath = path
+	t.Cleanup(func() {
+		mountInfoPath = oldPath
+	})
+}
+
+func TestUnescapeMountInfo(t *testing.T) {
+	if got := unescapeMountInfo(`/var/mnt/my\040pool`); got != "/var/mnt/my pool" {
+		t.Errorf("unescapeMountInfo() = %q, want %q", got, "/var/mnt/my pool")
+	}
+	if got := unescapeMountInfo(`/var/mnt/a\b`); got != `/var/mnt/a\b` {
+		t.Errorf("unescapeMountInfo() = %q, want the input unchanged", got)
+	}
+}
+
+func TestCleanupStaleMountpoints(t *testing.T) {
+	mntDir := t.TempDir()
+	dir := func(name string) string { return filepath.Join(mntDir, name) }
+	for _, name := range []string{"tank", "old", "mounted", "busy"} {
+		if err := os.Mkdir(dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir("busy"), "data"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	setMountInfo(t, "/", dir("tank"), dir("mounted"))
+
+	st := &persistentState{Mountpoints: map[string]string{
+		"tank":    dir("tank"),
+		"old":     dir("old"),
+		"mounted": dir("mounted"),
+		"busy":    dir("busy"),
+		"gone":    dir("gone"),
+	}}
+	changed, err := cleanupStaleMountpoints(st, []string{"tank"})
+	if err != nil || !changed {
+		t.Fatalf("cleanupStaleMountpoints() = %v, %v; want true, nil", changed, err)
+	}
+
+	for name, wantExists := range map[string]bool{"tank": true, "old": false, "mounted": true, "busy": true} {
+		if _, err := os.Stat(dir(name)); (err == nil) != wantExists {
+			t.Errorf("Directory %s exists = %v, want %v", name, err == nil, wantExists)
+		}
+	}
+	want := map[string]string{"tank": dir("tank"), "mounted": dir("mounted"), "busy": dir("busy")}
+	if fmt.Sprint(st.Mountpoints) != fmt.Sprint(want) {
+		t.Errorf("Tracked mountpoints = %v, want %v", st.Mountpoints, want)
+	}
+}
+
+func TestCreatePool_RecordsMountpoint(t *testing.T) {
+	state := newRunState(nil)
+	config := poolConfig{Name: "tank", Disks: []diskSpec{{Dev: "/dev/sda"}}, Ashift: "12"}
+	if err := createPool(t.Context(), &mockZFSProvider{}, "/fake/zpool", config, state); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	config = poolConfig{Name: "zvols", Disks: []diskSpec{{Dev: "/dev/sdb"}}, Ashift: "12", Mountpoint: "none"}
+	if err := createPool(t.Context(), &mockZFSProvider{}, "/fake/zpool", config, state); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	if fmt.Sprint(state.createdMountpoints) != "map[tank:/var/mnt/tank]" {
+		t.Errorf("Expected only the tank mountpoint to be recorded, got %v", state.createdMountpoints)
+	}
+
+	stateDir := t.TempDir()
+	setMountInfo(t, "/")
+	if err := updateMountpointState(stateDir, state.createdMountpoints, false, []string{"tank"}); err != nil {
+		t.Fatalf("updateMountpointState() returned an unexpected error: %v", err)
+	}
+	st, err := loadState(stateDir)
+	if err != nil || st.Mountpoints["tank"] != "/var/mnt/tank" {
+		t.Errorf("State after recording = %+v, %v", st, err)
+	}
+}
+
+func TestCreatePool_AdoptMountpoint(t *testing.T) {
+	var set []string
+	mountpoint := "/mnt/legacy-tank"
+	mockProvider := &mockZFSProvider{
+		GetDatasetPropertiesFunc: func(ctx context.Context, zfsPath, dataset string, props []string) (map[string]string, error) {
+			return map[string]string{"mountpoint": mountpoint}, nil
+		},
+		SetDatasetPropertyFunc: func(ctx context.Context, zfsPath, dataset, prop, value string) ([]byte, error) {
+			set = append(set, dataset+" "+prop+"="+value)
+			mountpoint = value
+			return nil, nil
+		},
+	}
+	state := newRunState(map[string]string{"tank": "123"})
+	state.zfsPath = "/fake/zfs"
+	config := poolConfig{Name: "tank", Ashift: "12", Adopt: true}
+	for range 2 {
+		if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, state); err != nil {
+			t.Fatalf("createPool() returned an unexpected error: %v", err)
+		}
+	}
+	if fmt.Sprint(set) != "[tank mountpoint=/var/mnt/tank]" {
+		t.Errorf("Expected the mountpoint to be moved once, got %v", set)
+	}
+	if state.createdMountpoints["tank"] != "/var/mnt/tank" {
+		t.Errorf("Expected the adopted mountpoint to be recorded, got %v", state.createdMountpoints)
+	}
+
+	config.Adopt = false
+	set = nil
+	mountpoint = "/mnt/elsewhere"
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, state); err != nil || set != nil {
+		t.Errorf("Expected the mountpoint to be left alone without adoption, got %v, %v", set, err)
+	}
+}
+
+func TestParseMountOwnership(t *testing.T) {
+	owner, errs := parseMountOwnership(0)
+	if owner != nil || len(errs) != 0 {
+		t.Errorf("parseMountOwnership() without variables = %+v, %v; want nil", owner, errs)
+	}
+
+	t.Setenv("ZPOOL_0_MOUNT_UID", "999")
+	t.Setenv("ZPOOL_0_MOUNT_MODE", "0750")
+	owner, errs = parseMountOwnership(0)
+	if len(errs) != 0 || owner == nil || *owner != (mountOwnership{UID: 999, GID: -1, Mode: 0o750}) {
+		t.Errorf("parseMountOwnership() = %+v, %v; want uid 999 and mode 0750", owner, errs)
+	}
+
+	t.Setenv("ZPOOL_0_MOUNT_GID", "-1")
+	t.Setenv("ZPOOL_0_MOUNT_MODE", "0789")
+	if _, errs = parseMountOwnership(0); len(errs) != 2 {
+		t.Errorf("Expected errors for the invalid gid and mode, got %v", errs)
+	}
+}
+
+func TestCreatePool_MountOwnership(t *testing.T) {
+	var calls []string
+	mockProvider := &mockZFSProvider{
+		EnsureOwnershipFunc: func(path string, uid, gid, mode int) (bool, error) {
+			calls = append(calls, fmt.Sprintf("%s %d %d %o", path, uid, gid, mode))
+			return true, nil
+		},
+	}
+	owner := &mountOwnership{UID: 999, GID: 999, Mode: 0o750}
+
+	config := poolConfig{Name: "tank", Disks: []diskSpec{{Dev: "/dev/sda"}}, Ashift: "12", MountOwner: owner}
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newRunState(nil)); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	// Existing pools are kept in line as well.
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newRunState(map[string]string{"tank": "1"})); err != nil {
+		t.Fatalf("createPool() for an existing pool returned an unexpected error: %v", err)
+	}
+	// Pools without a mountpoint directory are left alone.
+	config = poolConfig{Name: "zvols", Disks: []diskSpec{{Dev: "/dev/sdb"}}, Ashift: "12", Mountpoint: "none", MountOwner: owner}
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newRunState(nil)); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+
+	want := "[/var/mnt/tank 999 999 750 /var/mnt/tank 999 999 750]"
+	if fmt.Sprint(calls) != want {
+		t.Errorf("EnsureOwnership calls = %v, want %v", calls, want)
+	}
+}
+
+func TestLiveZFSProvider_EnsureOwnership(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	provider := &liveZFSProvider{}
+
+	changed, err := provider.EnsureOwnership(dir, os.Getuid(), -1, 0o1775)
+	if err != nil || !changed {
+		t.Fatalf("EnsureOwnership() = %v, %v; want a change", changed, err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o775 || info.Mode()&os.ModeSticky == 0 {
+		t.Errorf("Mode after EnsureOwnership() = %v, want sticky 0775", info.Mode())
+	}
+
+	changed, err = provider.EnsureOwnership(dir, os.Getuid(), -1, 0o1775)
+	if err != nil || changed {
+		t.Errorf("Second EnsureOwnership() = %v, %v; want no change", changed, err)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const notifyTimeout = 30 * time.Second // Deadline for delivering a notification to a single sink.
+
+// severities lists the notification severities from least to most severe.
+var severities = []string{severityInfo, severityWarning, severityCritical}
+
+// notifier delivers notifications to a single channel, e.g. mail or a webhook.
+type notifier interface {
+	// Name identifies the channel in log messages.
+	Name() string
+	// Notify delivers a single notification.
+	Notify(ctx context.Context, n notification) error
+}
+
+// notifySink is a configured notifier with the least severity it receives.
+type notifySink struct {
+	notifier
+	MinSeverity string
+}
+
+// accepts reports whether the sink receives notifications of the given severity.
+func (s notifySink) accepts(severity string) bool {
+	return slices.Index(severities, severity) >= slices.Index(severities, s.MinSeverity)
+}
+
+// parseMinSeverity reads the least severity a sink receives from key, defaulting to info.
+func parseMinSeverity(key string) (string, error) {
+	severity := strings.ToLower(getEnv(key, severityInfo))
+	if !slices.Contains(severities, severity) {
+		return "", fmt.Errorf("invalid %s %q, must be one of %s", key, severity, strings.Join(severities, ", "))
+	}
+	return severity, nil
+}
+
+// parseNotifySinks reads the configuration of every notification sink and returns the enabled
+// ones. Each sink has its own ZPOOL_<SINK>_MIN_SEVERITY filter.
+func parseNotifySinks() ([]notifySink, error) {
+	var sinks []notifySink
+	add := func(n notifier, severityKey string) error {
+		severity, err := parseMinSeverity(severityKey)
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, notifySink{notifier: n, MinSeverity: severity})
+		return nil
+	}
+
+	smtp, err := parseSMTPSettings()
+	if err != nil {
+		return nil, err
+	}
+	if smtp.enabled() {
+		if err := add(smtp, "ZPOOL_SMTP_MIN_SEVERITY"); err != nil {
+			return nil, err
+		}
+	}
+
+	if webhook := strings.TrimSpace(os.Getenv("ZPOOL_WEBHOOK_URL")); webhook != "" {
+		u, err := url.Parse(webhook)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("invalid ZPOOL_WEBHOOK_URL %q, must be an http or https URL", webhook)
+		}
+		n := webhookNotifier{URL: webhook, Authorization: os.Getenv("ZPOOL_WEBHOOK_AUTHORIZATION")}
+		if err := add(n, "ZPOOL_WEBHOOK_MIN_SEVERITY"); err != nil {
+			return nil, err
+		}
+	}
+
+	if path := strings.TrimSpace(os.Getenv("ZPOOL_NOTIFY_FILE")); path != "" {
+		if !filepath.IsAbs(path) {
+			return nil, fmt.Errorf("ZPOOL_NOTIFY_FILE must be an absolute path, got %q", path)
+		}
+		if err := add(fileNotifier{Path: path}, "ZPOOL_NOTIFY_FILE_MIN_SEVERITY"); err != nil {
+			return nil, err
+		}
+	}
+
+	events, err := parseKubeEventSettings()
+	if err != nil {
+		return nil, err
+	}
+	if events.enabled() {
+		if err := add(events, "ZPOOL_K8S_EVENTS_MIN_SEVERITY"); err != nil {
+			return nil, err
+		}
+	}
+	return sinks, nil
+}
+
+// notifyAll sends a notification to every sink that accepts its severity. Failed deliveries
+// are only logged and do not keep the other sinks from receiving it.
+func notifyAll(ctx context.Context, sinks []notifySink, n notification) {
+	if n.Node == "" {
+		n.Node = nodeName()
+	}
+	for _, sink := range sinks {
+		if !sink.accepts(n.Severity) {
+			continue
+		}
+		sinkCtx, cancel := context.WithTimeout(ctx, notifyTimeout)
+		err := sink.Notify(sinkCtx, n)
+		cancel()
+		if err != nil {
+			slog.Warn("Failed to send notification", "sink", sink.Name(), "event", n.Event, "pool", n.Pool, "error", err)
+			continue
+		}
+		slog.Info("Sent notification", "sink", sink.Name(), "event", n.Event, "pool", n.Pool, "severity", n.Severity)
+	}
+}
+
+// nodeName returns the host name of the node, or "unknown".
+func nodeName() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return name
+}
+
+// webhookNotifier posts notifications as JSON to a URL.
+type webhookNotifier struct {
+	URL           string
+	Authorization string // Value of the Authorization header, e.g. "Bearer <token>". Empty sends none.
+}
+
+func (w webhookNotifier) Name() string { return "webhook" }
+
+// Notify posts the notification as a JSON object. Any status other than 2xx is an error.
+func (w webhookNotifier) Notify(ctx context.Context, n notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Authorization != "" {
+		req.Header.Set("Authorization", w.Authorization)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// fileNotifier appends notifications as JSON lines to a file.
+type fileNotifier struct {
+	Path string
+}
+
+func (f fileNotifier) Name() string { return "file" }
+
+// Notify appends the notification as a single JSON line.
+func (f fileNotifier) Notify(ctx context.Context, n notification) error {
+	line, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
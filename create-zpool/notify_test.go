@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingNotifier records the notifications it receives.
+type recordingNotifier struct {
+	name string
+	err  error
+	got  *[]string
+}
+
+func (r recordingNotifier) Name() string { return r.name }
+
+func (r recordingNotifier) Notify(ctx context.Context, n notification) error {
+	*r.got = append(*r.got, r.name+":"+n.Event+"@"+n.Node)
+	return r.err
+}
+
+func TestNotifyAll_SeverityFilter(t *testing.T) {
+	var got []string
+	sinks := []notifySink{
+		{notifier: recordingNotifier{name: "all", err: errors.New("unreachable"), got: &got}, MinSeverity: severityInfo},
+		{notifier: recordingNotifier{name: "warnings", got: &got}, MinSeverity: severityWarning},
+		{notifier: recordingNotifier{name: "critical", got: &got}, MinSeverity: severityCritical},
+	}
+	notifyAll(t.Context(), sinks, notification{Severity: severityInfo, Event: eventPoolHealthy, Node: "node1", Pool: "tank"})
+	notifyAll(t.Context(), sinks, notification{Severity: severityWarning, Event: eventPoolUnhealthy, Node: "node1", Pool: "tank"})
+	notifyAll(t.Context(), sinks, notification{Severity: severityCritical, Event: eventScrubErrors, Node: "node1", Pool: "tank"})
+
+	want := []string{
+		"all:pool-healthy@node1",
+		"all:pool-unhealthy@node1", "warnings:pool-unhealthy@node1",
+		"all:scrub-errors@node1", "warnings:scrub-errors@node1", "critical:scrub-errors@node1",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Unexpected deliveries %q, want %q", got, want)
+	}
+
+	got = nil
+	notifyAll(t.Context(), sinks[:1], notification{Severity: severityInfo, Event: eventPoolHealthy, Pool: "tank"})
+	if want := "all:pool-healthy@" + nodeName(); len(got) != 1 || got[0] != want {
+		t.Errorf("Expected the node name to be filled in, got %q, want %q", got, want)
+	}
+}
+
+func TestParseNotifySinks(t *testing.T) {
+	for _, key := range []string{"ZPOOL_SMTP_HOST", "ZPOOL_WEBHOOK_URL", "ZPOOL_NOTIFY_FILE", "ZPOOL_K8S_EVENTS_SERVER"} {
+		t.Setenv(key, "")
+	}
+	sinks, err := parseNotifySinks()
+	if err != nil || len(sinks) != 0 {
+		t.Fatalf("Expected no sinks without configuration, got %v, %v", sinks, err)
+	}
+
+	t.Setenv("ZPOOL_WEBHOOK_URL", "https://alerts.example.com/hook")
+	t.Setenv("ZPOOL_WEBHOOK_MIN_SEVERITY", "Warning")
+	t.Setenv("ZPOOL_NOTIFY_FILE", "/var/log/zpool-events.jsonl")
+	sinks, err = parseNotifySinks()
+	if err != nil {
+		t.Fatalf("parseNotifySinks() returned an unexpected error: %v", err)
+	}
+	if len(sinks) != 2 || sinks[0].Name() != "webhook" || sinks[0].MinSeverity != severityWarning ||
+		sinks[1].Name() != "file" || sinks[1].MinSeverity != severityInfo {
+		t.Errorf("Unexpected sinks %+v", sinks)
+	}
+
+	tests := []struct {
+		key, value, wantErr string
+	}{
+		{"ZPOOL_WEBHOOK_MIN_SEVERITY", "error", "invalid ZPOOL_WEBHOOK_MIN_SEVERITY"},
+		{"ZPOOL_WEBHOOK_URL", "ftp://example.com", "invalid ZPOOL_WEBHOOK_URL"},
+		{"ZPOOL_NOTIFY_FILE", "events.jsonl", "must be an absolute path"},
+		{"ZPOOL_K8S_EVENTS_SERVER", "http://10.0.0.1:6443", "must be an https URL"},
+		{"ZPOOL_K8S_EVENTS_SERVER", "https://10.0.0.1:6443", "requires ZPOOL_K8S_EVENTS_TOKEN_FILE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.value, func(t *testing.T) {
+			t.Setenv(tt.key, tt.value)
+			if _, err := parseNotifySinks(); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestWebhookNotify(t *testing.T) {
+	var got notification
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode the webhook body: %v", err)
+		}
+		if strings.HasSuffix(r.URL.Path, "/fail") {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	n := notification{Time: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC), Severity: severityCritical, Event: eventScrubErrors, Node: "node1", Pool: "tank", Message: "2 errors"}
+	w := webhookNotifier{URL: server.URL + "/hook", Authorization: "Bearer secret"}
+	if err := w.Notify(t.Context(), n); err != nil {
+		t.Fatalf("Notify() returned an unexpected error: %v", err)
+	}
+	if got != n || auth != "Bearer secret" {
+		t.Errorf("Unexpected webhook request %+v with authorization %q", got, auth)
+	}
+
+	w.URL = server.URL + "/fail"
+	if err := w.Notify(t.Context(), n); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("Expected an error for the failed status, got %v", err)
+	}
+}
+
+func TestFileNotify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	f := fileNotifier{Path: path}
+	for _, pool := range []string{"tank", "backup"} {
+		if err := f.Notify(t.Context(), notification{Severity: severityWarning, Event: eventPoolUnhealthy, Pool: pool}); err != nil {
+			t.Fatalf("Notify() returned an unexpected error: %v", err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"pool":"tank"`) || !strings.Contains(lines[1], `"pool":"backup"`) {
+		t.Errorf("Unexpected file contents %q", data)
+	}
+}
+
+func TestKubeEventNotify(t *testing.T) {
+	var event map[string]any
+	var path, auth string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, auth = r.URL.Path, r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("Failed to decode the event: %v", err)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	caFile, tokenFile := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "token")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tokenFile, []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ZPOOL_K8S_EVENTS_SERVER", server.URL+"/")
+	t.Setenv("ZPOOL_K8S_EVENTS_CA_FILE", caFile)
+	t.Setenv("ZPOOL_K8S_EVENTS_TOKEN_FILE", tokenFile)
+	t.Setenv("ZPOOL_K8S_EVENTS_NAMESPACE", "kube-system")
+	t.Setenv("ZPOOL_K8S_EVENTS_NODE", "node1")
+
+	k, err := parseKubeEventSettings()
+	if err != nil {
+		t.Fatalf("parseKubeEventSettings() returned an unexpected error: %v", err)
+	}
+	n := notification{Time: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC), Severity: severityWarning, Event: eventPoolUnhealthy, Pool: "Tank", Message: "Pool Tank is DEGRADED."}
+	if err := k.Notify(t.Context(), n); err != nil {
+		t.Fatalf("Notify() returned an unexpected error: %v", err)
+	}
+	if path != "/api/v1/namespaces/kube-system/events" || auth != "Bearer secret" {
+		t.Errorf("Unexpected request to %q with authorization %q", path, auth)
+	}
+	involved, _ := event["involvedObject"].(map[string]any)
+	metadata, _ := event["metadata"].(map[string]any)
+	if event["reason"] != "PoolUnhealthy" || event["type"] != "Warning" || event["lastTimestamp"] != "2025-03-01T12:00:00Z" ||
+		involved["kind"] != "Node" || involved["name"] != "node1" || metadata["generateName"] != "zpool-tank." {
+		t.Errorf("Unexpected event %v", event)
+	}
+
+	n.Severity = severityInfo
+	if err := k.Notify(t.Context(), n); err != nil || event["type"] != "Normal" {
+		t.Errorf("Expected a Normal event for info notifications, got %v, %v", event["type"], err)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// maxParallelism bounds the limits, far above anything a single node benefits from.
+const maxParallelism = 1024
+
+// Concurrency limits per operation class, set from the environment by parseParallelismLimits.
+// Pools themselves are always processed one after the other, so that disk selection and the
+// order configured with ZPOOL_<n>_PRIORITY and ZPOOL_<n>_AFTER stay deterministic.
+var (
+	probeParallelism  = defaultProbeParallelism  // Devices probed concurrently per pool.
+	statusParallelism = defaultStatusParallelism // Per-pool status queries run concurrently in fallback mode.
+	diskParallelism   = 0                        // Disks erased, trimmed or burned in concurrently per pool, 0 for all at once.
+)
+
+// parseParallelismLimits reads ZPOOL_PROBE_PARALLELISM, ZPOOL_STATUS_PARALLELISM and
+// ZPOOL_DISK_PARALLELISM.
+func parseParallelismLimits() error {
+	var errs []error
+	limits := []struct {
+		key   string
+		limit *int
+		min   uint64
+	}{
+		{"ZPOOL_PROBE_PARALLELISM", &probeParallelism, 1},
+		{"ZPOOL_STATUS_PARALLELISM", &statusParallelism, 1},
+		{"ZPOOL_DISK_PARALLELISM", &diskParallelism, 0},
+	}
+	for _, l := range limits {
+		n, err := getEnvUint(l.key, uint64(*l.limit))
+		switch {
+		case err != nil:
+			errs = append(errs, err)
+		case n < l.min || n > maxParallelism:
+			errs = append(errs, fmt.Errorf("%s must be between %d and %d, got %d", l.key, l.min, maxParallelism, n))
+		default:
+			*l.limit = int(n)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// diskSlots returns how many of n disks are erased, trimmed or burned in at a time.
+func diskSlots(n int) int {
+	if diskParallelism == 0 || diskParallelism > n {
+		return max(n, 1)
+	}
+	return diskParallelism
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseParallelismLimits(t *testing.T) {
+	t.Cleanup(func() {
+		probeParallelism, statusParallelism, diskParallelism = defaultProbeParallelism, defaultStatusParallelism, 0
+	})
+
+	t.Setenv("ZPOOL_PROBE_PARALLELISM", "2")
+	t.Setenv("ZPOOL_DISK_PARALLELISM", "1")
+	if err := parseParallelismLimits(); err != nil {
+		t.Fatalf("parseParallelismLimits() returned an unexpected error: %v", err)
+	}
+	if probeParallelism != 2 || statusParallelism != defaultStatusParallelism || diskParallelism != 1 {
+		t.Errorf("Limits = probe %d, status %d, disk %d, want 2, %d, 1", probeParallelism, statusParallelism, diskParallelism, defaultStatusParallelism)
+	}
+
+	for _, value := range []string{"0", "-1", "many", "5000"} {
+		t.Setenv("ZPOOL_STATUS_PARALLELISM", value)
+		if err := parseParallelismLimits(); err == nil {
+			t.Errorf("Expected an error for ZPOOL_STATUS_PARALLELISM=%s", value)
+		}
+	}
+}
+
+func TestEraseDisks_Parallelism(t *testing.T) {
+	t.Cleanup(func() { diskParallelism = 0 })
+
+	for _, limit := range []int{0, 2} {
+		diskParallelism = limit
+		var (
+			mu               sync.Mutex
+			running, highest int
+		)
+		mockProvider := &mockZFSProvider{
+			DiscardDeviceFunc: func(path string, secure bool) error {
+				mu.Lock()
+				running++
+				highest = max(highest, running)
+				mu.Unlock()
+				time.Sleep(20 * time.Millisecond)
+				mu.Lock()
+				running--
+				mu.Unlock()
+				return nil
+			},
+		}
+		if err := eraseDisks(mockProvider, "tank", eraseDiscard, []string{"/dev/sda", "/dev/sdb", "/dev/sdc", "/dev/sdd"}); err != nil {
+			t.Fatalf("eraseDisks() returned an unexpected error: %v", err)
+		}
+		if want := diskSlots(4); highest > want || (limit > 0 && highest != want) {
+			t.Errorf("With ZPOOL_DISK_PARALLELISM=%d, %d disks were erased at once, want at most %d", limit, highest, want)
+		}
+	}
+}
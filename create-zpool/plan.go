@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// planAction is a single change the tool would make, as written to the JSON plan.
+type planAction struct {
+	Pool     string   `json:"pool,omitempty"`
+	Action   string   `json:"action"`
+	Args     []string `json:"args,omitempty"`     // Full zpool arguments for create, add, attach, detach and remove, or the properties of a new zvol.
+	ID       string   `json:"id,omitempty"`       // Numeric identifier of a pool to import.
+	Device   string   `json:"device,omitempty"`   // Device to discard or use as swap.
+	Path     string   `json:"path,omitempty"`     // Directory whose ownership is changed.
+	Dataset  string   `json:"dataset,omitempty"`  // Dataset to mount, zvol to create or snapshot to take.
+	Property string   `json:"property,omitempty"` // Pool or dataset property or module parameter to set.
+	Value    string   `json:"value,omitempty"`
+}
+
+// plan is the JSON document written in plan mode.
+type plan struct {
+	Actions []planAction `json:"actions"`
+	Errors  []string     `json:"errors,omitempty"`
+}
+
+// planningProvider records every change instead of making it and passes all queries through
+// to the wrapped provider, so that a normal run computes the plan with the exact same logic.
+type planningProvider struct {
+	zfsProvider
+
+	mu      sync.Mutex
+	pool    string          // Pool configuration currently being processed.
+	planned map[string]bool // Pools that only exist in the plan.
+	actions []planAction
+
+	explain bool     // Write the plan in plain language instead of JSON, see writeExplanation.
+	pools   []string // Configured pools, named in the explanation even without changes.
+}
+
+// newPlanningProvider wraps provider for plan mode.
+func newPlanningProvider(provider zfsProvider) *planningProvider {
+	return &planningProvider{zfsProvider: provider, planned: make(map[string]bool)}
+}
+
+// setPool sets the pool that subsequent actions are attributed to.
+func (p *planningProvider) setPool(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pool = name
+}
+
+func (p *planningProvider) record(action planAction) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	action.Pool = p.pool
+	p.actions = append(p.actions, action)
+}
+
+// CreatePool records the pool creation.
+func (p *planningProvider) CreatePool(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+	p.record(planAction{Action: "create", Args: args})
+	p.mu.Lock()
+	p.planned[p.pool] = true
+	p.mu.Unlock()
+	return nil, nil
+}
+
+// ImportPool records the pool import.
+func (p *planningProvider) ImportPool(ctx context.Context, zpoolPath, id string) ([]byte, error) {
+	p.record(planAction{Action: "import", ID: id})
+	p.mu.Lock()
+	p.planned[p.pool] = true
+	p.mu.Unlock()
+	return nil, nil
+}
+
+// ImportPoolReadOnly records the read-only pool import.
+func (p *planningProvider) ImportPoolReadOnly(ctx context.Context, zpoolPath, id string) ([]byte, error) {
+	p.record(planAction{Action: "import-readonly", ID: id})
+	return nil, nil
+}
+
+// ExportPool records the pool export.
+func (p *planningProvider) ExportPool(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+	p.record(planAction{Action: "export"})
+	return nil, nil
+}
+
+// ClearPool records clearing the pool errors.
+func (p *planningProvider) ClearPool(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+	p.record(planAction{Action: "clear"})
+	return nil, nil
+}
+
+// InitializePool records the start of the pool initialization.
+func (p *planningProvider) InitializePool(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+	p.record(planAction{Action: "initialize"})
+	return nil, nil
+}
+
+// GetInitializeStatus reports no progress for planned pools.
+func (p *planningProvider) GetInitializeStatus(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+	return nil, nil
+}
+
+// GetPoolProperties reports no values for pools that only exist in the plan, since the
+// properties of a pool that is still exported cannot be read.
+func (p *planningProvider) GetPoolProperties(ctx context.Context, zpoolPath, name string, props []string) (map[string]string, error) {
+	p.mu.Lock()
+	planned := p.planned[name]
+	p.mu.Unlock()
+	if planned {
+		return map[string]string{}, nil
+	}
+	return p.zfsProvider.GetPoolProperties(ctx, zpoolPath, name, props)
+}
+
+// ListDatasets reports no datasets for pools that only exist in the plan.
+func (p *planningProvider) ListDatasets(ctx context.Context, zfsPath, pool string) ([]datasetInfo, error) {
+	p.mu.Lock()
+	planned := p.planned[pool]
+	p.mu.Unlock()
+	if planned {
+		return nil, nil
+	}
+	return p.zfsProvider.ListDatasets(ctx, zfsPath, pool)
+}
+
+// LoadKeys records loading the encryption keys.
+func (p *planningProvider) LoadKeys(ctx context.Context, zfsPath, pool string) ([]byte, error) {
+	p.record(planAction{Action: "load-keys"})
+	return nil, nil
+}
+
+// MountDataset records the mount.
+func (p *planningProvider) MountDataset(ctx context.Context, zfsPath, dataset string) ([]byte, error) {
+	p.record(planAction{Action: "mount", Dataset: dataset})
+	return nil, nil
+}
+
+// SnapshotRecursive records the snapshot.
+func (p *planningProvider) SnapshotRecursive(ctx context.Context, zfsPath, snapshot string) ([]byte, error) {
+	p.record(planAction{Action: "snapshot", Dataset: snapshot})
+	return nil, nil
+}
+
+// CheckpointPool records the pool checkpoint.
+func (p *planningProvider) CheckpointPool(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+	p.record(planAction{Action: "checkpoint"})
+	return nil, nil
+}
+
+// DiscardCheckpoint records discarding the pool checkpoint.
+func (p *planningProvider) DiscardCheckpoint(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+	p.record(planAction{Action: "discard-checkpoint"})
+	return nil, nil
+}
+
+// UpgradePool records the pool upgrade.
+func (p *planningProvider) UpgradePool(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+	p.record(planAction{Action: "upgrade"})
+	return nil, nil
+}
+
+// ListVolumes reports no zvols for pools that only exist in the plan.
+func (p *planningProvider) ListVolumes(ctx context.Context, zfsPath, pool string) (map[string]string, error) {
+	p.mu.Lock()
+	planned := p.planned[pool]
+	p.mu.Unlock()
+	if planned {
+		return nil, nil
+	}
+	return p.zfsProvider.ListVolumes(ctx, zfsPath, pool)
+}
+
+// CreateVolume records the zvol creation.
+func (p *planningProvider) CreateVolume(ctx context.Context, zfsPath, dataset string, size uint64, props map[string]string) ([]byte, error) {
+	p.record(planAction{Action: "create-volume", Dataset: dataset, Args: poolPropertyArgs(props), Value: strconv.FormatUint(size, 10)})
+	return nil, nil
+}
+
+// FormatSwap records formatting a zvol as swap.
+func (p *planningProvider) FormatSwap(path string) error {
+	p.record(planAction{Action: "format-swap", Device: path})
+	return nil
+}
+
+// EnableSwap records enabling swap.
+func (p *planningProvider) EnableSwap(path string) error {
+	p.record(planAction{Action: "enable-swap", Device: path})
+	return nil
+}
+
+// AddVdevs records the addition of vdevs.
+func (p *planningProvider) AddVdevs(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+	p.record(planAction{Action: "add", Args: args})
+	return nil, nil
+}
+
+// AttachDevice records the attachment of a device.
+func (p *planningProvider) AttachDevice(ctx context.Context, zpoolPath, name, device, newDevice, ashift string) ([]byte, error) {
+	p.record(planAction{Action: "attach", Args: attachArgs(name, device, newDevice, ashift)})
+	return nil, nil
+}
+
+// DetachDevice records the detachment of a device.
+func (p *planningProvider) DetachDevice(ctx context.Context, zpoolPath, name, device string) ([]byte, error) {
+	p.record(planAction{Action: "detach", Args: []string{"detach", name, device}})
+	return nil, nil
+}
+
+// RemoveVdev records the removal of a vdev.
+func (p *planningProvider) RemoveVdev(ctx context.Context, zpoolPath, name, vdev string) ([]byte, error) {
+	p.record(planAction{Action: "remove", Args: []string{"remove", name, vdev}})
+	return nil, nil
+}
+
+// SetDatasetProperty records the property change.
+func (p *planningProvider) SetDatasetProperty(ctx context.Context, zfsPath, dataset, prop, value string) ([]byte, error) {
+	p.record(planAction{Action: "set-dataset-property", Dataset: dataset, Property: prop, Value: value})
+	return nil, nil
+}
+
+// SetPoolProperty records the property change.
+func (p *planningProvider) SetPoolProperty(ctx context.Context, zpoolPath, name, prop, value string) ([]byte, error) {
+	p.record(planAction{Action: "set-property", Property: prop, Value: value})
+	return nil, nil
+}
+
+// DiscardDevice records the discard.
+func (p *planningProvider) DiscardDevice(path string, secure bool) error {
+	action := "discard"
+	if secure {
+		action = "secure-discard"
+	}
+	p.record(planAction{Action: action, Device: path})
+	return nil
+}
+
+// WriteModuleParameter records the module parameter change.
+func (p *planningProvider) WriteModuleParameter(name, value string) error {
+	p.record(planAction{Action: "set-module-parameter", Property: name, Value: value})
+	return nil
+}
+
+// EnsureOwnership records the ownership change. Whether anything would actually change is
+// not checked, since the mountpoint of a planned pool does not exist yet.
+func (p *planningProvider) EnsureOwnership(path string, uid, gid, mode int) (bool, error) {
+	var attrs []string
+	if uid >= 0 {
+		attrs = append(attrs, fmt.Sprintf("uid=%d", uid))
+	}
+	if gid >= 0 {
+		attrs = append(attrs, fmt.Sprintf("gid=%d", gid))
+	}
+	if mode >= 0 {
+		attrs = append(attrs, fmt.Sprintf("mode=%04o", mode))
+	}
+	p.record(planAction{Action: "set-ownership", Path: path, Value: strings.Join(attrs, " ")})
+	return false, nil
+}
+
+// BurnInDevice refuses to run, burn-in is never part of a plan.
+func (p *planningProvider) BurnInDevice(path string, size, seed uint64) error {
+	return fmt.Errorf("burn-in of %s is not supported in plan mode", path)
+}
+
+// ProbeSyncWriteLatency refuses to run, nothing is written to a device in plan mode.
+func (p *planningProvider) ProbeSyncWriteLatency(path string, writes int) (time.Duration, error) {
+	return 0, fmt.Errorf("latency probe of %s is not supported in plan mode", path)
+}
+
+// writePlan writes the recorded actions and errors as indented JSON, or as explanation, to
+// path, or to stdout if path is empty.
+func (p *planningProvider) writePlan(path string, errs []error) error {
+	p.mu.Lock()
+	doc := plan{Actions: p.actions}
+	p.mu.Unlock()
+	if doc.Actions == nil {
+		doc.Actions = []planAction{}
+	}
+	for _, err := range errs {
+		doc.Errors = append(doc.Errors, err.Error())
+	}
+
+	var data []byte
+	if p.explain {
+		var buf bytes.Buffer
+		writeExplanation(&buf, doc, p.pools)
+		data = buf.Bytes()
+	} else {
+		var err error
+		if data, err = json.MarshalIndent(doc, "", "  "); err != nil {
+			return err
+		}
+		data = append(data, '\n')
+	}
+	if path == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// finishPlan writes the plan and returns the process exit code, which is non-zero if the
+// plan could not be written or the run that computed it had errors.
+func finishPlan(planner *planningProvider, path string, errs []error) int {
+	planner.setPool("")
+	if err := planner.writePlan(path, errs); err != nil {
+		slog.Error("Failed to write plan", "path", path, "error", err)
+		return 1
+	}
+	slog.Info("Plan written, no changes were made.", "path", path, "actions", len(planner.actions), "error_count", len(errs))
+	if len(errs) > 0 {
+		return 1
+	}
+	return 0
+}
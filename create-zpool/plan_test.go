@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPlanningProvider_CreatePool(t *testing.T) {
+	mockProvider := &mockZFSProvider{
+		CreatePoolFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+			t.Errorf("Plan must not create pools, got %v", args)
+			return nil, nil
+		},
+		DiscardDeviceFunc: func(path string, secure bool) error {
+			t.Errorf("Plan must not discard %s", path)
+			return nil
+		},
+		InitializePoolFunc: func(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+			t.Errorf("Plan must not initialize %s", name)
+			return nil, nil
+		},
+	}
+	planner := newPlanningProvider(mockProvider)
+	planner.setPool("tank")
+
+	config := poolConfig{
+		Name:       "tank",
+		Type:       "mirror",
+		Disks:      []diskSpec{{Dev: "/dev/sda"}, {Dev: "/dev/sdb"}},
+		Ashift:     "12",
+		Erase:      eraseSecure,
+		Initialize: true,
+	}
+	if err := createPool(t.Context(), planner, "/fake/zpool", config, &runState{existingPools: map[string]string{}, usedDisks: map[string]bool{}, dryRun: true}); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+
+	// Erases run concurrently, so only the set of erased devices is deterministic.
+	if len(planner.actions) != 4 {
+		t.Fatalf("Expected 4 planned actions, got %+v", planner.actions)
+	}
+	erased := map[string]bool{planner.actions[0].Device: true, planner.actions[1].Device: true}
+	if !erased["/dev/sda"] || !erased["/dev/sdb"] || planner.actions[0].Action != "secure-discard" {
+		t.Errorf("Expected both disks to be securely discarded first, got %+v", planner.actions[:2])
+	}
+	wantCreate := planAction{Pool: "tank", Action: "create", Args: []string{"create", "-m", "/var/mnt/tank", "-o", "ashift=12", "tank", "mirror", "/dev/sda", "/dev/sdb"}}
+	if !reflect.DeepEqual(planner.actions[2], wantCreate) {
+		t.Errorf("Planned create = %+v, want %+v", planner.actions[2], wantCreate)
+	}
+	if !reflect.DeepEqual(planner.actions[3], planAction{Pool: "tank", Action: "initialize"}) {
+		t.Errorf("Expected initialization to be planned last, got %+v", planner.actions[3])
+	}
+}
+
+func TestPlanningProvider_ImportAndReconcile(t *testing.T) {
+	mockProvider := &mockZFSProvider{
+		ListImportablePoolsFunc: func(ctx context.Context, zpoolPath string) ([]importablePool, error) {
+			return []importablePool{{Name: "tank", ID: "42"}}, nil
+		},
+		ImportPoolFunc: func(ctx context.Context, zpoolPath, id string) ([]byte, error) {
+			t.Errorf("Plan must not import pool %s", id)
+			return nil, nil
+		},
+		GetPoolPropertiesFunc: func(ctx context.Context, zpoolPath, name string, props []string) (map[string]string, error) {
+			return nil, errors.New("cannot open 'tank': no such pool")
+		},
+	}
+	planner := newPlanningProvider(mockProvider)
+	planner.setPool("tank")
+
+	config := poolConfig{Name: "tank", Ashift: "12", Import: true, Properties: map[string]string{"autotrim": "on"}, Reconcile: []string{"autotrim"}}
+	if err := createPool(t.Context(), planner, "/fake/zpool", config, newRunState(nil)); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	want := []planAction{
+		{Pool: "tank", Action: "import", ID: "42"},
+		{Pool: "tank", Action: "set-property", Property: "autotrim", Value: "on"},
+	}
+	if !reflect.DeepEqual(planner.actions, want) {
+		t.Errorf("Planned actions = %+v, want %+v", planner.actions, want)
+	}
+}
+
+func TestCreatePool_DryRunDoesNotWait(t *testing.T) {
+	mockProvider := &mockZFSProvider{
+		IsBlockDeviceFunc: func(path string) (bool, error) {
+			return path != "/dev/sdb", nil
+		},
+	}
+	planner := newPlanningProvider(mockProvider)
+	state := newRunState(nil)
+	state.dryRun = true
+
+	config := poolConfig{Name: "tank", Type: "mirror", Disks: []diskSpec{{Dev: "/dev/sda"}, {Dev: "/dev/sdb"}}, Ashift: "12", WaitForDisks: time.Hour}
+	start := time.Now()
+	if err := createPool(t.Context(), planner, "/fake/zpool", config, state); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	if time.Since(start) > time.Minute {
+		t.Error("Plan waited for missing disks")
+	}
+	if len(planner.actions) != 0 || state.usedDisks["/dev/sda"] {
+		t.Errorf("Expected no actions and no used disks for an incomplete pool, got %+v, %v", planner.actions, state.usedDisks)
+	}
+}
+
+func TestWritePlan(t *testing.T) {
+	planner := newPlanningProvider(&mockZFSProvider{})
+	planner.setPool("tank")
+	if err := planner.WriteModuleParameter("zfs_arc_max", "1073741824"); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := planner.writePlan(path, []error{errors.New(`pool "data": invalid type: "mirrror"`)}); err != nil {
+		t.Fatalf("writePlan() returned an unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc plan
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("Plan is not valid JSON: %v\n%s", err, data)
+	}
+	want := plan{
+		Actions: []planAction{{Pool: "tank", Action: "set-module-parameter", Property: "zfs_arc_max", Value: "1073741824"}},
+		Errors:  []string{`pool "data": invalid type: "mirrror"`},
+	}
+	if !reflect.DeepEqual(doc, want) {
+		t.Errorf("writePlan() wrote %+v, want %+v", doc, want)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// importablePool is an exported pool found by scanning devices with `zpool import`.
+type importablePool struct {
+	Name    string   // Pool name.
+	ID      string   // Numeric pool identifier (the pool GUID).
+	State   string   // Pool state as reported by the scan (e.g. "ONLINE", "DEGRADED").
+	Devices []string // Leaf devices listed in the pool configuration.
+	// Auxiliary are those of Devices in the cache and spares sections, which are not configured
+	// for pools but may have been added by hand.
+	Auxiliary []string
+}
+
+// vdevGroupPattern matches the names of interior vdevs and device class headings in
+// `zpool import` and `zpool status` configuration listings.
+var vdevGroupPattern = regexp.MustCompile(`^((mirror|raidz[123]?|draid[123]?(:[0-9a-z:]+)?|replacing|spare)-[0-9]+|logs|cache|spares|special|dedup)$`)
+
+// parseImportablePools parses the human readable output of `zpool import` without arguments.
+func parseImportablePools(output string) []importablePool {
+	var pools []importablePool
+	var current *importablePool
+	inConfig, auxiliary := false, false
+
+	for line := range strings.Lines(output) {
+		trimmed := strings.TrimSpace(line)
+		key, value, hasKey := strings.Cut(trimmed, ":")
+		value = strings.TrimSpace(value)
+
+		switch {
+		case hasKey && key == "pool":
+			pools = append(pools, importablePool{Name: value})
+			current = &pools[len(pools)-1]
+			inConfig, auxiliary = false, false
+		case current == nil:
+			continue
+		case hasKey && key == "id":
+			current.ID = value
+		case hasKey && key == "state" && !inConfig:
+			current.State = value
+		case hasKey && key == "config":
+			inConfig = true
+		case inConfig && trimmed != "":
+			fields := strings.Fields(trimmed)
+			switch fields[0] {
+			case "cache", "spares":
+				auxiliary = true
+			case "logs", "special", "dedup":
+				auxiliary = false
+			}
+			if fields[0] == current.Name || vdevGroupPattern.MatchString(fields[0]) {
+				continue
+			}
+			current.Devices = append(current.Devices, fields[0])
+			if auxiliary {
+				current.Auxiliary = append(current.Auxiliary, fields[0])
+			}
+		}
+	}
+	return pools
+}
+
+// importablePools scans for exported pools once per run and returns the cached result afterwards.
+func (s *runState) importablePools(ctx context.Context, provider zfsProvider, zpoolPath string) ([]importablePool, error) {
+	if !s.importScanned {
+		pools, err := provider.ListImportablePools(ctx, zpoolPath)
+		if err != nil {
+			return nil, err
+		}
+		s.importable = pools
+		s.importScanned = true
+		slog.Info("Scanned devices for exported pools", "count", len(pools))
+	}
+	return s.importable, nil
+}
+
+// importExportedPool imports an exported pool with the configured name instead of creating a
+// new one on top of its disks. It reports whether a pool was imported. Several exported pools
+// sharing the name are an error, since picking one could import the wrong data, and so is one
+// on other disks than the configured ones, see checkExportedPoolDevices.
+func importExportedPool(ctx context.Context, provider zfsProvider, zpoolPath string, config poolConfig, state *runState) (bool, error) {
+	pools, err := state.importablePools(ctx, provider, zpoolPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to scan for exported pools: %w", err)
+	}
+
+	var matches []importablePool
+	for _, pool := range pools {
+		if pool.Name == config.Name {
+			matches = append(matches, pool)
+		}
+	}
+	if len(matches) == 0 {
+		return false, nil
+	}
+	if len(matches) > 1 {
+		var ids []string
+		for _, m := range matches {
+			ids = append(ids, m.ID)
+		}
+		return false, fmt.Errorf("found %d exported pools named %q (ids %s), import the right one manually", len(matches), config.Name, strings.Join(ids, ", "))
+	}
+
+	pool := matches[0]
+	if err := state.verifyPoolGUID(config.Name, pool.ID); err != nil {
+		return false, err
+	}
+	if err := checkExportedPoolDevices(provider, config, pool); err != nil {
+		return false, err
+	}
+	slog.Info("Found exported pool, importing it instead of creating a new one", "pool", config.Name, "id", pool.ID, "state", pool.State, "devices", pool.Devices)
+	importCtx, cancel := withCommandTimeout(ctx, config.ImportTimeout)
+	output, err := provider.ImportPool(importCtx, zpoolPath, pool.ID)
+	cancel()
+	if err != nil {
+		err = newZpoolCommandError("import", err, output)
+		var devices []string
+		for _, dev := range pool.Devices {
+			devices = append(devices, importDevicePath(provider, dev))
+		}
+		err = withDevices(err, devices)
+		state.collectDiagnostics(ctx, provider, config.Name, "import", devices, err)
+		return false, err
+	}
+	slog.Info("ZFS pool imported successfully", "pool", config.Name, "id", pool.ID)
+
+	if state.existingPools == nil {
+		state.existingPools = make(map[string]string)
+	}
+	state.existingPools[config.Name] = pool.ID
+	state.invalidatePoolStatuses()
+	return true, nil
+}
+
+// checkExportedPoolDevices checks that every device of an exported pool is on one of the disks
+// configured for the pool: an unrelated pool of the same name, e.g. on a disk moved in from
+// another node, must not be imported in its place. A device matches a configured path, or the
+// query of a model entry, each of which stands for one disk. Cache and spare devices are not
+// checked, since the pool does not need them, and neither is any pool that has no disks
+// configured, which is only ever imported.
+func checkExportedPoolDevices(provider zfsProvider, config poolConfig, pool importablePool) error {
+	disks, err := configuredDisks(config)
+	if err != nil {
+		return err
+	}
+	for _, class := range vdevClasses {
+		listed, err := config.classVdev(class).disks()
+		if err != nil {
+			return fmt.Errorf("invalid %s disk list: %w", class, err)
+		}
+		disks = append(disks, listed...)
+	}
+	if len(disks) == 0 {
+		return nil
+	}
+
+	var names, models []string
+	for _, disk := range disks {
+		if disk.Dev != "" {
+			names = append(names, filepath.Base(disk.Dev), resolvedDiskName(provider, disk.Dev))
+		} else {
+			models = append(models, disk.Model)
+		}
+	}
+	var foreign []string
+	for _, dev := range pool.Devices {
+		if slices.Contains(pool.Auxiliary, dev) {
+			continue
+		}
+		leaf := &vdevStatus{Name: dev, Path: importDevicePath(provider, dev)}
+		if slices.ContainsFunc(names, func(name string) bool { return leafMatchesDisk(provider, leaf, name) }) {
+			continue
+		}
+		if model, err := provider.GetDiskModel(leaf.Path); err == nil {
+			if i := slices.IndexFunc(models, func(query string) bool { return modelMatches(query, model) }); i >= 0 {
+				models = slices.Delete(models, i, i+1)
+				continue
+			}
+		}
+		foreign = append(foreign, dev)
+	}
+	if len(foreign) > 0 {
+		return fmt.Errorf("exported pool %q (id %s) is on devices that are not configured for it: %s; import it by hand with `zpool import %s` if it is the right pool", config.Name, pool.ID, strings.Join(foreign, ", "), pool.ID)
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testImportOutput = `   pool: tank
+     id: 15011732311313692791
+  state: ONLINE
+ action: The pool can be imported using its name or numeric identifier.
+ config:
+
+	tank        ONLINE
+	  mirror-0  ONLINE
+	    sda     ONLINE
+	    sdb     ONLINE
+	logs
+	  nvme0n1   ONLINE
+
+   pool: old
+     id: 42
+  state: DEGRADED
+status: One or more devices are missing from the system.
+ action: The pool can be imported despite missing or damaged devices.
+ config:
+
+	old         DEGRADED
+	  raidz1-0  DEGRADED
+	    sdc     ONLINE
+	    sdd     UNAVAIL
+	    sde     ONLINE
+`
+
+func TestParseImportablePools(t *testing.T) {
+	pools := parseImportablePools(testImportOutput)
+	if len(pools) != 2 {
+		t.Fatalf("Expected 2 importable pools, got %d: %+v", len(pools), pools)
+	}
+
+	tank := pools[0]
+	if tank.Name != "tank" || tank.ID != "15011732311313692791" || tank.State != "ONLINE" {
+		t.Errorf("tank is incorrect: got %+v", tank)
+	}
+	if strings.Join(tank.Devices, " ") != "sda sdb nvme0n1" {
+		t.Errorf("tank devices are incorrect: got %v", tank.Devices)
+	}
+
+	old := pools[1]
+	if old.Name != "old" || old.ID != "42" || old.State != "DEGRADED" || strings.Join(old.Devices, " ") != "sdc sdd sde" {
+		t.Errorf("old is incorrect: got %+v", old)
+	}
+
+	withCache := parseImportablePools(strings.Replace(testImportOutput, "\tlogs\n", "\tcache\n\t  sdf       ONLINE\n\tlogs\n", 1))
+	if strings.Join(withCache[0].Devices, " ") != "sda sdb sdf nvme0n1" || strings.Join(withCache[0].Auxiliary, " ") != "sdf" {
+		t.Errorf("tank with a cache device is incorrect: got %+v", withCache[0])
+	}
+
+	if pools := parseImportablePools(""); len(pools) != 0 {
+		t.Errorf("Expected no pools for empty output, got %+v", pools)
+	}
+}
+
+func TestCreatePool_ImportsExportedPool(t *testing.T) {
+	var scans int
+	var imported []string
+	mockProvider := &mockZFSProvider{
+		ListImportablePoolsFunc: func(ctx context.Context, zpoolPath string) ([]importablePool, error) {
+			scans++
+			return parseImportablePools(testImportOutput), nil
+		},
+		ImportPoolFunc: func(ctx context.Context, zpoolPath, id string) ([]byte, error) {
+			imported = append(imported, id)
+			return nil, nil
+		},
+		CreatePoolFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+			if strings.Contains(strings.Join(args, " "), " tank ") {
+				t.Fatalf("CreatePool must not be called for an importable pool, got args %v", args)
+			}
+			return nil, nil
+		},
+	}
+
+	state := newRunState(nil)
+	tank := poolConfig{Name: "tank", Type: "mirror", Disks: []diskSpec{{Dev: "/dev/sda"}, {Dev: "/dev/sdb"}}, Log: vdevSpec{DiskList: "/dev/nvme0n1"}, Ashift: "12", Import: true}
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", tank, state); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	if strings.Join(imported, " ") != "15011732311313692791" {
+		t.Errorf("Expected tank to be imported by id, got %v", imported)
+	}
+	if state.existingPools["tank"] != "15011732311313692791" {
+		t.Errorf("Expected imported pool to be recorded as existing, got %v", state.existingPools)
+	}
+	if len(state.usedDisks) != 0 {
+		t.Errorf("Expected no disks to be picked for an imported pool, got %v", state.usedDisks)
+	}
+
+	// A pool without an exported counterpart is created; the scan is not repeated.
+	fresh := poolConfig{Name: "fresh", Disks: []diskSpec{{Dev: "/dev/sdf"}}, Ashift: "12", Import: true}
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", fresh, state); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	if scans != 1 {
+		t.Errorf("Expected a single import scan per run, got %d", scans)
+	}
+}
+
+func TestImportExportedPool_ForeignDevices(t *testing.T) {
+	exported := importablePool{Name: "tank", ID: "7", Devices: []string{"ata-WDC_1", "nvme-Samsung_2-part1", "sdz"}, Auxiliary: []string{"sdz"}}
+	provider := &mockZFSProvider{
+		ListImportablePoolsFunc: func(ctx context.Context, zpoolPath string) ([]importablePool, error) {
+			return []importablePool{exported}, nil
+		},
+		IsBlockDeviceFunc: func(path string) (bool, error) {
+			return strings.HasPrefix(path, "/dev/disk/by-id/"), nil
+		},
+		GetDiskModelFunc: func(path string) (string, error) {
+			if path == "/dev/disk/by-id/nvme-Samsung_2-part1" {
+				return "Samsung SSD 980 PRO", nil
+			}
+			return "", errors.New("no model")
+		},
+	}
+	var imported []string
+	provider.ImportPoolFunc = func(ctx context.Context, zpoolPath, id string) ([]byte, error) {
+		imported = append(imported, id)
+		return nil, nil
+	}
+
+	// A disk of a configured path and one matching a model query; the spare is not checked.
+	config := poolConfig{Name: "tank", Disks: []diskSpec{{Dev: "/dev/disk/by-id/ata-WDC_1"}, {Model: "Samsung SSD 980*"}}}
+	if ok, err := importExportedPool(t.Context(), provider, "/fake/zpool", config, newRunState(nil)); !ok || err != nil {
+		t.Fatalf("importExportedPool() = %v, %v; want the configured pool imported", ok, err)
+	}
+
+	// The same name on disks configured for nothing, e.g. moved in from another node.
+	config.Disks = []diskSpec{{Dev: "/dev/disk/by-id/ata-WDC_1"}, {Dev: "/dev/disk/by-id/ata-WDC_3"}}
+	_, err := importExportedPool(t.Context(), provider, "/fake/zpool", config, newRunState(nil))
+	if err == nil || !strings.Contains(err.Error(), "nvme-Samsung_2-part1") {
+		t.Errorf("Expected the unconfigured device to be refused, got %v", err)
+	}
+	if len(imported) != 1 {
+		t.Errorf("Expected only the configured pool to be imported, got %v", imported)
+	}
+}
+
+func TestCreatePool_ImportAmbiguousOrFailedScan(t *testing.T) {
+	config := poolConfig{Name: "tank", Disks: []diskSpec{{Dev: "/dev/sda"}}, Ashift: "12", Import: true}
+
+	duplicate := &mockZFSProvider{
+		ListImportablePoolsFunc: func(ctx context.Context, zpoolPath string) ([]importablePool, error) {
+			return []importablePool{{Name: "tank", ID: "1"}, {Name: "tank", ID: "2"}}, nil
+		},
+	}
+	if err := createPool(t.Context(), duplicate, "/fake/zpool", config, newRunState(nil)); err == nil || !strings.Contains(err.Error(), "2 exported pools") {
+		t.Errorf("Expected an ambiguity error, got %v", err)
+	}
+
+	failing := &mockZFSProvider{
+		ListImportablePoolsFunc: func(ctx context.Context, zpoolPath string) ([]importablePool, error) {
+			return nil, errors.New("scan timed out")
+		},
+		CreatePoolFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+			t.Fatal("CreatePool must not be called when the import scan failed")
+			return nil, nil
+		},
+	}
+	if err := createPool(t.Context(), failing, "/fake/zpool", config, newRunState(nil)); err == nil {
+		t.Error("Expected an error when the import scan fails")
+	}
+}
+
+func TestCreatePool_PerPoolTimeouts(t *testing.T) {
+	deadlines := make(map[string]time.Duration)
+	record := func(ctx context.Context, op string) {
+		if deadline, ok := ctx.Deadline(); ok {
+			deadlines[op] = time.Until(deadline).Round(time.Hour)
+		}
+	}
+	mockProvider := &mockZFSProvider{
+		ListImportablePoolsFunc: func(ctx context.Context, zpoolPath string) ([]importablePool, error) {
+			return parseImportablePools(testImportOutput), nil
+		},
+		ImportPoolFunc: func(ctx context.Context, zpoolPath, id string) ([]byte, error) {
+			record(ctx, "import")
+			return nil, nil
+		},
+		CreatePoolFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+			record(ctx, "create")
+			return nil, nil
+		},
+	}
+
+	state := newRunState(nil)
+	tank := poolConfig{Name: "tank", Type: "mirror", Disks: []diskSpec{{Dev: "/dev/sda"}, {Dev: "/dev/sdb"}}, Log: vdevSpec{DiskList: "/dev/nvme0n1"}, Ashift: "12", Import: true, ImportTimeout: 2 * time.Hour}
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", tank, state); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	archive := poolConfig{Name: "archive", Type: "draid2", Disks: []diskSpec{{Dev: "/dev/sdf"}, {Dev: "/dev/sdg"}, {Dev: "/dev/sdh"}}, Ashift: "12", CreateTimeout: 3 * time.Hour}
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", archive, state); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	if deadlines["import"] != 2*time.Hour || deadlines["create"] != 3*time.Hour {
+		t.Errorf("Expected the per-pool timeouts as deadlines, got %v", deadlines)
+	}
+
+	// Without a per-pool timeout the provider applies ZPOOL_COMMAND_TIMEOUT.
+	clear(deadlines)
+	scratch := poolConfig{Name: "scratch", Disks: []diskSpec{{Dev: "/dev/sdj"}}, Ashift: "12"}
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", scratch, state); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	if len(deadlines) != 0 {
+		t.Errorf("Expected no deadline without a per-pool timeout, got %v", deadlines)
+	}
+}
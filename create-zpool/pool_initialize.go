@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// initializePollInterval is how often initialization progress is logged while waiting for it.
+var initializePollInterval = 30 * time.Second
+
+// initializeProgressPattern matches the per-device annotation of `zpool status -i`,
+// e.g. "(42% initialized, started at Mon Oct 12 10:00:00 2026)".
+var initializeProgressPattern = regexp.MustCompile(`^\s*(\S+)\s.*\((\d+)% initialized, (\w+) at [^)]*\)`)
+
+// initializeProgress is the initialization state of a single device.
+type initializeProgress struct {
+	Device  string
+	Percent string // Percentage written so far, e.g. "42".
+	State   string // "started", "suspended" or "completed".
+}
+
+// parseInitializeProgress extracts the per-device initialization progress from `zpool status -i` output.
+func parseInitializeProgress(output string) []initializeProgress {
+	var progress []initializeProgress
+	for line := range strings.Lines(output) {
+		if m := initializeProgressPattern.FindStringSubmatch(line); m != nil {
+			progress = append(progress, initializeProgress{Device: m[1], Percent: m[2], State: m[3]})
+		}
+	}
+	return progress
+}
+
+// initializePool starts `zpool initialize` on a newly created pool and logs its progress.
+// If config.InitializeWait is set, it keeps logging progress until every device completed.
+func initializePool(ctx context.Context, provider zfsProvider, zpoolPath string, config poolConfig) error {
+	slog.Info("Starting pool initialization", "pool", config.Name)
+	output, err := provider.InitializePool(ctx, zpoolPath, config.Name)
+	if err != nil {
+		return newZpoolCommandError("initialize", err, output)
+	}
+
+	for {
+		done, err := logInitializeProgress(ctx, provider, zpoolPath, config.Name)
+		if err != nil {
+			slog.Warn("Failed to get initialization progress", "pool", config.Name, "error", err)
+		}
+		if done || !config.InitializeWait {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(initializePollInterval):
+		}
+		activeWatchdog.progressed()
+	}
+}
+
+// logInitializeProgress logs the initialization progress of each device of the pool and
+// reports whether all devices completed.
+func logInitializeProgress(ctx context.Context, provider zfsProvider, zpoolPath, name string) (bool, error) {
+	output, err := provider.GetInitializeStatus(ctx, zpoolPath, name)
+	if err != nil {
+		return false, fmt.Errorf("%w. Output: %s", err, string(output))
+	}
+
+	progress := parseInitializeProgress(string(output))
+	done := len(progress) > 0
+	for _, p := range progress {
+		slog.Info("Pool initialization progress", "pool", name, "device", p.Device, "percent", p.Percent, "state", p.State)
+		if p.State != "completed" {
+			done = false
+		}
+	}
+	if done {
+		slog.Info("Pool initialization completed", "pool", name)
+	}
+	return done, nil
+}
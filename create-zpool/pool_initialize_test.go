@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseInitializeProgress(t *testing.T) {
+	output := `  pool: tank
+ state: ONLINE
+config:
+
+	NAME        STATE     READ WRITE CKSUM
+	tank        ONLINE       0     0     0
+	  mirror-0  ONLINE       0     0     0
+	    sda     ONLINE       0     0     0  (42% initialized, started at Mon Oct 12 10:00:00 2026)
+	    sdb     ONLINE       0     0     0  (100% initialized, completed at Mon Oct 12 10:30:00 2026)
+	    sdc     ONLINE       0     0     0  (uninitialized)
+`
+	progress := parseInitializeProgress(output)
+	if len(progress) != 2 {
+		t.Fatalf("Expected 2 devices with progress, got %+v", progress)
+	}
+	if progress[0] != (initializeProgress{Device: "sda", Percent: "42", State: "started"}) {
+		t.Errorf("sda progress is incorrect: got %+v", progress[0])
+	}
+	if progress[1] != (initializeProgress{Device: "sdb", Percent: "100", State: "completed"}) {
+		t.Errorf("sdb progress is incorrect: got %+v", progress[1])
+	}
+}
+
+func TestCreatePool_Initialize(t *testing.T) {
+	oldInterval := initializePollInterval
+	initializePollInterval = time.Millisecond
+	t.Cleanup(func() {
+		initializePollInterval = oldInterval
+	})
+
+	var started []string
+	polls := 0
+	mockProvider := &mockZFSProvider{
+		InitializePoolFunc: func(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+			started = append(started, name)
+			return nil, nil
+		},
+		GetInitializeStatusFunc: func(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+			polls++
+			if polls < 3 {
+				return fmt.Appendf(nil, "\t    sda     ONLINE  0 0 0  (%d%% initialized, started at now)\n", polls*30), nil
+			}
+			return []byte("\t    sda     ONLINE  0 0 0  (100% initialized, completed at now)\n"), nil
+		},
+	}
+
+	config := poolConfig{Name: "tank", Disks: []diskSpec{{Dev: "/dev/sda"}}, Ashift: "12", Initialize: true, InitializeWait: true}
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newRunState(nil)); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	if len(started) != 1 || started[0] != "tank" {
+		t.Errorf("Expected initialization to be started once for tank, got %v", started)
+	}
+	if polls != 3 {
+		t.Errorf("Expected progress to be polled until completion (3 polls), got %d", polls)
+	}
+
+	// Without waiting, progress is only reported once.
+	polls = 0
+	config.InitializeWait = false
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newRunState(nil)); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	if polls != 1 {
+		t.Errorf("Expected a single progress report without waiting, got %d", polls)
+	}
+}
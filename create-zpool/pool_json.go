@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// poolJSONPattern matches the variables holding the whole configuration of a pool as JSON.
+var poolJSONPattern = regexp.MustCompile(`^ZPOOL_CONFIG_([0-9]+)$`)
+
+// poolJSONFields maps the scalar fields of a ZPOOL_CONFIG_<n> object to the suffix of the
+// ZPOOL_<n>_<SUFFIX> variable they stand for.
+var poolJSONFields = map[string]string{
+	"name":                 "NAME",
+	"enabled":              "ENABLED",
+	"priority":             "PRIORITY",
+	"type":                 "TYPE",
+	"ashift":               "ASHIFT",
+	"mountpoint":           "MOUNTPOINT",
+	"canmount":             "CANMOUNT",
+	"mount_uid":            "MOUNT_UID",
+	"mount_gid":            "MOUNT_GID",
+	"mount_mode":           "MOUNT_MODE",
+	"dedup":                "DEDUP",
+	"dedup_ack":            "DEDUP_ACK",
+	"special_small_blocks": "SPECIAL_SMALL_BLOCKS",
+	"recordsize":           "RECORDSIZE",
+	"upgrade":              "UPGRADE",
+	"import":               "IMPORT",
+	"adopt_mountpoint":     "ADOPT_MOUNTPOINT",
+	"staged":               "STAGED",
+	"swap_size":            "SWAP_SIZE",
+	"initialize":           "INITIALIZE",
+	"initialize_wait":      "INITIALIZE_WAIT",
+	"erase":                "ERASE",
+	"trim":                 "TRIM",
+	"strict":               "STRICT",
+	"mixed_vdevs":          "MIXED_VDEVS",
+	"strict_disks":         "STRICT_DISKS", // Alias of strict.
+	"wait_for_disks":       "WAIT_FOR_DISKS",
+	"create_timeout":       "CREATE_TIMEOUT",
+	"import_timeout":       "IMPORT_TIMEOUT",
+}
+
+// poolJSONVolumeFields maps the scalar fields of the zvols of a ZPOOL_CONFIG_<n> object to the
+// suffix of their ZPOOL_<n>_ZVOL_<m>_<SUFFIX> variable.
+var poolJSONVolumeFields = map[string]string{"name": "NAME", "size": "SIZE", "preset": "PRESET"}
+
+// poolJSONErrors are the errors found in the ZPOOL_CONFIG_<n> variables by index, set at startup
+// from poolJSONSources. parsePoolConfigs fails the affected pools with them.
+var poolJSONErrors map[int][]error
+
+// poolJSONSources returns a configuration source for every ZPOOL_CONFIG_<n> variable, with the
+// per-field variables its object stands for, and the errors found in them by index.
+func poolJSONSources() ([]configSource, map[int][]error) {
+	var sources []configSource
+	errs := make(map[int][]error)
+	for _, kv := range os.Environ() {
+		key, value, _ := strings.Cut(kv, "=")
+		m := poolJSONPattern.FindStringSubmatch(key)
+		if m == nil {
+			continue
+		}
+		n, err := strconv.Atoi(m[1])
+		if err != nil || n >= maxPoolLimit {
+			continue
+		}
+		vars, err := flattenPoolJSON(n, value)
+		if err != nil {
+			errs[n] = append(errs[n], fmt.Errorf("invalid %s: %w", key, err))
+			continue
+		}
+		sources = append(sources, configSource{Name: key, Precedence: precedencePoolJSON, Vars: vars})
+	}
+	slices.SortFunc(sources, func(a, b configSource) int { return strings.Compare(a.Name, b.Name) })
+	return sources, errs
+}
+
+// flattenPoolJSON translates the JSON object of ZPOOL_CONFIG_<n> into the ZPOOL_<n>_* variables
+// it stands for. Unknown fields are an error, so that a typo does not go unnoticed.
+func flattenPoolJSON(n int, value string) (map[string]string, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(value), &obj); err != nil {
+		return nil, fmt.Errorf("must be a JSON object: %w", err)
+	}
+	prefix := fmt.Sprintf("ZPOOL_%d_", n)
+	vars := make(map[string]string)
+	for _, field := range slices.Sorted(maps.Keys(obj)) {
+		raw := obj[field]
+		var err error
+		switch field {
+		case "disks":
+			// A JSON array is passed on as such, ZPOOL_<n>_DISKS decodes it.
+			if s, serr := jsonScalar(raw); serr == nil {
+				vars[prefix+"DISKS"] = s
+			} else {
+				var disks []json.RawMessage
+				if err = json.Unmarshal(raw, &disks); err == nil {
+					vars[prefix+"DISKS"] = string(bytes.TrimSpace(raw))
+				}
+			}
+		case "after", "reconcile":
+			var list []string
+			if err = json.Unmarshal(raw, &list); err == nil {
+				vars[prefix+strings.ToUpper(field)] = strings.Join(list, ",")
+			}
+		case "sizes":
+			var sizes []string
+			if err = json.Unmarshal(raw, &sizes); err == nil {
+				for p, size := range sizes {
+					vars[fmt.Sprintf("%sSIZE_%d", prefix, p)] = size
+				}
+			}
+		case "properties":
+			err = flattenPoolJSONProperties(prefix, raw, vars)
+		case "zvols":
+			err = flattenPoolJSONVolumes(prefix, raw, vars)
+		case "vdevs":
+			err = flattenPoolJSONVdevs(prefix, raw, vars)
+		case "draid":
+			err = flattenPoolJSONDraid(prefix, raw, vars)
+		case "cache":
+			var vdev map[string]json.RawMessage
+			if err = json.Unmarshal(raw, &vdev); err == nil {
+				err = flattenPoolJSONCache(prefix, vdev, vars)
+			}
+		case "log", "special":
+			var vdev map[string]json.RawMessage
+			if err = json.Unmarshal(raw, &vdev); err == nil {
+				err = flattenPoolJSONVdev(prefix+strings.ToUpper(field)+"_", vdev, vars)
+			}
+		default:
+			suffix, ok := poolJSONFields[field]
+			if !ok {
+				return nil, fmt.Errorf("unknown field %q", field)
+			}
+			vars[prefix+suffix], err = jsonScalar(raw)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", field, err)
+		}
+	}
+	if vars[prefix+"NAME"] == "" {
+		return nil, fmt.Errorf("field %q is required", "name")
+	}
+	return vars, nil
+}
+
+// flattenPoolJSONProperties translates the "properties" object of a pool into the variables
+// of the managed pool properties and ZPOOL_<n>_PROPERTIES for all others.
+func flattenPoolJSONProperties(prefix string, raw json.RawMessage, vars map[string]string) error {
+	var props map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &props); err != nil {
+		return err
+	}
+	var pairs []string
+	for _, name := range slices.Sorted(maps.Keys(props)) {
+		s, err := jsonScalar(props[name])
+		if err != nil {
+			return fmt.Errorf("property %q: %w", name, err)
+		}
+		if prop, ok := managedPoolProperties[name]; ok {
+			vars[prefix+prop.envSuffix] = s
+			continue
+		}
+		if strings.Contains(s, ",") {
+			return fmt.Errorf("property %q: the value %q cannot contain a comma", name, s)
+		}
+		pairs = append(pairs, name+"="+s)
+	}
+	if len(pairs) > 0 {
+		vars[prefix+"PROPERTIES"] = strings.Join(pairs, ",")
+	}
+	return nil
+}
+
+// flattenPoolJSONVolumes translates the "zvols" array of a pool into ZPOOL_<n>_ZVOL_<m>_*
+// variables. The properties of a zvol are an object of property names and values.
+func flattenPoolJSONVolumes(prefix string, raw json.RawMessage, vars map[string]string) error {
+	var volumes []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &volumes); err != nil {
+		return err
+	}
+	for m, volume := range volumes {
+		volumePrefix := fmt.Sprintf("%sZVOL_%d_", prefix, m)
+		for field, value := range volume {
+			if field == "properties" {
+				var props map[string]json.RawMessage
+				if err := json.Unmarshal(value, &props); err != nil {
+					return fmt.Errorf("zvol %d: properties: %w", m, err)
+				}
+				var pairs []string
+				for _, name := range slices.Sorted(maps.Keys(props)) {
+					s, err := jsonScalar(props[name])
+					if err != nil {
+						return fmt.Errorf("zvol %d: property %q: %w", m, name, err)
+					}
+					pairs = append(pairs, name+"="+s)
+				}
+				vars[volumePrefix+"PROPERTIES"] = strings.Join(pairs, ",")
+				continue
+			}
+			suffix, ok := poolJSONVolumeFields[field]
+			if !ok {
+				return fmt.Errorf("zvol %d: unknown field %q", m, field)
+			}
+			s, err := jsonScalar(value)
+			if err != nil {
+				return fmt.Errorf("zvol %d: field %q: %w", m, field, err)
+			}
+			vars[volumePrefix+suffix] = s
+		}
+	}
+	return nil
+}
+
+// flattenPoolJSONVdevs translates the "vdevs" array of a pool into ZPOOL_<n>_VDEV_<m>_*
+// variables. The disks of a vdev take the same forms as those of the pool, and a data vdev may
+// have a "draid" layout like the pool.
+func flattenPoolJSONVdevs(prefix string, raw json.RawMessage, vars map[string]string) error {
+	var vdevs []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &vdevs); err != nil {
+		return err
+	}
+	for m, vdev := range vdevs {
+		vdevPrefix := fmt.Sprintf("%sVDEV_%d_", prefix, m)
+		if layout, ok := vdev["draid"]; ok {
+			if err := flattenPoolJSONDraid(vdevPrefix, layout, vars); err != nil {
+				return fmt.Errorf("vdev %d: %w", m, err)
+			}
+			delete(vdev, "draid")
+		}
+		if err := flattenPoolJSONVdev(vdevPrefix, vdev, vars); err != nil {
+			return fmt.Errorf("vdev %d: %w", m, err)
+		}
+	}
+	return nil
+}
+
+// flattenPoolJSONCache translates the "cache" object of a pool into ZPOOL_<n>_CACHE_* variables.
+// Besides the fields of a vdev, it has the L2ARC module parameters as an object of parameter
+// names and values.
+func flattenPoolJSONCache(prefix string, vdev map[string]json.RawMessage, vars map[string]string) error {
+	if raw, ok := vdev["params"]; ok {
+		var params map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return fmt.Errorf("field %q: %w", "params", err)
+		}
+		var pairs []string
+		for _, name := range slices.Sorted(maps.Keys(params)) {
+			s, err := jsonScalar(params[name])
+			if err != nil {
+				return fmt.Errorf("parameter %q: %w", name, err)
+			}
+			pairs = append(pairs, name+"="+s)
+		}
+		vars[prefix+"CACHE_PARAMS"] = strings.Join(pairs, ",")
+		delete(vdev, "params")
+	}
+	return flattenPoolJSONVdev(prefix+"CACHE_", vdev, vars)
+}
+
+// flattenPoolJSONVdev translates a vdev object with a type, disks and an ashift into the
+// <prefix>TYPE, <prefix>DISKS and <prefix>ASHIFT variables, e.g. the "log" object of a pool into
+// ZPOOL_<n>_LOG_*.
+func flattenPoolJSONVdev(prefix string, vdev map[string]json.RawMessage, vars map[string]string) error {
+	for field, value := range vdev {
+		switch field {
+		case "type":
+			s, err := jsonScalar(value)
+			if err != nil {
+				return fmt.Errorf("field %q: %w", field, err)
+			}
+			vars[prefix+"TYPE"] = s
+		case "ashift":
+			s, err := jsonScalar(value)
+			if err != nil {
+				return fmt.Errorf("field %q: %w", field, err)
+			}
+			vars[prefix+"ASHIFT"] = s
+		case "disks":
+			if s, err := jsonScalar(value); err == nil {
+				vars[prefix+"DISKS"] = s
+				continue
+			}
+			var disks []json.RawMessage
+			if err := json.Unmarshal(value, &disks); err != nil {
+				return fmt.Errorf("field %q: %w", field, err)
+			}
+			vars[prefix+"DISKS"] = string(bytes.TrimSpace(value))
+		default:
+			return fmt.Errorf("unknown field %q", field)
+		}
+	}
+	if vars[prefix+"DISKS"] == "" {
+		return fmt.Errorf("field %q is required", "disks")
+	}
+	return nil
+}
+
+// poolJSONDraidFields maps the fields of a "draid" object to the suffix of the variable they
+// stand for, see draidSettings.
+var poolJSONDraidFields = map[string]string{
+	"data":     "DRAID_DATA",
+	"children": "DRAID_CHILDREN",
+	"spares":   "DRAID_SPARES",
+}
+
+// flattenPoolJSONDraid translates the "draid" object of a pool or vdev, e.g. {"data": 4,
+// "spares": 1}, into <prefix>DRAID_* variables.
+func flattenPoolJSONDraid(prefix string, raw json.RawMessage, vars map[string]string) error {
+	var layout map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &layout); err != nil {
+		return fmt.Errorf("field %q: %w", "draid", err)
+	}
+	for field, value := range layout {
+		suffix, ok := poolJSONDraidFields[field]
+		if !ok {
+			return fmt.Errorf("draid: unknown field %q", field)
+		}
+		s, err := jsonScalar(value)
+		if err != nil {
+			return fmt.Errorf("draid: field %q: %w", field, err)
+		}
+		vars[prefix+suffix] = s
+	}
+	return nil
+}
+
+// jsonScalar returns a JSON string, number or boolean as the string a variable would hold.
+func jsonScalar(raw json.RawMessage) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return "", err
+	}
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return "", fmt.Errorf("must be a string, number or boolean, got %s", bytes.TrimSpace(raw))
+}
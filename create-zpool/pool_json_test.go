@@ -0,0 +1,119 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFlattenPoolJSON(t *testing.T) {
+	blob := `{
+		"name": "tank",
+		"type": "mirror",
+		"ashift": 13,
+		"trim": true,
+		"disks": ["/dev/sda", {"model": "Dell*"}],
+		"after": ["fast", "boot"],
+		"sizes": [">=1T"],
+		"properties": {"autotrim": "on", "listsnapshots": "on", "autoexpand": "on"},
+		"zvols": [{"name": "vm/disk0", "size": "20G", "properties": {"volblocksize": "16K", "compression": "lz4"}}],
+		"vdevs": [{"type": "mirror", "disks": "/dev/sdc /dev/sdd"}, {"disks": [{"model": "Intel*"}]}, {"type": "draid1", "draid": {"spares": 1}, "disks": "/dev/sde /dev/sdf /dev/sdg"}],
+		"log": {"type": "mirror", "disks": ["/dev/nvme0n1", "/dev/nvme1n1"], "ashift": 12},
+		"special": {"type": "mirror", "disks": "/dev/nvme2n1 /dev/nvme3n1"},
+		"cache": {"disks": "/dev/nvme4n1", "params": {"l2arc_write_max": 67108864, "l2arc_rebuild_enabled": 1}}
+	}`
+	got, err := flattenPoolJSON(2, blob)
+	if err != nil {
+		t.Fatalf("flattenPoolJSON() returned an unexpected error: %v", err)
+	}
+	want := map[string]string{
+		"ZPOOL_2_NAME":                "tank",
+		"ZPOOL_2_TYPE":                "mirror",
+		"ZPOOL_2_ASHIFT":              "13",
+		"ZPOOL_2_TRIM":                "true",
+		"ZPOOL_2_DISKS":               `["/dev/sda", {"model": "Dell*"}]`,
+		"ZPOOL_2_AFTER":               "fast,boot",
+		"ZPOOL_2_SIZE_0":              ">=1T",
+		"ZPOOL_2_AUTOTRIM":            "on",
+		"ZPOOL_2_PROPERTIES":          "autoexpand=on,listsnapshots=on",
+		"ZPOOL_2_ZVOL_0_NAME":         "vm/disk0",
+		"ZPOOL_2_ZVOL_0_SIZE":         "20G",
+		"ZPOOL_2_ZVOL_0_PROPERTIES":   "compression=lz4,volblocksize=16K",
+		"ZPOOL_2_VDEV_0_TYPE":         "mirror",
+		"ZPOOL_2_VDEV_0_DISKS":        "/dev/sdc /dev/sdd",
+		"ZPOOL_2_VDEV_1_DISKS":        `[{"model": "Intel*"}]`,
+		"ZPOOL_2_VDEV_2_TYPE":         "draid1",
+		"ZPOOL_2_VDEV_2_DRAID_SPARES": "1",
+		"ZPOOL_2_VDEV_2_DISKS":        "/dev/sde /dev/sdf /dev/sdg",
+		"ZPOOL_2_LOG_TYPE":            "mirror",
+		"ZPOOL_2_LOG_ASHIFT":          "12",
+		"ZPOOL_2_LOG_DISKS":           `["/dev/nvme0n1", "/dev/nvme1n1"]`,
+		"ZPOOL_2_SPECIAL_TYPE":        "mirror",
+		"ZPOOL_2_SPECIAL_DISKS":       "/dev/nvme2n1 /dev/nvme3n1",
+		"ZPOOL_2_CACHE_DISKS":         "/dev/nvme4n1",
+		"ZPOOL_2_CACHE_PARAMS":        "l2arc_rebuild_enabled=1,l2arc_write_max=67108864",
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("flattenPoolJSON() = %v, want %v", got, want)
+	}
+}
+
+func TestFlattenPoolJSON_Errors(t *testing.T) {
+	testCases := map[string]string{
+		"not an object":       `["tank"]`,
+		"malformed":           `{"name": "tank"`,
+		"missing name":        `{"type": "mirror"}`,
+		"unknown field":       `{"name": "tank", "tpye": "mirror"}`,
+		"unsupported field":   `{"name": "tank", "datasets": [{"name": "data"}]}`,
+		"property with comma": `{"name": "tank", "properties": {"org.example:racks": "1,2"}}`,
+		"object as scalar":    `{"name": {"value": "tank"}}`,
+		"unknown zvol field":  `{"name": "tank", "zvols": [{"name": "a", "size": "1G", "sparse": true}]}`,
+		"unknown vdev field":  `{"name": "tank", "vdevs": [{"type": "mirror", "disks": ["/dev/sda"], "recordsize": "1M"}]}`,
+		"vdev without disks":  `{"name": "tank", "vdevs": [{"type": "mirror"}]}`,
+		"log as array":        `{"name": "tank", "log": ["/dev/nvme0n1"]}`,
+		"unknown draid field": `{"name": "tank", "type": "draid2", "draid": {"parity": 2}}`,
+		"draid in log":        `{"name": "tank", "log": {"draid": {"spares": 1}, "disks": ["/dev/nvme0n1"]}}`,
+		"params in log":       `{"name": "tank", "log": {"disks": ["/dev/nvme0n1"], "params": {"l2arc_noprefetch": 0}}}`,
+		"cache params array":  `{"name": "tank", "cache": {"disks": ["/dev/nvme0n1"], "params": ["l2arc_noprefetch=0"]}}`,
+	}
+	for name, blob := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if vars, err := flattenPoolJSON(0, blob); err == nil {
+				t.Errorf("flattenPoolJSON(%s) expected an error, got %v", blob, vars)
+			}
+		})
+	}
+}
+
+func TestParsePoolConfigs_PoolJSON(t *testing.T) {
+	for _, key := range []string{"ZPOOL_0_NAME", "ZPOOL_0_TYPE", "ZPOOL_0_DISKS"} {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+	t.Setenv("ZPOOL_CONFIG_0", `{"name": "tank", "type": "raidz", "disks": "/dev/sda /dev/sdb /dev/sdc"}`)
+	t.Setenv("ZPOOL_CONFIG_1", `{"name": "fast", "trim": "maybe"`)
+	// A per-field variable overrides the field of the JSON object.
+	t.Setenv("ZPOOL_0_TYPE", "raidz2")
+	t.Cleanup(func() { poolJSONErrors = nil })
+
+	sources, errs := poolJSONSources()
+	poolJSONErrors = errs
+	mergeConfigSources(append(sources, envConfigSource())...).apply()
+
+	configs := parsePoolConfigs()
+	var names []string
+	for _, c := range configs {
+		names = append(names, c.Name)
+	}
+	if !slices.Equal(names, []string{"tank", "ZPOOL_CONFIG_1"}) {
+		t.Fatalf("Expected tank and the invalid ZPOOL_CONFIG_1, got %v", names)
+	}
+	if configs[0].Type != "raidz2" || configs[0].DiskList != "/dev/sda /dev/sdb /dev/sdc" {
+		t.Errorf("Unexpected configuration %+v", configs[0])
+	}
+	if len(configs[1].ParseErrors) != 1 || !strings.Contains(configs[1].ParseErrors[0].Error(), "invalid ZPOOL_CONFIG_1") {
+		t.Errorf("Expected the invalid JSON to fail the pool, got %v", configs[1].ParseErrors)
+	}
+}
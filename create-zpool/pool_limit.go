@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// poolNamePattern matches the variables naming a pool, ZPOOL_<n>_NAME.
+var poolNamePattern = regexp.MustCompile(`^ZPOOL_([0-9]+)_NAME$`)
+
+// poolLimit returns the number of pool indices that are read, see ZPOOL_MAX_POOLS. An invalid
+// value returns defaultMaxPools with the error.
+func poolLimit() (int, error) {
+	return parsePoolLimit(os.Getenv("ZPOOL_MAX_POOLS"))
+}
+
+// parsePoolLimit parses a value of ZPOOL_MAX_POOLS; empty is defaultMaxPools.
+func parsePoolLimit(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return defaultMaxPools, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > maxPoolLimit {
+		return defaultMaxPools, fmt.Errorf("invalid ZPOOL_MAX_POOLS %q, must be an integer from 1 to %d", value, maxPoolLimit)
+	}
+	return n, nil
+}
+
+// configuredPoolIndices returns the indices of all pools that are configured, in ascending
+// order, including those beyond the limit: those with a ZPOOL_<n>_NAME, and those whose
+// ZPOOL_CONFIG_<n> could not be read, see poolJSONErrors.
+func configuredPoolIndices() []int {
+	indices := make(map[int]bool)
+	for _, kv := range os.Environ() {
+		key, value, _ := strings.Cut(kv, "=")
+		if m := poolNamePattern.FindStringSubmatch(key); m != nil && value != "" {
+			if n, err := strconv.Atoi(m[1]); err == nil {
+				indices[n] = true
+			}
+		}
+	}
+	for n, errs := range poolJSONErrors {
+		if len(errs) > 0 {
+			indices[n] = true
+		}
+	}
+	return slices.Sorted(maps.Keys(indices))
+}
+
+// poolIndicesFrom returns the indices of configured from first up to, but excluding, last.
+func poolIndicesFrom(configured []int, first, last int) []int {
+	var indices []int
+	for _, n := range configured {
+		if n >= first && n < last {
+			indices = append(indices, n)
+		}
+	}
+	return indices
+}
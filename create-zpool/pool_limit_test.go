@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParsePoolLimit(t *testing.T) {
+	for value, want := range map[string]int{"": defaultMaxPools, " 8 ": 8, "1000": 1000} {
+		if got, err := parsePoolLimit(value); got != want || err != nil {
+			t.Errorf("parsePoolLimit(%q) = %d, %v, want %d", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"0", "-1", "1001", "many"} {
+		if got, err := parsePoolLimit(value); got != defaultMaxPools || err == nil {
+			t.Errorf("parsePoolLimit(%q) = %d, %v, want an error", value, got, err)
+		}
+	}
+}
+
+func TestParsePoolConfigs_MaxPools(t *testing.T) {
+	for i := range 5 {
+		t.Setenv(fmt.Sprintf("ZPOOL_%d_NAME", i), fmt.Sprintf("pool%d", i))
+	}
+	t.Setenv("ZPOOL_MAX_POOLS", "3")
+	if configs := parsePoolConfigs(); len(configs) != 3 {
+		t.Errorf("Expected 3 pools, got %d", len(configs))
+	}
+	t.Setenv("ZPOOL_MAX_POOLS", "50")
+	if configs := parsePoolConfigs(); len(configs) != 5 {
+		t.Errorf("Expected 5 pools, got %d", len(configs))
+	}
+}
+
+func TestParsePoolConfigs_Gaps(t *testing.T) {
+	t.Setenv("ZPOOL_0_NAME", "tank")
+	t.Setenv("ZPOOL_2_NAME", "data")
+	t.Setenv("ZPOOL_5_NAME", "backup")
+	t.Setenv("ZPOOL_6_NAME", "beyond")
+	t.Setenv("ZPOOL_MAX_POOLS", "6")
+
+	names := func() []string {
+		var names []string
+		for _, config := range parsePoolConfigs() {
+			names = append(names, config.Name)
+		}
+		return names
+	}
+	if got := strings.Join(names(), " "); got != "tank" {
+		t.Errorf("Expected parsing to stop at the gap, got %q", got)
+	}
+	t.Setenv("ZPOOL_SKIP_GAPS", "true")
+	if got := strings.Join(names(), " "); got != "tank data backup" {
+		t.Errorf("Expected the gaps to be skipped up to the limit, got %q", got)
+	}
+}
+
+func TestPoolIndicesFrom(t *testing.T) {
+	t.Setenv("ZPOOL_3_NAME", "tank")
+	t.Setenv("ZPOOL_12_NAME", "data")
+	t.Setenv("ZPOOL_7_NAME", "")
+	configured := configuredPoolIndices()
+	if got := fmt.Sprint(poolIndicesFrom(configured, 1, 13)); got != "[3 12]" {
+		t.Errorf("Unexpected indices %s of %v", got, configured)
+	}
+	if got := poolIndicesFrom(configured, 4, 12); len(got) != 0 {
+		t.Errorf("Expected no indices, got %v", got)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// parsePoolOrder reads ZPOOL_<n>_PRIORITY and ZPOOL_<n>_AFTER of pool n.
+func parsePoolOrder(n int) (priority int, after []string, err error) {
+	key := fmt.Sprintf("ZPOOL_%d_PRIORITY", n)
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		if priority, err = strconv.Atoi(value); err != nil {
+			err = fmt.Errorf("invalid integer %q for %s", value, key)
+		}
+	}
+	after = strings.FieldsFunc(os.Getenv(fmt.Sprintf("ZPOOL_%d_AFTER", n)), func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+	return priority, after, err
+}
+
+// orderPoolConfigs returns configs in the order they are processed: every pool comes after the
+// pools listed in its After, and otherwise pools with a higher priority come first, pools of
+// equal priority in configuration order. Unknown pools in After and dependency cycles are added
+// to the ParseErrors of the affected pools, which then keep their place by priority.
+func orderPoolConfigs(configs []poolConfig) []poolConfig {
+	remaining := make(map[string]int) // Configurations of each name that are not placed yet.
+	for _, config := range configs {
+		remaining[config.Name]++
+	}
+	for i := range configs {
+		for _, dep := range configs[i].After {
+			if remaining[dep] == 0 {
+				configs[i].ParseErrors = append(configs[i].ParseErrors, fmt.Errorf("unknown pool %q in ZPOOL_<n>_AFTER", dep))
+			}
+		}
+	}
+
+	ready := func(config poolConfig) bool {
+		for _, dep := range config.After {
+			if remaining[dep] > 0 {
+				return false
+			}
+		}
+		return true
+	}
+	placed := make([]bool, len(configs))
+	ordered := make([]poolConfig, 0, len(configs))
+	for len(ordered) < len(configs) {
+		next := -1
+		for i, config := range configs {
+			if !placed[i] && ready(config) && (next < 0 || config.Priority > configs[next].Priority) {
+				next = i
+			}
+		}
+		if next < 0 {
+			// Only pools in or behind a dependency cycle are left. They fail with an error, but are
+			// still ordered by priority so that the run reports each of them.
+			for i := range configs {
+				if !placed[i] {
+					configs[i].ParseErrors = append(configs[i].ParseErrors, errors.New("pool is part of or depends on a dependency cycle in ZPOOL_<n>_AFTER"))
+					configs[i].After = nil
+				}
+			}
+			continue
+		}
+		placed[next] = true
+		remaining[configs[next].Name]--
+		ordered = append(ordered, configs[next])
+	}
+	return ordered
+}
+
+// failedDependency returns the first pool in the After of config that failed in this run, or ""
+// if none did. Dependencies that were disabled or not processed at all do not count.
+func failedDependency(config poolConfig, failed map[string]bool) string {
+	for _, dep := range config.After {
+		if failed[dep] {
+			return dep
+		}
+	}
+	return ""
+}
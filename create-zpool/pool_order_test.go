@@ -0,0 +1,102 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestOrderPoolConfigs(t *testing.T) {
+	tests := []struct {
+		name       string
+		configs    []poolConfig
+		want       []string
+		wantErrors []string // Pools that get a parse error.
+	}{
+		{
+			name:    "configuration order by default",
+			configs: []poolConfig{{Name: "a"}, {Name: "b"}, {Name: "c"}},
+			want:    []string{"a", "b", "c"},
+		},
+		{
+			name:    "higher priority first",
+			configs: []poolConfig{{Name: "a"}, {Name: "b", Priority: -1}, {Name: "c", Priority: 10}, {Name: "d", Priority: 10}},
+			want:    []string{"c", "d", "a", "b"},
+		},
+		{
+			name:    "dependencies before priority",
+			configs: []poolConfig{{Name: "data", Priority: 10, After: []string{"csi"}}, {Name: "scratch", Priority: 5}, {Name: "csi"}},
+			want:    []string{"scratch", "csi", "data"},
+		},
+		{
+			name:       "unknown dependency",
+			configs:    []poolConfig{{Name: "a", After: []string{"missing"}}, {Name: "b"}},
+			want:       []string{"a", "b"},
+			wantErrors: []string{"a"},
+		},
+		{
+			name:       "cycle",
+			configs:    []poolConfig{{Name: "a", After: []string{"b"}}, {Name: "b", After: []string{"a"}}, {Name: "c", After: []string{"a"}}, {Name: "d"}},
+			want:       []string{"d", "a", "b", "c"},
+			wantErrors: []string{"a", "b", "c"},
+		},
+		{
+			name:       "self",
+			configs:    []poolConfig{{Name: "a", After: []string{"a"}}},
+			want:       []string{"a"},
+			wantErrors: []string{"a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got, gotErrors []string
+			for _, config := range orderPoolConfigs(tt.configs) {
+				got = append(got, config.Name)
+				if len(config.ParseErrors) > 0 {
+					gotErrors = append(gotErrors, config.Name)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("orderPoolConfigs() = %v, want %v", got, tt.want)
+			}
+			if !slices.Equal(gotErrors, tt.wantErrors) {
+				t.Errorf("Pools with parse errors = %v, want %v", gotErrors, tt.wantErrors)
+			}
+		})
+	}
+}
+
+func TestParsePoolConfigs_Order(t *testing.T) {
+	t.Setenv("ZPOOL_0_NAME", "data")
+	t.Setenv("ZPOOL_0_AFTER", "csi, scratch")
+	t.Setenv("ZPOOL_1_NAME", "scratch")
+	t.Setenv("ZPOOL_2_NAME", "csi")
+	t.Setenv("ZPOOL_2_PRIORITY", "10")
+	t.Setenv("ZPOOL_3_NAME", "bad")
+	t.Setenv("ZPOOL_3_PRIORITY", "high")
+
+	var names []string
+	configs := parsePoolConfigs()
+	for _, config := range configs {
+		names = append(names, config.Name)
+	}
+	if want := []string{"csi", "scratch", "data", "bad"}; !slices.Equal(names, want) {
+		t.Errorf("parsePoolConfigs() order = %v, want %v", names, want)
+	}
+	if !slices.Equal(configs[2].After, []string{"csi", "scratch"}) {
+		t.Errorf("After = %v, want [csi scratch]", configs[2].After)
+	}
+	if len(configs[3].ParseErrors) != 1 || !strings.Contains(configs[3].ParseErrors[0].Error(), "ZPOOL_3_PRIORITY") {
+		t.Errorf("Expected an error for the invalid priority, got %v", configs[3].ParseErrors)
+	}
+}
+
+func TestFailedDependency(t *testing.T) {
+	config := poolConfig{Name: "data", After: []string{"csi", "scratch"}}
+	if dep := failedDependency(config, map[string]bool{"other": true}); dep != "" {
+		t.Errorf("failedDependency() = %q, want none", dep)
+	}
+	if dep := failedDependency(config, map[string]bool{"scratch": true}); dep != "scratch" {
+		t.Errorf("failedDependency() = %q, want scratch", dep)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// managedPoolProperties maps the pool properties that can be configured per pool to the
+// suffix of their ZPOOL_<n>_<SUFFIX> environment variable and a validation function.
+var managedPoolProperties = map[string]struct {
+	envSuffix string
+	valid     func(string) bool
+}{
+	"autotrim":      {"AUTOTRIM", oneOf("on", "off")},
+	"failmode":      {"FAILMODE", oneOf("wait", "continue", "panic")},
+	"comment":       {"COMMENT", isValidPoolComment},
+	"compatibility": {"COMPATIBILITY", isValidCompatibility},
+}
+
+// oneOf returns a validation function accepting only the given values.
+func oneOf(values ...string) func(string) bool {
+	return func(v string) bool {
+		return slices.Contains(values, v)
+	}
+}
+
+// isValidPoolComment checks the limits zpool(8) places on the comment property:
+// at most 32 printable ASCII characters.
+func isValidPoolComment(comment string) bool {
+	if len(comment) > 32 {
+		return false
+	}
+	for _, r := range comment {
+		if r > unicode.MaxASCII || !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+var compatibilityPattern = regexp.MustCompile(`^[a-zA-Z0-9_.+/-]+(,[a-zA-Z0-9_.+/-]+)*$`)
+
+// isValidCompatibility checks if the value is "off", "legacy" or a comma separated list of
+// compatibility feature set file names.
+func isValidCompatibility(compat string) bool {
+	return compatibilityPattern.MatchString(compat)
+}
+
+// poolPropertyPattern matches the names of pool properties, including features, e.g.
+// feature@async_destroy, and user properties, e.g. org.example:owner.
+var poolPropertyPattern = regexp.MustCompile(`^[a-z][a-z0-9_.:-]*(@[a-z0-9_]+)?$`)
+
+// reservedPoolProperties are the pool properties that cannot be set with ZPOOL_<n>_PROPERTIES,
+// with the reason.
+var reservedPoolProperties = map[string]string{
+	"ashift":   "configure it with ZPOOL_<n>_ASHIFT",
+	"altroot":  "it is set for staged pools, see ZPOOL_<n>_STAGED",
+	"readonly": "it only applies to imports",
+}
+
+// parsePoolProperties reads the pool properties and the ZPOOL_<n>_RECONCILE list for pool index
+// i. The ZPOOL_PROPERTIES defaults are overridden by ZPOOL_<i>_PROPERTIES, and those by the
+// variables of the managed properties, e.g. ZPOOL_<i>_AUTOTRIM. Properties that are not set are
+// omitted from the returned map.
+func parsePoolProperties(i int) (props map[string]string, reconcile []string, errs []error) {
+	props = make(map[string]string)
+	for _, key := range []string{"ZPOOL_PROPERTIES", fmt.Sprintf("ZPOOL_%d_PROPERTIES", i)} {
+		errs = append(errs, parsePoolPropertyList(key, props)...)
+	}
+	for _, name := range slices.Sorted(maps.Keys(managedPoolProperties)) {
+		if value, ok := lookupEnvTrimmed(fmt.Sprintf("ZPOOL_%d_%s", i, managedPoolProperties[name].envSuffix)); ok {
+			props[name] = value
+		}
+	}
+	for item := range strings.SplitSeq(getEnv(fmt.Sprintf("ZPOOL_%d_RECONCILE", i), ""), ",") {
+		if item = strings.TrimSpace(strings.ToLower(item)); item != "" {
+			reconcile = append(reconcile, item)
+		}
+	}
+	return props, reconcile, errs
+}
+
+// parsePoolPropertyList adds the comma separated property=value pairs of variable key to props.
+func parsePoolPropertyList(key string, props map[string]string) []error {
+	var errs []error
+	for item := range strings.SplitSeq(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, "=")
+		name, value = strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(value)
+		if !ok || value == "" || name == "" {
+			errs = append(errs, fmt.Errorf("invalid %s entry %q, must be property=value", key, item))
+			continue
+		}
+		props[name] = value
+	}
+	return errs
+}
+
+// validatePoolProperties checks all configured property values and that every property
+// marked for reconciliation has a configured value. Only the values of managed properties are
+// known, zpool checks those of the others.
+func validatePoolProperties(props map[string]string, reconcile []string) error {
+	for _, name := range slices.Sorted(maps.Keys(props)) {
+		if reason, ok := reservedPoolProperties[name]; ok {
+			return fmt.Errorf("pool property %q cannot be configured as a property, %s", name, reason)
+		}
+		if !poolPropertyPattern.MatchString(name) {
+			return fmt.Errorf("invalid pool property name %q, run `create-zpool capabilities` for the supported ones", name)
+		}
+		if prop, ok := managedPoolProperties[name]; ok && !prop.valid(props[name]) {
+			return fmt.Errorf("invalid value %q for pool property %q", props[name], name)
+		}
+	}
+	for _, name := range reconcile {
+		if _, ok := props[name]; !ok {
+			return fmt.Errorf("pool property %q is marked for reconciliation but has no configured value", name)
+		}
+	}
+	return nil
+}
+
+// poolPropertyArgs returns the `-o property=value` arguments for zpool create, sorted by property name.
+func poolPropertyArgs(props map[string]string) []string {
+	var args []string
+	for _, name := range slices.Sorted(maps.Keys(props)) {
+		args = append(args, "-o", name+"="+props[name])
+	}
+	return args
+}
+
+// reconcilePoolProperties compares the properties listed in reconcile against the live values
+// of an existing pool and applies `zpool set` for every difference.
+func reconcilePoolProperties(ctx context.Context, provider zfsProvider, zpoolPath string, config poolConfig) error {
+	if len(config.Reconcile) == 0 {
+		return nil
+	}
+
+	current, err := provider.GetPoolProperties(ctx, zpoolPath, config.Name, config.Reconcile)
+	if err != nil {
+		return fmt.Errorf("failed to read pool properties: %w", err)
+	}
+
+	for _, name := range config.Reconcile {
+		want := config.Properties[name]
+		if current[name] == want {
+			slog.Info("Pool property is up to date", "pool", config.Name, "property", name, "value", want)
+			continue
+		}
+		slog.Info("Updating pool property", "pool", config.Name, "property", name, "from", current[name], "to", want)
+		output, err := provider.SetPoolProperty(ctx, zpoolPath, config.Name, name, want)
+		if err != nil {
+			return fmt.Errorf("zpool set %s failed: %w. Output: %s", name, err, string(output))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestValidatePoolProperties(t *testing.T) {
+	testCases := []struct {
+		name      string
+		props     map[string]string
+		reconcile []string
+		fail      bool
+	}{
+		{"empty", nil, nil, false},
+		{"all valid", map[string]string{"autotrim": "on", "failmode": "continue", "comment": "rack 4, slot 2", "compatibility": "openzfs-2.1-linux,grub2"}, []string{"autotrim", "comment"}, false},
+		{"compatibility off", map[string]string{"compatibility": "off"}, nil, false},
+		{"invalid autotrim", map[string]string{"autotrim": "yes"}, nil, true},
+		{"invalid failmode", map[string]string{"failmode": "retry"}, nil, true},
+		{"comment too long", map[string]string{"comment": strings.Repeat("x", 33)}, nil, true},
+		{"comment not printable", map[string]string{"comment": "tab\there"}, nil, true},
+		{"invalid compatibility", map[string]string{"compatibility": "openzfs 2.1"}, nil, true},
+		{"generic properties", map[string]string{"autoexpand": "on", "feature@async_destroy": "enabled", "org.example:owner": "storage"}, []string{"autoexpand"}, false},
+		{"reserved property", map[string]string{"readonly": "on"}, nil, true},
+		{"ashift as property", map[string]string{"ashift": "12"}, nil, true},
+		{"invalid property name", map[string]string{"Auto Expand": "on"}, nil, true},
+		{"reconcile without value", map[string]string{"autotrim": "on"}, []string{"failmode"}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validatePoolProperties(tc.props, tc.reconcile)
+			if tc.fail && err == nil {
+				t.Error("Expected an error, got nil")
+			}
+			if !tc.fail && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestParsePoolProperties(t *testing.T) {
+	t.Setenv("ZPOOL_3_AUTOTRIM", "on")
+	t.Setenv("ZPOOL_3_COMMENT", " nvme tier ")
+	t.Setenv("ZPOOL_3_RECONCILE", "Autotrim, comment,")
+
+	props, reconcile, errs := parsePoolProperties(3)
+	if len(errs) > 0 {
+		t.Fatalf("parsePoolProperties() errors = %v", errs)
+	}
+	if len(props) != 2 || props["autotrim"] != "on" || props["comment"] != "nvme tier" {
+		t.Errorf("parsePoolProperties() props = %v", props)
+	}
+	if strings.Join(reconcile, ",") != "autotrim,comment" {
+		t.Errorf("parsePoolProperties() reconcile = %v", reconcile)
+	}
+}
+
+func TestParsePoolProperties_Generic(t *testing.T) {
+	t.Setenv("ZPOOL_PROPERTIES", "autoexpand=on, delegation=off")
+	t.Setenv("ZPOOL_3_PROPERTIES", "Delegation=on,listsnapshots=on,autotrim=off")
+	t.Setenv("ZPOOL_3_AUTOTRIM", "on")
+
+	props, _, errs := parsePoolProperties(3)
+	if len(errs) > 0 {
+		t.Fatalf("parsePoolProperties() errors = %v", errs)
+	}
+	want := map[string]string{"autoexpand": "on", "delegation": "on", "listsnapshots": "on", "autotrim": "on"}
+	if !maps.Equal(props, want) {
+		t.Errorf("parsePoolProperties() props = %v; want %v", props, want)
+	}
+
+	t.Setenv("ZPOOL_3_PROPERTIES", "autoexpand")
+	if _, _, errs := parsePoolProperties(3); len(errs) != 1 {
+		t.Errorf("Expected one error for an entry without a value, got %v", errs)
+	}
+}
+
+func TestCreatePool_PropertiesAtCreation(t *testing.T) {
+	var gotArgs string
+	mockProvider := &mockZFSProvider{
+		CreatePoolFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+			gotArgs = strings.Join(args, " ")
+			return nil, nil
+		},
+	}
+	config := poolConfig{
+		Name:       "tank",
+		Disks:      []diskSpec{{Dev: "/dev/sda"}},
+		Ashift:     "12",
+		Properties: map[string]string{"failmode": "continue", "autotrim": "on", "autoexpand": "on"},
+	}
+
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newRunState(nil)); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	want := "create -m /var/mnt/tank -o ashift=12 -o autoexpand=on -o autotrim=on -o failmode=continue tank /dev/sda"
+	if gotArgs != want {
+		t.Errorf("zpool args = %q; want %q", gotArgs, want)
+	}
+}
+
+func TestCreatePool_ReconcileExistingPool(t *testing.T) {
+	var set []string
+	mockProvider := &mockZFSProvider{
+		GetPoolPropertiesFunc: func(ctx context.Context, zpoolPath, name string, props []string) (map[string]string, error) {
+			return map[string]string{"autotrim": "off", "failmode": "continue"}, nil
+		},
+		SetPoolPropertyFunc: func(ctx context.Context, zpoolPath, name, prop, value string) ([]byte, error) {
+			set = append(set, name+":"+prop+"="+value)
+			return nil, nil
+		},
+		CreatePoolFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+			t.Fatalf("CreatePool must not be called for an existing pool, got args %v", args)
+			return nil, nil
+		},
+	}
+	config := poolConfig{
+		Name:       "tank",
+		Ashift:     "12",
+		Properties: map[string]string{"autotrim": "on", "failmode": "continue", "comment": "not reconciled"},
+		Reconcile:  []string{"autotrim", "failmode"},
+	}
+
+	err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newRunState(map[string]string{"tank": "1"}))
+	if err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	if strings.Join(set, " ") != "tank:autotrim=on" {
+		t.Errorf("Expected only autotrim to be updated, got %v", set)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const defaultStatusParallelism = 4 // Maximum number of concurrent per-pool status queries in fallback mode, see ZPOOL_STATUS_PARALLELISM.
+
+// poolStatus is the subset of a pool entry in `zpool status -j` output used by this tool.
+// Numeric fields are kept as strings, since that is how zpool emits them without --json-int.
+type poolStatus struct {
+	Name       string                 `json:"name"`
+	State      string                 `json:"state"`
+	GUID       string                 `json:"pool_guid"`
+	Status     string                 `json:"status"`
+	Action     string                 `json:"action"`
+	ErrorCount string                 `json:"error_count"`
+	Vdevs      map[string]*vdevStatus `json:"vdevs"`
+	Spares     map[string]*vdevStatus `json:"spares"`
+	Logs       map[string]*vdevStatus `json:"logs"`    // Log vdevs, if zpool lists them apart from the vdev tree, see classVdevs.
+	Special    map[string]*vdevStatus `json:"special"` // Special vdevs, likewise.
+	L2Cache    map[string]*vdevStatus `json:"l2cache"` // Cache devices, which zpool always lists apart.
+	Scan       *scanStats             `json:"scan_stats"`
+}
+
+// scanStats describes the last or current scrub or resilver in `zpool status -j` output.
+type scanStats struct {
+	Function string `json:"function"` // "SCRUB" or "RESILVER".
+	State    string `json:"state"`    // "SCANNING", "FINISHED" or "CANCELED".
+	EndTime  string `json:"end_time"`
+	Errors   string `json:"errors"`
+}
+
+// vdevStatus is a node of the vdev tree in `zpool status -j` output.
+type vdevStatus struct {
+	Name           string                 `json:"name"`
+	VdevType       string                 `json:"vdev_type"`
+	Class          string                 `json:"class"`
+	State          string                 `json:"state"`
+	Path           string                 `json:"path"`
+	ReadErrors     string                 `json:"read_errors"`
+	WriteErrors    string                 `json:"write_errors"`
+	ChecksumErrors string                 `json:"checksum_errors"`
+	Vdevs          map[string]*vdevStatus `json:"vdevs"`
+}
+
+// leaves returns all leaf vdevs (disks and files) below v, sorted by name.
+func (v *vdevStatus) leaves() []*vdevStatus {
+	if len(v.Vdevs) == 0 {
+		return []*vdevStatus{v}
+	}
+	var leaves []*vdevStatus
+	for _, name := range slices.Sorted(maps.Keys(v.Vdevs)) {
+		leaves = append(leaves, v.Vdevs[name].leaves()...)
+	}
+	return leaves
+}
+
+// leaves returns all leaf vdevs of the pool, sorted by name within each parent.
+func (s *poolStatus) leaves() []*vdevStatus {
+	var leaves []*vdevStatus
+	for _, name := range slices.Sorted(maps.Keys(s.Vdevs)) {
+		leaves = append(leaves, s.Vdevs[name].leaves()...)
+	}
+	return leaves
+}
+
+// healthy reports whether the pool is ONLINE without any recorded data errors.
+func (s *poolStatus) healthy() bool {
+	errs, _ := strconv.ParseUint(s.ErrorCount, 10, 64)
+	return s.State == "ONLINE" && errs == 0
+}
+
+// parsePoolStatusJSON parses the output of `zpool status -j` into a map of pool name to status.
+func parsePoolStatusJSON(data []byte) (map[string]*poolStatus, error) {
+	var doc struct {
+		Pools map[string]*poolStatus `json:"pools"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode zpool status JSON: %w", err)
+	}
+	if doc.Pools == nil {
+		doc.Pools = make(map[string]*poolStatus)
+	}
+	return doc.Pools, nil
+}
+
+// parsePoolStatusText extracts the pool state from the human readable `zpool status <name>`
+// output. It is only used with zpool versions that do not support JSON output.
+func parsePoolStatusText(name string, output string) *poolStatus {
+	status := &poolStatus{Name: name}
+	for line := range strings.Lines(output) {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "state":
+			status.State = strings.TrimSpace(value)
+		case "status":
+			status.Status = strings.TrimSpace(value)
+		case "action":
+			status.Action = strings.TrimSpace(value)
+		}
+	}
+	return status
+}
+
+// poolStatusCache collects the status of all pools once and shares the result between
+// every consumer for the rest of the run. It prefers a single `zpool status -j` call and
+// falls back to concurrent per-pool `zpool status <name>` queries on older zpool versions.
+type poolStatusCache struct {
+	provider  zfsProvider
+	zpoolPath string
+
+	mu     sync.Mutex
+	loaded bool
+	pools  map[string]*poolStatus
+	err    error
+}
+
+// newPoolStatusCache creates an empty cache using provider and zpoolPath to query pools.
+func newPoolStatusCache(provider zfsProvider, zpoolPath string) *poolStatusCache {
+	return &poolStatusCache{provider: provider, zpoolPath: zpoolPath}
+}
+
+// Invalidate drops the cached result so that the next lookup queries zpool again,
+// e.g. after pools have been created or imported.
+func (c *poolStatusCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loaded = false
+	c.pools = nil
+	c.err = nil
+}
+
+// Status returns the status of the named pools, querying zpool on first use.
+// Pools that do not exist are missing from the returned map.
+func (c *poolStatusCache) Status(ctx context.Context, names []string) (map[string]*poolStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.loaded {
+		c.pools, c.err = c.load(ctx)
+		c.loaded = true
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	result := make(map[string]*poolStatus, len(names))
+	for _, name := range names {
+		if status, ok := c.pools[name]; ok {
+			result[name] = status
+		}
+	}
+	return result, nil
+}
+
+func (c *poolStatusCache) load(ctx context.Context) (map[string]*poolStatus, error) {
+	output, err := c.provider.GetAllPoolStatus(ctx, c.zpoolPath)
+	if err == nil {
+		return parsePoolStatusJSON(output)
+	}
+	slog.Info("JSON pool status unavailable, falling back to per-pool status queries", "error", err)
+
+	existing, err := c.provider.ListPools(ctx, c.zpoolPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		pools = make(map[string]*poolStatus)
+		sem   = make(chan struct{}, statusParallelism)
+	)
+	for name, guid := range existing {
+		wg.Go(func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			output, err := c.provider.GetPoolStatus(ctx, name, c.zpoolPath)
+			if err != nil {
+				slog.Warn("Failed to get pool status", "pool", name, "error", err, "output", string(output))
+				return
+			}
+			status := parsePoolStatusText(name, string(output))
+			status.GUID = guid
+
+			mu.Lock()
+			pools[name] = status
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+
+	return pools, nil
+}
+
+// reportPoolHealth logs the health of all named pools that exist, using a single status lookup.
+func reportPoolHealth(ctx context.Context, cache *poolStatusCache, names []string) {
+	statuses, err := cache.Status(ctx, names)
+	if err != nil {
+		slog.Warn("Failed to collect pool status", "error", err)
+		return
+	}
+
+	for _, name := range names {
+		status, ok := statuses[name]
+		if !ok {
+			continue
+		}
+
+		var devices []string
+		for _, leaf := range status.leaves() {
+			devices = append(devices, leaf.Name+"="+leaf.State)
+		}
+
+		logArgs := []any{"pool", name, "state", status.State, "guid", status.GUID, "devices", strings.Join(devices, " ")}
+		if status.healthy() {
+			slog.Info("Pool health", logArgs...)
+		} else {
+			logArgs = append(logArgs, "data_errors", status.ErrorCount, "status", status.Status, "action", status.Action)
+			slog.Warn("Pool is not healthy", logArgs...)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+const testPoolStatusJSON = `{
+  "output_version": {"command": "zpool status", "vers_major": 0, "vers_minor": 1},
+  "pools": {
+    "tank": {
+      "name": "tank",
+      "state": "DEGRADED",
+      "pool_guid": "1234567890",
+      "status": "One or more devices could not be used.",
+      "action": "Attach the missing device and online it using 'zpool online'.",
+      "error_count": "0",
+      "vdevs": {
+        "tank": {
+          "name": "tank",
+          "vdev_type": "root",
+          "state": "DEGRADED",
+          "vdevs": {
+            "mirror-0": {
+              "name": "mirror-0",
+              "vdev_type": "mirror",
+              "state": "DEGRADED",
+              "vdevs": {
+                "sdb": {"name": "sdb", "vdev_type": "disk", "state": "UNAVAIL", "path": "/dev/sdb1", "read_errors": "0", "write_errors": "0", "checksum_errors": "0"},
+                "sda": {"name": "sda", "vdev_type": "disk", "state": "ONLINE", "path": "/dev/sda1", "read_errors": "0", "write_errors": "0", "checksum_errors": "0"}
+              }
+            }
+          }
+        }
+      }
+    },
+    "data": {"name": "data", "state": "ONLINE", "pool_guid": "42", "error_count": "0"}
+  }
+}`
+
+func TestParsePoolStatusJSON(t *testing.T) {
+	pools, err := parsePoolStatusJSON([]byte(testPoolStatusJSON))
+	if err != nil {
+		t.Fatalf("parsePoolStatusJSON() returned an unexpected error: %v", err)
+	}
+	if len(pools) != 2 {
+		t.Fatalf("Expected 2 pools, got %d", len(pools))
+	}
+
+	tank := pools["tank"]
+	if tank.State != "DEGRADED" || tank.GUID != "1234567890" || tank.healthy() {
+		t.Errorf("tank status is incorrect: got %+v", tank)
+	}
+	leaves := tank.leaves()
+	if len(leaves) != 2 || leaves[0].Name != "sda" || leaves[1].Name != "sdb" || leaves[1].State != "UNAVAIL" {
+		t.Errorf("tank leaves are incorrect: got %+v", leaves)
+	}
+	if !pools["data"].healthy() {
+		t.Errorf("Expected data pool to be healthy, got %+v", pools["data"])
+	}
+
+	if _, err := parsePoolStatusJSON([]byte("invalid option 'j'")); err == nil {
+		t.Error("Expected an error for non-JSON output")
+	}
+}
+
+func TestParsePoolStatusText(t *testing.T) {
+	output := "  pool: tank\n state: ONLINE\nconfig:\n\n\tNAME        STATE     READ WRITE CKSUM\n\ttank        ONLINE       0     0     0\n"
+	status := parsePoolStatusText("tank", output)
+	if status.Name != "tank" || status.State != "ONLINE" || !status.healthy() {
+		t.Errorf("parsePoolStatusText() = %+v; want ONLINE tank", status)
+	}
+}
+
+func TestPoolStatusCache_SingleQuery(t *testing.T) {
+	var calls atomic.Int32
+	mockProvider := &mockZFSProvider{
+		GetAllPoolStatusFunc: func(ctx context.Context, zpoolPath string) ([]byte, error) {
+			calls.Add(1)
+			return []byte(testPoolStatusJSON), nil
+		},
+		GetPoolStatusFunc: func(ctx context.Context, name, zpoolPath string) ([]byte, error) {
+			t.Errorf("Unexpected per-pool status query for %q", name)
+			return nil, nil
+		},
+	}
+
+	cache := newPoolStatusCache(mockProvider, "/fake/zpool")
+	for range 3 {
+		statuses, err := cache.Status(t.Context(), []string{"tank", "missing"})
+		if err != nil {
+			t.Fatalf("Status() returned an unexpected error: %v", err)
+		}
+		if len(statuses) != 1 || statuses["tank"] == nil {
+			t.Fatalf("Expected only tank in result, got %v", statuses)
+		}
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("Expected exactly 1 zpool status call, got %d", got)
+	}
+
+	cache.Invalidate()
+	if _, err := cache.Status(t.Context(), []string{"tank"}); err != nil {
+		t.Fatalf("Status() returned an unexpected error: %v", err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("Expected a new zpool status call after Invalidate, got %d calls", got)
+	}
+}
+
+func TestPoolStatusCache_FallbackToPerPoolStatus(t *testing.T) {
+	var calls atomic.Int32
+	mockProvider := &mockZFSProvider{
+		GetAllPoolStatusFunc: func(ctx context.Context, zpoolPath string) ([]byte, error) {
+			return []byte("invalid option 'j'"), errors.New("exit status 2")
+		},
+		ListPoolsFunc: func(ctx context.Context, zpoolPath string) (map[string]string, error) {
+			return map[string]string{"tank": "1", "data": "2", "other": "3"}, nil
+		},
+		GetPoolStatusFunc: func(ctx context.Context, name, zpoolPath string) ([]byte, error) {
+			calls.Add(1)
+			return []byte("  pool: " + name + "\n state: ONLINE\n"), nil
+		},
+	}
+
+	cache := newPoolStatusCache(mockProvider, "/fake/zpool")
+	statuses, err := cache.Status(t.Context(), []string{"tank", "data"})
+	if err != nil {
+		t.Fatalf("Status() returned an unexpected error: %v", err)
+	}
+	if len(statuses) != 2 || statuses["tank"].GUID != "1" || statuses["data"].State != "ONLINE" {
+		t.Errorf("Unexpected fallback statuses: %v", statuses)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("Expected one status query per existing pool, got %d", got)
+	}
+}
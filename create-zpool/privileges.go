@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// capability is a Linux capability, see capabilities(7).
+type capability struct {
+	Name   string
+	Bit    uint
+	Reason string // What the tool needs it for.
+}
+
+// requiredCapabilities is the complete set of capabilities a run needs once the binaries are
+// found. Everything else is dropped by minimizePrivileges, also for the zpool and zfs commands
+// run afterwards.
+var requiredCapabilities = []capability{
+	{"CAP_SYS_ADMIN", 21, "ZFS ioctls on /dev/zfs, mounting datasets and enabling swap"},
+	{"CAP_SYS_RAWIO", 17, "raw access to block devices by zpool and secure discards"},
+	{"CAP_DAC_OVERRIDE", 1, "files and directories not owned by root, e.g. mountpoints handed to workloads"},
+	{"CAP_CHOWN", 0, "the owner of mountpoints, see ZPOOL_<n>_MOUNT_UID"},
+	{"CAP_FOWNER", 3, "the permission bits of mountpoints not owned by root"},
+}
+
+// capSetPCAP is the capability needed to shrink the bounding set.
+const capSetPCAP = 8
+
+// procStatusFile is read for the capabilities of the process.
+var procStatusFile = "/proc/self/status"
+
+// requiredCapabilityMask returns requiredCapabilities as a capability bit mask.
+func requiredCapabilityMask() uint64 {
+	var mask uint64
+	for _, c := range requiredCapabilities {
+		mask |= 1 << c.Bit
+	}
+	return mask
+}
+
+// missingCapabilities returns the names of the required capabilities that are not in effective.
+func missingCapabilities(effective uint64) []string {
+	var missing []string
+	for _, c := range requiredCapabilities {
+		if effective&(1<<c.Bit) == 0 {
+			missing = append(missing, c.Name)
+		}
+	}
+	return missing
+}
+
+// readEffectiveCapabilities returns the effective capability set of the process from the CapEff
+// line of /proc/self/status.
+func readEffectiveCapabilities() (uint64, error) {
+	data, err := os.ReadFile(procStatusFile)
+	if err != nil {
+		return 0, err
+	}
+	for line := range strings.Lines(string(data)) {
+		if value, ok := strings.CutPrefix(line, "CapEff:"); ok {
+			return strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		}
+	}
+	return 0, fmt.Errorf("no CapEff in %s", procStatusFile)
+}
+
+// minimizePrivileges drops every capability but requiredCapabilities from the process and from
+// the commands it runs, so that the rest of the run cannot do more than managing pools even
+// though it runs as root. Missing required capabilities are reported, since zpool would fail
+// later with a much less helpful error. Failures are logged and leave the privileges as they are.
+func minimizePrivileges() {
+	effective, err := readEffectiveCapabilities()
+	if err != nil {
+		slog.Warn("Failed to read the capabilities of the process, not dropping any", "error", err)
+		return
+	}
+	if missing := missingCapabilities(effective); len(missing) > 0 {
+		slog.Error("Capabilities required to manage pools are missing", "missing", strings.Join(missing, ","))
+	}
+	if effective&^requiredCapabilityMask() == 0 {
+		return
+	}
+	if err := dropCapabilities(requiredCapabilityMask() & effective); err != nil {
+		slog.Warn("Failed to drop capabilities, running with all of them", "error", err)
+		return
+	}
+	var kept []string
+	for _, c := range requiredCapabilities {
+		kept = append(kept, c.Name)
+	}
+	slog.Info("Dropped all capabilities except those required to manage pools", "kept", strings.Join(kept, ","))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Constants from <linux/capability.h> and <linux/prctl.h>.
+const (
+	linuxCapabilityVersion3 = 0x20080522
+	prCapBSetDrop           = 24
+	prSetNoNewPrivs         = 38
+	prCapAmbient            = 47
+	prCapAmbientClearAll    = 4
+)
+
+// capUserHeader and capUserData are the arguments of capset(2).
+type capUserHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capUserData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+// dropCapabilities reduces the capabilities of all threads of the process to keep: the bounding
+// and ambient sets first, so that commands run later cannot regain any capability even though
+// they run as root, then the effective, permitted and inheritable sets. Gaining privileges
+// through setuid binaries is disabled as well.
+func dropCapabilities(keep uint64) error {
+	last := 63
+	if data, err := os.ReadFile("/proc/sys/kernel/cap_last_cap"); err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+			last = n
+		}
+	}
+	for c := 0; c <= last; c++ {
+		if keep&(1<<c) != 0 {
+			continue
+		}
+		if _, _, errno := syscall.AllThreadsSyscall(syscall.SYS_PRCTL, prCapBSetDrop, uintptr(c), 0); errno != 0 && !errors.Is(errno, syscall.EINVAL) {
+			return fmt.Errorf("failed to drop capability %d from the bounding set: %w", c, errno)
+		}
+	}
+	if _, _, errno := syscall.AllThreadsSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientClearAll, 0, 0, 0, 0); errno != 0 && !errors.Is(errno, syscall.EINVAL) {
+		return fmt.Errorf("failed to clear the ambient capabilities: %w", errno)
+	}
+
+	header := capUserHeader{version: linuxCapabilityVersion3}
+	var data [2]capUserData
+	for i := range data {
+		set := uint32(keep >> (32 * i))
+		data[i] = capUserData{effective: set, permitted: set, inheritable: set}
+	}
+	if _, _, errno := syscall.AllThreadsSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("capset failed: %w", errno)
+	}
+	if _, _, errno := syscall.AllThreadsSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return fmt.Errorf("failed to set no_new_privs: %w", errno)
+	}
+	return nil
+}
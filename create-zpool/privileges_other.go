@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// dropCapabilities is only supported on Linux.
+func dropCapabilities(keep uint64) error {
+	return errors.New("capabilities are only supported on linux")
+}
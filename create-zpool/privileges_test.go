@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestReadEffectiveCapabilities(t *testing.T) {
+	old := procStatusFile
+	t.Cleanup(func() { procStatusFile = old })
+
+	procStatusFile = filepath.Join(t.TempDir(), "status")
+	status := "Name:\tcreate-zpool\nCapInh:\t0000000000000000\nCapPrm:\t000001ffffffffff\nCapEff:\t0000000000200009\nCapBnd:\t000001ffffffffff\n"
+	if err := os.WriteFile(procStatusFile, []byte(status), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	effective, err := readEffectiveCapabilities()
+	if err != nil {
+		t.Fatalf("readEffectiveCapabilities() returned an unexpected error: %v", err)
+	}
+	if effective != 0x200009 {
+		t.Errorf("readEffectiveCapabilities() = %#x, want 0x200009", effective)
+	}
+	// CAP_CHOWN, CAP_FOWNER and CAP_SYS_ADMIN are set.
+	if missing := missingCapabilities(effective); !slices.Equal(missing, []string{"CAP_SYS_RAWIO", "CAP_DAC_OVERRIDE"}) {
+		t.Errorf("missingCapabilities() = %v", missing)
+	}
+	if missing := missingCapabilities(requiredCapabilityMask()); len(missing) != 0 {
+		t.Errorf("Expected no missing capabilities, got %v", missing)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// defaultProgressInterval is how often long running work is reported, see ZPOOL_PROGRESS_INTERVAL.
+const defaultProgressInterval = 30 * time.Second
+
+// progressInterval is how often startHeartbeat logs; zero disables the heartbeats.
+var progressInterval = defaultProgressInterval
+
+// startHeartbeat logs msg with args and the elapsed time every progressInterval until the
+// returned function is called, so that a pool whose creation takes minutes does not leave the
+// log silent.
+func startHeartbeat(msg string, args ...any) func() {
+	if progressInterval <= 0 {
+		return func() {}
+	}
+	start := time.Now()
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				slog.Info(msg, append(args, "elapsed", now.Sub(start).Round(time.Second).String())...)
+			}
+		}
+	})
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// outputLogger logs the output of a command line by line as it is written, instead of only
+// once the command has exited.
+type outputLogger struct {
+	command string
+	mu      sync.Mutex
+	partial []byte // Output after the last complete line.
+}
+
+// Write logs the complete lines of p and keeps the rest for the next write.
+func (l *outputLogger) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.partial = append(l.partial, p...)
+	for {
+		line, rest, ok := bytes.Cut(l.partial, []byte("\n"))
+		if !ok {
+			break
+		}
+		l.log(line)
+		l.partial = rest
+	}
+	return len(p), nil
+}
+
+// flush logs the output after the last complete line.
+func (l *outputLogger) flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.log(l.partial)
+	l.partial = nil
+}
+
+func (l *outputLogger) log(line []byte) {
+	line = bytes.TrimRight(line, "\r")
+	if len(bytes.TrimSpace(line)) == 0 {
+		return
+	}
+	// Output shows that the command is alive.
+	activeWatchdog.progressed()
+	slog.Info("Command output", "command", l.command, "line", string(line))
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer that can be written by the heartbeat goroutine while a test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLog records the default logger's output for the duration of the test.
+func captureLog(t *testing.T) *syncBuffer {
+	t.Helper()
+	buf := &syncBuffer{}
+	old := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(old) })
+	return buf
+}
+
+func TestOutputLogger(t *testing.T) {
+	buf := captureLog(t)
+	logger := &outputLogger{command: "zpool create tank"}
+	logger.Write([]byte("first li"))
+	if buf.String() != "" {
+		t.Fatalf("Expected an incomplete line not to be logged, got %q", buf.String())
+	}
+	logger.Write([]byte("ne\r\n\nsecond line\nthird"))
+	logger.flush()
+
+	out := buf.String()
+	for _, want := range []string{`line="first line"`, `line="second line"`, "line=third", `command="zpool create tank"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %s in the log, got:\n%s", want, out)
+		}
+	}
+	if n := strings.Count(out, "Command output"); n != 3 {
+		t.Errorf("Expected 3 logged lines without the empty one, got %d:\n%s", n, out)
+	}
+}
+
+func TestStartHeartbeat(t *testing.T) {
+	buf := captureLog(t)
+	old := progressInterval
+	t.Cleanup(func() { progressInterval = old })
+
+	progressInterval = 10 * time.Millisecond
+	stop := startHeartbeat("Still working on pool", "pool", "tank")
+	time.Sleep(50 * time.Millisecond)
+	stop()
+	if out := buf.String(); !strings.Contains(out, `msg="Still working on pool" pool=tank elapsed=`) {
+		t.Errorf("Expected heartbeats, got:\n%s", out)
+	}
+
+	n := len(buf.String())
+	time.Sleep(30 * time.Millisecond)
+	if len(buf.String()) != n {
+		t.Error("Expected no heartbeats after stopping")
+	}
+
+	progressInterval = 0
+	startHeartbeat("Still working on pool")()
+}
+
+func TestLiveZFSProvider_StreamCommand(t *testing.T) {
+	shPath, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not available")
+	}
+	buf := captureLog(t)
+	provider := &liveZFSProvider{commandTimeout: 10 * time.Second}
+	output, err := provider.streamCommand(t.Context(), shPath, "-c", "echo created; echo warning >&2")
+	if err != nil {
+		t.Fatalf("streamCommand() returned an unexpected error: %v", err)
+	}
+	if !bytes.Equal(output, []byte("created\nwarning\n")) {
+		t.Errorf("Expected the combined output, got %q", output)
+	}
+	if out := buf.String(); !strings.Contains(out, "line=created") || !strings.Contains(out, "line=warning") {
+		t.Errorf("Expected the output to be logged, got:\n%s", out)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+const defaultPVStorageClass = "zfs-local" // Storage class of rendered PersistentVolumes, see ZPOOL_PV_STORAGE_CLASS.
+
+// invalidPVNameChars matches characters that are not allowed in a Kubernetes object name.
+var invalidPVNameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// persistentVolume is the data rendered into a static PersistentVolume manifest.
+type persistentVolume struct {
+	Name         string
+	Pool         string
+	Node         string
+	StorageClass string
+	Path         string
+	Capacity     uint64 // Bytes available in the root dataset of the pool.
+}
+
+// persistentVolumeTemplate renders a local PersistentVolume. All strings are written as
+// double-quoted scalars, which YAML shares with JSON, so no value can break the document.
+var persistentVolumeTemplate = template.Must(template.New("pv").Funcs(template.FuncMap{"quote": strconv.Quote}).Parse(
+	`apiVersion: v1
+kind: PersistentVolume
+metadata:
+  name: {{ quote .Name }}
+  annotations:
+    zpool-extension/pool: {{ quote .Pool }}
+spec:
+  capacity:
+    storage: {{ quote (printf "%d" .Capacity) }}
+  volumeMode: Filesystem
+  accessModes:
+    - ReadWriteOnce
+  persistentVolumeReclaimPolicy: Retain
+  storageClassName: {{ quote .StorageClass }}
+  local:
+    path: {{ quote .Path }}
+  nodeAffinity:
+    required:
+      nodeSelectorTerms:
+        - matchExpressions:
+            - key: kubernetes.io/hostname
+              operator: In
+              values:
+                - {{ quote .Node }}
+`))
+
+// persistentVolumeName derives a valid object name from the node and pool names.
+func persistentVolumeName(node, pool string) string {
+	name := invalidPVNameChars.ReplaceAllString(strings.ToLower(node+"-"+pool), "-")
+	name = strings.Trim(name, "-.")
+	if len(name) > 253 {
+		name = strings.TrimRight(name[:253], "-.")
+	}
+	return name
+}
+
+// pvSettings holds the global settings for rendering PersistentVolume manifests.
+type pvSettings struct {
+	Dir          string // Output directory; empty disables rendering.
+	StorageClass string
+	Node         string
+}
+
+// parsePVSettings reads ZPOOL_PV_DIR, ZPOOL_PV_STORAGE_CLASS and ZPOOL_PV_NODE_NAME.
+// The node name defaults to the hostname, which is the node name on Talos.
+func parsePVSettings() (pvSettings, error) {
+	settings := pvSettings{
+		Dir:          os.Getenv("ZPOOL_PV_DIR"),
+		StorageClass: getEnv("ZPOOL_PV_STORAGE_CLASS", defaultPVStorageClass),
+		Node:         os.Getenv("ZPOOL_PV_NODE_NAME"),
+	}
+	if settings.Dir == "" {
+		return settings, nil
+	}
+	if !filepath.IsAbs(settings.Dir) {
+		return settings, fmt.Errorf("ZPOOL_PV_DIR must be an absolute path, got %q", settings.Dir)
+	}
+	if settings.Node == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return settings, fmt.Errorf("failed to determine the node name, set ZPOOL_PV_NODE_NAME: %w", err)
+		}
+		settings.Node = hostname
+	}
+	return settings, nil
+}
+
+// writePersistentVolumes renders a static PersistentVolume manifest for the root dataset of
+// each given pool into settings.Dir, named pv-<pool>.yaml. Pools whose root dataset is not
+// mounted at an absolute path are skipped. Existing manifests are overwritten, so capacities
+// follow the pools.
+func writePersistentVolumes(ctx context.Context, provider zfsProvider, zfsPath string, settings pvSettings, pools []string) []error {
+	if zfsPath == "" {
+		return []error{fmt.Errorf("cannot render PersistentVolumes: zfs binary not found")}
+	}
+	if err := os.MkdirAll(settings.Dir, 0o755); err != nil {
+		return []error{fmt.Errorf("failed to create PersistentVolume directory %s: %w", settings.Dir, err)}
+	}
+
+	var errs []error
+	for _, pool := range pools {
+		props, err := provider.GetDatasetProperties(ctx, zfsPath, pool, []string{"available", "mountpoint"})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("pool %q: failed to read dataset properties for PersistentVolume: %w", pool, err))
+			continue
+		}
+		mountpoint := props["mountpoint"]
+		if !filepath.IsAbs(mountpoint) {
+			slog.Info("Pool is not mounted at a path, not rendering a PersistentVolume", "pool", pool, "mountpoint", mountpoint)
+			continue
+		}
+		capacity, err := strconv.ParseUint(props["available"], 10, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("pool %q: invalid available space %q: %w", pool, props["available"], err))
+			continue
+		}
+
+		pv := persistentVolume{
+			Name:         persistentVolumeName(settings.Node, pool),
+			Pool:         pool,
+			Node:         settings.Node,
+			StorageClass: settings.StorageClass,
+			Path:         mountpoint,
+			Capacity:     capacity,
+		}
+		var b strings.Builder
+		if err := persistentVolumeTemplate.Execute(&b, pv); err != nil {
+			errs = append(errs, fmt.Errorf("pool %q: failed to render PersistentVolume: %w", pool, err))
+			continue
+		}
+		path := filepath.Join(settings.Dir, "pv-"+pool+".yaml")
+		if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+			errs = append(errs, fmt.Errorf("pool %q: failed to write PersistentVolume: %w", pool, err))
+			continue
+		}
+		slog.Info("Rendered PersistentVolume", "pool", pool, "name", pv.Name, "path", path)
+	}
+	return errs
+}
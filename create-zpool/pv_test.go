@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPersistentVolumeName(t *testing.T) {
+	tests := map[string]struct{ node, pool, want string }{
+		"simple":     {"worker-1", "tank", "worker-1-tank"},
+		"uppercase":  {"Worker-1", "Tank", "worker-1-tank"},
+		"underscore": {"worker-1", "fast_pool:2", "worker-1-fast-pool-2"},
+		"fqdn":       {"worker-1.example.com", "tank", "worker-1.example.com-tank"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := persistentVolumeName(tt.node, tt.pool); got != tt.want {
+				t.Errorf("persistentVolumeName(%q, %q) = %q, want %q", tt.node, tt.pool, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWritePersistentVolumes(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "pv")
+	mockProvider := &mockZFSProvider{
+		GetDatasetPropertiesFunc: func(ctx context.Context, zfsPath, dataset string, props []string) (map[string]string, error) {
+			switch dataset {
+			case "tank":
+				return map[string]string{"available": "1099511627776", "mountpoint": "/var/mnt/tank"}, nil
+			case "legacy":
+				return map[string]string{"available": "1024", "mountpoint": "legacy"}, nil
+			}
+			return nil, errors.New("dataset does not exist")
+		},
+	}
+	settings := pvSettings{Dir: dir, StorageClass: "zfs-local", Node: "worker-1"}
+
+	errs := writePersistentVolumes(t.Context(), mockProvider, "/fake/zfs", settings, []string{"tank", "legacy", "missing"})
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), `pool "missing"`) {
+		t.Errorf("Expected a single error for the missing pool, got %v", errs)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "pv-tank.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `apiVersion: v1
+kind: PersistentVolume
+metadata:
+  name: "worker-1-tank"
+  annotations:
+    zpool-extension/pool: "tank"
+spec:
+  capacity:
+    storage: "1099511627776"
+  volumeMode: Filesystem
+  accessModes:
+    - ReadWriteOnce
+  persistentVolumeReclaimPolicy: Retain
+  storageClassName: "zfs-local"
+  local:
+    path: "/var/mnt/tank"
+  nodeAffinity:
+    required:
+      nodeSelectorTerms:
+        - matchExpressions:
+            - key: kubernetes.io/hostname
+              operator: In
+              values:
+                - "worker-1"
+`
+	if string(data) != want {
+		t.Errorf("Rendered manifest =\n%s\nwant\n%s", data, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pv-legacy.yaml")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected no manifest for a pool without a mount path, got %v", err)
+	}
+
+	if errs := writePersistentVolumes(t.Context(), mockProvider, "", settings, []string{"tank"}); len(errs) != 1 {
+		t.Errorf("Expected an error without a zfs binary, got %v", errs)
+	}
+}
+
+func TestParsePVSettings(t *testing.T) {
+	t.Setenv("ZPOOL_PV_DIR", "")
+	settings, err := parsePVSettings()
+	if err != nil || settings.Dir != "" {
+		t.Errorf("Expected rendering to be disabled by default, got %+v, %v", settings, err)
+	}
+
+	t.Setenv("ZPOOL_PV_DIR", "relative/dir")
+	if _, err := parsePVSettings(); err == nil {
+		t.Error("Expected an error for a relative ZPOOL_PV_DIR")
+	}
+
+	t.Setenv("ZPOOL_PV_DIR", "/var/mnt/.zpool-extension/pv")
+	t.Setenv("ZPOOL_PV_NODE_NAME", "worker-1")
+	settings, err = parsePVSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Node != "worker-1" || settings.StorageClass != defaultPVStorageClass {
+		t.Errorf("Unexpected settings %+v", settings)
+	}
+}
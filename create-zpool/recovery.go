@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// Recovery policies for pools that are suspended or faulted at startup, selected with
+// ZPOOL_RECOVERY. Every policy also attempts the steps of the ones before it.
+const (
+	recoveryNone     = "none"     // Only report the pool as failed.
+	recoveryClear    = "clear"    // Resume the pool with `zpool clear`.
+	recoveryReimport = "reimport" // Export the pool and import it again.
+	recoveryReadOnly = "readonly" // As a last resort, import the pool read-only.
+)
+
+// recoveryPolicies lists the recovery policies from least to most invasive.
+var recoveryPolicies = []string{recoveryNone, recoveryClear, recoveryReimport, recoveryReadOnly}
+
+// isValidRecoveryPolicy checks if the given recovery policy is supported.
+func isValidRecoveryPolicy(policy string) bool {
+	return slices.Contains(recoveryPolicies, policy)
+}
+
+// needsRecovery reports whether a pool with the given health cannot be used as is.
+func needsRecovery(health string) bool {
+	return health == "SUSPENDED" || health == "FAULTED"
+}
+
+// poolHealth returns the state of a pool from cache, e.g. "ONLINE" or "SUSPENDED".
+func poolHealth(ctx context.Context, cache *poolStatusCache, name string) (string, error) {
+	statuses, err := cache.Status(ctx, []string{name})
+	if err != nil {
+		return "", err
+	}
+	status, ok := statuses[name]
+	if !ok {
+		return "", fmt.Errorf("pool %s is not listed by zpool status", name)
+	}
+	return status.State, nil
+}
+
+// recoverPool checks whether an existing pool is suspended or faulted and, if so, walks
+// through the recovery steps allowed by policy until the pool is usable again: `zpool clear`,
+// then export and import, then a read-only import. guid identifies the pool for the imports.
+// A failed step moves on to the next one. An error is returned unless the pool ends up usable
+// read-write, naming the state it ended in and the steps attempted. In a dry run all allowed
+// steps are issued without checking their results. The health is read from cache, which is
+// invalidated after every step.
+func recoverPool(ctx context.Context, provider zfsProvider, cache *poolStatusCache, zpoolPath, name, guid, policy string, dryRun bool) error {
+	health, err := poolHealth(ctx, cache, name)
+	if err != nil {
+		slog.Warn("Failed to read pool health, not checking whether it needs recovery", "pool", name, "error", err)
+		return nil
+	}
+	if !needsRecovery(health) {
+		return nil
+	}
+	if policy == recoveryNone || policy == "" {
+		return fmt.Errorf("pool is %s and automatic recovery is disabled (ZPOOL_RECOVERY=%s)", health, recoveryNone)
+	}
+	slog.Warn("Pool needs recovery", "pool", name, "health", health, "policy", policy)
+
+	level := slices.Index(recoveryPolicies, policy)
+	initial, imported := health, true
+	var attempted []string
+	for _, step := range recoveryPolicies[1 : level+1] {
+		attempted = append(attempted, step)
+		slog.Info("Attempting pool recovery", "pool", name, "step", step)
+		err := runRecoveryStep(ctx, provider, zpoolPath, name, guid, step, &imported)
+		cache.Invalidate()
+		if err != nil {
+			logArgs := []any{"pool", name, "step", step, "error", err}
+			if hint := errorHint(err); hint != "" {
+				logArgs = append(logArgs, "hint", hint)
+			}
+			slog.Warn("Pool recovery step failed", logArgs...)
+			continue
+		}
+		if dryRun {
+			continue
+		}
+		if health, err = poolHealth(ctx, cache, name); err != nil {
+			slog.Warn("Failed to read pool health after recovery step", "pool", name, "step", step, "error", err)
+			health = "unknown"
+			continue
+		}
+		if needsRecovery(health) {
+			slog.Warn("Pool still needs recovery", "pool", name, "step", step, "health", health)
+			continue
+		}
+		if step == recoveryReadOnly {
+			return fmt.Errorf("pool was %s and could only be imported read-only, it is %s but not writable (attempted: %s)", initial, health, strings.Join(attempted, ", "))
+		}
+		slog.Info("Pool recovered", "pool", name, "step", step, "health", health)
+		return nil
+	}
+	if dryRun {
+		return nil
+	}
+	if !imported {
+		return fmt.Errorf("pool was %s and is no longer imported after failed recovery (attempted: %s)", initial, strings.Join(attempted, ", "))
+	}
+	return fmt.Errorf("pool is still %s after recovery (attempted: %s)", health, strings.Join(attempted, ", "))
+}
+
+// runRecoveryStep performs a single recovery step. imported tracks whether the pool is
+// currently imported, since a failed import leaves it exported for the next step.
+func runRecoveryStep(ctx context.Context, provider zfsProvider, zpoolPath, name, guid, step string, imported *bool) error {
+	if step == recoveryClear {
+		if output, err := provider.ClearPool(ctx, zpoolPath, name); err != nil {
+			return newZpoolCommandError("clear", err, output)
+		}
+		return nil
+	}
+
+	if *imported {
+		if output, err := provider.ExportPool(ctx, zpoolPath, name); err != nil {
+			return newZpoolCommandError("export", err, output)
+		}
+		*imported = false
+	}
+	importPool := provider.ImportPool
+	if step == recoveryReadOnly {
+		importPool = provider.ImportPoolReadOnly
+	}
+	if output, err := importPool(ctx, zpoolPath, guid); err != nil {
+		return newZpoolCommandError("import", err, output)
+	}
+	*imported = true
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// recoveryMock simulates a pool whose health changes after the given recovery command.
+type recoveryMock struct {
+	health   string
+	healAt   string // Command after which the pool is ONLINE.
+	failing  string // Command that fails.
+	commands []string
+}
+
+func (r *recoveryMock) provider() *mockZFSProvider {
+	run := func(command string) ([]byte, error) {
+		r.commands = append(r.commands, command)
+		if command == r.failing {
+			return []byte("cannot " + command), errors.New("exit status 1")
+		}
+		if command == r.healAt {
+			r.health = "ONLINE"
+		}
+		return nil, nil
+	}
+	return &mockZFSProvider{
+		GetAllPoolStatusFunc: func(ctx context.Context, zpoolPath string) ([]byte, error) {
+			return []byte(`{"pools": {"tank": {"name": "tank", "state": "` + r.health + `"}}}`), nil
+		},
+		ClearPoolFunc: func(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+			return run("clear")
+		},
+		ExportPoolFunc: func(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+			return run("export")
+		},
+		ImportPoolFunc: func(ctx context.Context, zpoolPath, id string) ([]byte, error) {
+			return run("import " + id)
+		},
+		ImportPoolReadOnlyFunc: func(ctx context.Context, zpoolPath, id string) ([]byte, error) {
+			return run("import-readonly " + id)
+		},
+	}
+}
+
+func TestRecoverPool(t *testing.T) {
+	tests := map[string]struct {
+		mock         recoveryMock
+		policy       string
+		wantCommands []string
+		wantErr      string
+	}{
+		"healthy": {
+			mock:   recoveryMock{health: "DEGRADED"},
+			policy: recoveryReadOnly,
+		},
+		"disabled": {
+			mock:    recoveryMock{health: "SUSPENDED"},
+			policy:  recoveryNone,
+			wantErr: "pool is SUSPENDED and automatic recovery is disabled",
+		},
+		"clear resumes the pool": {
+			mock:         recoveryMock{health: "SUSPENDED", healAt: "clear"},
+			policy:       recoveryReadOnly,
+			wantCommands: []string{"clear"},
+		},
+		"reimport": {
+			mock:         recoveryMock{health: "FAULTED", healAt: "import 42"},
+			policy:       recoveryReimport,
+			wantCommands: []string{"clear", "export", "import 42"},
+		},
+		"policy limits the steps": {
+			mock:         recoveryMock{health: "SUSPENDED", healAt: "import 42"},
+			policy:       recoveryClear,
+			wantCommands: []string{"clear"},
+			wantErr:      "pool is still SUSPENDED after recovery (attempted: clear)",
+		},
+		"read-only as last resort": {
+			mock:         recoveryMock{health: "SUSPENDED", healAt: "import-readonly 42", failing: "import 42"},
+			policy:       recoveryReadOnly,
+			wantCommands: []string{"clear", "export", "import 42", "import-readonly 42"},
+			wantErr:      "pool was SUSPENDED and could only be imported read-only",
+		},
+		"export failure": {
+			mock:         recoveryMock{health: "SUSPENDED", failing: "export"},
+			policy:       recoveryReadOnly,
+			wantCommands: []string{"clear", "export", "export"},
+			wantErr:      "pool is still SUSPENDED after recovery (attempted: clear, reimport, readonly)",
+		},
+		"left exported": {
+			mock:         recoveryMock{health: "SUSPENDED", failing: "import 42"},
+			policy:       recoveryReimport,
+			wantCommands: []string{"clear", "export", "import 42"},
+			wantErr:      "pool was SUSPENDED and is no longer imported after failed recovery",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			mock := tt.mock
+			provider := mock.provider()
+			err := recoverPool(t.Context(), provider, newPoolStatusCache(provider, "/fake/zpool"), "/fake/zpool", "tank", "42", tt.policy, false)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("recoverPool() returned an unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("recoverPool() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+			if !slices.Equal(mock.commands, tt.wantCommands) {
+				t.Errorf("Commands = %v, want %v", mock.commands, tt.wantCommands)
+			}
+		})
+	}
+}
+
+func TestCreatePool_RecoversExistingPool(t *testing.T) {
+	mock := recoveryMock{health: "SUSPENDED", healAt: "clear"}
+	state := newRunState(map[string]string{"tank": "42"})
+	state.recovery = recoveryClear
+	config := poolConfig{Name: "tank", Disks: []diskSpec{{Dev: "/dev/sda"}}, Ashift: "12"}
+	if err := createPool(t.Context(), mock.provider(), "/fake/zpool", config, state); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	if !slices.Equal(mock.commands, []string{"clear"}) {
+		t.Errorf("Expected the suspended pool to be cleared, got %v", mock.commands)
+	}
+
+	// Without recovery the pool is reported as failed instead of treated as existing.
+	mock = recoveryMock{health: "SUSPENDED"}
+	if err := createPool(t.Context(), mock.provider(), "/fake/zpool", config, newRunState(map[string]string{"tank": "42"})); err == nil {
+		t.Error("Expected an error for a suspended pool without recovery")
+	}
+}
+
+func TestRecoverPool_Plan(t *testing.T) {
+	mock := recoveryMock{health: "SUSPENDED"}
+	planner := newPlanningProvider(mock.provider())
+	planner.setPool("tank")
+	if err := recoverPool(t.Context(), planner, newPoolStatusCache(planner, "/fake/zpool"), "/fake/zpool", "tank", "42", recoveryReadOnly, true); err != nil {
+		t.Fatalf("recoverPool() returned an unexpected error: %v", err)
+	}
+	var actions []string
+	for _, a := range planner.actions {
+		actions = append(actions, a.Action)
+	}
+	want := []string{"clear", "export", "import", "export", "import-readonly"}
+	if !slices.Equal(actions, want) {
+		t.Errorf("Planned actions = %v, want %v", actions, want)
+	}
+	if len(mock.commands) != 0 {
+		t.Errorf("Expected no commands in plan mode, got %v", mock.commands)
+	}
+}
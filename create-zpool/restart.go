@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Exit codes of create runs. Talos restarts the service until it exits with exitConverged
+// (restart: untilSuccess), so every other code comes with a persisted backoff, see finishRun.
+// exitLocked and exitHung are retryable as well.
+const (
+	exitConverged   = 0  // All pools are in the desired state.
+	exitRetryable   = 75 // A later run may succeed, e.g. once missing disks appeared (EX_TEMPFAIL).
+	exitConfigError = 78 // The configuration is invalid and only changing it helps (EX_CONFIG).
+
+	defaultRetryBackoff    = 30 * time.Second // Backoff after the first failed run, see ZPOOL_RETRY_BACKOFF.
+	defaultRetryBackoffMax = 30 * time.Minute // Longest backoff, see ZPOOL_RETRY_BACKOFF_MAX.
+)
+
+// configError marks an error as caused by the configuration, which no retry can fix.
+type configError struct{ err error }
+
+func (e configError) Error() string { return e.err.Error() }
+func (e configError) Unwrap() error { return e.err }
+
+// runExitCode returns the exit code of a create run that failed with errs: exitConfigError if
+// every error is a configuration error, since restarting cannot help, and exitRetryable otherwise.
+func runExitCode(errs []error) int {
+	if len(errs) == 0 {
+		return exitConverged
+	}
+	for _, err := range errs {
+		if !errors.As(err, new(configError)) {
+			return exitRetryable
+		}
+	}
+	return exitConfigError
+}
+
+// retryBackoff is kept in the state file after a failed create run, so that the service manager
+// restarting the service does not run it again right away.
+type retryBackoff struct {
+	Failures int       `json:"failures"`  // Consecutive failed runs with the same configuration.
+	Until    time.Time `json:"until"`     // The next start waits until then.
+	ExitCode int       `json:"exit_code"` // Exit code of the last failed run.
+	// Config is a fingerprint of the configuration of the failed runs. A changed configuration
+	// is tried right away.
+	Config string `json:"config"`
+}
+
+// backoffSettings are the bounds of the exponential backoff between failed runs.
+type backoffSettings struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+// parseBackoffSettings reads ZPOOL_RETRY_BACKOFF and ZPOOL_RETRY_BACKOFF_MAX. Invalid values
+// are reported, but the defaults are returned anyway, so that a typo never causes a tight
+// restart loop.
+func parseBackoffSettings() (backoffSettings, error) {
+	settings := backoffSettings{Base: defaultRetryBackoff, Max: defaultRetryBackoffMax}
+	base, err := getEnvDuration("ZPOOL_RETRY_BACKOFF", defaultRetryBackoff)
+	if err != nil {
+		return settings, err
+	}
+	maxBackoff, err := getEnvDuration("ZPOOL_RETRY_BACKOFF_MAX", defaultRetryBackoffMax)
+	if err != nil {
+		return settings, err
+	}
+	if base > maxBackoff {
+		return settings, errors.New("ZPOOL_RETRY_BACKOFF must not exceed ZPOOL_RETRY_BACKOFF_MAX")
+	}
+	return backoffSettings{Base: base, Max: maxBackoff}, nil
+}
+
+// configFingerprint hashes everything this tool reads its configuration from: the variables,
+// the talos.zpool.* parameters of the kernel command line and the content of configFile, so that
+// fixing any of them ends the backoff of the run that failed with it.
+func configFingerprint(configFile string) string {
+	var entries []string
+	for _, kv := range os.Environ() {
+		if key, _, _ := strings.Cut(kv, "="); isConfigVar(key) {
+			entries = append(entries, kv)
+		}
+	}
+	if data, err := os.ReadFile(procCmdlineFile); err == nil {
+		vars, _ := parseCmdlineConfig(string(data))
+		for key, value := range vars {
+			entries = append(entries, "cmdline:"+key+"="+value)
+		}
+	}
+	slices.Sort(entries)
+	file := fileFingerprint(configFile)
+	entries = append(entries, "file:"+hex.EncodeToString(file[:]))
+	sum := sha256.Sum256([]byte(strings.Join(entries, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// backoffDelay returns how long a start waits before running, given the backoff left behind by
+// the previous run.
+func backoffDelay(b *retryBackoff, config string, now time.Time) time.Duration {
+	if b == nil || b.Config != config {
+		return 0
+	}
+	return max(b.Until.Sub(now), 0)
+}
+
+// nextBackoff returns the backoff after a run with the given configuration exited with code, or
+// nil if it converged. Retryable failures back off exponentially, configuration errors right
+// away with the longest backoff.
+func nextBackoff(prev *retryBackoff, settings backoffSettings, config string, code int, now time.Time) *retryBackoff {
+	if code == exitConverged {
+		return nil
+	}
+	failures := 1
+	if prev != nil && prev.Config == config {
+		failures = prev.Failures + 1
+	}
+	delay := settings.Max
+	if code != exitConfigError {
+		delay = settings.Base
+		for i := 1; i < failures && delay < settings.Max; i++ {
+			delay *= 2
+		}
+		delay = min(delay, settings.Max)
+	}
+	return &retryBackoff{Failures: failures, Until: now.Add(delay), ExitCode: code, Config: config}
+}
+
+// waitForBackoff sleeps for the backoff left behind by a failed previous run, unless the
+// configuration changed since, see configFingerprint.
+func waitForBackoff(stateDir, configFile string) {
+	st, err := loadState(stateDir)
+	if err != nil {
+		slog.Warn("Failed to load state, not backing off", "state_dir", stateDir, "error", err)
+		return
+	}
+	config := configFingerprint(configFile)
+	if st.Backoff != nil && st.Backoff.Config != config {
+		slog.Info("The configuration changed since the last failed run, not backing off", "failures", st.Backoff.Failures)
+		return
+	}
+	if delay := backoffDelay(st.Backoff, config, time.Now()); delay > 0 {
+		slog.Info("Backing off after failed runs", "failures", st.Backoff.Failures, "exit_code", st.Backoff.ExitCode, "delay", delay.Round(time.Second))
+		time.Sleep(delay)
+	}
+}
+
+// finishRun records the backoff for the exit code of a create run with the configuration file
+// configFile in the state file and returns the code.
+func finishRun(stateDir, configFile string, settings backoffSettings, code int) int {
+	st, err := loadState(stateDir)
+	if err != nil {
+		slog.Warn("Failed to load state, not recording the backoff", "state_dir", stateDir, "error", err)
+		return code
+	}
+	next := nextBackoff(st.Backoff, settings, configFingerprint(configFile), code, time.Now())
+	if next == nil && st.Backoff == nil {
+		return code
+	}
+	st.Backoff = next
+	if err := saveState(stateDir, st); err != nil {
+		slog.Warn("Failed to save the backoff", "state_dir", stateDir, "error", err)
+	}
+	if next != nil {
+		slog.Info("The next start backs off", "exit_code", code, "failures", next.Failures, "until", next.Until.Format(time.RFC3339))
+	}
+	return code
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunExitCode(t *testing.T) {
+	invalid := fmt.Errorf("pool %q: %w", "tank", configError{errors.New("ZPOOL_0_TYPE is invalid")})
+	tests := []struct {
+		name string
+		errs []error
+		want int
+	}{
+		{"no errors", nil, exitConverged},
+		{"configuration errors only", []error{invalid, invalid}, exitConfigError},
+		{"missing disks", []error{errors.New("disk /dev/sdb not found")}, exitRetryable},
+		{"mixed", []error{invalid, errors.New("zpool create failed")}, exitRetryable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runExitCode(tt.errs); got != tt.want {
+				t.Errorf("runExitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	settings := backoffSettings{Base: 30 * time.Second, Max: 5 * time.Minute}
+	now := time.Now()
+
+	var b *retryBackoff
+	var delays []time.Duration
+	for range 6 {
+		b = nextBackoff(b, settings, "a", exitRetryable, now)
+		delays = append(delays, b.Until.Sub(now))
+	}
+	want := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	for i := range want {
+		if delays[i] != want[i] {
+			t.Errorf("Backoff after %d failures = %s, want %s", i+1, delays[i], want[i])
+		}
+	}
+	if b.Failures != 6 || b.ExitCode != exitRetryable {
+		t.Errorf("Unexpected backoff %+v", b)
+	}
+
+	if changed := nextBackoff(b, settings, "b", exitRetryable, now); changed.Failures != 1 || changed.Until.Sub(now) != settings.Base {
+		t.Errorf("Expected a changed configuration to reset the backoff, got %+v", changed)
+	}
+	if invalid := nextBackoff(nil, settings, "a", exitConfigError, now); invalid.Until.Sub(now) != settings.Max {
+		t.Errorf("Expected a configuration error to back off for the longest time, got %s", invalid.Until.Sub(now))
+	}
+	if converged := nextBackoff(b, settings, "a", exitConverged, now); converged != nil {
+		t.Errorf("Expected no backoff after converging, got %+v", converged)
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	now := time.Now()
+	b := &retryBackoff{Failures: 2, Until: now.Add(time.Minute), Config: "a"}
+	if got := backoffDelay(b, "a", now); got != time.Minute {
+		t.Errorf("backoffDelay() = %s, want 1m", got)
+	}
+	if got := backoffDelay(b, "b", now); got != 0 {
+		t.Errorf("backoffDelay() = %s after a configuration change, want 0", got)
+	}
+	if got := backoffDelay(b, "a", now.Add(time.Hour)); got != 0 {
+		t.Errorf("backoffDelay() = %s after the backoff expired, want 0", got)
+	}
+	if got := backoffDelay(nil, "a", now); got != 0 {
+		t.Errorf("backoffDelay() = %s without a backoff, want 0", got)
+	}
+}
+
+func TestFinishRun(t *testing.T) {
+	stateDir := t.TempDir()
+	settings := backoffSettings{Base: time.Minute, Max: time.Hour}
+
+	for range 2 {
+		if code := finishRun(stateDir, "", settings, exitRetryable); code != exitRetryable {
+			t.Fatalf("finishRun() = %d, want %d", code, exitRetryable)
+		}
+	}
+	st, err := loadState(stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Backoff == nil || st.Backoff.Failures != 2 || st.Backoff.Config != configFingerprint("") {
+		t.Fatalf("Expected the backoff of two failed runs in the state, got %+v", st.Backoff)
+	}
+
+	if code := finishRun(stateDir, "", settings, exitConverged); code != exitConverged {
+		t.Fatalf("finishRun() = %d, want %d", code, exitConverged)
+	}
+	if st, err = loadState(stateDir); err != nil {
+		t.Fatal(err)
+	}
+	if st.Backoff != nil {
+		t.Errorf("Expected a converged run to clear the backoff, got %+v", st.Backoff)
+	}
+}
+
+func TestFinishRun_ConfigFileChanged(t *testing.T) {
+	stateDir := t.TempDir()
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configFile, []byte("pools:\n  - name: tank\n    type: bogus\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	finishRun(stateDir, configFile, backoffSettings{Base: time.Minute, Max: time.Hour}, exitConfigError)
+	st, err := loadState(stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delay := backoffDelay(st.Backoff, configFingerprint(configFile), time.Now()); delay == 0 {
+		t.Fatal("Expected a backoff for the unchanged configuration file")
+	}
+
+	if err := os.WriteFile(configFile, []byte("pools:\n  - name: tank\n    type: mirror\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if delay := backoffDelay(st.Backoff, configFingerprint(configFile), time.Now()); delay != 0 {
+		t.Errorf("backoffDelay() = %s after the configuration file was fixed, want 0", delay)
+	}
+}
+
+func TestConfigFingerprint_Cmdline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cmdline")
+	old := procCmdlineFile
+	procCmdlineFile = path
+	t.Cleanup(func() { procCmdlineFile = old })
+
+	if err := os.WriteFile(path, []byte("quiet talos.zpool.0.type=bogus\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	before := configFingerprint("")
+	if err := os.WriteFile(path, []byte("quiet talos.zpool.0.type=mirror\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if configFingerprint("") == before {
+		t.Error("Expected a changed talos.zpool.* parameter to change the fingerprint")
+	}
+}
+
+func TestParseBackoffSettings(t *testing.T) {
+	t.Setenv("ZPOOL_RETRY_BACKOFF", "1h")
+	t.Setenv("ZPOOL_RETRY_BACKOFF_MAX", "10m")
+	settings, err := parseBackoffSettings()
+	if err == nil {
+		t.Error("Expected an error for a backoff above its maximum")
+	}
+	if settings.Base != defaultRetryBackoff || settings.Max != defaultRetryBackoffMax {
+		t.Errorf("Expected the defaults alongside the error, got %+v", settings)
+	}
+}
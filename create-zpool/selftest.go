@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// zfsControlDevice is the character device through which zpool and zfs talk to the kernel module.
+var zfsControlDevice = "/dev/zfs"
+
+// Results of a self-test check.
+const (
+	checkPass = "PASS"
+	checkWarn = "WARN" // Works, but something is likely to cause trouble later.
+	checkFail = "FAIL"
+	checkSkip = "SKIP" // Not checked, because a check it depends on failed.
+)
+
+// selftestCheck is one line of the self-test checklist.
+type selftestCheck struct {
+	Name   string
+	Result string
+	Detail string
+}
+
+// runSelftest checks every prerequisite of a create run without changing anything: the ZFS
+// binaries and kernel module, the module parameters, the configuration and the configured disks.
+func runSelftest(ctx context.Context, provider zfsProvider) []selftestCheck {
+	var checks []selftestCheck
+	add := func(name, result, detail string) {
+		checks = append(checks, selftestCheck{Name: name, Result: result, Detail: detail})
+	}
+
+	zpoolPath, err := resolveBinary(provider, "ZPOOL_BIN", "zpool")
+	if err != nil {
+		add("zpool binary", checkFail, err.Error())
+	} else {
+		add("zpool binary", checkPass, zpoolPath)
+	}
+	if zfsPath, err := resolveBinary(provider, "ZFS_BIN", "zfs"); err == nil {
+		add("zfs binary", checkPass, zfsPath)
+	} else if _, ok := os.LookupEnv("ZFS_BIN"); ok {
+		add("zfs binary", checkFail, err.Error())
+	} else {
+		add("zfs binary", checkWarn, "not found, dataset operations are unavailable")
+	}
+
+	if ok, err := provider.IsCharDevice(zfsControlDevice); err != nil {
+		add(zfsControlDevice, checkFail, fmt.Sprintf("%v, is the zfs kernel module loaded?", err))
+	} else if !ok {
+		add(zfsControlDevice, checkFail, "not a character device")
+	} else {
+		add(zfsControlDevice, checkPass, "")
+	}
+
+	if effective, err := readEffectiveCapabilities(); err != nil {
+		add("capabilities", checkWarn, err.Error())
+	} else if missing := missingCapabilities(effective); len(missing) > 0 {
+		add("capabilities", checkFail, "missing "+strings.Join(missing, ", "))
+	} else {
+		add("capabilities", checkPass, "")
+	}
+
+	if zpoolPath == "" {
+		add("ZFS version", checkSkip, "zpool binary not found")
+	} else if output, err := provider.GetVersion(ctx, zpoolPath); err != nil {
+		add("ZFS version", checkFail, fmt.Sprintf("%v: %s", err, strings.TrimSpace(string(output))))
+	} else {
+		version := parseZFSVersion(string(output))
+		detail := fmt.Sprintf("userland %s, kernel %s", version.Userland, version.Kernel)
+		mismatch := checkVersionMismatch(version)
+		switch {
+		case version.Kernel == "":
+			add("ZFS version", checkFail, detail+", the kernel module is not loaded")
+		case mismatch != nil:
+			add("ZFS version", checkWarn, mismatch.Error())
+		default:
+			add("ZFS version", checkPass, detail)
+		}
+	}
+
+	// Module parameters are checked for readability, the configured ones also for existence.
+	params, paramErrs := parseModuleParameters()
+	names := slices.Sorted(maps.Keys(params))
+	if len(names) == 0 {
+		names = []string{"zfs_arc_max"}
+	}
+	for _, name := range names {
+		if _, err := provider.ReadModuleParameter(name); err != nil {
+			paramErrs = append(paramErrs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	if err := errors.Join(paramErrs...); err != nil {
+		add("module parameters", checkFail, strings.ReplaceAll(err.Error(), "\n", "; "))
+	} else {
+		add("module parameters", checkPass, "readable: "+strings.Join(names, ", "))
+	}
+
+	configs := parsePoolConfigs()
+	var configErrs []error
+	invalid := make(map[string]bool)
+	disabled := 0
+	for _, config := range configs {
+		if config.Disabled {
+			disabled++
+		}
+		if err := validatePoolConfig(config); err != nil {
+			configErrs = append(configErrs, fmt.Errorf("pool %q: %w", config.Name, err))
+			invalid[config.Name] = true
+		}
+	}
+	switch {
+	case len(configErrs) > 0:
+		add("configuration", checkFail, strings.ReplaceAll(errors.Join(configErrs...).Error(), "\n", "; "))
+	case len(configs) == 0:
+		add("configuration", checkWarn, "no pools configured, e.g. ZPOOL_0_NAME is not set")
+	default:
+		add("configuration", checkPass, fmt.Sprintf("%d pools, %d disabled", len(configs), disabled))
+	}
+
+	var existing map[string]string
+	if zpoolPath != "" {
+		if existing, err = provider.ListPools(ctx, zpoolPath); err != nil {
+			add("existing pools", checkFail, err.Error())
+			zpoolPath = ""
+		}
+	}
+	usedDisks := make(map[string]bool)
+	for _, config := range configs {
+		name := "disks of pool " + config.Name
+		switch {
+		case config.Disabled:
+			continue
+		case invalid[config.Name]:
+			add(name, checkSkip, "invalid configuration")
+			continue
+		case zpoolPath == "":
+			add(name, checkSkip, "existing pools unknown")
+			continue
+		}
+		if _, ok := existing[config.Name]; ok {
+			add(name, checkPass, "pool exists")
+			continue
+		}
+		disks, err := configuredDisks(config)
+		if err != nil {
+			add(name, checkFail, err.Error())
+			continue
+		}
+		sizeConds, err := parseSizeConditions(config.SizeFilters)
+		if err != nil {
+			add(name, checkFail, err.Error())
+			continue
+		}
+		selected, unusable := selectDisks(provider, config.Name, disks, sizeConds, usedDisks)
+		switch {
+		case len(unusable) > 0:
+			add(name, checkFail, "unusable: "+strings.Join(unusable, " "))
+		case len(selected) == 0:
+			add(name, checkFail, "no disks configured")
+		default:
+			add(name, checkPass, strings.Join(selected, " "))
+		}
+	}
+	return checks
+}
+
+// selftestMain implements ZPOOL_MODE=selftest: it prints the self-test checklist to w and returns
+// a non-zero exit code if any check failed.
+func selftestMain(ctx context.Context, provider zfsProvider, w io.Writer) int {
+	code := 0
+	for _, check := range runSelftest(ctx, provider) {
+		line := fmt.Sprintf("[%s] %s", check.Result, check.Name)
+		if check.Detail != "" {
+			line += ": " + check.Detail
+		}
+		fmt.Fprintln(w, line)
+		if check.Result == checkFail {
+			code = 1
+		}
+	}
+	return code
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRunSelftest(t *testing.T) {
+	t.Setenv("ZPOOL_0_NAME", "tank")
+	t.Setenv("ZPOOL_0_TYPE", "mirror")
+	t.Setenv("ZPOOL_0_DISKS", "/dev/sda /dev/sdb")
+	t.Setenv("ZPOOL_1_NAME", "data")
+	t.Setenv("ZPOOL_1_DISKS", "/dev/sdc")
+	t.Setenv("ZFS_PARAM_zfs_arc_max", "17179869184")
+
+	mockProvider := &mockZFSProvider{
+		ListPoolsFunc: func(ctx context.Context, zpoolPath string) (map[string]string, error) {
+			return map[string]string{"data": "42"}, nil
+		},
+	}
+	results := func() map[string]selftestCheck {
+		checks := make(map[string]selftestCheck)
+		for _, check := range runSelftest(t.Context(), mockProvider) {
+			checks[check.Name] = check
+		}
+		return checks
+	}
+
+	for name, check := range results() {
+		if check.Result != checkPass {
+			t.Errorf("Expected %s to pass, got %+v", name, check)
+		}
+	}
+	if check := results()["disks of pool data"]; check.Detail != "pool exists" {
+		t.Errorf("Expected the disks of an existing pool not to be resolved, got %+v", check)
+	}
+
+	mockProvider.IsBlockDeviceFunc = func(path string) (bool, error) { return path != "/dev/sdb", nil }
+	mockProvider.IsCharDeviceFunc = func(path string) (bool, error) { return false, errors.New("stat /dev/zfs: no such file or directory") }
+	mockProvider.ReadModuleParameterFunc = func(name string) (string, error) { return "", errors.New("unknown parameter") }
+	mockProvider.GetVersionFunc = func(ctx context.Context, zpoolPath string) ([]byte, error) { return []byte("zfs-2.3.0-1\n"), nil }
+	checks := results()
+	for _, name := range []string{"/dev/zfs", "module parameters", "ZFS version", "disks of pool tank"} {
+		if checks[name].Result != checkFail {
+			t.Errorf("Expected %s to fail, got %+v", name, checks[name])
+		}
+	}
+	if detail := checks["disks of pool tank"].Detail; !strings.Contains(detail, "/dev/sdb") {
+		t.Errorf("Expected the unusable disk in the details, got %q", detail)
+	}
+
+	t.Setenv("ZPOOL_0_ASHIFT", "4k")
+	if check := results()["disks of pool tank"]; check.Result != checkSkip {
+		t.Errorf("Expected the disks of an invalid pool to be skipped, got %+v", check)
+	}
+}
+
+func TestSelftestMain(t *testing.T) {
+	mockProvider := &mockZFSProvider{
+		LookPathFunc: func(file string) (string, error) { return "", errors.New("executable file not found in $PATH") },
+	}
+	var out strings.Builder
+	if code := selftestMain(t.Context(), mockProvider, &out); code != 1 {
+		t.Errorf("selftestMain() = %d, want 1", code)
+	}
+	for _, want := range []string{"[FAIL] zpool binary: executable file not found", "[WARN] zfs binary: not found", "[SKIP] ZFS version", "[PASS] /dev/zfs\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in the output, got:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// splitRequest describes a `zpool split` of a mirrored pool into a new pool.
+type splitRequest struct {
+	Pool    string
+	NewPool string
+	// Devices are the devices that form the new pool, at most one per mirror. Empty lets zpool
+	// take the last device of every mirror.
+	Devices []string
+}
+
+// parseSplitRequest reads the split request from the command line arguments after the mode,
+// `<pool> <new pool> [device...]`, or from ZPOOL_SPLIT_POOL, ZPOOL_SPLIT_NEW_POOL and the
+// whitespace or comma separated ZPOOL_SPLIT_DEVICES.
+func parseSplitRequest(args []string) (splitRequest, error) {
+	var req splitRequest
+	if len(args) > 0 {
+		if len(args) < 2 {
+			return req, errors.New("usage: split <pool> <new pool> [device...]")
+		}
+		req = splitRequest{Pool: args[0], NewPool: args[1], Devices: args[2:]}
+	} else {
+		req = splitRequest{
+			Pool:    strings.TrimSpace(os.Getenv("ZPOOL_SPLIT_POOL")),
+			NewPool: strings.TrimSpace(os.Getenv("ZPOOL_SPLIT_NEW_POOL")),
+			Devices: strings.FieldsFunc(os.Getenv("ZPOOL_SPLIT_DEVICES"), func(r rune) bool { return r == ',' || unicode.IsSpace(r) }),
+		}
+	}
+	if !isValidZpoolName(req.Pool) {
+		return req, fmt.Errorf("invalid pool to split: %q", req.Pool)
+	}
+	if !isValidZpoolName(req.NewPool) {
+		return req, fmt.Errorf("invalid new pool name: %q", req.NewPool)
+	}
+	if req.Pool == req.NewPool {
+		return req, fmt.Errorf("the new pool needs a name other than %q", req.Pool)
+	}
+	return req, nil
+}
+
+// validateSplit checks that the pool can be split as requested: it must be ONLINE without a
+// resilver in progress, all of its data vdevs must be mirrors, and every requested device must
+// belong to a different mirror.
+func validateSplit(status *poolStatus, req splitRequest) error {
+	if status.State != "ONLINE" {
+		return fmt.Errorf("pool %s is %s, only ONLINE pools are split", req.Pool, status.State)
+	}
+	if scan := status.Scan; scan != nil && scan.Function == "RESILVER" && scan.State == "SCANNING" {
+		return fmt.Errorf("pool %s is resilvering, wait for it to finish", req.Pool)
+	}
+	vdevs := status.dataVdevs()
+	if len(vdevs) == 0 {
+		return fmt.Errorf("the vdevs of pool %s are unknown, splitting requires zpool status -j", req.Pool)
+	}
+	owner := make(map[string]string) // Device names and paths to the mirror they belong to.
+	for _, vdev := range vdevs {
+		if vdev.VdevType != "mirror" {
+			return fmt.Errorf("pool %s has the %s vdev %s, only pools of mirrors can be split", req.Pool, describeVdevType(vdev.VdevType), vdev.Name)
+		}
+		for _, leaf := range vdev.leaves() {
+			owner[leaf.Name] = vdev.Name
+			if leaf.Path != "" {
+				owner[leaf.Path], owner[filepath.Base(leaf.Path)] = vdev.Name, vdev.Name
+			}
+		}
+	}
+	used := make(map[string]string)
+	for _, device := range req.Devices {
+		mirror, ok := owner[device]
+		if !ok {
+			return fmt.Errorf("device %s is not part of a mirror of pool %s", device, req.Pool)
+		}
+		if other, ok := used[mirror]; ok {
+			return fmt.Errorf("devices %s and %s both belong to %s, at most one device per mirror can be split off", other, device, mirror)
+		}
+		used[mirror] = device
+	}
+	return nil
+}
+
+// splitMain splits a mirrored pool: one device of every mirror is detached into a new pool,
+// which is left exported, e.g. to move its disks to new hardware. A new pool that already
+// exists means the split already happened, so the mode can stay configured across reboots.
+func splitMain(ctx context.Context, provider zfsProvider, zpoolPath string, args []string) int {
+	req, err := parseSplitRequest(args)
+	if err != nil {
+		slog.Error("Invalid split request", "error", err)
+		return 1
+	}
+	existing, err := provider.ListPools(ctx, zpoolPath)
+	if err != nil {
+		slog.Error("Failed to list existing pools", "error", err)
+		return 1
+	}
+	if _, ok := existing[req.NewPool]; ok {
+		slog.Info("The new pool already exists, nothing to split.", "pool", req.Pool, "new_pool", req.NewPool)
+		return 0
+	}
+	importable, err := provider.ListImportablePools(ctx, zpoolPath)
+	if err != nil {
+		slog.Error("Failed to scan for exported pools", "error", err)
+		return 1
+	}
+	if slices.ContainsFunc(importable, func(p importablePool) bool { return p.Name == req.NewPool }) {
+		slog.Info("The new pool already exists as an exported pool, nothing to split.", "pool", req.Pool, "new_pool", req.NewPool)
+		return 0
+	}
+	if _, ok := existing[req.Pool]; !ok {
+		slog.Error("Pool to split does not exist", "pool", req.Pool)
+		return 1
+	}
+
+	statuses, err := newPoolStatusCache(provider, zpoolPath).Status(ctx, []string{req.Pool})
+	if err != nil {
+		slog.Error("Failed to get pool status", "pool", req.Pool, "error", err)
+		return 1
+	}
+	status, ok := statuses[req.Pool]
+	if !ok {
+		slog.Error("Pool to split has no status", "pool", req.Pool)
+		return 1
+	}
+	if err := validateSplit(status, req); err != nil {
+		slog.Error("Cannot split pool", "pool", req.Pool, "error", err)
+		return 1
+	}
+
+	slog.Info("Splitting pool", "pool", req.Pool, "new_pool", req.NewPool, "devices", req.Devices)
+	if output, err := provider.SplitPool(ctx, zpoolPath, req.Pool, req.NewPool, req.Devices); err != nil {
+		err = newZpoolCommandError("split", err, output)
+		logArgs := []any{"pool", req.Pool, "error", err}
+		if hint := errorHint(err); hint != "" {
+			logArgs = append(logArgs, "hint", hint)
+		}
+		slog.Error("Failed to split pool", logArgs...)
+		return 1
+	}
+	slog.Info("Split pool, the new pool is exported and can be moved to other hardware.", "pool", req.Pool, "new_pool", req.NewPool)
+	return 0
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const splitPoolStatusJSON = `{
+  "pools": {
+    "tank": {
+      "name": "tank",
+      "state": "ONLINE",
+      "vdevs": {
+        "tank": {
+          "name": "tank",
+          "vdev_type": "root",
+          "vdevs": {
+            "mirror-0": {
+              "name": "mirror-0",
+              "vdev_type": "mirror",
+              "vdevs": {
+                "sda": {"name": "sda", "vdev_type": "disk", "state": "ONLINE", "path": "/dev/disk/by-id/ata-a"},
+                "sdb": {"name": "sdb", "vdev_type": "disk", "state": "ONLINE", "path": "/dev/disk/by-id/ata-b"}
+              }
+            },
+            "mirror-1": {
+              "name": "mirror-1",
+              "vdev_type": "mirror",
+              "vdevs": {
+                "sdc": {"name": "sdc", "vdev_type": "disk", "state": "ONLINE", "path": "/dev/disk/by-id/ata-c"},
+                "sdd": {"name": "sdd", "vdev_type": "disk", "state": "ONLINE", "path": "/dev/disk/by-id/ata-d"}
+              }
+            }
+          }
+        }
+      }
+    }
+  }
+}`
+
+func TestParseSplitRequest(t *testing.T) {
+	req, err := parseSplitRequest([]string{"tank", "tank2", "sdb", "sdd"})
+	if err != nil {
+		t.Fatalf("parseSplitRequest() returned an unexpected error: %v", err)
+	}
+	if req.Pool != "tank" || req.NewPool != "tank2" || !slices.Equal(req.Devices, []string{"sdb", "sdd"}) {
+		t.Errorf("Unexpected request %+v", req)
+	}
+
+	t.Setenv("ZPOOL_SPLIT_POOL", "tank")
+	t.Setenv("ZPOOL_SPLIT_NEW_POOL", "offsite")
+	t.Setenv("ZPOOL_SPLIT_DEVICES", "sdb, ata-d")
+	if req, err = parseSplitRequest(nil); err != nil || req.NewPool != "offsite" || !slices.Equal(req.Devices, []string{"sdb", "ata-d"}) {
+		t.Errorf("Unexpected request %+v from the environment, error %v", req, err)
+	}
+
+	for _, args := range [][]string{{"tank"}, {"tank", "tank"}, {"tank", "mirror"}} {
+		if _, err := parseSplitRequest(args); err == nil {
+			t.Errorf("parseSplitRequest(%q) expected an error", args)
+		}
+	}
+}
+
+func TestValidateSplit(t *testing.T) {
+	tests := []struct {
+		name    string
+		devices []string
+		modify  func(*poolStatus)
+		wantErr string
+	}{
+		{name: "default devices"},
+		{name: "names and paths", devices: []string{"sdb", "/dev/disk/by-id/ata-c"}},
+		{name: "by-id name", devices: []string{"ata-a"}},
+		{name: "unknown device", devices: []string{"sde"}, wantErr: "not part of a mirror"},
+		{name: "same mirror", devices: []string{"sda", "ata-b"}, wantErr: "both belong to mirror-0"},
+		{name: "degraded", modify: func(s *poolStatus) { s.State = "DEGRADED" }, wantErr: "only ONLINE pools"},
+		{name: "resilvering", modify: func(s *poolStatus) { s.Scan = &scanStats{Function: "RESILVER", State: "SCANNING"} }, wantErr: "resilvering"},
+		{name: "raidz", modify: func(s *poolStatus) { s.Vdevs["tank"].Vdevs["mirror-1"].VdevType = "raidz" }, wantErr: "only pools of mirrors"},
+		{name: "no vdev tree", modify: func(s *poolStatus) { s.Vdevs = nil }, wantErr: "requires zpool status -j"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pools, err := parsePoolStatusJSON([]byte(splitPoolStatusJSON))
+			if err != nil {
+				t.Fatal(err)
+			}
+			status := pools["tank"]
+			if tt.modify != nil {
+				tt.modify(status)
+			}
+			err = validateSplit(status, splitRequest{Pool: "tank", NewPool: "tank2", Devices: tt.devices})
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("validateSplit() returned an unexpected error: %v", err)
+			} else if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("validateSplit() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSplitMain(t *testing.T) {
+	var split []string
+	importable := []importablePool(nil)
+	mockProvider := &mockZFSProvider{
+		ListPoolsFunc: func(ctx context.Context, zpoolPath string) (map[string]string, error) {
+			return map[string]string{"tank": "1234"}, nil
+		},
+		ListImportablePoolsFunc: func(ctx context.Context, zpoolPath string) ([]importablePool, error) {
+			return importable, nil
+		},
+		GetAllPoolStatusFunc: func(ctx context.Context, zpoolPath string) ([]byte, error) {
+			return []byte(splitPoolStatusJSON), nil
+		},
+		SplitPoolFunc: func(ctx context.Context, zpoolPath, name, newName string, devices []string) ([]byte, error) {
+			split = append([]string{name, newName}, devices...)
+			return nil, nil
+		},
+	}
+
+	if code := splitMain(t.Context(), mockProvider, "/fake/zpool", []string{"tank", "tank2", "sdb"}); code != 0 {
+		t.Fatalf("splitMain() = %d, want 0", code)
+	}
+	if want := []string{"tank", "tank2", "sdb"}; !slices.Equal(split, want) {
+		t.Errorf("Unexpected split %q, want %q", split, want)
+	}
+
+	// Once the new pool exists, e.g. exported after a previous boot, the split is not repeated.
+	split, importable = nil, []importablePool{{Name: "tank2", State: "ONLINE"}}
+	if code := splitMain(t.Context(), mockProvider, "/fake/zpool", []string{"tank", "tank2"}); code != 0 || split != nil {
+		t.Errorf("Expected no split for an existing new pool, got %d, %q", code, split)
+	}
+
+	if code := splitMain(t.Context(), mockProvider, "/fake/zpool", []string{"data", "data2"}); code != 1 {
+		t.Errorf("splitMain() = %d for a missing pool, want 1", code)
+	}
+}
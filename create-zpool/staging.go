@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+const stagingDir = "staging" // Directory in the state directory holding the altroots of staged pools.
+
+// stagedCreateArgs inserts an altroot into the arguments of `zpool create`. An altroot also
+// sets cachefile=none, so the staged pool is never imported automatically.
+func stagedCreateArgs(args []string, altroot string) []string {
+	return slices.Concat(args[:1], []string{"-R", altroot}, args[1:])
+}
+
+// validateStagedPool checks a pool created under its altroot before it is made visible: it
+// must be ONLINE with the configured ashift and pool properties, and its root dataset must
+// exist and be mounted if ZFS mounts it automatically. Without zfsPath the datasets are not
+// checked.
+func validateStagedPool(ctx context.Context, provider zfsProvider, zpoolPath, zfsPath string, config poolConfig) error {
+	names := []string{"health", "ashift"}
+	for name := range config.Properties {
+		names = append(names, name)
+	}
+	props, err := provider.GetPoolProperties(ctx, zpoolPath, config.Name, names)
+	if err != nil {
+		return fmt.Errorf("failed to read pool properties: %w", err)
+	}
+	var errs []error
+	if props["health"] != "ONLINE" {
+		errs = append(errs, fmt.Errorf("pool is %s", props["health"]))
+	}
+	if props["ashift"] != config.Ashift {
+		errs = append(errs, fmt.Errorf("ashift is %s, expected %s", props["ashift"], config.Ashift))
+	}
+	for name, want := range config.Properties {
+		if props[name] != want {
+			errs = append(errs, fmt.Errorf("property %s is %q, expected %q", name, props[name], want))
+		}
+	}
+
+	if zfsPath != "" {
+		datasets, err := provider.ListDatasets(ctx, zfsPath, config.Name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to list datasets: %w", err))
+		} else if i := slices.IndexFunc(datasets, func(d datasetInfo) bool { return d.Name == config.Name }); i < 0 {
+			errs = append(errs, errors.New("root dataset is missing"))
+		} else if d := datasets[i]; d.automounted() && !d.Mounted {
+			errs = append(errs, fmt.Errorf("root dataset is not mounted at %s", d.Mountpoint))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// promoteStagedPool validates a pool created under an altroot, then exports it and imports it
+// again by GUID without the altroot, so that workloads only ever see the pool at its final
+// mountpoints once it is complete. A pool that fails validation is exported and left for
+// inspection. In a dry run the pool is not validated.
+func (s *runState) promoteStagedPool(ctx context.Context, provider zfsProvider, zpoolPath string, config poolConfig, altroot string) error {
+	props, err := provider.GetPoolProperties(ctx, zpoolPath, config.Name, []string{"guid"})
+	if err != nil {
+		return fmt.Errorf("failed to read pool GUID: %w", err)
+	}
+	id := props["guid"]
+	if id == "" {
+		// Planned pools have no GUID yet.
+		id = config.Name
+	}
+
+	var validationErr error
+	if !s.dryRun {
+		validationErr = validateStagedPool(ctx, provider, zpoolPath, s.zfsPath, config)
+	}
+	slog.Info("Exporting staged pool", "pool", config.Name, "altroot", altroot)
+	if output, err := provider.ExportPool(ctx, zpoolPath, config.Name); err != nil {
+		return errors.Join(validationErr, newZpoolCommandError("export", err, output))
+	}
+	if !s.dryRun {
+		removeEmptyDirs(altroot)
+	}
+	if validationErr != nil {
+		return fmt.Errorf("staged pool failed validation and was left exported: %w", validationErr)
+	}
+
+	slog.Info("Importing validated pool at its final mountpoints", "pool", config.Name, "guid", id)
+	importCtx, cancel := withCommandTimeout(ctx, config.ImportTimeout)
+	output, err := provider.ImportPool(importCtx, zpoolPath, id)
+	cancel()
+	if err != nil {
+		return newZpoolCommandError("import", err, output)
+	}
+	s.settleUdev(ctx, provider, "after staged import")
+	return nil
+}
+
+// removeEmptyDirs removes root and the directories below it that are empty, innermost first.
+// Directories that still have content, e.g. a mountpoint that is still mounted, are kept.
+func removeEmptyDirs(root string) {
+	var dirs []string
+	_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	for _, dir := range slices.Backward(dirs) {
+		_ = os.Remove(dir)
+	}
+}
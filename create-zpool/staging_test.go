@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestStagedCreateArgs(t *testing.T) {
+	args := []string{"create", "-m", "/var/mnt/tank", "tank", "/dev/sda"}
+	got := stagedCreateArgs(args, "/state/staging/tank")
+	want := []string{"create", "-R", "/state/staging/tank", "-m", "/var/mnt/tank", "tank", "/dev/sda"}
+	if !slices.Equal(got, want) {
+		t.Errorf("stagedCreateArgs() = %v, want %v", got, want)
+	}
+	if args[1] != "-m" {
+		t.Errorf("Expected the original arguments to be left alone, got %v", args)
+	}
+}
+
+func TestValidateStagedPool(t *testing.T) {
+	config := poolConfig{Name: "tank", Ashift: "12", Properties: map[string]string{"autotrim": "on"}}
+	props := map[string]string{"health": "ONLINE", "ashift": "12", "autotrim": "on"}
+	datasets := []datasetInfo{{Name: "tank", Mountpoint: "/state/staging/tank/var/mnt/tank", CanMount: "on", Mounted: true}}
+	mockProvider := &mockZFSProvider{
+		GetPoolPropertiesFunc: func(ctx context.Context, zpoolPath, name string, names []string) (map[string]string, error) {
+			return props, nil
+		},
+		ListDatasetsFunc: func(ctx context.Context, zfsPath, pool string) ([]datasetInfo, error) {
+			return datasets, nil
+		},
+	}
+	if err := validateStagedPool(t.Context(), mockProvider, "/fake/zpool", "/fake/zfs", config); err != nil {
+		t.Fatalf("validateStagedPool() returned an unexpected error: %v", err)
+	}
+
+	props = map[string]string{"health": "DEGRADED", "ashift": "9", "autotrim": "off"}
+	datasets[0].Mounted = false
+	err := validateStagedPool(t.Context(), mockProvider, "/fake/zpool", "/fake/zfs", config)
+	for _, want := range []string{"pool is DEGRADED", "ashift is 9", `property autotrim is "off"`, "root dataset is not mounted"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected an error containing %q, got %v", want, err)
+		}
+	}
+
+	// Without the zfs binary only the pool is checked.
+	props = map[string]string{"health": "ONLINE", "ashift": "12", "autotrim": "on"}
+	if err := validateStagedPool(t.Context(), mockProvider, "/fake/zpool", "", config); err != nil {
+		t.Errorf("Expected datasets not to be checked without zfs, got %v", err)
+	}
+}
+
+func TestCreatePool_Staged(t *testing.T) {
+	stagingDir := t.TempDir()
+	altroot := filepath.Join(stagingDir, "tank")
+	health := "ONLINE"
+	var calls []string
+	mockProvider := &mockZFSProvider{
+		CreatePoolFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+			calls = append(calls, strings.Join(args, " "))
+			// zfs creates the mountpoint below the altroot.
+			return nil, os.MkdirAll(filepath.Join(altroot, "var/mnt/tank"), 0o755)
+		},
+		GetPoolPropertiesFunc: func(ctx context.Context, zpoolPath, name string, names []string) (map[string]string, error) {
+			return map[string]string{"guid": "1234", "health": health, "ashift": "12"}, nil
+		},
+		ExportPoolFunc: func(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+			calls = append(calls, "export "+name)
+			return nil, nil
+		},
+		ImportPoolFunc: func(ctx context.Context, zpoolPath, id string) ([]byte, error) {
+			calls = append(calls, "import "+id)
+			return nil, nil
+		},
+	}
+	config := poolConfig{Name: "tank", Disks: []diskSpec{{Dev: "/dev/sda"}}, Ashift: "12", Staged: true}
+
+	state := newRunState(nil)
+	state.stagingDir = stagingDir
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, state); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	want := []string{"create -R " + altroot + " -m /var/mnt/tank -o ashift=12 tank /dev/sda", "export tank", "import 1234"}
+	if !slices.Equal(calls, want) {
+		t.Errorf("Unexpected calls %q, want %q", calls, want)
+	}
+	if _, err := os.Stat(altroot); !os.IsNotExist(err) {
+		t.Errorf("Expected the altroot to be removed, got %v", err)
+	}
+
+	// A pool that fails validation stays exported.
+	calls, health = nil, "DEGRADED"
+	state = newRunState(nil)
+	state.stagingDir = stagingDir
+	err := createPool(t.Context(), mockProvider, "/fake/zpool", config, state)
+	if err == nil || !strings.Contains(err.Error(), "left exported") {
+		t.Errorf("Expected a validation error, got %v", err)
+	}
+	if !slices.Equal(calls, want[:2]) {
+		t.Errorf("Expected the pool to be exported but not imported again, got %q", calls)
+	}
+}
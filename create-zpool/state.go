@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultStateDir = "/var/mnt/.zpool-extension" // Persistent directory for state kept across boots, see ZPOOL_STATE_DIR.
+
+	firstBootMarkerFile = "first-boot-done" // Marker written once the first run completed successfully.
+	stateFile           = "state.json"      // Persistent state of previous runs, see persistentState.
+	pauseFile           = "pause"           // Operators create this file to stop the tool from touching any disk.
+	lockFile            = "lock"            // Locked for the whole run, so that instances never overlap.
+
+	defaultLockWait = 5 * time.Minute // How long to wait for a running instance, see ZPOOL_LOCK_WAIT.
+)
+
+// errLocked is returned when another instance still holds the lock after waiting.
+var errLocked = errors.New("another instance is running")
+
+// lockPollInterval is how often a held lock is tried again while waiting for it.
+var lockPollInterval = time.Second
+
+// persistentState is what the tool remembers across boots in the state file.
+type persistentState struct {
+	// Mountpoints maps the names of pools created by this tool to their mountpoint directories.
+	Mountpoints map[string]string `json:"mountpoints,omitempty"`
+	// ErrorCounters maps "<pool>/<device>" to the last seen non-zero error counters of a device.
+	ErrorCounters map[string]deviceErrors `json:"error_counters,omitempty"`
+	// Trends maps pool names to samples of their space usage, oldest first.
+	Trends map[string][]poolSample `json:"trends,omitempty"`
+	// History maps pool names to the zpool history entries caused by this tool, oldest first.
+	History map[string][]historyEntry `json:"history,omitempty"`
+	// Notified maps "<kind>/<pool>" to the last condition a notification was sent for.
+	Notified map[string]string `json:"notified,omitempty"`
+	// Upgrades maps pool names to the safety nets taken before upgrading them, oldest first.
+	Upgrades map[string][]upgradeSafeguard `json:"upgrades,omitempty"`
+	// GUIDs maps the names of managed pools to the GUID pinned when they were created or first
+	// seen, see verifyPoolGUID.
+	GUIDs map[string]string `json:"guids,omitempty"`
+	// Backoff is left behind by a failed create run, see finishRun.
+	Backoff *retryBackoff `json:"backoff,omitempty"`
+}
+
+// loadState reads the state file from stateDir. A missing file yields an empty state.
+func loadState(stateDir string) (*persistentState, error) {
+	st := &persistentState{}
+	data, err := os.ReadFile(filepath.Join(stateDir, stateFile))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, st); err != nil {
+			return nil, fmt.Errorf("failed to decode state file: %w", err)
+		}
+	}
+	if st.Mountpoints == nil {
+		st.Mountpoints = make(map[string]string)
+	}
+	if st.ErrorCounters == nil {
+		st.ErrorCounters = make(map[string]deviceErrors)
+	}
+	if st.Trends == nil {
+		st.Trends = make(map[string][]poolSample)
+	}
+	if st.History == nil {
+		st.History = make(map[string][]historyEntry)
+	}
+	if st.Notified == nil {
+		st.Notified = make(map[string]string)
+	}
+	if st.Upgrades == nil {
+		st.Upgrades = make(map[string][]upgradeSafeguard)
+	}
+	if st.GUIDs == nil {
+		st.GUIDs = make(map[string]string)
+	}
+	return st, nil
+}
+
+// saveState writes st to the state file in stateDir. The file is replaced atomically, so an
+// interrupted write never leaves a truncated state behind.
+func saveState(stateDir string, st *persistentState) error {
+	if err := os.MkdirAll(stateDir, 0o700); err != nil {
+		return fmt.Errorf("failed to create state directory %s: %w", stateDir, err)
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(stateDir, stateFile+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(stateDir, stateFile)); err != nil {
+		return fmt.Errorf("failed to replace state file: %w", err)
+	}
+	return nil
+}
+
+// firstBootDone reports whether the first-boot marker exists in stateDir.
+func firstBootDone(stateDir string) (bool, error) {
+	_, err := os.Stat(filepath.Join(stateDir, firstBootMarkerFile))
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to check first-boot marker: %w", err)
+}
+
+// paused reports whether the pause file exists in stateDir.
+func paused(stateDir string) (bool, error) {
+	_, err := os.Stat(filepath.Join(stateDir, pauseFile))
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to check pause file: %w", err)
+}
+
+// acquireLock takes an exclusive lock on the lock file in stateDir, waiting up to wait for
+// another instance to release it (e.g. a service restart while a long create is still in
+// flight). The lock is held until the returned file is closed or the process exits. If the
+// lock is still held after waiting, errLocked is returned.
+func acquireLock(stateDir string, wait time.Duration) (*os.File, error) {
+	if err := os.MkdirAll(stateDir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create state directory %s: %w", stateDir, err)
+	}
+	// #nosec G304: The lock file lives in the configured state directory
+	f, err := os.OpenFile(filepath.Join(stateDir, lockFile), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", f.Name(), err)
+		}
+		if !time.Now().Before(deadline) {
+			f.Close()
+			return nil, errLocked
+		}
+		time.Sleep(min(lockPollInterval, time.Until(deadline)))
+	}
+}
+
+// recordFirstBoot writes the first-boot marker to stateDir if the run converged, i.e. errs is
+// empty. A pool that failed or is still waiting for its disks keeps the first boot open, so that
+// the next run creates it. It reports whether the marker was written.
+func recordFirstBoot(stateDir string, errs []error) (bool, error) {
+	if len(errs) > 0 {
+		return false, nil
+	}
+	if err := writeFirstBootMarker(stateDir); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// writeFirstBootMarker records in stateDir that the first run completed successfully.
+func writeFirstBootMarker(stateDir string) error {
+	if err := os.MkdirAll(stateDir, 0o700); err != nil {
+		return fmt.Errorf("failed to create state directory %s: %w", stateDir, err)
+	}
+	content := fmt.Sprintf("completed at %s\n", time.Now().UTC().Format(time.RFC3339))
+	if err := os.WriteFile(filepath.Join(stateDir, firstBootMarkerFile), []byte(content), 0o600); err != nil {
+		return fmt.Errorf("failed to write first-boot marker: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFirstBootMarker(t *testing.T) {
+	stateDir := filepath.Join(t.TempDir(), "state")
+
+	done, err := firstBootDone(stateDir)
+	if err != nil || done {
+		t.Fatalf("firstBootDone() on a missing state dir = %v, %v; want false, nil", done, err)
+	}
+
+	if recorded, err := recordFirstBoot(stateDir, []error{errors.New("pool failed")}); recorded || err != nil {
+		t.Fatalf("recordFirstBoot() of a failed run = %v, %v; want false, nil", recorded, err)
+	}
+	if done, _ := firstBootDone(stateDir); done {
+		t.Fatal("Expected no first-boot marker after a failed run")
+	}
+	if recorded, err := recordFirstBoot(stateDir, nil); !recorded || err != nil {
+		t.Fatalf("recordFirstBoot() = %v, %v; want true, nil", recorded, err)
+	}
+
+	done, err = firstBootDone(stateDir)
+	if err != nil || !done {
+		t.Fatalf("firstBootDone() after writing the marker = %v, %v; want true, nil", done, err)
+	}
+}
+
+func TestStateFile(t *testing.T) {
+	stateDir := filepath.Join(t.TempDir(), "state")
+
+	st, err := loadState(stateDir)
+	if err != nil || len(st.Mountpoints) != 0 {
+		t.Fatalf("loadState() on a missing state dir = %+v, %v; want empty state", st, err)
+	}
+
+	st.Mountpoints["tank"] = "/var/mnt/tank"
+	if err := saveState(stateDir, st); err != nil {
+		t.Fatalf("saveState() returned an unexpected error: %v", err)
+	}
+	loaded, err := loadState(stateDir)
+	if err != nil || loaded.Mountpoints["tank"] != "/var/mnt/tank" {
+		t.Errorf("loadState() after saving = %+v, %v", loaded, err)
+	}
+
+	entries, _ := os.ReadDir(stateDir)
+	if len(entries) != 1 {
+		t.Errorf("Expected only the state file in the state dir, got %v", entries)
+	}
+
+	if err := os.WriteFile(filepath.Join(stateDir, stateFile), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadState(stateDir); err == nil {
+		t.Error("Expected an error for a corrupt state file")
+	}
+}
+
+func TestPaused(t *testing.T) {
+	stateDir := t.TempDir()
+	if p, err := paused(stateDir); err != nil || p {
+		t.Fatalf("paused() without pause file = %v, %v; want false, nil", p, err)
+	}
+	if err := os.WriteFile(filepath.Join(stateDir, pauseFile), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := paused(stateDir); err != nil || !p {
+		t.Fatalf("paused() with pause file = %v, %v; want true, nil", p, err)
+	}
+}
+
+func TestAcquireLock(t *testing.T) {
+	oldInterval := lockPollInterval
+	lockPollInterval = time.Millisecond
+	t.Cleanup(func() {
+		lockPollInterval = oldInterval
+	})
+	stateDir := filepath.Join(t.TempDir(), "state")
+
+	first, err := acquireLock(stateDir, 0)
+	if err != nil {
+		t.Fatalf("acquireLock() returned an unexpected error: %v", err)
+	}
+	if _, err := acquireLock(stateDir, 20*time.Millisecond); !errors.Is(err, errLocked) {
+		t.Errorf("Expected errLocked while another instance holds the lock, got %v", err)
+	}
+
+	// A waiting instance gets the lock as soon as the running one is done.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		first.Close()
+	}()
+	second, err := acquireLock(stateDir, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Expected the lock after it was released, got %v", err)
+	}
+	second.Close()
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	swapVolume  = "swap"     // Name of the swap zvol below the root dataset of its pool.
+	swapLabel   = "zfs-swap" // Label written into the swap header, at most 16 bytes.
+	swapMagic   = "SWAPSPACE2"
+	minSwapSize = 16 << 20 // Smallest swap zvol that is created.
+
+	swapOwnerProperty = "io.containdk:zpool-extension" // User property marking the swap zvols created by this tool.
+	swapOwnerValue    = "swap"
+)
+
+var procSwapsPath = "/proc/swaps"
+
+// swapVolumeProperties returns the properties OpenZFS recommends for swap zvols: blocks of the
+// page size, cheap compression, no caching of data that is already in memory, and synchronous
+// writes, so that swapping out actually frees memory.
+func swapVolumeProperties(pageSize int) map[string]string {
+	return map[string]string{
+		"volblocksize":          strconv.Itoa(pageSize),
+		"compression":           "zle",
+		"logbias":               "throughput",
+		"sync":                  "always",
+		"primarycache":          "metadata",
+		"secondarycache":        "none",
+		"com.sun:auto-snapshot": "false",
+		swapOwnerProperty:       swapOwnerValue,
+	}
+}
+
+// parseSwapSize parses a swap size, either a size like "8G" or a multiple of the node's RAM like
+// "0.5x". Exactly one of the results is non-zero.
+func parseSwapSize(s string) (size uint64, ramFactor float64, err error) {
+	s = strings.TrimSpace(s)
+	if factor, ok := strings.CutSuffix(strings.ToLower(s), "x"); ok {
+		ramFactor, err = strconv.ParseFloat(factor, 64)
+		if err != nil || ramFactor <= 0 || ramFactor > 16 {
+			return 0, 0, fmt.Errorf("invalid RAM multiple %q: must be a number greater than 0 and at most 16, followed by x", s)
+		}
+		return 0, ramFactor, nil
+	}
+	size, err = parseSizeInBytes(s)
+	if err != nil {
+		return 0, 0, err
+	}
+	if size < minSwapSize {
+		return 0, 0, fmt.Errorf("swap size %q is smaller than %d MiB", s, minSwapSize>>20)
+	}
+	return size, 0, nil
+}
+
+// resolveSwapSize computes the size of the swap zvol from its configuration, rounded down to a
+// multiple of the page size.
+func resolveSwapSize(provider zfsProvider, s string, pageSize int) (uint64, error) {
+	size, ramFactor, err := parseSwapSize(s)
+	if err != nil {
+		return 0, err
+	}
+	if ramFactor > 0 {
+		memTotal, err := provider.GetMemTotal()
+		if err != nil {
+			return 0, fmt.Errorf("failed to determine total memory for the swap size: %w", err)
+		}
+		size = max(uint64(float64(memTotal)*ramFactor), minSwapSize)
+	}
+	return size - size%uint64(pageSize), nil
+}
+
+// swapHeader returns the first page of a swap area of size bytes, laid out like mkswap does:
+// version 1, the index of the last page, no bad pages, a UUID and a label, and the magic at the
+// very end of the page.
+func swapHeader(size uint64, pageSize int, uuid [16]byte) []byte {
+	page := make([]byte, pageSize)
+	info := page[1024:]
+	binary.NativeEndian.PutUint32(info[0:], 1)
+	binary.NativeEndian.PutUint32(info[4:], uint32(size/uint64(pageSize)-1))
+	copy(info[12:28], uuid[:])
+	copy(info[28:44], swapLabel)
+	copy(page[pageSize-len(swapMagic):], swapMagic)
+	return page
+}
+
+// hasSwapSignature reports whether page, the first page of a device, is a swap header.
+func hasSwapSignature(page []byte) bool {
+	return len(page) >= 1024+len(swapMagic) && string(page[len(page)-len(swapMagic):]) == swapMagic
+}
+
+// newSwapUUID returns a random version 4 UUID for a swap header.
+func newSwapUUID() [16]byte {
+	var uuid [16]byte
+	_, _ = rand.Read(uuid[:])
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+	return uuid
+}
+
+// activeSwaps returns the devices and files that are in use as swap, as listed in /proc/swaps.
+func activeSwaps() (map[string]bool, error) {
+	f, err := os.Open(procSwapsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	swaps := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// The header line starts with "Filename"; names are escaped like in the mount table.
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] == "Filename" {
+			continue
+		}
+		swaps[unescapeMountInfo(fields[0])] = true
+	}
+	return swaps, scanner.Err()
+}
+
+// ownsSwapVolume reports whether the zvol dataset carries the user property of the swap zvols
+// created by this tool.
+func (s *runState) ownsSwapVolume(ctx context.Context, provider zfsProvider, dataset string) bool {
+	props, err := provider.GetDatasetProperties(ctx, s.zfsPath, dataset, []string{swapOwnerProperty})
+	if err != nil {
+		slog.Warn("Failed to read the properties of the swap zvol", "zvol", dataset, "error", err)
+		return false
+	}
+	return props[swapOwnerProperty] == swapOwnerValue
+}
+
+// ensureSwap creates the swap zvol of a pool if it does not exist yet, formats it as swap and
+// enables it. An existing zvol is never reformatted or resized: one without a swap signature is
+// only formatted if it is blank or marked as created by this tool, e.g. because formatting it
+// failed in an earlier run, and otherwise an error. A size that differs from the configuration
+// is only logged.
+func (s *runState) ensureSwap(ctx context.Context, provider zfsProvider, config poolConfig) error {
+	if config.SwapSize == "" {
+		return nil
+	}
+	if s.zfsPath == "" {
+		return errors.New("zfs binary not found, cannot provision the swap zvol")
+	}
+	dataset := config.Name + "/" + swapVolume
+	pageSize := os.Getpagesize()
+	size, err := resolveSwapSize(provider, config.SwapSize, pageSize)
+	if err != nil {
+		return err
+	}
+
+	volumes, err := provider.ListVolumes(ctx, s.zfsPath, config.Name)
+	if err != nil {
+		return fmt.Errorf("failed to list zvols: %w", err)
+	}
+	volsize, exists := volumes[dataset]
+	if !exists {
+		slog.Info("Creating swap zvol", "pool", config.Name, "zvol", dataset, "size", size)
+		if output, err := provider.CreateVolume(ctx, s.zfsPath, dataset, size, swapVolumeProperties(pageSize)); err != nil {
+			return fmt.Errorf("failed to create swap zvol %s: %w, output: %s", dataset, err, string(output))
+		}
+	} else if volsize != strconv.FormatUint(size, 10) {
+		slog.Warn("Swap zvol size differs from the configuration, not resizing it", "zvol", dataset, "volsize", volsize, "configured", size)
+	}
+
+	path, err := s.waitForVolume(ctx, provider, dataset)
+	if err != nil {
+		return err
+	}
+	format := !exists
+	if exists {
+		formatted, blank, err := provider.IsSwapFormatted(path)
+		if err != nil {
+			return fmt.Errorf("failed to read the swap header of %s: %w", path, err)
+		}
+		if !formatted && !blank && !s.ownsSwapVolume(ctx, provider, dataset) {
+			return fmt.Errorf("zvol %s exists but is not formatted as swap, refusing to overwrite it", dataset)
+		}
+		format = !formatted
+	}
+	if format {
+		slog.Info("Formatting swap zvol", "zvol", dataset, "device", path)
+		if err := provider.FormatSwap(path); err != nil {
+			return fmt.Errorf("failed to format %s as swap: %w", path, err)
+		}
+	}
+
+	// A zvol that only exists in the plan is not active either.
+	if exists || !s.dryRun {
+		device, err := provider.EvalSymlinks(path)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", path, err)
+		}
+		swaps, err := activeSwaps()
+		if err != nil {
+			return fmt.Errorf("failed to read active swap areas: %w", err)
+		}
+		if swaps[device] {
+			slog.Info("Swap zvol already enabled", "zvol", dataset, "device", device)
+			return nil
+		}
+	}
+	if err := provider.EnableSwap(path); err != nil {
+		return fmt.Errorf("failed to enable swap on %s: %w", path, err)
+	}
+	slog.Info("Enabled swap zvol", "pool", config.Name, "zvol", dataset, "device", path)
+	return nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func setProcSwaps(t *testing.T, devices ...string) {
+	t.Helper()
+	content := "Filename\t\t\t\tType\t\tSize\t\tUsed\t\tPriority\n"
+	for _, device := range devices {
+		content += device + "                               partition\t8388604\t\t0\t\t-2\n"
+	}
+	path := filepath.Join(t.TempDir(), "swaps")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	oldPath := procSwapsPath
+	procSwapsPath = path
+	t.Cleanup(func() {
+		procSwapsPath = oldPath
+	})
+}
+
+func TestParseSwapSize(t *testing.T) {
+	tests := []struct {
+		input     string
+		size      uint64
+		ramFactor float64
+		wantErr   bool
+	}{
+		{input: "8G", size: 8 << 30},
+		{input: "512MiB", size: 512 << 20},
+		{input: "0.5x", ramFactor: 0.5},
+		{input: "2X", ramFactor: 2},
+		{input: "1M", wantErr: true},
+		{input: "0x", wantErr: true},
+		{input: "32x", wantErr: true},
+		{input: "lots", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			size, ramFactor, err := parseSwapSize(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSwapSize(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if size != tt.size || ramFactor != tt.ramFactor {
+				t.Errorf("parseSwapSize(%q) = %d, %v, want %d, %v", tt.input, size, ramFactor, tt.size, tt.ramFactor)
+			}
+		})
+	}
+}
+
+func TestResolveSwapSize(t *testing.T) {
+	mockProvider := &mockZFSProvider{GetMemTotalFunc: func() (uint64, error) { return 16<<30 + 1000, nil }}
+	size, err := resolveSwapSize(mockProvider, "0.5x", 4096)
+	if err != nil {
+		t.Fatalf("resolveSwapSize() returned an unexpected error: %v", err)
+	}
+	if size%4096 != 0 || size > 8<<30+500 || size < 8<<30 {
+		t.Errorf("resolveSwapSize() = %d, want 8 GiB rounded down to whole pages", size)
+	}
+}
+
+func TestSwapHeader(t *testing.T) {
+	uuid := [16]byte{1, 2, 3}
+	page := swapHeader(64<<20, 4096, uuid)
+	if len(page) != 4096 || !hasSwapSignature(page) {
+		t.Fatalf("Expected a page with the swap magic, got %d bytes", len(page))
+	}
+	if version := binary.NativeEndian.Uint32(page[1024:]); version != 1 {
+		t.Errorf("Expected version 1, got %d", version)
+	}
+	if lastPage := binary.NativeEndian.Uint32(page[1028:]); lastPage != 16383 {
+		t.Errorf("Expected the last page to be 16383, got %d", lastPage)
+	}
+	if page[1036] != 1 || !strings.HasPrefix(string(page[1052:1068]), swapLabel) {
+		t.Errorf("Expected the UUID and label in the header")
+	}
+	if hasSwapSignature(make([]byte, 4096)) {
+		t.Errorf("Expected an empty page not to be a swap header")
+	}
+}
+
+func TestActiveSwaps(t *testing.T) {
+	setProcSwaps(t, "/dev/zd0", `/var/swap\040file`)
+	swaps, err := activeSwaps()
+	if err != nil {
+		t.Fatalf("activeSwaps() returned an unexpected error: %v", err)
+	}
+	if len(swaps) != 2 || !swaps["/dev/zd0"] || !swaps["/var/swap file"] {
+		t.Errorf("Unexpected active swaps %v", swaps)
+	}
+}
+
+func TestEnsureSwap(t *testing.T) {
+	config := poolConfig{Name: "tank", SwapSize: "8G"}
+	var calls []string
+	volumes := map[string]string{}
+	formatted := true
+	mockProvider := &mockZFSProvider{
+		ListVolumesFunc: func(ctx context.Context, zfsPath, pool string) (map[string]string, error) {
+			return volumes, nil
+		},
+		CreateVolumeFunc: func(ctx context.Context, zfsPath, dataset string, size uint64, props map[string]string) ([]byte, error) {
+			calls = append(calls, "create "+dataset)
+			if size != 8<<30 || props["sync"] != "always" {
+				t.Errorf("Unexpected zvol size %d or properties %v", size, props)
+			}
+			return nil, nil
+		},
+		IsSwapFormattedFunc: func(path string) (bool, bool, error) { return formatted, false, nil },
+		FormatSwapFunc: func(path string) error {
+			calls = append(calls, "format "+path)
+			return nil
+		},
+		EnableSwapFunc: func(path string) error {
+			calls = append(calls, "swapon "+path)
+			return nil
+		},
+		EvalSymlinksFunc: func(path string) (string, error) { return "/dev/zd0", nil },
+	}
+	state := newRunState(nil)
+	state.zfsPath = "/fake/zfs"
+
+	setProcSwaps(t)
+	if err := state.ensureSwap(t.Context(), mockProvider, config); err != nil {
+		t.Fatalf("ensureSwap() returned an unexpected error: %v", err)
+	}
+	want := []string{"create tank/swap", "format /dev/zvol/tank/swap", "swapon /dev/zvol/tank/swap"}
+	if !slices.Equal(calls, want) {
+		t.Errorf("Unexpected calls %q, want %q", calls, want)
+	}
+
+	// An existing zvol is enabled, but neither created nor formatted again.
+	calls, volumes["tank/swap"] = nil, "8589934592"
+	if err := state.ensureSwap(t.Context(), mockProvider, config); err != nil || !slices.Equal(calls, want[2:]) {
+		t.Errorf("Expected only swapon for an existing zvol, got %q, %v", calls, err)
+	}
+
+	calls = nil
+	setProcSwaps(t, "/dev/zd0")
+	if err := state.ensureSwap(t.Context(), mockProvider, config); err != nil || len(calls) != 0 {
+		t.Errorf("Expected nothing to be done for an active swap zvol, got %q, %v", calls, err)
+	}
+
+	formatted = false
+	if err := state.ensureSwap(t.Context(), mockProvider, config); err == nil || !strings.Contains(err.Error(), "refusing to overwrite") {
+		t.Errorf("Expected an error for an unformatted zvol, got %v", err)
+	}
+}
+
+func TestEnsureSwap_FormatRetry(t *testing.T) {
+	config := poolConfig{Name: "tank", SwapSize: "8G"}
+	volumes := map[string]string{}
+	var props map[string]string
+	formatErr := errors.New("device or resource busy")
+	var calls []string
+	mockProvider := &mockZFSProvider{
+		ListVolumesFunc: func(ctx context.Context, zfsPath, pool string) (map[string]string, error) {
+			return volumes, nil
+		},
+		CreateVolumeFunc: func(ctx context.Context, zfsPath, dataset string, size uint64, p map[string]string) ([]byte, error) {
+			volumes[dataset], props = "8589934592", p
+			return nil, nil
+		},
+		GetDatasetPropertiesFunc: func(ctx context.Context, zfsPath, dataset string, names []string) (map[string]string, error) {
+			return props, nil
+		},
+		IsSwapFormattedFunc: func(path string) (bool, bool, error) { return false, false, nil },
+		FormatSwapFunc: func(path string) error {
+			calls = append(calls, "format "+path)
+			return formatErr
+		},
+		EnableSwapFunc: func(path string) error {
+			calls = append(calls, "swapon "+path)
+			return nil
+		},
+		EvalSymlinksFunc: func(path string) (string, error) { return "/dev/zd0", nil },
+	}
+	state := newRunState(nil)
+	state.zfsPath = "/fake/zfs"
+	setProcSwaps(t)
+
+	if err := state.ensureSwap(t.Context(), mockProvider, config); err == nil || !strings.Contains(err.Error(), "failed to format") {
+		t.Fatalf("Expected the first format to fail, got %v", err)
+	}
+	// The next run formats the zvol it created itself, although its header is not blank.
+	calls, formatErr = nil, nil
+	if err := state.ensureSwap(t.Context(), mockProvider, config); err != nil {
+		t.Fatalf("ensureSwap() returned an unexpected error on retry: %v", err)
+	}
+	if want := []string{"format /dev/zvol/tank/swap", "swapon /dev/zvol/tank/swap"}; !slices.Equal(calls, want) {
+		t.Errorf("Unexpected calls %q, want %q", calls, want)
+	}
+
+	// A blank zvol without the property is formatted as well.
+	calls, props = nil, nil
+	mockProvider.IsSwapFormattedFunc = func(path string) (bool, bool, error) { return false, true, nil }
+	if err := state.ensureSwap(t.Context(), mockProvider, config); err != nil || len(calls) != 2 {
+		t.Errorf("Expected a blank zvol to be formatted, got %q, %v", calls, err)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"time"
+)
+
+const defaultErrorThresholdWindow = 10 * time.Minute // Window for error thresholds, see ZPOOL_OFFLINE_WINDOW.
+
+// errorThresholds are the numbers of new errors within Window after which a device is taken
+// offline, like the ZFS event daemon does. A zero threshold disables the check for that counter.
+type errorThresholds struct {
+	Read     uint64
+	Write    uint64
+	Checksum uint64
+	Window   time.Duration
+}
+
+// parseErrorThresholds reads ZPOOL_OFFLINE_READ_ERRORS, ZPOOL_OFFLINE_WRITE_ERRORS,
+// ZPOOL_OFFLINE_CHECKSUM_ERRORS and ZPOOL_OFFLINE_WINDOW.
+func parseErrorThresholds() (errorThresholds, error) {
+	t := errorThresholds{}
+	for key, dst := range map[string]*uint64{
+		"ZPOOL_OFFLINE_READ_ERRORS":     &t.Read,
+		"ZPOOL_OFFLINE_WRITE_ERRORS":    &t.Write,
+		"ZPOOL_OFFLINE_CHECKSUM_ERRORS": &t.Checksum,
+	} {
+		v, err := getEnvUint(key, 0)
+		if err != nil {
+			return t, err
+		}
+		*dst = v
+	}
+	window, err := getEnvDuration("ZPOOL_OFFLINE_WINDOW", defaultErrorThresholdWindow)
+	if err != nil {
+		return t, err
+	}
+	if t.enabled() && window <= 0 {
+		return t, fmt.Errorf("ZPOOL_OFFLINE_WINDOW must be positive, got %s", window)
+	}
+	t.Window = window
+	return t, nil
+}
+
+// enabled reports whether any threshold is set.
+func (t errorThresholds) enabled() bool {
+	return t.Read > 0 || t.Write > 0 || t.Checksum > 0
+}
+
+// exceeded returns the name of the first counter whose increase reached its threshold, or "".
+func (t errorThresholds) exceeded(delta deviceErrors) string {
+	switch {
+	case t.Read > 0 && delta.Read >= t.Read:
+		return "read"
+	case t.Write > 0 && delta.Write >= t.Write:
+		return "write"
+	case t.Checksum > 0 && delta.Checksum >= t.Checksum:
+		return "checksum"
+	}
+	return ""
+}
+
+// availableSpare returns the name of a hot spare of the pool that is not in use, or "".
+func (s *poolStatus) availableSpare() string {
+	for _, name := range slices.Sorted(maps.Keys(s.Spares)) {
+		if s.Spares[name].State == "AVAIL" {
+			return name
+		}
+	}
+	return ""
+}
+
+// enforceErrorThresholds compares the error counters of every ONLINE device with the counters
+// at the start of its window, kept in baselines, and takes devices offline whose errors grew
+// past a threshold. An available hot spare then replaces the device. zpool refuses to offline
+// a device without which the pool would lose data, so pools never lose their last replica.
+func enforceErrorThresholds(ctx context.Context, provider zfsProvider, zpoolPath string, statuses map[string]*poolStatus, t errorThresholds, baselines map[string]deviceErrors, now time.Time) {
+	for _, pool := range slices.Sorted(maps.Keys(statuses)) {
+		status := statuses[pool]
+		for _, leaf := range status.leaves() {
+			key := pool + "/" + leaf.Name
+			current, ok := leafErrors(leaf)
+			if !ok || leaf.State != "ONLINE" {
+				delete(baselines, key)
+				continue
+			}
+			base, tracked := baselines[key]
+			if !tracked || now.Sub(base.Since) >= t.Window || current.Read < base.Read || current.Write < base.Write || current.Checksum < base.Checksum {
+				// New window, or the counters were cleared in between.
+				current.Since = now
+				baselines[key] = current
+				continue
+			}
+
+			delta := deviceErrors{Read: current.Read - base.Read, Write: current.Write - base.Write, Checksum: current.Checksum - base.Checksum}
+			counter := t.exceeded(delta)
+			if counter == "" {
+				continue
+			}
+			logArgs := []any{"pool", pool, "device", leaf.Name, "counter", counter,
+				"read", delta.Read, "write", delta.Write, "checksum", delta.Checksum, "window", t.Window}
+			if output, err := provider.OfflineDevice(ctx, zpoolPath, pool, leaf.Name); err != nil {
+				slog.Error("Device exceeded its error threshold but could not be taken offline", append(logArgs, "error", err, "output", string(output))...)
+				continue
+			}
+			delete(baselines, key)
+			leaf.State = "OFFLINE"
+			slog.Error("Device exceeded its error threshold and was taken offline", logArgs...)
+
+			spare := status.availableSpare()
+			if spare == "" {
+				continue
+			}
+			if output, err := provider.ReplaceDevice(ctx, zpoolPath, pool, leaf.Name, spare); err != nil {
+				slog.Error("Failed to replace offlined device with a hot spare", "pool", pool, "device", leaf.Name, "spare", spare, "error", err, "output", string(output))
+				continue
+			}
+			// The spare is in use now and must not be picked for another device.
+			status.Spares[spare].State = "INUSE"
+			slog.Warn("Replacing offlined device with a hot spare", "pool", pool, "device", leaf.Name, "spare", spare)
+		}
+	}
+}
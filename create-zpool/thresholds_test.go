@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseErrorThresholds(t *testing.T) {
+	t.Setenv("ZPOOL_OFFLINE_CHECKSUM_ERRORS", "10")
+	thresholds, err := parseErrorThresholds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thresholds != (errorThresholds{Checksum: 10, Window: defaultErrorThresholdWindow}) {
+		t.Errorf("parseErrorThresholds() = %+v", thresholds)
+	}
+
+	t.Setenv("ZPOOL_OFFLINE_READ_ERRORS", "-1")
+	if _, err := parseErrorThresholds(); err == nil {
+		t.Error("Expected an error for a negative threshold")
+	}
+}
+
+func TestEnforceErrorThresholds(t *testing.T) {
+	start := time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+	statuses := func(sdaChecksum, sdbRead string, spare string) map[string]*poolStatus {
+		status := &poolStatus{Name: "tank", Vdevs: map[string]*vdevStatus{
+			"tank": {Name: "tank", Vdevs: map[string]*vdevStatus{
+				"mirror-0": {Name: "mirror-0", Vdevs: map[string]*vdevStatus{
+					"sda": {Name: "sda", State: "ONLINE", ReadErrors: "0", WriteErrors: "0", ChecksumErrors: sdaChecksum},
+					"sdb": {Name: "sdb", State: "ONLINE", ReadErrors: sdbRead, WriteErrors: "0", ChecksumErrors: "0"},
+				}},
+			}},
+		}}
+		if spare != "" {
+			status.Spares = map[string]*vdevStatus{spare: {Name: spare, State: "AVAIL"}}
+		}
+		return map[string]*poolStatus{"tank": status}
+	}
+
+	var commands []string
+	offlineErr := error(nil)
+	mockProvider := &mockZFSProvider{
+		OfflineDeviceFunc: func(ctx context.Context, zpoolPath, name, device string) ([]byte, error) {
+			commands = append(commands, "offline "+device)
+			return nil, offlineErr
+		},
+		ReplaceDeviceFunc: func(ctx context.Context, zpoolPath, name, device, replacement string) ([]byte, error) {
+			commands = append(commands, "replace "+device+" "+replacement)
+			return nil, nil
+		},
+	}
+	thresholds := errorThresholds{Checksum: 10, Read: 5, Window: 10 * time.Minute}
+	baselines := make(map[string]deviceErrors)
+	enforce := func(s map[string]*poolStatus, now time.Duration) {
+		enforceErrorThresholds(t.Context(), mockProvider, "/fake/zpool", s, thresholds, baselines, start.Add(now))
+	}
+
+	// The first check only records the baseline, however high the counters are.
+	enforce(statuses("100", "0", ""), 0)
+	if len(commands) != 0 {
+		t.Fatalf("Expected no action on the first check, got %v", commands)
+	}
+
+	// Below the threshold within the window, then a new window starts.
+	enforce(statuses("109", "4", ""), 5*time.Minute)
+	enforce(statuses("115", "4", ""), 11*time.Minute)
+	if len(commands) != 0 {
+		t.Fatalf("Expected no action below the thresholds, got %v", commands)
+	}
+
+	// Both devices reach a threshold within the window; the only spare replaces the first one.
+	enforce(statuses("125", "9", "sdc"), 15*time.Minute)
+	want := []string{"offline sda", "replace sda sdc", "offline sdb"}
+	if !slices.Equal(commands, want) {
+		t.Errorf("Commands = %v, want %v", commands, want)
+	}
+
+	// A refused offline (e.g. no valid replicas) is retried on the next check.
+	commands = nil
+	offlineErr = errors.New("cannot offline sda: no valid replicas")
+	enforce(statuses("125", "9", ""), 16*time.Minute)
+	enforce(statuses("140", "9", ""), 17*time.Minute)
+	if !slices.Equal(commands, []string{"offline sda"}) {
+		t.Errorf("Commands = %v, want a single offline attempt", commands)
+	}
+	enforce(statuses("141", "9", ""), 18*time.Minute)
+	if len(commands) != 2 {
+		t.Errorf("Expected the offline to be retried, got %v", commands)
+	}
+}
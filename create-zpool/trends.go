@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTrendRetention = 30 * 24 * time.Hour // How long usage samples are kept, see ZPOOL_TREND_RETENTION.
+	trendSampleInterval   = time.Hour           // Minimum time between two stored samples of a pool.
+	poolUsageColumns      = "size,allocated,free,fragmentation,dedupratio"
+)
+
+// poolSample is the space usage of a pool at one point in time.
+type poolSample struct {
+	Time          time.Time `json:"time"`
+	Size          uint64    `json:"size"`
+	Allocated     uint64    `json:"allocated"`
+	Free          uint64    `json:"free"`
+	Fragmentation uint64    `json:"fragmentation"` // Fragmentation of the free space in percent.
+	DedupRatio    float64   `json:"dedup_ratio"`
+}
+
+// parsePoolUsage parses the output of `zpool list -Hp -o size,allocated,free,fragmentation,dedupratio`.
+// A fragmentation of "-", reported by pools without the spacemap_histogram feature, is read as 0.
+func parsePoolUsage(output string) (poolSample, error) {
+	fields := strings.Split(strings.TrimSpace(output), "\t")
+	if len(fields) != 5 {
+		return poolSample{}, fmt.Errorf("unexpected zpool list output %q", output)
+	}
+	var s poolSample
+	for i, dst := range []*uint64{&s.Size, &s.Allocated, &s.Free, &s.Fragmentation} {
+		if i == 3 && fields[i] == "-" {
+			continue
+		}
+		v, err := strconv.ParseUint(strings.TrimSuffix(fields[i], "%"), 10, 64)
+		if err != nil {
+			return poolSample{}, fmt.Errorf("invalid value %q in zpool list output: %w", fields[i], err)
+		}
+		*dst = v
+	}
+	ratio, err := strconv.ParseFloat(strings.TrimSuffix(fields[4], "x"), 64)
+	if err != nil {
+		return poolSample{}, fmt.Errorf("invalid dedup ratio %q in zpool list output: %w", fields[4], err)
+	}
+	s.DedupRatio = ratio
+	return s, nil
+}
+
+// recordSample stores the sample in samples unless the newest stored one is younger than
+// trendSampleInterval, and drops samples older than retention. It returns the new samples
+// and whether they changed.
+func recordSample(samples []poolSample, sample poolSample, retention time.Duration) ([]poolSample, bool) {
+	changed := false
+	cutoff := sample.Time.Add(-retention)
+	if i := slices.IndexFunc(samples, func(s poolSample) bool { return !s.Time.Before(cutoff) }); i != 0 {
+		if i < 0 {
+			i = len(samples)
+		}
+		samples = samples[i:]
+		changed = true
+	}
+	if len(samples) == 0 || sample.Time.Sub(samples[len(samples)-1].Time) >= trendSampleInterval {
+		samples = append(samples, sample)
+		changed = true
+	}
+	return samples, changed
+}
+
+// poolTrend describes how the usage of a pool changed between the oldest stored sample and
+// the current one.
+type poolTrend struct {
+	Current             poolSample
+	Span                time.Duration // Time between the oldest sample and the current one.
+	FreeChangePerDay    float64       // Change of the free space in bytes per day, negative while filling up.
+	FragmentationChange int64         // Change of the fragmentation in percentage points.
+	DedupRatioChange    float64
+}
+
+// computeTrend computes the trend from the stored samples to the current sample.
+func computeTrend(samples []poolSample, current poolSample) poolTrend {
+	trend := poolTrend{Current: current}
+	if len(samples) == 0 {
+		return trend
+	}
+	oldest := samples[0]
+	trend.Span = current.Time.Sub(oldest.Time)
+	if trend.Span < trendSampleInterval {
+		return trend
+	}
+	trend.FreeChangePerDay = (float64(current.Free) - float64(oldest.Free)) / trend.Span.Hours() * 24
+	trend.FragmentationChange = int64(current.Fragmentation) - int64(oldest.Fragmentation)
+	trend.DedupRatioChange = current.DedupRatio - oldest.DedupRatio
+	return trend
+}
+
+// daysUntilFull extrapolates when the pool runs out of free space. ok is false if the free
+// space is not shrinking.
+func (t poolTrend) daysUntilFull() (days float64, ok bool) {
+	if t.FreeChangePerDay >= 0 {
+		return 0, false
+	}
+	return float64(t.Current.Free) / -t.FreeChangePerDay, true
+}
+
+// updateTrends samples the space usage of the named pools, stores the samples in the state
+// file for capacity planning unless retention is zero, and logs the trend of every pool. If
+// metricsFile is set, the usage and trends are also written there in the Prometheus text format.
+func updateTrends(ctx context.Context, provider zfsProvider, zpoolPath, stateDir string, names []string, retention time.Duration, metricsFile string, version zfsVersion, now time.Time) {
+	st, err := loadState(stateDir)
+	if err != nil {
+		slog.Warn("Failed to load state, not recording usage trends", "state_dir", stateDir, "error", err)
+		return
+	}
+
+	changed := false
+	trends := make(map[string]poolTrend)
+	for _, name := range names {
+		sample, err := provider.GetPoolUsage(ctx, zpoolPath, name)
+		if err != nil {
+			// Pools that do not exist are already reported by the health check.
+			continue
+		}
+		sample.Time = now
+		trend := computeTrend(st.Trends[name], sample)
+		trends[name] = trend
+
+		logArgs := []any{"pool", name, "free", sample.Free, "fragmentation", fmt.Sprintf("%d%%", sample.Fragmentation),
+			"dedup_ratio", sample.DedupRatio, "span", trend.Span.Round(time.Minute)}
+		if trend.Span >= trendSampleInterval {
+			logArgs = append(logArgs, "free_change_per_day", int64(trend.FreeChangePerDay),
+				"fragmentation_change", trend.FragmentationChange, "dedup_ratio_change", trend.DedupRatioChange)
+			if days, ok := trend.daysUntilFull(); ok {
+				logArgs = append(logArgs, "days_until_full", int64(days))
+			}
+		}
+		slog.Info("Pool usage trend", logArgs...)
+		if retention <= 0 {
+			continue
+		}
+		var updated bool
+		if st.Trends[name], updated = recordSample(st.Trends[name], sample, retention); updated {
+			changed = true
+		}
+	}
+
+	// Forget pools that are no longer configured, and all samples if recording is disabled.
+	for name := range st.Trends {
+		if retention <= 0 || !slices.Contains(names, name) {
+			delete(st.Trends, name)
+			changed = true
+		}
+	}
+	if changed {
+		if err := saveState(stateDir, st); err != nil {
+			slog.Warn("Failed to save usage trends", "state_dir", stateDir, "error", err)
+		}
+	}
+
+	if metricsFile != "" {
+		if err := writeMetrics(metricsFile, trends, version); err != nil {
+			slog.Warn("Failed to write metrics file", "path", metricsFile, "error", err)
+		}
+	}
+}
+
+// renderMetrics renders the usage and trends of the pools in the Prometheus text format, after
+// an info metric with the ZFS versions as labels, if they are known.
+func renderMetrics(trends map[string]poolTrend, version zfsVersion) string {
+	metrics := []struct {
+		name, help string
+		value      func(poolTrend) float64
+	}{
+		{"zpool_size_bytes", "Total size of the pool.", func(t poolTrend) float64 { return float64(t.Current.Size) }},
+		{"zpool_allocated_bytes", "Allocated space of the pool.", func(t poolTrend) float64 { return float64(t.Current.Allocated) }},
+		{"zpool_free_bytes", "Free space of the pool.", func(t poolTrend) float64 { return float64(t.Current.Free) }},
+		{"zpool_fragmentation_percent", "Fragmentation of the free space of the pool.", func(t poolTrend) float64 { return float64(t.Current.Fragmentation) }},
+		{"zpool_dedup_ratio", "Deduplication ratio of the pool.", func(t poolTrend) float64 { return t.Current.DedupRatio }},
+		{"zpool_free_bytes_change_per_day", "Change of the free space per day over the trend span.", func(t poolTrend) float64 { return t.FreeChangePerDay }},
+		{"zpool_fragmentation_change_percent", "Change of the fragmentation over the trend span.", func(t poolTrend) float64 { return float64(t.FragmentationChange) }},
+		{"zpool_dedup_ratio_change", "Change of the deduplication ratio over the trend span.", func(t poolTrend) float64 { return t.DedupRatioChange }},
+		{"zpool_trend_span_seconds", "Time covered by the stored usage samples.", func(t poolTrend) float64 { return t.Span.Seconds() }},
+	}
+	pools := slices.Sorted(maps.Keys(trends))
+	var b strings.Builder
+	if version != (zfsVersion{}) {
+		b.WriteString("# HELP zfs_version_info ZFS userland and kernel module versions.\n# TYPE zfs_version_info gauge\n")
+		fmt.Fprintf(&b, "zfs_version_info{userland=%s,kernel=%s} 1\n", strconv.Quote(version.Userland), strconv.Quote(version.Kernel))
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", m.name, m.help, m.name)
+		for _, pool := range pools {
+			fmt.Fprintf(&b, "%s{pool=%s} %s\n", m.name, strconv.Quote(pool), strconv.FormatFloat(m.value(trends[pool]), 'g', -1, 64))
+		}
+	}
+	return b.String()
+}
+
+// writeMetrics atomically replaces path with the rendered metrics, so that collectors reading
+// the file (e.g. the node exporter textfile collector) never see a partial write.
+func writeMetrics(path string, trends map[string]poolTrend, version zfsVersion) error {
+	return writeFileAtomic(path, []byte(renderMetrics(trends, version)))
+}
+
+// writeFileAtomic replaces path with data through a temporary file in the same directory, so
+// that readers see either the old or the new content.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
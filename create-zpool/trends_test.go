@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePoolUsage(t *testing.T) {
+	got, err := parsePoolUsage("1000\t400\t600\t12\t1.25\n")
+	if err != nil {
+		t.Fatalf("parsePoolUsage() error = %v", err)
+	}
+	want := poolSample{Size: 1000, Allocated: 400, Free: 600, Fragmentation: 12, DedupRatio: 1.25}
+	if got != want {
+		t.Errorf("parsePoolUsage() = %+v, want %+v", got, want)
+	}
+
+	if got, err := parsePoolUsage("1000\t0\t1000\t-\t1.00x"); err != nil || got.Fragmentation != 0 {
+		t.Errorf("Expected unknown fragmentation to read as 0, got %+v, %v", got, err)
+	}
+	for _, output := range []string{"", "1000\t400\t600\t12", "1T\t400\t600\t12\t1.00", "1000\t400\t600\t12\tnone"} {
+		if _, err := parsePoolUsage(output); err == nil {
+			t.Errorf("parsePoolUsage(%q) expected an error", output)
+		}
+	}
+}
+
+func TestRecordSample(t *testing.T) {
+	start := time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) poolSample { return poolSample{Time: start.Add(d)} }
+
+	samples, changed := recordSample(nil, at(0), 48*time.Hour)
+	if !changed || len(samples) != 1 {
+		t.Fatalf("Expected the first sample to be stored, got %v", samples)
+	}
+	if samples, changed = recordSample(samples, at(30*time.Minute), 48*time.Hour); changed || len(samples) != 1 {
+		t.Errorf("Expected a sample within the interval to be skipped, got %v", samples)
+	}
+	samples, _ = recordSample(samples, at(time.Hour), 48*time.Hour)
+	samples, _ = recordSample(samples, at(24*time.Hour), 48*time.Hour)
+	if len(samples) != 3 {
+		t.Fatalf("Expected 3 samples, got %v", samples)
+	}
+
+	// Samples older than the retention are dropped.
+	samples, _ = recordSample(samples, at(48*time.Hour+30*time.Minute), 48*time.Hour)
+	if len(samples) != 3 || !samples[0].Time.Equal(start.Add(time.Hour)) {
+		t.Errorf("Expected the oldest sample to be dropped, got %v", samples)
+	}
+	samples, _ = recordSample(samples, at(200*time.Hour), 48*time.Hour)
+	if len(samples) != 1 {
+		t.Errorf("Expected only the new sample after a long gap, got %v", samples)
+	}
+}
+
+func TestComputeTrend(t *testing.T) {
+	start := time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+	samples := []poolSample{{Time: start, Free: 1000, Fragmentation: 10, DedupRatio: 1}}
+
+	trend := computeTrend(samples, poolSample{Time: start.Add(2 * 24 * time.Hour), Free: 800, Fragmentation: 14, DedupRatio: 1.5})
+	if trend.FreeChangePerDay != -100 || trend.FragmentationChange != 4 || trend.DedupRatioChange != 0.5 {
+		t.Errorf("Unexpected trend %+v", trend)
+	}
+	if days, ok := trend.daysUntilFull(); !ok || days != 8 {
+		t.Errorf("daysUntilFull() = %v, %v, want 8, true", days, ok)
+	}
+
+	// Too short a span gives no trend.
+	trend = computeTrend(samples, poolSample{Time: start.Add(10 * time.Minute), Free: 500})
+	if trend.FreeChangePerDay != 0 {
+		t.Errorf("Expected no trend within the sample interval, got %+v", trend)
+	}
+	if _, ok := trend.daysUntilFull(); ok {
+		t.Error("Expected no estimate without a shrinking free space")
+	}
+}
+
+func TestUpdateTrends(t *testing.T) {
+	stateDir := t.TempDir()
+	metricsFile := filepath.Join(t.TempDir(), "zpool.prom")
+	start := time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+	if err := saveState(stateDir, &persistentState{Trends: map[string][]poolSample{"old": {{Time: start}}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	free := uint64(1000)
+	mockProvider := &mockZFSProvider{
+		GetPoolUsageFunc: func(ctx context.Context, zpoolPath, name string) (poolSample, error) {
+			if name != "tank" {
+				return poolSample{}, errors.New("no such pool")
+			}
+			return poolSample{Size: 2000, Allocated: 2000 - free, Free: free, Fragmentation: 3, DedupRatio: 1}, nil
+		},
+	}
+	names := []string{"tank", "missing"}
+	updateTrends(t.Context(), mockProvider, "/fake/zpool", stateDir, names, 30*24*time.Hour, metricsFile, zfsVersion{}, start)
+	free = 900
+	updateTrends(t.Context(), mockProvider, "/fake/zpool", stateDir, names, 30*24*time.Hour, metricsFile, zfsVersion{Userland: "2.3.1-1", Kernel: "2.3.0-1"}, start.Add(24*time.Hour))
+
+	st, err := loadState(stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := st.Trends["old"]; ok {
+		t.Error("Expected pools that are no longer configured to be forgotten")
+	}
+	if len(st.Trends["tank"]) != 2 || st.Trends["tank"][1].Free != 900 {
+		t.Errorf("Unexpected stored samples %+v", st.Trends["tank"])
+	}
+
+	data, err := os.ReadFile(metricsFile)
+	if err != nil {
+		t.Fatalf("Failed to read metrics file: %v", err)
+	}
+	for _, line := range []string{
+		"# TYPE zpool_free_bytes gauge\n",
+		`zfs_version_info{userland="2.3.1-1",kernel="2.3.0-1"} 1` + "\n",
+		`zpool_free_bytes{pool="tank"} 900` + "\n",
+		`zpool_free_bytes_change_per_day{pool="tank"} -100` + "\n",
+		`zpool_trend_span_seconds{pool="tank"} 86400` + "\n",
+	} {
+		if !strings.Contains(string(data), line) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", line, data)
+		}
+	}
+	if strings.Contains(string(data), "missing") {
+		t.Errorf("Expected no metrics for missing pools, got:\n%s", data)
+	}
+
+	// A zero retention disables recording and drops the stored samples.
+	updateTrends(t.Context(), mockProvider, "/fake/zpool", stateDir, names, 0, "", zfsVersion{}, start.Add(48*time.Hour))
+	if st, err = loadState(stateDir); err != nil || len(st.Trends) != 0 {
+		t.Errorf("Expected no stored samples, got %+v, %v", st.Trends, err)
+	}
+}
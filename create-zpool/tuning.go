@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const moduleParamEnvPrefix = "ZFS_PARAM_" // Prefix of environment variables setting ZFS module parameters.
+
+var (
+	moduleParamNamePattern  = regexp.MustCompile(`^[a-z0-9_]+$`)
+	moduleParamValuePattern = regexp.MustCompile(`^[A-Za-z0-9_.,:+-]+$`)
+)
+
+// parseModuleParameters collects all ZFS_PARAM_<name>=<value> environment variables into a map
+// of module parameter name to value. Invalid names or values are returned as errors.
+func parseModuleParameters() (map[string]string, []error) {
+	params := make(map[string]string)
+	var errs []error
+	for _, kv := range os.Environ() {
+		key, value, _ := strings.Cut(kv, "=")
+		name, ok := strings.CutPrefix(key, moduleParamEnvPrefix)
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if !moduleParamNamePattern.MatchString(name) {
+			errs = append(errs, fmt.Errorf("invalid ZFS module parameter name %q in %s", name, key))
+			continue
+		}
+		if !moduleParamValuePattern.MatchString(value) {
+			errs = append(errs, fmt.Errorf("invalid value %q for ZFS module parameter %s", value, name))
+			continue
+		}
+		params[name] = value
+	}
+	return params, errs
+}
+
+// applyModuleParameters writes the given ZFS module parameters, skipping those that already
+// have the requested value. Every parameter is attempted; failures are returned together.
+func applyModuleParameters(provider zfsProvider, params map[string]string) []error {
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(params)) {
+		want := params[name]
+		current, err := provider.ReadModuleParameter(name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("ZFS module parameter %s: %w", name, err))
+			continue
+		}
+		if current == want {
+			slog.Info("ZFS module parameter is up to date", "parameter", name, "value", want)
+			continue
+		}
+		if err := provider.WriteModuleParameter(name, want); err != nil {
+			errs = append(errs, fmt.Errorf("ZFS module parameter %s: %w", name, err))
+			continue
+		}
+		slog.Info("Set ZFS module parameter", "parameter", name, "from", current, "to", want)
+	}
+	return errs
+}
+
+// arcMaxFromPercent computes zfs_arc_max from ZFS_ARC_MAX_PERCENT and the node's MemTotal and
+// adds it to params. Nothing is done if the variable is unset. Combining it with an explicit
+// ZFS_PARAM_zfs_arc_max is rejected, since it is unclear which of the two is meant.
+func arcMaxFromPercent(provider zfsProvider, params map[string]string) error {
+	raw, ok := lookupEnvTrimmed("ZFS_ARC_MAX_PERCENT")
+	if !ok {
+		return nil
+	}
+	percent, err := strconv.ParseFloat(raw, 64)
+	if err != nil || percent <= 0 || percent > 100 {
+		return fmt.Errorf("invalid ZFS_ARC_MAX_PERCENT %q: must be a number greater than 0 and at most 100", raw)
+	}
+	if _, ok := params["zfs_arc_max"]; ok {
+		return fmt.Errorf("ZFS_ARC_MAX_PERCENT and %szfs_arc_max are mutually exclusive", moduleParamEnvPrefix)
+	}
+
+	memTotal, err := provider.GetMemTotal()
+	if err != nil {
+		return fmt.Errorf("failed to determine total memory for ZFS_ARC_MAX_PERCENT: %w", err)
+	}
+	arcMax := uint64(float64(memTotal) * percent / 100)
+	params["zfs_arc_max"] = strconv.FormatUint(arcMax, 10)
+	slog.Info("Computed ARC size limit", "percent", raw, "mem_total", memTotal, "zfs_arc_max", arcMax)
+	return nil
+}
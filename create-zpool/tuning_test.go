@@ -0,0 +1,140 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseModuleParameters(t *testing.T) {
+	t.Setenv("ZFS_PARAM_zfs_arc_max", " 17179869184 ")
+	t.Setenv("ZFS_PARAM_l2arc_write_max", "67108864")
+	t.Setenv("ZFS_PARAM_Bad-Name", "1")
+	t.Setenv("ZFS_PARAM_zfs_arc_min", "1 2")
+
+	params, errs := parseModuleParameters()
+	if len(params) != 2 || params["zfs_arc_max"] != "17179869184" || params["l2arc_write_max"] != "67108864" {
+		t.Errorf("parseModuleParameters() params = %v", params)
+	}
+	if len(errs) != 2 {
+		t.Errorf("Expected 2 errors for the invalid name and value, got %v", errs)
+	}
+}
+
+func TestApplyModuleParameters(t *testing.T) {
+	current := map[string]string{"zfs_arc_max": "0", "zfs_arc_min": "1073741824"}
+	var written []string
+	mockProvider := &mockZFSProvider{
+		ReadModuleParameterFunc: func(name string) (string, error) {
+			if v, ok := current[name]; ok {
+				return v, nil
+			}
+			return "", os.ErrNotExist
+		},
+		WriteModuleParameterFunc: func(name, value string) error {
+			written = append(written, name+"="+value)
+			return nil
+		},
+	}
+
+	errs := applyModuleParameters(mockProvider, map[string]string{
+		"zfs_arc_max":   "17179869184",
+		"zfs_arc_min":   "1073741824",
+		"zfs_not_there": "1",
+	})
+	if strings.Join(written, " ") != "zfs_arc_max=17179869184" {
+		t.Errorf("Expected only the changed parameter to be written, got %v", written)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "zfs_not_there") {
+		t.Errorf("Expected one error for the unknown parameter, got %v", errs)
+	}
+}
+
+func TestLiveZFSProvider_ModuleParameters(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldPath := zfsModuleParamsPath
+	zfsModuleParamsPath = tmpDir
+	t.Cleanup(func() {
+		zfsModuleParamsPath = oldPath
+	})
+
+	if err := os.WriteFile(filepath.Join(tmpDir, "zfs_arc_max"), []byte("0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	provider := &liveZFSProvider{}
+	if v, err := provider.ReadModuleParameter("zfs_arc_max"); err != nil || v != "0" {
+		t.Fatalf("ReadModuleParameter() = %q, %v; want 0", v, err)
+	}
+	if err := provider.WriteModuleParameter("zfs_arc_max", "42"); err != nil {
+		t.Fatalf("WriteModuleParameter() returned an unexpected error: %v", err)
+	}
+	if v, err := provider.ReadModuleParameter("zfs_arc_max"); err != nil || v != "42" {
+		t.Errorf("ReadModuleParameter() after write = %q, %v; want 42", v, err)
+	}
+	if _, err := provider.ReadModuleParameter("zfs_unknown"); err == nil || !strings.Contains(err.Error(), "unknown parameter") {
+		t.Errorf("Expected an unknown parameter error, got %v", err)
+	}
+	if err := provider.WriteModuleParameter("zfs_unknown", "1"); err == nil {
+		t.Error("Expected writing an unknown parameter to fail")
+	}
+}
+
+func TestArcMaxFromPercent(t *testing.T) {
+	mockProvider := &mockZFSProvider{
+		GetMemTotalFunc: func() (uint64, error) { return 64 << 30, nil },
+	}
+
+	t.Run("unset", func(t *testing.T) {
+		params := map[string]string{}
+		if err := arcMaxFromPercent(mockProvider, params); err != nil || len(params) != 0 {
+			t.Errorf("arcMaxFromPercent() = %v, params %v; want no change", err, params)
+		}
+	})
+
+	t.Run("computed", func(t *testing.T) {
+		t.Setenv("ZFS_ARC_MAX_PERCENT", "25")
+		params := map[string]string{}
+		if err := arcMaxFromPercent(mockProvider, params); err != nil {
+			t.Fatalf("arcMaxFromPercent() returned an unexpected error: %v", err)
+		}
+		if params["zfs_arc_max"] != "17179869184" {
+			t.Errorf("zfs_arc_max = %q, want 17179869184", params["zfs_arc_max"])
+		}
+	})
+
+	for _, value := range []string{"0", "101", "-5", "quarter"} {
+		t.Run("invalid "+value, func(t *testing.T) {
+			t.Setenv("ZFS_ARC_MAX_PERCENT", value)
+			if err := arcMaxFromPercent(mockProvider, map[string]string{}); err == nil {
+				t.Errorf("Expected an error for ZFS_ARC_MAX_PERCENT=%s", value)
+			}
+		})
+	}
+
+	t.Run("conflicts with explicit parameter", func(t *testing.T) {
+		t.Setenv("ZFS_ARC_MAX_PERCENT", "25")
+		params := map[string]string{"zfs_arc_max": "1073741824"}
+		if err := arcMaxFromPercent(mockProvider, params); err == nil || !strings.Contains(err.Error(), "mutually exclusive") {
+			t.Errorf("Expected a mutually exclusive error, got %v", err)
+		}
+		if params["zfs_arc_max"] != "1073741824" {
+			t.Errorf("Explicit zfs_arc_max was overwritten: %q", params["zfs_arc_max"])
+		}
+	})
+}
+
+func TestParseMemTotal(t *testing.T) {
+	meminfo := "MemTotal:       16316412 kB\nMemFree:         1234567 kB\n"
+	got, err := parseMemTotal(meminfo)
+	if err != nil || got != 16316412*1024 {
+		t.Errorf("parseMemTotal() = %d, %v; want %d", got, err, 16316412*1024)
+	}
+	if _, err := parseMemTotal("MemFree: 1 kB\n"); err == nil {
+		t.Error("Expected an error when MemTotal is missing")
+	}
+	if _, err := parseMemTotal("MemTotal: 12 MB\n"); err == nil {
+		t.Error("Expected an error for an unexpected unit")
+	}
+}
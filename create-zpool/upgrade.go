@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	upgradeSnapshotPrefix      = "zpool-upgrade-"   // Prefix of the snapshots taken before upgrading a pool.
+	defaultCheckpointRetention = 7 * 24 * time.Hour // How long upgrade checkpoints are kept, see ZPOOL_CHECKPOINT_RETENTION.
+)
+
+// upgradeSafeguard records the safety net taken before a pool was upgraded.
+type upgradeSafeguard struct {
+	Time       time.Time `json:"time"`
+	Snapshot   string    `json:"snapshot"`             // Recursive snapshot of the root dataset.
+	Checkpoint bool      `json:"checkpoint,omitempty"` // Whether the pool checkpoint taken with it is still held.
+	Features   []string  `json:"features,omitempty"`   // Features that were disabled before the upgrade.
+}
+
+// featuresPending reports whether the status text of a pool says that supported features are
+// not enabled yet. zpool only says so for features allowed by the compatibility property.
+func featuresPending(status string) bool {
+	return strings.Contains(status, "features are not enabled")
+}
+
+// disabledFeatures returns the sorted names of the features that are disabled in props, as read
+// with `zpool get all`.
+func disabledFeatures(props map[string]string) []string {
+	var features []string
+	for _, name := range slices.Sorted(maps.Keys(props)) {
+		if feature, ok := strings.CutPrefix(name, "feature@"); ok && props[name] == "disabled" {
+			features = append(features, feature)
+		}
+	}
+	return features
+}
+
+// upgradePool enables all supported features of an existing pool with `zpool upgrade`. Feature
+// flags cannot be disabled again, so a recursive snapshot and a pool checkpoint are taken first
+// and recorded in the state file. Rewinding to the checkpoint is the only way back to the old
+// feature set; the snapshot protects the data. A pool that cannot be checkpointed, e.g. because
+// a device removal is in progress, is upgraded with the snapshot only.
+func (s *runState) upgradePool(ctx context.Context, provider zfsProvider, zpoolPath string, config poolConfig) error {
+	if !config.Upgrade {
+		return nil
+	}
+	statuses, err := s.poolStatuses(provider, zpoolPath).Status(ctx, []string{config.Name})
+	if err != nil {
+		return fmt.Errorf("failed to get pool status: %w", err)
+	}
+	status, ok := statuses[config.Name]
+	if !ok {
+		return fmt.Errorf("pool %s is not listed by zpool status", config.Name)
+	}
+	if !featuresPending(status.Status) {
+		slog.Info("All supported pool features are enabled", "pool", config.Name)
+		return nil
+	}
+	if status.State != "ONLINE" {
+		slog.Warn("Not upgrading a pool that is not ONLINE", "pool", config.Name, "state", status.State)
+		return nil
+	}
+	if s.zfsPath == "" {
+		return errors.New("zfs binary not found, not upgrading the pool without a snapshot")
+	}
+
+	now := time.Now().UTC()
+	safeguard := upgradeSafeguard{Time: now, Snapshot: config.Name + "@" + upgradeSnapshotPrefix + now.Format("20060102T150405Z")}
+	if props, err := provider.GetPoolProperties(ctx, zpoolPath, config.Name, []string{"all"}); err != nil {
+		slog.Warn("Failed to read pool features", "pool", config.Name, "error", err)
+	} else {
+		safeguard.Features = disabledFeatures(props)
+	}
+
+	slog.Info("Taking a snapshot before upgrading the pool", "pool", config.Name, "snapshot", safeguard.Snapshot)
+	if output, err := provider.SnapshotRecursive(ctx, s.zfsPath, safeguard.Snapshot); err != nil {
+		return fmt.Errorf("failed to snapshot the pool before upgrading it: %w, output: %s", err, string(output))
+	}
+	if output, err := provider.CheckpointPool(ctx, zpoolPath, config.Name); err == nil {
+		safeguard.Checkpoint = true
+		slog.Info("Took a pool checkpoint before upgrading the pool", "pool", config.Name)
+	} else if strings.Contains(string(output), "checkpoint exists") {
+		// An older checkpoint reaches back even further, it is kept but not ours to discard.
+		slog.Info("Pool already has a checkpoint, keeping it", "pool", config.Name)
+	} else {
+		slog.Warn("Failed to take a pool checkpoint, upgrading with the snapshot only", "pool", config.Name, "error", err, "output", string(output))
+	}
+	if !s.dryRun {
+		if err := recordUpgradeSafeguard(s.stateDir, config.Name, safeguard); err != nil {
+			return err
+		}
+	}
+
+	slog.Info("Upgrading pool", "pool", config.Name, "features", safeguard.Features)
+	output, err := provider.UpgradePool(ctx, zpoolPath, config.Name)
+	s.invalidatePoolStatuses()
+	if err != nil {
+		return newZpoolCommandError("upgrade", err, output)
+	}
+	return nil
+}
+
+// recordUpgradeSafeguard appends a safeguard of the named pool to the state file.
+func recordUpgradeSafeguard(stateDir, pool string, safeguard upgradeSafeguard) error {
+	st, err := loadState(stateDir)
+	if err != nil {
+		return fmt.Errorf("failed to load state, not upgrading the pool: %w", err)
+	}
+	st.Upgrades[pool] = append(st.Upgrades[pool], safeguard)
+	if err := saveState(stateDir, st); err != nil {
+		return fmt.Errorf("failed to record the upgrade safeguards, not upgrading the pool: %w", err)
+	}
+	return nil
+}
+
+// discardExpiredCheckpoints discards the upgrade checkpoints of the named pools that are older
+// than retention. A checkpoint keeps all space that was allocated when it was taken and blocks
+// operations like device removal or reguid, so it is not kept forever. Checkpoints that are
+// already gone, e.g. discarded by an administrator, are only marked as such.
+func discardExpiredCheckpoints(ctx context.Context, provider zfsProvider, zpoolPath, stateDir string, names []string, retention time.Duration, now time.Time) {
+	st, err := loadState(stateDir)
+	if err != nil {
+		slog.Warn("Failed to load state, not discarding upgrade checkpoints", "state_dir", stateDir, "error", err)
+		return
+	}
+	changed := false
+	for _, name := range names {
+		for i := range st.Upgrades[name] {
+			safeguard := &st.Upgrades[name][i]
+			if !safeguard.Checkpoint || now.Sub(safeguard.Time) < retention {
+				continue
+			}
+			props, err := provider.GetPoolProperties(ctx, zpoolPath, name, []string{"checkpoint"})
+			if err != nil {
+				slog.Warn("Failed to read the pool checkpoint", "pool", name, "error", err)
+				continue
+			}
+			if held := props["checkpoint"]; held != "" && held != "-" {
+				if output, err := provider.DiscardCheckpoint(ctx, zpoolPath, name); err != nil {
+					slog.Warn("Failed to discard the upgrade checkpoint", "pool", name, "error", err, "output", string(output))
+					continue
+				}
+				slog.Info("Discarded the upgrade checkpoint", "pool", name, "taken", safeguard.Time, "retention", retention)
+			}
+			safeguard.Checkpoint = false
+			changed = true
+		}
+	}
+	if changed {
+		if err := saveState(stateDir, st); err != nil {
+			slog.Warn("Failed to save the upgrade safeguards", "state_dir", stateDir, "error", err)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const upgradableStatus = `  pool: tank
+ state: ONLINE
+status: Some supported and requested features are not enabled on the pool.
+	The pool can still be used, but some features are unavailable.
+action: Enable all features using 'zpool upgrade'. Once this is done,
+	the pool may no longer be accessible by software that does not support
+	the features. See zpool-features(7) for details.
+config:
+`
+
+func TestDisabledFeatures(t *testing.T) {
+	props := map[string]string{"feature@raidz_expansion": "disabled", "feature@async_destroy": "enabled", "feature@block_cloning": "disabled", "autotrim": "off"}
+	if got, want := disabledFeatures(props), []string{"block_cloning", "raidz_expansion"}; !slices.Equal(got, want) {
+		t.Errorf("disabledFeatures() = %v, want %v", got, want)
+	}
+}
+
+func TestUpgradePool(t *testing.T) {
+	var calls []string
+	checkpointErr := error(nil)
+	mockProvider := &mockZFSProvider{
+		GetAllPoolStatusFunc: func(ctx context.Context, zpoolPath string) ([]byte, error) {
+			return nil, errors.New("unrecognized option -j")
+		},
+		ListPoolsFunc: func(ctx context.Context, zpoolPath string) (map[string]string, error) {
+			return map[string]string{"tank": "42"}, nil
+		},
+		GetPoolStatusFunc: func(ctx context.Context, name, zpoolPath string) ([]byte, error) {
+			return []byte(upgradableStatus), nil
+		},
+		GetPoolPropertiesFunc: func(ctx context.Context, zpoolPath, name string, props []string) (map[string]string, error) {
+			return map[string]string{"feature@block_cloning": "disabled"}, nil
+		},
+		SnapshotRecursiveFunc: func(ctx context.Context, zfsPath, snapshot string) ([]byte, error) {
+			calls = append(calls, "snapshot")
+			if !strings.HasPrefix(snapshot, "tank@zpool-upgrade-") {
+				t.Errorf("Unexpected snapshot name %q", snapshot)
+			}
+			return nil, nil
+		},
+		CheckpointPoolFunc: func(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+			calls = append(calls, "checkpoint")
+			if checkpointErr != nil {
+				return []byte("cannot checkpoint 'tank': device removal in progress"), checkpointErr
+			}
+			return nil, nil
+		},
+		UpgradePoolFunc: func(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+			calls = append(calls, "upgrade")
+			return nil, nil
+		},
+	}
+	state := newRunState(nil)
+	state.zfsPath = "/fake/zfs"
+	state.stateDir = t.TempDir()
+	config := poolConfig{Name: "tank", Upgrade: true}
+
+	if err := state.upgradePool(t.Context(), mockProvider, "/fake/zpool", config); err != nil {
+		t.Fatalf("upgradePool() returned an unexpected error: %v", err)
+	}
+	if want := []string{"snapshot", "checkpoint", "upgrade"}; !slices.Equal(calls, want) {
+		t.Errorf("Unexpected calls %q, want %q", calls, want)
+	}
+
+	// Without a checkpoint the pool is still upgraded, with the snapshot as the only safety net.
+	calls, checkpointErr = nil, errors.New("exit status 1")
+	if err := state.upgradePool(t.Context(), mockProvider, "/fake/zpool", config); err != nil {
+		t.Fatalf("upgradePool() returned an unexpected error: %v", err)
+	}
+	if want := []string{"snapshot", "checkpoint", "upgrade"}; !slices.Equal(calls, want) {
+		t.Errorf("Unexpected calls %q, want %q", calls, want)
+	}
+
+	st, err := loadState(state.stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records := st.Upgrades["tank"]; len(records) != 2 || !records[0].Checkpoint || records[1].Checkpoint ||
+		!slices.Equal(records[0].Features, []string{"block_cloning"}) {
+		t.Errorf("Unexpected upgrade records %+v", records)
+	}
+
+	// Nothing is done for pools that have all features enabled. The upgrade dropped the
+	// cached status, so the new one is read.
+	calls = nil
+	mockProvider.GetPoolStatusFunc = nil
+	if err := state.upgradePool(t.Context(), mockProvider, "/fake/zpool", config); err != nil || len(calls) != 0 {
+		t.Errorf("Expected no upgrade, got %q, %v", calls, err)
+	}
+}
+
+func TestDiscardExpiredCheckpoints(t *testing.T) {
+	stateDir := t.TempDir()
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	st, err := loadState(stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Upgrades["tank"] = []upgradeSafeguard{
+		{Time: now.Add(-10 * 24 * time.Hour), Snapshot: "tank@old", Checkpoint: true},
+		{Time: now.Add(-time.Hour), Snapshot: "tank@new", Checkpoint: true},
+	}
+	st.Upgrades["gone"] = []upgradeSafeguard{{Time: now.Add(-10 * 24 * time.Hour), Snapshot: "gone@old", Checkpoint: true}}
+	if err := saveState(stateDir, st); err != nil {
+		t.Fatal(err)
+	}
+
+	var discarded []string
+	mockProvider := &mockZFSProvider{
+		GetPoolPropertiesFunc: func(ctx context.Context, zpoolPath, name string, props []string) (map[string]string, error) {
+			if name == "gone" {
+				return map[string]string{"checkpoint": "-"}, nil
+			}
+			return map[string]string{"checkpoint": "1048576"}, nil
+		},
+		DiscardCheckpointFunc: func(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+			discarded = append(discarded, name)
+			return nil, nil
+		},
+	}
+	discardExpiredCheckpoints(t.Context(), mockProvider, "/fake/zpool", stateDir, []string{"tank", "gone"}, 7*24*time.Hour, now)
+	if !slices.Equal(discarded, []string{"tank"}) {
+		t.Errorf("Expected only the expired checkpoint of tank to be discarded, got %q", discarded)
+	}
+	if st, err = loadState(stateDir); err != nil {
+		t.Fatal(err)
+	}
+	if st.Upgrades["tank"][0].Checkpoint || !st.Upgrades["tank"][1].Checkpoint || st.Upgrades["gone"][0].Checkpoint {
+		t.Errorf("Unexpected upgrade records %+v", st.Upgrades)
+	}
+}
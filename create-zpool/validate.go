@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// validatedBoolSettings and validatedDurationSettings are the global settings of these types
+// that `validate` checks, besides those checked as a group, e.g. by parseMonitorSettings.
+var (
+	validatedBoolSettings = []string{"ZPOOL_FAIL_SAFE", "ZPOOL_DROP_CAPABILITIES", "ZPOOL_FIRST_BOOT_ONLY", "ZPOOL_CREATE_DRY_RUN",
+		"ZPOOL_DIAGNOSTICS", "ZPOOL_MOUNT_DATASETS", "ZPOOL_CLEANUP_MOUNTPOINTS", "ZPOOL_RELOAD", "ZPOOL_STRICT",
+		"ZPOOL_SKIP_GAPS"}
+	validatedDurationSettings = []string{"ZPOOL_COMMAND_TIMEOUT", "ZPOOL_LOCK_WAIT", "ZPOOL_LOG_DEDUP_WINDOW", "ZPOOL_PROGRESS_INTERVAL",
+		"ZPOOL_WATCHDOG_TIMEOUT", "ZPOOL_WATCH_INTERVAL", "ZPOOL_UDEV_SETTLE_TIMEOUT", "ZPOOL_BUSY_RETRY_DELAY", "ZPOOL_LOG_MAX_LATENCY"}
+)
+
+// minVdevDisks is the least number of disks of each vdev type that zpool create accepts. dRAID
+// types are checked against their layout, see validateDraidType.
+var minVdevDisks = map[string]int{"mirror": 2, "raidz1": 2, "raidz2": 3, "raidz3": 4}
+
+// poolValidation is the result of validating the configuration of one pool.
+type poolValidation struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type,omitempty"` // For several vdevs, e.g. "mirror(2) mirror(2)".
+	Disabled bool     `json:"disabled,omitempty"`
+	Disks    int      `json:"disks"` // Configured disk entries; every model entry selects one disk.
+	Errors   []string `json:"errors,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// validationReport is the document `validate` writes.
+type validationReport struct {
+	Valid    bool             `json:"valid"`
+	Sources  []string         `json:"sources"` // The merged configuration sources, see effectiveConfig.
+	Errors   []string         `json:"errors,omitempty"`
+	Warnings []string         `json:"warnings,omitempty"`
+	Pools    []poolValidation `json:"pools"` // In the order they are processed, see orderPoolConfigs.
+}
+
+// validateConfiguration checks the whole configuration in the environment without looking at
+// the system: the global settings and every pool, including the disk counts of their layouts.
+// configFileErr is the error of reading the configuration file, if any.
+func validateConfiguration(sources []string, configFileErr error) validationReport {
+	report := validationReport{Sources: sources, Pools: []poolValidation{}}
+	for _, err := range validateSettings(configFileErr) {
+		report.Errors = append(report.Errors, err.Error())
+	}
+
+	// Unknown pools and cycles in ZPOOL_<n>_AFTER are among the ParseErrors, see orderPoolConfigs.
+	configs := parsePoolConfigs()
+	if len(configs) == 0 {
+		report.Warnings = append(report.Warnings, "no pools are configured")
+	}
+	seen := make(map[string]bool)
+	for _, config := range configs {
+		pool := poolValidation{Name: config.Name, Type: config.Type, Disabled: config.Disabled}
+		if len(config.Vdevs) > 0 {
+			pool.Type = describeVdevSpecs(config.Vdevs)
+		}
+		if seen[config.Name] {
+			pool.Errors = append(pool.Errors, fmt.Sprintf("pool %q is configured more than once", config.Name))
+		}
+		seen[config.Name] = true
+		if err := validatePoolConfig(config); err != nil {
+			pool.Errors = append(pool.Errors, err.Error())
+		}
+		disks, err := configuredDisks(config)
+		if err != nil {
+			pool.Errors = append(pool.Errors, err.Error())
+		}
+		pool.Disks = len(disks)
+		if err == nil {
+			if err := validateDiskCount(config, len(disks)); err != nil {
+				pool.Errors = append(pool.Errors, err.Error())
+			}
+		}
+		if ashift, err := strconv.Atoi(config.Ashift); err == nil && ashift != 0 && (ashift < 9 || ashift > 16) {
+			pool.Errors = append(pool.Errors, fmt.Sprintf("ashift %d is out of range, zpool accepts 0 (detect) or 9 to 16", ashift))
+		}
+		if _, err := parseSizeConditions(config.SizeFilters); err != nil {
+			pool.Errors = append(pool.Errors, err.Error())
+		}
+		if len(disks) == 0 {
+			pool.Warnings = append(pool.Warnings, "no disks are configured, the pool is only imported or reconciled if it exists")
+		}
+		report.Pools = append(report.Pools, pool)
+	}
+	// Invalid module parameters are reported by validateSettings.
+	params, _ := parseModuleParameters()
+	if err := cacheModuleParameters(configs, params); err != nil {
+		report.Errors = append(report.Errors, err.Error())
+	}
+
+	report.Valid = len(report.Errors) == 0
+	for _, pool := range report.Pools {
+		report.Valid = report.Valid && len(pool.Errors) == 0
+	}
+	return report
+}
+
+// validateSettings checks the global settings a create run reads.
+func validateSettings(configFileErr error) []error {
+	var errs []error
+	if configFileErr != nil {
+		errs = append(errs, configFileErr)
+	}
+	for _, key := range validatedBoolSettings {
+		if _, err := getEnvBool(key, false); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, key := range validatedDurationSettings {
+		if _, err := getEnvDuration(key, 0); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if _, err := getEnvUint("ZPOOL_BUSY_RETRIES", defaultBusyRetries); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := poolLimit(); err != nil {
+		errs = append(errs, err)
+	}
+	if policy := getEnv("ZPOOL_RECOVERY", recoveryNone); !isValidRecoveryPolicy(policy) {
+		errs = append(errs, fmt.Errorf("unsupported ZPOOL_RECOVERY policy %q, valid are %v", policy, recoveryPolicies))
+	}
+	if policy := getEnv("ZPOOL_GUID_MISMATCH", guidMismatchRefuse); !isValidGUIDMismatchPolicy(policy) {
+		errs = append(errs, fmt.Errorf("unsupported ZPOOL_GUID_MISMATCH policy %q, valid are %v", policy, guidMismatchPolicies))
+	}
+	if policy := getEnv("ZPOOL_LOG_LATENCY_CHECK", logLatencyWarn); !isValidLogLatencyPolicy(policy) {
+		errs = append(errs, fmt.Errorf("unsupported ZPOOL_LOG_LATENCY_CHECK policy %q, valid are %v", policy, logLatencyPolicies))
+	}
+	if policy := getEnv("ZPOOL_VERSION_MISMATCH", versionMismatchWarn); policy != versionMismatchWarn && policy != versionMismatchRefuse {
+		errs = append(errs, fmt.Errorf("ZPOOL_VERSION_MISMATCH must be warn or refuse, got %q", policy))
+	}
+	if err := parseParallelismLimits(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := parseBackoffSettings(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := parseMonitorSettings(); err != nil {
+		errs = append(errs, err)
+	}
+	_, paramErrs := parseModuleParameters()
+	return append(errs, paramErrs...)
+}
+
+// validateDiskCount checks that a pool of the configured type can be created from disks
+// configured disk entries. Without disks, there is nothing to create.
+func validateDiskCount(config poolConfig, disks int) error {
+	if disks == 0 {
+		return nil
+	}
+	if layout, ok, err := parseDraidType(config.Type); ok {
+		if err != nil || layout.validate() != nil || (layout.Children > 0 && layout.Children != disks) {
+			return nil // An invalid layout and a children mismatch are reported by validatePoolConfig.
+		}
+		return layout.validateDisks(disks)
+	}
+	vdevType := normalizeVdevType(config.Type)
+	if need, ok := minVdevDisks[vdevType]; ok && disks < need {
+		return fmt.Errorf("%d configured disks are too few for a %s vdev, at least %d are required", disks, vdevType, need)
+	}
+	return nil
+}
+
+// validateMain implements the `validate` mode: it writes the validation report as JSON to w and
+// exits with exitConfigError if the configuration is invalid. Nothing but the environment and
+// the configuration file is read, so that configurations can be checked anywhere, e.g. in CI.
+func validateMain(w io.Writer, sources []string, configFileErr error) int {
+	report := validateConfiguration(sources, configFileErr)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if !report.Valid {
+		return exitConfigError
+	}
+	return exitConverged
+}
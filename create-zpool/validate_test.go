@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfiguration(t *testing.T) {
+	t.Setenv("ZPOOL_0_NAME", "tank")
+	t.Setenv("ZPOOL_0_TYPE", "mirror")
+	t.Setenv("ZPOOL_0_DISKS", "/dev/sda /dev/sdb")
+	t.Setenv("ZPOOL_0_CACHE_DISKS", "/dev/nvme0n1")
+	t.Setenv("ZPOOL_0_CACHE_PARAMS", "l2arc_write_max=67108864")
+	t.Setenv("ZFS_PARAM_l2arc_write_max", "134217728")
+	t.Setenv("ZPOOL_1_NAME", "data")
+	t.Setenv("ZPOOL_1_TYPE", "raidz2")
+	t.Setenv("ZPOOL_1_DISK_0_MODEL", "ST16000NM*")
+	t.Setenv("ZPOOL_1_DISK_1_MODEL", "ST16000NM*")
+	t.Setenv("ZPOOL_1_ASHIFT", "17")
+	t.Setenv("ZPOOL_1_AFTER", "tank")
+	t.Setenv("ZPOOL_2_NAME", "tank")
+	t.Setenv("ZPOOL_3_NAME", "scratch")
+	t.Setenv("ZPOOL_3_AFTER", "missing")
+	t.Setenv("ZPOOL_LOCK_WAIT", "soon")
+
+	report := validateConfiguration([]string{"env"}, errors.New("invalid configuration file"))
+	if report.Valid {
+		t.Fatal("Expected an invalid configuration")
+	}
+	if len(report.Errors) != 3 || !strings.Contains(report.Errors[1], "ZPOOL_LOCK_WAIT") || !strings.Contains(report.Errors[2], "cache parameter l2arc_write_max") {
+		t.Errorf("Unexpected global errors %q", report.Errors)
+	}
+	// In processing order: data comes after both pools named tank.
+	want := []struct {
+		name string
+		errs []string
+	}{
+		{"tank", nil},
+		{"tank", []string{"configured more than once"}},
+		{"data", []string{"2 configured disks are too few for a raidz2 vdev", "ashift 17 is out of range"}},
+		{"scratch", []string{`unknown pool "missing"`}},
+	}
+	if len(report.Pools) != len(want) {
+		t.Fatalf("Expected %d pools, got %+v", len(want), report.Pools)
+	}
+	for i, w := range want {
+		pool := report.Pools[i]
+		if pool.Name != w.name || len(pool.Errors) != len(w.errs) {
+			t.Errorf("Pool %d: %q with errors %q, want %q with %q", i, pool.Name, pool.Errors, w.name, w.errs)
+			continue
+		}
+		for j, msg := range w.errs {
+			if !strings.Contains(pool.Errors[j], msg) {
+				t.Errorf("Pool %d: error %q, want it to contain %q", i, pool.Errors[j], msg)
+			}
+		}
+	}
+	if report.Pools[0].Disks != 2 || len(report.Pools[1].Warnings) != 1 {
+		t.Errorf("Unexpected disks or warnings %+v", report.Pools[:2])
+	}
+}
+
+func TestValidateMain(t *testing.T) {
+	t.Setenv("ZPOOL_0_NAME", "tank")
+	t.Setenv("ZPOOL_0_TYPE", "draid1")
+	t.Setenv("ZPOOL_0_DISKS", "/dev/sda /dev/sdb /dev/sdc")
+
+	var out strings.Builder
+	if code := validateMain(&out, []string{"env"}, nil); code != exitConverged {
+		t.Fatalf("validateMain() = %d, want %d:\n%s", code, exitConverged, out.String())
+	}
+	var report validationReport
+	if err := json.Unmarshal([]byte(out.String()), &report); err != nil || !report.Valid || len(report.Pools) != 1 {
+		t.Errorf("Unexpected report %s: %v", out.String(), err)
+	}
+
+	t.Setenv("ZPOOL_0_TYPE", "draid1:4c")
+	if code := validateMain(&strings.Builder{}, []string{"env"}, nil); code != exitConfigError {
+		t.Errorf("validateMain() = %d, want %d", code, exitConfigError)
+	}
+}
@@ -0,0 +1,600 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// vdevClasses are the allocation classes besides the data vdevs a pool can be configured with,
+// in the order their vdevs are added.
+var vdevClasses = []string{"log", "special", "cache"}
+
+// vdevClassTypes are the vdev types each allocation class accepts. An empty type adds every disk
+// as a vdev of its own, the only way zpool adds cache devices.
+var vdevClassTypes = map[string][]string{
+	"log":     {"", "mirror"},
+	"special": {"", "mirror"},
+	"cache":   {""},
+}
+
+// classVdev returns the configured vdev of an allocation class of the pool, see vdevClasses.
+func (c poolConfig) classVdev(class string) vdevSpec {
+	switch class {
+	case "log":
+		return c.Log
+	case "special":
+		return c.Special
+	case "cache":
+		return c.Cache
+	}
+	return vdevSpec{}
+}
+
+// classAshift returns the ashift of the vdev of an allocation class of the pool: its own, or
+// else the pool's. Devices added to an existing pool use it as well, so that they match the sector
+// size the class was created with.
+func (c poolConfig) classAshift(class string) string {
+	return cmp.Or(c.classVdev(class).Ashift, c.Ashift)
+}
+
+// parseClassVdev reads the vdev of an allocation class of pool i, e.g. ZPOOL_<i>_LOG_TYPE,
+// ZPOOL_<i>_LOG_DISKS and ZPOOL_<i>_LOG_ASHIFT for the log. Placeholders in the disks are expanded, see
+// expandDiskTemplate.
+func parseClassVdev(i int, class string, facts func() map[string]string) (vdevSpec, error) {
+	prefix := fmt.Sprintf("ZPOOL_%d_%s_", i, strings.ToUpper(class))
+	vdev := vdevSpec{Type: strings.TrimSpace(os.Getenv(prefix + "TYPE")), Ashift: strings.TrimSpace(os.Getenv(prefix + "ASHIFT"))}
+	diskList, err := expandDiskTemplate(strings.TrimSpace(os.Getenv(prefix+"DISKS")), facts)
+	vdev.DiskList = diskList
+	return vdev, err
+}
+
+// validateClassVdev checks the vdev of an allocation class: its type, its number of disks,
+// and that none of its disks is also configured for another vdev of the pool, taken. A vdev
+// without a type or disks is not configured.
+func validateClassVdev(class string, vdev vdevSpec, taken []diskSpec) error {
+	if vdev.Type == "" && vdev.DiskList == "" {
+		return nil
+	}
+	if err := validateVdevAshift(vdev.Ashift); err != nil {
+		return fmt.Errorf("%s: %w", class, err)
+	}
+	if types := vdevClassTypes[class]; !slices.Contains(types, vdev.Type) {
+		if len(types) == 1 {
+			return fmt.Errorf("invalid %s type: %q, must be empty, every %s disk is a vdev of its own", class, vdev.Type, class)
+		}
+		return fmt.Errorf("invalid %s type: %q, must be empty or one of %v", class, vdev.Type, types[1:])
+	}
+	disks, err := vdev.disks()
+	if err != nil {
+		return fmt.Errorf("invalid %s disk list %q: %w", class, vdev.DiskList, err)
+	}
+	if len(disks) == 0 {
+		return fmt.Errorf("no %s disks are configured", class)
+	}
+	if err := validateVdevDisks(vdev.Type, len(disks)); err != nil {
+		return fmt.Errorf("%s: %w", class, err)
+	}
+	for _, disk := range disks {
+		if disk.Dev != "" && slices.ContainsFunc(taken, func(d diskSpec) bool { return filepath.Clean(d.Dev) == filepath.Clean(disk.Dev) }) {
+			return fmt.Errorf("%s disk %s is also configured for another vdev of the pool", class, disk.Dev)
+		}
+	}
+	return nil
+}
+
+// validateSpecialRedundancy checks that the special vdev of a pool survives as many failed
+// disks as its data vdevs do: the pool's metadata lives on it, so losing it loses the whole
+// pool, however redundant the data vdevs are.
+func validateSpecialRedundancy(config poolConfig) error {
+	disks, err := config.Special.disks()
+	if err != nil || len(disks) == 0 {
+		return nil // Reported by validateClassVdev.
+	}
+	need, ok := dataRedundancy(config)
+	if !ok {
+		return nil
+	}
+	if have := vdevRedundancy(config.Special.Type, len(disks)); have < need {
+		return fmt.Errorf("the special vdev survives %d failed disks, the data vdevs %d, and losing it loses the pool: configure ZPOOL_<n>_SPECIAL_TYPE=mirror with at least %d disks", have, need, need+1)
+	}
+	return nil
+}
+
+const (
+	maxSmallBlocks    = 16 << 20  // Largest special_small_blocks value, the largest block size of ZFS.
+	defaultRecordsize = 128 << 10 // recordsize of a dataset that does not set one.
+)
+
+// validBlockSize reports whether size is a power of two from 512 bytes to 16M.
+func validBlockSize(size uint64) bool {
+	return size >= 512 && size <= maxSmallBlocks && size&(size-1) == 0
+}
+
+// validateSmallBlocks checks the recordsize and special_small_blocks values of a pool. Both
+// are a power of two from 512 bytes to 16M, special_small_blocks may also be 0. It only means
+// something with a special vdev to put the small blocks on, and must be below the recordsize:
+// otherwise every data block is small and the special vdev gets all data.
+func validateSmallBlocks(config poolConfig) error {
+	recordsize := uint64(defaultRecordsize)
+	if config.Recordsize != "" {
+		size, err := parseSizeInBytes(config.Recordsize)
+		if err != nil {
+			return fmt.Errorf("invalid recordsize value %q: %w", config.Recordsize, err)
+		}
+		if !validBlockSize(size) {
+			return fmt.Errorf("invalid recordsize value %q, must be a power of two from 512 to 16M", config.Recordsize)
+		}
+		recordsize = size
+	}
+	if config.SmallBlocks == "" {
+		return nil
+	}
+	if config.Special.DiskList == "" {
+		return fmt.Errorf("special_small_blocks=%s requires a special vdev, configure ZPOOL_<n>_SPECIAL_DISKS", config.SmallBlocks)
+	}
+	size, err := parseSizeInBytes(config.SmallBlocks)
+	if err != nil {
+		return fmt.Errorf("invalid special_small_blocks value %q: %w", config.SmallBlocks, err)
+	}
+	if size != 0 && !validBlockSize(size) {
+		return fmt.Errorf("invalid special_small_blocks value %q, must be 0 or a power of two from 512 to 16M", config.SmallBlocks)
+	}
+	if size >= recordsize {
+		return fmt.Errorf("special_small_blocks=%s is not below the recordsize of %s, all data would be stored on the special vdev", config.SmallBlocks, formatSize(recordsize))
+	}
+	return nil
+}
+
+// dataRedundancy returns the number of failed disks the least redundant data vdev of a pool
+// survives, see vdevRedundancy. ok is false if the pool has no valid data vdevs configured.
+func dataRedundancy(config poolConfig) (redundancy int, ok bool) {
+	if len(config.Vdevs) == 0 {
+		disks, err := configuredDisks(config)
+		if err != nil || len(disks) == 0 {
+			return 0, false
+		}
+		return vdevRedundancy(config.Type, len(disks)), true
+	}
+	for m, vdev := range config.Vdevs {
+		disks, err := vdev.disks()
+		if err != nil {
+			return 0, false
+		}
+		if r := vdevRedundancy(vdev.Type, len(disks)); m == 0 || r < redundancy {
+			redundancy = r
+		}
+	}
+	return redundancy, true
+}
+
+// vdevRedundancy returns the number of disks a vdev of the given type built from disks can
+// lose without losing data: its parity for RAID-Z and dRAID, all but one disk for a mirror, and
+// none for plain disks.
+func vdevRedundancy(vdevType string, disks int) int {
+	if layout, ok, err := parseDraidType(vdevType); ok {
+		if err != nil {
+			return 0
+		}
+		return layout.Parity
+	}
+	switch normalizeVdevType(vdevType) {
+	case "mirror":
+		return max(disks-1, 0)
+	case "raidz1":
+		return 1
+	case "raidz2":
+		return 2
+	case "raidz3":
+		return 3
+	}
+	return 0
+}
+
+// selectClassDisks selects the devices of the vdev of an allocation class. It runs after the
+// data disks were selected, so that no disk is picked twice. Unlike data disks, every
+// configured device must be usable, since a pool without its log or cache or with a less
+// redundant special vdev is not what was asked for. On error, none of the devices are left marked as used.
+func selectClassDisks(provider zfsProvider, pool, class string, vdev vdevSpec, usedDisks map[string]bool) ([]string, error) {
+	disks, err := vdev.disks()
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s disk list %q: %w", class, vdev.DiskList, err)
+	}
+	slog.Info("Probing specified disks", "pool", pool, "class", class, "disks", disks)
+	// Size filters are meant for the data disks, the devices of the classes are usually much smaller.
+	selected, unusable := selectDisks(provider, pool, disks, nil, usedDisks)
+	if len(unusable) > 0 {
+		for _, dev := range selected {
+			delete(usedDisks, dev)
+		}
+		return nil, fmt.Errorf("%d of %d configured %s disks are unusable: %s", len(unusable), len(disks), class, strings.Join(unusable, ", "))
+	}
+	return selected, nil
+}
+
+// Policies for log devices that are slow at synchronous writes, selected with
+// ZPOOL_LOG_LATENCY_CHECK.
+const (
+	logLatencyOff    = "off"    // Do not probe log devices.
+	logLatencyWarn   = "warn"   // Log a warning and use the device anyway.
+	logLatencyRefuse = "refuse" // Fail the pool.
+)
+
+// logLatencyPolicies lists the supported log latency policies.
+var logLatencyPolicies = []string{logLatencyOff, logLatencyWarn, logLatencyRefuse}
+
+const (
+	defaultLogMaxLatency = 5 * time.Millisecond // Median synchronous write latency above which a log device is too slow, see ZPOOL_LOG_MAX_LATENCY.
+	syncProbeWrites      = 16                   // Number of synchronous writes of a latency probe.
+	syncProbeBlockSize   = 4096                 // Size of each of those writes.
+)
+
+// isValidLogLatencyPolicy checks if the given log latency policy is supported.
+func isValidLogLatencyPolicy(policy string) bool {
+	return slices.Contains(logLatencyPolicies, policy)
+}
+
+// checkLogLatency probes the synchronous write latency of the devices picked for the log of a
+// pool. Every synchronous write of the pool waits for its log, so a log on e.g. a spinning disk
+// that was referenced by accident makes the whole pool slower instead of faster. A device whose
+// median latency exceeds the limit is reported, or refused with the refuse policy. A device
+// that cannot be probed is used as is. Nothing is probed in a dry run.
+func (s *runState) checkLogLatency(provider zfsProvider, pool string, devices []string) error {
+	if s.logLatencyPolicy == "" || s.logLatencyPolicy == logLatencyOff || s.dryRun {
+		return nil
+	}
+	limit := cmp.Or(s.logMaxLatency, defaultLogMaxLatency)
+	var slow []string
+	for _, dev := range devices {
+		latency, err := provider.ProbeSyncWriteLatency(dev, syncProbeWrites)
+		if err != nil {
+			slog.Warn("Failed to probe the synchronous write latency of a log device", "pool", pool, "device", dev, "error", err)
+			continue
+		}
+		if latency <= limit {
+			slog.Info("Log device latency", "pool", pool, "device", dev, "latency", latency)
+			continue
+		}
+		slog.Warn("Log device is slow at synchronous writes and will slow down the pool", "pool", pool, "device", dev, "latency", latency, "limit", limit)
+		slow = append(slow, fmt.Sprintf("%s (%s)", dev, latency))
+	}
+	if len(slow) > 0 && s.logLatencyPolicy == logLatencyRefuse {
+		return fmt.Errorf("log devices take longer than %s per synchronous write: %s", limit, strings.Join(slow, ", "))
+	}
+	return nil
+}
+
+// classVdevArgs returns the arguments of `zpool create` for the vdev of an allocation class
+// built from disks, e.g. "log mirror /dev/nvme0n1 /dev/nvme1n1".
+func classVdevArgs(class string, vdev vdevSpec, disks []string) []string {
+	args := []string{class}
+	if vdev.Type != "" {
+		args = append(args, vdev.Type)
+	}
+	return append(args, disks...)
+}
+
+// classVdevs returns the top-level vdevs of an allocation class, e.g. "log": those in the vdev
+// tree with that class, and those zpool lists in a section of their own, e.g. "logs",
+// "special" or "l2cache".
+func (s *poolStatus) classVdevs(class string) []*vdevStatus {
+	var vdevs []*vdevStatus
+	if root, ok := s.Vdevs[s.Name]; ok {
+		for _, name := range slices.Sorted(maps.Keys(root.Vdevs)) {
+			if vdev := root.Vdevs[name]; vdev.Class == class || vdev.Class == class+"s" {
+				vdevs = append(vdevs, vdev)
+			}
+		}
+	}
+	sections := map[string]map[string]*vdevStatus{"log": s.Logs, "special": s.Special, "cache": s.L2Cache}
+	for _, name := range slices.Sorted(maps.Keys(sections[class])) {
+		vdevs = append(vdevs, sections[class][name])
+	}
+	return vdevs
+}
+
+// auditClassVdev compares the configured vdev of an allocation class with the pool's. Pools
+// without one configured are not checked, their class vdevs may have been added by hand, and
+// neither are pools whose vdev tree is unknown, see dataVdevs.
+func auditClassVdev(config poolConfig, class string, vdev vdevSpec, status *poolStatus) []driftItem {
+	if vdev.DiskList == "" || status.dataVdevs() == nil {
+		return nil
+	}
+	want := describeVdevSpecs([]vdevSpec{vdev})
+	have := describeActualVdevs(status.classVdevs(class))
+	if have == "" {
+		have = "none"
+	}
+	if want == have {
+		return nil
+	}
+	return []driftItem{{Pool: config.Name, Field: class, Want: want, Have: have}}
+}
+
+// classDevice is a device of an allocation class of an existing pool, see reconcileLog and
+// reconcileCache.
+type classDevice struct {
+	vdev   *vdevStatus // Top-level vdev the device belongs to, the device itself unless mirrored.
+	leaf   *vdevStatus
+	wanted bool // Whether the device is one of the configured devices of the class.
+}
+
+// name returns how zpool commands refer to the device.
+func (d classDevice) name() string {
+	return cmp.Or(d.leaf.Path, d.leaf.Name)
+}
+
+// reconcileLog changes the log of an existing pool to the configured one, e.g. after a worn-out
+// log device was replaced by a new one in the configuration. Configured devices the log lacks
+// are added first, so that the pool keeps a log throughout: attached to the log mirror, or with
+// `zpool add`. Then the layout is changed: a mirror is split with `zpool detach` for a log of
+// single devices, and single devices are merged into the mirror for a mirrored log. Last, log
+// devices that are not configured are detached from a mirror that keeps configured ones, or
+// removed with `zpool remove`. A pool without a configured log is left alone, so a log added by
+// hand is kept. Like on creation, every device to add must be usable and fast enough, see
+// checkLogLatency.
+func reconcileLog(ctx context.Context, provider zfsProvider, zpoolPath string, config poolConfig, state *runState) error {
+	if config.Log.DiskList == "" {
+		return nil
+	}
+	statuses, err := state.poolStatuses(provider, zpoolPath).Status(ctx, []string{config.Name})
+	if err != nil {
+		slog.Warn("Failed to read the pool status, not checking the log", "pool", config.Name, "error", err)
+		return nil
+	}
+	status, ok := statuses[config.Name]
+	if !ok || status.Vdevs == nil {
+		// Without the vdev tree, e.g. from zpool versions without JSON output, the log is unknown.
+		return nil
+	}
+	disks, err := config.Log.disks()
+	if err != nil {
+		return fmt.Errorf("invalid log disk list %q: %w", config.Log.DiskList, err)
+	}
+
+	devices, missing := matchClassDevices(provider, status.classVdevs("log"), disks)
+	r := logReconciler{ctx: ctx, provider: provider, zpoolPath: zpoolPath, config: config, state: state, removed: make(map[*vdevStatus]bool)}
+	if config.Log.Type == "mirror" {
+		err = r.reconcileMirror(devices, missing)
+	} else {
+		err = r.reconcileSingle(devices, missing)
+	}
+	if err != nil {
+		return err
+	}
+	return r.removeUnwanted(devices)
+}
+
+// matchClassDevices matches the devices of the top-level vdevs of an allocation class of a pool
+// against the configured disks of the class. A device matches a configured path, or the query of a model entry, each of which
+// stands for one disk. The configured disks no device matches are returned as missing.
+func matchClassDevices(provider zfsProvider, vdevs []*vdevStatus, disks []diskSpec) (devices []classDevice, missing []diskSpec) {
+	matched := make([]bool, len(disks))
+	for _, vdev := range vdevs {
+		for _, leaf := range vdev.leaves() {
+			device := classDevice{vdev: vdev, leaf: leaf}
+			for i, disk := range disks {
+				if matched[i] {
+					continue
+				}
+				if disk.Dev != "" {
+					device.wanted = leafMatchesDisk(provider, leaf, filepath.Base(disk.Dev)) || leafMatchesDisk(provider, leaf, resolvedDiskName(provider, disk.Dev))
+				} else if model, err := provider.GetDiskModel(device.name()); err == nil {
+					device.wanted = modelMatches(disk.Model, model)
+				}
+				if device.wanted {
+					matched[i] = true
+					break
+				}
+			}
+			devices = append(devices, device)
+		}
+	}
+	for i, disk := range disks {
+		if !matched[i] {
+			missing = append(missing, disk)
+		}
+	}
+	return devices, missing
+}
+
+// logReconciler runs the zpool commands that change the log of an existing pool.
+type logReconciler struct {
+	ctx       context.Context
+	provider  zfsProvider
+	zpoolPath string
+	config    poolConfig
+	state     *runState
+	removed   map[*vdevStatus]bool // Top-level log vdevs removed from the pool so far.
+}
+
+// ashift returns the ashift of new log devices, see classAshift.
+func (r logReconciler) ashift() string {
+	return r.config.classAshift("log")
+}
+
+// selectMissing probes the configured log disks the log lacks and returns their devices.
+func (r logReconciler) selectMissing(missing []diskSpec) ([]string, error) {
+	if len(missing) == 0 {
+		return nil, nil
+	}
+	slog.Info("Probing log disks to add", "pool", r.config.Name, "disks", missing)
+	selected, unusable := selectDisks(r.provider, r.config.Name, missing, nil, r.state.usedDisks)
+	if len(unusable) > 0 {
+		for _, dev := range selected {
+			delete(r.state.usedDisks, dev)
+		}
+		return nil, fmt.Errorf("%d of %d log disks to add are unusable: %s", len(unusable), len(missing), strings.Join(unusable, ", "))
+	}
+	if err := r.state.checkLogLatency(r.provider, r.config.Name, selected); err != nil {
+		for _, dev := range selected {
+			delete(r.state.usedDisks, dev)
+		}
+		return nil, err
+	}
+	return selected, nil
+}
+
+// reconcileMirror turns the log into a single mirror of the configured devices. The missing
+// devices are attached to the first top-level log vdev that holds a configured device, or added
+// as a new mirror if there is none. Configured devices in other top-level log vdevs are removed
+// from the pool and attached to the mirror.
+func (r logReconciler) reconcileMirror(devices []classDevice, missing []diskSpec) error {
+	var base *classDevice
+	for i := range devices {
+		if devices[i].wanted {
+			base = &devices[i]
+			break
+		}
+	}
+	selected, err := r.selectMissing(missing)
+	if err != nil {
+		return err
+	}
+	if base == nil {
+		if len(selected) == 0 {
+			return nil
+		}
+		args := []string{"log"}
+		if len(selected) > 1 {
+			args = append(args, "mirror")
+		}
+		return r.add(append(args, selected...), "Adding the configured log mirror")
+	}
+	for _, dev := range selected {
+		if err := r.attach(base.name(), dev); err != nil {
+			return err
+		}
+	}
+	for _, device := range devices {
+		if !device.wanted || device.vdev == base.vdev {
+			continue
+		}
+		slog.Info("Moving a log device into the log mirror", "pool", r.config.Name, "device", device.name())
+		if !r.removed[device.vdev] {
+			if err := r.remove(device); err != nil {
+				return err
+			}
+		}
+		if err := r.attach(base.name(), device.name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcileSingle turns the log into single devices. The missing devices are added, and the
+// configured devices of a log mirror are detached from it and added on their own.
+func (r logReconciler) reconcileSingle(devices []classDevice, missing []diskSpec) error {
+	selected, err := r.selectMissing(missing)
+	if err != nil {
+		return err
+	}
+	if len(selected) > 0 {
+		if err := r.add(append([]string{"log"}, selected...), "Adding configured log devices"); err != nil {
+			return err
+		}
+	}
+	kept := make(map[*vdevStatus]bool)
+	for _, device := range devices {
+		if !device.wanted || device.vdev == device.leaf {
+			continue
+		}
+		if !kept[device.vdev] {
+			// The first configured device stays in place of the mirror.
+			kept[device.vdev] = true
+			continue
+		}
+		slog.Info("Splitting a log device off the log mirror", "pool", r.config.Name, "device", device.name())
+		if err := r.detach(device.name()); err != nil {
+			return err
+		}
+		if err := r.add([]string{"log", device.name()}, "Adding a log device on its own"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeUnwanted takes the log devices that are not configured out of the pool. A device
+// is detached from a log mirror that keeps a configured device, otherwise its top-level vdev is
+// removed from the pool.
+func (r logReconciler) removeUnwanted(devices []classDevice) error {
+	for _, device := range devices {
+		if device.wanted || r.removed[device.vdev] {
+			continue
+		}
+		keepsWanted := slices.ContainsFunc(devices, func(d classDevice) bool { return d.vdev == device.vdev && d.wanted })
+		if device.vdev != device.leaf && keepsWanted {
+			slog.Info("Detaching a log device that is not configured", "pool", r.config.Name, "device", device.name())
+			if err := r.detach(device.name()); err != nil {
+				return err
+			}
+			continue
+		}
+		slog.Info("Removing a log vdev that is not configured", "pool", r.config.Name, "device", device.name())
+		if err := r.remove(device); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add adds the log vdevs of args, e.g. "log mirror /dev/nvme0n1 /dev/nvme1n1", to the pool.
+func (r logReconciler) add(args []string, message string) error {
+	cmdArgs := []string{"add"}
+	if ashift := r.ashift(); ashift != "" && ashift != "0" {
+		cmdArgs = append(cmdArgs, "-o", "ashift="+ashift)
+	}
+	cmdArgs = append(append(cmdArgs, r.config.Name), args...)
+	slog.Info(message, "pool", r.config.Name, "args", strings.Join(cmdArgs, " "))
+	output, err := r.provider.AddVdevs(r.ctx, r.zpoolPath, cmdArgs)
+	r.state.invalidatePoolStatuses()
+	if err != nil {
+		return fmt.Errorf("zpool add of log %s failed: %w. Output: %s", strings.Join(args[1:], " "), err, string(output))
+	}
+	return nil
+}
+
+// attach mirrors the log device device onto newDevice.
+func (r logReconciler) attach(device, newDevice string) error {
+	slog.Info("Attaching a log device to mirror the existing one", "pool", r.config.Name, "device", device, "new_device", newDevice, "ashift", r.ashift())
+	output, err := r.provider.AttachDevice(r.ctx, r.zpoolPath, r.config.Name, device, newDevice, r.ashift())
+	r.state.invalidatePoolStatuses()
+	if err != nil {
+		return fmt.Errorf("zpool attach of log device %s failed: %w. Output: %s", newDevice, err, string(output))
+	}
+	return nil
+}
+
+// detach detaches the log device device from its mirror.
+func (r logReconciler) detach(device string) error {
+	output, err := r.provider.DetachDevice(r.ctx, r.zpoolPath, r.config.Name, device)
+	r.state.invalidatePoolStatuses()
+	if err != nil {
+		return fmt.Errorf("zpool detach of log device %s failed: %w. Output: %s", device, err, string(output))
+	}
+	return nil
+}
+
+// remove removes the top-level log vdev of device from the pool, with all its devices.
+func (r logReconciler) remove(device classDevice) error {
+	target := device.name()
+	if device.vdev != device.leaf {
+		target = device.vdev.Name
+	}
+	r.removed[device.vdev] = true
+	output, err := r.provider.RemoveVdev(r.ctx, r.zpoolPath, r.config.Name, target)
+	r.state.invalidatePoolStatuses()
+	if err != nil {
+		return fmt.Errorf("zpool remove of log %s failed: %w. Output: %s", target, err, string(output))
+	}
+	return nil
+}
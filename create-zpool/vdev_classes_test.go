@@ -0,0 +1,513 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateClassVdev(t *testing.T) {
+	data := []diskSpec{{Dev: "/dev/sda"}, {Model: "Samsung*"}}
+	tests := []struct {
+		name    string
+		vdev    vdevSpec
+		wantErr string
+	}{
+		{"none", vdevSpec{}, ""},
+		{"single", vdevSpec{DiskList: "/dev/nvme0n1"}, ""},
+		{"mirror", vdevSpec{Type: "mirror", DiskList: "/dev/nvme0n1 /dev/nvme1n1"}, ""},
+		{"raidz", vdevSpec{Type: "raidz1", DiskList: "/dev/nvme0n1 /dev/nvme1n1"}, "invalid log type"},
+		{"too few", vdevSpec{Type: "mirror", DiskList: "/dev/nvme0n1"}, "at least 2"},
+		{"no disks", vdevSpec{Type: "mirror"}, "no log disks"},
+		{"data disk", vdevSpec{DiskList: "/dev/nvme0n1 /dev//sda"}, "/dev//sda is also configured for another vdev"},
+	}
+	for _, tt := range tests {
+		err := validateClassVdev("log", tt.vdev, data)
+		if (err == nil) != (tt.wantErr == "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: validateClassVdev() = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCreatePool_Log(t *testing.T) {
+	var gotArgs string
+	mockProvider := &mockZFSProvider{
+		IsBlockDeviceFunc: func(path string) (bool, error) { return path != "/dev/nvme9n1", nil },
+		CreatePoolFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+			gotArgs = strings.Join(args, " ")
+			return nil, nil
+		},
+	}
+	config := poolConfig{
+		Name:   "tank",
+		Type:   "mirror",
+		Ashift: "12",
+		Disks:  []diskSpec{{Dev: "/dev/sda"}, {Dev: "/dev/sdb"}},
+		Log:    vdevSpec{Type: "mirror", DiskList: "/dev/nvme0n1 /dev/nvme1n1"},
+	}
+	state := newRunState(nil)
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, state); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	if !strings.HasSuffix(gotArgs, "tank mirror /dev/sda /dev/sdb log mirror /dev/nvme0n1 /dev/nvme1n1") {
+		t.Errorf("Unexpected create args %q", gotArgs)
+	}
+	if !state.usedDisks["/dev/nvme1n1"] {
+		t.Errorf("Expected the log disks to be marked as used, got %v", state.usedDisks)
+	}
+
+	// A data disk cannot be a log disk as well, and a missing log disk fails the pool.
+	// A data disk selected by model is only found to be a log disk as well at runtime.
+	config.Disks[1] = diskSpec{Model: "Samsung*"}
+	mockProvider.ResolveDiskByModelFunc = func(model string, sizeConds []sizeCondition, usedDisks map[string]bool) (string, error) {
+		return "/dev/sdb", nil
+	}
+	for logDisks, wantErr := range map[string]string{
+		"/dev/nvme0n1 /dev/sda":     "also configured for another vdev",
+		"/dev/nvme0n1 /dev/sdb":     "log disks are unusable",
+		"/dev/nvme0n1 /dev/nvme9n1": "log disks are unusable",
+	} {
+		config.Log.DiskList = logDisks
+		gotArgs = ""
+		state := newRunState(nil)
+		if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, state); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("Log disks %s: expected an error containing %q, got %v", logDisks, wantErr, err)
+		}
+		if gotArgs != "" || len(state.usedDisks) != 0 {
+			t.Errorf("Log disks %s: expected no pool and no used disks, got %q and %v", logDisks, gotArgs, state.usedDisks)
+		}
+	}
+}
+
+func TestCreatePool_ClassAshift(t *testing.T) {
+	for _, class := range vdevClasses {
+		t.Run(class, func(t *testing.T) {
+			var calls []string
+			record := func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+				calls = append(calls, strings.Join(args, " "))
+				return nil, nil
+			}
+			mockProvider := &mockZFSProvider{CreatePoolFunc: record, AddVdevsFunc: record}
+			config := poolConfig{Name: "tank", Type: "mirror", Ashift: "9", Disks: []diskSpec{{Dev: "/dev/sda"}, {Dev: "/dev/sdb"}}}
+			vdev := vdevSpec{DiskList: "/dev/nvme0n1", Ashift: "12"}
+			switch class {
+			case "log":
+				config.Log = vdev
+			case "special":
+				// The special vdev must be as redundant as the data vdevs.
+				vdev = vdevSpec{Type: "mirror", DiskList: "/dev/nvme0n1 /dev/nvme1n1", Ashift: "12"}
+				config.Special = vdev
+			case "cache":
+				config.Cache = vdev
+			}
+			if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newRunState(nil)); err != nil {
+				t.Fatalf("createPool() returned an unexpected error: %v", err)
+			}
+			want := "add -o ashift=12 tank " + strings.Join(classVdevArgs(class, vdev, strings.Fields(vdev.DiskList)), " ")
+			if len(calls) != 2 || !strings.HasSuffix(calls[0], "-o ashift=9 tank mirror /dev/sda /dev/sdb") || calls[1] != want {
+				t.Errorf("zpool calls = %q, want create and %q", calls, want)
+			}
+		})
+	}
+}
+
+func TestReconcileClassAshift(t *testing.T) {
+	status := `{"pools": {"tank": {"name": "tank", "vdevs": {"tank": {"name": "tank", "vdevs": {
+	  "sda": {"name": "sda"}, "nvme0n1": {"name": "nvme0n1", "class": "log", "path": "/dev/nvme0n1"}}}},
+	  "l2cache": {"nvme2n1": {"name": "nvme2n1", "class": "l2cache", "path": "/dev/nvme2n1"}}}}}`
+	var added []string
+	var attachAshift string
+	mockProvider := &mockZFSProvider{
+		GetAllPoolStatusFunc: func(ctx context.Context, zpoolPath string) ([]byte, error) { return []byte(status), nil },
+		IsBlockDeviceFunc:    func(path string) (bool, error) { return true, nil },
+		AddVdevsFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+			added = append(added, strings.Join(args, " "))
+			return nil, nil
+		},
+		AttachDeviceFunc: func(ctx context.Context, zpoolPath, name, device, newDevice, ashift string) ([]byte, error) {
+			attachAshift = ashift
+			return nil, nil
+		},
+	}
+	config := poolConfig{Name: "tank", Ashift: "9",
+		Log:   vdevSpec{DiskList: "/dev/nvme0n1 /dev/nvme1n1", Ashift: "12"},
+		Cache: vdevSpec{DiskList: "/dev/nvme2n1 /dev/nvme3n1", Ashift: "13"}}
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newRunState(map[string]string{"tank": "1234567890"})); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	if want := []string{"add -o ashift=12 tank log /dev/nvme1n1", "add -o ashift=13 tank cache /dev/nvme3n1"}; !slices.Equal(added, want) {
+		t.Errorf("Added = %q, want %q", added, want)
+	}
+
+	// Without an ashift of their own, new devices get the pool's, also when mirroring the log.
+	config.Log = vdevSpec{Type: "mirror", DiskList: config.Log.DiskList}
+	config.Cache.Ashift = ""
+	added = nil
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newRunState(map[string]string{"tank": "1234567890"})); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	if want := []string{"add -o ashift=9 tank cache /dev/nvme3n1"}; !slices.Equal(added, want) || attachAshift != "9" {
+		t.Errorf("Added = %q with attach ashift %q, want %q and 9", added, attachAshift, want)
+	}
+}
+
+func TestValidateSpecialRedundancy(t *testing.T) {
+	mirror := []diskSpec{{Dev: "/dev/sda"}, {Dev: "/dev/sdb"}}
+	tests := []struct {
+		name    string
+		config  poolConfig
+		wantErr bool
+	}{
+		{"no special", poolConfig{Type: "raidz2", Disks: mirror}, false},
+		{"stripe", poolConfig{Disks: mirror, Special: vdevSpec{DiskList: "/dev/nvme0n1"}}, false},
+		{"mirror", poolConfig{Type: "mirror", Disks: mirror, Special: vdevSpec{Type: "mirror", DiskList: "/dev/nvme0n1 /dev/nvme1n1"}}, false},
+		{"single for mirror", poolConfig{Type: "mirror", Disks: mirror, Special: vdevSpec{DiskList: "/dev/nvme0n1"}}, true},
+		{"plain disks for mirror", poolConfig{Type: "mirror", Disks: mirror, Special: vdevSpec{DiskList: "/dev/nvme0n1 /dev/nvme1n1"}}, true},
+		{"2-way for raidz2", poolConfig{DiskList: "/dev/sda /dev/sdb /dev/sdc /dev/sdd", Type: "raidz2", Special: vdevSpec{Type: "mirror", DiskList: "/dev/nvme0n1 /dev/nvme1n1"}}, true},
+		{"3-way for draid2", poolConfig{DiskList: "/dev/sda /dev/sdb /dev/sdc /dev/sdd", Type: "draid2", Special: vdevSpec{Type: "mirror", DiskList: "/dev/nvme0n1 /dev/nvme1n1 /dev/nvme2n1"}}, false},
+		{"least redundant vdev", poolConfig{MixedVdevs: true, Vdevs: []vdevSpec{{Type: "raidz2", DiskList: "/dev/sda /dev/sdb /dev/sdc /dev/sdd"}, {Type: "mirror", DiskList: "/dev/sde /dev/sdf"}},
+			Special: vdevSpec{Type: "mirror", DiskList: "/dev/nvme0n1 /dev/nvme1n1"}}, false},
+	}
+	for _, tt := range tests {
+		if err := validateSpecialRedundancy(tt.config); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateSpecialRedundancy() = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateSmallBlocks(t *testing.T) {
+	special := vdevSpec{DiskList: "/dev/nvme0n1"}
+	tests := []struct {
+		name    string
+		config  poolConfig
+		wantErr bool
+	}{
+		{"unset", poolConfig{}, false},
+		{"32K", poolConfig{Special: special, SmallBlocks: "32K"}, false},
+		{"zero", poolConfig{Special: special, SmallBlocks: "0"}, false},
+		{"64K", poolConfig{Special: special, SmallBlocks: "64K"}, false},
+		{"default recordsize", poolConfig{Special: special, SmallBlocks: "128K"}, true},
+		{"above default recordsize", poolConfig{Special: special, SmallBlocks: "1M"}, true},
+		{"below recordsize", poolConfig{Special: special, SmallBlocks: "512K", Recordsize: "1M"}, false},
+		{"configured recordsize", poolConfig{Special: special, SmallBlocks: "32K", Recordsize: "32K"}, true},
+		{"invalid recordsize", poolConfig{Recordsize: "100K"}, true},
+		{"no special vdev", poolConfig{SmallBlocks: "32K"}, true},
+		{"not a power of two", poolConfig{Special: special, SmallBlocks: "48K"}, true},
+		{"too small", poolConfig{Special: special, SmallBlocks: "256"}, true},
+		{"too large", poolConfig{Special: special, SmallBlocks: "32M"}, true},
+		{"not a size", poolConfig{Special: special, SmallBlocks: "small"}, true},
+	}
+	for _, tt := range tests {
+		if err := validateSmallBlocks(tt.config); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateSmallBlocks() = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCreatePool_Special(t *testing.T) {
+	var gotArgs string
+	mockProvider := &mockZFSProvider{
+		IsBlockDeviceFunc: func(path string) (bool, error) { return true, nil },
+		CreatePoolFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+			gotArgs = strings.Join(args, " ")
+			return nil, nil
+		},
+	}
+	config := poolConfig{
+		Name:        "tank",
+		Type:        "mirror",
+		Ashift:      "12",
+		Disks:       []diskSpec{{Dev: "/dev/sda"}, {Dev: "/dev/sdb"}},
+		Log:         vdevSpec{DiskList: "/dev/nvme0n1"},
+		Special:     vdevSpec{Type: "mirror", DiskList: "/dev/nvme1n1 /dev/nvme2n1"},
+		SmallBlocks: "32K",
+	}
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newRunState(nil)); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	if !strings.HasSuffix(gotArgs, "-O special_small_blocks=32K tank mirror /dev/sda /dev/sdb log /dev/nvme0n1 special mirror /dev/nvme1n1 /dev/nvme2n1") {
+		t.Errorf("Unexpected create args %q", gotArgs)
+	}
+
+	// A larger recordsize allows larger small blocks.
+	config.Recordsize, config.SmallBlocks = "1M", "256K"
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newRunState(nil)); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	if !strings.Contains(gotArgs, "-O recordsize=1M -O special_small_blocks=256K tank") {
+		t.Errorf("Unexpected create args %q", gotArgs)
+	}
+
+	// The log and the special vdev cannot share a disk.
+	config.Special.DiskList = "/dev/nvme0n1 /dev/nvme2n1"
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newRunState(nil)); err == nil || !strings.Contains(err.Error(), "special disk /dev/nvme0n1") {
+		t.Errorf("Expected an error for a shared disk, got %v", err)
+	}
+}
+
+func TestAuditClassVdev(t *testing.T) {
+	var status poolStatus
+	if err := json.Unmarshal([]byte(`{
+	  "name": "tank",
+	  "vdevs": {"tank": {"name": "tank", "vdevs": {
+	    "mirror-0": {"name": "mirror-0", "vdevs": {"sda": {"name": "sda"}, "sdb": {"name": "sdb"}}},
+	    "nvme0n1": {"name": "nvme0n1", "class": "log"}
+	  }}},
+	  "logs": {"nvme1n1": {"name": "nvme1n1", "class": "logs"}}
+	}`), &status); err != nil {
+		t.Fatal(err)
+	}
+	config := poolConfig{Name: "tank"}
+	if items := auditClassVdev(config, "log", vdevSpec{DiskList: "/dev/nvme0n1 /dev/nvme1n1"}, &status); len(items) != 0 {
+		t.Errorf("Expected no drift, got %+v", items)
+	}
+	items := auditClassVdev(config, "log", vdevSpec{Type: "mirror", DiskList: "/dev/nvme0n1 /dev/nvme1n1"}, &status)
+	if len(items) != 1 || items[0].Want != "mirror(2)" || items[0].Have != "stripe(2)" {
+		t.Errorf("Expected log drift, got %+v", items)
+	}
+	if items := auditClassVdev(config, "log", vdevSpec{}, &status); len(items) != 0 {
+		t.Errorf("Expected no check without a configured log, got %+v", items)
+	}
+	items = auditClassVdev(config, "special", vdevSpec{Type: "mirror", DiskList: "/dev/nvme2n1 /dev/nvme3n1"}, &status)
+	if len(items) != 1 || items[0].Field != "special" || items[0].Have != "none" {
+		t.Errorf("Expected a missing special vdev, got %+v", items)
+	}
+}
+
+func TestCheckLogLatency(t *testing.T) {
+	var probed []string
+	mockProvider := &mockZFSProvider{
+		ProbeSyncWriteLatencyFunc: func(path string, writes int) (time.Duration, error) {
+			probed = append(probed, path)
+			switch path {
+			case "/dev/sdx":
+				return 8 * time.Millisecond, nil
+			case "/dev/nvme9n1":
+				return 0, errors.New("device or resource busy")
+			}
+			return 30 * time.Microsecond, nil
+		},
+	}
+	devices := []string{"/dev/nvme0n1", "/dev/sdx", "/dev/nvme9n1"}
+	tests := []struct {
+		name       string
+		policy     string
+		dryRun     bool
+		wantProbed bool
+		wantErr    bool
+	}{
+		{"unset", "", false, false, false},
+		{"off", logLatencyOff, false, false, false},
+		{"warn", logLatencyWarn, false, true, false},
+		{"refuse", logLatencyRefuse, false, true, true},
+		{"plan mode", logLatencyRefuse, true, false, false},
+	}
+	for _, tt := range tests {
+		probed = nil
+		state := newRunState(nil)
+		state.logLatencyPolicy, state.dryRun = tt.policy, tt.dryRun
+		err := state.checkLogLatency(mockProvider, "tank", devices)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: checkLogLatency() = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil && (!strings.Contains(err.Error(), "/dev/sdx (8ms)") || strings.Contains(err.Error(), "nvme")) {
+			t.Errorf("%s: expected only the slow device in the error, got %v", tt.name, err)
+		}
+		if got := slices.Equal(probed, devices); got != tt.wantProbed {
+			t.Errorf("%s: probed %v, want all devices probed %v", tt.name, probed, tt.wantProbed)
+		}
+	}
+
+	// A higher limit accepts the slow device.
+	state := newRunState(nil)
+	state.logLatencyPolicy, state.logMaxLatency = logLatencyRefuse, 10*time.Millisecond
+	if err := state.checkLogLatency(mockProvider, "tank", devices); err != nil {
+		t.Errorf("checkLogLatency() with a higher limit returned an unexpected error: %v", err)
+	}
+}
+
+func TestCreatePool_SlowLog(t *testing.T) {
+	created := false
+	mockProvider := &mockZFSProvider{
+		IsBlockDeviceFunc: func(path string) (bool, error) { return true, nil },
+		CreatePoolFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+			created = true
+			return nil, nil
+		},
+		ProbeSyncWriteLatencyFunc: func(path string, writes int) (time.Duration, error) {
+			if path == "/dev/sdc" {
+				return 12 * time.Millisecond, nil
+			}
+			return 20 * time.Microsecond, nil
+		},
+	}
+	config := poolConfig{Name: "tank", Type: "mirror", Ashift: "12", Disks: []diskSpec{{Dev: "/dev/sda"}, {Dev: "/dev/sdb"}}, Log: vdevSpec{DiskList: "/dev/sdc"}}
+	state := newRunState(nil)
+	state.logLatencyPolicy = logLatencyRefuse
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, state); err == nil || !strings.Contains(err.Error(), "synchronous write") {
+		t.Errorf("Expected an error for a slow log device, got %v", err)
+	}
+	if created || len(state.usedDisks) != 0 {
+		t.Errorf("Expected no pool and no used disks, got created %v, used %v", created, state.usedDisks)
+	}
+
+	// A fast log device is used.
+	config.Log.DiskList = "/dev/nvme0n1"
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, state); err != nil || !created {
+		t.Errorf("Expected the pool to be created, got %v", err)
+	}
+}
+
+func TestReconcileLog(t *testing.T) {
+	const (
+		single   = `"nvme0n1": {"name": "nvme0n1", "class": "log", "path": "/dev/nvme0n1"}`
+		singles  = single + `, "nvme1n1": {"name": "nvme1n1", "class": "log", "path": "/dev/nvme1n1"}`
+		mirrored = `"mirror-1": {"name": "mirror-1", "class": "log", "vdevs": {"nvme0n1": {"name": "nvme0n1", "path": "/dev/nvme0n1"}, "nvme1n1": {"name": "nvme1n1", "path": "/dev/nvme1n1"}}}`
+	)
+	tests := []struct {
+		name         string
+		log          string // Log vdevs in the status of the pool.
+		config       vdevSpec
+		wantCommands []string
+		wantErr      string
+	}{
+		{
+			name:         "attach to mirror a single device",
+			log:          single,
+			config:       vdevSpec{Type: "mirror", DiskList: "/dev/nvme1n1 /dev/nvme0n1"},
+			wantCommands: []string{"attach /dev/nvme0n1 /dev/nvme1n1 12"},
+		},
+		{
+			name:         "add a device",
+			log:          single,
+			config:       vdevSpec{DiskList: "/dev/nvme1n1 /dev/nvme0n1"},
+			wantCommands: []string{"add -o ashift=12 tank log /dev/nvme1n1"},
+		},
+		{
+			name:         "replace a device",
+			log:          single,
+			config:       vdevSpec{DiskList: "/dev/nvme2n1"},
+			wantCommands: []string{"add -o ashift=12 tank log /dev/nvme2n1", "remove /dev/nvme0n1"},
+		},
+		{
+			name:         "replace a device by a mirror",
+			log:          single,
+			config:       vdevSpec{Type: "mirror", DiskList: "/dev/nvme1n1 /dev/nvme2n1"},
+			wantCommands: []string{"add -o ashift=12 tank log mirror /dev/nvme1n1 /dev/nvme2n1", "remove /dev/nvme0n1"},
+		},
+		{
+			name:         "merge single devices into a mirror",
+			log:          singles,
+			config:       vdevSpec{Type: "mirror", DiskList: "/dev/nvme0n1 /dev/nvme1n1"},
+			wantCommands: []string{"remove /dev/nvme1n1", "attach /dev/nvme0n1 /dev/nvme1n1 12"},
+		},
+		{
+			name:   "mirror as configured",
+			log:    mirrored,
+			config: vdevSpec{Type: "mirror", DiskList: "/dev/nvme0n1 /dev/nvme1n1"},
+		},
+		{
+			name:         "replace a side of the mirror",
+			log:          mirrored,
+			config:       vdevSpec{Type: "mirror", DiskList: "/dev/nvme0n1 /dev/nvme2n1"},
+			wantCommands: []string{"attach /dev/nvme0n1 /dev/nvme2n1 12", "detach /dev/nvme1n1"},
+		},
+		{
+			name:         "shrink the mirror to a single device",
+			log:          mirrored,
+			config:       vdevSpec{DiskList: "/dev/nvme1n1"},
+			wantCommands: []string{"detach /dev/nvme0n1"},
+		},
+		{
+			name:         "split the mirror",
+			log:          mirrored,
+			config:       vdevSpec{DiskList: "/dev/nvme0n1 /dev/nvme1n1"},
+			wantCommands: []string{"detach /dev/nvme1n1", "add -o ashift=12 tank log /dev/nvme1n1"},
+		},
+		{
+			name:         "replace the mirror",
+			log:          mirrored,
+			config:       vdevSpec{Type: "mirror", DiskList: "/dev/nvme2n1 /dev/nvme3n1"},
+			wantCommands: []string{"add -o ashift=12 tank log mirror /dev/nvme2n1 /dev/nvme3n1", "remove mirror-1"},
+		},
+		{
+			name: "log without configuration",
+			log:  mirrored,
+		},
+		{
+			name:    "unusable device",
+			log:     single,
+			config:  vdevSpec{DiskList: "/dev/nvme9n1"},
+			wantErr: "1 of 1 log disks to add are unusable: /dev/nvme9n1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := `{"pools": {"tank": {"name": "tank", "vdevs": {"tank": {"name": "tank", "vdevs": {
+			  "mirror-0": {"name": "mirror-0", "vdevs": {"sda": {"name": "sda"}, "sdb": {"name": "sdb"}}},
+			  ` + tt.log + `}}}}}}`
+			var commands []string
+			mockProvider := &mockZFSProvider{
+				GetAllPoolStatusFunc: func(ctx context.Context, zpoolPath string) ([]byte, error) { return []byte(status), nil },
+				IsBlockDeviceFunc:    func(path string) (bool, error) { return path != "/dev/nvme9n1", nil },
+				AddVdevsFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+					commands = append(commands, strings.Join(args, " "))
+					return nil, nil
+				},
+				AttachDeviceFunc: func(ctx context.Context, zpoolPath, name, device, newDevice, ashift string) ([]byte, error) {
+					commands = append(commands, "attach "+device+" "+newDevice+" "+ashift)
+					return nil, nil
+				},
+				DetachDeviceFunc: func(ctx context.Context, zpoolPath, name, device string) ([]byte, error) {
+					commands = append(commands, "detach "+device)
+					return nil, nil
+				},
+				RemoveVdevFunc: func(ctx context.Context, zpoolPath, name, vdev string) ([]byte, error) {
+					commands = append(commands, "remove "+vdev)
+					return nil, nil
+				},
+			}
+			config := poolConfig{Name: "tank", Type: "mirror", Ashift: "12", Log: tt.config}
+			state := newRunState(map[string]string{"tank": "1234567890"})
+			err := createPool(t.Context(), mockProvider, "/fake/zpool", config, state)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("createPool() returned an unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("createPool() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+			if !slices.Equal(commands, tt.wantCommands) {
+				t.Errorf("Commands = %q, want %q", commands, tt.wantCommands)
+			}
+		})
+	}
+}
+
+func TestReconcileLog_Plan(t *testing.T) {
+	status := `{"pools": {"tank": {"name": "tank", "vdevs": {"tank": {"name": "tank", "vdevs": {
+	  "nvme0n1": {"name": "nvme0n1", "class": "log", "path": "/dev/nvme0n1"}
+	}}}}}}`
+	mockProvider := &mockZFSProvider{
+		GetAllPoolStatusFunc: func(ctx context.Context, zpoolPath string) ([]byte, error) { return []byte(status), nil },
+		IsBlockDeviceFunc:    func(path string) (bool, error) { return true, nil },
+	}
+	planner := newPlanningProvider(mockProvider)
+	planner.setPool("tank")
+	config := poolConfig{Name: "tank", Log: vdevSpec{DiskList: "/dev/nvme1n1"}}
+	state := newRunState(map[string]string{"tank": "1234567890"})
+	state.dryRun = true
+	if err := reconcileLog(t.Context(), planner, "/fake/zpool", config, state); err != nil {
+		t.Fatalf("reconcileLog() returned an unexpected error: %v", err)
+	}
+	var explained []string
+	for _, action := range planner.actions {
+		explained = append(explained, explainAction(action))
+	}
+	if want := []string{"add log from 1 disk (/dev/nvme1n1)", "remove /dev/nvme0n1 from the pool"}; !slices.Equal(explained, want) {
+		t.Errorf("Explained actions = %q, want %q", explained, want)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// vdevSpec is one data vdev of a pool with several of them, configured with
+// ZPOOL_<n>_VDEV_<m>_TYPE and ZPOOL_<n>_VDEV_<m>_DISKS.
+type vdevSpec struct {
+	Type     string // e.g. "mirror", "raidz2" or a dRAID type; empty adds every disk as a vdev of its own.
+	DiskList string // The disks in the syntax of ZPOOL_<n>_DISKS, see parseDiskList.
+	Ashift   string // ashift of the vdev if it differs from the pool's, see vdevAddition. Empty uses the pool's.
+}
+
+// disks returns the configured disks of the vdev.
+func (v vdevSpec) disks() ([]diskSpec, error) {
+	return parseDiskList(v.DiskList)
+}
+
+// parseVdevSpecs reads the vdevs of pool i, ZPOOL_<i>_VDEV_<m>_*, up to the first index with
+// neither a type nor disks. Placeholders in the disks are expanded, see expandDiskTemplate, and
+// a dRAID layout in ZPOOL_<i>_VDEV_<m>_DRAID_* is added to the type, see draidTypeWithSettings.
+func parseVdevSpecs(i int, facts func() map[string]string) ([]vdevSpec, []error) {
+	var vdevs []vdevSpec
+	var errs []error
+	for m := 0; ; m++ {
+		prefix := fmt.Sprintf("ZPOOL_%d_VDEV_%d_", i, m)
+		vdevType := strings.TrimSpace(os.Getenv(prefix + "TYPE"))
+		diskList := strings.TrimSpace(os.Getenv(prefix + "DISKS"))
+		if vdevType == "" && diskList == "" {
+			return vdevs, errs
+		}
+		ashift := strings.TrimSpace(os.Getenv(prefix + "ASHIFT"))
+		diskList, err := expandDiskTemplate(diskList, facts)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if vdevType, err = draidTypeWithSettings(vdevType, prefix); err != nil {
+			errs = append(errs, err)
+		}
+		vdevs = append(vdevs, vdevSpec{Type: vdevType, DiskList: diskList, Ashift: ashift})
+	}
+}
+
+// vdevDisks returns the disks of all vdevs, in order.
+func vdevDisks(vdevs []vdevSpec) ([]diskSpec, error) {
+	var disks []diskSpec
+	for m, vdev := range vdevs {
+		listed, err := vdev.disks()
+		if err != nil {
+			return nil, fmt.Errorf("vdev %d: invalid disk list %q: %w", m, vdev.DiskList, err)
+		}
+		disks = append(disks, listed...)
+	}
+	return disks, nil
+}
+
+// validateVdevs checks the vdevs of a pool: the pool-wide type and disks cannot be combined
+// with them, every vdev needs a valid type and enough disks for it, and vdevs of different
+// redundancy, which zpool refuses without -f, must be acknowledged with MixedVdevs. Vdevs with
+// an ashift of their own are added after the pool is created, so they must come last.
+func validateVdevs(config poolConfig) error {
+	if len(config.Vdevs) == 0 {
+		if config.MixedVdevs {
+			return errors.New("mixed vdevs are only supported with ZPOOL_<n>_VDEV_<m>_* vdevs")
+		}
+		return nil
+	}
+	if config.Type != "" || len(config.Disks) > 0 || config.DiskList != "" {
+		return errors.New("the type and disks of a pool cannot be combined with ZPOOL_<n>_VDEV_<m>_* vdevs, configure every disk in its vdev")
+	}
+	var layouts []string
+	added := 0
+	for m, vdev := range config.Vdevs {
+		if err := validateVdevAshift(vdev.Ashift); err != nil {
+			return fmt.Errorf("vdev %d: %w", m, err)
+		}
+		if addedLater(config, vdev) {
+			added++
+		} else if added > 0 {
+			return fmt.Errorf("vdev %d uses the ashift of the pool, but comes after a vdev with an ashift of its own, which is added once the pool is created; configure those vdevs last", m)
+		}
+		if !isValidZpoolType(vdev.Type) {
+			return fmt.Errorf("vdev %d: invalid type: %q, run `create-zpool capabilities` for the supported ones", m, vdev.Type)
+		}
+		disks, err := vdev.disks()
+		if err != nil {
+			return fmt.Errorf("vdev %d: invalid disk list %q: %w", m, vdev.DiskList, err)
+		}
+		if len(disks) == 0 {
+			return fmt.Errorf("vdev %d: no disks are configured", m)
+		}
+		if err := validateVdevDisks(vdev.Type, len(disks)); err != nil {
+			return fmt.Errorf("vdev %d: %w", m, err)
+		}
+		if layout := vdevLayout(vdev.Type, len(disks)); !slices.Contains(layouts, layout) {
+			layouts = append(layouts, layout)
+		}
+	}
+	if added == len(config.Vdevs) {
+		return errors.New("every vdev has an ashift of its own, set ZPOOL_<n>_ASHIFT to that of the first one instead")
+	}
+	if len(layouts) > 1 && !config.MixedVdevs {
+		return fmt.Errorf("zpool refuses vdevs of different redundancy (%s), set ZPOOL_<n>_MIXED_VDEVS=true to create them anyway", strings.Join(layouts, ", "))
+	}
+	return nil
+}
+
+// validateVdevDisks checks that a vdev of the given type can be built from disks.
+func validateVdevDisks(vdevType string, disks int) error {
+	if _, ok, _ := parseDraidType(vdevType); ok {
+		return validateDraidType(vdevType, disks)
+	}
+	if need, ok := minVdevDisks[normalizeVdevType(vdevType)]; ok && disks < need {
+		return fmt.Errorf("%d disks are too few for a %s vdev, at least %d are required", disks, normalizeVdevType(vdevType), need)
+	}
+	return nil
+}
+
+// vdevLayout describes the redundancy of a vdev the way zpool compares it, e.g. "2-disk
+// mirror". Plain disks are all alike, whatever their number.
+func vdevLayout(vdevType string, disks int) string {
+	if vdevType == "" {
+		return "stripe"
+	}
+	return fmt.Sprintf("%d-disk %s", disks, normalizeVdevType(vdevType))
+}
+
+// vdevCreateArgs returns the vdev arguments of `zpool create` for each of vdevs built from disks,
+// the selected devices of all vdevs in order, e.g. "mirror /dev/sda /dev/sdb" and "mirror
+// /dev/sdc /dev/sdd".
+func vdevCreateArgs(vdevs []vdevSpec, disks []string) ([][]string, error) {
+	var args [][]string
+	for m, vdev := range vdevs {
+		listed, err := vdev.disks()
+		if err != nil {
+			return nil, fmt.Errorf("vdev %d: invalid disk list %q: %w", m, vdev.DiskList, err)
+		}
+		if len(listed) > len(disks) {
+			return nil, fmt.Errorf("vdev %d: %d disks are configured, but only %d are left", m, len(listed), len(disks))
+		}
+		var vdevArgs []string
+		if vdev.Type != "" {
+			vdevArgs = append(vdevArgs, vdev.Type)
+		}
+		args = append(args, append(vdevArgs, disks[:len(listed)]...))
+		disks = disks[len(listed):]
+	}
+	if len(disks) > 0 {
+		return nil, fmt.Errorf("%d disks are not part of any vdev", len(disks))
+	}
+	return args, nil
+}
+
+// validateVdevAshift checks the ashift of a vdev: empty for that of the pool, 0 to detect it, or
+// 9 to 16.
+func validateVdevAshift(ashift string) error {
+	if ashift == "" {
+		return nil
+	}
+	if n, err := strconv.Atoi(ashift); err != nil || (n != 0 && (n < 9 || n > 16)) {
+		return fmt.Errorf("invalid ashift value: %q, zpool accepts 0 (detect) or 9 to 16", ashift)
+	}
+	return nil
+}
+
+// addedLater reports whether vdev of the pool is added with `zpool add` once the pool is
+// created: `zpool create` sets one ashift for all vdevs, so a vdev with an ashift of its own
+// cannot be part of it.
+func addedLater(config poolConfig, vdev vdevSpec) bool {
+	return vdev.Ashift != "" && vdev.Ashift != config.Ashift
+}
+
+// vdevAddition is a vdev that is added to a new pool with `zpool add -o ashift=<Ashift>` right
+// after its creation, see addedLater.
+type vdevAddition struct {
+	Ashift string
+	Args   []string // The vdev arguments, e.g. "log /dev/nvme0n1".
+}
+
+// attachArgs returns the arguments of `zpool attach` for mirroring device of pool name onto
+// newDevice, with -o ashift unless ashift is empty or 0, which zpool detects anyway.
+func attachArgs(name, device, newDevice, ashift string) []string {
+	args := []string{"attach"}
+	if ashift != "" && ashift != "0" {
+		args = append(args, "-o", "ashift="+ashift)
+	}
+	return append(args, name, device, newDevice)
+}
+
+// addVdevs adds the vdevs with an ashift of their own to the newly created pool, in order. A
+// failure leaves the pool without the remaining ones, which is reported, like every difference
+// to the configuration, by `audit`.
+func addVdevs(ctx context.Context, provider zfsProvider, zpoolPath string, config poolConfig, additions []vdevAddition) error {
+	for _, addition := range additions {
+		args := []string{"add"}
+		if config.MixedVdevs {
+			args = append(args, "-f")
+		}
+		args = append(args, "-o", "ashift="+addition.Ashift, config.Name)
+		args = append(args, addition.Args...)
+		slog.Info("Adding a vdev with its own ashift", "pool", config.Name, "ashift", addition.Ashift, "args", strings.Join(args, " "))
+		if output, err := provider.AddVdevs(ctx, zpoolPath, args); err != nil {
+			return fmt.Errorf("zpool add of %s failed: %w. Output: %s", strings.Join(addition.Args, " "), err, string(output))
+		}
+	}
+	return nil
+}
+
+// describeVdevSpecs renders the configured vdevs like describeActualLayout does for a pool,
+// e.g. "mirror(2) raidz2(6)", with the number of disks of each.
+func describeVdevSpecs(vdevs []vdevSpec) string {
+	var parts []string
+	for _, vdev := range vdevs {
+		disks, _ := vdev.disks()
+		parts = append(parts, describeVdevType(normalizeVdevType(vdev.Type))+"("+strconv.Itoa(len(disks))+")")
+	}
+	return strings.Join(parts, " ")
+}
+
+// describeActualVdevs renders the data vdevs of a pool like describeVdevSpecs, in the order
+// they were added. Plain disks are vdevs of their own whose position is unknown, so they are
+// counted together first.
+func describeActualVdevs(vdevs []*vdevStatus) string {
+	vdevs = slices.Clone(vdevs)
+	slices.SortStableFunc(vdevs, func(a, b *vdevStatus) int { return vdevIndex(a.Name) - vdevIndex(b.Name) })
+	var parts []string
+	stripe := 0
+	for _, vdev := range vdevs {
+		if len(vdev.Vdevs) == 0 {
+			stripe++
+			continue
+		}
+		if stripe > 0 {
+			parts, stripe = append(parts, "stripe("+strconv.Itoa(stripe)+")"), 0
+		}
+		parts = append(parts, vdevTypeFromName(vdev.Name)+"("+strconv.Itoa(len(vdev.leaves()))+")")
+	}
+	if stripe > 0 {
+		parts = append(parts, "stripe("+strconv.Itoa(stripe)+")")
+	}
+	return strings.Join(parts, " ")
+}
+
+// vdevIndex returns the index zpool numbers a top-level vdev with, e.g. 1 for mirror-1, or -1
+// for a plain disk.
+func vdevIndex(name string) int {
+	name, _, _ = strings.Cut(name, ":")
+	if i := strings.LastIndex(name, "-"); i > 0 {
+		if n, err := strconv.Atoi(name[i+1:]); err == nil {
+			return n
+		}
+	}
+	return -1
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParsePoolConfigs_Vdevs(t *testing.T) {
+	t.Setenv("ZPOOL_0_NAME", "tank")
+	t.Setenv("ZPOOL_0_VDEV_0_TYPE", "mirror")
+	t.Setenv("ZPOOL_0_VDEV_0_DISKS", "/dev/sda /dev/sdb")
+	t.Setenv("ZPOOL_0_VDEV_1_TYPE", "mirror")
+	t.Setenv("ZPOOL_0_VDEV_1_DISKS", `["/dev/sdc", {"model": "Samsung*"}]`)
+	t.Setenv("ZPOOL_0_VDEV_3_TYPE", "mirror")
+
+	configs := parsePoolConfigs()
+	if len(configs) != 1 || len(configs[0].Vdevs) != 2 {
+		t.Fatalf("Expected one pool with two vdevs, got %+v", configs)
+	}
+	disks, err := configuredDisks(configs[0])
+	if err != nil || len(disks) != 4 || disks[3].Model != "Samsung*" {
+		t.Errorf("configuredDisks() = %v, %v", disks, err)
+	}
+	if err := validatePoolConfig(configs[0]); err != nil {
+		t.Errorf("validatePoolConfig() returned an unexpected error: %v", err)
+	}
+}
+
+func TestValidateVdevs(t *testing.T) {
+	mirror := func(disks string) vdevSpec { return vdevSpec{Type: "mirror", DiskList: disks} }
+	tests := []struct {
+		name    string
+		config  poolConfig
+		wantErr string
+	}{
+		{"mirrors", poolConfig{Vdevs: []vdevSpec{mirror("a b"), mirror("c d")}}, ""},
+		{"raidz2 and mirror", poolConfig{Vdevs: []vdevSpec{{Type: "raidz2", DiskList: "a b c d"}, mirror("e f")}}, "different redundancy"},
+		{"mixed", poolConfig{Vdevs: []vdevSpec{{Type: "raidz2", DiskList: "a b c d"}, mirror("e f")}, MixedVdevs: true}, ""},
+		{"widths", poolConfig{Vdevs: []vdevSpec{mirror("a b"), mirror("c d e")}}, "2-disk mirror, 3-disk mirror"},
+		{"pool type", poolConfig{Type: "mirror", Vdevs: []vdevSpec{mirror("a b")}}, "cannot be combined"},
+		{"pool disks", poolConfig{DiskList: "x", Vdevs: []vdevSpec{mirror("a b")}}, "cannot be combined"},
+		{"bad type", poolConfig{Vdevs: []vdevSpec{{Type: "raid5", DiskList: "a b c"}}}, "vdev 0: invalid type"},
+		{"no disks", poolConfig{Vdevs: []vdevSpec{mirror("a b"), {Type: "mirror"}}}, "vdev 1: no disks"},
+		{"too few", poolConfig{Vdevs: []vdevSpec{{Type: "raidz3", DiskList: "a b c"}}}, "at least 4"},
+		{"draid", poolConfig{Vdevs: []vdevSpec{{Type: "draid2:4d:1s", DiskList: "a b c d"}}}, "invalid dRAID type"},
+		{"mixed without vdevs", poolConfig{MixedVdevs: true}, "only supported"},
+		{"own ashift last", poolConfig{Ashift: "12", Vdevs: []vdevSpec{mirror("a b"), {Type: "mirror", DiskList: "c d", Ashift: "9"}}}, ""},
+		{"pool ashift", poolConfig{Ashift: "12", Vdevs: []vdevSpec{{Type: "mirror", DiskList: "a b", Ashift: "12"}, mirror("c d")}}, ""},
+		{"own ashift first", poolConfig{Ashift: "12", Vdevs: []vdevSpec{{Type: "mirror", DiskList: "a b", Ashift: "9"}, mirror("c d")}}, "configure those vdevs last"},
+		{"only own ashift", poolConfig{Ashift: "12", Vdevs: []vdevSpec{{Type: "mirror", DiskList: "a b", Ashift: "9"}}}, "every vdev"},
+		{"bad ashift", poolConfig{Vdevs: []vdevSpec{{Type: "mirror", DiskList: "a b", Ashift: "20"}}}, "invalid ashift"},
+	}
+	for _, tt := range tests {
+		err := validateVdevs(tt.config)
+		if (err == nil) != (tt.wantErr == "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: validateVdevs() = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCreatePool_Vdevs(t *testing.T) {
+	var gotArgs string
+	mockProvider := &mockZFSProvider{
+		IsBlockDeviceFunc: func(path string) (bool, error) { return path != "/dev/sdz", nil },
+		CreatePoolFunc: func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+			gotArgs = strings.Join(args, " ")
+			return nil, nil
+		},
+	}
+	config := poolConfig{
+		Name:       "tank",
+		Ashift:     "12",
+		Vdevs:      []vdevSpec{{Type: "raidz2", DiskList: "/dev/sda /dev/sdb /dev/sdc /dev/sdd"}, {Type: "mirror", DiskList: "/dev/sde /dev/sdf"}},
+		MixedVdevs: true,
+	}
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newRunState(nil)); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	if !strings.Contains(gotArgs, " -f ") || !strings.HasSuffix(gotArgs, "tank raidz2 /dev/sda /dev/sdb /dev/sdc /dev/sdd mirror /dev/sde /dev/sdf") {
+		t.Errorf("Unexpected create args %q", gotArgs)
+	}
+
+	// A missing disk would shift the others between the vdevs, so the pool is not created.
+	config.Vdevs[1].DiskList = "/dev/sde /dev/sdz"
+	mockProvider.CreatePoolFunc = func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+		t.Fatalf("CreatePool must not be called with a missing vdev disk, got args %v", args)
+		return nil, nil
+	}
+	state := newRunState(nil)
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, state); err == nil || !strings.Contains(err.Error(), "/dev/sdz") {
+		t.Errorf("Expected an error naming /dev/sdz, got %v", err)
+	}
+	if len(state.usedDisks) != 0 {
+		t.Errorf("Expected the picked disks to be released, got %v", state.usedDisks)
+	}
+}
+
+func TestCreatePool_VdevAshift(t *testing.T) {
+	var calls []string
+	record := func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+		calls = append(calls, strings.Join(args, " "))
+		return nil, nil
+	}
+	mockProvider := &mockZFSProvider{CreatePoolFunc: record, AddVdevsFunc: record}
+	config := poolConfig{
+		Name:   "tank",
+		Ashift: "9",
+		Vdevs:  []vdevSpec{{Type: "mirror", DiskList: "/dev/sda /dev/sdb"}, {Type: "mirror", DiskList: "/dev/sdc /dev/sdd", Ashift: "12"}},
+		Log:    vdevSpec{DiskList: "/dev/nvme0n1", Ashift: "12"},
+	}
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newRunState(nil)); err != nil {
+		t.Fatalf("createPool() returned an unexpected error: %v", err)
+	}
+	// zpool create sets one ashift for all of its vdevs, so the others follow with zpool add.
+	if len(calls) != 3 || !strings.HasSuffix(calls[0], "-o ashift=9 tank mirror /dev/sda /dev/sdb") ||
+		calls[1] != "add -o ashift=12 tank mirror /dev/sdc /dev/sdd" || calls[2] != "add -o ashift=12 tank log /dev/nvme0n1" {
+		t.Errorf("Unexpected zpool calls %q", calls)
+	}
+
+	calls = nil
+	mockProvider.AddVdevsFunc = func(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+		return []byte("no such device"), errors.New("exit status 1")
+	}
+	if err := createPool(t.Context(), mockProvider, "/fake/zpool", config, newRunState(nil)); err == nil || !strings.Contains(err.Error(), "pool created but zpool add") {
+		t.Errorf("Expected an error for the failed addition, got %v", err)
+	}
+}
+
+func TestVdevCreateArgs(t *testing.T) {
+	vdevs := []vdevSpec{{Type: "mirror", DiskList: "a b"}, {DiskList: "c"}}
+	args, err := vdevCreateArgs(vdevs, []string{"/dev/sda", "/dev/sdb", "/dev/sdc"})
+	if err != nil || len(args) != 2 || strings.Join(slices.Concat(args...), " ") != "mirror /dev/sda /dev/sdb /dev/sdc" {
+		t.Errorf("vdevCreateArgs() = %v, %v", args, err)
+	}
+	if _, err := vdevCreateArgs(vdevs, []string{"/dev/sda", "/dev/sdb"}); err == nil {
+		t.Error("Expected an error for too few disks")
+	}
+	if _, err := vdevCreateArgs(vdevs, []string{"/dev/sda", "/dev/sdb", "/dev/sdc", "/dev/sdd"}); err == nil {
+		t.Error("Expected an error for disks outside of the vdevs")
+	}
+}
+
+func TestDescribeActualVdevs(t *testing.T) {
+	leaf := func(name string) *vdevStatus { return &vdevStatus{Name: name} }
+	group := func(name string, leaves ...string) *vdevStatus {
+		vdev := &vdevStatus{Name: name, Vdevs: map[string]*vdevStatus{}}
+		for _, l := range leaves {
+			vdev.Vdevs[l] = leaf(l)
+		}
+		return vdev
+	}
+	// Sorted by name, mirror-10 would come before mirror-2.
+	vdevs := []*vdevStatus{group("mirror-0", "sda", "sdb"), group("mirror-10", "sdc", "sdd"), group("raidz2-2", "sde", "sdf", "sdg", "sdh"), leaf("sdi")}
+	if got, want := describeActualVdevs(vdevs), "stripe(1) mirror(2) raidz2(4) mirror(2)"; got != want {
+		t.Errorf("describeActualVdevs() = %q, want %q", got, want)
+	}
+	specs := []vdevSpec{{Type: "mirror", DiskList: "a b"}, {Type: "raidz2", DiskList: "a b c d"}, {Type: "mirror", DiskList: "a b"}}
+	if got, want := describeVdevSpecs(specs), "mirror(2) raidz2(4) mirror(2)"; got != want {
+		t.Errorf("describeVdevSpecs() = %q, want %q", got, want)
+	}
+}
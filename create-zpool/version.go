@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// Build metadata, set at build time with
+// -ldflags "-X main.buildVersion=... -X main.buildCommit=... -X main.buildDate=...".
+var (
+	buildVersion = "dev"
+	buildCommit  string
+	buildDate    string
+)
+
+// openZFSCompatibility lists the OpenZFS release series this build targets. Older releases lack
+// features it relies on, like `zpool status -j`.
+const openZFSCompatibility = "2.3, 2.4"
+
+// buildInfo describes the running build of the extension.
+type buildInfo struct {
+	Version   string
+	Commit    string
+	Date      string
+	GoVersion string
+	OpenZFS   string
+}
+
+// currentBuildInfo returns the build metadata. Builds without ldflags, e.g. `go build` in a
+// checkout, fall back to the VCS information embedded by the Go toolchain.
+func currentBuildInfo() buildInfo {
+	info := buildInfo{Version: buildVersion, Commit: buildCommit, Date: buildDate, GoVersion: runtime.Version(), OpenZFS: openZFSCompatibility}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range bi.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.Date == "":
+				info.Date = setting.Value
+			}
+		}
+	}
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.Date == "" {
+		info.Date = "unknown"
+	}
+	return info
+}
+
+// logArgs returns the build metadata as slog arguments.
+func (b buildInfo) logArgs() []any {
+	return []any{"version", b.Version, "commit", b.Commit, "build_date", b.Date, "go", b.GoVersion, "openzfs", b.OpenZFS}
+}
+
+// printBuildInfo writes the build metadata for the version mode and ZPOOL_PRINT_VERSION.
+func printBuildInfo(w io.Writer, b buildInfo) {
+	fmt.Fprintf(w, "create-zpool %s\ncommit:     %s\nbuild date: %s\ngo:         %s\nopenzfs:    %s\n", b.Version, b.Commit, b.Date, b.GoVersion, b.OpenZFS)
+}
+
+// zfsVersion holds the userland and kernel module versions reported by `zpool version`.
+type zfsVersion struct {
+	Userland string
+	Kernel   string
+}
+
+// parseZFSVersion parses `zpool version` output, which prints the userland version
+// (e.g. "zfs-2.2.6-1") followed by the kernel module version (e.g. "zfs-kmod-2.2.6-1").
+func parseZFSVersion(output string) zfsVersion {
+	var version zfsVersion
+	for line := range strings.Lines(output) {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "zfs-kmod-"):
+			version.Kernel = strings.TrimPrefix(line, "zfs-kmod-")
+		case strings.HasPrefix(line, "zfs-"):
+			version.Userland = strings.TrimPrefix(line, "zfs-")
+		}
+	}
+	return version
+}
+
+// logZFSVersion logs the ZFS userland and kernel module versions together with the pool
+// features supported by the loaded module. Failures are only logged, since the versions
+// are informational.
+func logZFSVersion(ctx context.Context, provider zfsProvider, zpoolPath string) zfsVersion {
+	output, err := provider.GetVersion(ctx, zpoolPath)
+	if err != nil {
+		slog.Warn("Failed to determine ZFS version", "error", err, "output", strings.TrimSpace(string(output)))
+		return zfsVersion{}
+	}
+	version := parseZFSVersion(string(output))
+
+	logArgs := []any{"userland", version.Userland, "kernel", version.Kernel}
+	features, err := provider.ListPoolFeatures()
+	if err != nil {
+		slog.Debug("Failed to list supported pool features", "error", err)
+	} else {
+		logArgs = append(logArgs, "pool_features", strings.Join(features, ","))
+	}
+	slog.Info("ZFS version", logArgs...)
+	return version
+}
+
+// Policies for ZPOOL_VERSION_MISMATCH.
+const (
+	versionMismatchWarn   = "warn"
+	versionMismatchRefuse = "refuse"
+)
+
+// releaseSeries returns the major.minor part of a ZFS version such as "2.2.6-1".
+func releaseSeries(version string) string {
+	major, rest, _ := strings.Cut(version, ".")
+	minor, _, _ := strings.Cut(rest, ".")
+	minor, _, _ = strings.Cut(minor, "-")
+	return major + "." + minor
+}
+
+// checkVersionMismatch reports an error if the userland and kernel module belong to different
+// release series. Patch level differences are compatible; they are common right after an update.
+// An unknown version is not treated as a mismatch.
+func checkVersionMismatch(version zfsVersion) error {
+	if version.Userland == "" || version.Kernel == "" {
+		return nil
+	}
+	if releaseSeries(version.Userland) != releaseSeries(version.Kernel) {
+		return fmt.Errorf("zfs userland %s does not match kernel module %s", version.Userland, version.Kernel)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseZFSVersion(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   zfsVersion
+	}{
+		{"both", "zfs-2.2.6-1\nzfs-kmod-2.2.6-1\n", zfsVersion{Userland: "2.2.6-1", Kernel: "2.2.6-1"}},
+		{"mismatch", "zfs-2.3.0-1\nzfs-kmod-2.2.7-1\n", zfsVersion{Userland: "2.3.0-1", Kernel: "2.2.7-1"}},
+		{"module not loaded", "zfs-2.2.6-1\n", zfsVersion{Userland: "2.2.6-1"}},
+		{"empty", "", zfsVersion{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseZFSVersion(tt.output); got != tt.want {
+				t.Errorf("parseZFSVersion() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogZFSVersion(t *testing.T) {
+	mockProvider := &mockZFSProvider{}
+	want := zfsVersion{Userland: "2.2.6-1", Kernel: "2.2.6-1"}
+	if got := logZFSVersion(t.Context(), mockProvider, "/fake/zpool"); got != want {
+		t.Errorf("logZFSVersion() = %+v, want %+v", got, want)
+	}
+
+	mockProvider.GetVersionFunc = func(_ context.Context, _ string) ([]byte, error) {
+		return []byte("unrecognized command 'version'"), errors.New("exit status 2")
+	}
+	if got := logZFSVersion(t.Context(), mockProvider, "/fake/zpool"); got != (zfsVersion{}) {
+		t.Errorf("logZFSVersion() on failure = %+v, want zero value", got)
+	}
+}
+
+func TestLiveZFSProvider_ListPoolFeatures(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldPath := zfsPoolFeaturesPath
+	zfsPoolFeaturesPath = tmpDir
+	t.Cleanup(func() {
+		zfsPoolFeaturesPath = oldPath
+	})
+
+	for _, feature := range []string{"org.openzfs:draid", "com.delphix:embedded_data"} {
+		if err := os.Mkdir(filepath.Join(tmpDir, feature), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	features, err := (&liveZFSProvider{}).ListPoolFeatures()
+	if err != nil {
+		t.Fatalf("ListPoolFeatures() returned an unexpected error: %v", err)
+	}
+	if !slices.Equal(features, []string{"com.delphix:embedded_data", "org.openzfs:draid"}) {
+		t.Errorf("ListPoolFeatures() = %v", features)
+	}
+}
+
+func TestCheckVersionMismatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		version zfsVersion
+		wantErr bool
+	}{
+		{"identical", zfsVersion{Userland: "2.2.6-1", Kernel: "2.2.6-1"}, false},
+		{"patch level differs", zfsVersion{Userland: "2.2.7-1", Kernel: "2.2.6-1"}, false},
+		{"minor differs", zfsVersion{Userland: "2.3.0-1", Kernel: "2.2.7-1"}, true},
+		{"major differs", zfsVersion{Userland: "3.0.0", Kernel: "2.3.0"}, true},
+		{"release candidate", zfsVersion{Userland: "2.3.0-rc5", Kernel: "2.3.0-rc5"}, false},
+		{"kernel unknown", zfsVersion{Userland: "2.2.6-1"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkVersionMismatch(tt.version); (err != nil) != tt.wantErr {
+				t.Errorf("checkVersionMismatch(%+v) error = %v, wantErr %v", tt.version, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBuildInfo(t *testing.T) {
+	oldVersion, oldCommit, oldDate := buildVersion, buildCommit, buildDate
+	t.Cleanup(func() { buildVersion, buildCommit, buildDate = oldVersion, oldCommit, oldDate })
+	buildVersion, buildCommit, buildDate = "1.4.0", "0123abc", "2025-03-10T12:00:00Z"
+
+	info := currentBuildInfo()
+	if info.Version != "1.4.0" || info.Commit != "0123abc" || info.Date != "2025-03-10T12:00:00Z" || info.OpenZFS != openZFSCompatibility {
+		t.Errorf("Unexpected build info %+v", info)
+	}
+	var out strings.Builder
+	printBuildInfo(&out, info)
+	for _, want := range []string{"create-zpool 1.4.0\n", "commit:     0123abc\n", "openzfs:    " + openZFSCompatibility + "\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in the version output, got:\n%s", want, out.String())
+		}
+	}
+
+	buildCommit, buildDate = "", ""
+	if info := currentBuildInfo(); info.Commit == "" || info.Date == "" {
+		t.Errorf("Expected a placeholder for missing build metadata, got %+v", info)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const zvolDevDir = "/dev/zvol" // Where the ZFS udev rules link the device nodes of zvols.
+
+// volumePollInterval is how often the device node of a new zvol is looked for.
+var volumePollInterval = 100 * time.Millisecond
+
+// volumeTriggerInterval is how often the udev events of the zvols are replayed while the device
+// node of a new zvol is missing, since the kernel may only create the zvol after the first replay.
+var volumeTriggerInterval = time.Second
+
+// volumeDevicePath returns the device path of a zvol, e.g. /dev/zvol/tank/swap.
+func volumeDevicePath(dataset string) string {
+	return zvolDevDir + "/" + dataset
+}
+
+// volumePresets are named property sets for common zvol uses, selected with ZPOOL_<n>_ZVOL_<m>_PRESET.
+// Properties configured for the zvol itself take precedence.
+var volumePresets = map[string]map[string]string{
+	// Virtual machine disks: blocks that match typical guest filesystem I/O, and honoring
+	// the guest's flushes.
+	"kvm-disk": {"volblocksize": "16K", "compression": "lz4", "sync": "standard"},
+	// iSCSI LUNs: larger blocks for mostly sequential initiator I/O.
+	"iscsi-lun": {"volblocksize": "64K", "compression": "lz4", "sync": "standard"},
+	// Databases with 8K pages, like PostgreSQL, tuned for commit latency.
+	"database": {"volblocksize": "8K", "compression": "lz4", "sync": "standard", "logbias": "latency"},
+}
+
+// volumeConfig is a zvol created in a pool.
+type volumeConfig struct {
+	Name       string            // Name below the root dataset, e.g. "vms/web01".
+	Size       uint64            // volsize in bytes.
+	Preset     string            // Name of a preset in volumePresets, empty for none.
+	Properties map[string]string // Properties set at creation, overriding the preset.
+}
+
+// properties returns the properties of the zvol: the preset, overridden by its own properties.
+func (v volumeConfig) properties() map[string]string {
+	props := maps.Clone(volumePresets[v.Preset])
+	if props == nil {
+		props = make(map[string]string)
+	}
+	maps.Copy(props, v.Properties)
+	return props
+}
+
+var (
+	// volumeNamePattern matches zvol names below the root dataset, one or more components of
+	// the characters ZFS allows in dataset names.
+	volumeNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]+(/[A-Za-z0-9_.:-]+)*$`)
+	// volumePropertyPattern matches property names, including user properties like com.example:owner.
+	volumePropertyPattern = regexp.MustCompile(`^[a-z][a-z0-9_.:-]*$`)
+)
+
+// parseVolumeConfigs reads ZPOOL_<n>_ZVOL_<m>_NAME, _SIZE, _PRESET and _PROPERTIES for pool index i.
+// _PROPERTIES is a comma separated list of property=value pairs.
+func parseVolumeConfigs(i int) ([]volumeConfig, []error) {
+	var volumes []volumeConfig
+	var errs []error
+	for j := 0; ; j++ {
+		prefix := fmt.Sprintf("ZPOOL_%d_ZVOL_%d_", i, j)
+		name := strings.TrimSpace(os.Getenv(prefix + "NAME"))
+		if name == "" {
+			break
+		}
+		volume := volumeConfig{Name: name, Preset: strings.ToLower(strings.TrimSpace(os.Getenv(prefix + "PRESET"))), Properties: make(map[string]string)}
+		components := strings.Split(name, "/")
+		if !volumeNamePattern.MatchString(name) || slices.Contains(components, ".") || slices.Contains(components, "..") {
+			errs = append(errs, fmt.Errorf("invalid %sNAME %q", prefix, name))
+		} else if name == swapVolume {
+			errs = append(errs, fmt.Errorf("%sNAME %q is reserved for ZPOOL_%d_SWAP_SIZE", prefix, name, i))
+		}
+		size, err := parseSizeInBytes(os.Getenv(prefix + "SIZE"))
+		if err != nil || size == 0 {
+			errs = append(errs, fmt.Errorf("invalid %sSIZE %q", prefix, os.Getenv(prefix+"SIZE")))
+		}
+		volume.Size = size
+		if _, ok := volumePresets[volume.Preset]; volume.Preset != "" && !ok {
+			errs = append(errs, fmt.Errorf("unknown %sPRESET %q, must be one of %s", prefix, volume.Preset, strings.Join(slices.Sorted(maps.Keys(volumePresets)), ", ")))
+		}
+		for item := range strings.SplitSeq(os.Getenv(prefix+"PROPERTIES"), ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			prop, value, ok := strings.Cut(item, "=")
+			prop, value = strings.ToLower(strings.TrimSpace(prop)), strings.TrimSpace(value)
+			if !ok || value == "" || !volumePropertyPattern.MatchString(prop) {
+				errs = append(errs, fmt.Errorf("invalid %sPROPERTIES entry %q, must be property=value", prefix, item))
+				continue
+			}
+			volume.Properties[prop] = value
+		}
+		volumes = append(volumes, volume)
+	}
+	return volumes, errs
+}
+
+// ensureVolumes creates the configured zvols of a pool that do not exist yet and makes sure the
+// device node of every one of them exists. Existing zvols are left alone, their size and
+// properties are not reconciled.
+func (s *runState) ensureVolumes(ctx context.Context, provider zfsProvider, config poolConfig) error {
+	if len(config.Volumes) == 0 {
+		return nil
+	}
+	if s.zfsPath == "" {
+		return fmt.Errorf("zfs binary not found, cannot create the %d configured zvols", len(config.Volumes))
+	}
+	existing, err := provider.ListVolumes(ctx, s.zfsPath, config.Name)
+	if err != nil {
+		return fmt.Errorf("failed to list zvols: %w", err)
+	}
+	for _, volume := range config.Volumes {
+		dataset := config.Name + "/" + volume.Name
+		if _, ok := existing[dataset]; !ok {
+			slog.Info("Creating zvol", "pool", config.Name, "zvol", dataset, "size", volume.Size, "preset", volume.Preset)
+			if output, err := provider.CreateVolume(ctx, s.zfsPath, dataset, volume.Size, volume.properties()); err != nil {
+				return fmt.Errorf("failed to create zvol %s: %w, output: %s", dataset, err, string(output))
+			}
+		}
+		if _, err := s.waitForVolume(ctx, provider, dataset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForVolume waits until the device node of a newly created zvol exists. The node is created
+// by udev, which may lag behind `zfs create` or miss its event altogether, e.g. while it is still
+// starting during boot, so the events of the zvols are replayed before settling and again while
+// the node is missing. Consumers like swap, iSCSI targets or VMs need the path right away, so a
+// node that does not show up within the udev settle timeout (or a minute, if settling is
+// disabled) is an error. In a dry run there is nothing to wait for.
+func (s *runState) waitForVolume(ctx context.Context, provider zfsProvider, dataset string) (string, error) {
+	path := volumeDevicePath(dataset)
+	if s.dryRun {
+		return path, nil
+	}
+	triggerVolumeEvents(provider, dataset)
+	triggered := time.Now()
+	s.settleUdev(ctx, provider, "after creating zvol "+dataset)
+	timeout := s.udevSettleTimeout
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(volumePollInterval)
+	defer ticker.Stop()
+	for {
+		if ok, _ := provider.IsBlockDevice(path); ok {
+			return path, nil
+		}
+		if time.Since(triggered) >= volumeTriggerInterval {
+			triggerVolumeEvents(provider, dataset)
+			triggered = time.Now()
+		}
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("device node %s of zvol %s did not appear within %s, check that the ZFS udev rules are installed", path, dataset, timeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+// triggerVolumeEvents replays the udev events of the zvols for the device node of dataset. A
+// failure is only logged, the node may appear anyway.
+func triggerVolumeEvents(provider zfsProvider, dataset string) {
+	if err := provider.TriggerVolumeEvents(); err != nil {
+		slog.Warn("Failed to trigger udev events for zvols", "zvol", dataset, "error", err)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseVolumeConfigs(t *testing.T) {
+	t.Setenv("ZPOOL_0_ZVOL_0_NAME", "vms/web01")
+	t.Setenv("ZPOOL_0_ZVOL_0_SIZE", "20G")
+	t.Setenv("ZPOOL_0_ZVOL_0_PRESET", "KVM-Disk")
+	t.Setenv("ZPOOL_0_ZVOL_0_PROPERTIES", "compression=zstd, com.example:owner=web")
+	t.Setenv("ZPOOL_0_ZVOL_1_NAME", "scratch")
+	t.Setenv("ZPOOL_0_ZVOL_1_SIZE", "1T")
+
+	volumes, errs := parseVolumeConfigs(0)
+	if len(errs) != 0 {
+		t.Fatalf("parseVolumeConfigs() returned unexpected errors: %v", errs)
+	}
+	if len(volumes) != 2 || volumes[0].Name != "vms/web01" || volumes[0].Size != 20<<30 || volumes[0].Preset != "kvm-disk" ||
+		volumes[1].Name != "scratch" || volumes[1].Preset != "" {
+		t.Fatalf("Unexpected volumes %+v", volumes)
+	}
+	want := map[string]string{"volblocksize": "16K", "compression": "zstd", "sync": "standard", "com.example:owner": "web"}
+	if got := volumes[0].properties(); !maps.Equal(got, want) {
+		t.Errorf("properties() = %v, want %v", got, want)
+	}
+	if got := volumes[1].properties(); len(got) != 0 {
+		t.Errorf("Expected no properties without a preset, got %v", got)
+	}
+
+	t.Setenv("ZPOOL_0_ZVOL_0_NAME", "../web01")
+	t.Setenv("ZPOOL_0_ZVOL_0_PRESET", "vmware")
+	t.Setenv("ZPOOL_0_ZVOL_0_PROPERTIES", "compression")
+	t.Setenv("ZPOOL_0_ZVOL_1_NAME", "swap")
+	t.Setenv("ZPOOL_0_ZVOL_1_SIZE", "")
+	_, errs = parseVolumeConfigs(0)
+	for _, want := range []string{"invalid ZPOOL_0_ZVOL_0_NAME", "unknown ZPOOL_0_ZVOL_0_PRESET", "invalid ZPOOL_0_ZVOL_0_PROPERTIES", "is reserved", "invalid ZPOOL_0_ZVOL_1_SIZE"} {
+		if !slices.ContainsFunc(errs, func(err error) bool { return strings.Contains(err.Error(), want) }) {
+			t.Errorf("Expected an error containing %q, got %v", want, errs)
+		}
+	}
+}
+
+func TestEnsureVolumes(t *testing.T) {
+	config := poolConfig{Name: "tank", Volumes: []volumeConfig{
+		{Name: "vms/web01", Size: 20 << 30, Preset: "kvm-disk"},
+		{Name: "existing", Size: 1 << 30},
+	}}
+	var created, waited []string
+	mockProvider := &mockZFSProvider{
+		ListVolumesFunc: func(ctx context.Context, zfsPath, pool string) (map[string]string, error) {
+			return map[string]string{"tank/existing": "1073741824"}, nil
+		},
+		CreateVolumeFunc: func(ctx context.Context, zfsPath, dataset string, size uint64, props map[string]string) ([]byte, error) {
+			created = append(created, dataset)
+			if props["volblocksize"] != "16K" {
+				t.Errorf("Expected the preset properties, got %v", props)
+			}
+			return nil, nil
+		},
+		IsBlockDeviceFunc: func(path string) (bool, error) {
+			waited = append(waited, path)
+			return true, nil
+		},
+	}
+	state := newRunState(nil)
+	state.zfsPath = "/fake/zfs"
+	if err := state.ensureVolumes(t.Context(), mockProvider, config); err != nil {
+		t.Fatalf("ensureVolumes() returned an unexpected error: %v", err)
+	}
+	if !slices.Equal(created, []string{"tank/vms/web01"}) {
+		t.Errorf("Expected only the missing zvol to be created, got %q", created)
+	}
+	if want := []string{"/dev/zvol/tank/vms/web01", "/dev/zvol/tank/existing"}; !slices.Equal(waited, want) {
+		t.Errorf("Expected the device nodes of all zvols to be checked, got %q, want %q", waited, want)
+	}
+}
+
+func TestWaitForVolume_Timeout(t *testing.T) {
+	mockProvider := &mockZFSProvider{IsBlockDeviceFunc: func(path string) (bool, error) { return false, os.ErrNotExist }}
+	state := newRunState(nil)
+	state.udevSettleTimeout = 50 * time.Millisecond
+	_, err := state.waitForVolume(t.Context(), mockProvider, "tank/swap")
+	if err == nil || !strings.Contains(err.Error(), "tank/swap did not appear within 50ms") {
+		t.Errorf("Expected a timeout error, got %v", err)
+	}
+
+	state.dryRun = true
+	if path, err := state.waitForVolume(t.Context(), mockProvider, "tank/swap"); err != nil || path != "/dev/zvol/tank/swap" {
+		t.Errorf("Expected no waiting in a dry run, got %q, %v", path, err)
+	}
+}
+
+func TestWaitForVolume_TriggersEvents(t *testing.T) {
+	oldPoll, oldTrigger := volumePollInterval, volumeTriggerInterval
+	volumePollInterval, volumeTriggerInterval = 5*time.Millisecond, 20*time.Millisecond
+	t.Cleanup(func() {
+		volumePollInterval, volumeTriggerInterval = oldPoll, oldTrigger
+	})
+
+	// The node only appears once udev saw the event it missed, here the second replay, since
+	// the zvol was not there yet at the first one.
+	var triggers int
+	settledAfter := -1
+	mockProvider := &mockZFSProvider{
+		TriggerVolumeEventsFunc: func() error {
+			triggers++
+			return nil
+		},
+		SettleUdevFunc: func(ctx context.Context, timeout time.Duration) error {
+			settledAfter = triggers
+			return nil
+		},
+		IsBlockDeviceFunc: func(path string) (bool, error) { return triggers >= 2, nil },
+	}
+	state := newRunState(nil)
+	state.udevSettleTimeout = 5 * time.Second
+	path, err := state.waitForVolume(t.Context(), mockProvider, "tank/swap")
+	if err != nil || path != "/dev/zvol/tank/swap" {
+		t.Fatalf("waitForVolume() = %q, %v", path, err)
+	}
+	if triggers != 2 || settledAfter != 1 {
+		t.Errorf("Expected the events to be replayed before settling and once more, got %d replays, settled after %d", triggers, settledAfter)
+	}
+}
+
+func TestLiveZFSProvider_TriggerVolumeEvents(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldPath := sysClassBlockPath
+	sysClassBlockPath = tmpDir
+	t.Cleanup(func() {
+		sysClassBlockPath = oldPath
+	})
+	for _, name := range []string{"zd0", "zd16p1", "sda"} {
+		if err := os.MkdirAll(filepath.Join(tmpDir, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(tmpDir, name, "uevent"), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := (&liveZFSProvider{}).TriggerVolumeEvents(); err != nil {
+		t.Fatalf("TriggerVolumeEvents() returned an unexpected error: %v", err)
+	}
+	for name, want := range map[string]string{"zd0": "add", "zd16p1": "add", "sda": ""} {
+		if data, _ := os.ReadFile(filepath.Join(tmpDir, name, "uevent")); string(data) != want {
+			t.Errorf("uevent of %s = %q, want %q", name, data, want)
+		}
+	}
+}
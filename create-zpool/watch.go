@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// monitorSettings controls the checks run on the configured pools after each run and, in
+// watch mode, periodically.
+type monitorSettings struct {
+	AutoClear       bool            // Clear error counters once they stopped increasing, see autoClearErrors.
+	AutoClearWindow time.Duration   // How long error counters must stay unchanged before they are cleared.
+	Thresholds      errorThresholds // Error thresholds for taking devices offline, see enforceErrorThresholds.
+	Capacity        []int           // Ascending capacity warning thresholds in percent, see checkCapacity.
+	TrendRetention  time.Duration   // How long usage samples are kept, zero disables recording, see updateTrends.
+	MetricsFile     string          // Where to write usage metrics in the Prometheus text format, if set.
+	HeartbeatFile   string          // Where watch mode writes its heartbeat, if set, see writeHeartbeat.
+	History         bool            // Record the pool history caused by this tool, see recordPoolHistory.
+	Notifiers       []notifySink    // Where to send notifications about pool events, see poolEvents.
+	// HeartbeatInterval is how often the heartbeat is written, independent of the check interval.
+	HeartbeatInterval time.Duration
+	// CheckpointRetention is how long upgrade checkpoints are kept, zero keeps them until they
+	// are discarded by hand, see discardExpiredCheckpoints.
+	CheckpointRetention time.Duration
+}
+
+// parseMonitorSettings reads ZPOOL_AUTO_CLEAR, ZPOOL_AUTO_CLEAR_WINDOW, ZPOOL_CAPACITY_THRESHOLDS,
+// ZPOOL_TREND_RETENTION, ZPOOL_METRICS_FILE, ZPOOL_HEARTBEAT_FILE, ZPOOL_HEARTBEAT_INTERVAL,
+// ZPOOL_RECORD_HISTORY, ZPOOL_CHECKPOINT_RETENTION, the error thresholds and the notification sinks.
+func parseMonitorSettings() (monitorSettings, error) {
+	var settings monitorSettings
+	var err error
+	if settings.Capacity, err = parseCapacityThresholds(getEnv("ZPOOL_CAPACITY_THRESHOLDS", defaultCapacityThresholds)); err != nil {
+		return settings, err
+	}
+	if settings.TrendRetention, err = getEnvDuration("ZPOOL_TREND_RETENTION", defaultTrendRetention); err != nil {
+		return settings, err
+	}
+	settings.MetricsFile = os.Getenv("ZPOOL_METRICS_FILE")
+	if settings.MetricsFile != "" && !filepath.IsAbs(settings.MetricsFile) {
+		return settings, fmt.Errorf("ZPOOL_METRICS_FILE must be an absolute path, got %q", settings.MetricsFile)
+	}
+	settings.HeartbeatFile = os.Getenv("ZPOOL_HEARTBEAT_FILE")
+	if settings.HeartbeatFile != "" && !filepath.IsAbs(settings.HeartbeatFile) {
+		return settings, fmt.Errorf("ZPOOL_HEARTBEAT_FILE must be an absolute path, got %q", settings.HeartbeatFile)
+	}
+	if settings.HeartbeatInterval, err = getEnvDuration("ZPOOL_HEARTBEAT_INTERVAL", defaultHeartbeatInterval); err != nil {
+		return settings, err
+	}
+	if settings.HeartbeatInterval <= 0 {
+		return settings, fmt.Errorf("ZPOOL_HEARTBEAT_INTERVAL must be positive, got %s", settings.HeartbeatInterval)
+	}
+	if settings.History, err = getEnvBool("ZPOOL_RECORD_HISTORY", true); err != nil {
+		return settings, err
+	}
+	if settings.CheckpointRetention, err = getEnvDuration("ZPOOL_CHECKPOINT_RETENTION", defaultCheckpointRetention); err != nil {
+		return settings, err
+	}
+	if settings.Notifiers, err = parseNotifySinks(); err != nil {
+		return settings, err
+	}
+	if settings.Thresholds, err = parseErrorThresholds(); err != nil {
+		return settings, err
+	}
+	if settings.AutoClear, err = getEnvBool("ZPOOL_AUTO_CLEAR", false); err != nil {
+		return settings, err
+	}
+	if settings.AutoClearWindow, err = getEnvDuration("ZPOOL_AUTO_CLEAR_WINDOW", defaultAutoClearWindow); err != nil {
+		return settings, err
+	}
+	if settings.AutoClear && settings.AutoClearWindow <= 0 {
+		return settings, fmt.Errorf("ZPOOL_AUTO_CLEAR_WINDOW must be positive, got %s", settings.AutoClearWindow)
+	}
+	return settings, nil
+}
+
+// disabledMonitorSettings returns settings with all checks and notifications off.
+func disabledMonitorSettings() monitorSettings {
+	return monitorSettings{HeartbeatInterval: defaultHeartbeatInterval}
+}
+
+// poolMonitor runs the checks on the configured pools after each run and, in watch mode,
+// periodically. It keeps the state that only makes sense between checks of the same process.
+type poolMonitor struct {
+	settings  monitorSettings
+	version   zfsVersion              // ZFS versions, exported with the metrics.
+	started   time.Time               // When the process started, older pool history is not recorded.
+	baselines map[string]deviceErrors // Error counters at the start of the threshold window, by "<pool>/<device>".
+}
+
+// newPoolMonitor creates a monitor with the given settings.
+func newPoolMonitor(settings monitorSettings) *poolMonitor {
+	return &poolMonitor{settings: settings, started: time.Now(), baselines: make(map[string]deviceErrors)}
+}
+
+// check reports the health of the named pools and runs the enabled checks on them, using a
+// single status lookup. Last, the pool history of everything done so far is recorded.
+func (m *poolMonitor) check(ctx context.Context, provider zfsProvider, zpoolPath, stateDir string, names []string) {
+	cache := newPoolStatusCache(provider, zpoolPath)
+	reportPoolHealth(ctx, cache, names)
+	if len(m.settings.Notifiers) > 0 {
+		m.notifyPoolEvents(ctx, cache, stateDir, names)
+	}
+	if len(m.settings.Capacity) > 0 {
+		checkCapacity(ctx, provider, zpoolPath, names, m.settings.Capacity)
+	}
+	updateTrends(ctx, provider, zpoolPath, stateDir, names, m.settings.TrendRetention, m.settings.MetricsFile, m.version, time.Now())
+	if m.settings.AutoClear || m.settings.Thresholds.enabled() {
+		m.checkErrors(ctx, provider, zpoolPath, stateDir, names, cache)
+	}
+	if m.settings.CheckpointRetention > 0 {
+		discardExpiredCheckpoints(ctx, provider, zpoolPath, stateDir, names, m.settings.CheckpointRetention, time.Now())
+	}
+	if m.settings.History {
+		recordPoolHistory(ctx, provider, zpoolPath, stateDir, names, m.started)
+	}
+}
+
+// notifyPoolEvents sends notifications for changes of the pool health and for scrubs that found
+// errors, remembering what was notified in the state file.
+func (m *poolMonitor) notifyPoolEvents(ctx context.Context, cache *poolStatusCache, stateDir string, names []string) {
+	statuses, err := cache.Status(ctx, names)
+	if err != nil {
+		// Already reported by the health check.
+		return
+	}
+	st, err := loadState(stateDir)
+	if err != nil {
+		slog.Warn("Failed to load state, not sending notifications", "state_dir", stateDir, "error", err)
+		return
+	}
+	events := poolEvents(statuses, st.Notified, time.Now())
+	if len(events) == 0 {
+		return
+	}
+	if err := saveState(stateDir, st); err != nil {
+		slog.Warn("Failed to save notification state", "state_dir", stateDir, "error", err)
+	}
+	for _, n := range events {
+		m.notify(ctx, n)
+	}
+}
+
+// notify sends a notification to the configured sinks, see notifyAll.
+func (m *poolMonitor) notify(ctx context.Context, n notification) {
+	notifyAll(ctx, m.settings.Notifiers, n)
+}
+
+// checkErrors takes devices past the error thresholds offline and clears stale error counters.
+func (m *poolMonitor) checkErrors(ctx context.Context, provider zfsProvider, zpoolPath, stateDir string, names []string, cache *poolStatusCache) {
+
+	statuses, err := cache.Status(ctx, names)
+	if err != nil {
+		slog.Warn("Failed to collect pool status, not checking error counters", "error", err)
+		return
+	}
+	now := time.Now()
+	if m.settings.Thresholds.enabled() {
+		enforceErrorThresholds(ctx, provider, zpoolPath, statuses, m.settings.Thresholds, m.baselines, now)
+	}
+	if !m.settings.AutoClear {
+		return
+	}
+	st, err := loadState(stateDir)
+	if err != nil {
+		slog.Warn("Failed to load state, not clearing errors", "state_dir", stateDir, "error", err)
+		return
+	}
+	if autoClearErrors(ctx, provider, zpoolPath, st, statuses, m.settings.AutoClearWindow, now) {
+		if err := saveState(stateDir, st); err != nil {
+			slog.Warn("Failed to save error counters", "state_dir", stateDir, "error", err)
+		}
+	}
+}
+
+// watchMain keeps the service running after a successful run and monitors the named pools
+// every interval until the service is stopped. Iterations are skipped while paused. The
+// heartbeat file, if configured, is updated from the same loop, so that it goes stale when
+// the loop is wedged. A change of the configuration file restarts the run, see configReloader;
+// a nil reload disables that.
+func watchMain(ctx context.Context, provider zfsProvider, zpoolPath, stateDir string, names []string, interval time.Duration, monitor *poolMonitor, reload *configReloader) int {
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	slog.Info("Watching pools", "interval", interval, "pools", names)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// The run that started watch mode has just checked the pools.
+	hb := heartbeat{Started: monitor.started, LastCheck: time.Now(), NextCheck: time.Now().Add(interval), PID: os.Getpid()}
+	var beat <-chan time.Time
+	if monitor.settings.HeartbeatFile != "" {
+		beatTicker := time.NewTicker(monitor.settings.HeartbeatInterval)
+		defer beatTicker.Stop()
+		beat = beatTicker.C
+	}
+	writeBeat := func() {
+		if monitor.settings.HeartbeatFile == "" {
+			return
+		}
+		hb.Time = time.Now()
+		if err := writeHeartbeat(monitor.settings.HeartbeatFile, hb); err != nil {
+			slog.Warn("Failed to write heartbeat file", "path", monitor.settings.HeartbeatFile, "error", err)
+		}
+	}
+	writeBeat()
+
+	for {
+		fileChanged := false
+		select {
+		case <-ctx.Done():
+			slog.Info("Stopping to watch pools.")
+			return 0
+		case <-beat:
+			writeBeat()
+			continue
+		case <-reload.changes():
+			if fileChanged = reload.changed(); !fileChanged {
+				continue
+			}
+		case <-ticker.C:
+			hb.NextCheck = time.Now().Add(interval)
+		}
+
+		isPaused, err := paused(stateDir)
+		if err != nil {
+			slog.Error("Cannot determine whether execution is paused, skipping checks", "state_dir", stateDir, "error", err)
+			continue
+		}
+		hb.Paused = isPaused
+		if isPaused {
+			slog.Info("Pause file found, skipping checks.")
+			writeBeat()
+			continue
+		}
+		// A changed file is applied once the pause is lifted, since a paused run would exit.
+		if reload.changed() {
+			reload.reload()
+		}
+		if fileChanged {
+			continue
+		}
+		monitor.check(ctx, provider, zpoolPath, stateDir, names)
+		hb.LastCheck = time.Now()
+		writeBeat()
+	}
+}
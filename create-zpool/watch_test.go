@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseMonitorSettings(t *testing.T) {
+	t.Setenv("ZPOOL_AUTO_CLEAR", "true")
+	t.Setenv("ZPOOL_AUTO_CLEAR_WINDOW", "")
+	settings, err := parseMonitorSettings()
+	if err != nil || !settings.AutoClear || settings.AutoClearWindow != defaultAutoClearWindow {
+		t.Errorf("parseMonitorSettings() = %+v, %v", settings, err)
+	}
+
+	t.Setenv("ZPOOL_AUTO_CLEAR_WINDOW", "0")
+	if _, err := parseMonitorSettings(); err == nil {
+		t.Error("Expected an error for a zero window with auto clear enabled")
+	}
+	t.Setenv("ZPOOL_AUTO_CLEAR_WINDOW", "")
+
+	t.Setenv("ZPOOL_HEARTBEAT_FILE", "heartbeat.json")
+	if _, err := parseMonitorSettings(); err == nil {
+		t.Error("Expected an error for a relative heartbeat file")
+	}
+}
+
+func TestWatchMain(t *testing.T) {
+	stateDir := t.TempDir()
+	var polls atomic.Int32
+	ctx, cancel := context.WithCancel(t.Context())
+	mockProvider := &mockZFSProvider{
+		GetAllPoolStatusFunc: func(ctx context.Context, zpoolPath string) ([]byte, error) {
+			if polls.Add(1) == 3 {
+				// Pausing skips the checks until the service is stopped.
+				if err := os.WriteFile(filepath.Join(stateDir, pauseFile), nil, 0o644); err != nil {
+					t.Error(err)
+				}
+				time.AfterFunc(20*time.Millisecond, cancel)
+			}
+			return []byte(testPoolStatusJSON), nil
+		},
+	}
+
+	if code := watchMain(ctx, mockProvider, "/fake/zpool", stateDir, []string{"tank"}, time.Millisecond, newPoolMonitor(monitorSettings{}), nil); code != 0 {
+		t.Errorf("watchMain() = %d, want 0", code)
+	}
+	if got := polls.Load(); got != 3 {
+		t.Errorf("Expected 3 checks before pausing, got %d", got)
+	}
+}
+
+func TestWatchMain_Heartbeat(t *testing.T) {
+	stateDir := t.TempDir()
+	path := filepath.Join(t.TempDir(), "heartbeat.json")
+	var polls atomic.Int32
+	ctx, cancel := context.WithCancel(t.Context())
+	mockProvider := &mockZFSProvider{
+		GetAllPoolStatusFunc: func(ctx context.Context, zpoolPath string) ([]byte, error) {
+			if polls.Add(1) == 2 {
+				time.AfterFunc(20*time.Millisecond, cancel)
+			}
+			return []byte(testPoolStatusJSON), nil
+		},
+	}
+	monitor := newPoolMonitor(monitorSettings{HeartbeatFile: path, HeartbeatInterval: time.Millisecond})
+	if code := watchMain(ctx, mockProvider, "/fake/zpool", stateDir, []string{"tank"}, 5*time.Millisecond, monitor, nil); code != 0 {
+		t.Errorf("watchMain() = %d, want 0", code)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Expected a heartbeat file: %v", err)
+	}
+	var hb heartbeat
+	if err := json.Unmarshal(data, &hb); err != nil {
+		t.Fatalf("Invalid heartbeat %s: %v", data, err)
+	}
+	if hb.PID != os.Getpid() || hb.Paused || !hb.Started.Equal(monitor.started) {
+		t.Errorf("Unexpected heartbeat %+v", hb)
+	}
+	if hb.LastCheck.Before(hb.Started) || hb.Time.Before(hb.LastCheck) || !hb.NextCheck.After(hb.LastCheck) {
+		t.Errorf("Heartbeat timestamps out of order: %+v", hb)
+	}
+}
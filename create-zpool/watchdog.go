@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWatchdogTimeout = 30 * time.Minute // Longest a phase may go without progress, see ZPOOL_WATCHDOG_TIMEOUT.
+
+	exitHung = 4 // Exit code when the watchdog found a phase of the run hung.
+)
+
+// watchdogCheckInterval is how often the watchdog checks the current phase.
+var watchdogCheckInterval = 10 * time.Second
+
+// activeWatchdog watches the current run; nil disables the watchdog. All methods of watchdog
+// accept a nil receiver, so that callers don't have to check.
+var activeWatchdog *watchdog
+
+// watchdog detects phases of a run that make no progress for far longer than any of their
+// commands may take. Commands are killed at their deadline, but a blocking bug or a system call
+// stuck on a dying disk could still wedge the node's boot; the watchdog then dumps the
+// goroutine stacks and the in-flight commands and ends the run with exitHung.
+type watchdog struct {
+	out  io.Writer // Where the goroutine stacks are dumped.
+	exit func(int) // Ends the run, os.Exit outside of tests.
+
+	mu       sync.Mutex
+	phase    string        // Current phase, empty between phases.
+	timeout  time.Duration // How long the phase may go without progress.
+	progress time.Time     // Start of the phase or its last progress.
+	calls    map[int]watchdogCall
+	nextCall int
+}
+
+// watchdogCall is an external command in flight, including those abandoned after their deadline.
+type watchdogCall struct {
+	Command string
+	Started time.Time
+}
+
+// newWatchdog creates a watchdog that dumps to out and ends the run with exit.
+func newWatchdog(out io.Writer, exit func(int)) *watchdog {
+	return &watchdog{out: out, exit: exit, calls: make(map[int]watchdogCall)}
+}
+
+// run checks the current phase every watchdogCheckInterval until ctx is done or it found the
+// phase hung.
+func (w *watchdog) run(ctx context.Context) {
+	ticker := time.NewTicker(watchdogCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if w.check(now) {
+				return
+			}
+		}
+	}
+}
+
+// enter starts a phase that may go without progress for timeout. The returned function ends it.
+func (w *watchdog) enter(phase string, timeout time.Duration) func() {
+	if w == nil {
+		return func() {}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.phase, w.timeout, w.progress = phase, timeout, time.Now()
+	return func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if w.phase == phase {
+			w.phase = ""
+		}
+	}
+}
+
+// progressed tells the watchdog that the current phase is still making progress, e.g. on every
+// poll while waiting for disks, so that expected long waits are not mistaken for a hang.
+func (w *watchdog) progressed() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.progress = time.Now()
+}
+
+// trackCall records an external command as in flight. The returned function removes it.
+func (w *watchdog) trackCall(command string) func() {
+	if w == nil {
+		return func() {}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	id := w.nextCall
+	w.nextCall++
+	w.calls[id] = watchdogCall{Command: command, Started: time.Now()}
+	return func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.calls, id)
+	}
+}
+
+// check reports whether the current phase went without progress for longer than its timeout at
+// now. If so, it logs the phase and the in-flight commands, dumps all goroutine stacks and ends
+// the run.
+func (w *watchdog) check(now time.Time) bool {
+	w.mu.Lock()
+	phase, stalled, timeout := w.phase, now.Sub(w.progress), w.timeout
+	var calls []string
+	for _, call := range w.calls {
+		calls = append(calls, fmt.Sprintf("%s (running for %s)", call.Command, now.Sub(call.Started).Round(time.Second)))
+	}
+	w.mu.Unlock()
+	if phase == "" || timeout <= 0 || stalled <= timeout {
+		return false
+	}
+
+	slices.Sort(calls)
+	slog.Error("Watchdog: a phase of the run is hung, dumping goroutine stacks and failing the run",
+		"phase", phase, "without_progress", stalled.Round(time.Second), "timeout", timeout, "commands", strings.Join(calls, "; "))
+	fmt.Fprintf(w.out, "goroutine stacks of hung phase %q:\n%s\n", phase, goroutineStacks())
+	w.exit(exitHung)
+	return true
+}
+
+// goroutineStacks returns the stacks of all goroutines, like an unrecovered panic prints them.
+func goroutineStacks() []byte {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// phaseTimeout is the watchdog timeout of a phase: the configured timeout, but at least twice
+// the longest deadline of the commands in the phase, so that a single slow but healthy command
+// never trips it.
+func phaseTimeout(timeout time.Duration, commandTimeouts ...time.Duration) time.Duration {
+	for _, commandTimeout := range commandTimeouts {
+		timeout = max(timeout, 2*commandTimeout)
+	}
+	return timeout
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchdog_Check(t *testing.T) {
+	var out bytes.Buffer
+	exitCode := -1
+	w := newWatchdog(&out, func(code int) { exitCode = code })
+
+	if w.check(time.Now().Add(time.Hour)) {
+		t.Fatal("Expected no hang outside of a phase")
+	}
+
+	leave := w.enter("pool tank", time.Minute)
+	untrack := w.trackCall("zpool create tank mirror /dev/sda /dev/sdb")
+	if w.check(time.Now().Add(30 * time.Second)) {
+		t.Fatal("Expected no hang within the timeout")
+	}
+	w.progressed()
+	if !w.check(time.Now().Add(2 * time.Minute)) {
+		t.Fatal("Expected a hang after the timeout without progress")
+	}
+	if exitCode != exitHung {
+		t.Errorf("Exit code = %d, want %d", exitCode, exitHung)
+	}
+	if dump := out.String(); !strings.Contains(dump, `hung phase "pool tank"`) || !strings.Contains(dump, "TestWatchdog_Check") {
+		t.Errorf("Expected the goroutine stacks of the hung phase, got:\n%s", dump)
+	}
+
+	untrack()
+	leave()
+	out.Reset()
+	if w.check(time.Now().Add(time.Hour)) || out.Len() > 0 {
+		t.Errorf("Expected no hang after the phase ended")
+	}
+}
+
+func TestWatchdog_ProgressResetsTimer(t *testing.T) {
+	w := newWatchdog(&bytes.Buffer{}, func(int) { t.Error("The watchdog must not fire while the phase progresses") })
+	defer w.enter("pool tank", time.Minute)()
+
+	// Stand in for the polls of a long wait, e.g. for disks to appear.
+	w.mu.Lock()
+	w.progress = time.Now().Add(-50 * time.Second)
+	w.mu.Unlock()
+	w.progressed()
+	if w.check(time.Now().Add(30 * time.Second)) {
+		t.Error("Expected progress to reset the timeout")
+	}
+}
+
+func TestWatchdog_Run(t *testing.T) {
+	oldInterval := watchdogCheckInterval
+	watchdogCheckInterval = 5 * time.Millisecond
+	t.Cleanup(func() { watchdogCheckInterval = oldInterval })
+
+	exited := make(chan int, 1)
+	w := newWatchdog(&bytes.Buffer{}, func(code int) { exited <- code })
+	defer w.enter("startup", 20*time.Millisecond)()
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	go w.run(ctx)
+
+	select {
+	case code := <-exited:
+		if code != exitHung {
+			t.Errorf("Exit code = %d, want %d", code, exitHung)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the watchdog to end the hung run")
+	}
+}
+
+func TestWatchdog_Nil(t *testing.T) {
+	var w *watchdog
+	w.enter("startup", time.Minute)()
+	w.progressed()
+	w.trackCall("zpool list")()
+}
+
+func TestPhaseTimeout(t *testing.T) {
+	if got := phaseTimeout(30*time.Minute, 5*time.Minute); got != 30*time.Minute {
+		t.Errorf("phaseTimeout() = %s, want the configured timeout", got)
+	}
+	if got := phaseTimeout(30*time.Minute, 5*time.Minute, 2*time.Hour, 0); got != 4*time.Hour {
+		t.Errorf("phaseTimeout() = %s, want twice the longest command timeout", got)
+	}
+}
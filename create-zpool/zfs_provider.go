@@ -1,14 +1,28 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 )
 
+// commandWaitDelay is how long a timed out command is given to exit after being killed
+// before it is abandoned (e.g. a process stuck in uninterruptible sleep on a dying disk).
+const commandWaitDelay = 5 * time.Second
+
+// errCommandTimeout is returned (wrapped) when an external command exceeds its deadline.
+var errCommandTimeout = errors.New("command timed out")
+
 // sizeCondition represents a mathematical size condition.
 type sizeCondition struct {
 	operator string // "<", ">", "<=", ">=", "=", "=="
@@ -122,16 +136,128 @@ func parseSizeCondition(s string) (sizeCondition, error) {
 type zfsProvider interface {
 	// LookPath searches for a binary in the system's PATH.
 	LookPath(file string) (string, error)
-	// PoolExists checks if a ZFS pool with the given name already exists.
-	PoolExists(name, zpoolPath string) bool
+	// ListPools returns all currently imported pools as a map of pool name to pool GUID.
+	ListPools(ctx context.Context, zpoolPath string) (map[string]string, error)
 	// CreatePool executes the `zpool create` command with the given arguments.
 	// It returns the combined stdout/stderr output and any execution error.
-	CreatePool(zpoolPath string, args []string) ([]byte, error)
+	CreatePool(ctx context.Context, zpoolPath string, args []string) ([]byte, error)
+	// AddVdevs executes the `zpool add` command with the given arguments.
+	// It returns the combined stdout/stderr output and any execution error.
+	AddVdevs(ctx context.Context, zpoolPath string, args []string) ([]byte, error)
+	// DryRunCreatePool executes `zpool create -n` with the arguments of CreatePool, which
+	// validates them and prints the layout without creating the pool.
+	DryRunCreatePool(ctx context.Context, zpoolPath string, args []string) ([]byte, error)
 	// GetPoolStatus executes the `zpool status` command for the given pool.
 	// It returns the combined stdout/stderr output and any execution error.
-	GetPoolStatus(name, zpoolPath string) ([]byte, error)
+	GetPoolStatus(ctx context.Context, name, zpoolPath string) ([]byte, error)
+	// ListImportablePools scans devices with `zpool import` and returns all exported pools found.
+	ListImportablePools(ctx context.Context, zpoolPath string) ([]importablePool, error)
+	// ImportPool imports the exported pool with the given numeric identifier.
+	// It returns the combined stdout/stderr output and any execution error.
+	ImportPool(ctx context.Context, zpoolPath, id string) ([]byte, error)
+	// ImportPoolReadOnly executes `zpool import -o readonly=on` for the pool with the given identifier.
+	// It returns the combined stdout/stderr output and any execution error.
+	ImportPoolReadOnly(ctx context.Context, zpoolPath, id string) ([]byte, error)
+	// ExportPool executes `zpool export -f` for the given pool.
+	// It returns the combined stdout/stderr output and any execution error.
+	ExportPool(ctx context.Context, zpoolPath, name string) ([]byte, error)
+	// ClearPool executes `zpool clear` for the given pool, which resumes a suspended pool.
+	// It returns the combined stdout/stderr output and any execution error.
+	ClearPool(ctx context.Context, zpoolPath, name string) ([]byte, error)
+	// ClearDevice executes `zpool clear` for a single device of a pool, resetting its error counters.
+	// It returns the combined stdout/stderr output and any execution error.
+	ClearDevice(ctx context.Context, zpoolPath, name, device string) ([]byte, error)
+	// OfflineDevice executes `zpool offline` for a single device of a pool.
+	// It returns the combined stdout/stderr output and any execution error.
+	OfflineDevice(ctx context.Context, zpoolPath, name, device string) ([]byte, error)
+	// ReplaceDevice executes `zpool replace` to replace a device of a pool with another one.
+	// It returns the combined stdout/stderr output and any execution error.
+	ReplaceDevice(ctx context.Context, zpoolPath, name, device, replacement string) ([]byte, error)
+	// AttachDevice executes `zpool attach` to mirror a device of a pool onto another one, with
+	// `-o ashift` unless ashift is empty. It returns the combined stdout/stderr output and any
+	// execution error.
+	AttachDevice(ctx context.Context, zpoolPath, name, device, newDevice, ashift string) ([]byte, error)
+	// DetachDevice executes `zpool detach` to take a device out of its mirror.
+	// It returns the combined stdout/stderr output and any execution error.
+	DetachDevice(ctx context.Context, zpoolPath, name, device string) ([]byte, error)
+	// RemoveVdev executes `zpool remove` to take a top-level vdev, e.g. a log device, out of a pool.
+	// It returns the combined stdout/stderr output and any execution error.
+	RemoveVdev(ctx context.Context, zpoolPath, name, vdev string) ([]byte, error)
+	// InitializePool starts `zpool initialize` for the given pool without waiting for it.
+	// It returns the combined stdout/stderr output and any execution error.
+	InitializePool(ctx context.Context, zpoolPath, name string) ([]byte, error)
+	// GetInitializeStatus executes `zpool status -i` for the given pool, which includes the
+	// per-device initialization progress.
+	GetInitializeStatus(ctx context.Context, zpoolPath, name string) ([]byte, error)
+	// GetPoolProperties returns the current values of the given pool properties using `zpool get`.
+	GetPoolProperties(ctx context.Context, zpoolPath, name string, props []string) (map[string]string, error)
+	// ReadDeviceLabels returns the ZFS labels of a device using `zdb -l`.
+	ReadDeviceLabels(ctx context.Context, zdbPath, device string) ([]byte, error)
+	// GetPoolHistory returns the command history of a pool including internal events and
+	// the issuing user and host, using `zpool history -il`.
+	GetPoolHistory(ctx context.Context, zpoolPath, name string) ([]byte, error)
+	// GetPoolUsage returns the current space usage of a pool using `zpool list`.
+	GetPoolUsage(ctx context.Context, zpoolPath, name string) (poolSample, error)
+	// GetDatasetProperties returns the current values of the given dataset properties using `zfs get`.
+	// Numeric values are returned in parsable (exact) form.
+	GetDatasetProperties(ctx context.Context, zfsPath, dataset string, props []string) (map[string]string, error)
+	// ListDatasets returns the filesystems of a pool, including its root dataset, using `zfs list`.
+	ListDatasets(ctx context.Context, zfsPath, pool string) ([]datasetInfo, error)
+	// LoadKeys executes `zfs load-key -r` to load the keys of all encrypted datasets of a pool.
+	// It returns the combined stdout/stderr output and any execution error.
+	LoadKeys(ctx context.Context, zfsPath, pool string) ([]byte, error)
+	// MountDataset executes `zfs mount` for the given dataset.
+	// It returns the combined stdout/stderr output and any execution error.
+	MountDataset(ctx context.Context, zfsPath, dataset string) ([]byte, error)
+	// SetDatasetProperty executes `zfs set` for the given dataset property.
+	// It returns the combined stdout/stderr output and any execution error.
+	SetDatasetProperty(ctx context.Context, zfsPath, dataset, prop, value string) ([]byte, error)
+	// SnapshotRecursive executes `zfs snapshot -r` for the given snapshot name, e.g. tank@before.
+	// It returns the combined stdout/stderr output and any execution error.
+	SnapshotRecursive(ctx context.Context, zfsPath, snapshot string) ([]byte, error)
+	// CheckpointPool executes `zpool checkpoint` for the given pool.
+	// It returns the combined stdout/stderr output and any execution error.
+	CheckpointPool(ctx context.Context, zpoolPath, name string) ([]byte, error)
+	// DiscardCheckpoint executes `zpool checkpoint -d` for the given pool.
+	// It returns the combined stdout/stderr output and any execution error.
+	DiscardCheckpoint(ctx context.Context, zpoolPath, name string) ([]byte, error)
+	// SplitPool executes `zpool split` to detach one device of every mirror into a new, exported pool.
+	// Empty devices lets zpool take the last device of every mirror.
+	// It returns the combined stdout/stderr output and any execution error.
+	SplitPool(ctx context.Context, zpoolPath, name, newName string, devices []string) ([]byte, error)
+	// UpgradePool executes `zpool upgrade` to enable all supported features of the given pool.
+	// It returns the combined stdout/stderr output and any execution error.
+	UpgradePool(ctx context.Context, zpoolPath, name string) ([]byte, error)
+	// ListVolumes returns the zvols of a pool with their volsize in bytes using `zfs list`.
+	ListVolumes(ctx context.Context, zfsPath, pool string) (map[string]string, error)
+	// CreateVolume executes `zfs create -p -V` to create a zvol of size bytes with the given properties.
+	// It returns the combined stdout/stderr output and any execution error.
+	CreateVolume(ctx context.Context, zfsPath, dataset string, size uint64, props map[string]string) ([]byte, error)
+	// SetPoolProperty executes `zpool set property=value` for the given pool.
+	// It returns the combined stdout/stderr output and any execution error.
+	SetPoolProperty(ctx context.Context, zpoolPath, name, prop, value string) ([]byte, error)
+	// GetVersion executes `zpool version`, which reports the userland and kernel module versions.
+	// It returns the combined stdout/stderr output and any execution error.
+	GetVersion(ctx context.Context, zpoolPath string) ([]byte, error)
+	// GetPropertyHelp executes `zpool get` or `zfs get` without arguments, whose usage message
+	// lists all properties the binary supports. That usage exits non-zero.
+	// It returns the combined stdout/stderr output and any execution error.
+	GetPropertyHelp(ctx context.Context, binPath string) ([]byte, error)
+	// ListPoolFeatures returns the names of the pool features supported by the loaded kernel module.
+	ListPoolFeatures() ([]string, error)
+	// GetAllPoolStatus executes `zpool status -j` for all pools and returns its JSON output.
+	GetAllPoolStatus(ctx context.Context, zpoolPath string) ([]byte, error)
+	// SettleUdev waits until udev has processed all queued events, like `udevadm settle`,
+	// so that device nodes and their symlinks exist. It fails after timeout.
+	SettleUdev(ctx context.Context, timeout time.Duration) error
+	// TriggerVolumeEvents replays the add uevent of every zvol device, like
+	// `udevadm trigger --action=add --sysname-match=zd*`, so that udev creates the /dev/zvol
+	// symlinks of zvols whose events it missed.
+	TriggerVolumeEvents() error
 	// IsBlockDevice checks if the given path corresponds to a block device.
 	IsBlockDevice(path string) (bool, error)
+	// IsCharDevice checks if the given path corresponds to a character device, like /dev/zfs.
+	IsCharDevice(path string) (bool, error)
 	// ResolveDiskByModel scans /sys/block to find a disk matching the model
 	// that is unpartitioned, meets size requirements, and not already marked as used.
 	ResolveDiskByModel(model string, sizeConds []sizeCondition, usedDisks map[string]bool) (string, error)
@@ -139,11 +265,139 @@ type zfsProvider interface {
 	GetDiskSize(path string) (uint64, error)
 	// EvalSymlinks evaluates any symbolic links to return the canonical path.
 	EvalSymlinks(path string) (string, error)
+	// EnsureOwnership sets the owner, group and permission bits of path where they differ.
+	// Negative values leave the corresponding attribute alone. It reports whether anything changed.
+	EnsureOwnership(path string, uid, gid, mode int) (bool, error)
+	// GetQueueInfo returns the request queue attributes of the block device at path.
+	GetQueueInfo(path string) (queueInfo, error)
+	// GetDiskModel returns the model of the disk at path, or of the disk a partition at path is on.
+	GetDiskModel(path string) (string, error)
+	// DiscardDevice discards all blocks of the block device at path. With secure set, the
+	// device must also erase any copies of the data it keeps internally.
+	DiscardDevice(path string, secure bool) error
+	// BurnInDevice destructively writes and verifies a test pattern over the first size bytes
+	// of the block device at path. The pattern is derived from seed.
+	BurnInDevice(path string, size, seed uint64) error
+	// ProbeSyncWriteLatency rewrites the first writes blocks of the block device at path with
+	// their own content, each synchronously, and returns the median latency of the writes.
+	ProbeSyncWriteLatency(path string, writes int) (time.Duration, error)
+	// IsSwapFormatted reports whether the block device at path carries a swap signature, and
+	// whether its first page is blank (all zeros), like that of a zvol that was just created.
+	IsSwapFormatted(path string) (formatted, blank bool, err error)
+	// FormatSwap writes a swap header to the block device at path, like mkswap.
+	FormatSwap(path string) error
+	// EnableSwap starts swapping to the block device at path, like swapon.
+	EnableSwap(path string) error
+	// ReadModuleParameter returns the current value of a ZFS kernel module parameter.
+	ReadModuleParameter(name string) (string, error)
+	// WriteModuleParameter sets a ZFS kernel module parameter.
+	WriteModuleParameter(name, value string) error
+	// GetMemTotal returns the total usable RAM of the node in bytes.
+	GetMemTotal() (uint64, error)
+}
+
+// queueInfo holds the request queue attributes of a block device relevant for discards.
+type queueInfo struct {
+	Rotational      bool   // The device has spinning media.
+	DiscardMaxBytes uint64 // Largest discard the device accepts; zero if it does not support discard.
 }
 
 // liveZFSProvider is the concrete implementation of ZFSProvider that executes
 // real commands and interacts with the live filesystem.
-type liveZFSProvider struct{}
+type liveZFSProvider struct {
+	// commandTimeout is the deadline applied to each external command whose context
+	// does not already carry one. Zero disables the timeout.
+	commandTimeout time.Duration
+}
+
+// commandResult carries the outcome of an external command from its goroutine.
+type commandResult struct {
+	output []byte
+	err    error
+}
+
+// commandOutput selects the output an external command returns.
+type commandOutput int
+
+const (
+	outputStdout   commandOutput = iota // Only stdout.
+	outputCombined                      // Combined stdout and stderr.
+	outputStreamed                      // Combined stdout and stderr, also logged line by line as it is written.
+)
+
+// runCommand runs name with args under the provider's command timeout. It returns the
+// combined stdout/stderr output if combined is set, and only stdout otherwise.
+func (p *liveZFSProvider) runCommand(ctx context.Context, combined bool, name string, args ...string) ([]byte, error) {
+	if combined {
+		return p.execCommand(ctx, outputCombined, name, args...)
+	}
+	return p.execCommand(ctx, outputStdout, name, args...)
+}
+
+// streamCommand runs a command that may take minutes, like zpool create, like runCommand with
+// combined output, but logs the output as it is written.
+func (p *liveZFSProvider) streamCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return p.execCommand(ctx, outputStreamed, name, args...)
+}
+
+// execCommand runs name with args under the provider's command timeout and returns the
+// selected output. A command exceeding its deadline is killed and reported as
+// errCommandTimeout; if it cannot be reaped within commandWaitDelay it is abandoned so the
+// caller can move on.
+func (p *liveZFSProvider) execCommand(ctx context.Context, output commandOutput, name string, args ...string) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok && p.commandTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.commandTimeout)
+		defer cancel()
+	}
+
+	// #nosec G204: Intentionally executing system binaries with configured arguments
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.WaitDelay = commandWaitDelay
+
+	start := time.Now()
+	done := make(chan commandResult, 1)
+	// An abandoned command stays tracked by the watchdog until it actually exits.
+	untrack := activeWatchdog.trackCall(filepath.Base(name) + " " + strings.Join(args, " "))
+	go func() {
+		defer untrack()
+		var res commandResult
+		switch output {
+		case outputStdout:
+			res.output, res.err = cmd.Output()
+		case outputCombined:
+			res.output, res.err = cmd.CombinedOutput()
+		case outputStreamed:
+			var buf bytes.Buffer
+			logger := &outputLogger{command: filepath.Base(name) + " " + strings.Join(args, " ")}
+			w := io.MultiWriter(&buf, logger)
+			cmd.Stdout, cmd.Stderr = w, w
+			res.err = cmd.Run()
+			logger.flush()
+			res.output = buf.Bytes()
+		}
+		done <- res
+	}()
+
+	var res commandResult
+	select {
+	case res = <-done:
+	case <-ctx.Done():
+		select {
+		case res = <-done:
+		case <-time.After(commandWaitDelay):
+			res.err = errors.New("process did not exit after being killed and was abandoned")
+		}
+	}
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		if errors.Is(ctxErr, context.DeadlineExceeded) {
+			return res.output, fmt.Errorf("%w after %s: %s %s: %v", errCommandTimeout, time.Since(start).Round(time.Millisecond), filepath.Base(name), strings.Join(args, " "), res.err)
+		}
+		return res.output, fmt.Errorf("%s %s: %w", filepath.Base(name), strings.Join(args, " "), ctxErr)
+	}
+	return res.output, res.err
+}
 
 // LookPath wraps exec.LookPath.
 func (p *liveZFSProvider) LookPath(file string) (string, error) {
@@ -155,26 +409,242 @@ func (p *liveZFSProvider) EvalSymlinks(path string) (string, error) {
 	return filepath.EvalSymlinks(path)
 }
 
-// PoolExists checks if a ZFS pool with the given name already exists.
-func (p *liveZFSProvider) PoolExists(name, zpoolPath string) bool {
-	// #nosec G204: Intentionally executing system binary with dynamic pool name
-	cmd := exec.Command(zpoolPath, "list", name)
-	// We only care if the command succeeds (exit code 0), not about its output.
-	return cmd.Run() == nil
+// ListPools lists all imported pools using a single `zpool list -H -o name,guid` call.
+func (p *liveZFSProvider) ListPools(ctx context.Context, zpoolPath string) (map[string]string, error) {
+	output, err := p.runCommand(ctx, false, zpoolPath, "list", "-H", "-o", "name,guid")
+	if err != nil {
+		return nil, fmt.Errorf("zpool list failed: %w", err)
+	}
+	return parsePoolList(string(output)), nil
+}
+
+// parsePoolList parses the tab separated output of `zpool list -H -o name,guid`.
+func parsePoolList(output string) map[string]string {
+	pools := make(map[string]string)
+	for line := range strings.Lines(output) {
+		name, guid, _ := strings.Cut(strings.TrimRight(line, "\r\n"), "\t")
+		if name == "" {
+			continue
+		}
+		pools[name] = strings.TrimSpace(guid)
+	}
+	return pools
 }
 
 // CreatePool creates a zpool using the `zpool create` command.
-func (p *liveZFSProvider) CreatePool(zpoolPath string, args []string) ([]byte, error) {
-	// #nosec G204: Intentionally executing system binary with user-configured arguments
-	cmd := exec.Command(zpoolPath, args...)
-	return cmd.CombinedOutput()
+func (p *liveZFSProvider) CreatePool(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+	return p.streamCommand(ctx, zpoolPath, args...)
+}
+
+// AddVdevs adds vdevs to a pool using `zpool add`.
+func (p *liveZFSProvider) AddVdevs(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+	return p.runCommand(ctx, true, zpoolPath, args...)
+}
+
+// DryRunCreatePool validates the arguments of a pool creation using `zpool create -n`.
+func (p *liveZFSProvider) DryRunCreatePool(ctx context.Context, zpoolPath string, args []string) ([]byte, error) {
+	return p.runCommand(ctx, true, zpoolPath, dryRunCreateArgs(args)...)
 }
 
 // GetPoolStatus returns the status of a ZFS pool using the `zpool status` command.
-func (p *liveZFSProvider) GetPoolStatus(name, zpoolPath string) ([]byte, error) {
-	// #nosec G204: Intentionally executing system binary with dynamic pool name
-	cmd := exec.Command(zpoolPath, "status", name)
-	return cmd.CombinedOutput()
+func (p *liveZFSProvider) GetPoolStatus(ctx context.Context, name, zpoolPath string) ([]byte, error) {
+	return p.runCommand(ctx, true, zpoolPath, "status", name)
+}
+
+// ListImportablePools scans for exported pools using `zpool import` without arguments.
+func (p *liveZFSProvider) ListImportablePools(ctx context.Context, zpoolPath string) ([]importablePool, error) {
+	output, err := p.runCommand(ctx, true, zpoolPath, "import")
+	if err != nil {
+		// zpool exits non-zero when the scan simply finds nothing.
+		if strings.Contains(string(output), "no pools available to import") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("zpool import scan failed: %w. Output: %s", err, string(output))
+	}
+	return parseImportablePools(string(output)), nil
+}
+
+// ImportPool imports an exported pool by its numeric identifier using `zpool import`.
+func (p *liveZFSProvider) ImportPool(ctx context.Context, zpoolPath, id string) ([]byte, error) {
+	return p.streamCommand(ctx, zpoolPath, "import", id)
+}
+
+// ImportPoolReadOnly imports an exported pool read-only using `zpool import -o readonly=on`.
+func (p *liveZFSProvider) ImportPoolReadOnly(ctx context.Context, zpoolPath, id string) ([]byte, error) {
+	return p.runCommand(ctx, true, zpoolPath, "import", "-o", "readonly=on", id)
+}
+
+// ExportPool forcibly exports a pool using `zpool export -f`.
+func (p *liveZFSProvider) ExportPool(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+	return p.runCommand(ctx, true, zpoolPath, "export", "-f", name)
+}
+
+// ClearPool clears the device errors of a pool using `zpool clear`.
+func (p *liveZFSProvider) ClearPool(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+	return p.runCommand(ctx, true, zpoolPath, "clear", name)
+}
+
+// ClearDevice clears the error counters of a device using `zpool clear <pool> <device>`.
+func (p *liveZFSProvider) ClearDevice(ctx context.Context, zpoolPath, name, device string) ([]byte, error) {
+	return p.runCommand(ctx, true, zpoolPath, "clear", name, device)
+}
+
+// OfflineDevice takes a device of a pool offline using `zpool offline`.
+func (p *liveZFSProvider) OfflineDevice(ctx context.Context, zpoolPath, name, device string) ([]byte, error) {
+	return p.runCommand(ctx, true, zpoolPath, "offline", name, device)
+}
+
+// ReplaceDevice replaces a device of a pool using `zpool replace`.
+func (p *liveZFSProvider) ReplaceDevice(ctx context.Context, zpoolPath, name, device, replacement string) ([]byte, error) {
+	return p.runCommand(ctx, true, zpoolPath, "replace", name, device, replacement)
+}
+
+// AttachDevice attaches a new device to a device of a pool using `zpool attach`.
+func (p *liveZFSProvider) AttachDevice(ctx context.Context, zpoolPath, name, device, newDevice, ashift string) ([]byte, error) {
+	return p.runCommand(ctx, true, zpoolPath, attachArgs(name, device, newDevice, ashift)...)
+}
+
+// DetachDevice detaches a device from its mirror using `zpool detach`.
+func (p *liveZFSProvider) DetachDevice(ctx context.Context, zpoolPath, name, device string) ([]byte, error) {
+	return p.runCommand(ctx, true, zpoolPath, "detach", name, device)
+}
+
+// RemoveVdev removes a top-level vdev from a pool using `zpool remove`.
+func (p *liveZFSProvider) RemoveVdev(ctx context.Context, zpoolPath, name, vdev string) ([]byte, error) {
+	return p.runCommand(ctx, true, zpoolPath, "remove", name, vdev)
+}
+
+// InitializePool starts initializing all devices of a pool using `zpool initialize`.
+func (p *liveZFSProvider) InitializePool(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+	return p.runCommand(ctx, true, zpoolPath, "initialize", name)
+}
+
+// GetInitializeStatus returns the pool status including initialization progress using `zpool status -i`.
+func (p *liveZFSProvider) GetInitializeStatus(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+	return p.runCommand(ctx, true, zpoolPath, "status", "-i", name)
+}
+
+// GetVersion returns the ZFS versions using `zpool version`.
+func (p *liveZFSProvider) GetVersion(ctx context.Context, zpoolPath string) ([]byte, error) {
+	return p.runCommand(ctx, true, zpoolPath, "version")
+}
+
+// GetPropertyHelp runs `get` without arguments to print the supported properties.
+func (p *liveZFSProvider) GetPropertyHelp(ctx context.Context, binPath string) ([]byte, error) {
+	return p.runCommand(ctx, true, binPath, "get")
+}
+
+// GetPoolProperties reads pool properties using `zpool get -H -o property,value`.
+func (p *liveZFSProvider) GetPoolProperties(ctx context.Context, zpoolPath, name string, props []string) (map[string]string, error) {
+	output, err := p.runCommand(ctx, false, zpoolPath, "get", "-H", "-o", "property,value", strings.Join(props, ","), name)
+	if err != nil {
+		return nil, fmt.Errorf("zpool get failed: %w", err)
+	}
+	// The output has the same tab separated two column layout as the pool list.
+	return parsePoolList(string(output)), nil
+}
+
+// ReadDeviceLabels dumps the ZFS labels of a device using `zdb -l`.
+func (p *liveZFSProvider) ReadDeviceLabels(ctx context.Context, zdbPath, device string) ([]byte, error) {
+	return p.runCommand(ctx, true, zdbPath, "-l", device)
+}
+
+// GetPoolHistory returns the long history of a pool with internal events using `zpool history -il`.
+func (p *liveZFSProvider) GetPoolHistory(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+	return p.runCommand(ctx, false, zpoolPath, "history", "-il", name)
+}
+
+// GetPoolUsage reads the space usage of a pool using `zpool list -Hp`. The sample has no time set.
+func (p *liveZFSProvider) GetPoolUsage(ctx context.Context, zpoolPath, name string) (poolSample, error) {
+	output, err := p.runCommand(ctx, false, zpoolPath, "list", "-Hp", "-o", poolUsageColumns, name)
+	if err != nil {
+		return poolSample{}, fmt.Errorf("zpool list failed: %w", err)
+	}
+	return parsePoolUsage(string(output))
+}
+
+// GetDatasetProperties reads dataset properties using `zfs get -Hp -o property,value`.
+func (p *liveZFSProvider) GetDatasetProperties(ctx context.Context, zfsPath, dataset string, props []string) (map[string]string, error) {
+	output, err := p.runCommand(ctx, false, zfsPath, "get", "-Hp", "-o", "property,value", strings.Join(props, ","), dataset)
+	if err != nil {
+		return nil, fmt.Errorf("zfs get failed: %w", err)
+	}
+	return parsePoolList(string(output)), nil
+}
+
+// ListDatasets lists the filesystems of a pool using `zfs list -Hp -t filesystem -r`.
+func (p *liveZFSProvider) ListDatasets(ctx context.Context, zfsPath, pool string) ([]datasetInfo, error) {
+	output, err := p.runCommand(ctx, false, zfsPath, "list", "-Hp", "-t", "filesystem", "-r",
+		"-o", "name,mountpoint,canmount,mounted,keystatus", pool)
+	if err != nil {
+		return nil, fmt.Errorf("zfs list failed: %w", err)
+	}
+	return parseDatasetList(string(output)), nil
+}
+
+// SnapshotRecursive takes a recursive snapshot using `zfs snapshot -r`.
+func (p *liveZFSProvider) SnapshotRecursive(ctx context.Context, zfsPath, snapshot string) ([]byte, error) {
+	return p.runCommand(ctx, true, zfsPath, "snapshot", "-r", snapshot)
+}
+
+// CheckpointPool takes a pool checkpoint using `zpool checkpoint`.
+func (p *liveZFSProvider) CheckpointPool(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+	return p.runCommand(ctx, true, zpoolPath, "checkpoint", name)
+}
+
+// DiscardCheckpoint discards the pool checkpoint using `zpool checkpoint -d`.
+func (p *liveZFSProvider) DiscardCheckpoint(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+	return p.runCommand(ctx, true, zpoolPath, "checkpoint", "-d", name)
+}
+
+// SplitPool splits a mirrored pool using `zpool split`.
+func (p *liveZFSProvider) SplitPool(ctx context.Context, zpoolPath, name, newName string, devices []string) ([]byte, error) {
+	return p.streamCommand(ctx, zpoolPath, append([]string{"split", name, newName}, devices...)...)
+}
+
+// UpgradePool enables all supported features using `zpool upgrade`.
+func (p *liveZFSProvider) UpgradePool(ctx context.Context, zpoolPath, name string) ([]byte, error) {
+	return p.runCommand(ctx, true, zpoolPath, "upgrade", name)
+}
+
+// ListVolumes lists the zvols of a pool using `zfs list -Hp -t volume -r`.
+func (p *liveZFSProvider) ListVolumes(ctx context.Context, zfsPath, pool string) (map[string]string, error) {
+	output, err := p.runCommand(ctx, false, zfsPath, "list", "-Hp", "-t", "volume", "-r", "-o", "name,volsize", pool)
+	if err != nil {
+		return nil, fmt.Errorf("zfs list failed: %w", err)
+	}
+	return parsePoolList(string(output)), nil
+}
+
+// CreateVolume creates a zvol and any missing parent filesystems using `zfs create -p -V`.
+func (p *liveZFSProvider) CreateVolume(ctx context.Context, zfsPath, dataset string, size uint64, props map[string]string) ([]byte, error) {
+	args := append([]string{"create", "-p", "-V", strconv.FormatUint(size, 10)}, poolPropertyArgs(props)...)
+	return p.runCommand(ctx, true, zfsPath, append(args, dataset)...)
+}
+
+// LoadKeys loads the keys of a pool's encrypted datasets using `zfs load-key -r`.
+func (p *liveZFSProvider) LoadKeys(ctx context.Context, zfsPath, pool string) ([]byte, error) {
+	return p.runCommand(ctx, true, zfsPath, "load-key", "-r", pool)
+}
+
+// MountDataset mounts a dataset using `zfs mount`.
+func (p *liveZFSProvider) MountDataset(ctx context.Context, zfsPath, dataset string) ([]byte, error) {
+	return p.runCommand(ctx, true, zfsPath, "mount", dataset)
+}
+
+// SetDatasetProperty sets a dataset property using `zfs set`.
+func (p *liveZFSProvider) SetDatasetProperty(ctx context.Context, zfsPath, dataset, prop, value string) ([]byte, error) {
+	return p.runCommand(ctx, true, zfsPath, "set", prop+"="+value, dataset)
+}
+
+// SetPoolProperty sets a pool property using `zpool set`.
+func (p *liveZFSProvider) SetPoolProperty(ctx context.Context, zpoolPath, name, prop, value string) ([]byte, error) {
+	return p.runCommand(ctx, true, zpoolPath, "set", prop+"="+value, name)
+}
+
+// GetAllPoolStatus returns the status of all pools as JSON using `zpool status -j`.
+func (p *liveZFSProvider) GetAllPoolStatus(ctx context.Context, zpoolPath string) ([]byte, error) {
+	return p.runCommand(ctx, false, zpoolPath, "status", "-j")
 }
 
 // IsBlockDevice checks if the given path corresponds to a block device.
@@ -189,6 +659,15 @@ func (p *liveZFSProvider) IsBlockDevice(path string) (bool, error) {
 	return isBlockDevice, nil
 }
 
+// IsCharDevice checks if a path is a character device.
+func (p *liveZFSProvider) IsCharDevice(path string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	return info.Mode()&os.ModeCharDevice != 0, nil
+}
+
 // GetDiskSize returns the size of the block device at the given path in bytes.
 func (p *liveZFSProvider) GetDiskSize(path string) (uint64, error) {
 	realPath, err := p.EvalSymlinks(path)
@@ -196,7 +675,7 @@ func (p *liveZFSProvider) GetDiskSize(path string) (uint64, error) {
 		return 0, fmt.Errorf("failed to resolve symlink for %s: %w", path, err)
 	}
 	devName := filepath.Base(realPath)
-	sizeFile := filepath.Join("/sys/class/block", devName, "size")
+	sizeFile := filepath.Join(sysClassBlockPath, devName, "size")
 
 	// #nosec G304: Intentionally reading disk size from sysfs
 	sizeBytes, err := os.ReadFile(sizeFile)
@@ -213,6 +692,198 @@ func (p *liveZFSProvider) GetDiskSize(path string) (uint64, error) {
 
 var sysBlockPath = "/sys/block"
 
+var sysClassBlockPath = "/sys/class/block"
+
+var zfsModuleParamsPath = "/sys/module/zfs/parameters"
+
+var procMeminfoPath = "/proc/meminfo"
+
+var zfsPoolFeaturesPath = "/sys/module/zfs/features.pool"
+
+// udevQueuePath exists while udevd has events queued, which is what `udevadm settle` checks.
+var udevQueuePath = "/run/udev/queue"
+
+// udevSettlePollInterval is how often the udev queue is checked while waiting for it to drain.
+var udevSettlePollInterval = 100 * time.Millisecond
+
+// SettleUdev polls the udev queue marker until it is gone.
+func (p *liveZFSProvider) SettleUdev(ctx context.Context, timeout time.Duration) error {
+	if _, err := os.Stat(filepath.Dir(udevQueuePath)); err != nil {
+		return fmt.Errorf("udev state is not available: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(udevSettlePollInterval)
+	defer ticker.Stop()
+	for {
+		_, err := os.Stat(udevQueuePath)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to check udev queue: %w", err)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("udev queue did not settle within %s", timeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+// TriggerVolumeEvents writes "add" to the uevent file of every zd* device in sysfs.
+func (p *liveZFSProvider) TriggerVolumeEvents() error {
+	uevents, err := filepath.Glob(filepath.Join(sysClassBlockPath, "zd*", "uevent"))
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, uevent := range uevents {
+		if err := os.WriteFile(uevent, []byte("add"), 0); err != nil {
+			errs = append(errs, fmt.Errorf("failed to trigger %s: %w", uevent, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ListPoolFeatures lists the pool features the kernel module exposes in sysfs.
+func (p *liveZFSProvider) ListPoolFeatures() ([]string, error) {
+	entries, err := os.ReadDir(zfsPoolFeaturesPath)
+	if err != nil {
+		return nil, err
+	}
+	features := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		features = append(features, entry.Name())
+	}
+	return features, nil
+}
+
+// EnsureOwnership changes the ownership and mode of path if they differ from the requested ones.
+func (p *liveZFSProvider) EnsureOwnership(path string, uid, gid, mode int) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false, fmt.Errorf("cannot determine the owner of %s", path)
+	}
+
+	changed := false
+	if (uid >= 0 && int(stat.Uid) != uid) || (gid >= 0 && int(stat.Gid) != gid) {
+		if err := os.Chown(path, uid, gid); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+	const modeBits = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+	if mode >= 0 && info.Mode()&modeBits != fileModeFromUnix(mode) {
+		if err := os.Chmod(path, fileModeFromUnix(mode)); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+// fileModeFromUnix converts unix permission bits, including setuid, setgid and sticky, to a FileMode.
+func fileModeFromUnix(mode int) fs.FileMode {
+	m := fs.FileMode(mode) & fs.ModePerm
+	if mode&0o4000 != 0 {
+		m |= fs.ModeSetuid
+	}
+	if mode&0o2000 != 0 {
+		m |= fs.ModeSetgid
+	}
+	if mode&0o1000 != 0 {
+		m |= fs.ModeSticky
+	}
+	return m
+}
+
+// GetQueueInfo reads the queue attributes of a block device from sysfs. For a partition the
+// attributes of its disk are returned.
+func (p *liveZFSProvider) GetQueueInfo(path string) (queueInfo, error) {
+	realPath, err := p.EvalSymlinks(path)
+	if err != nil {
+		return queueInfo{}, fmt.Errorf("failed to resolve symlink for %s: %w", path, err)
+	}
+	// Partitions share the queue of their disk.
+	queueDir := filepath.Join(diskSysfsDir(realPath), "queue")
+
+	var info queueInfo
+	for name, parse := range map[string]func(uint64){
+		"rotational":        func(v uint64) { info.Rotational = v != 0 },
+		"discard_max_bytes": func(v uint64) { info.DiscardMaxBytes = v },
+	} {
+		// #nosec G304: Intentionally reading queue attributes from sysfs
+		data, err := os.ReadFile(filepath.Join(queueDir, name))
+		if err != nil {
+			return queueInfo{}, fmt.Errorf("failed to read queue attribute: %w", err)
+		}
+		value, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return queueInfo{}, fmt.Errorf("failed to parse queue attribute %s: %w", name, err)
+		}
+		parse(value)
+	}
+	return info, nil
+}
+
+// diskSysfsDir returns the sysfs directory of the disk of the block device realPath: its own, or
+// for a partition that of its disk, which is the parent directory in sysfs.
+func diskSysfsDir(realPath string) string {
+	blockDir := filepath.Join(sysClassBlockPath, filepath.Base(realPath))
+	if _, err := os.Stat(filepath.Join(blockDir, "partition")); err == nil {
+		if resolved, err := filepath.EvalSymlinks(blockDir); err == nil {
+			blockDir = filepath.Dir(resolved)
+		}
+	}
+	return blockDir
+}
+
+// GetDiskModel reads the model of the disk of a block device from sysfs.
+func (p *liveZFSProvider) GetDiskModel(path string) (string, error) {
+	realPath, err := p.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve symlink for %s: %w", path, err)
+	}
+	// #nosec G304: Intentionally reading the disk model from sysfs
+	data, err := os.ReadFile(filepath.Join(diskSysfsDir(realPath), "device", "model"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read disk model: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// ReadModuleParameter reads a ZFS module parameter from sysfs.
+func (p *liveZFSProvider) ReadModuleParameter(name string) (string, error) {
+	// #nosec G304: Intentionally reading module parameter from sysfs
+	value, err := os.ReadFile(filepath.Join(zfsModuleParamsPath, name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("unknown parameter (no %s in %s)", name, zfsModuleParamsPath)
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(value)), nil
+}
+
+// WriteModuleParameter writes a ZFS module parameter to sysfs.
+func (p *liveZFSProvider) WriteModuleParameter(name, value string) error {
+	// #nosec G304: Intentionally writing module parameter to sysfs
+	f, err := os.OpenFile(filepath.Join(zfsModuleParamsPath, name), os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(value); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
 // ResolveDiskByModel scans /sys/block to find a disk matching the model
 // that is unpartitioned, matches size restrictions, and not already marked as used.
 func (p *liveZFSProvider) ResolveDiskByModel(targetModel string, sizeConds []sizeCondition, usedDisks map[string]bool) (string, error) {
@@ -222,8 +893,6 @@ func (p *liveZFSProvider) ResolveDiskByModel(targetModel string, sizeConds []siz
 		return "", fmt.Errorf("failed to read %s: %w", sysBlockPath, err)
 	}
 
-	normalizedTarget := normalizeModel(targetModel)
-
 	for _, entry := range entries {
 		devName := entry.Name()
 
@@ -256,21 +925,7 @@ func (p *liveZFSProvider) ResolveDiskByModel(targetModel string, sizeConds []siz
 			continue
 		}
 
-		sysfsModel := strings.TrimSpace(string(modelBytes))
-		normalizedSysfs := normalizeModel(sysfsModel)
-
-		matched := false
-		if strings.Contains(normalizedTarget, "*") || strings.Contains(normalizedTarget, "?") {
-			var err error
-			matched, err = filepath.Match(normalizedTarget, normalizedSysfs)
-			if err != nil {
-				continue
-			}
-		} else {
-			matched = strings.Contains(normalizedSysfs, normalizedTarget)
-		}
-
-		if !matched {
+		if !modelMatches(targetModel, string(modelBytes)) {
 			continue
 		}
 
@@ -333,9 +988,50 @@ func (p *liveZFSProvider) ResolveDiskByModel(targetModel string, sizeConds []siz
 	return "", fmt.Errorf("no unpartitioned, unused disk found matching model %q with the requested size conditions", targetModel)
 }
 
+// modelMatches reports whether the disk model matches the model query target: a glob pattern if
+// it contains wildcards, a substring otherwise, both compared normalized.
+func modelMatches(target, model string) bool {
+	target, model = normalizeModel(target), normalizeModel(model)
+	if strings.Contains(target, "*") || strings.Contains(target, "?") {
+		matched, err := filepath.Match(target, model)
+		return err == nil && matched
+	}
+	return strings.Contains(model, target)
+}
+
 // normalizeModel normalizes a model string to make matches more robust.
 // It converts to lower case and trims surrounding whitespace.
 func normalizeModel(m string) string {
 	m = strings.ReplaceAll(m, "\x00", "")
 	return strings.TrimSpace(strings.ToLower(m))
 }
+
+// GetMemTotal reads MemTotal from /proc/meminfo.
+func (p *liveZFSProvider) GetMemTotal() (uint64, error) {
+	// #nosec G304: Intentionally reading memory information from procfs
+	data, err := os.ReadFile(procMeminfoPath)
+	if err != nil {
+		return 0, err
+	}
+	return parseMemTotal(string(data))
+}
+
+// parseMemTotal extracts MemTotal in bytes from /proc/meminfo content, which reports it in kB.
+func parseMemTotal(meminfo string) (uint64, error) {
+	for line := range strings.Lines(meminfo) {
+		value, ok := strings.CutPrefix(line, "MemTotal:")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) != 2 || fields[1] != "kB" {
+			return 0, fmt.Errorf("unexpected MemTotal line %q", strings.TrimSpace(line))
+		}
+		kb, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid MemTotal value %q: %w", fields[0], err)
+		}
+		return kb * 1024, nil
+	}
+	return 0, errors.New("MemTotal not found in meminfo")
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Typed causes of failed zpool commands, recognized from their output by newZpoolCommandError.
+var (
+	errDeviceInUse          = errors.New("a device is in use or holds existing data")
+	errForeignPool          = errors.New("the pool was last used by another system")
+	errInsufficientReplicas = errors.New("devices have insufficient replicas")
+	errDeviceMissing        = errors.New("a device does not exist")
+	errDeviceTooSmall       = errors.New("a device is too small")
+	errMismatchedLayout     = errors.New("the devices do not form a consistent vdev")
+	errDeviceBusy           = errors.New("a device is busy")
+	errPoolExists           = errors.New("the pool already exists")
+)
+
+// zpoolErrorPatterns maps substrings of the output of failed zpool commands, in lower case, to
+// their typed cause and a remediation hint. The first matching pattern wins.
+var zpoolErrorPatterns = []struct {
+	pattern string
+	cause   error
+	hint    string
+}{
+	{"previously in use from another system", errForeignPool,
+		"The pool was imported by another host and not exported. Make sure no other system uses its disks, then import it once manually with `zpool import -f`."},
+	{"last accessed by another system", errForeignPool,
+		"The pool was imported by another host and not exported. Make sure no other system uses its disks, then import it once manually with `zpool import -f`."},
+	{"insufficient replicas", errInsufficientReplicas,
+		"Too many devices of the pool are missing or faulted. Check that all its disks are attached and visible to the node, see the diagnostic bundle for the labels found."},
+	{"is in use and contains", errDeviceInUse,
+		"A disk holds an existing filesystem or partition table. Wipe it, or set ZPOOL_<n>_ERASE if its data is not needed, or remove it from the configuration."},
+	{"is part of active pool", errDeviceInUse,
+		"A disk belongs to an imported pool. Remove it from the configuration, or destroy that pool first."},
+	{"is part of exported pool", errDeviceInUse,
+		"A disk belongs to an exported pool. Set ZPOOL_<n>_IMPORT to import it, or wipe the disk if the pool is not needed."},
+	{"is part of potentially active pool", errDeviceInUse,
+		"A disk belongs to a pool that may be in use by another system. Make sure it is not, then wipe the disk."},
+	{"device or resource busy", errDeviceBusy,
+		"A disk is held open, e.g. by a mounted filesystem, an md or LVM device, or a running multipath daemon. Release it and retry."},
+	{"mismatched replication level", errMismatchedLayout,
+		"The disks would form vdevs with different redundancy. Check ZPOOL_<n>_TYPE and the number of disks, or the ZPOOL_<n>_VDEV_<m>_* vdevs."},
+	{"device is too small", errDeviceTooSmall,
+		"A disk is below the 64 MiB ZFS minimum. Check the disk selection and size filters."},
+	{"no such device", errDeviceMissing,
+		"A configured device does not exist. Check the device paths, they may have changed after a hardware change."},
+	{"no such file or directory", errDeviceMissing,
+		"A configured device does not exist. Check the device paths, they may have changed after a hardware change."},
+	{"pool already exists", errPoolExists,
+		"A pool with this name is already imported, possibly under a different GUID. Check `zpool list`."},
+}
+
+// zpoolCommandError is a failed zpool command. Cause and Hint are set if the output matched a
+// known error pattern; errors.Is matches both the cause and the original error.
+type zpoolCommandError struct {
+	Command string   // The zpool subcommand, e.g. "create".
+	Devices []string // Devices the command was run on, if known, see withDevices.
+	Err     error
+	Output  string
+	Cause   error
+	Hint    string
+}
+
+func (e *zpoolCommandError) Error() string {
+	if e.Cause == nil {
+		return fmt.Sprintf("zpool %s command failed: %v. Output: %s", e.Command, e.Err, e.Output)
+	}
+	return fmt.Sprintf("zpool %s command failed: %v: %v. Output: %s", e.Command, e.Cause, e.Err, e.Output)
+}
+
+func (e *zpoolCommandError) Unwrap() []error {
+	if e.Cause == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Cause, e.Err}
+}
+
+// newZpoolCommandError wraps the error and output of a failed zpool command, classifying the
+// output into a typed cause with a hint where possible.
+func newZpoolCommandError(command string, err error, output []byte) error {
+	e := &zpoolCommandError{Command: command, Err: err, Output: string(output)}
+	lower := strings.ToLower(e.Output)
+	for _, p := range zpoolErrorPatterns {
+		if strings.Contains(lower, p.pattern) {
+			e.Cause, e.Hint = p.cause, p.hint
+			break
+		}
+	}
+	return e
+}
+
+// withDevices records the devices a failed zpool command was run on in err, if it is one.
+func withDevices(err error, devices []string) error {
+	var e *zpoolCommandError
+	if errors.As(err, &e) {
+		e.Devices = devices
+	}
+	return err
+}
+
+// errorHint returns the remediation hint for err if it wraps a recognized zpool error, or "".
+func errorHint(err error) string {
+	var e *zpoolCommandError
+	if errors.As(err, &e) {
+		return e.Hint
+	}
+	return ""
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestNewZpoolCommandError(t *testing.T) {
+	exitErr := errors.New("exit status 1")
+	tests := map[string]struct {
+		output string
+		want   error
+	}{
+		"filesystem":   {output: "invalid vdev specification\nuse '-f' to override the following errors:\n/dev/sda1 is in use and contains an unknown filesystem.", want: errDeviceInUse},
+		"foreign pool": {output: "cannot import 'tank': pool was previously in use from another system.\nLast accessed by node2 (hostid=1234).", want: errForeignPool},
+		"replicas":     {output: "cannot import 'tank': one or more devices is currently unavailable\n\tdevices have insufficient replicas", want: errInsufficientReplicas},
+		"busy":         {output: "cannot open '/dev/sdb': Device or resource busy", want: errDeviceBusy},
+		"missing":      {output: "cannot open '/dev/sdz': No such device or address", want: errDeviceMissing},
+		"unknown":      {output: "something unexpected", want: nil},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := newZpoolCommandError("create", exitErr, []byte(tt.output))
+			if !errors.Is(err, exitErr) {
+				t.Errorf("Expected the original error to be wrapped, got %v", err)
+			}
+			if !strings.HasPrefix(err.Error(), "zpool create command failed: ") || !strings.HasSuffix(err.Error(), "Output: "+tt.output) {
+				t.Errorf("Unexpected message %q", err.Error())
+			}
+			hint := errorHint(fmt.Errorf("pool %q: %w", "tank", err))
+			if tt.want == nil {
+				if hint != "" {
+					t.Errorf("Expected no hint for unknown output, got %q", hint)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Expected errors.Is(%v, %v)", err, tt.want)
+			}
+			if hint == "" {
+				t.Error("Expected a hint through wrapping errors")
+			}
+		})
+	}
+
+	if hint := errorHint(errors.New("plain")); hint != "" {
+		t.Errorf("Expected no hint for other errors, got %q", hint)
+	}
+}